@@ -17,6 +17,7 @@
 package blockproducer
 
 import (
+	"bytes"
 	"encoding/binary"
 	"sync"
 
@@ -35,7 +36,7 @@ func newBlockNode(h uint32, block *types.Block, parent *blockNode) *blockNode {
 	var count uint32
 
 	if parent != nil {
-		count = parent.height + 1
+		count = parent.count + 1
 	} else {
 		count = 0
 	}
@@ -56,17 +57,6 @@ func (bn *blockNode) indexKey() (key []byte) {
 	return
 }
 
-func (bn *blockNode) initBlockNode(block *types.Block, parent *blockNode) {
-	bn.hash = block.SignedHeader.BlockHash
-	bn.parent = nil
-	bn.height = 0
-
-	if parent != nil {
-		bn.parent = parent
-		bn.height = parent.height + 1
-	}
-}
-
 func (bn *blockNode) ancestor(h uint32) *blockNode {
 	if h > bn.height {
 		return nil
@@ -79,6 +69,40 @@ func (bn *blockNode) ancestor(h uint32) *blockNode {
 	return ancestor
 }
 
+// betterThan reports whether the branch ending at bn should be preferred to the one ending at
+// other. The branch with more blocks wins, and a tie is broken by the lower height and then by the
+// smaller block hash, so that all block producers choose the same branch.
+func (bn *blockNode) betterThan(other *blockNode) bool {
+	if other == nil {
+		return true
+	}
+	if bn.count != other.count {
+		return bn.count > other.count
+	}
+	if bn.height != other.height {
+		return bn.height < other.height
+	}
+	return bytes.Compare(bn.hash[:], other.hash[:]) < 0
+}
+
+// forkPoint returns the last common ancestor of the branches ending at a and b, or nil if they
+// are not in the same tree.
+func forkPoint(a, b *blockNode) *blockNode {
+	for a != nil && b != nil && a != b {
+		if a.count > b.count {
+			a = a.parent
+		} else if b.count > a.count {
+			b = b.parent
+		} else {
+			a, b = a.parent, b.parent
+		}
+	}
+	if a != b {
+		return nil
+	}
+	return a
+}
+
 type blockIndex struct {
 	mu    sync.RWMutex
 	index map[hash.Hash]*blockNode
@@ -93,8 +117,8 @@ func newBlockIndex() *blockIndex {
 }
 
 func (bi *blockIndex) addBlock(b *blockNode) {
-	bi.mu.RLock()
-	defer bi.mu.RUnlock()
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
 
 	bi.index[b.hash] = b
 }
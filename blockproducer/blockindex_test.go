@@ -143,3 +143,53 @@ func TestIndexBlock(t *testing.T) {
 		t.Fatalf("two values should be equal: \n\tv0=%+v\n\tv1=%+v", bn0, bn3)
 	}
 }
+
+func TestForkChoice(t *testing.T) {
+	block0, err := generateRandomBlock(hash.Hash{}, true)
+	if err != nil {
+		t.Fatalf("Unexcepted error: %v", err)
+	}
+	bn0 := newBlockNode(0, block0, nil)
+
+	// bn0 <- bn1 <- bn3
+	//     \- bn2 <- bn4 <- bn5
+	var bns = []*blockNode{bn0}
+	for i, p := range []int{0, 0, 1, 2, 4} {
+		block, err := generateRandomBlock(bns[p].hash, false)
+		if err != nil {
+			t.Fatalf("Unexcepted error: %v", err)
+		}
+		bns = append(bns, newBlockNode(uint32(i+1), block, bns[p]))
+	}
+	if bns[5].count != 3 {
+		t.Fatalf("unexpected count: %d", bns[5].count)
+	}
+
+	if fp := forkPoint(bns[3], bns[5]); fp != bn0 {
+		t.Fatalf("unexpected fork point: %v", fp)
+	}
+	if fp := forkPoint(bns[4], bns[2]); fp != bns[2] {
+		t.Fatalf("unexpected fork point: %v", fp)
+	}
+	if fp := forkPoint(bns[5], newBlockNode(6, block0, nil)); fp != nil {
+		t.Fatalf("unexpected fork point: %v", fp)
+	}
+
+	// more blocks
+	if !bns[5].betterThan(bns[3]) || bns[3].betterThan(bns[5]) {
+		t.Fatal("the longer branch should be better")
+	}
+	// same count, lower height
+	if !bns[3].betterThan(bns[4]) || bns[4].betterThan(bns[3]) {
+		t.Fatal("the branch with lower height should be better")
+	}
+	// same count and height, smaller hash
+	var same = *bns[3]
+	same.hash[0] = ^same.hash[0]
+	if bns[3].betterThan(&same) == same.betterThan(bns[3]) {
+		t.Fatal("the fork choice should be deterministic")
+	}
+	if !bns[3].betterThan(nil) {
+		t.Fatal("any branch should be better than nil")
+	}
+}
@@ -17,6 +17,7 @@
 package blockproducer
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 	"time"
@@ -28,6 +29,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/merkle"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/timesync"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	"github.com/coreos/bbolt"
@@ -42,6 +44,10 @@ var (
 	metaLastTxBillingIndexBucket        = []byte("covenantsql-last-tx-billing-index-bucket")
 	metaAccountIndexBucket              = []byte("covenantsql-account-index-bucket")
 	metaSQLChainIndexBucket             = []byte("covenantsql-sqlchain-index-bucket")
+	metaAccountTxIndexBucket            = []byte("covenantsql-account-tx-index-bucket")
+	metaTxAccountIndexBucket            = []byte("covenantsql-tx-account-index-bucket")
+	metaEvidenceBucket                  = []byte("covenantsql-evidence-bucket")
+	metaUndoBucket                      = []byte("covenantsql-undo-bucket")
 	gasprice                     uint32 = 1
 	accountAddress               proto.AccountAddress
 )
@@ -55,11 +61,17 @@ type Chain struct {
 	rt *rt
 	st *State
 	cl *rpc.Caller
+	vb *voteBook
 
 	blocksFromSelf chan *types.Block
 	blocksFromRPC  chan *types.Block
 	pendingTxs     chan pi.Transaction
 	stopCh         chan struct{}
+
+	// issueLock serializes the transactions issued by this block producer to keep nonce in order
+	issueLock sync.Mutex
+	// stLock serializes the head moves of st against the block checks upon the head
+	stLock sync.RWMutex
 }
 
 // NewChain creates a new blockchain.
@@ -120,6 +132,26 @@ func NewChain(cfg *Config) (*Chain, error) {
 		}
 
 		_, err = bucket.CreateBucketIfNotExists(metaSQLChainIndexBucket)
+		if err != nil {
+			return
+		}
+
+		_, err = bucket.CreateBucketIfNotExists(metaAccountTxIndexBucket)
+		if err != nil {
+			return
+		}
+
+		_, err = bucket.CreateBucketIfNotExists(metaTxAccountIndexBucket)
+		if err != nil {
+			return
+		}
+
+		_, err = bucket.CreateBucketIfNotExists(metaEvidenceBucket)
+		if err != nil {
+			return
+		}
+
+		_, err = bucket.CreateBucketIfNotExists(metaUndoBucket)
 		return
 	})
 	if err != nil {
@@ -135,12 +167,17 @@ func NewChain(cfg *Config) (*Chain, error) {
 		rt:             newRuntime(cfg, accountAddress),
 		st:             &State{},
 		cl:             rpc.NewCaller(),
+		vb:             newVoteBook(),
 		blocksFromSelf: make(chan *types.Block),
 		blocksFromRPC:  make(chan *types.Block),
 		pendingTxs:     make(chan pi.Transaction),
 		stopCh:         make(chan struct{}),
 	}
 
+	if err = chain.setProducers(); err != nil {
+		return nil, err
+	}
+
 	log.Debugf("pushing genesis block: %v", cfg.Genesis)
 
 	if err = chain.pushGenesisBlock(cfg.Genesis); err != nil {
@@ -184,12 +221,45 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 		rt:             newRuntime(cfg, accountAddress),
 		st:             &State{},
 		cl:             rpc.NewCaller(),
+		vb:             newVoteBook(),
 		blocksFromSelf: make(chan *types.Block),
 		blocksFromRPC:  make(chan *types.Block),
 		pendingTxs:     make(chan pi.Transaction),
 		stopCh:         make(chan struct{}),
 	}
 
+	if err = chain.setProducers(); err != nil {
+		return nil, err
+	}
+
+	// create the index buckets which may be missing in a chain created by an older version
+	err = chain.db.Update(func(tx *bolt.Tx) (err error) {
+		meta := tx.Bucket(metaBucket[:])
+		txbk, err := meta.CreateBucketIfNotExists(metaTransactionBucket)
+		if err != nil {
+			return
+		}
+		for i := pi.TransactionType(0); i < pi.TransactionTypeNumber; i++ {
+			if _, err = txbk.CreateBucketIfNotExists(i.Bytes()); err != nil {
+				return
+			}
+		}
+		if _, err = meta.CreateBucketIfNotExists(metaAccountTxIndexBucket); err != nil {
+			return
+		}
+		if _, err = meta.CreateBucketIfNotExists(metaTxAccountIndexBucket); err != nil {
+			return
+		}
+		if _, err = meta.CreateBucketIfNotExists(metaEvidenceBucket); err != nil {
+			return
+		}
+		_, err = meta.CreateBucketIfNotExists(metaUndoBucket)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	err = chain.db.View(func(tx *bolt.Tx) (err error) {
 		meta := tx.Bucket(metaBucket[:])
 		err = chain.st.deserialize(meta.Get(metaStateKey))
@@ -198,10 +268,10 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 			return
 		}
 
+		// Blocks are keyed by height, so the parent of a block is always loaded before it,
+		// including the ones on forks
 		var last *blockNode
-		var index int32
 		blocks := meta.Bucket(metaBlockIndexBucket)
-		nodes := make([]blockNode, blocks.Stats().KeyN)
 
 		if err = blocks.ForEach(func(k, v []byte) (err error) {
 			block := &types.Block{}
@@ -215,28 +285,29 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 
 			if last == nil {
 				// TODO(lambda): check genesis block
-			} else if block.SignedHeader.ParentHash.IsEqual(&last.hash) {
+			} else {
 				if err = block.SignedHeader.Verify(); err != nil {
 					return err
 				}
 
-				parent = last
-			} else {
-				parent = chain.bi.lookupBlock(block.SignedHeader.BlockHash)
+				parent = chain.bi.lookupBlock(block.SignedHeader.ParentHash)
 
 				if parent == nil {
 					return ErrParentNotFound
 				}
 			}
 
-			nodes[index].initBlockNode(block, parent)
-			last = &nodes[index]
-			index++
+			last = newBlockNode(chain.rt.getHeightFromTime(block.Timestamp()), block, parent)
+			chain.bi.addBlock(last)
 			return err
 		}); err != nil {
 			return err
 		}
 
+		if chain.st.Node = chain.bi.lookupBlock(chain.st.Head); chain.st.Node == nil {
+			return ErrNoSuchBlock
+		}
+
 		txbillings := meta.Bucket(metaTxBillingIndexBucket)
 		if err = txbillings.ForEach(func(k, v []byte) (err error) {
 			txbilling := types.TxBilling{}
@@ -291,47 +362,54 @@ func (c *Chain) checkTxBilling(tb *types.TxBilling) (err error) {
 		return err
 	}
 
-	if val := c.ti.getTxBilling(tb.TxHash); val == nil {
-		err = c.db.View(func(tx *bolt.Tx) error {
-			meta := tx.Bucket(metaBucket[:])
-			dec := meta.Bucket(metaTxBillingIndexBucket).Get(tb.TxHash[:])
-			if len(dec) != 0 {
-				decTx := &types.TxBilling{}
-				err = decTx.Deserialize(dec)
-				if err != nil {
-					return err
-				}
-
-				if decTx != nil && (!decTx.SignedBlock.IsEqual(tb.SignedBlock)) {
-					return ErrExistedTx
-				}
-			}
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-	} else {
+	if val := c.ti.getTxBilling(tb.TxHash); val != nil {
 		if val.SignedBlock != nil && (!val.SignedBlock.IsEqual(tb.SignedBlock)) {
 			return ErrExistedTx
 		}
 	}
 
-	// check sequence ID to avoid double rewards and fees
-	databaseID := tb.GetDatabaseID()
-	sequenceID, err := c.ti.lastSequenceID(databaseID)
-	if err == nil {
-		if sequenceID >= tb.GetSequenceID() {
-			return ErrSmallerSequenceID
+	return c.db.View(checkTxBillingProcedure(tb))
+}
+
+// checkTxBillingProcedure checks the existed tx and the sequence ID of tb against the index
+// buckets.
+func checkTxBillingProcedure(tb *types.TxBilling) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) (err error) {
+		meta := tx.Bucket(metaBucket[:])
+		dec := meta.Bucket(metaTxBillingIndexBucket).Get(tb.TxHash[:])
+		if len(dec) != 0 {
+			decTx := &types.TxBilling{}
+			if err = decTx.Deserialize(dec); err != nil {
+				return
+			}
+			if decTx.SignedBlock != nil && (!decTx.SignedBlock.IsEqual(tb.SignedBlock)) {
+				return ErrExistedTx
+			}
+		}
+
+		// check sequence ID to avoid double rewards and fees
+		databaseID, err := utils.EncodeMsgPack(tb.GetDatabaseID())
+		if err != nil {
+			return
 		}
+		if enc := meta.Bucket(metaLastTxBillingIndexBucket).Get(databaseID.Bytes()); enc != nil {
+			var sequenceID uint32
+			if err = utils.DecodeMsgPack(enc, &sequenceID); err != nil {
+				return
+			}
+			if sequenceID >= tb.GetSequenceID() {
+				return ErrSmallerSequenceID
+			}
+		}
+		return
 	}
-
-	return nil
 }
 
 // checkBlock has following steps: 1. check parent block 2. checkTx 2. merkle tree 3. Hash 4. Signature.
 func (c *Chain) checkBlock(b *types.Block) (err error) {
-	// TODO(lambda): process block fork
+	c.stLock.RLock()
+	defer c.stLock.RUnlock()
+
 	if !b.SignedHeader.ParentHash.IsEqual(c.st.getHeader()) {
 		log.Debugf("chain's parent hash is %s, and height is %d. But received block's hash is %s", c.st.getHeader(), c.st.getHeight(), b.SignedHeader.ParentHash)
 		return ErrParentNotMatch
@@ -344,6 +422,27 @@ func (c *Chain) checkBlock(b *types.Block) (err error) {
 		}
 	}
 
+	if err = c.checkBlockHash(b); err != nil {
+		return
+	}
+
+	return c.checkStateRoot(b)
+}
+
+// checkStateRoot checks the state root of the block upon the current state.
+func (c *Chain) checkStateRoot(b *types.Block) (err error) {
+	var root hash.Hash
+	if root, err = c.ms.stateRootAfter(b.Transactions); err != nil {
+		return
+	}
+	if !b.SignedHeader.StateRoot.IsEqual(&root) {
+		return ErrInvalidStateRoot
+	}
+	return
+}
+
+// checkBlockHash checks the merkle tree root and hash of the block.
+func (c *Chain) checkBlockHash(b *types.Block) (err error) {
 	rootHash := merkle.NewMerkle(b.GetTxHashes()).GetRoot()
 	if !b.SignedHeader.MerkleRoot.IsEqual(rootHash) {
 		return ErrInvalidMerkleTreeRoot
@@ -362,6 +461,9 @@ func (c *Chain) checkBlock(b *types.Block) (err error) {
 }
 
 func (c *Chain) pushBlockWithoutCheck(b *types.Block) error {
+	c.stLock.Lock()
+	defer c.stLock.Unlock()
+
 	h := c.rt.getHeightFromTime(b.Timestamp())
 	node := newBlockNode(h, b, c.st.getNode())
 	state := State{
@@ -375,7 +477,7 @@ func (c *Chain) pushBlockWithoutCheck(b *types.Block) error {
 		return err
 	}
 
-	encState, err := c.st.serialize()
+	encState, err := state.serialize()
 	if err != nil {
 		return err
 	}
@@ -394,17 +496,40 @@ func (c *Chain) pushBlockWithoutCheck(b *types.Block) error {
 				return err
 			}
 		}
-		err = c.ms.partialCommitProcedure(b.Transactions)(tx)
+		// The state root of the genesis block is not committed by any block producer
+		var root *hash.Hash
+		if node.parent != nil {
+			root = &b.SignedHeader.StateRoot
+		}
+		err = c.ms.partialCommitWithUndoProcedure(b.Transactions, node.indexKey(), root)(tx)
 		return
 	})
 	if err != nil {
 		return err
 	}
-	c.st = &state
+	c.st.update(node)
 	c.bi.addBlock(node)
+	if h > voteBookDepth {
+		c.vb.prune(h - voteBookDepth)
+	}
 	return nil
 }
 
+// setProducers sets the accounts of the block producers to the meta state.
+func (c *Chain) setProducers() (err error) {
+	var (
+		peers     = c.rt.getPeers()
+		producers = make([]proto.AccountAddress, len(peers.Servers))
+	)
+	for i, v := range peers.Servers {
+		if producers[i], err = utils.PubKeyHash(v.PubKey); err != nil {
+			return
+		}
+	}
+	c.ms.setProducers(producers)
+	return
+}
+
 func (c *Chain) pushGenesisBlock(b *types.Block) (err error) {
 	err = c.pushBlockWithoutCheck(b)
 	if err != nil {
@@ -414,11 +539,23 @@ func (c *Chain) pushGenesisBlock(b *types.Block) (err error) {
 }
 
 func (c *Chain) pushBlock(b *types.Block) error {
+	if c.bi.hasBlock(b.SignedHeader.BlockHash) {
+		return nil
+	}
+	if !b.SignedHeader.ParentHash.IsEqual(c.st.getHeader()) {
+		return c.pushForkBlock(b)
+	}
+
 	err := c.checkBlock(b)
 	if err != nil {
 		return err
 	}
 
+	err = c.verifyCommits(b)
+	if err != nil {
+		return err
+	}
+
 	err = c.pushBlockWithoutCheck(b)
 	if err != nil {
 		return err
@@ -434,13 +571,219 @@ func (c *Chain) pushBlock(b *types.Block) error {
 	return nil
 }
 
+// pushForkBlock stores a block which does not extend the current head, and reorganizes the chain
+// if the branch of the block becomes the best one.
+func (c *Chain) pushForkBlock(b *types.Block) (err error) {
+	parent := c.bi.lookupBlock(b.SignedHeader.ParentHash)
+	if parent == nil {
+		return ErrParentNotFound
+	}
+	if err = c.checkBlockHash(b); err != nil {
+		return
+	}
+	if err = c.verifyCommits(b); err != nil {
+		return
+	}
+	// The tx billings can only be checked against the index upon the fork point, which is done
+	// when the branch is attached in reorganize
+	for _, v := range b.TxBillings {
+		if err = v.Verify(); err != nil {
+			return
+		}
+	}
+
+	var (
+		node = newBlockNode(c.rt.getHeightFromTime(b.Timestamp()), b, parent)
+		enc  []byte
+	)
+	if enc, err = b.Serialize(); err != nil {
+		return
+	}
+	if err = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Put(node.indexKey(), enc)
+	}); err != nil {
+		return
+	}
+	c.bi.addBlock(node)
+
+	log.WithFields(log.Fields{
+		"block_hash":  node.hash.String(),
+		"height":      node.height,
+		"count":       node.count,
+		"head_height": c.st.getHeight(),
+		"head_block":  c.st.getHeader().String(),
+	}).Info("received fork block")
+
+	if node.betterThan(c.st.getNode()) {
+		return c.reorganize(node)
+	}
+	return
+}
+
+// reorganize switches the head to tip: the blocks after the fork point on the current branch are
+// rolled back with their undo logs, their transactions are removed from the transaction indexes
+// and their tx billings are unpacked. Then the blocks on the new branch are applied with their tx
+// billings checked. Transactions of the rolled back blocks and the pool are applied again, and the
+// ones which conflict with the new branch are dropped.
+func (c *Chain) reorganize(tip *blockNode) (err error) {
+	c.stLock.Lock()
+	defer c.stLock.Unlock()
+
+	var (
+		head     = c.st.getNode()
+		fork     = forkPoint(head, tip)
+		pooled   = c.ms.pullTxs()
+		detached []*types.Block
+		attached []*types.Block
+		unmarked []*types.TxBilling
+		last     = make(map[proto.DatabaseID]*uint32)
+	)
+	if fork == nil {
+		return ErrParentNotFound
+	}
+
+	le := log.WithFields(log.Fields{
+		"fork_block": fork.hash.String(),
+		"old_head":   head.hash.String(),
+		"new_head":   tip.hash.String(),
+	})
+	le.Warning("reorganize chain")
+
+	loadBlock := func(bk *bolt.Bucket, node *blockNode) (b *types.Block, err error) {
+		b = &types.Block{}
+		err = b.Deserialize(bk.Get(node.indexKey()))
+		return
+	}
+	err = c.db.Update(func(tx *bolt.Tx) (err error) {
+		var (
+			b     *types.Block
+			bk    = tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket)
+			nodes []*blockNode
+			tbs   []*types.TxBilling
+			enc   []byte
+		)
+		for n := head; n != fork; n = n.parent {
+			if b, err = loadBlock(bk, n); err != nil {
+				return
+			}
+			if err = c.ms.rollbackProcedure(n.indexKey())(tx); err != nil {
+				return
+			}
+			for i := len(b.Transactions) - 1; i >= 0; i-- {
+				v := b.Transactions[i]
+				if err = unindexTransaction(tx, v.GetTransactionType(), v.GetHash()); err != nil {
+					return
+				}
+			}
+			tbs = append(tbs, b.TxBillings...)
+			detached = append(detached, b)
+		}
+		if err = unmarkTxBillingsProcedure(tbs, &unmarked, last)(tx); err != nil {
+			return
+		}
+		for n := tip; n != fork; n = n.parent {
+			nodes = append(nodes, n)
+		}
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if b, err = loadBlock(bk, nodes[i]); err != nil {
+				return
+			}
+			for _, v := range b.TxBillings {
+				if err = checkTxBillingProcedure(v)(tx); err != nil {
+					return
+				}
+				if err = pushTxBillingProcedure(v)(tx); err != nil {
+					return
+				}
+			}
+			for _, v := range b.Transactions {
+				if err = c.ms.applyTransactionProcedure(v)(tx); err != nil {
+					return
+				}
+			}
+			if err = c.ms.partialCommitWithUndoProcedure(
+				b.Transactions, nodes[i].indexKey(), &b.SignedHeader.StateRoot)(tx); err != nil {
+				return
+			}
+			attached = append(attached, b)
+		}
+		state := &State{
+			Node:   tip,
+			Head:   tip.hash,
+			Height: tip.height,
+		}
+		if enc, err = state.serialize(); err != nil {
+			return
+		}
+		return tx.Bucket(metaBucket[:]).Put(metaStateKey, enc)
+	})
+	if err != nil {
+		le.WithError(err).Error("failed to reorganize chain")
+		// The meta state has been changed in memory, reload it from the unchanged database
+		if rerr := c.db.View(c.ms.reloadProcedure()); rerr != nil {
+			le.WithError(rerr).Error("failed to reload meta state")
+		}
+		c.requeueTxs(pooled)
+		return
+	}
+	c.st.update(tip)
+
+	for _, v := range unmarked {
+		c.ti.addTxBilling(v)
+	}
+	for k, v := range last {
+		if v != nil {
+			c.ti.resetLastTxBilling(k, *v, true)
+		} else {
+			c.ti.resetLastTxBilling(k, 0, false)
+		}
+	}
+	for _, b := range attached {
+		for _, v := range b.TxBillings {
+			c.ti.addTxBilling(v)
+			if v.IsSigned() {
+				c.ti.updateLastTxBilling(v.GetDatabaseID(), v.GetSequenceID())
+			}
+		}
+	}
+	for i := len(detached) - 1; i >= 0; i-- {
+		c.requeueTxs(detached[i].Transactions)
+	}
+	c.requeueTxs(pooled)
+	return
+}
+
+// requeueTxs applies txs to the meta state again, the ones which cannot be applied are dropped.
+func (c *Chain) requeueTxs(txs []pi.Transaction) {
+	for _, v := range txs {
+		if err := c.processTx(v); err != nil {
+			log.WithFields(log.Fields{
+				"transaction": v.GetHash().String(),
+			}).WithError(err).Debug("drop transaction")
+		}
+	}
+}
+
 func (c *Chain) pushTxBillingWithoutCheck(tb *types.TxBilling) error {
-	encTx, err := tb.Serialize()
+	err := c.db.Update(pushTxBillingProcedure(tb))
 	if err != nil {
 		return err
 	}
+	c.ti.addTxBilling(tb)
+	if tb.IsSigned() {
+		c.ti.updateLastTxBilling(tb.GetDatabaseID(), tb.GetSequenceID())
+	}
+	return nil
+}
+
+// pushTxBillingProcedure stores tb to the index buckets.
+func pushTxBillingProcedure(tb *types.TxBilling) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		encTx, err := tb.Serialize()
+		if err != nil {
+			return err
+		}
 
-	err = c.db.Update(func(tx *bolt.Tx) error {
 		meta := tx.Bucket(metaBucket[:])
 		err = meta.Bucket(metaTxBillingIndexBucket).Put(tb.TxHash[:], encTx)
 		if err != nil {
@@ -462,15 +805,79 @@ func (c *Chain) pushTxBillingWithoutCheck(tb *types.TxBilling) error {
 			return err
 		}
 		return nil
-	})
-	if err != nil {
-		return err
 	}
-	c.ti.addTxBilling(tb)
-	if tb.IsSigned() {
-		c.ti.updateLastTxBilling(tb.GetDatabaseID(), tb.GetSequenceID())
+}
+
+// unmarkTxBillingsProcedure unpacks the tx billings of the rolled back blocks in the index
+// buckets, and rolls the last billing index of their databases back to the remaining packed tx
+// billings. The unpacked tx billings and the last billing indexes are returned to update txIndex.
+func unmarkTxBillingsProcedure(
+	tbs []*types.TxBilling, unmarked *[]*types.TxBilling, last map[proto.DatabaseID]*uint32,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) (err error) {
+		var (
+			meta = tx.Bucket(metaBucket[:])
+			bb   = meta.Bucket(metaTxBillingIndexBucket)
+			lb   = meta.Bucket(metaLastTxBillingIndexBucket)
+			enc  []byte
+		)
+		for _, v := range tbs {
+			var tb = v
+			if dec := bb.Get(v.TxHash[:]); dec != nil {
+				tb = &types.TxBilling{}
+				if err = tb.Deserialize(dec); err != nil {
+					return
+				}
+			}
+			tb.SetSignedBlock(nil)
+			if enc, err = tb.Serialize(); err != nil {
+				return
+			}
+			if err = bb.Put(tb.TxHash[:], enc); err != nil {
+				return
+			}
+			*unmarked = append(*unmarked, tb)
+			last[*tb.GetDatabaseID()] = nil
+		}
+		if len(last) == 0 {
+			return
+		}
+		if err = bb.ForEach(func(k, v []byte) (err error) {
+			var tb = &types.TxBilling{}
+			if err = tb.Deserialize(v); err != nil {
+				return
+			}
+			if !tb.IsSigned() {
+				return
+			}
+			if seq, ok := last[*tb.GetDatabaseID()]; ok && (seq == nil || *seq < tb.GetSequenceID()) {
+				var s = tb.GetSequenceID()
+				last[*tb.GetDatabaseID()] = &s
+			}
+			return
+		}); err != nil {
+			return
+		}
+		for k, v := range last {
+			var key, val *bytes.Buffer
+			if key, err = utils.EncodeMsgPack(&k); err != nil {
+				return
+			}
+			if v == nil {
+				if err = lb.Delete(key.Bytes()); err != nil {
+					return
+				}
+				continue
+			}
+			if val, err = utils.EncodeMsgPack(*v); err != nil {
+				return
+			}
+			if err = lb.Put(key.Bytes(), val.Bytes()); err != nil {
+				return
+			}
+		}
+		return
 	}
-	return nil
 }
 
 func (c *Chain) pushTxBilling(tb *types.TxBilling) error {
@@ -501,11 +908,20 @@ func (c *Chain) produceBlock(now time.Time) error {
 		TxBillings: c.ti.fetchUnpackedTxBillings(),
 	}
 
+	if b.SignedHeader.StateRoot, err = c.ms.stateRootAfter(b.Transactions); err != nil {
+		return err
+	}
+
 	err = b.PackAndSignBlock(priv)
 	if err != nil {
 		return err
 	}
 
+	err = c.commitBlock(b)
+	if err != nil {
+		return err
+	}
+
 	for i := range b.TxBillings {
 		b.TxBillings[i].SetSignedBlock(&b.SignedHeader.BlockHash)
 	}
@@ -556,21 +972,34 @@ func (c *Chain) produceTxBilling(br *types.BillingRequest) (_ *types.BillingResp
 		return
 	}
 
-	// update stable coin's balance
-	// TODO(lambda): because there is no token distribution,
-	// we only increase miners' balance but not decrease customer's balance
+	// fees are charged from the database payer and paid to miners on settlement, see
+	// metaState.applyBilling
+	profile, loaded := c.ms.loadSQLChainProfile(br.Header.DatabaseID)
+	if !loaded {
+		err = ErrDatabaseNotFound
+		return
+	}
 	var (
 		enc           []byte
-		accountNumber = len(br.Header.GasAmounts)
+		accountNumber = len(profile.Miners)
 		receivers     = make([]*proto.AccountAddress, accountNumber)
 		fees          = make([]uint64, accountNumber)
 		rewards       = make([]uint64, accountNumber)
+		index         = make(map[proto.AccountAddress]int, accountNumber)
 	)
 
-	for i, addrAndGas := range br.Header.GasAmounts {
-		receivers[i] = &addrAndGas.AccountAddress
-		fees[i] = addrAndGas.GasAmount * uint64(gasprice)
-		rewards[i] = 0
+	// every miner of the database is listed as a receiver, even if it earns nothing in the range
+	for i := range profile.Miners {
+		receivers[i] = &profile.Miners[i]
+		index[profile.Miners[i]] = i
+	}
+	for _, addrAndGas := range br.Header.GasAmounts {
+		i, ok := index[addrAndGas.AccountAddress]
+		if !ok {
+			err = ErrInvalidBillingRequest
+			return
+		}
+		fees[i] += addrAndGas.GasAmount * uint64(gasprice)
 	}
 
 	if enc, err = br.MarshalHash(); err != nil {
@@ -643,6 +1072,129 @@ func (c *Chain) produceTxBilling(br *types.BillingRequest) (_ *types.BillingResp
 	return resp, nil
 }
 
+// issueTx signs the transaction built with the next nonce of local account and applies it to
+// the meta state synchronously, so that the caller can be aware of the result.
+func (c *Chain) issueTx(build func(nonce pi.AccountNonce) pi.Transaction) (err error) {
+	c.issueLock.Lock()
+	defer c.issueLock.Unlock()
+
+	privKey, err := kms.GetLocalPrivateKey()
+	if err != nil {
+		return
+	}
+	var nc pi.AccountNonce
+	if nc, err = c.ms.nextNonce(c.rt.accountAddress); err != nil {
+		return
+	}
+	tx := build(nc)
+	if err = tx.Sign(privKey); err != nil {
+		return
+	}
+	return c.processTx(tx)
+}
+
+// createDatabase locks the deposit from owner's stable coin balance into the new database, as
+// authorized by the owner.
+func (c *Chain) createDatabase(
+	id proto.DatabaseID, deposit uint64, auth *types.DepositAuthorization,
+	miners []proto.AccountAddress) (err error,
+) {
+	return c.issueTx(func(nonce pi.AccountNonce) pi.Transaction {
+		return types.NewCreateDatabase(&types.CreateDatabaseHeader{
+			Issuer:        c.rt.accountAddress,
+			Owner:         auth.Owner,
+			DatabaseID:    id,
+			Nonce:         nonce,
+			Deposit:       deposit,
+			Authorization: *auth,
+			Miners:        miners,
+		})
+	})
+}
+
+// dropDatabase deletes the database and refunds the remaining deposit to its owner.
+func (c *Chain) dropDatabase(id proto.DatabaseID) (err error) {
+	return c.issueTx(func(nonce pi.AccountNonce) pi.Transaction {
+		return types.NewDropDatabase(&types.DropDatabaseHeader{
+			Issuer:     c.rt.accountAddress,
+			DatabaseID: id,
+			Nonce:      nonce,
+		})
+	})
+}
+
+// loadDatabaseFunds returns the deposit and arrears of the database, and the stable coin balance
+// of its owner.
+func (c *Chain) loadDatabaseFunds(id proto.DatabaseID) (deposit, arrears, balance uint64, err error) {
+	return c.ms.loadDatabaseFunds(id)
+}
+
+// loadSQLChainProfile returns a copy of the profile of database id.
+func (c *Chain) loadSQLChainProfile(id proto.DatabaseID) (p *types.SQLChainProfile, loaded bool) {
+	return c.ms.loadSQLChainProfile(id)
+}
+
+// queryTxHistory returns the transactions involving addr, the latest first. It skips the first
+// offset transactions and returns at most limit ones, or all of the rest if limit is 0.
+func (c *Chain) queryTxHistory(
+	addr proto.AccountAddress, offset, limit uint32) (records []*TxRecord, err error,
+) {
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		var (
+			meta = tx.Bucket(metaBucket[:])
+			ab   = meta.Bucket(metaAccountTxIndexBucket).Bucket(addr[:])
+			tb   = meta.Bucket(metaTransactionBucket)
+		)
+		if ab == nil {
+			return
+		}
+		var (
+			cur     = ab.Cursor()
+			skipped uint32
+		)
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if limit > 0 && uint32(len(records)) >= limit {
+				break
+			}
+			if len(v) != 4+hash.HashSize {
+				return ErrCorruptedIndex
+			}
+			r := &TxRecord{Type: pi.FromBytes(v[:4])}
+			copy(r.Hash[:], v[4:])
+			if r.Tx = tb.Bucket(r.Type.Bytes()).Get(r.Hash[:]); r.Tx == nil {
+				return ErrCorruptedIndex
+			}
+			r.Tx = append([]byte(nil), r.Tx...)
+			records = append(records, r)
+		}
+		return
+	})
+	return
+}
+
+// queryTxByHash returns the transaction of hash h.
+func (c *Chain) queryTxByHash(h hash.Hash) (record *TxRecord, err error) {
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		tb := tx.Bucket(metaBucket[:]).Bucket(metaTransactionBucket)
+		for i := pi.TransactionType(0); i < pi.TransactionTypeNumber; i++ {
+			if v := tb.Bucket(i.Bytes()).Get(h[:]); v != nil {
+				record = &TxRecord{
+					Type: i,
+					Hash: h,
+					Tx:   append([]byte(nil), v...),
+				}
+				return
+			}
+		}
+		return ErrNoSuchTransaction
+	})
+	return
+}
+
 // checkBillingRequest checks followings by order:
 // 1. period of sqlchain;
 // 2. request's hash
@@ -695,6 +1247,66 @@ func (c *Chain) fetchBlockByHeight(h uint32) (*types.Block, error) {
 	return b, nil
 }
 
+// proveState proves the committed state with the head block, which is retried in case that the
+// head block is being replaced.
+func (c *Chain) proveState(
+	prove func() (proof *merkle.Proof, root hash.Hash, err error)) (p *types.StateProof, err error,
+) {
+	for i := 0; i < proveStateRetries; i++ {
+		var (
+			node  = c.st.getNode()
+			b     = &types.Block{}
+			proof *merkle.Proof
+			root  hash.Hash
+		)
+		if node == nil || node.parent == nil {
+			return nil, ErrStateNotReady
+		}
+		if err = c.db.View(func(tx *bolt.Tx) error {
+			return b.Deserialize(
+				tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Get(node.indexKey()))
+		}); err != nil {
+			return
+		}
+		if proof, root, err = prove(); err != nil {
+			return
+		}
+		if root.IsEqual(&b.SignedHeader.StateRoot) {
+			p = &types.StateProof{
+				Header:  b.SignedHeader,
+				Commits: b.Commits,
+				Proof:   proof,
+			}
+			return
+		}
+	}
+	return nil, ErrStateNotReady
+}
+
+// proveAccount returns the committed account of addr, or nil if it does not exist, with its state
+// proof.
+func (c *Chain) proveAccount(
+	addr proto.AccountAddress) (account *types.Account, p *types.StateProof, err error,
+) {
+	p, err = c.proveState(func() (proof *merkle.Proof, root hash.Hash, err error) {
+		account, proof, root, err = c.ms.proveAccount(addr)
+		return
+	})
+	return
+}
+
+// proveSQLChainProfile returns the committed profile of id, or nil if it does not exist, with its
+// state proof.
+func (c *Chain) proveSQLChainProfile(
+	id proto.DatabaseID) (profile *types.SQLChainProfile, p *types.StateProof, err error,
+) {
+	p, err = c.proveState(func() (proof *merkle.Proof, root hash.Hash, err error) {
+		profile, proof, root, err = c.ms.proveSQLChainProfile(id)
+		return
+	})
+	return
+}
+
 // runCurrentTurn does the check and runs block producing if its my turn.
 func (c *Chain) runCurrentTurn(now time.Time) {
 	log.WithFields(log.Fields{
@@ -742,10 +1354,52 @@ func (c *Chain) sync() error {
 	return nil
 }
 
+// syncTime measures the clocks of the other block producers and updates the offset to the
+// coordinated chain time.
+func (c *Chain) syncTime() {
+	var (
+		peers  = c.rt.getPeers()
+		method = fmt.Sprintf("%s.%s", MainChainRPCName, "QueryTime")
+		ids    []proto.NodeID
+	)
+	for _, s := range peers.Servers {
+		if !s.ID.IsEqual(&c.rt.nodeID) {
+			ids = append(ids, s.ID)
+		}
+	}
+	offset := c.rt.clock.Sync(ids, func(id proto.NodeID) (recv, xmit time.Time, err error) {
+		resp := &timesync.QueryTimeResp{}
+		if err = c.cl.CallNode(id, method, &timesync.QueryTimeReq{}, resp); err != nil {
+			return
+		}
+		return resp.ReceiveTime, resp.TransmitTime, nil
+	})
+	c.rt.setOffset(offset)
+	log.WithFields(log.Fields{
+		"peer":   c.rt.getPeerInfoString(),
+		"offset": offset.String(),
+	}).Debug("Synchronized chain time")
+}
+
+// timeSyncCycle synchronizes the chain time once a period.
+func (c *Chain) timeSyncCycle() {
+	defer c.rt.wg.Done()
+	for {
+		c.syncTime()
+		select {
+		case <-c.rt.stopCh:
+			return
+		case <-time.After(c.rt.period):
+		}
+	}
+}
+
 // Start starts the chain by step:
 // 1. sync the chain
 // 2. goroutine for getting blocks
-// 3. goroutine for getting txes.
+// 3. goroutine for getting txes
+// 4. goroutine for voting on blocks proposed by the other block producers
+// 5. goroutine for synchronizing the chain time.
 func (c *Chain) Start() error {
 	err := c.sync()
 	if err != nil {
@@ -759,6 +1413,10 @@ func (c *Chain) Start() error {
 	c.rt.wg.Add(1)
 	go c.mainCycle()
 	c.rt.startService(c)
+	c.rt.wg.Add(1)
+	go c.processConsensus()
+	c.rt.wg.Add(1)
+	go c.timeSyncCycle()
 
 	return nil
 }
@@ -802,14 +1460,10 @@ func (c *Chain) processBlocks() {
 				}
 				stash = append(stash, block)
 			} else {
-				// Process block
-				if h < c.rt.getNextTurn()-1 {
-					// TODO(lambda): check and add to fork list.
-				} else {
-					err := c.pushBlock(block)
-					if err != nil {
-						log.Error(err)
-					}
+				// Process block, the older ones may be on a fork
+				err := c.pushBlock(block)
+				if err != nil {
+					log.Error(err)
 				}
 
 				// Return all stashed blocks to pending channel
@@ -829,6 +1483,18 @@ func (c *Chain) processTx(tx pi.Transaction) (err error) {
 	return c.db.Update(c.ms.applyTransactionProcedure(tx))
 }
 
+// addTx adds a transaction submitted by client to the pool, the error is returned if it's
+// rejected by the pool limits.
+func (c *Chain) addTx(tx pi.Transaction) (err error) {
+	if err = c.db.Update(c.ms.addTransactionProcedure(tx, c.rt.now())); err != nil {
+		log.WithFields(log.Fields{
+			"peer":        c.rt.getPeerInfoString(),
+			"transaction": tx.GetHash().String(),
+		}).Debugf("Failed to add tx with error: %v", err)
+	}
+	return
+}
+
 func (c *Chain) processTxs() {
 	defer c.rt.wg.Done()
 	for {
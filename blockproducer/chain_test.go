@@ -119,8 +119,16 @@ func TestChain(t *testing.T) {
 			}
 
 			// generate block
-			block, err := generateRandomBlockWithTxBillings(*chain.st.getHeader(), tbs)
+			block, err := generateRandomBlockWithTxBillings(*chain.st.getHeader(), chain.ms, tbs)
 			So(err, ShouldBeNil)
+			commit := types.NewVote(
+				types.VoteTypePrecommit,
+				chain.rt.getHeightFromTime(block.Timestamp()),
+				block.SignedHeader.BlockHash,
+				peers.Servers[0].ID,
+			)
+			So(commit.Sign(priv), ShouldBeNil)
+			block.Commits = []*types.Vote{commit}
 			err = chain.pushBlock(block)
 			So(err, ShouldBeNil)
 			for _, val := range tbs {
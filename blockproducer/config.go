@@ -27,6 +27,10 @@ import (
 
 const (
 	blockVersion int32 = 0x01
+
+	// proveStateRetries defines how many times a state proof is retried if the committed state
+	// does not match the head block, e.g. when a new block is being committed.
+	proveStateRetries = 3
 )
 
 // Config is the main chain configuration.
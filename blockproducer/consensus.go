@@ -0,0 +1,543 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/coreos/bbolt"
+)
+
+// The main chain commits a block in two voting rounds, which are driven by the proposer of the
+// block over the kayak transport:
+//
+// 1. Prevote: the proposer sends the block with its own prevote to the other block producers,
+//    each of them checks the block and replies a prevote on it.
+// 2. Precommit: with more than 2/3 prevotes, the proposer sends the prevotes to the other block
+//    producers, each of them verifies the prevotes and replies a precommit on the block.
+//
+// The block is final with more than 2/3 precommits, which are stored in the block as its commits.
+// A block producer never votes twice on different blocks at the same height and round type, and
+// any conflicting votes seen from the others are recorded as equivocation evidences, which are
+// sent to the other block producers.
+
+const (
+	// MainChainConsensusName defines the kayak transport service name of main chain consensus.
+	MainChainConsensusName = "MCCBFT"
+
+	consensusTransportID = "MainChain"
+	prevoteMethod        = "Prevote"
+	precommitMethod      = "Precommit"
+	evidenceMethod       = "Evidence"
+
+	// voteBookDepth defines how many heights of votes are kept for equivocation detection.
+	voteBookDepth = 16
+)
+
+// proposal defines the payload of a prevote request.
+type proposal struct {
+	// Block is the serialized proposed block.
+	Block   []byte
+	Prevote *types.Vote
+}
+
+// prevoteProof defines the payload of a precommit request.
+type prevoteProof struct {
+	Prevotes []*types.Vote
+}
+
+// quorum returns the minimum number of votes which is more than 2/3 of n.
+func quorum(n int) int {
+	return n*2/3 + 1
+}
+
+type voteKey struct {
+	voteType types.VoteType
+	height   uint32
+	voter    proto.NodeID
+}
+
+// voteBook keeps the recent votes seen by the block producer to detect equivocations.
+type voteBook struct {
+	sync.Mutex
+	votes map[voteKey]*types.Vote
+}
+
+func newVoteBook() *voteBook {
+	return &voteBook{
+		votes: make(map[voteKey]*types.Vote),
+	}
+}
+
+// record records vote v and returns an evidence if it conflicts with a recorded vote.
+func (b *voteBook) record(v *types.Vote) (ev *types.Evidence) {
+	b.Lock()
+	defer b.Unlock()
+	k := voteKey{voteType: v.Type, height: v.Height, voter: v.Voter}
+	if prev, ok := b.votes[k]; ok {
+		if !prev.BlockHash.IsEqual(&v.BlockHash) {
+			ev = &types.Evidence{First: prev, Second: v}
+		}
+		return
+	}
+	b.votes[k] = v
+	return
+}
+
+// lookup returns the recorded vote of the voter.
+func (b *voteBook) lookup(t types.VoteType, height uint32, voter proto.NodeID) *types.Vote {
+	b.Lock()
+	defer b.Unlock()
+	return b.votes[voteKey{voteType: t, height: height, voter: voter}]
+}
+
+// prune drops the votes below height.
+func (b *voteBook) prune(height uint32) {
+	b.Lock()
+	defer b.Unlock()
+	for k := range b.votes {
+		if k.height < height {
+			delete(b.votes, k)
+		}
+	}
+}
+
+// vote signs a vote of this block producer. It returns the recorded vote if this block producer
+// has already voted on the same block, or ErrEquivocation if it has voted on another one.
+func (c *Chain) vote(t types.VoteType, height uint32, h hash.Hash) (v *types.Vote, err error) {
+	if v = c.vb.lookup(t, height, c.rt.nodeID); v != nil {
+		if !v.BlockHash.IsEqual(&h) {
+			return nil, ErrEquivocation
+		}
+		return
+	}
+
+	priv, err := kms.GetLocalPrivateKey()
+	if err != nil {
+		return
+	}
+	v = types.NewVote(t, height, h, c.rt.nodeID)
+	if err = v.Sign(priv); err != nil {
+		return nil, err
+	}
+	if ev := c.vb.record(v); ev != nil {
+		// voted concurrently on another block
+		return nil, ErrEquivocation
+	}
+	return
+}
+
+// verifyVote verifies vote v of type t on block h at the given height, and checks it against the
+// recorded votes.
+func (c *Chain) verifyVote(v *types.Vote, t types.VoteType, height uint32, h *hash.Hash) (err error) {
+	if v == nil || v.Type != t || v.Height != height || !v.BlockHash.IsEqual(h) {
+		return ErrInvalidVote
+	}
+
+	var voter *kayak.Server
+	for _, s := range c.rt.getPeers().Servers {
+		if s.ID == v.Voter {
+			voter = s
+			break
+		}
+	}
+	if voter == nil || !voter.PubKey.IsEqual(v.Signee) {
+		return ErrInvalidVote
+	}
+	if err = v.Verify(); err != nil {
+		return
+	}
+
+	if ev := c.vb.record(v); ev != nil {
+		c.reportEquivocation(ev)
+		return ErrEquivocation
+	}
+	return
+}
+
+// verifyVotes verifies that votes of type t on block h are cast by more than 2/3 block producers.
+func (c *Chain) verifyVotes(votes []*types.Vote, t types.VoteType, height uint32, h *hash.Hash) (err error) {
+	voters := make(map[proto.NodeID]struct{}, len(votes))
+	for _, v := range votes {
+		if err = c.verifyVote(v, t, height, h); err != nil {
+			return
+		}
+		voters[v.Voter] = struct{}{}
+	}
+	if len(voters) < quorum(len(c.rt.getPeers().Servers)) {
+		return ErrNotEnoughVotes
+	}
+	return
+}
+
+// verifyCommits verifies that block b is committed by more than 2/3 block producers.
+func (c *Chain) verifyCommits(b *types.Block) error {
+	return c.verifyVotes(
+		b.Commits,
+		types.VoteTypePrecommit,
+		c.rt.getHeightFromTime(b.Timestamp()),
+		&b.SignedHeader.BlockHash,
+	)
+}
+
+// evidenceKey returns the storage key of evidence ev, which is unique for each equivocation of
+// a voter at a height and round type.
+func evidenceKey(ev *types.Evidence) (key []byte) {
+	key = make([]byte, 8, 8+len(ev.First.Voter))
+	binary.BigEndian.PutUint32(key, uint32(ev.First.Type))
+	binary.BigEndian.PutUint32(key[4:], ev.First.Height)
+	return append(key, ev.First.Voter...)
+}
+
+// storeEvidence stores the equivocation evidence, it returns false if the same equivocation is
+// already stored.
+func (c *Chain) storeEvidence(ev *types.Evidence) (stored bool, err error) {
+	enc, err := utils.EncodeMsgPack(ev)
+	if err != nil {
+		return
+	}
+	err = c.db.Update(func(tx *bolt.Tx) (err error) {
+		var (
+			bucket = tx.Bucket(metaBucket[:]).Bucket(metaEvidenceBucket)
+			key    = evidenceKey(ev)
+		)
+		if bucket.Get(key) != nil {
+			return
+		}
+		if err = bucket.Put(key, enc.Bytes()); err != nil {
+			return
+		}
+		stored = true
+		return
+	})
+	return
+}
+
+// reportEquivocation logs and stores the equivocation evidence, and propagates it to the other
+// block producers.
+func (c *Chain) reportEquivocation(ev *types.Evidence) {
+	le := log.WithFields(log.Fields{
+		"voter":  ev.First.Voter,
+		"type":   ev.First.Type.String(),
+		"height": ev.First.Height,
+		"first":  ev.First.BlockHash.String(),
+		"second": ev.Second.BlockHash.String(),
+	})
+	le.Warning("equivocation detected")
+
+	stored, err := c.storeEvidence(ev)
+	if err != nil {
+		le.WithError(err).Error("failed to store equivocation evidence")
+		return
+	}
+	if stored {
+		go c.broadcastEvidence(ev)
+	}
+}
+
+// broadcastEvidence sends the equivocation evidence to the other block producers.
+func (c *Chain) broadcastEvidence(ev *types.Evidence) {
+	if c.rt.transport == nil {
+		return
+	}
+	enc, err := utils.EncodeMsgPack(ev)
+	if err != nil {
+		log.WithError(err).Error("failed to encode equivocation evidence")
+		return
+	}
+
+	var (
+		l = &kayak.Log{
+			Index: uint64(ev.First.Height),
+			Data:  enc.Bytes(),
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), c.rt.period/3)
+		wg          = &sync.WaitGroup{}
+	)
+	defer cancel()
+
+	for _, s := range c.rt.getPeers().Servers {
+		if s.ID.IsEqual(&c.rt.nodeID) {
+			continue
+		}
+		wg.Add(1)
+		go func(id proto.NodeID) {
+			defer wg.Done()
+			if _, err := c.rt.transport.Request(ctx, id, evidenceMethod, l); err != nil {
+				log.WithFields(log.Fields{
+					"remote": id,
+					"voter":  ev.First.Voter,
+					"height": ev.First.Height,
+				}).WithError(err).Debug("failed to send equivocation evidence")
+			}
+		}(s.ID)
+	}
+	wg.Wait()
+}
+
+// processEvidence verifies and stores the equivocation evidence reported by another block
+// producer.
+func (c *Chain) processEvidence(data []byte) (err error) {
+	var ev = &types.Evidence{}
+	if err = utils.DecodeMsgPack(data, ev); err != nil {
+		return
+	}
+	if err = ev.Verify(); err != nil {
+		return
+	}
+
+	var voter *kayak.Server
+	for _, s := range c.rt.getPeers().Servers {
+		if s.ID == ev.First.Voter {
+			voter = s
+			break
+		}
+	}
+	if voter == nil || !voter.PubKey.IsEqual(ev.First.Signee) {
+		return types.ErrInvalidEvidence
+	}
+
+	var stored bool
+	if stored, err = c.storeEvidence(ev); err != nil {
+		return
+	}
+	if stored {
+		log.WithFields(log.Fields{
+			"voter":  ev.First.Voter,
+			"type":   ev.First.Type.String(),
+			"height": ev.First.Height,
+			"first":  ev.First.BlockHash.String(),
+			"second": ev.Second.BlockHash.String(),
+		}).Warning("equivocation reported")
+	}
+	return
+}
+
+// commitBlock runs the voting rounds on block b proposed by this block producer, and stores the
+// precommits in the block when it is committed.
+func (c *Chain) commitBlock(b *types.Block) (err error) {
+	var (
+		height = c.rt.getHeightFromTime(b.Timestamp())
+		h      = b.SignedHeader.BlockHash
+		enc    []byte
+		own    *types.Vote
+
+		prevotes, precommits []*types.Vote
+	)
+	if own, err = c.vote(types.VoteTypePrevote, height, h); err != nil {
+		return
+	}
+	if enc, err = b.Serialize(); err != nil {
+		return
+	}
+	if prevotes, err = c.collectVotes(prevoteMethod, own, &proposal{
+		Block:   enc,
+		Prevote: own,
+	}); err != nil {
+		return
+	}
+
+	if own, err = c.vote(types.VoteTypePrecommit, height, h); err != nil {
+		return
+	}
+	if precommits, err = c.collectVotes(precommitMethod, own, &prevoteProof{
+		Prevotes: prevotes,
+	}); err != nil {
+		return
+	}
+
+	b.Commits = precommits
+	return
+}
+
+// collectVotes requests the other block producers to vote like own with the payload, and returns
+// the votes if more than 2/3 block producers vote.
+func (c *Chain) collectVotes(
+	method string, own *types.Vote, payload interface{}) (votes []*types.Vote, err error,
+) {
+	var enc *bytes.Buffer
+	if enc, err = utils.EncodeMsgPack(payload); err != nil {
+		return
+	}
+
+	var (
+		peers = c.rt.getPeers()
+		l     = &kayak.Log{
+			Index: uint64(own.Height),
+			Data:  enc.Bytes(),
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), c.rt.period/3)
+		wg          = &sync.WaitGroup{}
+		lock        sync.Mutex
+	)
+	defer cancel()
+
+	votes = append(votes, own)
+	for _, s := range peers.Servers {
+		if s.ID.IsEqual(&c.rt.nodeID) {
+			continue
+		}
+		wg.Add(1)
+		go func(id proto.NodeID) {
+			defer wg.Done()
+			var (
+				v    = &types.Vote{}
+				le   = log.WithFields(log.Fields{"method": method, "remote": id, "height": own.Height})
+				resp []byte
+				err  error
+			)
+			if resp, err = c.rt.transport.Request(ctx, id, method, l); err != nil {
+				le.WithError(err).Debug("failed to request vote")
+				return
+			}
+			if err = utils.DecodeMsgPack(resp, v); err != nil {
+				le.WithError(err).Debug("failed to decode vote")
+				return
+			}
+			if v.Voter != id {
+				le.Debug("received vote of another voter")
+				return
+			}
+			if err = c.verifyVote(v, own.Type, own.Height, &own.BlockHash); err != nil {
+				le.WithError(err).Warning("received invalid vote")
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			votes = append(votes, v)
+		}(s.ID)
+	}
+	wg.Wait()
+
+	if len(votes) < quorum(len(peers.Servers)) {
+		return nil, ErrNotEnoughVotes
+	}
+	return
+}
+
+// processPrevote checks the proposed block and prevotes on it.
+func (c *Chain) processPrevote(data []byte) (v *types.Vote, err error) {
+	var (
+		p = &proposal{}
+		b = &types.Block{}
+	)
+	if err = utils.DecodeMsgPack(data, p); err != nil {
+		return
+	}
+	if err = b.Deserialize(p.Block); err != nil {
+		return
+	}
+	if err = b.Verify(); err != nil {
+		return
+	}
+
+	height := c.rt.getHeightFromTime(b.Timestamp())
+	proposer := c.rt.getProposer(height)
+	if proposer == nil || p.Prevote == nil || p.Prevote.Voter != proposer.ID ||
+		!proposer.PubKey.IsEqual(b.SignedHeader.Signee) {
+		return nil, ErrInvalidProposer
+	}
+	if err = c.verifyVote(p.Prevote, types.VoteTypePrevote, height, &b.SignedHeader.BlockHash); err != nil {
+		return
+	}
+	if err = c.checkBlock(b); err != nil {
+		return
+	}
+
+	return c.vote(types.VoteTypePrevote, height, b.SignedHeader.BlockHash)
+}
+
+// processPrecommit verifies the prevotes and precommits on the block.
+func (c *Chain) processPrecommit(data []byte) (v *types.Vote, err error) {
+	var p = &prevoteProof{}
+	if err = utils.DecodeMsgPack(data, p); err != nil {
+		return
+	}
+	if len(p.Prevotes) == 0 || p.Prevotes[0] == nil {
+		return nil, ErrNotEnoughVotes
+	}
+
+	var (
+		height = p.Prevotes[0].Height
+		h      = p.Prevotes[0].BlockHash
+	)
+	if err = c.verifyVotes(p.Prevotes, types.VoteTypePrevote, height, &h); err != nil {
+		return
+	}
+
+	return c.vote(types.VoteTypePrecommit, height, h)
+}
+
+func (c *Chain) processConsensusRequest(req kayak.Request) {
+	var (
+		v    *types.Vote
+		enc  *bytes.Buffer
+		resp []byte
+		err  error
+	)
+
+	if req.GetLog() == nil {
+		err = kayak.ErrInvalidRequest
+	} else {
+		switch req.GetMethod() {
+		case prevoteMethod:
+			v, err = c.processPrevote(req.GetLog().Data)
+		case precommitMethod:
+			v, err = c.processPrecommit(req.GetLog().Data)
+		case evidenceMethod:
+			err = c.processEvidence(req.GetLog().Data)
+		default:
+			err = kayak.ErrInvalidRequest
+		}
+	}
+	if err == nil && v != nil {
+		if enc, err = utils.EncodeMsgPack(v); err == nil {
+			resp = enc.Bytes()
+		}
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"peer":   c.rt.getPeerInfoString(),
+			"remote": req.GetPeerNodeID(),
+			"method": req.GetMethod(),
+		}).WithError(err).Debug("refused to vote")
+	}
+	req.SendResponse(resp, err)
+}
+
+func (c *Chain) processConsensus() {
+	defer c.rt.wg.Done()
+	for {
+		select {
+		case req := <-c.rt.transport.Process():
+			c.processConsensusRequest(req)
+		case <-c.stopCh:
+			return
+		}
+	}
+}
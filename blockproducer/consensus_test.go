@@ -0,0 +1,292 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/coreos/bbolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func signTestVote(
+	priv *asymmetric.PrivateKey, t types.VoteType, height uint32, h hash.Hash, voter proto.NodeID,
+) (v *types.Vote) {
+	v = types.NewVote(t, height, h, voter)
+	So(v.Sign(priv), ShouldBeNil)
+	return
+}
+
+func TestConsensus(t *testing.T) {
+	Convey("Given a chain of 4 block producers", t, func() {
+		var (
+			privs = make([]*asymmetric.PrivateKey, 4)
+			peers = &kayak.Peers{}
+			fl    = path.Join(testDataDir, t.Name())
+			err   error
+		)
+		kms.SetLocalKeyPair(testPrivKey, testPubKey)
+		privs[0] = testPrivKey
+		for i := range privs {
+			if privs[i] == nil {
+				privs[i], _, err = asymmetric.GenSecp256k1KeyPair()
+				So(err, ShouldBeNil)
+			}
+			peers.Servers = append(peers.Servers, &kayak.Server{
+				ID:     proto.NodeID([]byte{'n', 'o', 'd', 'e', byte('0' + i)}),
+				PubKey: privs[i].PubKey(),
+			})
+		}
+
+		db, err := bolt.Open(fl, 0600, nil)
+		So(err, ShouldBeNil)
+		Reset(func() {
+			So(db.Close(), ShouldBeNil)
+			So(os.Remove(fl), ShouldBeNil)
+		})
+		err = db.Update(func(tx *bolt.Tx) (err error) {
+			var meta *bolt.Bucket
+			if meta, err = tx.CreateBucket(metaBucket[:]); err != nil {
+				return
+			}
+			_, err = meta.CreateBucket(metaEvidenceBucket)
+			return
+		})
+		So(err, ShouldBeNil)
+
+		var (
+			period = time.Second
+			now    = time.Now().UTC()
+			c      = &Chain{
+				db: db,
+				ms: newMetaState(),
+				vb: newVoteBook(),
+				st: &State{Head: hash.Hash{0x1}},
+				rt: &rt{
+					chainInitTime: now.Add(-period - period/2),
+					bpNum:         4,
+					period:        period,
+					peers:         peers,
+					nodeID:        peers.Servers[0].ID,
+				},
+			}
+			// block at height 1 is proposed by node1
+			b = &types.Block{
+				SignedHeader: types.SignedHeader{
+					Header: types.Header{
+						Version:    blockVersion,
+						ParentHash: hash.Hash{0x1},
+						Timestamp:  now,
+					},
+				},
+			}
+			height uint32 = 1
+		)
+		So(b.PackAndSignBlock(privs[1]), ShouldBeNil)
+		So(c.rt.getHeightFromTime(b.Timestamp()), ShouldEqual, height)
+		So(c.rt.getProposer(height).ID, ShouldEqual, peers.Servers[1].ID)
+
+		Convey("Quorum should be more than 2/3 of the block producers", func() {
+			So(quorum(1), ShouldEqual, 1)
+			So(quorum(3), ShouldEqual, 3)
+			So(quorum(4), ShouldEqual, 3)
+			So(quorum(7), ShouldEqual, 5)
+		})
+		Convey("The block producer should not vote on different blocks at the same height", func() {
+			v, err := c.vote(types.VoteTypePrevote, height, hash.Hash{0x2})
+			So(err, ShouldBeNil)
+			So(v.Verify(), ShouldBeNil)
+			v2, err := c.vote(types.VoteTypePrevote, height, hash.Hash{0x2})
+			So(err, ShouldBeNil)
+			So(v2, ShouldEqual, v)
+			_, err = c.vote(types.VoteTypePrevote, height, hash.Hash{0x3})
+			So(err, ShouldEqual, ErrEquivocation)
+			_, err = c.vote(types.VoteTypePrecommit, height, hash.Hash{0x3})
+			So(err, ShouldBeNil)
+			_, err = c.vote(types.VoteTypePrevote, height+1, hash.Hash{0x3})
+			So(err, ShouldBeNil)
+		})
+		Convey("Votes should be verified against the block producers", func() {
+			var (
+				h     = hash.Hash{0x2}
+				votes = make([]*types.Vote, 4)
+			)
+			for i := range votes {
+				votes[i] = signTestVote(privs[i], types.VoteTypePrecommit, height, h, peers.Servers[i].ID)
+			}
+			So(c.verifyVotes(votes[:2], types.VoteTypePrecommit, height, &h), ShouldEqual, ErrNotEnoughVotes)
+			So(c.verifyVotes(
+				[]*types.Vote{votes[0], votes[1], votes[1]}, types.VoteTypePrecommit, height, &h,
+			), ShouldEqual, ErrNotEnoughVotes)
+			So(c.verifyVotes(votes[:3], types.VoteTypePrevote, height, &h), ShouldEqual, ErrInvalidVote)
+			So(c.verifyVotes(votes[:3], types.VoteTypePrecommit, height+1, &h), ShouldEqual, ErrInvalidVote)
+			So(c.verifyVotes(votes[:3], types.VoteTypePrecommit, height, &h), ShouldBeNil)
+			So(c.verifyVotes(votes, types.VoteTypePrecommit, height, &h), ShouldBeNil)
+
+			forged := signTestVote(privs[3], types.VoteTypePrecommit, height, h, peers.Servers[2].ID)
+			So(c.verifyVote(forged, types.VoteTypePrecommit, height, &h), ShouldEqual, ErrInvalidVote)
+			unknown := signTestVote(privs[3], types.VoteTypePrecommit, height, h, proto.NodeID("node4"))
+			So(c.verifyVote(unknown, types.VoteTypePrecommit, height, &h), ShouldEqual, ErrInvalidVote)
+		})
+		Convey("Equivocations should be detected and recorded", func() {
+			var (
+				h1 = hash.Hash{0x2}
+				h2 = hash.Hash{0x3}
+				v1 = signTestVote(privs[1], types.VoteTypePrecommit, height, h1, peers.Servers[1].ID)
+				v2 = signTestVote(privs[1], types.VoteTypePrecommit, height, h2, peers.Servers[1].ID)
+			)
+			So(c.verifyVote(v1, types.VoteTypePrecommit, height, &h1), ShouldBeNil)
+			So(c.verifyVote(v2, types.VoteTypePrecommit, height, &h2), ShouldEqual, ErrEquivocation)
+
+			var evs []*types.Evidence
+			err = db.View(func(tx *bolt.Tx) error {
+				return tx.Bucket(metaBucket[:]).Bucket(metaEvidenceBucket).ForEach(
+					func(k, v []byte) (err error) {
+						ev := &types.Evidence{}
+						if err = utils.DecodeMsgPack(v, ev); err != nil {
+							return
+						}
+						evs = append(evs, ev)
+						return
+					})
+			})
+			So(err, ShouldBeNil)
+			So(len(evs), ShouldEqual, 1)
+			So(evs[0].Verify(), ShouldBeNil)
+			So(evs[0].First.BlockHash, ShouldEqual, h1)
+			So(evs[0].Second.BlockHash, ShouldEqual, h2)
+
+			Convey("The votes should be pruned by height", func() {
+				c.vb.prune(height + 1)
+				So(c.verifyVote(v2, types.VoteTypePrecommit, height, &h2), ShouldBeNil)
+			})
+		})
+		Convey("Equivocation evidences reported by others should be verified and recorded", func() {
+			var (
+				v1  = signTestVote(privs[2], types.VoteTypePrevote, height, hash.Hash{0x2}, peers.Servers[2].ID)
+				v2  = signTestVote(privs[2], types.VoteTypePrevote, height, hash.Hash{0x3}, peers.Servers[2].ID)
+				f1  = signTestVote(privs[3], types.VoteTypePrevote, height, hash.Hash{0x2}, peers.Servers[2].ID)
+				f2  = signTestVote(privs[3], types.VoteTypePrevote, height, hash.Hash{0x3}, peers.Servers[2].ID)
+				buf *bytes.Buffer
+			)
+			buf, err = utils.EncodeMsgPack(&types.Evidence{First: f1, Second: f2})
+			So(err, ShouldBeNil)
+			So(c.processEvidence(buf.Bytes()), ShouldEqual, types.ErrInvalidEvidence)
+			buf, err = utils.EncodeMsgPack(&types.Evidence{First: v1, Second: v1})
+			So(err, ShouldBeNil)
+			So(c.processEvidence(buf.Bytes()), ShouldEqual, types.ErrInvalidEvidence)
+
+			ev := &types.Evidence{First: v1, Second: v2}
+			buf, err = utils.EncodeMsgPack(ev)
+			So(err, ShouldBeNil)
+			So(c.processEvidence(buf.Bytes()), ShouldBeNil)
+			stored, err := c.storeEvidence(ev)
+			So(err, ShouldBeNil)
+			So(stored, ShouldBeFalse)
+			err = db.View(func(tx *bolt.Tx) error {
+				So(tx.Bucket(metaBucket[:]).Bucket(metaEvidenceBucket).Get(evidenceKey(ev)), ShouldNotBeNil)
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+		Convey("The block producer should vote on the proposed block", func() {
+			var (
+				h       = b.SignedHeader.BlockHash
+				enc     []byte
+				buf     *bytes.Buffer
+				prevote = signTestVote(privs[1], types.VoteTypePrevote, height, h, peers.Servers[1].ID)
+			)
+			enc, err = b.Serialize()
+			So(err, ShouldBeNil)
+
+			// proposed by unexpected block producer
+			buf, err = utils.EncodeMsgPack(&proposal{
+				Block:   enc,
+				Prevote: signTestVote(privs[2], types.VoteTypePrevote, height, h, peers.Servers[2].ID),
+			})
+			So(err, ShouldBeNil)
+			_, err = c.processPrevote(buf.Bytes())
+			So(err, ShouldEqual, ErrInvalidProposer)
+
+			buf, err = utils.EncodeMsgPack(&proposal{Block: enc, Prevote: prevote})
+			So(err, ShouldBeNil)
+			own, err := c.processPrevote(buf.Bytes())
+			So(err, ShouldBeNil)
+			So(own.Type, ShouldEqual, types.VoteTypePrevote)
+			So(own.BlockHash, ShouldEqual, h)
+			So(own.Voter, ShouldEqual, c.rt.nodeID)
+
+			// precommit with not enough prevotes
+			buf, err = utils.EncodeMsgPack(&prevoteProof{Prevotes: []*types.Vote{prevote, own}})
+			So(err, ShouldBeNil)
+			_, err = c.processPrecommit(buf.Bytes())
+			So(err, ShouldEqual, ErrNotEnoughVotes)
+
+			buf, err = utils.EncodeMsgPack(&prevoteProof{Prevotes: []*types.Vote{
+				prevote, own,
+				signTestVote(privs[2], types.VoteTypePrevote, height, h, peers.Servers[2].ID),
+			}})
+			So(err, ShouldBeNil)
+			precommit, err := c.processPrecommit(buf.Bytes())
+			So(err, ShouldBeNil)
+			So(precommit.Type, ShouldEqual, types.VoteTypePrecommit)
+			So(precommit.BlockHash, ShouldEqual, h)
+
+			b.Commits = []*types.Vote{
+				precommit,
+				signTestVote(privs[1], types.VoteTypePrecommit, height, h, peers.Servers[1].ID),
+			}
+			So(c.verifyCommits(b), ShouldEqual, ErrNotEnoughVotes)
+			b.Commits = append(b.Commits,
+				signTestVote(privs[3], types.VoteTypePrecommit, height, h, peers.Servers[3].ID))
+			So(c.verifyCommits(b), ShouldBeNil)
+
+			// commits should survive block serialization
+			enc, err = b.Serialize()
+			So(err, ShouldBeNil)
+			decoded := &types.Block{}
+			So(decoded.Deserialize(enc), ShouldBeNil)
+			So(c.verifyCommits(decoded), ShouldBeNil)
+
+			// a conflicting block at the same height should be refused
+			fork := &types.Block{SignedHeader: b.SignedHeader}
+			fork.SignedHeader.Timestamp = now.Add(time.Millisecond)
+			So(fork.PackAndSignBlock(privs[1]), ShouldBeNil)
+			enc, err = fork.Serialize()
+			So(err, ShouldBeNil)
+			buf, err = utils.EncodeMsgPack(&proposal{
+				Block: enc,
+				Prevote: signTestVote(
+					privs[1], types.VoteTypePrevote, height, fork.SignedHeader.BlockHash, peers.Servers[1].ID),
+			})
+			So(err, ShouldBeNil)
+			_, err = c.processPrevote(buf.Bytes())
+			So(err, ShouldEqual, ErrEquivocation)
+		})
+	})
+}
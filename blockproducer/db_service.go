@@ -17,7 +17,9 @@
 package blockproducer
 
 import (
+	"math"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,7 +33,9 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/sqlchain"
 	ct "github.com/CovenantSQL/CovenantSQL/sqlchain/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	wt "github.com/CovenantSQL/CovenantSQL/worker/types"
 	dto "github.com/prometheus/client_model/go"
@@ -42,6 +46,27 @@ const (
 	DefaultAllocationRounds = 3
 	// DBServiceName for block producer to provide database management related logic.
 	DBServiceName = "BPDB"
+	// DefaultDepositPeriod defines the default billing periods prepaid by database deposit.
+	DefaultDepositPeriod = 100
+	// depositSpaceUnit defines the space unit of deposit computation, which is 1 megabyte.
+	depositSpaceUnit = 1 << 20
+	// DatabaseGracePeriods defines the remaining prepaid billing periods for a database to enter
+	// grace state.
+	DatabaseGracePeriods = 10
+	// DatabaseSuspendPeriods defines the billing periods of arrears for a read-only database to be
+	// suspended.
+	DatabaseSuspendPeriods = 3
+	// DatabaseExpirePeriods defines the billing periods of arrears for a suspended database to be
+	// dropped, its final billing is settled and the remaining deposit is refunded on expiry.
+	DatabaseExpirePeriods = 30
+	// DefaultBillingSettleTimeout defines the max time to wait for the final billing of a database
+	// to be applied before dropping it.
+	DefaultBillingSettleTimeout = time.Minute
+	// billingSettleCheckInterval defines the interval to check whether the final billing is
+	// applied.
+	billingSettleCheckInterval = time.Second
+	// DefaultStateCheckInterval defines the default interval of database state evaluation.
+	DefaultStateCheckInterval = time.Minute
 )
 
 var (
@@ -64,6 +89,13 @@ type DBService struct {
 	Consistent       *consistent.Consistent
 	NodeMetrics      *metric.NodeMetricMap
 
+	// Chain defines the main chain to lock and refund database deposits, accounting is disabled
+	// if it's nil.
+	Chain *Chain
+	// DepositPeriod defines the billing periods prepaid by database deposit, the deposit is sized
+	// by nodes × space (in megabytes) × period.
+	DepositPeriod uint64
+
 	// include block producer nodes for database allocation, for test case injection
 	includeBPNodesForAllocation bool
 }
@@ -90,15 +122,19 @@ func (s *DBService) CreateDatabase(req *CreateDatabaseRequest, resp *CreateDatab
 		return
 	}
 
-	// TODO(lambda): call accounting features, top up deposit
 	var genesisBlock *ct.Block
 	if genesisBlock, err = s.generateGenesisBlock(dbID, req.Header.ResourceMeta); err != nil {
 		return
 	}
 
+	// lock deposit from database owner
+	if err = s.lockDeposit(req, dbID, peers); err != nil {
+		return
+	}
+
 	defer func() {
 		if err != nil {
-			// TODO(lambda): release deposit on error
+			s.refundDeposit(dbID)
 		}
 	}()
 
@@ -181,11 +217,29 @@ func (s *DBService) DropDatabase(req *DropDatabaseRequest, resp *DropDatabaseRes
 		return
 	}
 
+	if err = s.dropDatabase(instanceMeta); err != nil {
+		return
+	}
+
+	// send response to client
+	// nothing to set on response, only error flag
+
+	return
+}
+
+// dropDatabase settles the final billing of the database, drops it from the miner nodes, refunds
+// the remaining deposit to its owner and removes it from meta.
+func (s *DBService) dropDatabase(instanceMeta wt.ServiceInstance) (err error) {
+	// pay the miners for the blocks which are not billed yet before refunding
+	if err = s.settleBilling(instanceMeta); err != nil {
+		return
+	}
+
 	// call miner nodes to drop database
 	dropDBSvcReq := new(wt.UpdateService)
 	dropDBSvcReq.Header.Op = wt.DropDB
 	dropDBSvcReq.Header.Instance = wt.ServiceInstance{
-		DatabaseID: req.Header.DatabaseID,
+		DatabaseID: instanceMeta.DatabaseID,
 	}
 	if dropDBSvcReq.Header.Signee, err = kms.GetLocalPublicKey(); err != nil {
 		return
@@ -203,21 +257,73 @@ func (s *DBService) DropDatabase(req *DropDatabaseRequest, resp *DropDatabaseRes
 	}
 
 	// withdraw deposit from sqlchain
-	// TODO(lambda): withdraw deposit and record drop database request
+	s.refundDeposit(instanceMeta.DatabaseID)
 
 	// remove from meta
-	if err = s.ServiceMap.Delete(req.Header.DatabaseID); err != nil {
+	if err = s.ServiceMap.Delete(instanceMeta.DatabaseID); err != nil {
 		// critical error
 		// TODO(xq262144): critical error recover
 		return
 	}
 
-	// send response to client
-	// nothing to set on response, only error flag
-
 	return
 }
 
+// settleBilling launches the billing of the blocks which are not billed yet on the leader of the
+// database, and waits until the billing is applied to the main chain.
+func (s *DBService) settleBilling(instanceMeta wt.ServiceInstance) (err error) {
+	if s.Chain == nil || instanceMeta.Peers == nil || instanceMeta.Peers.Leader == nil {
+		return
+	}
+
+	profile, loaded := s.Chain.loadSQLChainProfile(instanceMeta.DatabaseID)
+	if !loaded {
+		return ErrDatabaseNotFound
+	}
+
+	var (
+		next = profile.NextBillingHeight
+		req  = &sqlchain.MuxLaunchBillingReq{
+			DatabaseID: instanceMeta.DatabaseID,
+			LaunchBillingReq: sqlchain.LaunchBillingReq{
+				Low:  next,
+				High: -1,
+			},
+		}
+		resp = &sqlchain.MuxLaunchBillingResp{}
+	)
+	if err = rpc.NewCaller().CallNode(
+		instanceMeta.Peers.Leader.ID, route.SQLCLaunchBilling.String(), req, resp,
+	); err != nil {
+		if strings.Contains(err.Error(), sqlchain.ErrUnavailableBillingRang.Error()) {
+			// nothing left to bill
+			err = nil
+		}
+		return
+	}
+
+	timeout := time.After(DefaultBillingSettleTimeout)
+	ticker := time.NewTicker(billingSettleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if profile, loaded = s.Chain.loadSQLChainProfile(instanceMeta.DatabaseID); !loaded {
+				return ErrDatabaseNotFound
+			}
+			if profile.NextBillingHeight > next {
+				return
+			}
+		case <-timeout:
+			log.WithFields(log.Fields{
+				"db":   instanceMeta.DatabaseID,
+				"next": next,
+			}).Warning("settle database billing timeout")
+			return ErrBillingSettleTimeout
+		}
+	}
+}
+
 // GetDatabase defines block producer get database logic.
 func (s *DBService) GetDatabase(req *GetDatabaseRequest, resp *GetDatabaseResponse) (err error) {
 	// verify signature
@@ -275,6 +381,232 @@ func (s *DBService) GetNodeDatabases(req *wt.InitService, resp *wt.InitServiceRe
 	return
 }
 
+func (s *DBService) lockDeposit(
+	req *CreateDatabaseRequest, dbID proto.DatabaseID, peers *kayak.Peers) (err error,
+) {
+	if s.Chain == nil {
+		return
+	}
+
+	var (
+		owner   proto.AccountAddress
+		deposit uint64
+		auth    = &req.Header.Authorization
+		miners  = make([]proto.AccountAddress, len(peers.Servers))
+	)
+	// the allocated miners are recorded as the only receivers of the database billings
+	for i, v := range peers.Servers {
+		if miners[i], err = utils.PubKeyHash(v.PubKey); err != nil {
+			return
+		}
+	}
+	if owner, err = utils.PubKeyHash(req.Header.Signee); err != nil {
+		return
+	}
+	// the deposit should be authorized by the requester itself
+	if auth.Owner != owner {
+		return ErrInvalidDepositAuthorization
+	}
+	if err = auth.Verify(); err != nil {
+		return
+	}
+	if deposit, err = computeDeposit(req.Header.ResourceMeta, s.depositPeriod()); err != nil {
+		return
+	}
+	if deposit > auth.MaxDeposit {
+		return ErrInvalidDepositAuthorization
+	}
+	if err = s.Chain.createDatabase(dbID, deposit, auth, miners); err != nil {
+		log.WithFields(log.Fields{
+			"db":      dbID,
+			"owner":   owner.String(),
+			"deposit": deposit,
+		}).WithError(err).Warning("lock database deposit failed")
+	}
+	return
+}
+
+func (s *DBService) refundDeposit(dbID proto.DatabaseID) {
+	if s.Chain == nil {
+		return
+	}
+
+	if err := s.Chain.dropDatabase(dbID); err != nil {
+		// TODO(lambda): retry refund
+		log.WithField("db", dbID).WithError(err).Error("refund database deposit failed")
+	}
+}
+
+func (s *DBService) depositPeriod() uint64 {
+	if s.DepositPeriod == 0 {
+		return DefaultDepositPeriod
+	}
+	return s.DepositPeriod
+}
+
+// EstimateDeposit returns the deposit locked by a block producer with the default deposit period
+// for a database of resourceMeta, which is the max deposit authorized by the owner by default.
+func EstimateDeposit(resourceMeta wt.ResourceMeta) (deposit uint64, err error) {
+	return computeDeposit(resourceMeta, DefaultDepositPeriod)
+}
+
+func computeDeposit(resourceMeta wt.ResourceMeta, period uint64) (deposit uint64, err error) {
+	var space = (resourceMeta.Space + depositSpaceUnit - 1) / depositSpaceUnit
+	if space == 0 {
+		space = 1
+	}
+
+	deposit = uint64(resourceMeta.Node)
+	for _, v := range []uint64{space, period, uint64(gasprice)} {
+		if v != 0 && deposit > math.MaxUint64/v {
+			err = ErrBalanceOverflow
+			return
+		}
+		deposit *= v
+	}
+	return
+}
+
+// MonitorStates evaluates the database states from main chain periodically and pushes the changed
+// states to miners, until the stop channel is closed.
+func (s *DBService) MonitorStates(interval time.Duration, stopCh <-chan struct{}) {
+	if s.Chain == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkStates()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (s *DBService) checkStates() {
+	dbs, err := s.ServiceMap.GetAllDatabases()
+	if err != nil {
+		log.WithError(err).Warning("get databases for state check failed")
+		return
+	}
+
+	for _, meta := range dbs {
+		var (
+			state   wt.DatabaseState
+			expired bool
+		)
+		if expired, err = s.isExpired(meta); err != nil {
+			log.WithField("db", meta.DatabaseID).WithError(err).Debug("check database expiry failed")
+			continue
+		}
+		if expired {
+			if err = s.dropDatabase(meta); err != nil {
+				log.WithField("db", meta.DatabaseID).WithError(err).Warning("drop expired database failed")
+			} else {
+				log.WithField("db", meta.DatabaseID).Info("expired database dropped")
+			}
+			continue
+		}
+		if state, err = s.evaluateState(meta); err != nil {
+			log.WithField("db", meta.DatabaseID).WithError(err).Debug("evaluate database state failed")
+			continue
+		}
+		if state == meta.State {
+			continue
+		}
+		if err = s.updateState(meta, state); err != nil {
+			log.WithFields(log.Fields{
+				"db":    meta.DatabaseID,
+				"state": state.String(),
+			}).WithError(err).Warning("update database state failed")
+		}
+	}
+}
+
+// evaluateState computes the database state by its prepaid runway: a database enters grace state
+// when its funds cover less than DatabaseGracePeriods billing periods, turns read-only once it
+// owes arrears, and is suspended when the arrears reach DatabaseSuspendPeriods billing periods.
+func (s *DBService) evaluateState(meta wt.ServiceInstance) (state wt.DatabaseState, err error) {
+	var deposit, arrears, balance, cost uint64
+	if deposit, arrears, balance, err = s.Chain.loadDatabaseFunds(meta.DatabaseID); err != nil {
+		return
+	}
+	if cost, err = computeDeposit(meta.ResourceMeta, 1); err != nil {
+		return
+	}
+	if cost == 0 {
+		state = wt.DatabaseActive
+		return
+	}
+
+	switch {
+	case arrears/cost >= DatabaseSuspendPeriods:
+		state = wt.DatabaseSuspended
+	case arrears > 0:
+		state = wt.DatabaseReadOnly
+	case deposit/cost+balance/cost < DatabaseGracePeriods:
+		state = wt.DatabaseGrace
+	default:
+		state = wt.DatabaseActive
+	}
+	return
+}
+
+// isExpired returns whether the arrears of the database reach DatabaseExpirePeriods billing
+// periods.
+func (s *DBService) isExpired(meta wt.ServiceInstance) (expired bool, err error) {
+	var arrears, cost uint64
+	if _, arrears, _, err = s.Chain.loadDatabaseFunds(meta.DatabaseID); err != nil {
+		return
+	}
+	if cost, err = computeDeposit(meta.ResourceMeta, 1); err != nil {
+		return
+	}
+	expired = cost > 0 && arrears/cost >= DatabaseExpirePeriods
+	return
+}
+
+func (s *DBService) updateState(meta wt.ServiceInstance, state wt.DatabaseState) (err error) {
+	var privateKey *asymmetric.PrivateKey
+	var pubKey *asymmetric.PublicKey
+
+	if pubKey, err = kms.GetLocalPublicKey(); err != nil {
+		return
+	}
+	if privateKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+
+	updateStateReq := new(wt.UpdateService)
+	updateStateReq.Header.Op = wt.UpdateState
+	updateStateReq.Header.Instance = wt.ServiceInstance{
+		DatabaseID: meta.DatabaseID,
+		State:      state,
+	}
+	updateStateReq.Header.Signee = pubKey
+	if err = updateStateReq.Sign(privateKey); err != nil {
+		return
+	}
+
+	if err = s.batchSendSingleSvcReq(updateStateReq, s.peersToNodes(meta.Peers)); err != nil {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"db":   meta.DatabaseID,
+		"from": meta.State.String(),
+		"to":   state.String(),
+	}).Info("database state changed")
+
+	// save to meta
+	meta.State = state
+	return s.ServiceMap.Set(meta)
+}
+
 func (s *DBService) generateDatabaseID(reqNodeID *proto.RawNodeID) (dbID proto.DatabaseID, err error) {
 	var startNonce cpuminer.Uint256
 
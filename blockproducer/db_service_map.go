@@ -20,6 +20,7 @@ import (
 	"sync"
 
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
 	wt "github.com/CovenantSQL/CovenantSQL/worker/types"
 )
 
@@ -65,6 +66,8 @@ func InitServiceMap(persistImpl DBMetaPersistence) (s *DBServiceMap, err error)
 				s.nodeMap[server.ID] = make(map[proto.DatabaseID]bool)
 			}
 			s.nodeMap[server.ID][meta.DatabaseID] = true
+			// the nodes allocated by block producer are trusted as miners
+			route.SetNodeRole(server.ID, proto.Miner)
 		}
 	}
 
@@ -100,6 +103,7 @@ func (c *DBServiceMap) Set(meta wt.ServiceInstance) (err error) {
 			c.nodeMap[s.ID] = make(map[proto.DatabaseID]bool)
 		}
 		c.nodeMap[s.ID][meta.DatabaseID] = true
+		route.SetNodeRole(s.ID, proto.Miner)
 	}
 
 	// set to persistence
@@ -172,3 +176,17 @@ func (c *DBServiceMap) GetDatabases(nodeID proto.NodeID) (dbs []wt.ServiceInstan
 
 	return
 }
+
+// GetAllDatabases returns all the database configs.
+func (c *DBServiceMap) GetAllDatabases() (dbs []wt.ServiceInstance, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	dbs = make([]wt.ServiceInstance, 0, len(c.dbMap))
+
+	for _, db := range c.dbMap {
+		dbs = append(dbs, db)
+	}
+
+	return
+}
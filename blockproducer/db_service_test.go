@@ -20,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/metric"
@@ -231,3 +232,66 @@ func buildQuery(queryType wt.QueryType, connID uint64, seqNo uint64, databaseID
 
 	return
 }
+
+func TestServiceEvaluateState(t *testing.T) {
+	Convey("Given a db service with main chain accounting", t, func() {
+		var (
+			owner = proto.AccountAddress{0x0, 0x0, 0x0, 0x1}
+			dbID  = proto.DatabaseID("db")
+			ms    = newMetaState()
+			s     = &DBService{Chain: &Chain{ms: ms}}
+			meta  = wt.ServiceInstance{
+				DatabaseID:   dbID,
+				ResourceMeta: wt.ResourceMeta{Node: 2},
+			}
+			state wt.DatabaseState
+			err   error
+		)
+		ms.loadOrStoreAccountObject(owner, &accountObject{
+			Account: pt.Account{
+				Address: owner,
+			},
+		})
+		ms.loadOrStoreSQLChainObject(dbID, &sqlchainObject{
+			SQLChainProfile: pt.SQLChainProfile{
+				ID:      dbID,
+				Owner:   owner,
+				Deposit: 2 * DatabaseGracePeriods,
+			},
+		})
+		Convey("The database should be active with enough funds", func() {
+			state, err = s.evaluateState(meta)
+			So(err, ShouldBeNil)
+			So(state, ShouldEqual, wt.DatabaseActive)
+		})
+		Convey("The database should change state as funds run out", func() {
+			co, _ := ms.loadSQLChainObject(dbID)
+			co.Deposit = 2
+			state, err = s.evaluateState(meta)
+			So(err, ShouldBeNil)
+			So(state, ShouldEqual, wt.DatabaseGrace)
+			co.Deposit = 0
+			co.Arrears = 1
+			state, err = s.evaluateState(meta)
+			So(err, ShouldBeNil)
+			So(state, ShouldEqual, wt.DatabaseReadOnly)
+			co.Arrears = 2 * DatabaseSuspendPeriods
+			state, err = s.evaluateState(meta)
+			So(err, ShouldBeNil)
+			So(state, ShouldEqual, wt.DatabaseSuspended)
+			var expired bool
+			expired, err = s.isExpired(meta)
+			So(err, ShouldBeNil)
+			So(expired, ShouldBeFalse)
+			co.Arrears = 2 * DatabaseExpirePeriods
+			expired, err = s.isExpired(meta)
+			So(err, ShouldBeNil)
+			So(expired, ShouldBeTrue)
+		})
+		Convey("The unknown database should not be evaluated", func() {
+			meta.DatabaseID = proto.DatabaseID("unknown")
+			_, err = s.evaluateState(meta)
+			So(err, ShouldEqual, ErrDatabaseNotFound)
+		})
+	})
+}
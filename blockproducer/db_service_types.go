@@ -17,6 +17,9 @@
 package blockproducer
 
 import (
+	"bytes"
+
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/types"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
@@ -26,6 +29,9 @@ import (
 // CreateDatabaseRequestHeader defines client create database rpc header.
 type CreateDatabaseRequestHeader struct {
 	ResourceMeta wt.ResourceMeta
+	// Authorization allows block producer to lock the deposit of the new database from the
+	// owner's stable coin balance.
+	Authorization types.DepositAuthorization
 }
 
 // Serialize structure to bytes.
@@ -34,7 +40,12 @@ func (h *CreateDatabaseRequestHeader) Serialize() []byte {
 		return []byte{'\000'}
 	}
 
-	return h.ResourceMeta.Serialize()
+	buf := new(bytes.Buffer)
+
+	buf.Write(h.ResourceMeta.Serialize())
+	buf.Write(h.Authorization.HeaderHash[:])
+
+	return buf.Bytes()
 }
 
 // SignedCreateDatabaseRequestHeader defines signed client create database request header.
@@ -59,7 +70,7 @@ func (sh *SignedCreateDatabaseRequestHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedCreateDatabaseRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedCreateDatabaseRequestHeader) Sign(signer asymmetric.Signer) (err error) {
 	// build hash
 	buildHash(&sh.CreateDatabaseRequestHeader, &sh.HeaderHash)
 
@@ -81,7 +92,7 @@ func (r *CreateDatabaseRequest) Verify() error {
 }
 
 // Sign the request.
-func (r *CreateDatabaseRequest) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (r *CreateDatabaseRequest) Sign(signer asymmetric.Signer) (err error) {
 	// sign
 	return r.Header.Sign(signer)
 }
@@ -122,7 +133,7 @@ func (sh *SignedCreateDatabaseResponseHeader) Verify() (err error) {
 }
 
 // Sign the response.
-func (sh *SignedCreateDatabaseResponseHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedCreateDatabaseResponseHeader) Sign(signer asymmetric.Signer) (err error) {
 	// build hash
 	buildHash(&sh.CreateDatabaseResponseHeader, &sh.HeaderHash)
 
@@ -144,7 +155,7 @@ func (r *CreateDatabaseResponse) Verify() error {
 }
 
 // Sign the response.
-func (r *CreateDatabaseResponse) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (r *CreateDatabaseResponse) Sign(signer asymmetric.Signer) (err error) {
 	// sign
 	return r.Header.Sign(signer)
 }
@@ -185,7 +196,7 @@ func (sh *SignedDropDatabaseRequestHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedDropDatabaseRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedDropDatabaseRequestHeader) Sign(signer asymmetric.Signer) (err error) {
 	// build hash
 	buildHash(&sh.DropDatabaseRequestHeader, &sh.HeaderHash)
 
@@ -207,7 +218,7 @@ func (r *DropDatabaseRequest) Verify() error {
 }
 
 // Sign the request.
-func (r *DropDatabaseRequest) Sign(signer *asymmetric.PrivateKey) error {
+func (r *DropDatabaseRequest) Sign(signer asymmetric.Signer) error {
 	return r.Header.Sign(signer)
 }
 
@@ -250,7 +261,7 @@ func (sh *SignedGetDatabaseRequestHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedGetDatabaseRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedGetDatabaseRequestHeader) Sign(signer asymmetric.Signer) (err error) {
 	// build hash
 	buildHash(&sh.GetDatabaseRequestHeader, &sh.HeaderHash)
 
@@ -272,7 +283,7 @@ func (r *GetDatabaseRequest) Verify() error {
 }
 
 // Sign the request.
-func (r *GetDatabaseRequest) Sign(signer *asymmetric.PrivateKey) error {
+func (r *GetDatabaseRequest) Sign(signer asymmetric.Signer) error {
 	return r.Header.Sign(signer)
 }
 
@@ -312,7 +323,7 @@ func (sh *SignedGetDatabaseResponseHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedGetDatabaseResponseHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedGetDatabaseResponseHeader) Sign(signer asymmetric.Signer) (err error) {
 	// build hash
 	buildHash(&sh.GetDatabaseResponseHeader, &sh.HeaderHash)
 
@@ -334,7 +345,7 @@ func (r *GetDatabaseResponse) Verify() (err error) {
 }
 
 // Sign the request.
-func (r *GetDatabaseResponse) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (r *GetDatabaseResponse) Sign(signer asymmetric.Signer) (err error) {
 	return r.Header.Sign(signer)
 }
 
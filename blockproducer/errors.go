@@ -46,6 +46,8 @@ var (
 	ErrNoSuchBlock = errors.New("Cannot find such block")
 	// ErrNoSuchTxBilling defines no such txbilling error.
 	ErrNoSuchTxBilling = errors.New("Cannot find such txbilling")
+	// ErrNoSuchTransaction defines no such transaction error.
+	ErrNoSuchTransaction = errors.New("Cannot find such transaction")
 	// ErrSmallerSequenceID defines that new sequence id is smaller the old one.
 	ErrSmallerSequenceID = errors.New("SequanceID should be bigger than the old one")
 	// ErrInvalidBillingRequest defines BillingRequest is invalid
@@ -65,6 +67,17 @@ var (
 	ErrDatabaseNotFound = errors.New("database not found")
 	// ErrDatabaseExists indicates that the database already exists.
 	ErrDatabaseExists = errors.New("database already exists")
+	// ErrInvalidDepositAuthorization indicates that the deposit of a database is not authorized by
+	// its owner.
+	ErrInvalidDepositAuthorization = errors.New("invalid deposit authorization")
+	// ErrBillingSettleTimeout indicates that the final billing of a database is not applied in time.
+	ErrBillingSettleTimeout = errors.New("database billing settlement timeout")
+	// ErrInvalidIssuer indicates that a database transaction is issued by neither the database
+	// owner nor a block producer.
+	ErrInvalidIssuer = errors.New("transaction issuer is neither the owner nor a block producer")
+	// ErrInvalidBillingIssuer indicates that a billing is issued by neither a block producer nor
+	// the miners of the database.
+	ErrInvalidBillingIssuer = errors.New("billing issuer is neither a block producer nor the database miners")
 	// ErrDatabaseUserExists indicates that the database user already exists.
 	ErrDatabaseUserExists = errors.New("database user already exists")
 	// ErrInvalidAccountNonce indicates that a transaction has a invalid account nonce.
@@ -74,4 +87,31 @@ var (
 	ErrUnknownTransactionType = errors.New("unknown transaction type")
 	// ErrTransactionMismatch indicates that transactions to be committed mismatch the pool.
 	ErrTransactionMismatch = errors.New("transaction mismatch")
+	// ErrMissingUndoLog indicates that the undo log to roll back a block cannot be found.
+	ErrMissingUndoLog = errors.New("missing undo log")
+	// ErrTxPoolFull indicates that the transaction pool is full and the transaction doesn't pay
+	// enough fee to evict another one.
+	ErrTxPoolFull = errors.New("transaction pool is full")
+	// ErrAccountTxPoolFull indicates that the account has too many transactions in the pool.
+	ErrAccountTxPoolFull = errors.New("too many pending transactions of account")
+
+	// Errors on main chain consensus
+
+	// ErrInvalidVote indicates that a vote is not cast on the expected block by a block producer.
+	ErrInvalidVote = errors.New("invalid vote")
+	// ErrInvalidProposer indicates that a block is not proposed by the expected block producer.
+	ErrInvalidProposer = errors.New("invalid block proposer")
+	// ErrNotEnoughVotes indicates that a block is voted by no more than 2/3 block producers.
+	ErrNotEnoughVotes = errors.New("not enough votes")
+	// ErrEquivocation indicates that a vote conflicts with another one of the same voter.
+	ErrEquivocation = errors.New("equivocation detected")
+
+	// Errors on main chain state
+
+	// ErrInvalidStateRoot indicates that the state root of a block does not match the state after
+	// applying the block.
+	ErrInvalidStateRoot = errors.New("invalid state root")
+	// ErrStateNotReady indicates that the committed state cannot be proved with the head block,
+	// e.g., the head block is the genesis one or is being replaced.
+	ErrStateNotReady = errors.New("state not ready to be proved")
 )
@@ -0,0 +1,369 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// partitionHarness runs several chains sharing the same genesis block without network, blocks are
+// delivered by the test to simulate network partitions.
+type partitionHarness struct {
+	genesis *types.Block
+	peers   *kayak.Peers
+	configs []*Config
+	chains  []*Chain
+	// txs records the transactions from the genesis block to each block, which are replayed to
+	// compute the state roots of new blocks.
+	txs map[hash.Hash][]pi.Transaction
+}
+
+func newPartitionHarness(name string, n int) (h *partitionHarness) {
+	var err error
+	kms.SetLocalKeyPair(testPrivKey, testPubKey)
+	h = &partitionHarness{
+		peers: &kayak.Peers{
+			Servers: []*kayak.Server{
+				{ID: proto.NodeID("node0"), PubKey: testPubKey},
+			},
+		},
+	}
+	h.genesis, err = generateRandomBlock(genesisHash, true)
+	So(err, ShouldBeNil)
+	h.txs = map[hash.Hash][]pi.Transaction{
+		h.genesis.SignedHeader.BlockHash: h.genesis.Transactions,
+	}
+	for i := 0; i < n; i++ {
+		var (
+			fl  = path.Join(testDataDir, name+string('0'+byte(i)))
+			cfg = NewConfig(h.genesis, fl, nil, h.peers, h.peers.Servers[0].ID, testPeriod, testTick)
+			c   *Chain
+		)
+		c, err = NewChain(cfg)
+		So(err, ShouldBeNil)
+		h.configs = append(h.configs, cfg)
+		h.chains = append(h.chains, c)
+	}
+	return
+}
+
+func (h *partitionHarness) close() {
+	for i, c := range h.chains {
+		So(c.db.Close(), ShouldBeNil)
+		So(os.Remove(h.configs[i].DataFile), ShouldBeNil)
+	}
+}
+
+// newBlock returns a block of height on parent, which is committed by the block producer.
+func (h *partitionHarness) newBlock(parent hash.Hash, height uint32, txs ...pi.Transaction) *types.Block {
+	return h.newBlockWithTxBillings(parent, height, nil, txs...)
+}
+
+// newBlockWithTxBillings returns a block of height on parent with the tx billings packed.
+func (h *partitionHarness) newBlockWithTxBillings(
+	parent hash.Hash, height uint32, tbs []*types.TxBilling, txs ...pi.Transaction,
+) *types.Block {
+	var (
+		all = append(append([]pi.Transaction{}, h.txs[parent]...), txs...)
+		b   = &types.Block{
+			SignedHeader: types.SignedHeader{
+				Header: types.Header{
+					Version:    blockVersion,
+					ParentHash: parent,
+					Timestamp: h.genesis.Timestamp().Add(
+						time.Duration(height)*testPeriod + testPeriod/2),
+				},
+			},
+			TxBillings:   tbs,
+			Transactions: txs,
+		}
+		err error
+	)
+	b.SignedHeader.StateRoot, err = newMetaState().stateRootAfter(all)
+	So(err, ShouldBeNil)
+	So(b.PackAndSignBlock(testPrivKey), ShouldBeNil)
+	for _, v := range tbs {
+		v.SetSignedBlock(&b.SignedHeader.BlockHash)
+	}
+	h.txs[b.SignedHeader.BlockHash] = all
+	b.Commits = []*types.Vote{signTestVote(
+		testPrivKey, types.VoteTypePrecommit, height, b.SignedHeader.BlockHash, h.peers.Servers[0].ID)}
+	return b
+}
+
+// deliver pushes the blocks to the chain in order.
+func (h *partitionHarness) deliver(c *Chain, blocks ...*types.Block) {
+	for _, b := range blocks {
+		So(c.pushBlock(b), ShouldBeNil)
+	}
+}
+
+func newTestTransfer(
+	sender, receiver proto.AccountAddress, nonce pi.AccountNonce, amount uint64) pi.Transaction {
+	t := &types.Transfer{
+		TransferHeader: types.TransferHeader{
+			Sender:   sender,
+			Receiver: receiver,
+			Nonce:    nonce,
+			Amount:   amount,
+		},
+	}
+	So(t.Sign(testPrivKey), ShouldBeNil)
+	return t
+}
+
+func newTestTxBilling(id proto.DatabaseID, seq uint32) *types.TxBilling {
+	tc, err := generateRandomTxContent()
+	So(err, ShouldBeNil)
+	tc.SequenceID = seq
+	tc.BillingRequest.Header.DatabaseID = id
+	enc, err := tc.MarshalHash()
+	So(err, ShouldBeNil)
+	var (
+		h    = hash.THashH(enc)
+		addr = testAddress1
+	)
+	sign, err := testPrivKey.Sign(h[:])
+	So(err, ShouldBeNil)
+	return &types.TxBilling{
+		TxContent:      *tc,
+		AccountAddress: &addr,
+		TxHash:         &h,
+		Signee:         testPubKey,
+		Signature:      sign,
+	}
+}
+
+func committedBalance(c *Chain, addr proto.AccountAddress) uint64 {
+	o, ok := c.ms.readonly.accounts[addr]
+	So(ok, ShouldBeTrue)
+	return o.StableCoinBalance
+}
+
+func TestChainFork(t *testing.T) {
+	Convey("Given two block producers sharing the same blocks", t, func() {
+		var (
+			h      = newPartitionHarness(t.Name(), 2)
+			ca, cb = h.chains[0], h.chains[1]
+			b1     = h.newBlock(h.genesis.SignedHeader.BlockHash, 1,
+				newTestTransfer(testAddress1, testAddress2, 1, 10))
+		)
+		Reset(h.close)
+		h.deliver(ca, b1)
+		h.deliver(cb, b1)
+		So(*ca.st.getHeader(), ShouldEqual, b1.SignedHeader.BlockHash)
+		So(*cb.st.getHeader(), ShouldEqual, b1.SignedHeader.BlockHash)
+
+		Convey("The chains should converge to the longer branch after a partition", func() {
+			var (
+				conflicted = newTestTransfer(testAddress1, testAddress2, 2, 100)
+				requeued   = newTestTransfer(testAddress2, testAddress1, 1, 5)
+				a2         = h.newBlock(b1.SignedHeader.BlockHash, 2, conflicted, requeued)
+				b3         = h.newBlock(b1.SignedHeader.BlockHash, 3,
+					newTestTransfer(testAddress1, testAddress2, 2, 20))
+				b4 = h.newBlock(b3.SignedHeader.BlockHash, 4,
+					newTestTransfer(testAddress1, testAddress2, 3, 30))
+			)
+			// Partitioned
+			h.deliver(ca, a2)
+			h.deliver(cb, b3, b4)
+			So(committedBalance(ca, testAddress1), ShouldEqual, testInitBalance-105)
+			So(committedBalance(cb, testAddress1), ShouldEqual, testInitBalance-60)
+
+			// Healed
+			h.deliver(ca, b3, b4)
+			h.deliver(cb, a2)
+			for _, c := range h.chains {
+				So(*c.st.getHeader(), ShouldEqual, b4.SignedHeader.BlockHash)
+				So(c.st.getHeight(), ShouldEqual, 4)
+				So(c.bi.hasBlock(a2.SignedHeader.BlockHash), ShouldBeTrue)
+				So(committedBalance(c, testAddress1), ShouldEqual, testInitBalance-60)
+				So(committedBalance(c, testAddress2), ShouldEqual, testInitBalance+60)
+				So(c.ms.readonly.accounts[testAddress1].NextNonce, ShouldEqual, 4)
+				// The maintained state trie should follow the rollback and the commits
+				trie, err := c.ms.readonly.stateTrie()
+				So(err, ShouldBeNil)
+				So(c.ms.trie.Root(), ShouldResemble, trie.Root())
+				So(c.ms.trie.Root(), ShouldResemble, b4.SignedHeader.StateRoot)
+				_, err = c.fetchBlockByHeight(2)
+				So(err, ShouldEqual, ErrNoSuchBlock)
+				b, err := c.fetchBlockByHeight(3)
+				So(err, ShouldBeNil)
+				So(b.SignedHeader.BlockHash, ShouldEqual, b3.SignedHeader.BlockHash)
+			}
+
+			// The detached transactions should be requeued unless they conflict with the new branch
+			So(ca.ms.pool.hasTx(conflicted), ShouldBeFalse)
+			So(ca.ms.pool.hasTx(requeued), ShouldBeTrue)
+			bl, loaded := ca.ms.loadAccountStableBalance(testAddress1)
+			So(loaded, ShouldBeTrue)
+			So(bl, ShouldEqual, testInitBalance-55)
+
+			// The dropped transactions should be removed from the indexes
+			_, err := ca.queryTxByHash(conflicted.GetHash())
+			So(err, ShouldEqual, ErrNoSuchTransaction)
+			_, err = ca.queryTxByHash(requeued.GetHash())
+			So(err, ShouldBeNil)
+			records, err := ca.queryTxHistory(testAddress1, 0, 0)
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 5)
+			for _, v := range records {
+				So(v.Hash, ShouldNotEqual, conflicted.GetHash())
+			}
+
+			Convey("The reorganized chain should be reloaded from database", func() {
+				So(ca.db.Close(), ShouldBeNil)
+				c, err := LoadChain(h.configs[0])
+				So(err, ShouldBeNil)
+				h.chains[0] = c
+				So(*c.st.getHeader(), ShouldEqual, b4.SignedHeader.BlockHash)
+				So(c.st.getNode().count, ShouldEqual, 3)
+				So(c.bi.hasBlock(a2.SignedHeader.BlockHash), ShouldBeTrue)
+				So(committedBalance(c, testAddress1), ShouldEqual, testInitBalance-60)
+				So(committedBalance(c, testAddress2), ShouldEqual, testInitBalance+60)
+
+				// should be able to switch back to the other branch
+				a5 := h.newBlock(a2.SignedHeader.BlockHash, 5)
+				a6 := h.newBlock(a5.SignedHeader.BlockHash, 6)
+				h.deliver(c, a5, a6)
+				So(*c.st.getHeader(), ShouldEqual, a6.SignedHeader.BlockHash)
+				So(committedBalance(c, testAddress1), ShouldEqual, testInitBalance-105)
+				So(committedBalance(c, testAddress2), ShouldEqual, testInitBalance+105)
+			})
+		})
+		Convey("The tx billings should follow the chain reorganization", func() {
+			var (
+				id  = proto.DatabaseID("db")
+				tb1 = newTestTxBilling(id, 5)
+				a2  = h.newBlockWithTxBillings(b1.SignedHeader.BlockHash, 2, []*types.TxBilling{tb1})
+				b3  = h.newBlock(b1.SignedHeader.BlockHash, 3)
+				b4  = h.newBlock(b3.SignedHeader.BlockHash, 4)
+			)
+			h.deliver(ca, a2)
+			seq, err := ca.ti.lastSequenceID(&id)
+			So(err, ShouldBeNil)
+			So(seq, ShouldEqual, 5)
+
+			// The tx billings of the detached blocks should be unpacked
+			h.deliver(ca, b3, b4)
+			So(*ca.st.getHeader(), ShouldEqual, b4.SignedHeader.BlockHash)
+			v := ca.ti.getTxBilling(tb1.TxHash)
+			So(v, ShouldNotBeNil)
+			So(v.IsSigned(), ShouldBeFalse)
+			_, err = ca.ti.lastSequenceID(&id)
+			So(err, ShouldEqual, ErrNoSuchTxBilling)
+			So(ca.checkTxBilling(newTestTxBilling(id, 1)), ShouldBeNil)
+
+			Convey("The branch with stale tx billings should be refused", func() {
+				var (
+					d5 = h.newBlockWithTxBillings(
+						b4.SignedHeader.BlockHash, 5, []*types.TxBilling{newTestTxBilling(id, 7)})
+					a6 = h.newBlockWithTxBillings(
+						a2.SignedHeader.BlockHash, 6, []*types.TxBilling{newTestTxBilling(id, 5)})
+					a7 = h.newBlock(a6.SignedHeader.BlockHash, 7)
+					a8 = h.newBlock(a7.SignedHeader.BlockHash, 8)
+				)
+				h.deliver(ca, d5)
+				var errs []error
+				for _, b := range []*types.Block{a6, a7, a8} {
+					if err = ca.pushBlock(b); err != nil {
+						errs = append(errs, err)
+					}
+				}
+				So(errs, ShouldResemble, []error{ErrSmallerSequenceID})
+				So(*ca.st.getHeader(), ShouldEqual, d5.SignedHeader.BlockHash)
+				seq, err = ca.ti.lastSequenceID(&id)
+				So(err, ShouldBeNil)
+				So(seq, ShouldEqual, 7)
+				So(ca.ti.getTxBilling(tb1.TxHash).IsSigned(), ShouldBeFalse)
+			})
+		})
+		Convey("The chains should choose the same branch of the same length", func() {
+			var (
+				a2 = h.newBlock(b1.SignedHeader.BlockHash, 2,
+					newTestTransfer(testAddress1, testAddress2, 2, 100))
+				b3 = h.newBlock(b1.SignedHeader.BlockHash, 3,
+					newTestTransfer(testAddress1, testAddress2, 2, 20))
+			)
+			h.deliver(ca, a2)
+			h.deliver(cb, b3)
+			h.deliver(ca, b3)
+			h.deliver(cb, a2)
+			for _, c := range h.chains {
+				So(*c.st.getHeader(), ShouldEqual, a2.SignedHeader.BlockHash)
+				So(committedBalance(c, testAddress1), ShouldEqual, testInitBalance-110)
+				So(committedBalance(c, testAddress2), ShouldEqual, testInitBalance+110)
+			}
+		})
+		Convey("The block with invalid state root should be refused", func() {
+			b := h.newBlock(b1.SignedHeader.BlockHash, 2,
+				newTestTransfer(testAddress1, testAddress2, 2, 10))
+			b.SignedHeader.StateRoot = hash.Hash{0x1}
+			So(b.PackAndSignBlock(testPrivKey), ShouldBeNil)
+			b.Commits = []*types.Vote{signTestVote(
+				testPrivKey, types.VoteTypePrecommit, 2, b.SignedHeader.BlockHash, h.peers.Servers[0].ID)}
+			So(ca.pushBlock(b), ShouldEqual, ErrInvalidStateRoot)
+			So(ca.bi.hasBlock(b.SignedHeader.BlockHash), ShouldBeFalse)
+			So(committedBalance(ca, testAddress1), ShouldEqual, testInitBalance-10)
+		})
+		Convey("The committed state should be proved with the head block", func() {
+			var producers = map[proto.NodeID]*asymmetric.PublicKey{
+				h.peers.Servers[0].ID: testPubKey,
+			}
+			account, p, err := ca.proveAccount(testAddress1)
+			So(err, ShouldBeNil)
+			So(account.StableCoinBalance, ShouldEqual, testInitBalance-10)
+			So(p.Header.BlockHash, ShouldEqual, b1.SignedHeader.BlockHash)
+			So(p.VerifyAccount(producers, testAddress1, account), ShouldBeNil)
+			account.StableCoinBalance++
+			So(p.VerifyAccount(producers, testAddress1, account),
+				ShouldEqual, types.ErrInvalidStateProof)
+
+			profile, p, err := ca.proveSQLChainProfile(proto.DatabaseID("db"))
+			So(err, ShouldBeNil)
+			So(profile, ShouldBeNil)
+			So(p.VerifyDatabase(producers, proto.DatabaseID("db"), nil), ShouldBeNil)
+			So(p.VerifyDatabase(producers, proto.DatabaseID("db"), &types.SQLChainProfile{
+				ID: proto.DatabaseID("db"),
+			}), ShouldEqual, types.ErrInvalidStateProof)
+
+			// should not be proved by unknown producers
+			priv, _, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			producers[h.peers.Servers[0].ID] = priv.PubKey()
+			So(p.VerifyDatabase(producers, proto.DatabaseID("db"), nil),
+				ShouldEqual, types.ErrUnknownProducer)
+		})
+		Convey("The block with unknown parent should be refused", func() {
+			b := h.newBlock(hash.Hash{0x1}, 2)
+			So(ca.pushBlock(b), ShouldEqual, ErrParentNotFound)
+			So(ca.bi.hasBlock(b.SignedHeader.BlockHash), ShouldBeFalse)
+		})
+	})
+}
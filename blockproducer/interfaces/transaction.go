@@ -39,6 +39,36 @@ func (t TransactionType) Bytes() (b []byte) {
 	return
 }
 
+// String returns the name of the TransactionType.
+func (t TransactionType) String() string {
+	switch t {
+	case TransactionTypeBilling:
+		return "Billing"
+	case TransactionTypeTransfer:
+		return "Transfer"
+	case TransactionTypeCreateAccount:
+		return "CreateAccount"
+	case TransactionTypeDeleteAccount:
+		return "DeleteAccount"
+	case TransactionTypeAddDatabaseUser:
+		return "AddDatabaseUser"
+	case TransactionTypeAlterDatabaseUser:
+		return "AlterDatabaseUser"
+	case TransactionTypeDeleteDatabaseUser:
+		return "DeleteDatabaseUser"
+	case TransactionTypeBaseAccount:
+		return "BaseAccount"
+	case TransactionTypeCreateDatabase:
+		return "CreateDatabase"
+	case TransactionTypeDropDatabase:
+		return "DropDatabase"
+	case TransactionTypeTopUp:
+		return "TopUp"
+	default:
+		return "Unknown"
+	}
+}
+
 // FromBytes decodes a TransactionType from a byte slice.
 func FromBytes(b []byte) TransactionType {
 	return TransactionType(binary.BigEndian.Uint32(b))
@@ -61,6 +91,12 @@ const (
 	TransactionTypeDeleteDatabaseUser
 	// TransactionTypeBaseAccount defines base account.
 	TransactionTypeBaseAccount
+	// TransactionTypeCreateDatabase defines database creation transaction type.
+	TransactionTypeCreateDatabase
+	// TransactionTypeDropDatabase defines database deletion transaction type.
+	TransactionTypeDropDatabase
+	// TransactionTypeTopUp defines database top-up transaction type.
+	TransactionTypeTopUp
 	// TransactionTypeNumber defines transaction types number.
 	TransactionTypeNumber
 )
@@ -83,8 +119,9 @@ type Transaction interface {
 	Deserializer
 	GetAccountAddress() proto.AccountAddress
 	GetAccountNonce() AccountNonce
+	GetFee() uint64
 	GetHash() hash.Hash
 	GetTransactionType() TransactionType
-	Sign(signer *asymmetric.PrivateKey) error
+	Sign(signer asymmetric.Signer) error
 	Verify() error
 }
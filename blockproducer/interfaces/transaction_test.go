@@ -28,6 +28,12 @@ func TestTypes(t *testing.T) {
 			So(tt, ShouldEqual, FromBytes(tt.Bytes()))
 		}
 	})
+	Convey("Transaction types should have names", t, func() {
+		for tt := TransactionType(0); tt < TransactionTypeNumber; tt++ {
+			So(tt.String(), ShouldNotEqual, "Unknown")
+		}
+		So(TransactionTypeNumber.String(), ShouldEqual, "Unknown")
+	})
 	Convey("Transaction types should be hash stable", t, func() {
 		var (
 			h1, h2 []byte
@@ -21,6 +21,7 @@ import (
 	"sync"
 
 	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/merkle"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/coreos/bbolt"
@@ -55,6 +56,27 @@ type sqlchainObject struct {
 	pt.SQLChainProfile
 }
 
+// undoAccount records the committed account object of address, or nil if the account does not
+// exist.
+type undoAccount struct {
+	Address proto.AccountAddress
+	Account *pt.Account
+}
+
+// undoDatabase records the committed sqlchain profile of id, or nil if the database does not
+// exist.
+type undoDatabase struct {
+	ID      proto.DatabaseID
+	Profile *pt.SQLChainProfile
+}
+
+// metaUndo defines the undo log of a block, which records the committed objects overwritten by
+// the block.
+type metaUndo struct {
+	Accounts  []*undoAccount
+	Databases []*undoDatabase
+}
+
 type metaIndex struct {
 	sync.RWMutex
 	accounts  map[proto.AccountAddress]*accountObject
@@ -107,6 +129,43 @@ func (i *metaIndex) deepCopy() (cpy *metaIndex) {
 	return
 }
 
+// stateTrie builds the state trie of the index, the root of which is committed by the block
+// header.
+func (i *metaIndex) stateTrie() (trie *merkle.Trie, err error) {
+	trie = merkle.NewPatricia()
+	if err = i.updateTrie(trie); err != nil {
+		trie = nil
+	}
+	return
+}
+
+// updateTrie applies the objects of the index to the state trie, in which a nil object deletes
+// the key.
+func (i *metaIndex) updateTrie(trie *merkle.Trie) (err error) {
+	var enc []byte
+	for k, v := range i.accounts {
+		if v == nil {
+			trie.Delete(pt.AccountStateKey(k))
+			continue
+		}
+		if enc, err = v.Account.MarshalHash(); err != nil {
+			return
+		}
+		trie.Update(pt.AccountStateKey(k), enc)
+	}
+	for k, v := range i.databases {
+		if v == nil {
+			trie.Delete(pt.DatabaseStateKey(k))
+			continue
+		}
+		if enc, err = v.SQLChainProfile.MarshalHash(); err != nil {
+			return
+		}
+		trie.Update(pt.DatabaseStateKey(k), enc)
+	}
+	return
+}
+
 // IncreaseAccountStableBalance increases account stable coin balance and write persistence within
 // a boltdb transaction.
 func (i *metaIndex) IncreaseAccountStableBalance(
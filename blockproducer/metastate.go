@@ -18,10 +18,16 @@ package blockproducer
 
 import (
 	"bytes"
+	"encoding/binary"
+	"math/big"
+	"sort"
 	"sync"
+	"time"
 
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/merkle"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -34,17 +40,34 @@ import (
 type metaState struct {
 	sync.RWMutex
 	dirty, readonly *metaIndex
-	pool            *txPool
+	// trie is the state trie of the readonly objects, which is updated along with them. It is nil
+	// if it has to be rebuilt from the readonly objects.
+	trie *merkle.Trie
+	pool *txPool
+	// producers are the block producer accounts, which may issue database transactions on behalf
+	// of the database owners.
+	producers map[proto.AccountAddress]bool
 }
 
 func newMetaState() *metaState {
 	return &metaState{
 		dirty:    newMetaIndex(),
 		readonly: newMetaIndex(),
+		trie:     merkle.NewPatricia(),
 		pool:     newTxPool(),
 	}
 }
 
+// setProducers sets the block producer accounts.
+func (s *metaState) setProducers(producers []proto.AccountAddress) {
+	s.Lock()
+	defer s.Unlock()
+	s.producers = make(map[proto.AccountAddress]bool, len(producers))
+	for _, v := range producers {
+		s.producers[v] = true
+	}
+}
+
 func (s *metaState) loadAccountObject(k proto.AccountAddress) (o *accountObject, loaded bool) {
 	s.RLock()
 	defer s.RUnlock()
@@ -155,6 +178,130 @@ func (s *metaState) loadOrStoreSQLChainObject(
 	return
 }
 
+// loadDatabaseFunds returns the deposit and arrears of the database, and the stable coin balance
+// of its owner.
+func (s *metaState) loadDatabaseFunds(k proto.DatabaseID) (deposit, arrears, balance uint64, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	var (
+		o     *sqlchainObject
+		owner *accountObject
+		ok    bool
+	)
+	if o, ok = s.dirty.databases[k]; !ok {
+		o = s.readonly.databases[k]
+	}
+	if o == nil {
+		err = ErrDatabaseNotFound
+		return
+	}
+	if owner, ok = s.dirty.accounts[o.Owner]; !ok {
+		owner = s.readonly.accounts[o.Owner]
+	}
+	if owner != nil {
+		balance = owner.StableCoinBalance
+	}
+	deposit, arrears = o.Deposit, o.Arrears
+	return
+}
+
+// loadSQLChainProfile returns a copy of the profile of database k, including its users and miners.
+func (s *metaState) loadSQLChainProfile(k proto.DatabaseID) (p *pt.SQLChainProfile, loaded bool) {
+	s.RLock()
+	defer s.RUnlock()
+	var o *sqlchainObject
+	if o, loaded = s.dirty.databases[k]; !loaded {
+		o, loaded = s.readonly.databases[k]
+	}
+	if o == nil {
+		loaded = false
+		return
+	}
+	o.RLock()
+	defer o.RUnlock()
+	p = &pt.SQLChainProfile{
+		ID:      o.ID,
+		Owner:   o.Owner,
+		Deposit: o.Deposit,
+		Arrears: o.Arrears,
+		Miners:  append([]proto.AccountAddress(nil), o.Miners...),
+		Users:   make([]*pt.SQLChainUser, 0, len(o.Users)),
+
+		NextBillingHeight: o.NextBillingHeight,
+	}
+	for _, v := range o.Users {
+		if v != nil {
+			u := *v
+			p.Users = append(p.Users, &u)
+		}
+	}
+	return
+}
+
+// loadDatabasesOfAccount returns the sorted IDs of the databases owned by addr.
+func (s *metaState) loadDatabasesOfAccount(addr proto.AccountAddress) (ids []proto.DatabaseID) {
+	s.RLock()
+	defer s.RUnlock()
+	for k, v := range s.dirty.databases {
+		if v != nil && v.Owner == addr {
+			ids = append(ids, k)
+		}
+	}
+	for k, v := range s.readonly.databases {
+		if _, ok := s.dirty.databases[k]; !ok && v.Owner == addr {
+			ids = append(ids, k)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return
+}
+
+// involvedAccounts returns the accounts involved in transaction t. It should be called before t
+// is applied, since t may delete the objects referred by it.
+func (s *metaState) involvedAccounts(t pi.Transaction) (addrs []proto.AccountAddress) {
+	addrs = append(addrs, t.GetAccountAddress())
+	switch tx := t.(type) {
+	case *pt.Transfer:
+		addrs = append(addrs, tx.Receiver)
+	case *pt.TxBilling:
+		if o, loaded := s.loadSQLChainObject(*tx.GetDatabaseID()); loaded {
+			addrs = append(addrs, o.Owner)
+		}
+		for _, v := range tx.TxContent.Receivers {
+			if v != nil {
+				addrs = append(addrs, *v)
+			}
+		}
+	case *pt.CreateDatabase:
+		addrs = append(addrs, tx.Owner)
+	case *pt.DropDatabase:
+		// The arrears are settled to the miners on drop
+		if o, loaded := s.loadSQLChainObject(tx.DatabaseID); loaded {
+			addrs = append(addrs, o.Owner)
+			if o.Arrears > 0 {
+				addrs = append(addrs, o.Miners...)
+			}
+		}
+	case *pt.TopUp:
+		// The arrears are settled to the miners on top-up
+		if o, loaded := s.loadSQLChainObject(tx.DatabaseID); loaded && o.Arrears > 0 {
+			addrs = append(addrs, o.Miners...)
+		}
+	}
+	// Remove duplicates, keeping the order
+	var (
+		seen   = make(map[proto.AccountAddress]struct{}, len(addrs))
+		unique = addrs[:0]
+	)
+	for _, v := range addrs {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
 func (s *metaState) deleteAccountObject(k proto.AccountAddress) {
 	s.Lock()
 	defer s.Unlock()
@@ -214,16 +361,75 @@ func (s *metaState) commitProcedure() (_ func(*bolt.Tx) error) {
 				}
 			}
 		}
+		s.updateTrie(s.dirty)
 		// Clean dirty map and tx pool
 		s.dirty = newMetaIndex()
-		s.pool = newTxPool()
+		s.pool = s.pool.cleanCopy()
+		return
+	}
+}
+
+// commitDirty merges the dirty objects into the readonly ones in memory.
+func (s *metaState) commitDirty() {
+	s.Lock()
+	defer s.Unlock()
+	for k, v := range s.dirty.accounts {
+		if v != nil {
+			s.readonly.accounts[k] = v
+		} else {
+			delete(s.readonly.accounts, k)
+		}
+	}
+	for k, v := range s.dirty.databases {
+		if v != nil {
+			s.readonly.databases[k] = v
+		} else {
+			delete(s.readonly.databases, k)
+		}
+	}
+	s.updateTrie(s.dirty)
+	s.dirty = newMetaIndex()
+}
+
+// committedTrie returns the state trie of the readonly objects, which is rebuilt if it is not
+// maintained. The returned trie should be copied before any update, and the caller should hold
+// the lock.
+func (s *metaState) committedTrie() (trie *merkle.Trie, err error) {
+	if s.trie != nil {
+		return s.trie, nil
+	}
+	return s.readonly.stateTrie()
+}
+
+// updateTrie applies the objects of dirty to the state trie of the readonly objects, the caller
+// should hold the lock. The trie is left to be rebuilt on error.
+func (s *metaState) updateTrie(dirty *metaIndex) {
+	var trie, err = s.committedTrie()
+	if err == nil {
+		trie = trie.Copy()
+		err = dirty.updateTrie(trie)
+	}
+	if err != nil {
+		log.WithError(err).Warning("failed to update state trie, rebuild it on demand")
+		s.trie = nil
 		return
 	}
+	s.trie = trie
 }
 
 // partialCommitProcedure compares txs with pooled items, replays and commits the state due to txs
 // if txs matches part of or all the pooled items. Not committed txs will be left in the pool.
 func (s *metaState) partialCommitProcedure(txs []pi.Transaction) (_ func(*bolt.Tx) error) {
+	return s.partialCommitWithUndoProcedure(txs, nil, nil)
+}
+
+// partialCommitWithUndoProcedure works as partialCommitProcedure, and also stores the committed
+// objects which are overwritten as an undo log with undoKey if it is not nil. The undo log can be
+// used by rollbackProcedure to revert the commit. If root is not nil, the commit fails unless the
+// new state matches the state root.
+func (s *metaState) partialCommitWithUndoProcedure(
+	txs []pi.Transaction, undoKey []byte, root *hash.Hash) (_ func(*bolt.Tx) error,
+) {
 	return func(tx *bolt.Tx) (err error) {
 		var (
 			enc *bytes.Buffer
@@ -241,8 +447,9 @@ func (s *metaState) partialCommitProcedure(txs []pi.Transaction) (_ func(*bolt.T
 		var (
 			cp = s.pool.halfDeepCopy()
 			cm = &metaState{
-				dirty:    newMetaIndex(),
-				readonly: s.readonly.deepCopy(),
+				dirty:     newMetaIndex(),
+				readonly:  s.readonly.deepCopy(),
+				producers: s.producers,
 			}
 		)
 		// Compare and replay commits, stop whenever a tx has mismatched
@@ -251,11 +458,16 @@ func (s *metaState) partialCommitProcedure(txs []pi.Transaction) (_ func(*bolt.T
 				err = ErrTransactionMismatch
 				return
 			}
-			if err = cm.applyTransaction(v); err != nil {
+			if err = cm.replayTransaction(v); err != nil {
 				return
 			}
 		}
 
+		if undoKey != nil {
+			if err = cm.storeUndo(tx, undoKey); err != nil {
+				return
+			}
+		}
 		for k, v := range cm.dirty.accounts {
 			if v != nil {
 				// New/update object
@@ -292,25 +504,211 @@ func (s *metaState) partialCommitProcedure(txs []pi.Transaction) (_ func(*bolt.T
 				}
 			}
 		}
+		var trie *merkle.Trie
+		if trie, err = s.committedTrie(); err != nil {
+			return
+		}
+		trie = trie.Copy()
+		if err = cm.dirty.updateTrie(trie); err != nil {
+			return
+		}
+		if r := trie.Root(); root != nil && !r.IsEqual(root) {
+			err = ErrInvalidStateRoot
+			return
+		}
 
-		// Rebuild dirty map
-		cm.dirty = newMetaIndex()
-		for _, v := range cp.entries {
-			for _, tx := range v.transacions {
-				if err = cm.applyTransaction(tx); err != nil {
-					return
-				}
-			}
+		// Rebuild dirty map, the pooled transactions which cannot be applied upon the new state
+		// any more are dropped
+		var replayed, dropped []pi.Transaction
+		cm.dirty, replayed, dropped = replayPool(cm.readonly, cm.producers, cp)
+		cp.retain(replayed)
+		for _, v := range dropped {
+			var addr = v.GetAccountAddress()
+			log.WithFields(log.Fields{
+				"account":     addr.String(),
+				"nonce":       v.GetAccountNonce(),
+				"transaction": v.GetHash().String(),
+			}).Debug("dropped pooled transaction after commit")
 		}
 
 		// Clean dirty map and tx pool
 		s.pool = cp
 		s.readonly = cm.readonly
+		s.trie = trie
 		s.dirty = cm.dirty
 		return
 	}
 }
 
+// stateRootAfter returns the state root after applying txs upon the committed state, the state is
+// left unchanged.
+func (s *metaState) stateRootAfter(txs []pi.Transaction) (root hash.Hash, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	// The readonly objects are shared but never modified by the replay, which only writes to the
+	// dirty map
+	var cm = &metaState{
+		dirty:     newMetaIndex(),
+		readonly:  s.readonly,
+		producers: s.producers,
+	}
+	for _, v := range txs {
+		if err = cm.replayTransaction(v); err != nil {
+			return
+		}
+	}
+	var trie *merkle.Trie
+	if trie, err = s.committedTrie(); err != nil {
+		return
+	}
+	trie = trie.Copy()
+	if err = cm.dirty.updateTrie(trie); err != nil {
+		return
+	}
+	root = trie.Root()
+	return
+}
+
+// proveAccount returns the committed account of addr, or nil if it does not exist, with its merkle
+// proof and the state root.
+func (s *metaState) proveAccount(
+	addr proto.AccountAddress) (account *pt.Account, proof *merkle.Proof, root hash.Hash, err error,
+) {
+	s.RLock()
+	defer s.RUnlock()
+	var trie *merkle.Trie
+	if trie, err = s.committedTrie(); err != nil {
+		return
+	}
+	if o, ok := s.readonly.accounts[addr]; ok {
+		var cpy = o.Account
+		account = &cpy
+	}
+	proof = trie.Prove(pt.AccountStateKey(addr))
+	root = trie.Root()
+	return
+}
+
+// proveSQLChainProfile returns the committed profile of id, or nil if it does not exist, with its
+// merkle proof and the state root.
+func (s *metaState) proveSQLChainProfile(id proto.DatabaseID) (
+	profile *pt.SQLChainProfile, proof *merkle.Proof, root hash.Hash, err error,
+) {
+	s.RLock()
+	defer s.RUnlock()
+	var trie *merkle.Trie
+	if trie, err = s.committedTrie(); err != nil {
+		return
+	}
+	if o, ok := s.readonly.databases[id]; ok {
+		profile = &pt.SQLChainProfile{}
+		deepcopier.Copy(&o.SQLChainProfile).To(profile)
+	}
+	proof = trie.Prove(pt.DatabaseStateKey(id))
+	root = trie.Root()
+	return
+}
+
+// storeUndo stores the readonly objects to be overwritten by the dirty ones as an undo log with
+// key, the caller should hold the lock.
+func (s *metaState) storeUndo(tx *bolt.Tx, key []byte) (err error) {
+	var (
+		enc  *bytes.Buffer
+		undo = &metaUndo{}
+	)
+	for k := range s.dirty.accounts {
+		u := &undoAccount{Address: k}
+		if o, ok := s.readonly.accounts[k]; ok {
+			u.Account = &pt.Account{}
+			*u.Account = o.Account
+		}
+		undo.Accounts = append(undo.Accounts, u)
+	}
+	for k := range s.dirty.databases {
+		u := &undoDatabase{ID: k}
+		if o, ok := s.readonly.databases[k]; ok {
+			u.Profile = &pt.SQLChainProfile{}
+			deepcopier.Copy(&o.SQLChainProfile).To(u.Profile)
+		}
+		undo.Databases = append(undo.Databases, u)
+	}
+	if enc, err = utils.EncodeMsgPack(undo); err != nil {
+		return
+	}
+	return tx.Bucket(metaBucket[:]).Bucket(metaUndoBucket).Put(key, enc.Bytes())
+}
+
+// rollbackProcedure reverts the commit of undoKey by restoring the objects in its undo log, and
+// then removes the undo log. The dirty map and tx pool are cleaned, so any pooled transactions
+// should be applied again by the caller. The gapped transactions are kept in the pool.
+func (s *metaState) rollbackProcedure(undoKey []byte) (_ func(*bolt.Tx) error) {
+	return func(tx *bolt.Tx) (err error) {
+		var (
+			enc  *bytes.Buffer
+			ub   = tx.Bucket(metaBucket[:]).Bucket(metaUndoBucket)
+			ab   = tx.Bucket(metaBucket[:]).Bucket(metaAccountIndexBucket)
+			cb   = tx.Bucket(metaBucket[:]).Bucket(metaSQLChainIndexBucket)
+			undo = &metaUndo{}
+			v    = ub.Get(undoKey)
+			// restored records the restored objects to update the state trie
+			restored = newMetaIndex()
+		)
+		if v == nil {
+			err = ErrMissingUndoLog
+			return
+		}
+		if err = utils.DecodeMsgPack(v, undo); err != nil {
+			return
+		}
+		s.Lock()
+		defer s.Unlock()
+		for _, u := range undo.Accounts {
+			if u.Account == nil {
+				delete(s.readonly.accounts, u.Address)
+				restored.accounts[u.Address] = nil
+				if err = ab.Delete(u.Address[:]); err != nil {
+					return
+				}
+				continue
+			}
+			s.readonly.accounts[u.Address] = &accountObject{Account: *u.Account}
+			restored.accounts[u.Address] = s.readonly.accounts[u.Address]
+			if enc, err = utils.EncodeMsgPack(u.Account); err != nil {
+				return
+			}
+			if err = ab.Put(u.Address[:], enc.Bytes()); err != nil {
+				return
+			}
+		}
+		for _, u := range undo.Databases {
+			if u.Profile == nil {
+				delete(s.readonly.databases, u.ID)
+				restored.databases[u.ID] = nil
+				if err = cb.Delete([]byte(u.ID)); err != nil {
+					return
+				}
+				continue
+			}
+			s.readonly.databases[u.ID] = &sqlchainObject{SQLChainProfile: *u.Profile}
+			restored.databases[u.ID] = s.readonly.databases[u.ID]
+			if enc, err = utils.EncodeMsgPack(u.Profile); err != nil {
+				return
+			}
+			if err = cb.Put([]byte(u.ID), enc.Bytes()); err != nil {
+				return
+			}
+		}
+		if err = ub.Delete(undoKey); err != nil {
+			return
+		}
+		s.updateTrie(restored)
+		// Clean dirty map and tx pool
+		s.dirty = newMetaIndex()
+		s.pool = s.pool.cleanCopy()
+		return
+	}
+}
+
 func (s *metaState) reloadProcedure() (_ func(*bolt.Tx) error) {
 	return func(tx *bolt.Tx) (err error) {
 		s.Lock()
@@ -318,6 +716,8 @@ func (s *metaState) reloadProcedure() (_ func(*bolt.Tx) error) {
 		// Clean state
 		s.dirty = newMetaIndex()
 		s.readonly = newMetaIndex()
+		s.trie = nil
+		s.pool = s.pool.cleanCopy()
 		// Reload state
 		var (
 			ab = tx.Bucket(metaBucket[:]).Bucket(metaAccountIndexBucket)
@@ -343,6 +743,7 @@ func (s *metaState) reloadProcedure() (_ func(*bolt.Tx) error) {
 		}); err != nil {
 			return
 		}
+		s.trie, err = s.readonly.stateTrie()
 		return
 	}
 }
@@ -625,6 +1026,25 @@ func (s *metaState) nextNonce(addr proto.AccountAddress) (nonce pi.AccountNonce,
 	return
 }
 
+// nextDepositNonce returns the nonce of the next deposit authorization signed by addr.
+func (s *metaState) nextDepositNonce(addr proto.AccountAddress) (nonce pi.AccountNonce, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	var (
+		o      *accountObject
+		loaded bool
+	)
+	if o, loaded = s.dirty.accounts[addr]; !loaded {
+		o = s.readonly.accounts[addr]
+	}
+	if o == nil {
+		err = ErrAccountNotFound
+		return
+	}
+	nonce = o.NextDepositNonce
+	return
+}
+
 func (s *metaState) increaseNonce(addr proto.AccountAddress) (err error) {
 	s.Lock()
 	defer s.Unlock()
@@ -644,12 +1064,424 @@ func (s *metaState) increaseNonce(addr proto.AccountAddress) (err error) {
 	return
 }
 
+// dirtyAccountObject returns the dirty copy of account object, the caller should hold the lock.
+func (s *metaState) dirtyAccountObject(k proto.AccountAddress) (o *accountObject, err error) {
+	var (
+		src *accountObject
+		ok  bool
+	)
+	if o, ok = s.dirty.accounts[k]; ok {
+		if o == nil {
+			err = ErrAccountNotFound
+		}
+		return
+	}
+	if src, ok = s.readonly.accounts[k]; !ok {
+		err = ErrAccountNotFound
+		return
+	}
+	o = &accountObject{}
+	deepcopier.Copy(&src.Account).To(&o.Account)
+	s.dirty.accounts[k] = o
+	return
+}
+
+// dirtySQLChainObject returns the dirty copy of sqlchain object, the caller should hold the lock.
+func (s *metaState) dirtySQLChainObject(k proto.DatabaseID) (o *sqlchainObject, err error) {
+	var (
+		src *sqlchainObject
+		ok  bool
+	)
+	if o, ok = s.dirty.databases[k]; ok {
+		if o == nil {
+			err = ErrDatabaseNotFound
+		}
+		return
+	}
+	if src, ok = s.readonly.databases[k]; !ok {
+		err = ErrDatabaseNotFound
+		return
+	}
+	o = &sqlchainObject{}
+	deepcopier.Copy(&src.SQLChainProfile).To(&o.SQLChainProfile)
+	s.dirty.databases[k] = o
+	return
+}
+
+// applyBilling settles the billing of a sqlchain: the fees are charged from the database deposit
+// and then the owner's stable coin balance, and paid to the receivers. If the payer runs short,
+// the collected amount is shared by receivers in proportion to their fees and the shortage is
+// recorded as arrears of the database. Block rewards are minted as covenant coin.
+//
+// A billing is accepted only if it is signed and issued by a block producer, or if its request is
+// signed by all the miners of the database, and its receivers must be exactly the database miners.
+//
+// The gas amounts of the request are accounted per miner, not per user: the owner funds the
+// database with its deposit and grants the users access, so the owner pays for all of them.
 func (s *metaState) applyBilling(tx *pt.TxBilling) (err error) {
-	for i, v := range tx.TxContent.Receivers {
-		if err = s.increaseAccountCovenantBalance(*v, tx.TxContent.Fees[i]); err != nil {
+	var (
+		content = &tx.TxContent
+		total   uint64
+	)
+	if len(content.Fees) != len(content.Receivers) || len(content.Rewards) != len(content.Receivers) {
+		return ErrInvalidBillingRequest
+	}
+	for i := range content.Fees {
+		if err = safeAdd(&total, &content.Fees[i]); err != nil {
+			return
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var (
+		db        *sqlchainObject
+		payer     *accountObject
+		available uint64
+	)
+	if db, err = s.dirtySQLChainObject(*tx.GetDatabaseID()); err != nil {
+		return
+	}
+	if !s.isIssuedByProducer(tx) && !isSignedByMiners(&content.BillingRequest, db.Miners) {
+		return ErrInvalidBillingIssuer
+	}
+	if !isMinerList(content.Receivers, db.Miners) {
+		return ErrInvalidBillingRequest
+	}
+	available = db.Deposit
+	if payer, err = s.dirtyAccountObject(db.Owner); err == nil {
+		if err = safeAdd(&available, &payer.StableCoinBalance); err != nil {
+			return
+		}
+	} else if err != ErrAccountNotFound {
+		return
+	}
+	err = nil
+
+	// Compute payments and check receivers before any balance manipulation
+	var (
+		receivers = make([]*accountObject, len(content.Receivers))
+		payments  = make([]uint64, len(content.Receivers))
+		charged   uint64
+	)
+	for i, v := range content.Receivers {
+		if receivers[i], err = s.dirtyAccountObject(*v); err != nil {
+			return
+		}
+		payments[i] = content.Fees[i]
+		if available < total {
+			payments[i] = prorate(content.Fees[i], available, total)
+		}
+		charged += payments[i]
+	}
+
+	// Charge payer: deposit first, then stable coin balance
+	var (
+		fromDeposit = charged
+		fromBalance uint64
+		shortage    = total - charged
+	)
+	if fromDeposit > db.Deposit {
+		fromDeposit = db.Deposit
+		fromBalance = charged - fromDeposit
+	}
+	if err = safeSub(&db.Deposit, &fromDeposit); err != nil {
+		return
+	}
+	if fromBalance > 0 {
+		if err = safeSub(&payer.StableCoinBalance, &fromBalance); err != nil {
+			return
+		}
+	}
+	if shortage > 0 {
+		if err = safeAdd(&db.Arrears, &shortage); err != nil {
+			return
+		}
+		log.WithFields(log.Fields{
+			"database": db.ID,
+			"charged":  charged,
+			"shortage": shortage,
+			"arrears":  db.Arrears,
+		}).Warning("database payer runs short on billing settlement")
+	}
+
+	// Pay receivers
+	for i, r := range receivers {
+		if err = safeAdd(&r.StableCoinBalance, &payments[i]); err != nil {
+			return
+		}
+		if err = safeAdd(&r.CovenantCoinBalance, &content.Rewards[i]); err != nil {
+			return
+		}
+	}
+
+	// Advance the billing cursor, so that the final settlement knows where to start
+	if next := content.BillingRequest.Header.HighHeight + 1; next > db.NextBillingHeight {
+		db.NextBillingHeight = next
+	}
+	return
+}
+
+// isIssuedByProducer reports whether tx is signed by the block producer which issues it.
+func (s *metaState) isIssuedByProducer(tx *pt.TxBilling) bool {
+	if tx.Signee == nil || tx.AccountAddress == nil {
+		return false
+	}
+	addr, err := utils.PubKeyHash(tx.Signee)
+	return err == nil && addr == *tx.AccountAddress && s.producers[addr]
+}
+
+// isSignedByMiners reports whether the billing request br is signed by every miner of miners.
+func isSignedByMiners(br *pt.BillingRequest, miners []proto.AccountAddress) bool {
+	if len(miners) == 0 || len(br.Signees) != len(br.Signatures) {
+		return false
+	}
+	h, err := br.PackRequestHeader()
+	if err != nil || !h.IsEqual(&br.RequestHash) {
+		return false
+	}
+	var signed = make(map[proto.AccountAddress]bool, len(br.Signees))
+	for i, v := range br.Signees {
+		if v == nil || br.Signatures[i] == nil || !br.Signatures[i].Verify(h[:], v) {
+			return false
+		}
+		var addr proto.AccountAddress
+		if addr, err = utils.PubKeyHash(v); err != nil {
+			return false
+		}
+		signed[addr] = true
+	}
+	for _, v := range miners {
+		if !signed[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// isMinerList reports whether receivers lists each miner of miners exactly once.
+func isMinerList(receivers []*proto.AccountAddress, miners []proto.AccountAddress) bool {
+	if len(receivers) != len(miners) {
+		return false
+	}
+	var listed = make(map[proto.AccountAddress]bool, len(receivers))
+	for _, v := range receivers {
+		if v == nil || listed[*v] {
+			return false
+		}
+		listed[*v] = true
+	}
+	for _, v := range miners {
+		if !listed[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// prorate returns floor(amount * part / total) without overflow.
+func prorate(amount, part, total uint64) uint64 {
+	var r big.Int
+	r.SetUint64(amount)
+	r.Mul(&r, new(big.Int).SetUint64(part))
+	r.Div(&r, new(big.Int).SetUint64(total))
+	return r.Uint64()
+}
+
+// applyCreateDatabase creates the database profile and locks the deposit from the owner's stable
+// coin balance into it.
+func (s *metaState) applyCreateDatabase(tx *pt.CreateDatabase) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	var (
+		id    = tx.DatabaseID
+		owner *accountObject
+	)
+	if tx.Issuer != tx.Owner && !s.producers[tx.Issuer] {
+		return ErrInvalidIssuer
+	}
+	if o, ok := s.dirty.databases[id]; ok {
+		if o != nil {
+			return ErrDatabaseExists
+		}
+	} else if _, ok := s.readonly.databases[id]; ok {
+		return ErrDatabaseExists
+	}
+	if tx.Authorization.Owner != tx.Owner || tx.Deposit > tx.Authorization.MaxDeposit {
+		return ErrInvalidDepositAuthorization
+	}
+	if owner, err = s.dirtyAccountObject(tx.Owner); err != nil {
+		return
+	}
+	if tx.Authorization.Nonce != owner.NextDepositNonce {
+		return ErrInvalidDepositAuthorization
+	}
+	if err = safeSub(&owner.StableCoinBalance, &tx.Deposit); err != nil {
+		return
+	}
+	owner.NextDepositNonce++
+	s.dirty.databases[id] = &sqlchainObject{
+		SQLChainProfile: pt.SQLChainProfile{
+			ID:      id,
+			Deposit: tx.Deposit,
+			Owner:   tx.Owner,
+			Miners:  append(make([]proto.AccountAddress, 0, len(tx.Miners)), tx.Miners...),
+			Users: []*pt.SQLChainUser{
+				{
+					Address:    tx.Owner,
+					Permission: pt.Admin,
+				},
+			},
+		},
+	}
+	return
+}
+
+// applyDropDatabase settles the arrears of the database from its deposit and its owner balance,
+// deletes the database profile and refunds the remaining deposit to its owner.
+func (s *metaState) applyDropDatabase(tx *pt.DropDatabase) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	var (
+		db    *sqlchainObject
+		owner *accountObject
+	)
+	if db, err = s.dirtySQLChainObject(tx.DatabaseID); err != nil {
+		return
+	}
+	if tx.Issuer != db.Owner && !s.producers[tx.Issuer] {
+		return ErrInvalidIssuer
+	}
+	if owner, err = s.dirtyAccountObject(db.Owner); err != nil {
+		return
+	}
+	// Settle the arrears from the deposit first, then from the owner balance
+	if db.Arrears > 0 && len(db.Miners) > 0 {
+		var (
+			miners      []*accountObject
+			settle      = db.Arrears
+			fromDeposit uint64
+			fromBalance uint64
+		)
+		if miners, err = s.dirtyMinerObjects(db); err != nil {
+			return
+		}
+		if db.Deposit+owner.StableCoinBalance < db.Deposit {
+			return ErrBalanceOverflow
+		}
+		if available := db.Deposit + owner.StableCoinBalance; settle > available {
+			settle = available
+		}
+		if fromDeposit = settle; fromDeposit > db.Deposit {
+			fromDeposit = db.Deposit
+		}
+		fromBalance = settle - fromDeposit
+		if err = safeSub(&db.Deposit, &fromDeposit); err != nil {
+			return
+		}
+		if err = safeSub(&owner.StableCoinBalance, &fromBalance); err != nil {
 			return
 		}
-		if err = s.increaseAccountStableBalance(*v, tx.TxContent.Rewards[i]); err != nil {
+		if err = settleArrears(db, miners, settle); err != nil {
+			return
+		}
+	}
+	if db.Arrears > 0 {
+		log.WithFields(log.Fields{
+			"database": db.ID,
+			"arrears":  db.Arrears,
+		}).Warning("database dropped with unsettled arrears")
+	}
+	// Refund the rest of the deposit
+	if err = safeAdd(&owner.StableCoinBalance, &db.Deposit); err != nil {
+		return
+	}
+	s.dirty.databases[tx.DatabaseID] = nil
+	return
+}
+
+// dirtyMinerObjects returns the dirty account objects of the serving miners of the database.
+func (s *metaState) dirtyMinerObjects(db *sqlchainObject) (miners []*accountObject, err error) {
+	miners = make([]*accountObject, len(db.Miners))
+	for i, v := range db.Miners {
+		if miners[i], err = s.dirtyAccountObject(v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// settleArrears writes off amount from the arrears of the database and pays it to the miners
+// evenly, the remainder goes to the first miner. The caller is responsible for collecting the
+// amount from its source.
+func settleArrears(db *sqlchainObject, miners []*accountObject, amount uint64) (err error) {
+	if amount == 0 || len(miners) == 0 {
+		return
+	}
+	if err = safeSub(&db.Arrears, &amount); err != nil {
+		return
+	}
+	var (
+		share     = amount / uint64(len(miners))
+		remainder = amount % uint64(len(miners))
+	)
+	for i, m := range miners {
+		var paid = share
+		if i == 0 {
+			paid += remainder
+		}
+		if err = safeAdd(&m.StableCoinBalance, &paid); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// applyTopUp transfers stable coin from sender to the database deposit, and settles the arrears
+// of the database by paying its miners evenly from the deposit.
+func (s *metaState) applyTopUp(tx *pt.TopUp) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	var (
+		sender *accountObject
+		db     *sqlchainObject
+		miners []*accountObject
+		settle uint64
+	)
+	if sender, err = s.dirtyAccountObject(tx.Sender); err != nil {
+		return
+	}
+	if db, err = s.dirtySQLChainObject(tx.DatabaseID); err != nil {
+		return
+	}
+	// Check before any balance manipulation
+	if sender.StableCoinBalance < tx.Amount {
+		return ErrInsufficientBalance
+	}
+	if db.Deposit+tx.Amount < db.Deposit {
+		return ErrBalanceOverflow
+	}
+	if db.Arrears > 0 && len(db.Miners) > 0 {
+		if settle = db.Deposit + tx.Amount; settle > db.Arrears {
+			settle = db.Arrears
+		}
+		if miners, err = s.dirtyMinerObjects(db); err != nil {
+			return
+		}
+	}
+
+	if err = safeSub(&sender.StableCoinBalance, &tx.Amount); err != nil {
+		return
+	}
+	if err = safeAdd(&db.Deposit, &tx.Amount); err != nil {
+		return
+	}
+	if settle > 0 {
+		if err = safeSub(&db.Deposit, &settle); err != nil {
+			return
+		}
+		if err = settleArrears(db, miners, settle); err != nil {
 			return
 		}
 	}
@@ -657,6 +1489,27 @@ func (s *metaState) applyBilling(tx *pt.TxBilling) (err error) {
 }
 
 func (s *metaState) applyTransaction(tx pi.Transaction) (err error) {
+	if tx == nil {
+		return ErrUnknownTransactionType
+	}
+	// Charge the fee first, it will be refunded if the transaction fails to apply, so that the
+	// metaState is left unchanged. The fee is burnt rather than paid to the block producer: the
+	// transaction is applied to the pool before its block and producer are known, and the state
+	// root of a block must not depend on who produces it.
+	var (
+		addr = tx.GetAccountAddress()
+		fee  = tx.GetFee()
+	)
+	if fee > 0 {
+		if err = s.decreaseAccountStableBalance(addr, fee); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				s.increaseAccountStableBalance(addr, fee)
+			}
+		}()
+	}
 	switch t := tx.(type) {
 	case *pt.Transfer:
 		err = s.transferAccountStableBalance(t.Sender, t.Receiver, t.Amount)
@@ -664,15 +1517,47 @@ func (s *metaState) applyTransaction(tx pi.Transaction) (err error) {
 		err = s.applyBilling(t)
 	case *pt.BaseAccount:
 		err = s.mustStoreAccountObject(t.Address, &accountObject{Account: t.Account})
+	case *pt.CreateDatabase:
+		err = s.applyCreateDatabase(t)
+	case *pt.DropDatabase:
+		err = s.applyDropDatabase(t)
+	case *pt.TopUp:
+		err = s.applyTopUp(t)
 	default:
 		err = ErrUnknownTransactionType
 	}
 	return
 }
 
+// replayTransaction applies tx and increases the nonce of its account, which is how
+// applyTransactionProcedure changes the state without the checks and pooling.
+func (s *metaState) replayTransaction(tx pi.Transaction) (err error) {
+	if err = s.applyTransaction(tx); err != nil {
+		return
+	}
+	return s.increaseNonce(tx.GetAccountAddress())
+}
+
 // applyTransaction tries to apply t to the metaState and push t to the memory pool if and
 // only if it can be applied correctly.
 func (s *metaState) applyTransactionProcedure(t pi.Transaction) (_ func(*bolt.Tx) error) {
+	return s.processTransactionProcedure(t, false, time.Time{})
+}
+
+// addTransactionProcedure works as applyTransactionProcedure for the transactions submitted by
+// clients, which are subject to the pool limits: a transaction whose nonce is ahead of the next
+// nonce of its account is kept as a gapped transaction until the gap is filled, or it goes stale
+// after the gap expiry or when its nonce is taken by another transaction. A transaction which
+// comes to a full pool evicts the pooled one paying the lowest fee if it pays a higher one.
+func (s *metaState) addTransactionProcedure(
+	t pi.Transaction, now time.Time) (_ func(*bolt.Tx) error,
+) {
+	return s.processTransactionProcedure(t, true, now)
+}
+
+func (s *metaState) processTransactionProcedure(
+	t pi.Transaction, limited bool, now time.Time) (_ func(*bolt.Tx) error,
+) {
 	var (
 		err     error
 		errPass = func(*bolt.Tx) error {
@@ -688,24 +1573,24 @@ func (s *metaState) applyTransactionProcedure(t pi.Transaction) (_ func(*bolt.Tx
 	}
 
 	var (
-		enc   []byte
-		hash  = t.GetHash()
 		addr  = t.GetAccountAddress()
 		nonce = t.GetAccountNonce()
-		ttype = t.GetTransactionType()
 	)
-	if enc, err = t.Serialize(); err != nil {
-		log.Debugf("encode failed on applying transaction: %v", err)
-		return errPass
-	}
 
 	// metaState-related checks will be performed within bolt.Tx to guarantee consistency
 	return func(tx *bolt.Tx) (err error) {
 		log.Debugf("processing transaction: %v", t)
 
+		if limited {
+			// The gaps may be filled by the transactions from blocks
+			s.evictStaleGappedTxs(now)
+			for k := range s.pool.gapped {
+				s.promoteGappedTxs(tx, k)
+			}
+		}
 		// Check tx existense
 		// TODO(leventeliu): maybe move outside?
-		if s.pool.hasTx(t) {
+		if s.pool.hasTx(t) || s.pool.hasGappedTx(t) {
 			log.Debug("transaction already in pool, apply failed")
 			return
 		}
@@ -718,39 +1603,252 @@ func (s *metaState) applyTransactionProcedure(t pi.Transaction) (_ func(*bolt.Tx
 			// Consider the first nonce 0
 			err = nil
 		}
+		if limited && nonce > nextNonce {
+			return s.pool.addGappedTx(t, nextNonce, now)
+		}
 		if nextNonce != nonce {
 			err = ErrInvalidAccountNonce
 			log.Debugf("nonce not match during transaction apply: %v", err)
 			return
 		}
-		// Try to put transaction before any state change, will be rolled back later
-		// if transaction doesn't apply
-		tb := tx.Bucket(metaBucket[:]).Bucket(metaTransactionBucket).Bucket(ttype.Bytes())
-		if err = tb.Put(hash[:], enc); err != nil {
-			log.Debugf("store transaction to bucket failed: %v", err)
+		if limited {
+			if err = s.makeRoomFor(t); err != nil {
+				return
+			}
+			// The pooled transactions of the account may be dropped with the evicted one
+			if next, _ := s.nextNonce(addr); next != nonce {
+				err = ErrInvalidAccountNonce
+				return
+			}
+		}
+		if err = s.poolTransaction(tx, t, nextNonce); err != nil {
 			return
 		}
-		// Try to apply transaction to metaState
-		if err = s.applyTransaction(t); err != nil {
-			log.Debugf("apply transaction failed: %v", err)
+		// Gapped transactions are not promoted for the transactions from blocks, which may
+		// conflict with the following ones in the same block
+		if limited {
+			s.promoteGappedTxs(tx, addr)
+		}
+		return
+	}
+}
+
+// poolTransaction applies t to the metaState, stores it to the transaction bucket and pushes it
+// to the pool. The metaState is left unchanged if it fails.
+func (s *metaState) poolTransaction(
+	tx *bolt.Tx, t pi.Transaction, nextNonce pi.AccountNonce) (err error,
+) {
+	var (
+		enc     []byte
+		hash    = t.GetHash()
+		addr    = t.GetAccountAddress()
+		ttype   = t.GetTransactionType()
+		applied bool
+		// The involved accounts depend on the state before t, e.g. the miners of a dropped
+		// database
+		involved = s.involvedAccounts(t)
+	)
+	if enc, err = t.Serialize(); err != nil {
+		log.Debugf("encode failed on applying transaction: %v", err)
+		return
+	}
+	defer func() {
+		if err != nil && applied {
+			// Revert the changes of t
+			s.Lock()
+			defer s.Unlock()
+			s.rebuildDirty()
+		}
+	}()
+	// Try to apply transaction to metaState
+	if err = s.applyTransaction(t); err != nil {
+		log.Debugf("apply transaction failed: %v", err)
+		return
+	}
+	applied = true
+	if err = s.increaseNonce(addr); err != nil {
+		return
+	}
+	// A transaction may be applied again after a chain reorganization, it should only be
+	// indexed once.
+	tb := tx.Bucket(metaBucket[:]).Bucket(metaTransactionBucket).Bucket(ttype.Bytes())
+	if tb.Get(hash[:]) == nil {
+		if err = indexAccountTransaction(tx, involved, ttype, hash); err != nil {
+			log.Debugf("index transaction failed: %v", err)
 			return
 		}
-		if err = s.increaseNonce(addr); err != nil {
-			// FIXME(leventeliu): should not fail here.
+	}
+	if err = tb.Put(hash[:], enc); err != nil {
+		log.Debugf("store transaction to bucket failed: %v", err)
+		return
+	}
+	// Push to pool
+	s.pool.addTx(t, nextNonce)
+	return
+}
+
+// promoteGappedTxs pools the gapped transactions of account addr whose nonce gaps are filled.
+func (s *metaState) promoteGappedTxs(tx *bolt.Tx, addr proto.AccountAddress) {
+	for {
+		var (
+			next pi.AccountNonce
+			t    pi.Transaction
+			ok   bool
+			err  error
+		)
+		if next, err = s.nextNonce(addr); err != nil {
+			return
+		}
+		if t, ok = s.pool.popGappedTx(addr, next); !ok {
+			return
+		}
+		if err = s.poolTransaction(tx, t, next); err != nil {
+			log.WithFields(log.Fields{
+				"account":     addr.String(),
+				"nonce":       next,
+				"transaction": t.GetHash().String(),
+			}).Debugf("failed to promote gapped transaction: %v", err)
+			return
+		}
+	}
+}
+
+// evictStaleGappedTxs evicts the gapped transactions which are expired at now, or whose nonces
+// are already taken.
+func (s *metaState) evictStaleGappedTxs(now time.Time) {
+	for _, v := range s.pool.evictStaleGappedTxs(now, s.nextNonce) {
+		var addr = v.GetAccountAddress()
+		log.WithFields(log.Fields{
+			"account":     addr.String(),
+			"nonce":       v.GetAccountNonce(),
+			"transaction": v.GetHash().String(),
+		}).Debug("evicted stale gapped transaction")
+	}
+}
+
+// makeRoomFor checks the pool limits for t, and evicts the pooled transaction paying the lowest
+// fee if the pool is full.
+func (s *metaState) makeRoomFor(t pi.Transaction) (err error) {
+	var victim *accountTxEntries
+	if victim, err = s.pool.checkLimits(t); err != nil || victim == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	var (
+		evicted = s.pool.removeLastTx(victim.account)
+		dropped = s.rebuildDirty()
+	)
+	for _, v := range append([]pi.Transaction{evicted}, dropped...) {
+		var addr = v.GetAccountAddress()
+		log.WithFields(log.Fields{
+			"account":     addr.String(),
+			"nonce":       v.GetAccountNonce(),
+			"transaction": v.GetHash().String(),
+		}).Debug("evicted pooled transaction")
+	}
+	return
+}
+
+// rebuildDirty rebuilds the dirty state by replaying the pooled transactions upon the readonly
+// state, the transactions which cannot be applied any more are dropped from the pool. The caller
+// should hold the lock.
+func (s *metaState) rebuildDirty() (dropped []pi.Transaction) {
+	var replayed []pi.Transaction
+	s.dirty, replayed, dropped = replayPool(s.readonly, s.producers, s.pool)
+	s.pool.retain(replayed)
+	return
+}
+
+// indexAccountTransaction appends the transaction to the history of each account in addrs. The
+// history of an account is kept in its own bucket, keyed by an increasing sequence number, and the
+// history keys of the transaction are kept in the reverse index to remove it from the histories.
+func indexAccountTransaction(
+	tx *bolt.Tx, addrs []proto.AccountAddress, ttype pi.TransactionType, h hash.Hash) (err error,
+) {
+	var (
+		meta = tx.Bucket(metaBucket[:])
+		ib   = meta.Bucket(metaAccountTxIndexBucket)
+		ab   *bolt.Bucket
+		seq  uint64
+		key  [8]byte
+		rev  = make([]byte, 0, len(addrs)*(hash.HashSize+len(key)))
+	)
+	for _, v := range addrs {
+		if ab, err = ib.CreateBucketIfNotExists(v[:]); err != nil {
+			return
+		}
+		if seq, err = ab.NextSequence(); err != nil {
+			return
+		}
+		binary.BigEndian.PutUint64(key[:], seq)
+		if err = ab.Put(key[:], append(ttype.Bytes(), h[:]...)); err != nil {
 			return
 		}
-		// Push to pool
-		s.pool.addTx(t, nextNonce)
+		rev = append(append(rev, v[:]...), key[:]...)
+	}
+	return meta.Bucket(metaTxAccountIndexBucket).Put(h[:], rev)
+}
+
+// unindexTransaction removes the transaction from the transaction bucket and the histories of
+// the accounts.
+func unindexTransaction(tx *bolt.Tx, ttype pi.TransactionType, h hash.Hash) (err error) {
+	var (
+		meta = tx.Bucket(metaBucket[:])
+		ib   = meta.Bucket(metaAccountTxIndexBucket)
+		rb   = meta.Bucket(metaTxAccountIndexBucket)
+		val  = append(ttype.Bytes(), h[:]...)
+		rev  = rb.Get(h[:])
+		ks   = hash.HashSize + 8
+	)
+	if err = meta.Bucket(metaTransactionBucket).Bucket(ttype.Bytes()).Delete(h[:]); err != nil {
 		return
 	}
+	if rev == nil {
+		// The transaction is indexed by an older version without the reverse index, look it up
+		// in all the histories
+		return ib.ForEach(func(k, _ []byte) (err error) {
+			var (
+				ab   = ib.Bucket(k)
+				keys [][]byte
+			)
+			if ab == nil {
+				return
+			}
+			if err = ab.ForEach(func(k, v []byte) error {
+				if bytes.Equal(v, val) {
+					keys = append(keys, append([]byte(nil), k...))
+				}
+				return nil
+			}); err != nil {
+				return
+			}
+			for _, v := range keys {
+				if err = ab.Delete(v); err != nil {
+					return
+				}
+			}
+			return
+		})
+	}
+	if len(rev)%ks != 0 {
+		return ErrCorruptedIndex
+	}
+	for i := 0; i < len(rev); i += ks {
+		if ab := ib.Bucket(rev[i : i+hash.HashSize]); ab != nil {
+			if err = ab.Delete(rev[i+hash.HashSize : i+ks]); err != nil {
+				return
+			}
+		}
+	}
+	return rb.Delete(h[:])
 }
 
+// pullTxs returns the pooled transactions ordered by fee, while the nonce order of each account
+// is kept.
 func (s *metaState) pullTxs() (txs []pi.Transaction) {
 	s.Lock()
 	defer s.Unlock()
-	for _, v := range s.pool.entries {
-		// TODO(leventeliu): check race condition.
-		txs = append(txs, v.transacions...)
-	}
+	_, txs, _ = replayPool(s.readonly, s.producers, s.pool)
 	return
 }
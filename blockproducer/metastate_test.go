@@ -24,7 +24,10 @@ import (
 
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/coreos/bbolt"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -42,6 +45,7 @@ func TestMetaState(t *testing.T) {
 			dbid1   = proto.DatabaseID("db#1")
 			dbid2   = proto.DatabaseID("db#2")
 			dbid3   = proto.DatabaseID("db#3")
+			miner   = testMinerAddress()
 			ms      = newMetaState()
 			fl      = path.Join(testDataDir, t.Name())
 			db, err = bolt.Open(fl, 0600, nil)
@@ -65,6 +69,12 @@ func TestMetaState(t *testing.T) {
 			if _, err = meta.CreateBucket(metaSQLChainIndexBucket); err != nil {
 				return
 			}
+			if _, err = meta.CreateBucket(metaAccountTxIndexBucket); err != nil {
+				return
+			}
+			if _, err = meta.CreateBucket(metaTxAccountIndexBucket); err != nil {
+				return
+			}
 			if txbk, err = meta.CreateBucket(metaTransactionBucket); err != nil {
 				return
 			}
@@ -569,6 +579,19 @@ func TestMetaState(t *testing.T) {
 				})
 			})
 			Convey("When transacions are added", func() {
+				// The billing is requested by the miner of the database
+				ao, loaded = ms.loadOrStoreAccountObject(miner, &accountObject{
+					Account: pt.Account{
+						Address: miner,
+					},
+				})
+				So(loaded, ShouldBeFalse)
+				co, loaded = ms.loadSQLChainObject(dbid1)
+				So(loaded, ShouldBeTrue)
+				co.Miners = []proto.AccountAddress{miner}
+				// Billing settlement requires the database to be known by the committed state
+				err = db.Update(ms.commitProcedure())
+				So(err, ShouldBeNil)
 				var (
 					n  pi.AccountNonce
 					t1 = &pt.Transfer{
@@ -582,13 +605,17 @@ func TestMetaState(t *testing.T) {
 					t2 = &pt.TxBilling{
 						TxContent: pt.TxContent{
 							SequenceID: 1,
-							Receivers:  []*proto.AccountAddress{&addr2},
-							Fees:       []uint64{1},
-							Rewards:    []uint64{1},
+							BillingRequest: pt.BillingRequest{
+								Header: pt.BillingRequestHeader{DatabaseID: dbid1},
+							},
+							Receivers: []*proto.AccountAddress{&miner},
+							Fees:      []uint64{1},
+							Rewards:   []uint64{1},
 						},
 						AccountAddress: &addr1,
 					}
 				)
+				signBillingRequest(&t2.TxContent.BillingRequest)
 				err = t1.Sign(testPrivKey)
 				So(err, ShouldBeNil)
 				err = t2.Sign(testPrivKey)
@@ -630,6 +657,39 @@ func TestMetaState(t *testing.T) {
 					So(err, ShouldBeNil)
 					So(n, ShouldEqual, 2)
 				})
+				Convey("The transactions should be indexed by involved accounts", func() {
+					var (
+						c       = &Chain{db: db}
+						records []*TxRecord
+						record  *TxRecord
+					)
+					records, err = c.queryTxHistory(addr2, 0, 0)
+					So(err, ShouldBeNil)
+					So(len(records), ShouldEqual, 1)
+					So(records[0].Type, ShouldEqual, pi.TransactionTypeTransfer)
+					So(records[0].Hash, ShouldEqual, t1.GetHash())
+					records, err = c.queryTxHistory(miner, 0, 0)
+					So(err, ShouldBeNil)
+					So(len(records), ShouldEqual, 1)
+					So(records[0].Type, ShouldEqual, pi.TransactionTypeBilling)
+					So(records[0].Hash, ShouldEqual, t2.GetHash())
+					records, err = c.queryTxHistory(addr1, 1, 1)
+					So(err, ShouldBeNil)
+					So(len(records), ShouldEqual, 1)
+					So(records[0].Hash, ShouldEqual, t1.GetHash())
+					records, err = c.queryTxHistory(addr3, 0, 0)
+					So(err, ShouldBeNil)
+					So(records, ShouldBeEmpty)
+
+					record, err = c.queryTxByHash(t1.GetHash())
+					So(err, ShouldBeNil)
+					So(record.Type, ShouldEqual, pi.TransactionTypeTransfer)
+					tx, err := record.Transaction()
+					So(err, ShouldBeNil)
+					So(tx.GetHash(), ShouldEqual, t1.GetHash())
+					_, err = c.queryTxByHash(hash.Hash{})
+					So(err, ShouldEqual, ErrNoSuchTransaction)
+				})
 				Convey("The metaState should report error on unknown transaction type", func() {
 					err = ms.applyTransaction(nil)
 					So(err, ShouldEqual, ErrUnknownTransactionType)
@@ -672,7 +732,444 @@ func TestMetaState(t *testing.T) {
 				)
 			})
 		})
+		Convey("When a database is billed", func() {
+			ao, loaded = ms.loadOrStoreAccountObject(addr1, &accountObject{
+				Account: pt.Account{
+					Address:           addr1,
+					StableCoinBalance: 10,
+				},
+			})
+			So(loaded, ShouldBeFalse)
+			ao, loaded = ms.loadOrStoreAccountObject(addr2, &accountObject{
+				Account: pt.Account{
+					Address: addr2,
+				},
+			})
+			So(loaded, ShouldBeFalse)
+			ao, loaded = ms.loadOrStoreAccountObject(addr3, &accountObject{
+				Account: pt.Account{
+					Address: addr3,
+				},
+			})
+			So(loaded, ShouldBeFalse)
+			co, loaded = ms.loadOrStoreSQLChainObject(dbid1, &sqlchainObject{
+				SQLChainProfile: pt.SQLChainProfile{
+					ID:      dbid1,
+					Owner:   addr1,
+					Deposit: 20,
+					Miners:  []proto.AccountAddress{addr2, addr3},
+				},
+			})
+			So(loaded, ShouldBeFalse)
+			// The billings are issued by the block producer
+			ms.setProducers([]proto.AccountAddress{miner})
+			var newBilling = func(id proto.DatabaseID, fees, rewards []uint64) *pt.TxBilling {
+				var tb = &pt.TxBilling{
+					TxContent: pt.TxContent{
+						BillingRequest: pt.BillingRequest{
+							Header: pt.BillingRequestHeader{DatabaseID: id},
+						},
+						Receivers: []*proto.AccountAddress{&addr2, &addr3},
+						Fees:      fees,
+						Rewards:   rewards,
+					},
+					AccountAddress: &miner,
+				}
+				So(tb.Sign(testPrivKey), ShouldBeNil)
+				return tb
+			}
+			Convey("The metaState should reject malformed or unknown billing", func() {
+				err = ms.applyBilling(newBilling(dbid1, []uint64{1}, []uint64{1, 1}))
+				So(err, ShouldEqual, ErrInvalidBillingRequest)
+				err = ms.applyBilling(newBilling(dbid2, []uint64{1, 1}, []uint64{1, 1}))
+				So(err, ShouldEqual, ErrDatabaseNotFound)
+			})
+			Convey("The metaState should reject forged billing", func() {
+				var tb = newBilling(dbid1, []uint64{1, 1}, []uint64{1, 1})
+				tb.AccountAddress = &addr1
+				err = ms.applyBilling(tb)
+				So(err, ShouldEqual, ErrInvalidBillingIssuer)
+				ms.setProducers(nil)
+				err = ms.applyBilling(newBilling(dbid1, []uint64{1, 1}, []uint64{1, 1}))
+				So(err, ShouldEqual, ErrInvalidBillingIssuer)
+				ms.setProducers([]proto.AccountAddress{miner})
+				tb = newBilling(dbid1, []uint64{1, 1}, []uint64{1, 1})
+				tb.TxContent.Receivers = []*proto.AccountAddress{&addr2, &addr1}
+				err = ms.applyBilling(tb)
+				So(err, ShouldEqual, ErrInvalidBillingRequest)
+				tb.TxContent.Receivers = []*proto.AccountAddress{&addr2, &addr2}
+				err = ms.applyBilling(tb)
+				So(err, ShouldEqual, ErrInvalidBillingRequest)
+				tb.TxContent.Receivers = []*proto.AccountAddress{&addr2}
+				tb.TxContent.Fees, tb.TxContent.Rewards = []uint64{1}, []uint64{1}
+				err = ms.applyBilling(tb)
+				So(err, ShouldEqual, ErrInvalidBillingRequest)
+				bl, loaded = ms.loadAccountStableBalance(addr1)
+				So(bl, ShouldEqual, 10)
+				co, loaded = ms.loadSQLChainObject(dbid1)
+				So(co.Deposit, ShouldEqual, 20)
+				So(co.Miners, ShouldResemble, []proto.AccountAddress{addr2, addr3})
+			})
+			Convey("The metaState should accept billing requested by the database miners", func() {
+				ao, loaded = ms.loadOrStoreAccountObject(miner, &accountObject{
+					Account: pt.Account{
+						Address: miner,
+					},
+				})
+				So(loaded, ShouldBeFalse)
+				co, loaded = ms.loadOrStoreSQLChainObject(dbid3, &sqlchainObject{
+					SQLChainProfile: pt.SQLChainProfile{
+						ID:      dbid3,
+						Owner:   addr1,
+						Deposit: 20,
+						Miners:  []proto.AccountAddress{miner},
+					},
+				})
+				So(loaded, ShouldBeFalse)
+				ms.setProducers(nil)
+				var tb = &pt.TxBilling{
+					TxContent: pt.TxContent{
+						BillingRequest: pt.BillingRequest{
+							Header: pt.BillingRequestHeader{DatabaseID: dbid3},
+						},
+						Receivers: []*proto.AccountAddress{&miner},
+						Fees:      []uint64{5},
+						Rewards:   []uint64{0},
+					},
+					AccountAddress: &addr1,
+				}
+				err = ms.applyBilling(tb)
+				So(err, ShouldEqual, ErrInvalidBillingIssuer)
+				signBillingRequest(&tb.TxContent.BillingRequest)
+				tb.TxContent.BillingRequest.Header.HighHeight = 1
+				err = ms.applyBilling(tb)
+				So(err, ShouldEqual, ErrInvalidBillingIssuer)
+				signBillingRequest(&tb.TxContent.BillingRequest)
+				err = ms.applyBilling(tb)
+				So(err, ShouldBeNil)
+				co, loaded = ms.loadSQLChainObject(dbid3)
+				So(co.Deposit, ShouldEqual, 15)
+				bl, loaded = ms.loadAccountStableBalance(miner)
+				So(bl, ShouldEqual, 5)
+			})
+			Convey("The fees should be charged from deposit first", func() {
+				err = ms.applyBilling(newBilling(dbid1, []uint64{5, 10}, []uint64{1, 2}))
+				So(err, ShouldBeNil)
+				co, loaded = ms.loadSQLChainObject(dbid1)
+				So(loaded, ShouldBeTrue)
+				So(co.Deposit, ShouldEqual, 5)
+				So(co.Arrears, ShouldEqual, 0)
+				So(co.NextBillingHeight, ShouldEqual, 1)
+				bl, loaded = ms.loadAccountStableBalance(addr1)
+				So(bl, ShouldEqual, 10)
+				bl, loaded = ms.loadAccountStableBalance(addr2)
+				So(bl, ShouldEqual, 5)
+				bl, loaded = ms.loadAccountCovenantBalance(addr2)
+				So(bl, ShouldEqual, 1)
+				bl, loaded = ms.loadAccountStableBalance(addr3)
+				So(bl, ShouldEqual, 10)
+				bl, loaded = ms.loadAccountCovenantBalance(addr3)
+				So(bl, ShouldEqual, 2)
+				Convey("The owner balance should cover the rest", func() {
+					err = ms.applyBilling(newBilling(dbid1, []uint64{6, 6}, []uint64{0, 0}))
+					So(err, ShouldBeNil)
+					co, loaded = ms.loadSQLChainObject(dbid1)
+					So(co.Deposit, ShouldEqual, 0)
+					So(co.Arrears, ShouldEqual, 0)
+					bl, loaded = ms.loadAccountStableBalance(addr1)
+					So(bl, ShouldEqual, 3)
+				})
+			})
+			Convey("The fees should be partially settled if payer runs short", func() {
+				err = ms.applyBilling(newBilling(dbid1, []uint64{20, 40}, []uint64{0, 0}))
+				So(err, ShouldBeNil)
+				co, loaded = ms.loadSQLChainObject(dbid1)
+				So(loaded, ShouldBeTrue)
+				So(co.Deposit, ShouldEqual, 0)
+				So(co.Arrears, ShouldEqual, 30)
+				bl, loaded = ms.loadAccountStableBalance(addr1)
+				So(bl, ShouldEqual, 0)
+				bl, loaded = ms.loadAccountStableBalance(addr2)
+				So(bl, ShouldEqual, 10)
+				bl, loaded = ms.loadAccountStableBalance(addr3)
+				So(bl, ShouldEqual, 20)
+				So(co.Miners, ShouldResemble, []proto.AccountAddress{addr2, addr3})
+				Convey("The arrears should be settled to miners on top-up", func() {
+					ao, _ = ms.loadAccountObject(addr1)
+					ao.StableCoinBalance = 50
+					var tu = pt.NewTopUp(&pt.TopUpHeader{
+						Sender:     addr1,
+						DatabaseID: dbid1,
+						Amount:     51,
+					})
+					So(ms.involvedAccounts(tu), ShouldResemble, []proto.AccountAddress{addr1, addr2, addr3})
+					err = ms.applyTransaction(tu)
+					So(err, ShouldEqual, ErrInsufficientBalance)
+					tu.Amount = 21
+					err = ms.applyTransaction(tu)
+					So(err, ShouldBeNil)
+					co, loaded = ms.loadSQLChainObject(dbid1)
+					So(co.Deposit, ShouldEqual, 0)
+					So(co.Arrears, ShouldEqual, 9)
+					bl, loaded = ms.loadAccountStableBalance(addr2)
+					So(bl, ShouldEqual, 21)
+					bl, loaded = ms.loadAccountStableBalance(addr3)
+					So(bl, ShouldEqual, 30)
+					tu.Amount = 29
+					err = ms.applyTransaction(tu)
+					So(err, ShouldBeNil)
+					co, loaded = ms.loadSQLChainObject(dbid1)
+					So(co.Deposit, ShouldEqual, 20)
+					So(co.Arrears, ShouldEqual, 0)
+					bl, loaded = ms.loadAccountStableBalance(addr1)
+					So(bl, ShouldEqual, 0)
+					bl, loaded = ms.loadAccountStableBalance(addr2)
+					So(bl, ShouldEqual, 26)
+					bl, loaded = ms.loadAccountStableBalance(addr3)
+					So(bl, ShouldEqual, 34)
+				})
+				Convey("The arrears should be settled to miners on drop", func() {
+					var dd = pt.NewDropDatabase(&pt.DropDatabaseHeader{
+						Issuer:     addr1,
+						DatabaseID: dbid1,
+					})
+					So(ms.involvedAccounts(dd), ShouldResemble, []proto.AccountAddress{addr1, addr2, addr3})
+					co.Deposit = 40
+					err = ms.applyTransaction(dd)
+					So(err, ShouldBeNil)
+					_, loaded = ms.loadSQLChainObject(dbid1)
+					So(loaded, ShouldBeFalse)
+					bl, loaded = ms.loadAccountStableBalance(addr1)
+					So(bl, ShouldEqual, 10)
+					bl, loaded = ms.loadAccountStableBalance(addr2)
+					So(bl, ShouldEqual, 25)
+					bl, loaded = ms.loadAccountStableBalance(addr3)
+					So(bl, ShouldEqual, 35)
+				})
+				Convey("The drop should be indexed by the miners settled with", func() {
+					var (
+						dd = pt.NewDropDatabase(&pt.DropDatabaseHeader{
+							Issuer:     addr1,
+							DatabaseID: dbid1,
+						})
+						c = &Chain{db: db}
+					)
+					co.Deposit = 40
+					err = db.Update(func(tx *bolt.Tx) error {
+						return ms.poolTransaction(tx, dd, 0)
+					})
+					So(err, ShouldBeNil)
+					_, loaded = ms.loadSQLChainObject(dbid1)
+					So(loaded, ShouldBeFalse)
+					for _, v := range []proto.AccountAddress{addr1, addr2, addr3} {
+						records, err := c.queryTxHistory(v, 0, 0)
+						So(err, ShouldBeNil)
+						So(len(records), ShouldEqual, 1)
+						So(records[0].Hash, ShouldEqual, dd.GetHash())
+					}
+				})
+				Convey("The owner balance should cover the arrears on drop", func() {
+					var dd = pt.NewDropDatabase(&pt.DropDatabaseHeader{
+						Issuer:     addr1,
+						DatabaseID: dbid1,
+					})
+					ao, _ = ms.loadAccountObject(addr1)
+					ao.StableCoinBalance = 10
+					co.Deposit = 5
+					err = ms.applyTransaction(dd)
+					So(err, ShouldBeNil)
+					_, loaded = ms.loadSQLChainObject(dbid1)
+					So(loaded, ShouldBeFalse)
+					bl, loaded = ms.loadAccountStableBalance(addr1)
+					So(bl, ShouldEqual, 0)
+					bl, loaded = ms.loadAccountStableBalance(addr2)
+					So(bl, ShouldEqual, 18)
+					bl, loaded = ms.loadAccountStableBalance(addr3)
+					So(bl, ShouldEqual, 27)
+				})
+			})
+		})
+		Convey("When a transaction pays fee", func() {
+			for _, v := range []proto.AccountAddress{addr1, addr2, addr3} {
+				ao, loaded = ms.loadOrStoreAccountObject(v, &accountObject{
+					Account: pt.Account{
+						Address:           v,
+						StableCoinBalance: 10,
+					},
+				})
+				So(loaded, ShouldBeFalse)
+			}
+			ms.setProducers([]proto.AccountAddress{addr3})
+			var tr = &pt.Transfer{
+				TransferHeader: pt.TransferHeader{
+					Sender:   addr1,
+					Receiver: addr2,
+					Amount:   5,
+					Fee:      3,
+				},
+			}
+			Convey("The fee should be burnt", func() {
+				err = ms.applyTransaction(tr)
+				So(err, ShouldBeNil)
+				bl, loaded = ms.loadAccountStableBalance(addr1)
+				So(bl, ShouldEqual, 2)
+				bl, loaded = ms.loadAccountStableBalance(addr2)
+				So(bl, ShouldEqual, 15)
+				bl, loaded = ms.loadAccountStableBalance(addr3)
+				So(bl, ShouldEqual, 10)
+			})
+			Convey("The fee should be refunded if the transaction fails", func() {
+				tr.Amount = 8
+				err = ms.applyTransaction(tr)
+				So(err, ShouldEqual, ErrInsufficientBalance)
+				bl, loaded = ms.loadAccountStableBalance(addr1)
+				So(bl, ShouldEqual, 10)
+				bl, loaded = ms.loadAccountStableBalance(addr2)
+				So(bl, ShouldEqual, 10)
+			})
+		})
+		Convey("When a database is created with deposit", func() {
+			ao, loaded = ms.loadOrStoreAccountObject(addr1, &accountObject{
+				Account: pt.Account{
+					Address:           addr1,
+					StableCoinBalance: 100,
+				},
+			})
+			So(loaded, ShouldBeFalse)
+			var (
+				cd = pt.NewCreateDatabase(&pt.CreateDatabaseHeader{
+					Issuer:     addr2,
+					Owner:      addr1,
+					DatabaseID: dbid1,
+					Deposit:    60,
+					Authorization: pt.DepositAuthorization{
+						DepositAuthorizationHeader: pt.DepositAuthorizationHeader{
+							Owner:      addr1,
+							MaxDeposit: 60,
+						},
+					},
+				})
+				dd = pt.NewDropDatabase(&pt.DropDatabaseHeader{
+					Issuer:     addr2,
+					DatabaseID: dbid1,
+				})
+			)
+			ms.setProducers([]proto.AccountAddress{addr2})
+			err = ms.applyTransaction(cd)
+			So(err, ShouldBeNil)
+			co, loaded = ms.loadSQLChainObject(dbid1)
+			So(loaded, ShouldBeTrue)
+			So(co.Owner, ShouldEqual, addr1)
+			So(co.Deposit, ShouldEqual, 60)
+			bl, loaded = ms.loadAccountStableBalance(addr1)
+			So(bl, ShouldEqual, 40)
+			Convey("The database should be listed with its profile", func() {
+				So(ms.loadDatabasesOfAccount(addr1), ShouldResemble, []proto.DatabaseID{dbid1})
+				So(ms.loadDatabasesOfAccount(addr2), ShouldBeEmpty)
+				profile, loaded := ms.loadSQLChainProfile(dbid1)
+				So(loaded, ShouldBeTrue)
+				So(profile.Owner, ShouldEqual, addr1)
+				So(profile.Deposit, ShouldEqual, 60)
+				So(len(profile.Users), ShouldEqual, 1)
+				So(profile.Users[0].Address, ShouldEqual, addr1)
+				So(profile.Users[0].Permission, ShouldEqual, pt.Admin)
+				_, loaded = ms.loadSQLChainProfile(dbid2)
+				So(loaded, ShouldBeFalse)
+				err = db.Update(ms.commitProcedure())
+				So(err, ShouldBeNil)
+				err = ms.applyTransaction(dd)
+				So(err, ShouldBeNil)
+				So(ms.loadDatabasesOfAccount(addr1), ShouldBeEmpty)
+				_, loaded = ms.loadSQLChainProfile(dbid1)
+				So(loaded, ShouldBeFalse)
+			})
+			Convey("The deposit authorization should not be replayed", func() {
+				ao, _ = ms.loadAccountObject(addr1)
+				So(ao.NextDepositNonce, ShouldEqual, 1)
+				cd.DatabaseID = dbid2
+				err = ms.applyTransaction(cd)
+				So(err, ShouldEqual, ErrInvalidDepositAuthorization)
+				cd.Authorization.Nonce = 1
+				cd.Deposit = 61
+				err = ms.applyTransaction(cd)
+				So(err, ShouldEqual, ErrInvalidDepositAuthorization)
+				cd.Deposit = 40
+				err = ms.applyTransaction(cd)
+				So(err, ShouldBeNil)
+				bl, loaded = ms.loadAccountStableBalance(addr1)
+				So(bl, ShouldEqual, 0)
+			})
+			Convey("The metaState should reject the txs issued by neither owner nor producer", func() {
+				dd.Issuer = addr3
+				err = ms.applyTransaction(dd)
+				So(err, ShouldEqual, ErrInvalidIssuer)
+				dd.Issuer = addr1
+				err = ms.applyTransaction(dd)
+				So(err, ShouldBeNil)
+				cd.Issuer = addr3
+				err = ms.applyTransaction(cd)
+				So(err, ShouldEqual, ErrInvalidIssuer)
+				cd.Issuer = addr1
+				cd.Authorization.Nonce = 1
+				err = ms.applyTransaction(cd)
+				So(err, ShouldBeNil)
+			})
+			Convey("The metaState should reject duplicated or unaffordable database", func() {
+				err = ms.applyTransaction(cd)
+				So(err, ShouldEqual, ErrDatabaseExists)
+				cd.DatabaseID = dbid2
+				cd.Authorization.Nonce = 1
+				err = ms.applyTransaction(cd)
+				So(err, ShouldEqual, ErrInsufficientBalance)
+				cd.Owner = addr3
+				err = ms.applyTransaction(cd)
+				So(err, ShouldEqual, ErrInvalidDepositAuthorization)
+				cd.Authorization.Owner = addr3
+				err = ms.applyTransaction(cd)
+				So(err, ShouldEqual, ErrAccountNotFound)
+			})
+			Convey("The remaining deposit should be refunded on drop", func() {
+				co.Deposit = 50
+				err = ms.applyTransaction(dd)
+				So(err, ShouldBeNil)
+				co, loaded = ms.loadSQLChainObject(dbid1)
+				So(loaded, ShouldBeFalse)
+				bl, loaded = ms.loadAccountStableBalance(addr1)
+				So(bl, ShouldEqual, 90)
+				err = ms.applyTransaction(dd)
+				So(err, ShouldEqual, ErrDatabaseNotFound)
+				Convey("The database should be able to be created again", func() {
+					cd.Authorization.Nonce = 1
+					err = ms.applyTransaction(cd)
+					So(err, ShouldBeNil)
+					bl, loaded = ms.loadAccountStableBalance(addr1)
+					So(bl, ShouldEqual, 30)
+				})
+			})
+		})
 		Convey("When base account txs are added", func() {
+			// Register a database paid by addr3 for the billing txs
+			ao, loaded = ms.loadOrStoreAccountObject(addr3, &accountObject{
+				Account: pt.Account{
+					Address:           addr3,
+					StableCoinBalance: 100,
+				},
+			})
+			So(loaded, ShouldBeFalse)
+			err = ms.createSQLChain(addr3, dbid3)
+			So(err, ShouldBeNil)
+			// The billings are requested by the miner of the database
+			ao, loaded = ms.loadOrStoreAccountObject(miner, &accountObject{
+				Account: pt.Account{
+					Address: miner,
+				},
+			})
+			So(loaded, ShouldBeFalse)
+			co, loaded = ms.loadSQLChainObject(dbid3)
+			So(loaded, ShouldBeTrue)
+			co.Miners = []proto.AccountAddress{miner}
+			err = db.Update(ms.commitProcedure())
+			So(err, ShouldBeNil)
 			var (
 				txs = []pi.Transaction{
 					&pt.BaseAccount{
@@ -700,18 +1197,24 @@ func TestMetaState(t *testing.T) {
 					&pt.TxBilling{
 						TxContent: pt.TxContent{
 							SequenceID: 2,
-							Receivers:  []*proto.AccountAddress{&addr2},
-							Fees:       []uint64{1},
-							Rewards:    []uint64{1},
+							BillingRequest: pt.BillingRequest{
+								Header: pt.BillingRequestHeader{DatabaseID: dbid3},
+							},
+							Receivers: []*proto.AccountAddress{&miner},
+							Fees:      []uint64{1},
+							Rewards:   []uint64{1},
 						},
 						AccountAddress: &addr1,
 					},
 					&pt.TxBilling{
 						TxContent: pt.TxContent{
 							SequenceID: 1,
-							Receivers:  []*proto.AccountAddress{&addr1},
-							Fees:       []uint64{1},
-							Rewards:    []uint64{1},
+							BillingRequest: pt.BillingRequest{
+								Header: pt.BillingRequestHeader{DatabaseID: dbid3},
+							},
+							Receivers: []*proto.AccountAddress{&miner},
+							Fees:      []uint64{1},
+							Rewards:   []uint64{1},
 						},
 						AccountAddress: &addr2,
 					},
@@ -750,6 +1253,9 @@ func TestMetaState(t *testing.T) {
 				}
 			)
 			for _, tx := range txs {
+				if tb, ok := tx.(*pt.TxBilling); ok {
+					signBillingRequest(&tb.TxContent.BillingRequest)
+				}
 				err = tx.Sign(testPrivKey)
 				So(err, ShouldBeNil)
 				err = db.Update(ms.applyTransactionProcedure(tx))
@@ -758,10 +1264,10 @@ func TestMetaState(t *testing.T) {
 			Convey("The state should match the update result", func() {
 				bl, loaded = ms.loadAccountStableBalance(addr1)
 				So(loaded, ShouldBeTrue)
-				So(bl, ShouldEqual, 84)
+				So(bl, ShouldEqual, 83)
 				bl, loaded = ms.loadAccountStableBalance(addr2)
 				So(loaded, ShouldBeTrue)
-				So(bl, ShouldEqual, 118)
+				So(bl, ShouldEqual, 117)
 			})
 			Convey("When state change is partial committed #1", func() {
 				err = db.Update(ms.partialCommitProcedure(txs[:2]))
@@ -769,10 +1275,10 @@ func TestMetaState(t *testing.T) {
 				Convey("The state should still match the update result", func() {
 					bl, loaded = ms.loadAccountStableBalance(addr1)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 84)
+					So(bl, ShouldEqual, 83)
 					bl, loaded = ms.loadAccountStableBalance(addr2)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 118)
+					So(bl, ShouldEqual, 117)
 				})
 			})
 			Convey("When state change is partial committed #2", func() {
@@ -781,10 +1287,10 @@ func TestMetaState(t *testing.T) {
 				Convey("The state should still match the update result", func() {
 					bl, loaded = ms.loadAccountStableBalance(addr1)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 84)
+					So(bl, ShouldEqual, 83)
 					bl, loaded = ms.loadAccountStableBalance(addr2)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 118)
+					So(bl, ShouldEqual, 117)
 				})
 			})
 			Convey("When state change is partial committed #3", func() {
@@ -793,10 +1299,10 @@ func TestMetaState(t *testing.T) {
 				Convey("The state should still match the update result", func() {
 					bl, loaded = ms.loadAccountStableBalance(addr1)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 84)
+					So(bl, ShouldEqual, 83)
 					bl, loaded = ms.loadAccountStableBalance(addr2)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 118)
+					So(bl, ShouldEqual, 117)
 				})
 			})
 			Convey("When state change is partial committed #4", func() {
@@ -805,12 +1311,31 @@ func TestMetaState(t *testing.T) {
 				Convey("The state should still match the update result", func() {
 					bl, loaded = ms.loadAccountStableBalance(addr1)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 84)
+					So(bl, ShouldEqual, 83)
 					bl, loaded = ms.loadAccountStableBalance(addr2)
 					So(loaded, ShouldBeTrue)
-					So(bl, ShouldEqual, 118)
+					So(bl, ShouldEqual, 117)
 				})
 			})
 		})
 	})
 }
+
+// testMinerAddress returns the account address of the test key pair.
+func testMinerAddress() (addr proto.AccountAddress) {
+	var err error
+	addr, err = utils.PubKeyHash(testPubKey)
+	So(err, ShouldBeNil)
+	return
+}
+
+// signBillingRequest signs br as the miner of testMinerAddress.
+func signBillingRequest(br *pt.BillingRequest) {
+	h, err := br.PackRequestHeader()
+	So(err, ShouldBeNil)
+	br.RequestHash = *h
+	sign, err := testPrivKey.Sign(h[:])
+	So(err, ShouldBeNil)
+	br.Signees = []*asymmetric.PublicKey{testPubKey}
+	br.Signatures = []*asymmetric.Signature{sign}
+}
@@ -19,7 +19,9 @@ package blockproducer
 import (
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	"github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/timesync"
 )
 
 const (
@@ -100,6 +102,8 @@ type NextAccountNonceResp struct {
 	proto.Envelope
 	Addr  proto.AccountAddress
 	Nonce pi.AccountNonce
+	// DepositNonce is the nonce of the next deposit authorization signed by the account.
+	DepositNonce pi.AccountNonce
 }
 
 // AddTxReq defines a request of the AddTx RPC method.
@@ -131,6 +135,10 @@ type QueryAccountStableBalanceResp struct {
 	Addr    proto.AccountAddress
 	OK      bool
 	Balance uint64
+	// Committed is the committed account proved by Proof, while Balance also counts the pending
+	// transactions. Proof is nil if the state cannot be proved yet.
+	Committed *types.Account
+	Proof     *types.StateProof
 }
 
 // QueryAccountCovenantBalanceReq defines a request of the QueryAccountCovenantBalance RPC method.
@@ -145,6 +153,79 @@ type QueryAccountCovenantBalanceResp struct {
 	Addr    proto.AccountAddress
 	OK      bool
 	Balance uint64
+	// Committed is the committed account proved by Proof, while Balance also counts the pending
+	// transactions. Proof is nil if the state cannot be proved yet.
+	Committed *types.Account
+	Proof     *types.StateProof
+}
+
+// QueryAccountDatabasesReq defines a request of the QueryAccountDatabases RPC method.
+type QueryAccountDatabasesReq struct {
+	proto.Envelope
+	Addr proto.AccountAddress
+}
+
+// QueryAccountDatabasesResp defines a response of the QueryAccountDatabases RPC method.
+type QueryAccountDatabasesResp struct {
+	proto.Envelope
+	Addr      proto.AccountAddress
+	Databases []proto.DatabaseID
+}
+
+// QuerySQLChainProfileReq defines a request of the QuerySQLChainProfile RPC method.
+type QuerySQLChainProfileReq struct {
+	proto.Envelope
+	DBID proto.DatabaseID
+}
+
+// QuerySQLChainProfileResp defines a response of the QuerySQLChainProfile RPC method.
+type QuerySQLChainProfileResp struct {
+	proto.Envelope
+	Profile types.SQLChainProfile
+	// Committed is the committed profile proved by Proof, while Profile also counts the pending
+	// transactions. Proof is nil if the state cannot be proved yet.
+	Committed *types.SQLChainProfile
+	Proof     *types.StateProof
+}
+
+// TxRecord defines a transaction in its serialized form, as returned by the transaction query RPC
+// methods.
+type TxRecord struct {
+	Type pi.TransactionType
+	Hash hash.Hash
+	Tx   []byte
+}
+
+// Transaction decodes the transaction of the record.
+func (r *TxRecord) Transaction() (pi.Transaction, error) {
+	return types.DecodeTransaction(r.Type, r.Tx)
+}
+
+// QueryTxHistoryReq defines a request of the QueryTxHistory RPC method.
+type QueryTxHistoryReq struct {
+	proto.Envelope
+	Addr   proto.AccountAddress
+	Offset uint32
+	Limit  uint32
+}
+
+// QueryTxHistoryResp defines a response of the QueryTxHistory RPC method.
+type QueryTxHistoryResp struct {
+	proto.Envelope
+	Addr    proto.AccountAddress
+	Records []*TxRecord
+}
+
+// QueryTxByHashReq defines a request of the QueryTxByHash RPC method.
+type QueryTxByHashReq struct {
+	proto.Envelope
+	Hash hash.Hash
+}
+
+// QueryTxByHashResp defines a response of the QueryTxByHash RPC method.
+type QueryTxByHashResp struct {
+	proto.Envelope
+	Record *TxRecord
 }
 
 // AdviseNewBlock is the RPC method to advise a new block to target server.
@@ -176,6 +257,12 @@ func (s *ChainRPCService) FetchBlock(req *FetchBlockReq, resp *FetchBlockResp) e
 	return err
 }
 
+// QueryTime is the RPC method to query the local clock for time synchronization.
+func (s *ChainRPCService) QueryTime(req *timesync.QueryTimeReq, resp *timesync.QueryTimeResp) error {
+	timesync.ServeQueryTime(resp)
+	return nil
+}
+
 // FetchTxBilling is the RPC method to fetch a known billing tx form the target server.
 func (s *ChainRPCService) FetchTxBilling(req *FetchTxBillingReq, resp *FetchTxBillingResp) error {
 	return nil
@@ -188,6 +275,9 @@ func (s *ChainRPCService) NextAccountNonce(
 	if resp.Nonce, err = s.chain.ms.nextNonce(req.Addr); err != nil {
 		return
 	}
+	if resp.DepositNonce, err = s.chain.ms.nextDepositNonce(req.Addr); err != nil {
+		return
+	}
 	resp.Addr = req.Addr
 	return
 }
@@ -198,9 +288,7 @@ func (s *ChainRPCService) AddTx(req *AddTxReq, resp *AddTxResp) (err error) {
 		return ErrUnknownTransactionType
 	}
 
-	s.chain.pendingTxs <- req.Tx
-
-	return
+	return s.chain.addTx(req.Tx)
 }
 
 // AddTxTransfer is the RPC method to add a transfer transaction.
@@ -209,9 +297,7 @@ func (s *ChainRPCService) AddTxTransfer(req *AddTxTransferReq, resp *AddTxResp)
 		return ErrUnknownTransactionType
 	}
 
-	s.chain.pendingTxs <- req.Tx
-
-	return
+	return s.chain.addTx(req.Tx)
 }
 
 // QueryAccountStableBalance is the RPC method to query acccount stable coin balance.
@@ -220,6 +306,9 @@ func (s *ChainRPCService) QueryAccountStableBalance(
 ) {
 	resp.Addr = req.Addr
 	resp.Balance, resp.OK = s.chain.ms.loadAccountStableBalance(req.Addr)
+	if resp.Committed, resp.Proof, err = s.chain.proveAccount(req.Addr); err == ErrStateNotReady {
+		err = nil
+	}
 	return
 }
 
@@ -229,5 +318,47 @@ func (s *ChainRPCService) QueryAccountCovenantBalance(
 ) {
 	resp.Addr = req.Addr
 	resp.Balance, resp.OK = s.chain.ms.loadAccountCovenantBalance(req.Addr)
+	if resp.Committed, resp.Proof, err = s.chain.proveAccount(req.Addr); err == ErrStateNotReady {
+		err = nil
+	}
+	return
+}
+
+// QueryAccountDatabases is the RPC method to query the databases owned by an account.
+func (s *ChainRPCService) QueryAccountDatabases(
+	req *QueryAccountDatabasesReq, resp *QueryAccountDatabasesResp) (err error,
+) {
+	resp.Addr = req.Addr
+	resp.Databases = s.chain.ms.loadDatabasesOfAccount(req.Addr)
+	return
+}
+
+// QuerySQLChainProfile is the RPC method to query the profile of a database.
+func (s *ChainRPCService) QuerySQLChainProfile(
+	req *QuerySQLChainProfileReq, resp *QuerySQLChainProfileResp) (err error,
+) {
+	profile, loaded := s.chain.ms.loadSQLChainProfile(req.DBID)
+	if !loaded {
+		return ErrDatabaseNotFound
+	}
+	resp.Profile = *profile
+	resp.Committed, resp.Proof, err = s.chain.proveSQLChainProfile(req.DBID)
+	if err == ErrStateNotReady {
+		err = nil
+	}
+	return
+}
+
+// QueryTxHistory is the RPC method to query the transactions involving an account, the latest
+// first.
+func (s *ChainRPCService) QueryTxHistory(req *QueryTxHistoryReq, resp *QueryTxHistoryResp) (err error) {
+	resp.Addr = req.Addr
+	resp.Records, err = s.chain.queryTxHistory(req.Addr, req.Offset, req.Limit)
+	return
+}
+
+// QueryTxByHash is the RPC method to query a transaction by its hash.
+func (s *ChainRPCService) QueryTxByHash(req *QueryTxByHashReq, resp *QueryTxByHashResp) (err error) {
+	resp.Record, err = s.chain.queryTxByHash(req.Hash)
 	return
 }
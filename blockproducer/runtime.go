@@ -22,8 +22,11 @@ import (
 	"time"
 
 	"github.com/CovenantSQL/CovenantSQL/kayak"
+	ka "github.com/CovenantSQL/CovenantSQL/kayak/api"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/transport"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/timesync"
 )
 
 // copy from /sqlchain/runtime.go
@@ -37,6 +40,8 @@ type rt struct {
 
 	accountAddress proto.AccountAddress
 	server         *rpc.Server
+	// transport carries the consensus votes among block producers.
+	transport kayak.Transport
 
 	bpNum uint32
 	// index is the index of the current server in the peer list.
@@ -57,11 +62,11 @@ type rt struct {
 	// nextTurn is the height of the next block.
 	nextTurn uint32
 
+	// clock measures the clock offsets of the other block producers.
+	clock *timesync.Clock
 	// timeMutex protects following time-relative fields.
 	timeMutex sync.Mutex
 	// offset is the time difference calculated by: coodinatedChainTime - time.Now().
-	//
-	// TODO(leventeliu): update offset in ping cycle.
 	offset time.Duration
 }
 
@@ -92,12 +97,27 @@ func newRuntime(cfg *Config, accountAddress proto.AccountAddress) *rt {
 		peers:          cfg.Peers,
 		nodeID:         cfg.NodeID,
 		nextTurn:       1,
+		clock:          timesync.NewClock(cfg.Period/timesync.MaxSkewRatio, timesync.ExpiryRounds*cfg.Period),
 		offset:         time.Duration(0),
 	}
 }
 
+// setOffset updates the time difference to the coodinated chain time.
+func (r *rt) setOffset(offset time.Duration) {
+	r.timeMutex.Lock()
+	defer r.timeMutex.Unlock()
+	r.offset = offset
+}
+
 func (r *rt) startService(chain *Chain) {
 	r.server.RegisterService(MainChainRPCName, &ChainRPCService{chain: chain})
+	r.transport = kt.NewETLSTransport(&kt.ETLSTransportConfig{
+		NodeID:           r.nodeID,
+		TransportID:      consensusTransportID,
+		TransportService: ka.NewMuxService(MainChainConsensusName, r.server),
+		ServiceName:      MainChainConsensusName,
+	})
+	r.transport.Init()
 }
 
 // nextTick returns the current clock reading and the duration till the next turn. If duration
@@ -114,6 +134,16 @@ func (r *rt) nextTick() (t time.Time, d time.Duration) {
 	return
 }
 
+// getProposer returns the block producer expected to propose the block of the given height.
+func (r *rt) getProposer(height uint32) *kayak.Server {
+	r.peersMutex.Lock()
+	defer r.peersMutex.Unlock()
+	if r.bpNum == 0 || int(height%r.bpNum) >= len(r.peers.Servers) {
+		return nil
+	}
+	return r.peers.Servers[height%r.bpNum]
+}
+
 func (r *rt) isMyTurn() bool {
 	r.stateMutex.Lock()
 	defer r.stateMutex.Unlock()
@@ -166,4 +196,7 @@ func (r *rt) stop() {
 }
 
 func (r *rt) stopService() {
+	if r.transport != nil {
+		r.transport.Shutdown()
+	}
 }
@@ -74,5 +74,15 @@ func (s *State) increaseHeightByOne() {
 func (s *State) getHeader() *hash.Hash {
 	s.Lock()
 	defer s.Unlock()
-	return &s.Head
+	h := s.Head
+	return &h
+}
+
+// update moves the state to node.
+func (s *State) update(node *blockNode) {
+	s.Lock()
+	defer s.Unlock()
+	s.Node = node
+	s.Head = node.hash
+	s.Height = node.height
 }
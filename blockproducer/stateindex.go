@@ -0,0 +1,76 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// StateIndex replays the main chain blocks to index the account and database states in memory,
+// for the nodes which follow the main chain without taking part in the consensus, e.g., explorer.
+type StateIndex struct {
+	ms *metaState
+}
+
+// NewStateIndex returns a new StateIndex with the accounts of the block producers.
+func NewStateIndex(producers []proto.AccountAddress) (i *StateIndex) {
+	i = &StateIndex{ms: newMetaState()}
+	i.ms.setProducers(producers)
+	return
+}
+
+// InvolvedAccounts returns the accounts involved in transaction t upon the current state. It
+// should be called before the block containing t is applied.
+func (i *StateIndex) InvolvedAccounts(t pi.Transaction) []proto.AccountAddress {
+	return i.ms.involvedAccounts(t)
+}
+
+// ApplyBlock replays the transactions of block b upon the current state. The blocks are trusted
+// as they are produced by block producers, a transaction failing to apply is skipped with a
+// warning.
+func (i *StateIndex) ApplyBlock(b *pt.Block) {
+	for _, v := range b.Transactions {
+		if err := i.ms.replayTransaction(v); err != nil {
+			log.WithFields(log.Fields{
+				"block":       b.SignedHeader.BlockHash.String(),
+				"transaction": v.GetHash().String(),
+			}).WithError(err).Warning("replay transaction failed")
+		}
+	}
+	i.ms.commitDirty()
+}
+
+// LoadAccount returns a copy of the account state of addr.
+func (i *StateIndex) LoadAccount(addr proto.AccountAddress) (account *pt.Account, loaded bool) {
+	var o *accountObject
+	if o, loaded = i.ms.loadAccountObject(addr); !loaded {
+		return
+	}
+	o.RLock()
+	defer o.RUnlock()
+	account = &pt.Account{}
+	*account = o.Account
+	return
+}
+
+// LoadDatabases returns the sorted IDs of the databases owned by addr.
+func (i *StateIndex) LoadDatabases(addr proto.AccountAddress) []proto.DatabaseID {
+	return i.ms.loadDatabasesOfAccount(addr)
+}
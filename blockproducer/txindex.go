@@ -31,14 +31,14 @@ type txIndex struct {
 	billingHashIndex map[hash.Hash]*types.TxBilling
 	// lastBillingIndex indexes last appearing txBilling of DatabaseID
 	// to ensure the nonce of txbilling monotone increasing
-	lastBillingIndex map[*proto.DatabaseID]uint32
+	lastBillingIndex map[proto.DatabaseID]uint32
 }
 
 // newTxIndex creates a new TxIndex.
 func newTxIndex() *txIndex {
 	ti := txIndex{
 		billingHashIndex: make(map[hash.Hash]*types.TxBilling),
-		lastBillingIndex: make(map[*proto.DatabaseID]uint32),
+		lastBillingIndex: make(map[proto.DatabaseID]uint32),
 	}
 	return &ti
 }
@@ -65,16 +65,29 @@ func (ti *txIndex) updateLastTxBilling(databaseID *proto.DatabaseID, sequenceID
 	ti.mu.Lock()
 	defer ti.mu.Unlock()
 
-	if v, ok := ti.lastBillingIndex[databaseID]; ok {
+	if v, ok := ti.lastBillingIndex[*databaseID]; ok {
 		if v >= sequenceID {
 			return ErrSmallerSequenceID
 		}
-		ti.lastBillingIndex[databaseID] = sequenceID
+		ti.lastBillingIndex[*databaseID] = sequenceID
 	}
-	ti.lastBillingIndex[databaseID] = sequenceID
+	ti.lastBillingIndex[*databaseID] = sequenceID
 	return
 }
 
+// resetLastTxBilling resets the last billing index of specific databaseID after a chain
+// reorganization, the index is removed if ok is false.
+func (ti *txIndex) resetLastTxBilling(databaseID proto.DatabaseID, sequenceID uint32, ok bool) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	if ok {
+		ti.lastBillingIndex[databaseID] = sequenceID
+	} else {
+		delete(ti.lastBillingIndex, databaseID)
+	}
+}
+
 // fetchUnpackedTxBillings fetch all txbillings in index.
 func (ti *txIndex) fetchUnpackedTxBillings() []*types.TxBilling {
 	ti.mu.Lock()
@@ -92,19 +105,28 @@ func (ti *txIndex) fetchUnpackedTxBillings() []*types.TxBilling {
 
 // hasTxBilling look up the specific txbilling in index.
 func (ti *txIndex) hasTxBilling(h *hash.Hash) bool {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
 	_, ok := ti.billingHashIndex[*h]
 	return ok
 }
 
 // getTxBilling look up the specific txbilling in index.
 func (ti *txIndex) getTxBilling(h *hash.Hash) *types.TxBilling {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
 	val := ti.billingHashIndex[*h]
 	return val
 }
 
 // lastSequenceID look up the last sequenceID of specific databaseID.
 func (ti *txIndex) lastSequenceID(databaseID *proto.DatabaseID) (uint32, error) {
-	if seqID, ok := ti.lastBillingIndex[databaseID]; ok {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	if seqID, ok := ti.lastBillingIndex[*databaseID]; ok {
 		return seqID, nil
 	}
 	return 0, ErrNoSuchTxBilling
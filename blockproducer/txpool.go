@@ -17,11 +17,46 @@
 package blockproducer
 
 import (
+	"bytes"
+	"container/heap"
+	"time"
+
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
+const (
+	// defaultMaxAccountTxs is the maximum number of pooled transactions of an account.
+	defaultMaxAccountTxs = 64
+	// defaultMaxTxs is the maximum number of pooled transactions of all accounts.
+	defaultMaxTxs = 4096
+	// defaultMaxAccountGappedTxs is the maximum number of gapped transactions of an account, it's
+	// also the maximum distance between the nonce of a gapped transaction and the next nonce of
+	// its account.
+	defaultMaxAccountGappedTxs = 16
+	// defaultMaxGappedTxs is the maximum number of gapped transactions of all accounts.
+	defaultMaxGappedTxs = 1024
+	// defaultGapExpiry is the duration a gapped transaction waits for its nonce gap to be filled
+	// before it's evicted.
+	defaultGapExpiry = 10 * time.Minute
+)
+
+// txPoolLimits defines the limits of a txPool.
+type txPoolLimits struct {
+	maxAccountTxs, maxTxs             int
+	maxAccountGappedTxs, maxGappedTxs int
+	gapExpiry                         time.Duration
+}
+
+var defaultTxPoolLimits = txPoolLimits{
+	maxAccountTxs:       defaultMaxAccountTxs,
+	maxTxs:              defaultMaxTxs,
+	maxAccountGappedTxs: defaultMaxAccountGappedTxs,
+	maxGappedTxs:        defaultMaxGappedTxs,
+	gapExpiry:           defaultGapExpiry,
+}
+
 type accountTxEntries struct {
 	account     proto.AccountAddress
 	baseNonce   pi.AccountNonce
@@ -46,6 +81,13 @@ func (e *accountTxEntries) addTx(tx pi.Transaction) {
 	e.transacions = append(e.transacions, tx)
 }
 
+func (e *accountTxEntries) lastTx() pi.Transaction {
+	if len(e.transacions) == 0 {
+		return nil
+	}
+	return e.transacions[len(e.transacions)-1]
+}
+
 func (e *accountTxEntries) halfDeepCopy() (cpy *accountTxEntries) {
 	return &accountTxEntries{
 		account:     e.account,
@@ -54,13 +96,32 @@ func (e *accountTxEntries) halfDeepCopy() (cpy *accountTxEntries) {
 	}
 }
 
+// gappedTx is a transaction whose nonce is ahead of the next nonce of its account, it waits in
+// the pool until the nonce gap is filled.
+type gappedTx struct {
+	tx       pi.Transaction
+	received time.Time
+}
+
+// txPool keeps the transactions which are applied to the dirty state but not committed yet, and
+// the gapped transactions which cannot be applied until the preceding ones arrive.
 type txPool struct {
 	entries map[proto.AccountAddress]*accountTxEntries
+	gapped  map[proto.AccountAddress]map[pi.AccountNonce]*gappedTx
+	limits  txPoolLimits
+	// size and gappedSize are the numbers of pooled and gapped transactions of all accounts.
+	size, gappedSize int
 }
 
 func newTxPool() *txPool {
+	return newTxPoolWithLimits(defaultTxPoolLimits)
+}
+
+func newTxPoolWithLimits(limits txPoolLimits) *txPool {
 	return &txPool{
 		entries: make(map[proto.AccountAddress]*accountTxEntries),
+		gapped:  make(map[proto.AccountAddress]map[pi.AccountNonce]*gappedTx),
+		limits:  limits,
 	}
 }
 
@@ -72,6 +133,7 @@ func (p *txPool) addTx(tx pi.Transaction, baseNonce pi.AccountNonce) {
 		p.entries[addr] = e
 	}
 	e.addTx(tx)
+	p.size++
 }
 
 func (p *txPool) getTxEntries(addr proto.AccountAddress) (e *accountTxEntries, ok bool) {
@@ -118,13 +180,266 @@ func (p *txPool) cmpAndMoveNextTx(tx pi.Transaction) (ok bool) {
 	// Move forward
 	te.transacions = te.transacions[1:]
 	te.baseNonce++
+	p.size--
+	return
+}
+
+// checkLimits checks whether tx can be pushed to the pool. If the pool is full, it returns the
+// account whose last transaction pays the lowest fee, which should be evicted to make room for tx.
+// The last transaction of the account of tx is never chosen, the nonce of tx depends on it.
+func (p *txPool) checkLimits(tx pi.Transaction) (victim *accountTxEntries, err error) {
+	var addr = tx.GetAccountAddress()
+	if e, ok := p.entries[addr]; ok && len(e.transacions) >= p.limits.maxAccountTxs {
+		err = ErrAccountTxPoolFull
+		return
+	}
+	if p.size < p.limits.maxTxs {
+		return
+	}
+	for k, v := range p.entries {
+		var last = v.lastTx()
+		if k == addr || last == nil {
+			continue
+		}
+		if victim == nil || txLess(last, victim.lastTx()) {
+			victim = v
+		}
+	}
+	if victim == nil || victim.lastTx().GetFee() >= tx.GetFee() {
+		victim = nil
+		err = ErrTxPoolFull
+		return
+	}
+	return
+}
+
+// removeLastTx removes the last transaction of account addr from the pool.
+func (p *txPool) removeLastTx(addr proto.AccountAddress) (tx pi.Transaction) {
+	var e, ok = p.entries[addr]
+	if !ok {
+		return
+	}
+	if tx = e.lastTx(); tx != nil {
+		e.transacions = e.transacions[:len(e.transacions)-1]
+		p.size--
+	}
+	return
+}
+
+// retain keeps only txs in the pool, which should be ordered by nonce for each account.
+func (p *txPool) retain(txs []pi.Transaction) {
+	for _, v := range p.entries {
+		v.transacions = nil
+	}
+	p.size = 0
+	for _, v := range txs {
+		if e, ok := p.entries[v.GetAccountAddress()]; ok {
+			e.addTx(v)
+			p.size++
+		}
+	}
+}
+
+// addGappedTx adds tx to the gapped transactions of its account, whose next nonce is nextNonce.
+func (p *txPool) addGappedTx(tx pi.Transaction, nextNonce pi.AccountNonce, now time.Time) error {
+	var (
+		addr   = tx.GetAccountAddress()
+		nonce  = tx.GetAccountNonce()
+		txs    = p.gapped[addr]
+		gt, ok = txs[nonce]
+	)
+	if ok {
+		if gt.tx.GetHash() == tx.GetHash() {
+			return nil
+		}
+		return ErrInvalidAccountNonce
+	}
+	if nonce <= nextNonce || int(nonce-nextNonce) > p.limits.maxAccountGappedTxs {
+		return ErrInvalidAccountNonce
+	}
+	if len(txs) >= p.limits.maxAccountGappedTxs {
+		return ErrAccountTxPoolFull
+	}
+	if p.gappedSize >= p.limits.maxGappedTxs {
+		return ErrTxPoolFull
+	}
+	if txs == nil {
+		txs = make(map[pi.AccountNonce]*gappedTx)
+		p.gapped[addr] = txs
+	}
+	txs[nonce] = &gappedTx{tx: tx, received: now}
+	p.gappedSize++
+	return nil
+}
+
+func (p *txPool) hasGappedTx(tx pi.Transaction) (ok bool) {
+	var gt *gappedTx
+	if gt, ok = p.gapped[tx.GetAccountAddress()][tx.GetAccountNonce()]; !ok {
+		return
+	}
+	return gt.tx.GetHash() == tx.GetHash()
+}
+
+// popGappedTx removes and returns the gapped transaction of account addr with nonce.
+func (p *txPool) popGappedTx(
+	addr proto.AccountAddress, nonce pi.AccountNonce) (tx pi.Transaction, ok bool,
+) {
+	var (
+		txs = p.gapped[addr]
+		gt  *gappedTx
+	)
+	if gt, ok = txs[nonce]; !ok {
+		return
+	}
+	tx = gt.tx
+	p.deleteGappedTx(addr, nonce)
+	return
+}
+
+func (p *txPool) deleteGappedTx(addr proto.AccountAddress, nonce pi.AccountNonce) {
+	var txs = p.gapped[addr]
+	if _, ok := txs[nonce]; !ok {
+		return
+	}
+	delete(txs, nonce)
+	p.gappedSize--
+	if len(txs) == 0 {
+		delete(p.gapped, addr)
+	}
+}
+
+// evictStaleGappedTxs evicts the gapped transactions which have been waiting longer than the gap
+// expiry, or whose nonces are already taken by other transactions.
+func (p *txPool) evictStaleGappedTxs(
+	now time.Time, nextNonce func(proto.AccountAddress) (pi.AccountNonce, error)) (
+	evicted []pi.Transaction,
+) {
+	for addr, txs := range p.gapped {
+		next, err := nextNonce(addr)
+		for nonce, gt := range txs {
+			if err == nil && nonce >= next && now.Sub(gt.received) <= p.limits.gapExpiry {
+				continue
+			}
+			evicted = append(evicted, gt.tx)
+			p.deleteGappedTx(addr, nonce)
+		}
+	}
 	return
 }
 
 func (p *txPool) halfDeepCopy() (cpy *txPool) {
-	cpy = newTxPool()
+	cpy = newTxPoolWithLimits(p.limits)
 	for k, v := range p.entries {
 		cpy.entries[k] = v.halfDeepCopy()
 	}
+	for k, v := range p.gapped {
+		var txs = make(map[pi.AccountNonce]*gappedTx, len(v))
+		for n, gt := range v {
+			txs[n] = gt
+		}
+		cpy.gapped[k] = txs
+	}
+	cpy.size = p.size
+	cpy.gappedSize = p.gappedSize
+	return
+}
+
+// cleanCopy returns an empty pool with the same limits and gapped transactions of p.
+func (p *txPool) cleanCopy() (cpy *txPool) {
+	cpy = p.halfDeepCopy()
+	cpy.entries = make(map[proto.AccountAddress]*accountTxEntries)
+	cpy.size = 0
+	return
+}
+
+// txLess reports whether x should be packed after y: the one paying a lower fee comes later, and
+// the hash is compared for transactions paying the same fee to keep the order deterministic.
+func txLess(x, y pi.Transaction) bool {
+	if xf, yf := x.GetFee(), y.GetFee(); xf != yf {
+		return xf < yf
+	}
+	xh, yh := x.GetHash(), y.GetHash()
+	return bytes.Compare(xh[:], yh[:]) > 0
+}
+
+// txCursor iterates the pooled transactions of an account.
+type txCursor struct {
+	txs []pi.Transaction
+	pos int
+}
+
+func (c *txCursor) head() pi.Transaction {
+	return c.txs[c.pos]
+}
+
+func (c *txCursor) next() bool {
+	c.pos++
+	return c.pos < len(c.txs)
+}
+
+// txQueue is a max heap of txCursor ordered by the fee of the head transaction.
+type txQueue []*txCursor
+
+func (q txQueue) Len() int           { return len(q) }
+func (q txQueue) Less(i, j int) bool { return txLess(q[j].head(), q[i].head()) }
+func (q txQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *txQueue) Push(x interface{}) {
+	*q = append(*q, x.(*txCursor))
+}
+
+func (q *txQueue) Pop() interface{} {
+	var (
+		old = *q
+		n   = len(old)
+		c   = old[n-1]
+	)
+	*q = old[:n-1]
+	return c
+}
+
+// replayPool replays the pooled transactions of p upon readonly. Each step takes the transaction
+// paying the highest fee among the next ones of all accounts, so that transactions are ordered by
+// fee while the nonce order of each account is kept. A transaction which fails to apply is held
+// until the state is changed by another one, and it is dropped with its successors if no more
+// transaction can be applied.
+func replayPool(readonly *metaIndex, producers map[proto.AccountAddress]bool, p *txPool) (
+	dirty *metaIndex, replayed, dropped []pi.Transaction,
+) {
+	var (
+		cm = &metaState{
+			dirty:     newMetaIndex(),
+			readonly:  readonly,
+			producers: producers,
+		}
+		q       = make(txQueue, 0, len(p.entries))
+		blocked []*txCursor
+	)
+	for _, v := range p.entries {
+		if len(v.transacions) > 0 {
+			q = append(q, &txCursor{txs: v.transacions})
+		}
+	}
+	heap.Init(&q)
+	for q.Len() > 0 {
+		var c = heap.Pop(&q).(*txCursor)
+		if err := cm.replayTransaction(c.head()); err != nil {
+			blocked = append(blocked, c)
+			continue
+		}
+		replayed = append(replayed, c.head())
+		if c.next() {
+			heap.Push(&q, c)
+		}
+		// The state is changed, so the blocked transactions may be applicable now
+		for _, v := range blocked {
+			heap.Push(&q, v)
+		}
+		blocked = blocked[:0]
+	}
+	for _, v := range blocked {
+		dropped = append(dropped, v.txs[v.pos:]...)
+	}
+	dirty = cm.dirty
 	return
 }
@@ -15,3 +15,251 @@
  */
 
 package blockproducer
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/coreos/bbolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestFeeTransfer(
+	sender, receiver proto.AccountAddress, nonce pi.AccountNonce, amount, fee uint64,
+) *pt.Transfer {
+	t := &pt.Transfer{
+		TransferHeader: pt.TransferHeader{
+			Sender:   sender,
+			Receiver: receiver,
+			Nonce:    nonce,
+			Amount:   amount,
+			Fee:      fee,
+		},
+	}
+	So(t.Sign(testPrivKey), ShouldBeNil)
+	return t
+}
+
+func TestTxPool(t *testing.T) {
+	Convey("Given a metaState with some accounts", t, func() {
+		var (
+			addr1   = proto.AccountAddress{0x0, 0x0, 0x0, 0x1}
+			addr2   = proto.AccountAddress{0x0, 0x0, 0x0, 0x2}
+			addr3   = proto.AccountAddress{0x0, 0x0, 0x0, 0x3}
+			addr4   = proto.AccountAddress{0x0, 0x0, 0x0, 0x4}
+			ms      = newMetaState()
+			now     = time.Now()
+			fl      = path.Join(testDataDir, t.Name())
+			db, err = bolt.Open(fl, 0600, nil)
+			add     = func(tx pi.Transaction) error {
+				return db.Update(ms.addTransactionProcedure(tx, now))
+			}
+			balance = func(addr proto.AccountAddress) uint64 {
+				b, loaded := ms.loadAccountStableBalance(addr)
+				So(loaded, ShouldBeTrue)
+				return b
+			}
+		)
+		So(err, ShouldBeNil)
+		Reset(func() {
+			So(db.Close(), ShouldBeNil)
+			So(os.Remove(fl), ShouldBeNil)
+		})
+		err = db.Update(func(tx *bolt.Tx) (err error) {
+			var meta, txbk *bolt.Bucket
+			if meta, err = tx.CreateBucket(metaBucket[:]); err != nil {
+				return
+			}
+			for _, v := range [][]byte{
+				metaAccountIndexBucket, metaSQLChainIndexBucket,
+				metaAccountTxIndexBucket, metaTxAccountIndexBucket,
+			} {
+				if _, err = meta.CreateBucket(v); err != nil {
+					return
+				}
+			}
+			if txbk, err = meta.CreateBucket(metaTransactionBucket); err != nil {
+				return
+			}
+			for i := pi.TransactionType(0); i < pi.TransactionTypeNumber; i++ {
+				if _, err = txbk.CreateBucket(i.Bytes()); err != nil {
+					return
+				}
+			}
+			return
+		})
+		So(err, ShouldBeNil)
+		for _, v := range []proto.AccountAddress{addr1, addr2, addr3} {
+			_, loaded := ms.loadOrStoreAccountObject(v, &accountObject{
+				Account: pt.Account{
+					Address:           v,
+					StableCoinBalance: 100,
+				},
+			})
+			So(loaded, ShouldBeFalse)
+		}
+		_, loaded := ms.loadOrStoreAccountObject(addr4, &accountObject{
+			Account: pt.Account{Address: addr4},
+		})
+		So(loaded, ShouldBeFalse)
+		So(db.Update(ms.commitProcedure()), ShouldBeNil)
+
+		Convey("The fee should be charged from the sender", func() {
+			So(add(newTestFeeTransfer(addr1, addr2, 0, 10, 3)), ShouldBeNil)
+			So(balance(addr1), ShouldEqual, 87)
+			So(balance(addr2), ShouldEqual, 110)
+			Convey("The fee should be refunded if the transaction fails", func() {
+				So(add(newTestFeeTransfer(addr1, addr2, 1, 85, 3)), ShouldEqual,
+					ErrInsufficientBalance)
+				So(balance(addr1), ShouldEqual, 87)
+				So(ms.pool.size, ShouldEqual, 1)
+				So(add(newTestFeeTransfer(addr4, addr2, 0, 0, 1)), ShouldEqual,
+					ErrInsufficientBalance)
+				So(balance(addr4), ShouldEqual, 0)
+			})
+		})
+		Convey("The pool should refuse transactions beyond the account limit", func() {
+			ms.pool.limits.maxAccountTxs = 2
+			So(add(newTestFeeTransfer(addr1, addr2, 0, 1, 0)), ShouldBeNil)
+			So(add(newTestFeeTransfer(addr1, addr2, 1, 1, 0)), ShouldBeNil)
+			So(add(newTestFeeTransfer(addr1, addr2, 2, 1, 0)), ShouldEqual, ErrAccountTxPoolFull)
+			So(add(newTestFeeTransfer(addr2, addr1, 0, 1, 0)), ShouldBeNil)
+			So(ms.pool.size, ShouldEqual, 3)
+			// Not limited for transactions from blocks
+			So(db.Update(ms.applyTransactionProcedure(
+				newTestFeeTransfer(addr1, addr2, 2, 1, 0))), ShouldBeNil)
+			So(ms.pool.size, ShouldEqual, 4)
+		})
+		Convey("The pool should evict the transaction paying the lowest fee when it's full", func() {
+			ms.pool.limits.maxTxs = 3
+			var (
+				t1 = newTestFeeTransfer(addr1, addr3, 0, 10, 1)
+				t2 = newTestFeeTransfer(addr2, addr1, 0, 10, 2)
+				t3 = newTestFeeTransfer(addr2, addr1, 1, 10, 4)
+				// depends on t1
+				t4 = newTestFeeTransfer(addr3, addr4, 0, 105, 3)
+			)
+			So(add(t1), ShouldBeNil)
+			So(add(t2), ShouldBeNil)
+			So(add(t4), ShouldBeNil)
+			So(add(newTestFeeTransfer(addr4, addr1, 0, 1, 1)), ShouldEqual, ErrTxPoolFull)
+			// t1 is evicted, and t4 is dropped
+			So(add(t3), ShouldBeNil)
+			So(ms.pool.hasTx(t1), ShouldBeFalse)
+			So(ms.pool.hasTx(t4), ShouldBeFalse)
+			So(ms.pool.hasTx(t2), ShouldBeTrue)
+			So(ms.pool.hasTx(t3), ShouldBeTrue)
+			So(ms.pool.size, ShouldEqual, 2)
+			So(balance(addr1), ShouldEqual, 120)
+			So(balance(addr2), ShouldEqual, 74)
+			So(balance(addr3), ShouldEqual, 100)
+			So(balance(addr4), ShouldEqual, 0)
+			n, err := ms.nextNonce(addr1)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+			n, err = ms.nextNonce(addr3)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+		Convey("The pool should keep gapped transactions until the gap is filled", func() {
+			var (
+				t1 = newTestFeeTransfer(addr1, addr2, 0, 1, 0)
+				t2 = newTestFeeTransfer(addr1, addr2, 1, 1, 0)
+				t3 = newTestFeeTransfer(addr1, addr2, 2, 1, 0)
+			)
+			So(add(t3), ShouldBeNil)
+			So(add(t2), ShouldBeNil)
+			So(add(newTestFeeTransfer(addr1, addr2, 2, 2, 0)), ShouldEqual,
+				ErrInvalidAccountNonce)
+			So(add(newTestFeeTransfer(addr1, addr2, 100, 1, 0)), ShouldEqual,
+				ErrInvalidAccountNonce)
+			So(ms.pool.hasGappedTx(t2), ShouldBeTrue)
+			So(ms.pool.hasGappedTx(t3), ShouldBeTrue)
+			So(ms.pool.size, ShouldEqual, 0)
+			So(balance(addr1), ShouldEqual, 100)
+			// Not gapped for transactions from blocks
+			So(db.Update(ms.applyTransactionProcedure(
+				newTestFeeTransfer(addr2, addr1, 1, 1, 0))), ShouldEqual, ErrInvalidAccountNonce)
+			So(ms.pool.gappedSize, ShouldEqual, 2)
+
+			So(add(t1), ShouldBeNil)
+			So(ms.pool.gappedSize, ShouldEqual, 0)
+			So(ms.pool.hasTx(t1), ShouldBeTrue)
+			So(ms.pool.hasTx(t2), ShouldBeTrue)
+			So(ms.pool.hasTx(t3), ShouldBeTrue)
+			So(balance(addr1), ShouldEqual, 97)
+			n, err := ms.nextNonce(addr1)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+		})
+		Convey("The pool should refuse gapped transactions beyond the limits", func() {
+			ms.pool.limits.maxAccountGappedTxs = 2
+			ms.pool.limits.maxGappedTxs = 3
+			So(add(newTestFeeTransfer(addr1, addr2, 1, 1, 0)), ShouldBeNil)
+			So(add(newTestFeeTransfer(addr1, addr2, 3, 1, 0)), ShouldEqual,
+				ErrInvalidAccountNonce)
+			So(add(newTestFeeTransfer(addr1, addr2, 2, 1, 0)), ShouldBeNil)
+			So(add(newTestFeeTransfer(addr2, addr1, 2, 1, 0)), ShouldBeNil)
+			So(add(newTestFeeTransfer(addr2, addr1, 1, 1, 0)), ShouldEqual, ErrTxPoolFull)
+		})
+		Convey("The pool should evict stale gapped transactions", func() {
+			var (
+				t1 = newTestFeeTransfer(addr1, addr2, 1, 1, 0)
+				t2 = newTestFeeTransfer(addr2, addr1, 1, 1, 0)
+				t3 = newTestFeeTransfer(addr3, addr1, 1, 1, 0)
+			)
+			So(add(t1), ShouldBeNil)
+			now = now.Add(ms.pool.limits.gapExpiry / 2)
+			So(add(t2), ShouldBeNil)
+			So(add(t3), ShouldBeNil)
+			now = now.Add(ms.pool.limits.gapExpiry/2 + time.Second)
+			So(add(newTestFeeTransfer(addr4, addr1, 0, 0, 0)), ShouldBeNil)
+			So(ms.pool.hasGappedTx(t1), ShouldBeFalse)
+			So(ms.pool.hasGappedTx(t2), ShouldBeTrue)
+			So(ms.pool.hasGappedTx(t3), ShouldBeTrue)
+			So(ms.pool.gappedSize, ShouldEqual, 2)
+
+			// The gaps are filled by the transactions from a block
+			var (
+				t4 = newTestFeeTransfer(addr2, addr1, 0, 1, 0)
+				t5 = newTestFeeTransfer(addr2, addr1, 1, 2, 0)
+				t6 = newTestFeeTransfer(addr3, addr1, 0, 1, 0)
+			)
+			for _, v := range []pi.Transaction{t4, t5, t6} {
+				So(db.Update(ms.applyTransactionProcedure(v)), ShouldBeNil)
+			}
+			So(ms.pool.gappedSize, ShouldEqual, 2)
+			// t2 is stale since its nonce is taken by t5, and t3 is promoted
+			So(add(newTestFeeTransfer(addr4, addr1, 1, 0, 0)), ShouldBeNil)
+			So(ms.pool.gappedSize, ShouldEqual, 0)
+			So(ms.pool.hasTx(t2), ShouldBeFalse)
+			So(ms.pool.hasTx(t5), ShouldBeTrue)
+			So(ms.pool.hasTx(t3), ShouldBeTrue)
+			So(balance(addr3), ShouldEqual, 98)
+		})
+		Convey("The transactions should be pulled in fee order", func() {
+			var (
+				t1 = newTestFeeTransfer(addr1, addr4, 0, 10, 1)
+				t2 = newTestFeeTransfer(addr1, addr2, 1, 10, 10)
+				t3 = newTestFeeTransfer(addr2, addr1, 0, 10, 5)
+				t4 = newTestFeeTransfer(addr3, addr1, 0, 10, 3)
+				// depends on t1
+				t5 = newTestFeeTransfer(addr4, addr1, 0, 5, 4)
+			)
+			for _, v := range []pi.Transaction{t1, t2, t3, t4, t5} {
+				So(add(v), ShouldBeNil)
+			}
+			var txs = ms.pullTxs()
+			So(txs, ShouldResemble, []pi.Transaction{t3, t4, t1, t2, t5})
+			So(db.Update(ms.partialCommitProcedure(txs)), ShouldBeNil)
+			So(ms.pool.size, ShouldEqual, 0)
+			So(balance(addr1), ShouldEqual, 94)
+			So(balance(addr4), ShouldEqual, 1)
+		})
+	})
+}
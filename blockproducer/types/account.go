@@ -57,11 +57,17 @@ type SQLChainUser struct {
 
 // SQLChainProfile defines a SQLChainProfile related to an account.
 type SQLChainProfile struct {
-	ID      proto.DatabaseID
+	ID proto.DatabaseID
+	// Deposit is the prepaid stable coin balance, billing is charged from deposit first.
 	Deposit uint64
-	Owner   proto.AccountAddress
-	Miners  []proto.AccountAddress
-	Users   []*SQLChainUser
+	// Arrears is the billing amount the payer failed to settle, database in arrears should be
+	// suspended.
+	Arrears uint64
+	// NextBillingHeight is the lowest block height of the sqlchain which is not billed yet.
+	NextBillingHeight int32
+	Owner             proto.AccountAddress
+	Miners            []proto.AccountAddress
+	Users             []*SQLChainUser
 }
 
 // Account store its balance, and other mate data.
@@ -71,4 +77,6 @@ type Account struct {
 	CovenantCoinBalance uint64
 	Rating              float64
 	NextNonce           pi.AccountNonce
+	// NextDepositNonce is the nonce of the next deposit authorization signed by the account.
+	NextDepositNonce pi.AccountNonce
 }
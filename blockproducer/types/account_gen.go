@@ -10,31 +10,37 @@ import (
 func (z *Account) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 5
-	o = append(o, 0x85, 0x85)
+	// map header, size 6
+	o = append(o, 0x86, 0x86)
 	if oTemp, err := z.NextNonce.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x86)
+	if oTemp, err := z.NextDepositNonce.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = append(o, 0x86)
 	o = hsp.AppendFloat64(o, z.Rating)
-	o = append(o, 0x85)
+	o = append(o, 0x86)
 	if oTemp, err := z.Address.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x86)
 	o = hsp.AppendUint64(o, z.StableCoinBalance)
-	o = append(o, 0x85)
+	o = append(o, 0x86)
 	o = hsp.AppendUint64(o, z.CovenantCoinBalance)
 	return
 }
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *Account) Msgsize() (s int) {
-	s = 1 + 10 + z.NextNonce.Msgsize() + 7 + hsp.Float64Size + 8 + z.Address.Msgsize() + 18 + hsp.Uint64Size + 20 + hsp.Uint64Size
+	s = 1 + 10 + z.NextNonce.Msgsize() + 17 + z.NextDepositNonce.Msgsize() + 7 + hsp.Float64Size + 8 + z.Address.Msgsize() + 18 + hsp.Uint64Size + 20 + hsp.Uint64Size
 	return
 }
 
@@ -42,8 +48,8 @@ func (z *Account) Msgsize() (s int) {
 func (z *SQLChainProfile) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 5
-	o = append(o, 0x85, 0x85)
+	// map header, size 7
+	o = append(o, 0x87, 0x87)
 	o = hsp.AppendArrayHeader(o, uint32(len(z.Users)))
 	for za0002 := range z.Users {
 		if z.Users[za0002] == nil {
@@ -60,7 +66,7 @@ func (z *SQLChainProfile) MarshalHash() (o []byte, err error) {
 			o = hsp.AppendInt32(o, int32(z.Users[za0002].Permission))
 		}
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x87)
 	o = hsp.AppendArrayHeader(o, uint32(len(z.Miners)))
 	for za0001 := range z.Miners {
 		if oTemp, err := z.Miners[za0001].MarshalHash(); err != nil {
@@ -69,20 +75,24 @@ func (z *SQLChainProfile) MarshalHash() (o []byte, err error) {
 			o = hsp.AppendBytes(o, oTemp)
 		}
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x87)
 	if oTemp, err := z.Owner.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x87)
 	if oTemp, err := z.ID.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x87)
 	o = hsp.AppendUint64(o, z.Deposit)
+	o = append(o, 0x87)
+	o = hsp.AppendUint64(o, z.Arrears)
+	o = append(o, 0x87)
+	o = hsp.AppendInt32(o, z.NextBillingHeight)
 	return
 }
 
@@ -100,7 +110,7 @@ func (z *SQLChainProfile) Msgsize() (s int) {
 	for za0001 := range z.Miners {
 		s += z.Miners[za0001].Msgsize()
 	}
-	s += 6 + z.Owner.Msgsize() + 3 + z.ID.Msgsize() + 8 + hsp.Uint64Size
+	s += 6 + z.Owner.Msgsize() + 3 + z.ID.Msgsize() + 8 + hsp.Uint64Size + 8 + hsp.Uint64Size + 18 + hsp.Int32Size
 	return
 }
 
@@ -60,6 +60,12 @@ func (b *BaseAccount) GetAccountNonce() pi.AccountNonce {
 	return pi.AccountNonce(0)
 }
 
+// GetFee implements interfaces/Transaction.GetFee.
+func (b *BaseAccount) GetFee() uint64 {
+	// BaseAccount is only issued in the genesis block, which is free of charge.
+	return 0
+}
+
 // GetHash implements interfaces/Transaction.GetHash.
 func (b *BaseAccount) GetHash() hash.Hash {
 	return b.AccountHash
@@ -71,7 +77,7 @@ func (b *BaseAccount) GetTransactionType() pi.TransactionType {
 }
 
 // Sign implements interfaces/Transaction.Sign.
-func (b *BaseAccount) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (b *BaseAccount) Sign(signer asymmetric.Signer) (err error) {
 	return
 }
 
@@ -93,7 +93,7 @@ func (br *BillingRequest) PackRequestHeader() (*hash.Hash, error) {
 }
 
 // SignRequestHeader first computes the hash of BillingRequestHeader, then signs the request.
-func (br *BillingRequest) SignRequestHeader(signee *asymmetric.PrivateKey) (*asymmetric.Signature, error) {
+func (br *BillingRequest) SignRequestHeader(signee asymmetric.Signer) (*asymmetric.Signature, error) {
 	signature, err := signee.Sign(br.RequestHash[:])
 	if err != nil {
 		return nil, err
@@ -37,7 +37,10 @@ type Header struct {
 	Producer   proto.AccountAddress
 	MerkleRoot hash.Hash
 	ParentHash hash.Hash
-	Timestamp  time.Time
+	// StateRoot is the merkle root of the main chain state after applying the block, which
+	// commits to the accounts and the SQLChain profiles, see AccountStateKey and DatabaseStateKey.
+	StateRoot hash.Hash
+	Timestamp time.Time
 }
 
 // SignedHeader defines the main chain header with the signature.
@@ -62,6 +65,8 @@ type Block struct {
 	SignedHeader SignedHeader
 	TxBillings   []*TxBilling
 	Transactions []pi.Transaction
+	// Commits are the precommit votes of the block producers, which finalize the block.
+	Commits []*Vote
 }
 
 // GetTxHashes returns all hashes of tx in block.{TxBillings, ...}
@@ -81,7 +86,7 @@ func (b *Block) GetTxHashes() []*hash.Hash {
 }
 
 // PackAndSignBlock computes block's hash and sign it.
-func (b *Block) PackAndSignBlock(signer *asymmetric.PrivateKey) error {
+func (b *Block) PackAndSignBlock(signer asymmetric.Signer) error {
 	hs := b.GetTxHashes()
 
 	b.SignedHeader.MerkleRoot = *merkle.NewMerkle(hs).GetRoot()
@@ -110,42 +115,122 @@ func enumType(t pi.TransactionType) (i pi.Transaction) {
 		i = (*Transfer)(nil)
 	case pi.TransactionTypeBaseAccount:
 		i = (*BaseAccount)(nil)
+	case pi.TransactionTypeCreateDatabase:
+		i = (*CreateDatabase)(nil)
+	case pi.TransactionTypeDropDatabase:
+		i = (*DropDatabase)(nil)
+	case pi.TransactionTypeTopUp:
+		i = (*TopUp)(nil)
 	}
 	return
 }
 
+// DecodeTransaction decodes a transaction of type t from its serialized form.
+func DecodeTransaction(t pi.TransactionType, enc []byte) (tx pi.Transaction, err error) {
+	rt := reflect.TypeOf(enumType(t))
+	if rt == nil {
+		return nil, ErrUnknownTransactionType
+	}
+	tx = reflect.New(rt.Elem()).Interface().(pi.Transaction)
+	if err = tx.Deserialize(enc); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// typedTransaction defines the encoding form of a transaction with its type, so that it can be
+// decoded to the concrete type.
+type typedTransaction struct {
+	Type pi.TransactionType
+	Data []byte
+}
+
+// blockSerialVersion is the version byte leading a serialized block. The blocks serialized before
+// versioning are not decodable: their transactions are not typed and their headers have no state
+// root, so a chain started before versioning has to be re-created from a new genesis.
+const blockSerialVersion byte = 0x01
+
+// blockPayload defines the encoding form of a block.
+type blockPayload struct {
+	SignedHeader SignedHeader
+	TxBillings   []*TxBilling
+	Transactions []*typedTransaction
+	Commits      []*Vote
+}
+
 // Serialize converts block to bytes.
 func (b *Block) Serialize() ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
+	p := &blockPayload{
+		SignedHeader: b.SignedHeader,
+		TxBillings:   b.TxBillings,
+		Transactions: make([]*typedTransaction, 0, len(b.Transactions)),
+		Commits:      b.Commits,
+	}
+	for _, v := range b.Transactions {
+		enc, err := v.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		p.Transactions = append(p.Transactions, &typedTransaction{
+			Type: v.GetTransactionType(),
+			Data: enc,
+		})
+	}
+
+	buf := bytes.NewBuffer([]byte{blockSerialVersion})
 	hd := codec.MsgpackHandle{
 		WriteExt:    true,
 		RawToString: true,
 	}
 	enc := codec.NewEncoder(buf, &hd)
-	err := enc.Encode(b)
+	err := enc.Encode(p)
 	return buf.Bytes(), err
 }
 
 // Deserialize converts bytes to block.
 func (b *Block) Deserialize(buf []byte) error {
-	r := bytes.NewBuffer(buf)
+	if len(buf) == 0 || buf[0] != blockSerialVersion {
+		return ErrUnknownBlockVersion
+	}
+
+	r := bytes.NewBuffer(buf[1:])
 	hd := codec.MsgpackHandle{
 		WriteExt:    true,
 		RawToString: true,
 	}
 
-	for i := pi.TransactionType(0); i < pi.TransactionTypeNumber; i++ {
-		err := hd.Intf2Impl(
-			reflect.TypeOf((*pi.Transaction)(nil)).Elem(),
-			reflect.TypeOf(enumType(i)),
-		)
+	p := &blockPayload{}
+	dec := codec.NewDecoder(r, &hd)
+	if err := dec.Decode(p); err != nil {
+		return err
+	}
+
+	b.SignedHeader = p.SignedHeader
+	b.TxBillings = p.TxBillings
+	b.Transactions = nil
+	b.Commits = p.Commits
+	for _, v := range p.Transactions {
+		if v == nil {
+			return ErrUnknownTransactionType
+		}
+		tx, err := DecodeTransaction(v.Type, v.Data)
 		if err != nil {
 			return err
 		}
+		b.Transactions = append(b.Transactions, tx)
 	}
+	return nil
+}
 
-	dec := codec.NewDecoder(r, &hd)
-	return dec.Decode(b)
+// MarshalBinary implements encoding.BinaryMarshaler, which is used by the msgpack codec to encode
+// the block in rpc messages.
+func (b *Block) MarshalBinary() ([]byte, error) {
+	return b.Serialize()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (b *Block) UnmarshalBinary(data []byte) error {
+	return b.Deserialize(data)
 }
 
 // PushTx pushes txes into block.
@@ -10,14 +10,27 @@ import (
 func (z *Block) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 2
-	o = append(o, 0x82, 0x82)
+	// map header, size 3
+	o = append(o, 0x83, 0x83)
 	if oTemp, err := z.SignedHeader.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x82)
+	o = append(o, 0x83)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Commits)))
+	for za0002 := range z.Commits {
+		if z.Commits[za0002] == nil {
+			o = hsp.AppendNil(o)
+		} else {
+			if oTemp, err := z.Commits[za0002].MarshalHash(); err != nil {
+				return nil, err
+			} else {
+				o = hsp.AppendBytes(o, oTemp)
+			}
+		}
+	}
+	o = append(o, 0x83)
 	o = hsp.AppendArrayHeader(o, uint32(len(z.TxBillings)))
 	for za0001 := range z.TxBillings {
 		if z.TxBillings[za0001] == nil {
@@ -35,7 +48,15 @@ func (z *Block) MarshalHash() (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *Block) Msgsize() (s int) {
-	s = 1 + 13 + z.SignedHeader.Msgsize() + 11 + hsp.ArrayHeaderSize
+	s = 1 + 13 + z.SignedHeader.Msgsize() + 8 + hsp.ArrayHeaderSize
+	for za0002 := range z.Commits {
+		if z.Commits[za0002] == nil {
+			s += hsp.NilSize
+		} else {
+			s += z.Commits[za0002].Msgsize()
+		}
+	}
+	s += 11 + hsp.ArrayHeaderSize
 	for za0001 := range z.TxBillings {
 		if z.TxBillings[za0001] == nil {
 			s += hsp.NilSize
@@ -50,35 +71,41 @@ func (z *Block) Msgsize() (s int) {
 func (z *Header) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 5
-	o = append(o, 0x85, 0x85)
+	// map header, size 6
+	o = append(o, 0x86, 0x86)
 	if oTemp, err := z.MerkleRoot.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x86)
 	if oTemp, err := z.ParentHash.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x86)
+	if oTemp, err := z.StateRoot.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = append(o, 0x86)
 	o = hsp.AppendInt32(o, z.Version)
-	o = append(o, 0x85)
+	o = append(o, 0x86)
 	if oTemp, err := z.Producer.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x85)
+	o = append(o, 0x86)
 	o = hsp.AppendTime(o, z.Timestamp)
 	return
 }
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *Header) Msgsize() (s int) {
-	s = 1 + 11 + z.MerkleRoot.Msgsize() + 11 + z.ParentHash.Msgsize() + 8 + hsp.Int32Size + 9 + z.Producer.Msgsize() + 10 + hsp.TimeSize
+	s = 1 + 11 + z.MerkleRoot.Msgsize() + 11 + z.ParentHash.Msgsize() + 10 + z.StateRoot.Msgsize() + 8 + hsp.Int32Size + 9 + z.Producer.Msgsize() + 10 + hsp.TimeSize
 	return
 }
 
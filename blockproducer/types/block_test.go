@@ -23,6 +23,8 @@ import (
 
 	"bytes"
 
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -156,3 +158,114 @@ func TestOther_MarshalHash(t *testing.T) {
 		So(tm.String(), ShouldResemble, "TxBilling")
 	})
 }
+
+func TestDecodeTransaction(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	tx := &Transfer{TransferHeader: TransferHeader{Amount: 10}}
+	if err = tx.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	enc, err := tx.Serialize()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	dec, err := DecodeTransaction(pi.TransactionTypeTransfer, enc)
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = dec.Verify(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if tr, ok := dec.(*Transfer); !ok || tr.HeaderHash != tx.HeaderHash || tr.Amount != tx.Amount {
+		t.Fatalf("Value not match:\n\tv1 = %+v\n\tv2 = %+v", tx, dec)
+	}
+
+	if _, err = DecodeTransaction(pi.TransactionTypeNumber, enc); err != ErrUnknownTransactionType {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+}
+
+func TestBlock_TypedTransactions(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	var (
+		tr = &Transfer{TransferHeader: TransferHeader{Amount: 10}}
+		ba = &BaseAccount{Account: Account{StableCoinBalance: 10}}
+	)
+	if err = tr.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	block := &Block{Transactions: []pi.Transaction{tr, ba}}
+	if err = block.PackAndSignBlock(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Encode block within a message as the rpc codec does
+	type message struct {
+		Block *Block
+	}
+	enc, err := utils.EncodeMsgPack(&message{Block: block})
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	dec := &message{}
+	if err = utils.DecodeMsgPack(enc.Bytes(), dec); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = dec.Block.Verify(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if len(dec.Block.Transactions) != 2 {
+		t.Fatalf("Unexpected transaction count: %d", len(dec.Block.Transactions))
+	}
+	if v, ok := dec.Block.Transactions[0].(*Transfer); !ok || v.Amount != tr.Amount {
+		t.Fatalf("Value not match:\n\tv1 = %+v\n\tv2 = %+v", tr, dec.Block.Transactions[0])
+	}
+	if v, ok := dec.Block.Transactions[1].(*BaseAccount); !ok || v.StableCoinBalance != ba.StableCoinBalance {
+		t.Fatalf("Value not match:\n\tv1 = %+v\n\tv2 = %+v", ba, dec.Block.Transactions[1])
+	}
+}
+
+func TestBlock_SerialVersion(t *testing.T) {
+	block, err := generateRandomBlock(genesisHash, false)
+	if err != nil {
+		t.Fatalf("Failed to generate block: %v", err)
+	}
+	enc, err := block.Serialize()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if enc[0] != blockSerialVersion {
+		t.Fatalf("Unexpected version byte: %#x", enc[0])
+	}
+
+	dec := &Block{}
+	if err = dec.Deserialize(enc); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if !dec.SignedHeader.BlockHash.IsEqual(&block.SignedHeader.BlockHash) {
+		t.Fatalf("Value not match:\n\tv1 = %+v\n\tv2 = %+v", block, dec)
+	}
+	if err = dec.Verify(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// The blocks serialized before versioning are not decodable
+	if err = dec.Deserialize(enc[1:]); err != ErrUnknownBlockVersion {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = dec.Deserialize(nil); err != ErrUnknownBlockVersion {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	enc[0] = blockSerialVersion + 1
+	if err = dec.Deserialize(enc); err != ErrUnknownBlockVersion {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"bytes"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+//go:generate hsp
+
+// CreateDatabaseHeader defines the database creation transaction header.
+type CreateDatabaseHeader struct {
+	// Issuer is the block producer account which issues the transaction on behalf of the owner.
+	Issuer, Owner proto.AccountAddress
+	DatabaseID    proto.DatabaseID
+	Nonce         pi.AccountNonce
+	// Deposit is the stable coin amount locked from owner into the database profile.
+	Deposit uint64
+	// Fee is the stable coin amount paid by issuer for the transaction to be packed.
+	Fee uint64
+	// Authorization is signed by owner to allow the deposit to be locked.
+	Authorization DepositAuthorization
+	// Miners are the accounts of the miners allocated to serve the database, which are the only
+	// receivers of its billings.
+	Miners []proto.AccountAddress
+}
+
+// CreateDatabase defines the database creation transaction.
+type CreateDatabase struct {
+	CreateDatabaseHeader
+	HeaderHash hash.Hash
+	Signee     *asymmetric.PublicKey
+	Signature  *asymmetric.Signature
+}
+
+// NewCreateDatabase returns new instance.
+func NewCreateDatabase(header *CreateDatabaseHeader) *CreateDatabase {
+	return &CreateDatabase{
+		CreateDatabaseHeader: *header,
+	}
+}
+
+// Serialize serializes CreateDatabase using msgpack.
+func (t *CreateDatabase) Serialize() (b []byte, err error) {
+	var enc *bytes.Buffer
+	if enc, err = utils.EncodeMsgPack(t); err != nil {
+		return
+	}
+	b = enc.Bytes()
+	return
+}
+
+// Deserialize desrializes CreateDatabase using msgpack.
+func (t *CreateDatabase) Deserialize(enc []byte) error {
+	return utils.DecodeMsgPack(enc, t)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (t *CreateDatabase) GetAccountAddress() proto.AccountAddress {
+	return t.Issuer
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (t *CreateDatabase) GetAccountNonce() pi.AccountNonce {
+	return t.Nonce
+}
+
+// GetFee implements interfaces/Transaction.GetFee.
+func (t *CreateDatabase) GetFee() uint64 {
+	return t.Fee
+}
+
+// GetHash implements interfaces/Transaction.GetHash.
+func (t *CreateDatabase) GetHash() hash.Hash {
+	return t.HeaderHash
+}
+
+// GetTransactionType implements interfaces/Transaction.GetTransactionType.
+func (t *CreateDatabase) GetTransactionType() pi.TransactionType {
+	return pi.TransactionTypeCreateDatabase
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (t *CreateDatabase) Sign(signer asymmetric.Signer) (err error) {
+	var enc []byte
+	if enc, err = t.CreateDatabaseHeader.MarshalHash(); err != nil {
+		return
+	}
+	var h = hash.THashH(enc)
+	if t.Signature, err = signer.Sign(h[:]); err != nil {
+		return
+	}
+	t.HeaderHash = h
+	t.Signee = signer.PubKey()
+	return
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (t *CreateDatabase) Verify() (err error) {
+	var enc []byte
+	if enc, err = t.CreateDatabaseHeader.MarshalHash(); err != nil {
+		return
+	} else if h := hash.THashH(enc); !t.HeaderHash.IsEqual(&h) {
+		err = ErrSignVerification
+		return
+	} else if !t.Signature.Verify(h[:], t.Signee) {
+		err = ErrSignVerification
+		return
+	}
+	// The transaction can only be signed by the issuer itself
+	var addr proto.AccountAddress
+	if addr, err = utils.PubKeyHash(t.Signee); err != nil {
+		return
+	} else if addr != t.Issuer {
+		err = ErrSignVerification
+		return
+	}
+	// The deposit must be authorized by the owner
+	if t.Authorization.Owner != t.Owner {
+		err = ErrSignVerification
+		return
+	}
+	return t.Authorization.Verify()
+}
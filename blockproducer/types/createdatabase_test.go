@@ -0,0 +1,89 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+func TestCreateDatabase_SignAndVerify(t *testing.T) {
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	issuer, err := utils.PubKeyHash(pub)
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	tx := NewCreateDatabase(&CreateDatabaseHeader{
+		Issuer:     issuer,
+		Owner:      issuer,
+		Deposit:    100,
+		DatabaseID: proto.DatabaseID("db"),
+	})
+	if err = tx.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Deposit without owner authorization should be rejected
+	if err = tx.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	tx.Authorization.Owner = issuer
+	tx.Authorization.MaxDeposit = 100
+	if err = tx.Authorization.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = tx.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = tx.Verify(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Authorization signed by others should be rejected
+	otherPriv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = tx.Authorization.Sign(otherPriv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = tx.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = tx.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = tx.Authorization.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Issuing for others should be rejected
+	tx.Issuer = proto.AccountAddress{}
+	if err = tx.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = tx.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+}
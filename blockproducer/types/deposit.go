@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+//go:generate hsp
+
+// DepositAuthorizationHeader defines the deposit authorization header.
+type DepositAuthorizationHeader struct {
+	Owner proto.AccountAddress
+	// Nonce should equal to the next deposit nonce of owner, so that the authorization can be
+	// used only once.
+	Nonce pi.AccountNonce
+	// MaxDeposit is the max stable coin amount allowed to be locked from owner.
+	MaxDeposit uint64
+}
+
+// DepositAuthorization defines the authorization signed by the owner to lock deposit from its
+// stable coin balance into a new database.
+type DepositAuthorization struct {
+	DepositAuthorizationHeader
+	HeaderHash hash.Hash
+	Signee     *asymmetric.PublicKey
+	Signature  *asymmetric.Signature
+}
+
+// Sign signs the authorization header.
+func (a *DepositAuthorization) Sign(signer asymmetric.Signer) (err error) {
+	var enc []byte
+	if enc, err = a.DepositAuthorizationHeader.MarshalHash(); err != nil {
+		return
+	}
+	var h = hash.THashH(enc)
+	if a.Signature, err = signer.Sign(h[:]); err != nil {
+		return
+	}
+	a.HeaderHash = h
+	a.Signee = signer.PubKey()
+	return
+}
+
+// Verify checks hash and signature of the authorization header.
+func (a *DepositAuthorization) Verify() (err error) {
+	var enc []byte
+	if enc, err = a.DepositAuthorizationHeader.MarshalHash(); err != nil {
+		return
+	} else if h := hash.THashH(enc); !a.HeaderHash.IsEqual(&h) {
+		err = ErrSignVerification
+		return
+	} else if a.Signee == nil || a.Signature == nil || !a.Signature.Verify(h[:], a.Signee) {
+		err = ErrSignVerification
+		return
+	}
+	// The authorization can only be signed by the owner itself
+	var addr proto.AccountAddress
+	if addr, err = utils.PubKeyHash(a.Signee); err != nil {
+		return
+	} else if addr != a.Owner {
+		err = ErrSignVerification
+		return
+	}
+	return
+}
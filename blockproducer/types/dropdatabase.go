@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"bytes"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+//go:generate hsp
+
+// DropDatabaseHeader defines the database deletion transaction header, the remaining deposit of
+// the database will be refunded to its owner.
+type DropDatabaseHeader struct {
+	// Issuer is the block producer account which issues the transaction on behalf of the owner.
+	Issuer     proto.AccountAddress
+	DatabaseID proto.DatabaseID
+	Nonce      pi.AccountNonce
+	// Fee is the stable coin amount paid by issuer for the transaction to be packed.
+	Fee uint64
+}
+
+// DropDatabase defines the database deletion transaction.
+type DropDatabase struct {
+	DropDatabaseHeader
+	HeaderHash hash.Hash
+	Signee     *asymmetric.PublicKey
+	Signature  *asymmetric.Signature
+}
+
+// NewDropDatabase returns new instance.
+func NewDropDatabase(header *DropDatabaseHeader) *DropDatabase {
+	return &DropDatabase{
+		DropDatabaseHeader: *header,
+	}
+}
+
+// Serialize serializes DropDatabase using msgpack.
+func (t *DropDatabase) Serialize() (b []byte, err error) {
+	var enc *bytes.Buffer
+	if enc, err = utils.EncodeMsgPack(t); err != nil {
+		return
+	}
+	b = enc.Bytes()
+	return
+}
+
+// Deserialize desrializes DropDatabase using msgpack.
+func (t *DropDatabase) Deserialize(enc []byte) error {
+	return utils.DecodeMsgPack(enc, t)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (t *DropDatabase) GetAccountAddress() proto.AccountAddress {
+	return t.Issuer
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (t *DropDatabase) GetAccountNonce() pi.AccountNonce {
+	return t.Nonce
+}
+
+// GetFee implements interfaces/Transaction.GetFee.
+func (t *DropDatabase) GetFee() uint64 {
+	return t.Fee
+}
+
+// GetHash implements interfaces/Transaction.GetHash.
+func (t *DropDatabase) GetHash() hash.Hash {
+	return t.HeaderHash
+}
+
+// GetTransactionType implements interfaces/Transaction.GetTransactionType.
+func (t *DropDatabase) GetTransactionType() pi.TransactionType {
+	return pi.TransactionTypeDropDatabase
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (t *DropDatabase) Sign(signer asymmetric.Signer) (err error) {
+	var enc []byte
+	if enc, err = t.DropDatabaseHeader.MarshalHash(); err != nil {
+		return
+	}
+	var h = hash.THashH(enc)
+	if t.Signature, err = signer.Sign(h[:]); err != nil {
+		return
+	}
+	t.HeaderHash = h
+	t.Signee = signer.PubKey()
+	return
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (t *DropDatabase) Verify() (err error) {
+	var enc []byte
+	if enc, err = t.DropDatabaseHeader.MarshalHash(); err != nil {
+		return
+	} else if h := hash.THashH(enc); !t.HeaderHash.IsEqual(&h) {
+		err = ErrSignVerification
+		return
+	} else if !t.Signature.Verify(h[:], t.Signee) {
+		err = ErrSignVerification
+		return
+	}
+	// The transaction can only be signed by the issuer itself
+	var addr proto.AccountAddress
+	if addr, err = utils.PubKeyHash(t.Signee); err != nil {
+		return
+	} else if addr != t.Issuer {
+		err = ErrSignVerification
+		return
+	}
+	return
+}
@@ -33,4 +33,22 @@ var (
 	// ErrNodePublicKeyNotMatch indicates that the public key given with a node does not match the
 	// one in the key store.
 	ErrNodePublicKeyNotMatch = errors.New("node publick key doesn't match")
+
+	// ErrUnknownTransactionType indicates that a transaction has a unknown type.
+	ErrUnknownTransactionType = errors.New("unknown transaction type")
+
+	// ErrUnknownBlockVersion indicates that a block is serialized in an unknown version.
+	ErrUnknownBlockVersion = errors.New("unknown block serialization version")
+
+	// ErrInvalidEvidence indicates that an evidence does not prove any equivocation.
+	ErrInvalidEvidence = errors.New("invalid equivocation evidence")
+
+	// ErrUnknownProducer indicates that a block is not signed by any known block producer.
+	ErrUnknownProducer = errors.New("unknown block producer")
+
+	// ErrNotEnoughCommits indicates that a block is not committed by enough block producers.
+	ErrNotEnoughCommits = errors.New("not enough commits")
+
+	// ErrInvalidStateProof indicates that a state proof does not match the state root.
+	ErrInvalidStateProof = errors.New("invalid state proof")
 )
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/merkle"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const (
+	accountStatePrefix  byte = 'a'
+	databaseStatePrefix byte = 'd'
+)
+
+// AccountStateKey returns the key of the account in the state trie.
+func AccountStateKey(addr proto.AccountAddress) []byte {
+	return append([]byte{accountStatePrefix}, addr[:]...)
+}
+
+// DatabaseStateKey returns the key of the SQLChain profile in the state trie.
+func DatabaseStateKey(id proto.DatabaseID) []byte {
+	return append([]byte{databaseStatePrefix}, []byte(id)...)
+}
+
+// StateProof proves an object of the main chain state with a block committed by the block
+// producers.
+type StateProof struct {
+	Header  SignedHeader
+	Commits []*Vote
+	Proof   *merkle.Proof
+}
+
+// VerifyAccount verifies that the account of addr is committed as account, or that it does not
+// exist if account is nil.
+func (p *StateProof) VerifyAccount(
+	producers map[proto.NodeID]*asymmetric.PublicKey, addr proto.AccountAddress, account *Account,
+) (err error) {
+	var value []byte
+	if account != nil {
+		if account.Address != addr {
+			return ErrInvalidStateProof
+		}
+		if value, err = account.MarshalHash(); err != nil {
+			return
+		}
+	}
+	return p.Verify(producers, AccountStateKey(addr), value)
+}
+
+// VerifyDatabase verifies that the SQLChain profile of id is committed as profile, or that it
+// does not exist if profile is nil.
+func (p *StateProof) VerifyDatabase(
+	producers map[proto.NodeID]*asymmetric.PublicKey, id proto.DatabaseID, profile *SQLChainProfile,
+) (err error) {
+	var value []byte
+	if profile != nil {
+		if profile.ID != id {
+			return ErrInvalidStateProof
+		}
+		if value, err = profile.MarshalHash(); err != nil {
+			return
+		}
+	}
+	return p.Verify(producers, DatabaseStateKey(id), value)
+}
+
+// Verify verifies that the block header is signed by one of the producers and committed by more
+// than 2/3 of them, and that key has the value in the state of the block, or that key is absent if
+// value is nil.
+func (p *StateProof) Verify(
+	producers map[proto.NodeID]*asymmetric.PublicKey, key, value []byte,
+) (err error) {
+	// Check header
+	var enc []byte
+	if enc, err = p.Header.Header.MarshalHash(); err != nil {
+		return
+	}
+	if h := hash.THashH(enc); !p.Header.BlockHash.IsEqual(&h) {
+		return ErrHashVerification
+	}
+	if p.Header.Signee == nil || p.Header.Signature == nil {
+		return ErrSignVerification
+	}
+	if err = p.Header.Verify(); err != nil {
+		return
+	}
+	var known bool
+	for _, v := range producers {
+		if v != nil && v.IsEqual(p.Header.Signee) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return ErrUnknownProducer
+	}
+
+	// Check commits
+	var voted = make(map[proto.NodeID]bool)
+	for _, v := range p.Commits {
+		if v == nil || v.Type != VoteTypePrecommit || !v.BlockHash.IsEqual(&p.Header.BlockHash) {
+			continue
+		}
+		if pub, ok := producers[v.Voter]; !ok || pub == nil || v.Verify() != nil || !pub.IsEqual(v.Signee) {
+			continue
+		}
+		voted[v.Voter] = true
+	}
+	if len(voted) < len(producers)*2/3+1 {
+		return ErrNotEnoughCommits
+	}
+
+	// Check state
+	if p.Proof == nil || !p.Proof.Verify(&p.Header.StateRoot, key, value) {
+		return ErrInvalidStateProof
+	}
+	return
+}
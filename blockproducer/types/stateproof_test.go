@@ -0,0 +1,113 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/merkle"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestStateProof_Verify(t *testing.T) {
+	var (
+		privs     = make([]*asymmetric.PrivateKey, 3)
+		producers = make(map[proto.NodeID]*asymmetric.PublicKey)
+		ids       = []proto.NodeID{"node0", "node1", "node2"}
+		err       error
+	)
+	for i := range privs {
+		if privs[i], _, err = asymmetric.GenSecp256k1KeyPair(); err != nil {
+			t.Fatalf("Unexpeted error: %v", err)
+		}
+		producers[ids[i]] = privs[i].PubKey()
+	}
+
+	var (
+		account = &Account{Address: proto.AccountAddress{0x1}, StableCoinBalance: 100}
+		profile = &SQLChainProfile{ID: "db", Owner: account.Address}
+		trie    = merkle.NewPatricia()
+		enc     []byte
+	)
+	if enc, err = account.MarshalHash(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	trie.Insert(AccountStateKey(account.Address), enc)
+	if enc, err = profile.MarshalHash(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	trie.Insert(DatabaseStateKey(profile.ID), enc)
+
+	b := &Block{SignedHeader: SignedHeader{Header: Header{StateRoot: trie.Root()}}}
+	if err = b.PackAndSignBlock(privs[0]); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	for i := range privs {
+		v := NewVote(VoteTypePrecommit, 1, b.SignedHeader.BlockHash, ids[i])
+		if err = v.Sign(privs[i]); err != nil {
+			t.Fatalf("Unexpeted error: %v", err)
+		}
+		b.Commits = append(b.Commits, v)
+	}
+
+	p := &StateProof{
+		Header:  b.SignedHeader,
+		Commits: b.Commits,
+		Proof:   trie.Prove(AccountStateKey(account.Address)),
+	}
+	if err = p.VerifyAccount(producers, account.Address, account); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = p.VerifyAccount(producers, account.Address, &Account{
+		Address: account.Address, StableCoinBalance: 1000,
+	}); err != ErrInvalidStateProof {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	p.Proof = trie.Prove(DatabaseStateKey(profile.ID))
+	if err = p.VerifyDatabase(producers, profile.ID, profile); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	p.Proof = trie.Prove(DatabaseStateKey("absent"))
+	if err = p.VerifyDatabase(producers, "absent", nil); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Block without a quorum of commits should be rejected
+	p.Commits = b.Commits[:2]
+	if err = p.VerifyDatabase(producers, "absent", nil); err != ErrNotEnoughCommits {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	p.Commits = append(b.Commits[:2:2], b.Commits[1])
+	if err = p.VerifyDatabase(producers, "absent", nil); err != ErrNotEnoughCommits {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Tampered header should be rejected
+	p.Commits = b.Commits
+	p.Header.StateRoot[0]++
+	if err = p.VerifyDatabase(producers, "absent", nil); err != ErrHashVerification {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Block signed by unknown producer should be rejected
+	delete(producers, ids[0])
+	p.Header = b.SignedHeader
+	if err = p.VerifyDatabase(producers, "absent", nil); err != ErrUnknownProducer {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"bytes"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+//go:generate hsp
+
+// TopUpHeader defines the database top-up transaction header.
+type TopUpHeader struct {
+	Sender     proto.AccountAddress
+	DatabaseID proto.DatabaseID
+	Nonce      pi.AccountNonce
+	// Amount is the stable coin amount transferred from sender to the database deposit, the
+	// arrears of the database will be settled first.
+	Amount uint64
+	// Fee is the stable coin amount paid by sender for the transaction to be packed.
+	Fee uint64
+}
+
+// TopUp defines the database top-up transaction.
+type TopUp struct {
+	TopUpHeader
+	HeaderHash hash.Hash
+	Signee     *asymmetric.PublicKey
+	Signature  *asymmetric.Signature
+}
+
+// NewTopUp returns new instance.
+func NewTopUp(header *TopUpHeader) *TopUp {
+	return &TopUp{
+		TopUpHeader: *header,
+	}
+}
+
+// Serialize serializes TopUp using msgpack.
+func (t *TopUp) Serialize() (b []byte, err error) {
+	var enc *bytes.Buffer
+	if enc, err = utils.EncodeMsgPack(t); err != nil {
+		return
+	}
+	b = enc.Bytes()
+	return
+}
+
+// Deserialize desrializes TopUp using msgpack.
+func (t *TopUp) Deserialize(enc []byte) error {
+	return utils.DecodeMsgPack(enc, t)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (t *TopUp) GetAccountAddress() proto.AccountAddress {
+	return t.Sender
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (t *TopUp) GetAccountNonce() pi.AccountNonce {
+	return t.Nonce
+}
+
+// GetFee implements interfaces/Transaction.GetFee.
+func (t *TopUp) GetFee() uint64 {
+	return t.Fee
+}
+
+// GetHash implements interfaces/Transaction.GetHash.
+func (t *TopUp) GetHash() hash.Hash {
+	return t.HeaderHash
+}
+
+// GetTransactionType implements interfaces/Transaction.GetTransactionType.
+func (t *TopUp) GetTransactionType() pi.TransactionType {
+	return pi.TransactionTypeTopUp
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (t *TopUp) Sign(signer asymmetric.Signer) (err error) {
+	var enc []byte
+	if enc, err = t.TopUpHeader.MarshalHash(); err != nil {
+		return
+	}
+	var h = hash.THashH(enc)
+	if t.Signature, err = signer.Sign(h[:]); err != nil {
+		return
+	}
+	t.HeaderHash = h
+	t.Signee = signer.PubKey()
+	return
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (t *TopUp) Verify() (err error) {
+	var enc []byte
+	if enc, err = t.TopUpHeader.MarshalHash(); err != nil {
+		return
+	} else if h := hash.THashH(enc); !t.HeaderHash.IsEqual(&h) {
+		err = ErrSignVerification
+		return
+	} else if !t.Signature.Verify(h[:], t.Signee) {
+		err = ErrSignVerification
+		return
+	}
+	// The deposit can only be paid by the sender itself
+	var addr proto.AccountAddress
+	if addr, err = utils.PubKeyHash(t.Signee); err != nil {
+		return
+	} else if addr != t.Sender {
+		err = ErrSignVerification
+		return
+	}
+	return
+}
@@ -33,6 +33,9 @@ type TransferHeader struct {
 	Sender, Receiver proto.AccountAddress
 	Nonce            pi.AccountNonce
 	Amount           uint64
+	// Fee is the stable coin amount paid by sender for the transaction to be packed, which is
+	// burnt on apply.
+	Fee uint64
 }
 
 // Transfer defines the transfer transaction.
@@ -68,6 +71,11 @@ func (t *Transfer) GetAccountNonce() pi.AccountNonce {
 	return t.Nonce
 }
 
+// GetFee implements interfaces/Transaction.GetFee.
+func (t *Transfer) GetFee() uint64 {
+	return t.Fee
+}
+
 // GetHash implements interfaces/Transaction.GetHash.
 func (t *Transfer) GetHash() hash.Hash {
 	return t.HeaderHash
@@ -79,7 +87,7 @@ func (t *Transfer) GetTransactionType() pi.TransactionType {
 }
 
 // Sign implements interfaces/Transaction.Sign.
-func (t *Transfer) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (t *Transfer) Sign(signer asymmetric.Signer) (err error) {
 	var enc []byte
 	if enc, err = t.TransferHeader.MarshalHash(); err != nil {
 		return
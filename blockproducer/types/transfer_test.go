@@ -15,3 +15,41 @@
  */
 
 package types
+
+import (
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestTransfer_Fee(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	tx := &Transfer{
+		TransferHeader: TransferHeader{
+			Sender:   proto.AccountAddress{0x1},
+			Receiver: proto.AccountAddress{0x2},
+			Amount:   100,
+			Fee:      10,
+		},
+	}
+	if err = tx.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if fee := tx.GetFee(); fee != 10 {
+		t.Fatalf("Unexpeted fee: %d", fee)
+	}
+	if err = tx.Verify(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// The fee should be covered by the signature
+	tx.Fee = 1
+	if err = tx.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+}
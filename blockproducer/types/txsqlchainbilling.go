@@ -115,6 +115,12 @@ func (tb *TxBilling) GetAccountNonce() pi.AccountNonce {
 	return pi.AccountNonce(tb.TxContent.SequenceID)
 }
 
+// GetFee implements interfaces/Transaction.GetFee.
+func (tb *TxBilling) GetFee() uint64 {
+	// TxBilling is produced by block producers, which is free of charge.
+	return 0
+}
+
 // GetHash implements interfaces/Transaction.GetHash.
 func (tb *TxBilling) GetHash() hash.Hash {
 	return *tb.TxHash
@@ -126,7 +132,7 @@ func (tb *TxBilling) GetTransactionType() pi.TransactionType {
 }
 
 // Sign computes tx of TxContent and signs it.
-func (tb *TxBilling) Sign(signer *asymmetric.PrivateKey) error {
+func (tb *TxBilling) Sign(signer asymmetric.Signer) error {
 	enc, err := tb.TxContent.MarshalHash()
 	if err != nil {
 		return err
@@ -134,8 +140,7 @@ func (tb *TxBilling) Sign(signer *asymmetric.PrivateKey) error {
 	h := hash.THashH(enc)
 	tb.TxHash = &h
 
-	pub := asymmetric.PublicKey(signer.PublicKey)
-	tb.Signee = &pub
+	tb.Signee = signer.PubKey()
 
 	signature, err := signer.Sign(h[:])
 	if err != nil {
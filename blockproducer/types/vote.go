@@ -0,0 +1,132 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// VoteType defines the consensus vote type.
+type VoteType int32
+
+const (
+	// VoteTypePrevote defines the vote on a proposed block.
+	VoteTypePrevote VoteType = iota
+	// VoteTypePrecommit defines the vote to commit a block, which is only cast after seeing more
+	// than 2/3 prevotes on the block.
+	VoteTypePrecommit
+)
+
+func (t VoteType) String() string {
+	switch t {
+	case VoteTypePrevote:
+		return "Prevote"
+	case VoteTypePrecommit:
+		return "Precommit"
+	default:
+		return "Unknown"
+	}
+}
+
+// VoteHeader defines the voted content of a block producer.
+type VoteHeader struct {
+	Type      VoteType
+	Height    uint32
+	BlockHash hash.Hash
+	Voter     proto.NodeID
+}
+
+// Vote defines a signed consensus vote.
+type Vote struct {
+	VoteHeader
+	HeaderHash hash.Hash
+	Signee     *asymmetric.PublicKey
+	Signature  *asymmetric.Signature
+}
+
+// NewVote returns a new unsigned vote.
+func NewVote(t VoteType, height uint32, blockHash hash.Hash, voter proto.NodeID) *Vote {
+	return &Vote{
+		VoteHeader: VoteHeader{
+			Type:      t,
+			Height:    height,
+			BlockHash: blockHash,
+			Voter:     voter,
+		},
+	}
+}
+
+// Sign signs the vote with the private key of the voter.
+func (v *Vote) Sign(signer asymmetric.Signer) (err error) {
+	var enc []byte
+	if enc, err = v.VoteHeader.MarshalHash(); err != nil {
+		return
+	}
+	var h = hash.THashH(enc)
+	if v.Signature, err = signer.Sign(h[:]); err != nil {
+		return
+	}
+	v.HeaderHash = h
+	v.Signee = signer.PubKey()
+	return
+}
+
+// Verify verifies the hash and signature of the vote.
+func (v *Vote) Verify() (err error) {
+	var enc []byte
+	if enc, err = v.VoteHeader.MarshalHash(); err != nil {
+		return
+	} else if h := hash.THashH(enc); !v.HeaderHash.IsEqual(&h) {
+		err = ErrSignVerification
+		return
+	} else if v.Signee == nil || v.Signature == nil || !v.Signature.Verify(h[:], v.Signee) {
+		err = ErrSignVerification
+		return
+	}
+	return
+}
+
+// Evidence defines the proof of an equivocation: two conflicting votes of the same type and
+// height signed by the same voter.
+type Evidence struct {
+	First, Second *Vote
+}
+
+// Verify checks whether the evidence really proves an equivocation.
+func (e *Evidence) Verify() (err error) {
+	if e.First == nil || e.Second == nil {
+		return ErrInvalidEvidence
+	}
+	if err = e.First.Verify(); err != nil {
+		return
+	}
+	if err = e.Second.Verify(); err != nil {
+		return
+	}
+	if e.First.Type != e.Second.Type ||
+		e.First.Height != e.Second.Height ||
+		e.First.Voter != e.Second.Voter ||
+		!e.First.Signee.IsEqual(e.Second.Signee) ||
+		e.First.BlockHash.IsEqual(&e.Second.BlockHash) {
+		return ErrInvalidEvidence
+	}
+	return
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestVote_SignAndVerify(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	v := NewVote(VoteTypePrevote, 1, hash.Hash{0x1}, proto.NodeID("node"))
+	if err = v.Sign(priv); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = v.Verify(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	// Tampered vote should be rejected
+	v.Type = VoteTypePrecommit
+	if err = v.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+}
+
+func TestEvidence_Verify(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+
+	var (
+		v1 = NewVote(VoteTypePrecommit, 1, hash.Hash{0x1}, proto.NodeID("node"))
+		v2 = NewVote(VoteTypePrecommit, 1, hash.Hash{0x2}, proto.NodeID("node"))
+		v3 = NewVote(VoteTypePrecommit, 2, hash.Hash{0x2}, proto.NodeID("node"))
+	)
+	for _, v := range []*Vote{v1, v2, v3} {
+		if err = v.Sign(priv); err != nil {
+			t.Fatalf("Unexpeted error: %v", err)
+		}
+	}
+
+	if err = (&Evidence{First: v1, Second: v2}).Verify(); err != nil {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = (&Evidence{First: v1, Second: v1}).Verify(); err != ErrInvalidEvidence {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = (&Evidence{First: v1, Second: v3}).Verify(); err != ErrInvalidEvidence {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+	if err = (&Evidence{First: v1}).Verify(); err != ErrInvalidEvidence {
+		t.Fatalf("Unexpeted error: %v", err)
+	}
+}
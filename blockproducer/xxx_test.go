@@ -150,7 +150,9 @@ func generateRandomBlock(parent hash.Hash, isGenesis bool) (b *types.Block, err
 	return
 }
 
-func generateRandomBlockWithTxBillings(parent hash.Hash, tbs []*types.TxBilling) (b *types.Block, err error) {
+func generateRandomBlockWithTxBillings(
+	parent hash.Hash, state *metaState, tbs []*types.TxBilling) (b *types.Block, err error,
+) {
 	// Generate key pair
 	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
 
@@ -189,6 +191,9 @@ func generateRandomBlockWithTxBillings(parent hash.Hash, tbs []*types.TxBilling)
 	}
 	b.Transactions = append(b.Transactions, tr)
 
+	if b.SignedHeader.StateRoot, err = state.stateRootAfter(b.Transactions); err != nil {
+		return
+	}
 	err = b.PackAndSignBlock(priv)
 	for i := range b.TxBillings {
 		b.TxBillings[i].SignedBlock = &b.SignedHeader.BlockHash
@@ -34,6 +34,9 @@ observer_pkgpath="github.com/CovenantSQL/CovenantSQL/cmd/observer"
 go build -ldflags "-X main.version=${version} -X github.com/CovenantSQL/CovenantSQL/conf.RoleTag=C ${GOLDFLAGS}" -o bin/covenantobserver ${observer_pkgpath}
 go test -coverpkg github.com/CovenantSQL/CovenantSQL/... -cover -race -c -tags 'testbinary' -ldflags "-X main.version=${version} -X github.com/CovenantSQL/CovenantSQL/conf.RoleTag=C ${GOLDFLAGS}" -o bin/covenantobserver.test ${observer_pkgpath}
 
+explorer_pkgpath="github.com/CovenantSQL/CovenantSQL/cmd/explorer"
+go build -ldflags "-X main.version=${version} -X github.com/CovenantSQL/CovenantSQL/conf.RoleTag=C ${GOLDFLAGS}" -o bin/covenantexplorer ${explorer_pkgpath}
+
 # hotfix_pkgpath="github.com/CovenantSQL/CovenantSQL/cmd/hotfix/msgpack-20180824"
 # CGO_ENABLED=1 go build -ldflags "${GOLDFLAGS}" -o bin/hotfix_msgpack_20180824 ${hotfix_pkgpath}
 
@@ -43,6 +46,9 @@ CGO_ENABLED=1 go build -ldflags "-X main.version=${version} -X github.com/Covena
 adapter_pkgpath="github.com/CovenantSQL/CovenantSQL/cmd/adapter"
 CGO_ENABLED=1 go build -ldflags "-X main.version=${version} -X github.com/CovenantSQL/CovenantSQL/conf.RoleTag=C ${GOLDFLAGS}" --tags ${platform}" sqlite_omit_load_extension" -o bin/covenantadapter ${adapter_pkgpath}
 
+pg_adapter_pkgpath="github.com/CovenantSQL/CovenantSQL/cmd/pg-adapter"
+CGO_ENABLED=1 go build -ldflags "-X main.version=${version} -X github.com/CovenantSQL/CovenantSQL/conf.RoleTag=C ${GOLDFLAGS}" --tags ${platform}" sqlite_omit_load_extension" -o bin/covenantpgadapter ${pg_adapter_pkgpath}
+
 faucet_pkgpath="github.com/CovenantSQL/CovenantSQL/cmd/faucet"
 CGO_ENABLED=1 go build -ldflags "-X main.version=${version} -X github.com/CovenantSQL/CovenantSQL/conf.RoleTag=C ${GOLDFLAGS}" --tags ${platform}" sqlite_omit_load_extension" -o bin/covenantfaucet ${faucet_pkgpath}
 
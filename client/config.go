@@ -24,6 +24,7 @@ import (
 const (
 	paramKeyDebug          = "debug"
 	paramKeyUpdateInterval = "update_interval"
+	paramKeyIdentity       = "identity"
 )
 
 var (
@@ -38,6 +39,10 @@ type Config struct {
 	Debug               bool
 	PeersUpdateInterval time.Duration
 
+	// Identity is the name of identity registered by RegisterIdentity,
+	// empty for local node identity.
+	Identity string
+
 	// additional configs should be filled
 	// such as read/write/exec timeout
 	// currently no timeout is supported.
@@ -70,6 +75,10 @@ func (cfg *Config) FormatDSN() string {
 		newQuery.Set(paramKeyUpdateInterval, cfg.PeersUpdateInterval.String())
 	}
 
+	if cfg.Identity != "" {
+		newQuery.Set(paramKeyIdentity, cfg.Identity)
+	}
+
 	u.RawQuery = newQuery.Encode()
 
 	return u.String()
@@ -97,5 +106,7 @@ func ParseDSN(dsn string) (cfg *Config, err error) {
 		}
 	}
 
+	cfg.Identity = urlQuery.Get(paramKeyIdentity)
+
 	return
 }
@@ -47,5 +47,11 @@ func TestConfig(t *testing.T) {
 		cfg.Debug = true
 		cfg.PeersUpdateInterval = DefaultPeersUpdateInterval
 		So(cfg.FormatDSN(), ShouldEqual, "covenantsql://db?debug=true")
+
+		// test with identity
+		cfg, err = ParseDSN("covenantsql://db?identity=alice")
+		So(err, ShouldBeNil)
+		So(cfg.Identity, ShouldEqual, "alice")
+		So(cfg.FormatDSN(), ShouldEqual, "covenantsql://db?identity=alice")
 	})
 }
@@ -28,7 +28,6 @@ import (
 
 	bp "github.com/CovenantSQL/CovenantSQL/blockproducer"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
-	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/kayak"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
@@ -48,10 +47,11 @@ type conn struct {
 	dbID proto.DatabaseID
 
 	queries   []wt.Query
+	results   []*execResult
 	peers     *kayak.Peers
 	peersLock sync.RWMutex
 	nodeID    proto.NodeID
-	privKey   *asymmetric.PrivateKey
+	signer    asymmetric.Signer
 	pubKey    *asymmetric.PublicKey
 
 	inTransaction bool
@@ -67,29 +67,17 @@ func newConn(cfg *Config) (c *conn, err error) {
 	// init connectionID to random id
 	atomic.CompareAndSwapUint64(&connectionID, 0, randSource.Uint64())
 
-	// get local node id
-	var nodeID proto.NodeID
-	if nodeID, err = kms.GetLocalNodeID(); err != nil {
-		return
-	}
-
-	// get local private key
-	var privKey *asymmetric.PrivateKey
-	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
-		return
-	}
-
-	// get local public key
-	var pubKey *asymmetric.PublicKey
-	if pubKey, err = kms.GetLocalPublicKey(); err != nil {
+	// get signing identity, local node identity is used by default
+	var id *Identity
+	if id, err = getIdentity(cfg.Identity); err != nil {
 		return
 	}
 
 	c = &conn{
 		dbID:    proto.DatabaseID(cfg.DatabaseID),
-		nodeID:  nodeID,
-		privKey: privKey,
-		pubKey:  pubKey,
+		nodeID:  id.NodeID,
+		signer:  id.Signer,
+		pubKey:  id.PublicKey,
 		queries: make([]wt.Query, 0),
 		closeCh: make(chan struct{}),
 	}
@@ -168,6 +156,7 @@ func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, e
 	// TODO(xq262144): make use of the ctx argument
 	c.inTransaction = true
 	c.queries = c.queries[:0]
+	c.results = c.results[:0]
 
 	return c, nil
 }
@@ -191,13 +180,23 @@ func (c *conn) ExecContext(ctx context.Context, query string, args []driver.Name
 
 	// TODO(xq262144): make use of the ctx argument
 	sq := convertQuery(query, args)
-	if _, err = c.addQuery(wt.WriteQuery, sq); err != nil {
-		return
+	res := new(execResult)
+	if c.inTransaction {
+		// result is set on commit
+		c.queries = append(c.queries, *sq)
+		c.results = append(c.results, res)
+		return res, nil
 	}
 
-	result = driver.ResultNoRows
+	var response *wt.Response
+	if response, err = c.sendQuery(wt.WriteQuery, []wt.Query{*sq}); err != nil {
+		return
+	}
+	if err = setResults(response, []*execResult{res}); err != nil {
+		return
+	}
 
-	return
+	return res, nil
 }
 
 // QueryContext implements the driver.QueryerContext.QueryContext method.
@@ -208,8 +207,19 @@ func (c *conn) QueryContext(ctx context.Context, query string, args []driver.Nam
 	}
 
 	// TODO(xq262144): make use of the ctx argument
+	if c.inTransaction {
+		// read query is not supported in transaction
+		err = ErrQueryInTransaction
+		return
+	}
+
 	sq := convertQuery(query, args)
-	return c.addQuery(wt.ReadQuery, sq)
+	var response *wt.Response
+	if response, err = c.sendQuery(wt.ReadQuery, []wt.Query{*sq}); err != nil {
+		return
+	}
+
+	return newRows(response), nil
 }
 
 // Commit implements the driver.Tx.Commit method.
@@ -224,14 +234,17 @@ func (c *conn) Commit() (err error) {
 
 	defer func() {
 		c.queries = c.queries[:0]
+		c.results = c.results[:0]
 		c.inTransaction = false
 	}()
 
 	if len(c.queries) > 0 {
 		// send query
-		if _, err = c.sendQuery(wt.WriteQuery, c.queries); err != nil {
+		var response *wt.Response
+		if response, err = c.sendQuery(wt.WriteQuery, c.queries); err != nil {
 			return
 		}
+		err = setResults(response, c.results)
 	}
 
 	return
@@ -249,6 +262,7 @@ func (c *conn) Rollback() error {
 
 	defer func() {
 		c.queries = c.queries[:0]
+		c.results = c.results[:0]
 		c.inTransaction = false
 	}()
 
@@ -259,24 +273,7 @@ func (c *conn) Rollback() error {
 	return nil
 }
 
-func (c *conn) addQuery(queryType wt.QueryType, query *wt.Query) (rows driver.Rows, err error) {
-	if c.inTransaction {
-		// check query type, enqueue query
-		if queryType == wt.ReadQuery {
-			// read query is not supported in transaction
-			err = ErrQueryInTransaction
-			return
-		}
-
-		// append queries
-		c.queries = append(c.queries, *query)
-		return
-	}
-
-	return c.sendQuery(queryType, []wt.Query{*query})
-}
-
-func (c *conn) sendQuery(queryType wt.QueryType, queries []wt.Query) (rows driver.Rows, err error) {
+func (c *conn) sendQuery(queryType wt.QueryType, queries []wt.Query) (response *wt.Response, err error) {
 	c.peersLock.RLock()
 	defer c.peersLock.RUnlock()
 
@@ -299,21 +296,21 @@ func (c *conn) sendQuery(queryType wt.QueryType, queries []wt.Query) (rows drive
 		},
 	}
 
-	if err = req.Sign(c.privKey); err != nil {
+	if err = req.Sign(c.signer); err != nil {
 		return
 	}
 
 	pCaller := rpc.NewPersistentCaller(c.peers.Leader.ID)
 	defer pCaller.Close()
-	var response wt.Response
-	if err = pCaller.Call(route.DBSQuery.String(), req, &response); err != nil {
+	response = new(wt.Response)
+	if err = pCaller.Call(route.DBSQuery.String(), req, response); err != nil {
 		if strings.Contains(err.Error(), "invalid request sequence") {
 			// request sequence failure, try again
 			atomic.StoreUint64(&connectionID, randSource.Uint64())
 			req.Header.ConnectionID = atomic.LoadUint64(&connectionID)
 			req.Header.SeqNo = atomic.AddUint64(&seqNo, 1)
 
-			if err = req.Sign(c.privKey); err != nil {
+			if err = req.Sign(c.signer); err != nil {
 				return
 			}
 
@@ -343,7 +340,7 @@ func (c *conn) sendQuery(queryType wt.QueryType, queries []wt.Query) (rows drive
 		},
 	}
 
-	if err = ack.Sign(c.privKey); err != nil {
+	if err = ack.Sign(c.signer); err != nil {
 		return
 	}
 
@@ -355,8 +352,6 @@ func (c *conn) sendQuery(queryType wt.QueryType, queries []wt.Query) (rows drive
 		err = nil
 	}
 
-	rows = newRows(&response)
-
 	return
 }
 
@@ -368,7 +363,7 @@ func (c *conn) getPeers() (err error) {
 	req.Header.DatabaseID = c.dbID
 	req.Header.Signee = c.pubKey
 
-	if err = req.Sign(c.privKey); err != nil {
+	if err = req.Sign(c.signer); err != nil {
 		return
 	}
 
@@ -381,12 +376,42 @@ func (c *conn) getPeers() (err error) {
 	if err = res.Verify(); err != nil {
 		return
 	}
+	if err = verifyPeers(res.Header.InstanceMeta.Peers, c.peers); err != nil {
+		return
+	}
 
 	c.peers = res.Header.InstanceMeta.Peers
 
 	return
 }
 
+// verifyPeers verifies that the peers are signed by a block producer, and that they are not older
+// than the known ones if any.
+func verifyPeers(peers, known *kayak.Peers) error {
+	if peers == nil || peers.Leader == nil || peers.Signature == nil ||
+		!isBlockProducer(peers.PubKey) || !peers.Verify() {
+		return ErrInvalidPeers
+	}
+	if _, found := peers.Find(peers.Leader.ID); !found {
+		return ErrInvalidPeers
+	}
+	if known != nil && peers.Term < known.Term {
+		return ErrInvalidPeers
+	}
+	return nil
+}
+
+// setResults fills the results of write queries with the signed exec results of response.
+func setResults(response *wt.Response, results []*execResult) (err error) {
+	if len(response.Header.Results) != len(results) {
+		return ErrInvalidResponse
+	}
+	for i := range results {
+		results[i].set(&response.Header.Results[i])
+	}
+	return
+}
+
 func getLocalTime() time.Time {
 	return time.Now().UTC()
 }
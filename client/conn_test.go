@@ -20,6 +20,7 @@ import (
 	"database/sql"
 	"testing"
 
+	"github.com/CovenantSQL/CovenantSQL/kayak"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -73,9 +74,15 @@ func TestConn(t *testing.T) {
 		testRowCount(2)
 
 		// test with query and multiple arguments
-		_, err = db.Exec("insert into test values(?), (?)", 3, 4)
+		execResult, err := db.Exec("insert into test values(?), (?)", 3, 4)
 		So(err, ShouldBeNil)
 		testRowCount(4)
+		affected, err := execResult.RowsAffected()
+		So(err, ShouldBeNil)
+		So(affected, ShouldEqual, 2)
+		lastInsertID, err := execResult.LastInsertId()
+		So(err, ShouldBeNil)
+		So(lastInsertID, ShouldEqual, 4)
 
 		// parameter count is more than placeholders
 		_, err = db.Exec("insert into test values(?)", 5, 6)
@@ -206,12 +213,17 @@ func TestTransaction(t *testing.T) {
 
 		_, err = tx.Exec("insert into test values(2)")
 		So(err, ShouldBeNil)
-		_, err = tx.Exec("insert into test values(3)")
+		txResult, err := tx.Exec("insert into test values(3)")
 		So(err, ShouldBeNil)
+		_, err = txResult.RowsAffected()
+		So(err, ShouldEqual, ErrResultNotAvailable)
 
 		err = tx.Commit()
 		So(err, ShouldBeNil)
 		testRowCount(3)
+		affected, err := txResult.RowsAffected()
+		So(err, ShouldBeNil)
+		So(affected, ShouldEqual, 1)
 		err = tx.Rollback()
 		So(err, ShouldNotBeNil)
 
@@ -250,3 +262,29 @@ func TestTransaction(t *testing.T) {
 		So(err, ShouldNotBeNil)
 	})
 }
+
+func TestVerifyPeers(t *testing.T) {
+	Convey("test verify peers", t, func() {
+		var stopTestService func()
+		var err error
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		var peers, stale *kayak.Peers
+		peers, err = getPeers(2)
+		So(err, ShouldBeNil)
+		stale, err = getPeers(1)
+		So(err, ShouldBeNil)
+		So(verifyPeers(peers, nil), ShouldBeNil)
+		So(verifyPeers(peers, stale), ShouldBeNil)
+		So(verifyPeers(stale, peers), ShouldEqual, ErrInvalidPeers)
+		So(verifyPeers(nil, peers), ShouldEqual, ErrInvalidPeers)
+
+		// tampered peers should be refused
+		peers.Term++
+		So(verifyPeers(peers, nil), ShouldEqual, ErrInvalidPeers)
+		peers.Signature = nil
+		So(verifyPeers(peers, nil), ShouldEqual, ErrInvalidPeers)
+	})
+}
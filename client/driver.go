@@ -19,10 +19,14 @@ package client
 import (
 	"database/sql"
 	"database/sql/driver"
+	"time"
 
 	bp "github.com/CovenantSQL/CovenantSQL/blockproducer"
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
@@ -34,6 +38,9 @@ import (
 const (
 	// PubKeyStorePath defines public cache store.
 	PubKeyStorePath = "./public.keystore"
+	// MaxStateProofAge defines the max age of the block by which a state proof is accepted, so
+	// that a block producer cannot answer with a stale state.
+	MaxStateProofAge = 5 * time.Minute
 )
 
 func init() {
@@ -76,16 +83,35 @@ func Init(configFile string, masterKey []byte) (err error) {
 
 // Create send create database operation to block producer.
 func Create(meta ResourceMeta) (dsn string, err error) {
-	req := new(bp.CreateDatabaseRequest)
-	req.Header.ResourceMeta = wt.ResourceMeta(meta)
-	if req.Header.Signee, err = kms.GetLocalPublicKey(); err != nil {
+	return CreateWithIdentity(meta, "")
+}
+
+// CreateWithIdentity send create database operation to block producer on behalf of the registered identity.
+// The deposit is authorized up to the deposit of meta for the default deposit period of block producers,
+// use CreateWithDeposit if the block producers prepay a longer period.
+func CreateWithIdentity(meta ResourceMeta, identity string) (dsn string, err error) {
+	var maxDeposit uint64
+	if maxDeposit, err = bp.EstimateDeposit(wt.ResourceMeta(meta)); err != nil {
+		return
+	}
+	return CreateWithDeposit(meta, identity, maxDeposit)
+}
+
+// CreateWithDeposit send create database operation to block producer on behalf of the registered identity,
+// the block producer is authorized to lock no more than maxDeposit stable coin as the database deposit.
+func CreateWithDeposit(meta ResourceMeta, identity string, maxDeposit uint64) (dsn string, err error) {
+	var id *Identity
+	if id, err = getIdentity(identity); err != nil {
 		return
 	}
-	var privateKey *asymmetric.PrivateKey
-	if privateKey, err = kms.GetLocalPrivateKey(); err != nil {
+
+	req := new(bp.CreateDatabaseRequest)
+	req.Header.ResourceMeta = wt.ResourceMeta(meta)
+	if req.Header.Authorization, err = authorizeDeposit(id, maxDeposit); err != nil {
 		return
 	}
-	if err = req.Sign(privateKey); err != nil {
+	req.Header.Signee = id.PublicKey
+	if err = req.Sign(id.Signer); err != nil {
 		return
 	}
 	res := new(bp.CreateDatabaseResponse)
@@ -99,6 +125,7 @@ func Create(meta ResourceMeta) (dsn string, err error) {
 
 	cfg := NewConfig()
 	cfg.DatabaseID = string(res.Header.InstanceMeta.DatabaseID)
+	cfg.Identity = identity
 	dsn = cfg.FormatDSN()
 
 	return
@@ -111,16 +138,15 @@ func Drop(dsn string) (err error) {
 		return
 	}
 
-	req := new(bp.DropDatabaseRequest)
-	req.Header.DatabaseID = proto.DatabaseID(cfg.DatabaseID)
-	if req.Header.Signee, err = kms.GetLocalPublicKey(); err != nil {
-		return
-	}
-	var privateKey *asymmetric.PrivateKey
-	if privateKey, err = kms.GetLocalPrivateKey(); err != nil {
+	var id *Identity
+	if id, err = getIdentity(cfg.Identity); err != nil {
 		return
 	}
-	if err = req.Sign(privateKey); err != nil {
+
+	req := new(bp.DropDatabaseRequest)
+	req.Header.DatabaseID = proto.DatabaseID(cfg.DatabaseID)
+	req.Header.Signee = id.PublicKey
+	if err = req.Sign(id.Signer); err != nil {
 		return
 	}
 	res := new(bp.DropDatabaseResponse)
@@ -129,7 +155,8 @@ func Drop(dsn string) (err error) {
 	return
 }
 
-// GetStableCoinBalance get the stable coin balance of current account.
+// GetStableCoinBalance get the stable coin balance of current account, which is committed on the
+// main chain and verified with the state proof.
 func GetStableCoinBalance() (balance uint64, err error) {
 	req := new(bp.QueryAccountStableBalanceReq)
 	resp := new(bp.QueryAccountStableBalanceResp)
@@ -143,14 +170,22 @@ func GetStableCoinBalance() (balance uint64, err error) {
 		return
 	}
 
-	if err = requestBP(route.MCCQueryAccountStableBalance, req, resp); err == nil {
-		balance = resp.Balance
+	if err = requestBP(route.MCCQueryAccountStableBalance, req, resp); err != nil {
+		return
+	}
+
+	if err = verifyAccount(req.Addr, resp.Committed, resp.Proof); err != nil {
+		return
+	}
+	if resp.Committed != nil {
+		balance = resp.Committed.StableCoinBalance
 	}
 
 	return
 }
 
-// GetCovenantCoinBalance get the covenant coin balance of current account.
+// GetCovenantCoinBalance get the covenant coin balance of current account, which is committed on
+// the main chain and verified with the state proof.
 func GetCovenantCoinBalance() (balance uint64, err error) {
 	req := new(bp.QueryAccountCovenantBalanceReq)
 	resp := new(bp.QueryAccountCovenantBalanceResp)
@@ -164,10 +199,196 @@ func GetCovenantCoinBalance() (balance uint64, err error) {
 		return
 	}
 
-	if err = requestBP(route.MCCQueryAccountCovenantBalance, req, resp); err == nil {
-		balance = resp.Balance
+	if err = requestBP(route.MCCQueryAccountCovenantBalance, req, resp); err != nil {
+		return
+	}
+
+	if err = verifyAccount(req.Addr, resp.Committed, resp.Proof); err != nil {
+		return
+	}
+	if resp.Committed != nil {
+		balance = resp.Committed.CovenantCoinBalance
+	}
+
+	return
+}
+
+// GetDatabases gets the databases owned by current account.
+func GetDatabases() (databases []proto.DatabaseID, err error) {
+	req := new(bp.QueryAccountDatabasesReq)
+	resp := new(bp.QueryAccountDatabasesResp)
+
+	if req.Addr, err = getLocalAccountAddress(); err != nil {
+		return
+	}
+
+	if err = requestBP(route.MCCQueryAccountDatabases, req, resp); err == nil {
+		databases = resp.Databases
+	}
+
+	return
+}
+
+// GetDatabaseProfile gets the profile of the database, including its users, miners, deposit and
+// arrears, which is committed on the main chain and verified with the state proof.
+func GetDatabaseProfile(dsn string) (profile *pt.SQLChainProfile, err error) {
+	var cfg *Config
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+
+	req := new(bp.QuerySQLChainProfileReq)
+	resp := new(bp.QuerySQLChainProfileResp)
+	req.DBID = proto.DatabaseID(cfg.DatabaseID)
+
+	if err = requestBP(route.MCCQuerySQLChainProfile, req, resp); err != nil {
+		return
+	}
+
+	if err = verifyDatabase(req.DBID, resp.Committed, resp.Proof); err != nil {
+		return
+	}
+	if resp.Committed == nil {
+		err = ErrDatabaseNotCommitted
+		return
+	}
+	profile = resp.Committed
+
+	return
+}
+
+// GetTransactionHistory gets the transactions involving current account, the latest first. The
+// first offset transactions are skipped, and at most limit ones are returned if limit is not 0.
+func GetTransactionHistory(offset, limit uint32) (txs []pi.Transaction, err error) {
+	req := new(bp.QueryTxHistoryReq)
+	resp := new(bp.QueryTxHistoryResp)
+	req.Offset = offset
+	req.Limit = limit
+
+	if req.Addr, err = getLocalAccountAddress(); err != nil {
+		return
+	}
+
+	if err = requestBP(route.MCCQueryTxHistory, req, resp); err != nil {
+		return
+	}
+
+	txs = make([]pi.Transaction, 0, len(resp.Records))
+	for _, r := range resp.Records {
+		var tx pi.Transaction
+		if tx, err = r.Transaction(); err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+
+	return
+}
+
+// GetTransaction gets the transaction of the hash.
+func GetTransaction(txHash hash.Hash) (tx pi.Transaction, err error) {
+	req := new(bp.QueryTxByHashReq)
+	resp := new(bp.QueryTxByHashResp)
+	req.Hash = txHash
+
+	if err = requestBP(route.MCCQueryTxByHash, req, resp); err != nil {
+		return
+	}
+
+	return resp.Record.Transaction()
+}
+
+func getLocalAccountAddress() (addr proto.AccountAddress, err error) {
+	var pubKey *asymmetric.PublicKey
+	if pubKey, err = kms.GetLocalPublicKey(); err != nil {
+		return
 	}
 
+	return utils.PubKeyHash(pubKey)
+}
+
+// getBlockProducers returns the public keys of the block producers in configuration, which commit
+// the main chain blocks.
+func getBlockProducers() (producers map[proto.NodeID]*asymmetric.PublicKey) {
+	producers = make(map[proto.NodeID]*asymmetric.PublicKey)
+	if conf.GConf == nil {
+		return
+	}
+	for _, n := range conf.GConf.KnownNodes {
+		if (n.Role == proto.Leader || n.Role == proto.Follower) && n.PublicKey != nil {
+			producers[n.ID] = n.PublicKey
+		}
+	}
+	if len(producers) == 0 && conf.GConf.BP != nil && conf.GConf.BP.PublicKey != nil {
+		producers[conf.GConf.BP.NodeID] = conf.GConf.BP.PublicKey
+	}
+	return
+}
+
+// isBlockProducer returns whether pubKey is the public key of a block producer in configuration.
+func isBlockProducer(pubKey *asymmetric.PublicKey) bool {
+	if pubKey == nil {
+		return false
+	}
+	for _, v := range getBlockProducers() {
+		if v.IsEqual(pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyStateProofAge verifies that the state proof is proved with a block not older than
+// MaxStateProofAge.
+func verifyStateProofAge(proof *pt.StateProof) error {
+	if getLocalTime().Sub(proof.Header.Timestamp) > MaxStateProofAge {
+		return ErrStaleStateProof
+	}
+	return nil
+}
+
+// verifyAccount verifies the account returned by a block producer with the state proof, so that a
+// block producer cannot lie about it.
+func verifyAccount(addr proto.AccountAddress, account *pt.Account, proof *pt.StateProof) error {
+	if proof == nil {
+		return ErrMissingStateProof
+	}
+	if err := proof.VerifyAccount(getBlockProducers(), addr, account); err != nil {
+		return err
+	}
+	return verifyStateProofAge(proof)
+}
+
+// verifyDatabase verifies the SQLChain profile returned by a block producer with the state proof,
+// so that a block producer cannot lie about it.
+func verifyDatabase(id proto.DatabaseID, profile *pt.SQLChainProfile, proof *pt.StateProof) error {
+	if proof == nil {
+		return ErrMissingStateProof
+	}
+	if err := proof.VerifyDatabase(getBlockProducers(), id, profile); err != nil {
+		return err
+	}
+	return verifyStateProofAge(proof)
+}
+
+// authorizeDeposit signs the deposit authorization of the identity with its next deposit nonce.
+func authorizeDeposit(id *Identity, maxDeposit uint64) (auth pt.DepositAuthorization, err error) {
+	var (
+		req  = new(bp.NextAccountNonceReq)
+		resp = new(bp.NextAccountNonceResp)
+	)
+	if req.Addr, err = utils.PubKeyHash(id.PublicKey); err != nil {
+		return
+	}
+	if err = requestBP(route.MCCNextAccountNonce, req, resp); err != nil {
+		return
+	}
+
+	auth.Owner = req.Addr
+	auth.Nonce = resp.DepositNonce
+	auth.MaxDeposit = maxDeposit
+	err = auth.Sign(id.Signer)
+
 	return
 }
 
@@ -19,7 +19,12 @@ package client
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/merkle"
+	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	. "github.com/smartystreets/goconvey/convey"
@@ -101,3 +106,82 @@ func TestGetStableCoinBalance(t *testing.T) {
 		So(balance, ShouldEqual, 0)
 	})
 }
+
+func TestGetDatabases(t *testing.T) {
+	Convey("test get databases", t, func() {
+		var stopTestService func()
+		var err error
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		var databases []proto.DatabaseID
+		databases, err = GetDatabases()
+
+		So(err, ShouldBeNil)
+		So(databases, ShouldResemble, []proto.DatabaseID{"db"})
+	})
+}
+
+func TestGetDatabaseProfile(t *testing.T) {
+	Convey("test get database profile", t, func() {
+		var stopTestService func()
+		var err error
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		var profile *pt.SQLChainProfile
+		profile, err = GetDatabaseProfile("covenantsql://db")
+
+		So(err, ShouldBeNil)
+		So(profile.ID, ShouldEqual, proto.DatabaseID("db"))
+	})
+}
+
+func TestGetTransactions(t *testing.T) {
+	Convey("test get transactions", t, func() {
+		var stopTestService func()
+		var err error
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		var txs []pi.Transaction
+		txs, err = GetTransactionHistory(0, 10)
+		So(err, ShouldBeNil)
+		So(txs, ShouldHaveLength, 1)
+		So(txs[0].Verify(), ShouldBeNil)
+
+		var tx pi.Transaction
+		tx, err = GetTransaction(txs[0].GetHash())
+		So(err, ShouldBeNil)
+		So(tx.GetHash(), ShouldEqual, txs[0].GetHash())
+	})
+}
+
+func TestVerifyStateProof(t *testing.T) {
+	Convey("test verify state proof", t, func() {
+		var stopTestService func()
+		var err error
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		var (
+			addr  = proto.AccountAddress{0x1}
+			key   = pt.AccountStateKey(addr)
+			proof *pt.StateProof
+		)
+		proof, err = getTestStateProof(merkle.NewPatricia(), key)
+		So(err, ShouldBeNil)
+		So(verifyAccount(addr, nil, proof), ShouldBeNil)
+		So(verifyAccount(addr, nil, nil), ShouldEqual, ErrMissingStateProof)
+
+		// a proof of a stale block should be refused
+		proof, err = getTestStateProofAt(
+			merkle.NewPatricia(), key, time.Now().UTC().Add(-MaxStateProofAge-time.Minute))
+		So(err, ShouldBeNil)
+		So(verifyAccount(addr, nil, proof), ShouldEqual, ErrStaleStateProof)
+	})
+}
@@ -21,4 +21,19 @@ import "errors"
 // Various errors the driver might returns.
 var (
 	ErrQueryInTransaction = errors.New("only write is supported during transaction")
+	ErrInvalidIdentity    = errors.New("invalid identity")
+	ErrIdentityNotFound   = errors.New("identity not registered")
+	// ErrMissingStateProof indicates that a block producer does not prove its response.
+	ErrMissingStateProof = errors.New("missing state proof")
+	// ErrStaleStateProof indicates that a block producer proves its response with a stale block.
+	ErrStaleStateProof = errors.New("stale state proof")
+	// ErrInvalidPeers indicates that the peers of a database are not signed by a block producer, or
+	// that they are older than the known ones.
+	ErrInvalidPeers = errors.New("invalid database peers")
+	// ErrDatabaseNotCommitted indicates that the database is not committed on the main chain yet.
+	ErrDatabaseNotCommitted = errors.New("database not committed")
+	// ErrInvalidResponse indicates that the results of response do not match the queries of request.
+	ErrInvalidResponse = errors.New("invalid response")
+	// ErrResultNotAvailable indicates that the result of query in transaction is read before commit.
+	ErrResultNotAvailable = errors.New("result not available until transaction is committed")
 )
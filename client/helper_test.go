@@ -28,12 +28,14 @@ import (
 	"time"
 
 	bp "github.com/CovenantSQL/CovenantSQL/blockproducer"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/consistent"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/kayak"
+	"github.com/CovenantSQL/CovenantSQL/merkle"
 	"github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
@@ -146,13 +148,121 @@ func (s *stubBPDBService) getInstanceMeta(dbID proto.DatabaseID) (instance wt.Se
 	return
 }
 
+func (s *stubBPDBService) NextAccountNonce(req *bp.NextAccountNonceReq,
+	resp *bp.NextAccountNonceResp) (err error) {
+	resp.Addr = req.Addr
+	return
+}
+
 func (s *stubBPDBService) QueryAccountStableBalance(req *bp.QueryAccountStableBalanceReq,
 	resp *bp.QueryAccountStableBalanceResp) (err error) {
+	resp.Addr = req.Addr
+	resp.Proof, err = getTestStateProof(merkle.NewPatricia(), pt.AccountStateKey(req.Addr))
 	return
 }
 
 func (s *stubBPDBService) QueryAccountCovenantBalance(req *bp.QueryAccountCovenantBalanceReq,
 	resp *bp.QueryAccountCovenantBalanceResp) (err error) {
+	resp.Addr = req.Addr
+	resp.Proof, err = getTestStateProof(merkle.NewPatricia(), pt.AccountStateKey(req.Addr))
+	return
+}
+
+func (s *stubBPDBService) QueryAccountDatabases(req *bp.QueryAccountDatabasesReq,
+	resp *bp.QueryAccountDatabasesResp) (err error) {
+	resp.Addr = req.Addr
+	resp.Databases = []proto.DatabaseID{"db"}
+	return
+}
+
+func (s *stubBPDBService) QuerySQLChainProfile(req *bp.QuerySQLChainProfileReq,
+	resp *bp.QuerySQLChainProfileResp) (err error) {
+	var (
+		trie = merkle.NewPatricia()
+		enc  []byte
+	)
+	resp.Profile.ID = req.DBID
+	if enc, err = resp.Profile.MarshalHash(); err != nil {
+		return
+	}
+	trie.Insert(pt.DatabaseStateKey(req.DBID), enc)
+	resp.Committed = &resp.Profile
+	resp.Proof, err = getTestStateProof(trie, pt.DatabaseStateKey(req.DBID))
+	return
+}
+
+func (s *stubBPDBService) QueryTxHistory(req *bp.QueryTxHistoryReq,
+	resp *bp.QueryTxHistoryResp) (err error) {
+	var record *bp.TxRecord
+	if record, err = getTestTxRecord(); err != nil {
+		return
+	}
+	resp.Addr = req.Addr
+	resp.Records = []*bp.TxRecord{record}
+	return
+}
+
+func (s *stubBPDBService) QueryTxByHash(req *bp.QueryTxByHashReq,
+	resp *bp.QueryTxByHashResp) (err error) {
+	resp.Record, err = getTestTxRecord()
+	return
+}
+
+// getTestStateProof returns the state proof of key in trie, with a block committed by the local
+// node as the only block producer.
+func getTestStateProof(trie *merkle.Trie, key []byte) (p *pt.StateProof, err error) {
+	return getTestStateProofAt(trie, key, time.Now().UTC())
+}
+
+// getTestStateProofAt works as getTestStateProof with a block of timestamp ts.
+func getTestStateProofAt(trie *merkle.Trie, key []byte, ts time.Time) (p *pt.StateProof, err error) {
+	var (
+		privKey *asymmetric.PrivateKey
+		nodeID  proto.NodeID
+		b       = &pt.Block{
+			SignedHeader: pt.SignedHeader{
+				Header: pt.Header{
+					StateRoot: trie.Root(),
+					Timestamp: ts,
+				},
+			},
+		}
+	)
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if nodeID, err = kms.GetLocalNodeID(); err != nil {
+		return
+	}
+	if err = b.PackAndSignBlock(privKey); err != nil {
+		return
+	}
+	commit := pt.NewVote(pt.VoteTypePrecommit, 1, b.SignedHeader.BlockHash, nodeID)
+	if err = commit.Sign(privKey); err != nil {
+		return
+	}
+	p = &pt.StateProof{
+		Header:  b.SignedHeader,
+		Commits: []*pt.Vote{commit},
+		Proof:   trie.Prove(key),
+	}
+	return
+}
+
+func getTestTxRecord() (record *bp.TxRecord, err error) {
+	var privKey *asymmetric.PrivateKey
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	tx := &pt.Transfer{TransferHeader: pt.TransferHeader{Amount: 1}}
+	if err = tx.Sign(privKey); err != nil {
+		return
+	}
+	record = &bp.TxRecord{
+		Type: tx.GetTransactionType(),
+		Hash: tx.GetHash(),
+	}
+	record.Tx, err = tx.Serialize()
 	return
 }
 
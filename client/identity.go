@@ -0,0 +1,114 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+var (
+	identities     = make(map[string]*Identity)
+	identitiesLock sync.RWMutex
+)
+
+// Identity defines a signer and node id used to sign requests on behalf of a user, the signer
+// could be a private key, a key of kms.Wallet or a kms.RemoteSigner.
+type Identity struct {
+	NodeID    proto.NodeID
+	Signer    asymmetric.Signer
+	PublicKey *asymmetric.PublicKey
+}
+
+// NewIdentity builds identity from signer, the node id is computed with the nonce.
+// A nil nonce stands for the zero nonce, the resulting node id has no proof of work guarantee.
+func NewIdentity(signer asymmetric.Signer, nonce *mine.Uint256) (id *Identity, err error) {
+	if signer == nil {
+		err = ErrInvalidIdentity
+		return
+	}
+
+	if nonce == nil {
+		nonce = &mine.Uint256{}
+	}
+
+	publicKey := signer.PubKey()
+	nodeIDHash := mine.HashBlock(publicKey.Serialize(), *nonce)
+
+	id = &Identity{
+		NodeID:    proto.NodeID(nodeIDHash.String()),
+		Signer:    signer,
+		PublicKey: publicKey,
+	}
+
+	return
+}
+
+// RegisterIdentity registers a named identity which could be used by the identity DSN parameter.
+func RegisterIdentity(name string, id *Identity) (err error) {
+	if name == "" || id == nil || id.Signer == nil || id.PublicKey == nil || id.NodeID.IsEmpty() {
+		return ErrInvalidIdentity
+	}
+
+	identitiesLock.Lock()
+	defer identitiesLock.Unlock()
+
+	identities[name] = id
+
+	return
+}
+
+// DeregisterIdentity removes the named identity from registry.
+func DeregisterIdentity(name string) {
+	identitiesLock.Lock()
+	defer identitiesLock.Unlock()
+
+	delete(identities, name)
+}
+
+// getIdentity returns the named identity, empty name stands for the local node identity.
+func getIdentity(name string) (id *Identity, err error) {
+	if name == "" {
+		id = &Identity{}
+
+		if id.NodeID, err = kms.GetLocalNodeID(); err != nil {
+			return
+		}
+		if id.Signer, err = kms.GetLocalSigner(); err != nil {
+			return
+		}
+		if id.PublicKey, err = kms.GetLocalPublicKey(); err != nil {
+			return
+		}
+
+		return
+	}
+
+	identitiesLock.RLock()
+	defer identitiesLock.RUnlock()
+
+	var ok bool
+	if id, ok = identities[name]; !ok {
+		err = ErrIdentityNotFound
+	}
+
+	return
+}
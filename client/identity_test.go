@@ -0,0 +1,55 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdentity(t *testing.T) {
+	Convey("test identity registry", t, func() {
+		privKey, pubKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+
+		_, err = NewIdentity(nil, nil)
+		So(err, ShouldEqual, ErrInvalidIdentity)
+
+		nonce := &mine.Uint256{A: 1}
+		id, err := NewIdentity(privKey, nonce)
+		So(err, ShouldBeNil)
+		So(id.PublicKey.IsEqual(pubKey), ShouldBeTrue)
+		So(kms.IsIDPubNonceValid(id.NodeID.ToRawNodeID(), nonce, pubKey), ShouldBeTrue)
+
+		So(RegisterIdentity("", id), ShouldEqual, ErrInvalidIdentity)
+		So(RegisterIdentity("alice", &Identity{NodeID: proto.NodeID("")}), ShouldEqual, ErrInvalidIdentity)
+		So(RegisterIdentity("alice", id), ShouldBeNil)
+
+		got, err := getIdentity("alice")
+		So(err, ShouldBeNil)
+		So(got, ShouldEqual, id)
+
+		DeregisterIdentity("alice")
+		_, err = getIdentity("alice")
+		So(err, ShouldEqual, ErrIdentityNotFound)
+	})
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	wt "github.com/CovenantSQL/CovenantSQL/worker/types"
+)
+
+// execResult implements driver.Result, queries in transaction are sent on commit, so their
+// results are not available until the transaction is committed.
+type execResult struct {
+	ready        bool
+	affectedRows int64
+	lastInsertID int64
+}
+
+func (r *execResult) set(res *wt.ResponseExecResult) {
+	r.ready = true
+	r.affectedRows = res.AffectedRows
+	r.lastInsertID = res.LastInsertID
+}
+
+// LastInsertId implements driver.Result.LastInsertId method.
+func (r *execResult) LastInsertId() (int64, error) {
+	if !r.ready {
+		return 0, ErrResultNotAvailable
+	}
+	return r.lastInsertID, nil
+}
+
+// RowsAffected implements driver.Result.RowsAffected method.
+func (r *execResult) RowsAffected() (int64, error) {
+	if !r.ready {
+		return 0, ErrResultNotAvailable
+	}
+	return r.affectedRows, nil
+}
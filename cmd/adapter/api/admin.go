@@ -30,34 +30,25 @@ func init() {
 
 	// add routes
 	adminRoutes := GetV1Router().PathPrefix("/admin").Subrouter()
-	adminRoutes.Use(adminPrivilegeChecker)
 	adminRoutes.HandleFunc("/create", api.CreateDatabase).Methods("POST")
 	adminRoutes.HandleFunc("/drop", api.DropDatabase).Methods("DELETE")
 }
 
-func adminPrivilegeChecker(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
-			cert := r.TLS.PeerCertificates[0]
-
-			for _, privilegedCert := range config.GetConfig().AdminCertificates {
-				if cert.Equal(privilegedCert) {
-					next.ServeHTTP(rw, r)
-					return
-				}
-			}
-		}
-
-		// forbidden
-		sendResponse(http.StatusForbidden, false, nil, nil, rw)
-	})
-}
-
 // adminAPI defines admin features such as database create/drop.
 type adminAPI struct{}
 
 // CreateDatabase defines create database admin API.
 func (a *adminAPI) CreateDatabase(rw http.ResponseWriter, r *http.Request) {
+	// new database is not bound to any database scope
+	if !checkPrivilege(rw, r, "", config.AdminOperation) {
+		return
+	}
+
+	s, ok := getStorage(rw, r)
+	if !ok {
+		return
+	}
+
 	nodeCntStr := r.FormValue("node")
 	nodeCnt, err := strconv.Atoi(nodeCntStr)
 
@@ -67,7 +58,7 @@ func (a *adminAPI) CreateDatabase(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	var dbID string
-	if dbID, err = config.GetConfig().StorageInstance.Create(nodeCnt); err != nil {
+	if dbID, err = s.Create(nodeCnt); err != nil {
 		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
 		return
 	}
@@ -84,8 +75,17 @@ func (a *adminAPI) DropDatabase(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkPrivilege(rw, r, dbID, config.AdminOperation) {
+		return
+	}
+
+	s, ok := getStorage(rw, r)
+	if !ok {
+		return
+	}
+
 	var err error
-	if err = config.GetConfig().StorageInstance.Drop(dbID); err != nil {
+	if err = s.Drop(dbID); err != nil {
 		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
 		return
 	}
@@ -0,0 +1,179 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/config"
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/schema"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+func init() {
+	var api graphQLAPI
+
+	// add routes
+	GetV1Router().HandleFunc("/graphql", api.Query).Methods("GET", "POST")
+	GetV1Router().HandleFunc("/graphql/schema", api.Schema).Methods("GET")
+}
+
+// graphQLAPI defines graphql api generated from database schema.
+type graphQLAPI struct{}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Schema returns the generated graphql schema definition.
+func (a *graphQLAPI) Schema(rw http.ResponseWriter, r *http.Request) {
+	ctx, ok := getAutoAPIContext(rw, r)
+	if !ok {
+		return
+	}
+
+	sendResponse(http.StatusOK, true, nil, map[string]interface{}{
+		"sdl": ctx.schema.SDL(),
+	}, rw)
+}
+
+// Query executes graphql query or mutation, mutations are executed in one transaction.
+func (a *graphQLAPI) Query(rw http.ResponseWriter, r *http.Request) {
+	req, ok := buildGraphQLRequest(rw, r)
+	if !ok {
+		return
+	}
+
+	ctx, ok := getAutoAPIContext(rw, r)
+	if !ok {
+		return
+	}
+
+	op, err := schema.ParseGraphQL(req.Query, req.OperationName)
+	if err != nil {
+		sendGraphQLError(http.StatusBadRequest, err, rw)
+		return
+	}
+
+	if op.Type == schema.MutationOperation && r.Method != http.MethodPost {
+		sendGraphQLError(http.StatusMethodNotAllowed, "Mutation requires POST method", rw)
+		return
+	}
+
+	autoAPI := config.GetConfig().AutoAPI
+	plan, err := ctx.schema.Plan(op, req.Variables, schema.Limits{Default: autoAPI.DefaultLimit, Max: autoAPI.MaxLimit})
+	if err != nil {
+		sendGraphQLError(http.StatusBadRequest, err, rw)
+		return
+	}
+
+	queries, patterns := toStorageQueries(plan.Statements())
+
+	operation := config.ReadOperation
+	if op.Type == schema.MutationOperation {
+		operation = config.WriteOperation
+	}
+	if len(patterns) > 0 && !checkPrivilege(rw, r, ctx.dbID, operation, patterns...) {
+		return
+	}
+
+	log.WithField("db", ctx.dbID).WithField("operation", op.Type).WithField("count", len(queries)).Infof("got graphql")
+
+	var rows [][][]interface{}
+	var affectedRows []int64
+
+	if op.Type == schema.MutationOperation {
+		if len(queries) > 0 {
+			if affectedRows, err = ctx.storage.ExecTx(ctx.dbID, queries); err != nil {
+				sendGraphQLError(http.StatusInternalServerError, err, rw)
+				return
+			}
+		}
+	} else {
+		for _, q := range queries {
+			var result [][]interface{}
+			if _, _, result, err = ctx.storage.Query(ctx.dbID, q.Pattern, q.Args...); err != nil {
+				sendGraphQLError(http.StatusInternalServerError, err, rw)
+				return
+			}
+			rows = append(rows, result)
+		}
+	}
+
+	sendResponse(http.StatusOK, true, nil, plan.Data(rows, affectedRows), rw)
+}
+
+// buildGraphQLRequest reads graphql request from json body or query/variables/operationName parameters.
+func buildGraphQLRequest(rw http.ResponseWriter, r *http.Request) (req *graphQLRequest, ok bool) {
+	req = &graphQLRequest{}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch {
+	case r.Method == http.MethodPost && mediaType == "application/json":
+		if err := decodeJSONBody(r, req); err != nil {
+			sendGraphQLError(http.StatusBadRequest, "Invalid json body", rw)
+			return nil, false
+		}
+	case r.Method == http.MethodPost && mediaType == "application/graphql":
+		query, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			sendGraphQLError(http.StatusBadRequest, err, rw)
+			return nil, false
+		}
+		req.Query = string(query)
+	default:
+		req.Query = r.FormValue("query")
+		req.OperationName = r.FormValue("operationName")
+		if rawVariables := r.FormValue("variables"); rawVariables != "" {
+			decoder := json.NewDecoder(strings.NewReader(rawVariables))
+			decoder.UseNumber()
+			if err := decoder.Decode(&req.Variables); err != nil {
+				sendGraphQLError(http.StatusBadRequest, "Invalid variables parameter", rw)
+				return nil, false
+			}
+		}
+	}
+
+	if req.Query == "" {
+		sendGraphQLError(http.StatusBadRequest, "Missing query parameter", rw)
+		return nil, false
+	}
+
+	return req, true
+}
+
+// sendGraphQLError sends error response with graphql errors list for graphql clients.
+func sendGraphQLError(code int, msg interface{}, rw http.ResponseWriter) {
+	msgStr := fmt.Sprint(msg)
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":  msgStr,
+		"success": false,
+		"data":    nil,
+		"errors": []map[string]interface{}{
+			{"message": msgStr},
+		},
+	})
+}
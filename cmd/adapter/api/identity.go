@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/config"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+)
+
+func init() {
+	var api identityAPI
+
+	// add routes
+	GetV1Router().HandleFunc("/identity", api.SetIdentity).Methods("PUT", "POST")
+	GetV1Router().HandleFunc("/identity", api.ClearIdentity).Methods("DELETE")
+}
+
+// identityAPI defines delegated signing key management of authenticated principals.
+type identityAPI struct{}
+
+// SetIdentity uploads the private key used to sign queries of request principal.
+func (a *identityAPI) SetIdentity(rw http.ResponseWriter, r *http.Request) {
+	keyBytes, err := hex.DecodeString(r.FormValue("key"))
+	if err != nil || len(keyBytes) != asymmetric.PrivateKeyBytesLen {
+		sendResponse(http.StatusBadRequest, false, "Invalid private key", nil, rw)
+		return
+	}
+
+	var nonce *mine.Uint256
+	if nonceStr := r.FormValue("nonce"); nonceStr != "" {
+		var nonceBytes []byte
+		if nonceBytes, err = hex.DecodeString(nonceStr); err == nil {
+			nonce, err = mine.Uint256FromBytes(nonceBytes)
+		}
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, "Invalid nonce", nil, rw)
+			return
+		}
+	}
+
+	privateKey, _ := asymmetric.PrivKeyFromBytes(keyBytes)
+
+	if err = config.GetConfig().Policy.SetIdentity(r, privateKey, nonce); err != nil {
+		sendAuthError(rw, err)
+		return
+	}
+
+	sendResponse(http.StatusOK, true, nil, nil, rw)
+}
+
+// ClearIdentity removes the uploaded private key of request principal.
+func (a *identityAPI) ClearIdentity(rw http.ResponseWriter, r *http.Request) {
+	if err := config.GetConfig().Policy.ClearIdentity(r); err != nil {
+		sendAuthError(rw, err)
+		return
+	}
+
+	sendResponse(http.StatusOK, true, nil, nil, rw)
+}
@@ -30,6 +30,7 @@ func init() {
 	// add routes
 	GetV1Router().HandleFunc("/query", api.Query).Methods("GET", "POST")
 	GetV1Router().HandleFunc("/exec", api.Write).Methods("GET", "POST")
+	GetV1Router().HandleFunc("/batch", api.Batch).Methods("POST")
 }
 
 // queryAPI defines query features such as database update/select.
@@ -47,7 +48,21 @@ func (a *queryAPI) Query(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.WithField("db", dbID).WithField("query", query).Infof("got query")
+	if !checkPrivilege(rw, r, dbID, config.ReadOperation, query) {
+		return
+	}
+
+	args, ok := buildArgs(rw, r)
+	if !ok {
+		return
+	}
+
+	s, ok := getStorage(rw, r)
+	if !ok {
+		return
+	}
+
+	log.WithField("db", dbID).WithField("query", query).WithField("args", args).Infof("got query")
 
 	assoc := r.FormValue("assoc")
 
@@ -55,7 +70,7 @@ func (a *queryAPI) Query(rw http.ResponseWriter, r *http.Request) {
 	var types []string
 	var rows [][]interface{}
 	var err error
-	if columns, types, rows, err = config.GetConfig().StorageInstance.Query(dbID, query); err != nil {
+	if columns, types, rows, err = s.Query(dbID, query, args...); err != nil {
 		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
 		return
 	}
@@ -96,53 +111,86 @@ func (a *queryAPI) Query(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Exec defines write query for database.
+// Write defines write query for database.
 func (a *queryAPI) Write(rw http.ResponseWriter, r *http.Request) {
-	// check privilege
-	hasPrivilege := false
-	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
-		cert := r.TLS.PeerCertificates[0]
-
-		for _, privilegedCert := range config.GetConfig().WriteCertificates {
-			if cert.Equal(privilegedCert) {
-				hasPrivilege = true
-				break
-			}
-		}
+	query := buildQuery(rw, r)
+	if query == "" {
+		return
+	}
 
-		if !hasPrivilege {
-			for _, privilegedCert := range config.GetConfig().AdminCertificates {
-				if cert.Equal(privilegedCert) {
-					hasPrivilege = true
-					break
-				}
-			}
-		}
+	dbID := getDatabaseID(rw, r)
+	if dbID == "" {
+		return
 	}
 
-	// forbidden
-	if !hasPrivilege {
-		sendResponse(http.StatusForbidden, false, nil, nil, rw)
+	if !checkPrivilege(rw, r, dbID, config.WriteOperation, query) {
 		return
 	}
 
-	query := buildQuery(rw, r)
-	if query == "" {
+	args, ok := buildArgs(rw, r)
+	if !ok {
 		return
 	}
 
+	s, ok := getStorage(rw, r)
+	if !ok {
+		return
+	}
+
+	log.WithField("db", dbID).WithField("query", query).WithField("args", args).Infof("got exec")
+
+	affectedRows, lastInsertID, err := s.Exec(dbID, query, args...)
+	if err != nil {
+		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
+		return
+	}
+
+	// raw queries may alter the schema of generated api
+	invalidateSchema(dbID)
+
+	sendResponse(http.StatusOK, true, nil, map[string]interface{}{
+		"affected_rows":  affectedRows,
+		"last_insert_id": lastInsertID,
+	}, rw)
+}
+
+// Batch defines multiple write queries executed in one transaction.
+func (a *queryAPI) Batch(rw http.ResponseWriter, r *http.Request) {
 	dbID := getDatabaseID(rw, r)
 	if dbID == "" {
 		return
 	}
 
-	log.WithField("db", dbID).WithField("query", query).Infof("got exec")
+	queries := buildQueries(rw, r)
+	if len(queries) == 0 {
+		return
+	}
+
+	patterns := make([]string, 0, len(queries))
+	for _, q := range queries {
+		patterns = append(patterns, q.Pattern)
+	}
 
-	var err error
-	if err = config.GetConfig().StorageInstance.Exec(dbID, query); err != nil {
+	if !checkPrivilege(rw, r, dbID, config.WriteOperation, patterns...) {
+		return
+	}
+
+	s, ok := getStorage(rw, r)
+	if !ok {
+		return
+	}
+
+	log.WithField("db", dbID).WithField("count", len(queries)).Infof("got batch")
+
+	affectedRows, err := s.ExecTx(dbID, queries)
+	if err != nil {
 		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
 		return
 	}
 
-	sendResponse(http.StatusOK, true, nil, nil, rw)
+	invalidateSchema(dbID)
+
+	sendResponse(http.StatusOK, true, nil, map[string]interface{}{
+		"affected_rows": affectedRows,
+	}, rw)
 }
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/config"
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/schema"
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/storage"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+var (
+	// schema cache of databases with generated api, keyed by database and principal
+	// since each principal sees the schema of its own identity and table grants.
+	schemaCache     = make(map[schemaCacheKey]*cachedSchema)
+	schemaCacheLock sync.Mutex
+)
+
+type schemaCacheKey struct {
+	dbID      string
+	principal string
+}
+
+type cachedSchema struct {
+	schema   *schema.Schema
+	loadedAt time.Time
+}
+
+// autoAPIContext defines the resolved database context of generated api request.
+type autoAPIContext struct {
+	dbID    string
+	storage storage.Storage
+	schema  *schema.Schema
+}
+
+// getAutoAPIContext checks the generated api is enabled and readable for database,
+// then loads the database schema.
+func getAutoAPIContext(rw http.ResponseWriter, r *http.Request) (ctx *autoAPIContext, ok bool) {
+	dbID := getDatabaseID(rw, r)
+	if dbID == "" {
+		return
+	}
+
+	if !config.GetConfig().AutoAPIEnabled(dbID) {
+		sendResponse(http.StatusNotFound, false, "Generated api is not enabled for database", nil, rw)
+		return
+	}
+
+	if !checkPrivilege(rw, r, dbID, config.ReadOperation) {
+		return
+	}
+
+	policy := config.GetConfig().Policy
+	principal, err := policy.Principal(r)
+	if err != nil {
+		sendAuthError(rw, err)
+		return
+	}
+	tables, err := policy.AllowedTables(r, dbID, config.ReadOperation)
+	if err != nil {
+		sendAuthError(rw, err)
+		return
+	}
+
+	ctx = &autoAPIContext{dbID: dbID}
+	if ctx.storage, ok = getStorage(rw, r); !ok {
+		return nil, false
+	}
+
+	key := schemaCacheKey{dbID: dbID, principal: principal}
+	if ctx.schema, err = loadSchema(ctx.storage, key, tables); err != nil {
+		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
+		return nil, false
+	}
+
+	return ctx, true
+}
+
+// loadSchema returns cached database schema or discovers schema with storage,
+// only the allowed tables are kept, nil allowed tables stands for all tables.
+func loadSchema(s storage.Storage, key schemaCacheKey, allowed map[string]bool) (sch *schema.Schema, err error) {
+	schemaCacheLock.Lock()
+	cached, ok := schemaCache[key]
+	schemaCacheLock.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < config.GetConfig().AutoAPI.SchemaTTL {
+		return cached.schema, nil
+	}
+
+	if sch, err = schema.Load(func(query string, args ...interface{}) (columns []string, rows [][]interface{}, err error) {
+		columns, _, rows, err = s.Query(key.dbID, query, args...)
+		return
+	}); err != nil {
+		log.WithField("db", key.dbID).Errorf("load database schema failed: %v", err)
+		return
+	}
+
+	if allowed != nil {
+		tables := make([]*schema.Table, 0, len(sch.Tables))
+		for _, t := range sch.Tables {
+			if allowed[strings.ToLower(t.Name)] {
+				tables = append(tables, t)
+			}
+		}
+		sch = schema.NewSchema(tables)
+	}
+
+	schemaCacheLock.Lock()
+	schemaCache[key] = &cachedSchema{schema: sch, loadedAt: time.Now()}
+	schemaCacheLock.Unlock()
+
+	return
+}
+
+// invalidateSchema drops cached schemas of database after raw queries which may alter schema.
+func invalidateSchema(dbID string) {
+	schemaCacheLock.Lock()
+	defer schemaCacheLock.Unlock()
+
+	for key := range schemaCache {
+		if key.dbID == dbID {
+			delete(schemaCache, key)
+		}
+	}
+}
+
+func toStorageQueries(stmts []*schema.Statement) (queries []storage.Query, patterns []string) {
+	queries = make([]storage.Query, 0, len(stmts))
+	patterns = make([]string, 0, len(stmts))
+
+	for _, stmt := range stmts {
+		queries = append(queries, storage.Query{Pattern: stmt.Query, Args: stmt.Args})
+		patterns = append(patterns, stmt.Query)
+	}
+
+	return
+}
+
+func decodeJSONBody(r *http.Request, v interface{}) (err error) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
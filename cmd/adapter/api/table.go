@@ -0,0 +1,311 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/config"
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/schema"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	var api tableAPI
+
+	// add routes
+	tableRoutes := GetV1Router().PathPrefix("/tables").Subrouter()
+	tableRoutes.HandleFunc("", api.Tables).Methods("GET")
+	tableRoutes.HandleFunc("/{table}", api.List).Methods("GET")
+	tableRoutes.HandleFunc("/{table}", api.Create).Methods("POST")
+	tableRoutes.HandleFunc("/{table}", api.Update).Methods("PATCH")
+	tableRoutes.HandleFunc("/{table}", api.Delete).Methods("DELETE")
+}
+
+// tableAPI defines rest resources generated from database schema.
+type tableAPI struct{}
+
+// Tables returns tables and columns of database.
+func (a *tableAPI) Tables(rw http.ResponseWriter, r *http.Request) {
+	ctx, ok := getAutoAPIContext(rw, r)
+	if !ok {
+		return
+	}
+
+	tables := make([]map[string]interface{}, 0, len(ctx.schema.Tables))
+	for _, t := range ctx.schema.Tables {
+		columns := make([]map[string]interface{}, 0, len(t.Columns))
+		for _, c := range t.Columns {
+			columns = append(columns, map[string]interface{}{
+				"name":        c.Name,
+				"type":        c.DeclType,
+				"not_null":    c.NotNull,
+				"primary_key": c.PrimaryKey,
+			})
+		}
+		tables = append(tables, map[string]interface{}{
+			"name":    t.Name,
+			"columns": columns,
+		})
+	}
+
+	sendResponse(http.StatusOK, true, nil, map[string]interface{}{
+		"tables": tables,
+	}, rw)
+}
+
+// List selects rows of table with filter, order, fields and limit/offset parameters.
+func (a *tableAPI) List(rw http.ResponseWriter, r *http.Request) {
+	ctx, table, ok := getTable(rw, r)
+	if !ok {
+		return
+	}
+
+	opts := &schema.SelectOptions{}
+	var err error
+
+	if fields := r.FormValue("fields"); fields != "" {
+		for _, f := range strings.Split(fields, ",") {
+			opts.Columns = append(opts.Columns, strings.TrimSpace(f))
+		}
+	}
+	if opts.Filter, err = schema.ParseFilter(r.FormValue("filter")); err != nil {
+		sendResponse(http.StatusBadRequest, false, err, nil, rw)
+		return
+	}
+	if opts.Orders, err = schema.ParseOrder(r.FormValue("order")); err != nil {
+		sendResponse(http.StatusBadRequest, false, err, nil, rw)
+		return
+	}
+
+	var limit int
+	if limit, ok = getIntParam(rw, r, "limit"); !ok {
+		return
+	}
+	if opts.Offset, ok = getIntParam(rw, r, "offset"); !ok {
+		return
+	}
+
+	autoAPI := config.GetConfig().AutoAPI
+	opts.Limit = schema.Limits{Default: autoAPI.DefaultLimit, Max: autoAPI.MaxLimit}.Apply(limit)
+
+	stmt, columns, err := table.Select(opts)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err, nil, rw)
+		return
+	}
+
+	if !checkPrivilege(rw, r, ctx.dbID, config.ReadOperation, stmt.Query) {
+		return
+	}
+
+	log.WithField("db", ctx.dbID).WithField("table", table.Name).WithField("query", stmt.Query).Infof("got table list")
+
+	_, _, rows, err := ctx.storage.Query(ctx.dbID, stmt.Query, stmt.Args...)
+	if err != nil {
+		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
+		return
+	}
+
+	assocRows := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		assocRow := make(map[string]interface{}, len(row))
+		for i, v := range row {
+			if i < len(columns) {
+				assocRow[columns[i]] = v
+			}
+		}
+		assocRows = append(assocRows, assocRow)
+	}
+
+	sendResponse(http.StatusOK, true, nil, map[string]interface{}{
+		"rows":   assocRows,
+		"limit":  opts.Limit,
+		"offset": opts.Offset,
+	}, rw)
+}
+
+// Create inserts a json object or array of objects in one transaction.
+func (a *tableAPI) Create(rw http.ResponseWriter, r *http.Request) {
+	ctx, table, ok := getTable(rw, r)
+	if !ok {
+		return
+	}
+
+	var body interface{}
+	if err := decodeJSONBody(r, &body); err != nil {
+		sendResponse(http.StatusBadRequest, false, "Invalid json body", nil, rw)
+		return
+	}
+
+	objects, isList := body.([]interface{})
+	if !isList {
+		objects = []interface{}{body}
+	}
+	if len(objects) == 0 {
+		sendResponse(http.StatusBadRequest, false, "Empty rows", nil, rw)
+		return
+	}
+
+	stmts := make([]*schema.Statement, 0, len(objects))
+	for _, o := range objects {
+		values, isObject := o.(map[string]interface{})
+		if !isObject {
+			sendResponse(http.StatusBadRequest, false, "Rows should be json objects", nil, rw)
+			return
+		}
+
+		stmt, err := table.Insert(values)
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, err, nil, rw)
+			return
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	queries, patterns := toStorageQueries(stmts)
+	if !checkPrivilege(rw, r, ctx.dbID, config.WriteOperation, patterns...) {
+		return
+	}
+
+	log.WithField("db", ctx.dbID).WithField("table", table.Name).WithField("count", len(queries)).Infof("got table insert")
+
+	affectedRows, err := ctx.storage.ExecTx(ctx.dbID, queries)
+	if err != nil {
+		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
+		return
+	}
+
+	sendResponse(http.StatusCreated, true, nil, map[string]interface{}{
+		"affected_rows": affectedRows,
+	}, rw)
+}
+
+// Update sets columns of json object body on rows matching the required filter.
+func (a *tableAPI) Update(rw http.ResponseWriter, r *http.Request) {
+	ctx, table, ok := getTable(rw, r)
+	if !ok {
+		return
+	}
+
+	filter, ok := getRequiredFilter(rw, r)
+	if !ok {
+		return
+	}
+
+	var values map[string]interface{}
+	if err := decodeJSONBody(r, &values); err != nil {
+		sendResponse(http.StatusBadRequest, false, "Invalid json body", nil, rw)
+		return
+	}
+
+	stmt, err := table.Update(values, filter)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err, nil, rw)
+		return
+	}
+
+	a.exec(rw, r, ctx, table, stmt)
+}
+
+// Delete deletes rows matching the required filter.
+func (a *tableAPI) Delete(rw http.ResponseWriter, r *http.Request) {
+	ctx, table, ok := getTable(rw, r)
+	if !ok {
+		return
+	}
+
+	filter, ok := getRequiredFilter(rw, r)
+	if !ok {
+		return
+	}
+
+	stmt, err := table.Delete(filter)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err, nil, rw)
+		return
+	}
+
+	a.exec(rw, r, ctx, table, stmt)
+}
+
+func (a *tableAPI) exec(rw http.ResponseWriter, r *http.Request, ctx *autoAPIContext, table *schema.Table, stmt *schema.Statement) {
+	if !checkPrivilege(rw, r, ctx.dbID, config.WriteOperation, stmt.Query) {
+		return
+	}
+
+	log.WithField("db", ctx.dbID).WithField("table", table.Name).WithField("query", stmt.Query).Infof("got table exec")
+
+	affectedRows, _, err := ctx.storage.Exec(ctx.dbID, stmt.Query, stmt.Args...)
+	if err != nil {
+		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
+		return
+	}
+
+	sendResponse(http.StatusOK, true, nil, map[string]interface{}{
+		"affected_rows": affectedRows,
+	}, rw)
+}
+
+func getTable(rw http.ResponseWriter, r *http.Request) (ctx *autoAPIContext, table *schema.Table, ok bool) {
+	if ctx, ok = getAutoAPIContext(rw, r); !ok {
+		return
+	}
+
+	var err error
+	if table, err = ctx.schema.Table(mux.Vars(r)["table"]); err != nil {
+		sendResponse(http.StatusNotFound, false, err, nil, rw)
+		return nil, nil, false
+	}
+
+	return ctx, table, true
+}
+
+// getRequiredFilter requires explicit filter for update and delete, use {} to match all rows.
+func getRequiredFilter(rw http.ResponseWriter, r *http.Request) (filter map[string]interface{}, ok bool) {
+	rawFilter := r.URL.Query().Get("filter")
+	if rawFilter == "" {
+		sendResponse(http.StatusBadRequest, false, "Missing filter parameter", nil, rw)
+		return
+	}
+
+	var err error
+	if filter, err = schema.ParseFilter(rawFilter); err != nil {
+		sendResponse(http.StatusBadRequest, false, err, nil, rw)
+		return
+	}
+
+	return filter, true
+}
+
+func getIntParam(rw http.ResponseWriter, r *http.Request, name string) (value int, ok bool) {
+	rawValue := r.FormValue(name)
+	if rawValue == "" {
+		return 0, true
+	}
+
+	var err error
+	if value, err = strconv.Atoi(rawValue); err != nil || value < 0 {
+		sendResponse(http.StatusBadRequest, false, "Invalid "+name+" parameter", nil, rw)
+		return 0, false
+	}
+
+	return value, true
+}
@@ -17,10 +17,17 @@
 package api
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/config"
+	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/storage"
 )
 
 var (
@@ -61,6 +68,171 @@ func buildQuery(rw http.ResponseWriter, r *http.Request) string {
 	return ""
 }
 
+// buildArgs parses the optional args parameter, a json array for positional arguments
+// or a json object for named arguments.
+func buildArgs(rw http.ResponseWriter, r *http.Request) (args []interface{}, ok bool) {
+	var err error
+	if args, err = parseArgs(r.FormValue("args")); err != nil {
+		sendResponse(http.StatusBadRequest, false, err, nil, rw)
+		return nil, false
+	}
+
+	return args, true
+}
+
+// buildQueries parses the queries parameter of batch request,
+// a json array like [{"query": "INSERT INTO t VALUES(?)", "args": [1]}].
+func buildQueries(rw http.ResponseWriter, r *http.Request) (queries []storage.Query) {
+	rawQueries := r.FormValue("queries")
+	if rawQueries == "" {
+		sendResponse(http.StatusBadRequest, false, "Missing queries parameter", nil, rw)
+		return
+	}
+
+	var reqQueries []struct {
+		Query string          `json:"query"`
+		Args  json.RawMessage `json:"args"`
+	}
+
+	if err := json.Unmarshal([]byte(rawQueries), &reqQueries); err != nil {
+		sendResponse(http.StatusBadRequest, false, "Invalid queries parameter", nil, rw)
+		return
+	}
+
+	if len(reqQueries) == 0 {
+		sendResponse(http.StatusBadRequest, false, "Empty queries parameter", nil, rw)
+		return
+	}
+
+	queries = make([]storage.Query, 0, len(reqQueries))
+
+	for i, q := range reqQueries {
+		if q.Query == "" {
+			sendResponse(http.StatusBadRequest, false, fmt.Sprintf("Missing query in statement %d", i), nil, rw)
+			return nil
+		}
+
+		args, err := parseArgs(string(q.Args))
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, fmt.Sprintf("Invalid args in statement %d: %v", i, err), nil, rw)
+			return nil
+		}
+
+		queries = append(queries, storage.Query{
+			Pattern: q.Query,
+			Args:    args,
+		})
+	}
+
+	return
+}
+
+func parseArgs(rawArgs string) (args []interface{}, err error) {
+	rawArgs = strings.TrimSpace(rawArgs)
+	if rawArgs == "" || rawArgs == "null" {
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(rawArgs)))
+	decoder.UseNumber()
+
+	switch rawArgs[0] {
+	case '[':
+		var positional []interface{}
+		if err = decoder.Decode(&positional); err != nil {
+			return
+		}
+
+		args = make([]interface{}, 0, len(positional))
+		for _, v := range positional {
+			if v, err = convertArg(v); err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+	case '{':
+		var named map[string]interface{}
+		if err = decoder.Decode(&named); err != nil {
+			return
+		}
+
+		// keep argument order stable
+		names := make([]string, 0, len(named))
+		for name := range named {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		args = make([]interface{}, 0, len(named))
+		for _, name := range names {
+			var v interface{}
+			if v, err = convertArg(named[name]); err != nil {
+				return nil, err
+			}
+			// sqlite3 driver matches name with :, @ and $ prefixes
+			args = append(args, sql.Named(strings.TrimLeft(name, ":@$"), v))
+		}
+	default:
+		err = ErrInvalidArgs
+	}
+
+	return
+}
+
+func convertArg(v interface{}) (interface{}, error) {
+	switch a := v.(type) {
+	case nil, string, bool:
+		return a, nil
+	case json.Number:
+		if i, err := a.Int64(); err == nil {
+			return i, nil
+		}
+		return a.Float64()
+	default:
+		// nested arrays and objects are not valid sql values
+		return nil, ErrInvalidArgs
+	}
+}
+
+// checkPrivilege authorizes the request with adapter policy and sends error response on failure.
+func checkPrivilege(rw http.ResponseWriter, r *http.Request, dbID string, op config.Operation, queries ...string) bool {
+	if err := config.GetConfig().Policy.Authorize(r, dbID, op, queries...); err != nil {
+		sendAuthError(rw, err)
+		return false
+	}
+
+	return true
+}
+
+// getStorage returns storage signing queries with identity of the request principal.
+func getStorage(rw http.ResponseWriter, r *http.Request) (s storage.Storage, ok bool) {
+	cfg := config.GetConfig()
+	ds, delegated := cfg.StorageInstance.(storage.DelegatedStorage)
+	if !delegated {
+		return cfg.StorageInstance, true
+	}
+
+	identity, err := cfg.Policy.Identity(r)
+	if err != nil {
+		sendAuthError(rw, err)
+		return nil, false
+	}
+
+	if identity == "" {
+		return cfg.StorageInstance, true
+	}
+
+	return ds.WithIdentity(identity), true
+}
+
+func sendAuthError(rw http.ResponseWriter, err error) {
+	if err == config.ErrInvalidToken {
+		sendResponse(http.StatusUnauthorized, false, err, nil, rw)
+	} else {
+		sendResponse(http.StatusForbidden, false, err, nil, rw)
+	}
+}
+
 func sendResponse(code int, success bool, msg interface{}, data interface{}, rw http.ResponseWriter) {
 	msgStr := "ok"
 	if msg != nil {
@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/CovenantSQL/CovenantSQL/client"
 	"github.com/CovenantSQL/CovenantSQL/cmd/adapter/storage"
@@ -58,12 +59,33 @@ type Config struct {
 	AdminCertificates []*x509.Certificate `yaml:"-"`
 	WriteCertificates []*x509.Certificate `yaml:"-"`
 
+	// authorization policy
+	Authorization *AuthorizationConfig `yaml:"Authorization"`
+	Policy        *Policy              `yaml:"-"`
+
+	// schema generated rest/graphql api
+	AutoAPI *AutoAPIConfig `yaml:"AutoAPI"`
+
 	// storage config
 	StorageDriver   string          `yaml:"StorageDriver"` // sqlite3 or ThunderDB
 	StorageRoot     string          `yaml:"StorageRoot"`
 	StorageInstance storage.Storage `yaml:"-"`
 }
 
+// AutoAPIConfig defines the rest/graphql api generated from database schema.
+type AutoAPIConfig struct {
+	Databases    []string      `yaml:"Databases"`    // database ids, * for all
+	DefaultLimit int           `yaml:"DefaultLimit"` // page size without limit parameter
+	MaxLimit     int           `yaml:"MaxLimit"`     // maximum page size
+	SchemaTTL    time.Duration `yaml:"SchemaTTL"`    // schema cache expiration
+}
+
+const (
+	defaultAutoAPILimit     = 100
+	defaultAutoAPIMaxLimit  = 1000
+	defaultAutoAPISchemaTTL = time.Minute
+)
+
 type confWrapper struct {
 	Adapter *Config `yaml:"Adapter"`
 }
@@ -163,6 +185,23 @@ func LoadConfig(configPath string, password string) (config *Config, err error)
 		config.TLSConfig.ClientAuth = tls.NoClientCert
 	}
 
+	// load authorization policy
+	if config.Policy, err = loadPolicy(config, workingRoot, []byte(password)); err != nil {
+		return
+	}
+
+	if config.AutoAPI != nil {
+		if config.AutoAPI.DefaultLimit <= 0 {
+			config.AutoAPI.DefaultLimit = defaultAutoAPILimit
+		}
+		if config.AutoAPI.MaxLimit <= 0 {
+			config.AutoAPI.MaxLimit = defaultAutoAPIMaxLimit
+		}
+		if config.AutoAPI.SchemaTTL <= 0 {
+			config.AutoAPI.SchemaTTL = defaultAutoAPISchemaTTL
+		}
+	}
+
 	// load storage
 	switch config.StorageDriver {
 	case "covenantsql":
@@ -192,6 +231,21 @@ func GetConfig() *Config {
 	return currentConfig
 }
 
+// AutoAPIEnabled returns whether the generated api is enabled for database.
+func (c *Config) AutoAPIEnabled(dbID string) bool {
+	if c.AutoAPI == nil {
+		return false
+	}
+
+	for _, d := range c.AutoAPI.Databases {
+		if d == AnyDatabase || d == dbID {
+			return true
+		}
+	}
+
+	return false
+}
+
 func loadCert(pemFile string) (cert *x509.Certificate, err error) {
 	// only the first pem section is parsed and identified as certificate.
 	var certBytes []byte
@@ -27,4 +27,14 @@ var (
 	ErrInvalidStorageConfig = errors.New("invalid storage config")
 	// ErrInvalidCertificateFile defines invalid certificate file error.
 	ErrInvalidCertificateFile = errors.New("invalid certificate file")
+	// ErrInvalidAuthorizationConfig defines invalid role/principal config error.
+	ErrInvalidAuthorizationConfig = errors.New("invalid authorization config")
+	// ErrInvalidToken defines unknown api token error.
+	ErrInvalidToken = errors.New("invalid api token")
+	// ErrPermissionDenied defines no role of principal grants the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrUnrecognizedQuery defines query could not be checked against table allow-list.
+	ErrUnrecognizedQuery = errors.New("query could not be verified against table allow-list")
+	// ErrAnonymousIdentity defines identity upload without an authenticated principal.
+	ErrAnonymousIdentity = errors.New("anonymous client could not hold signing identity")
 )
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"net/http"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+func registerIdentity(name string, privateKey *asymmetric.PrivateKey, nonce *mine.Uint256) (err error) {
+	var id *client.Identity
+	if id, err = client.NewIdentity(privateKey, nonce); err != nil {
+		return
+	}
+
+	if err = client.RegisterIdentity(name, id); err != nil {
+		return
+	}
+
+	log.WithField("identity", name).WithField("node", id.NodeID).Info("registered signing identity")
+
+	return
+}
+
+// Identity returns the client identity name used to sign queries of the request,
+// empty identity stands for the adapter's own key pair.
+func (p *Policy) Identity(r *http.Request) (identity string, err error) {
+	var pr *principal
+	if pr, err = p.authenticate(r); err != nil {
+		return
+	}
+
+	if pr == p.defaultPrincipal {
+		return
+	}
+
+	p.uploadedIdentitiesLock.RLock()
+	defer p.uploadedIdentitiesLock.RUnlock()
+
+	if uploaded, ok := p.uploadedIdentities[pr.name]; ok {
+		return uploaded, nil
+	}
+
+	return pr.identity, nil
+}
+
+// SetIdentity binds an uploaded private key to the request principal,
+// the key is held in memory only and replaces the configured principal key.
+func (p *Policy) SetIdentity(r *http.Request, privateKey *asymmetric.PrivateKey, nonce *mine.Uint256) (err error) {
+	var pr *principal
+	if pr, err = p.authenticate(r); err != nil {
+		return
+	}
+
+	if pr == p.defaultPrincipal {
+		return ErrAnonymousIdentity
+	}
+
+	identity := uploadedIdentityPrefix + pr.name
+	if err = registerIdentity(identity, privateKey, nonce); err != nil {
+		return
+	}
+
+	p.uploadedIdentitiesLock.Lock()
+	defer p.uploadedIdentitiesLock.Unlock()
+
+	p.uploadedIdentities[pr.name] = identity
+
+	return
+}
+
+// ClearIdentity removes the uploaded private key of the request principal.
+func (p *Policy) ClearIdentity(r *http.Request) (err error) {
+	var pr *principal
+	if pr, err = p.authenticate(r); err != nil {
+		return
+	}
+
+	if pr == p.defaultPrincipal {
+		return ErrAnonymousIdentity
+	}
+
+	p.uploadedIdentitiesLock.Lock()
+	defer p.uploadedIdentitiesLock.Unlock()
+
+	if identity, ok := p.uploadedIdentities[pr.name]; ok {
+		delete(p.uploadedIdentities, pr.name)
+		client.DeregisterIdentity(identity)
+	}
+
+	return
+}
@@ -0,0 +1,470 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Operation defines the privilege level required by an adapter api.
+type Operation string
+
+const (
+	// ReadOperation defines read query privilege.
+	ReadOperation Operation = "read"
+	// WriteOperation defines write query privilege, implies read privilege.
+	WriteOperation Operation = "write"
+	// AdminOperation defines database create/drop privilege, implies write privilege.
+	AdminOperation Operation = "admin"
+)
+
+// AnyDatabase matches all databases in role scope.
+const AnyDatabase = "*"
+
+// level returns the privilege level of operation.
+func (o Operation) level() int {
+	switch o {
+	case ReadOperation:
+		return 1
+	case WriteOperation:
+		return 2
+	case AdminOperation:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// RoleConfig defines the scope of a role.
+type RoleConfig struct {
+	Databases []string  `yaml:"Databases"` // database ids, * for all
+	Operation Operation `yaml:"Operation"` // read/write/admin
+	Tables    []string  `yaml:"Tables"`    // optional table allow-list
+}
+
+// PrincipalConfig defines a client identity and the roles granted to it.
+type PrincipalConfig struct {
+	Name      string   `yaml:"Name"`
+	Roles     []string `yaml:"Roles"`
+	CertFiles []string `yaml:"Certs"`
+	Tokens    []string `yaml:"Tokens"`
+
+	// optional key used to sign queries on behalf of principal, encrypted with the adapter master key
+	PrivateKeyFile string        `yaml:"PrivateKey"`
+	Nonce          *mine.Uint256 `yaml:"Nonce"`
+}
+
+// AuthorizationConfig defines the role based access policy of adapter.
+type AuthorizationConfig struct {
+	// role granted to clients without any known certificate or token, empty for deny
+	DefaultRole string                 `yaml:"DefaultRole"`
+	Roles       map[string]*RoleConfig `yaml:"Roles"`
+	Principals  []*PrincipalConfig     `yaml:"Principals"`
+}
+
+type role struct {
+	name      string
+	anyDB     bool
+	databases map[string]bool
+	operation Operation
+	tables    map[string]bool // nil for all tables
+}
+
+type principal struct {
+	name     string
+	roles    []*role
+	identity string // client identity name used for signing, empty for adapter identity
+}
+
+// Policy defines the compiled authorization policy.
+type Policy struct {
+	certPrincipals   map[[sha256.Size]byte]*principal
+	tokenPrincipals  map[string]*principal
+	defaultPrincipal *principal
+
+	// identities uploaded by principals, overrides the configured principal identity
+	uploadedIdentities     map[string]string
+	uploadedIdentitiesLock sync.RWMutex
+}
+
+const (
+	legacyAdminRole  = "legacy-admin"
+	legacyWriteRole  = "legacy-writer"
+	legacyReadRole   = "legacy-reader"
+	anonymousName    = "anonymous"
+	tokenHeader      = "X-Adapter-Token"
+	bearerAuthPrefix = "Bearer "
+
+	principalIdentityPrefix = "principal:"
+	uploadedIdentityPrefix  = "uploaded:"
+)
+
+func newPolicy() *Policy {
+	return &Policy{
+		certPrincipals:     make(map[[sha256.Size]byte]*principal),
+		tokenPrincipals:    make(map[string]*principal),
+		uploadedIdentities: make(map[string]string),
+	}
+}
+
+// loadPolicy compiles the authorization config, AdminCerts/WriteCerts are honored as legacy roles.
+// Principal private keys are decrypted with the adapter master key.
+func loadPolicy(config *Config, workingRoot string, masterKey []byte) (p *Policy, err error) {
+	p = newPolicy()
+
+	roles := map[string]*role{
+		legacyAdminRole: {name: legacyAdminRole, anyDB: true, operation: AdminOperation},
+		legacyWriteRole: {name: legacyWriteRole, anyDB: true, operation: WriteOperation},
+		legacyReadRole:  {name: legacyReadRole, anyDB: true, operation: ReadOperation},
+	}
+
+	// principal names key the uploaded identities, so a name is owned by exactly one
+	// configured principal or one legacy certificate, legacy certificates are named by their
+	// fingerprints since their common names are not guaranteed to be unique or non-empty
+	names := map[string]interface{}{anonymousName: anonymousName}
+	claimName := func(name string, owner interface{}) (err error) {
+		if existing, ok := names[name]; ok && existing != owner {
+			err = ErrInvalidAuthorizationConfig
+			log.WithField("principal", name).Errorf("duplicate principal name: %v", err)
+			return
+		}
+		names[name] = owner
+		return
+	}
+
+	for _, certs := range []struct {
+		certs []*x509.Certificate
+		role  string
+	}{
+		{config.AdminCertificates, legacyAdminRole},
+		{config.WriteCertificates, legacyWriteRole},
+	} {
+		for _, cert := range certs.certs {
+			fingerprint := sha256.Sum256(cert.Raw)
+			name := certs.role + ":" + hex.EncodeToString(fingerprint[:])
+			if err = claimName(name, fingerprint); err != nil {
+				return
+			}
+			p.addCert(cert, &principal{name: name, roles: []*role{roles[certs.role]}})
+		}
+	}
+
+	authConfig := config.Authorization
+	if authConfig == nil {
+		// legacy behavior: every client could read all databases
+		p.defaultPrincipal = &principal{name: anonymousName, roles: []*role{roles[legacyReadRole]}}
+		return
+	}
+
+	for name, rc := range authConfig.Roles {
+		if rc == nil || rc.Operation.level() == 0 || len(rc.Databases) == 0 {
+			err = ErrInvalidAuthorizationConfig
+			log.WithField("role", name).Errorf("invalid role config: %v", err)
+			return
+		}
+		if _, exists := roles[name]; exists {
+			err = ErrInvalidAuthorizationConfig
+			log.WithField("role", name).Errorf("role name is reserved: %v", err)
+			return
+		}
+
+		r := &role{
+			name:      name,
+			databases: make(map[string]bool),
+			operation: rc.Operation,
+		}
+		for _, dbID := range rc.Databases {
+			if dbID == AnyDatabase {
+				r.anyDB = true
+			}
+			r.databases[dbID] = true
+		}
+		if len(rc.Tables) > 0 {
+			r.tables = make(map[string]bool)
+			for _, table := range rc.Tables {
+				r.tables[strings.ToLower(table)] = true
+			}
+		}
+		roles[name] = r
+	}
+
+	resolveRoles := func(names []string) (resolved []*role, err error) {
+		for _, name := range names {
+			r, ok := roles[name]
+			if !ok {
+				err = ErrInvalidAuthorizationConfig
+				log.WithField("role", name).Errorf("unknown role: %v", err)
+				return
+			}
+			resolved = append(resolved, r)
+		}
+		return
+	}
+
+	for _, pc := range authConfig.Principals {
+		if pc == nil || pc.Name == "" {
+			err = ErrInvalidAuthorizationConfig
+			log.Errorf("principal name is required: %v", err)
+			return
+		}
+
+		if err = claimName(pc.Name, pc); err != nil {
+			return
+		}
+
+		pr := &principal{name: pc.Name}
+		if pr.roles, err = resolveRoles(pc.Roles); err != nil {
+			return
+		}
+
+		if pc.PrivateKeyFile != "" {
+			var privateKey *asymmetric.PrivateKey
+			if privateKey, err = kms.LoadPrivateKey(filepath.Join(workingRoot, pc.PrivateKeyFile), masterKey); err != nil {
+				log.WithField("principal", pc.Name).Errorf("load principal private key failed: %v", err)
+				return
+			}
+			pr.identity = principalIdentityPrefix + pc.Name
+			if err = registerIdentity(pr.identity, privateKey, pc.Nonce); err != nil {
+				log.WithField("principal", pc.Name).Errorf("register principal identity failed: %v", err)
+				return
+			}
+		}
+
+		for _, certFile := range pc.CertFiles {
+			var cert *x509.Certificate
+			if cert, err = loadCert(filepath.Join(workingRoot, certFile)); err != nil {
+				log.WithField("principal", pc.Name).Errorf("load principal certificate failed: %v", err)
+				return
+			}
+			p.addCert(cert, pr)
+		}
+
+		for _, token := range pc.Tokens {
+			if token == "" {
+				err = ErrInvalidAuthorizationConfig
+				log.WithField("principal", pc.Name).Errorf("empty token: %v", err)
+				return
+			}
+			if _, exists := p.tokenPrincipals[token]; exists {
+				err = ErrInvalidAuthorizationConfig
+				log.WithField("principal", pc.Name).Errorf("duplicate token: %v", err)
+				return
+			}
+			p.tokenPrincipals[token] = pr
+		}
+	}
+
+	if authConfig.DefaultRole != "" {
+		p.defaultPrincipal = &principal{name: anonymousName}
+		if p.defaultPrincipal.roles, err = resolveRoles([]string{authConfig.DefaultRole}); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (p *Policy) addCert(cert *x509.Certificate, pr *principal) {
+	fingerprint := sha256.Sum256(cert.Raw)
+	if existing, ok := p.certPrincipals[fingerprint]; ok {
+		// merge roles of same certificate without touching the shared principals
+		roles := make([]*role, 0, len(existing.roles)+len(pr.roles))
+		roles = append(roles, existing.roles...)
+		roles = append(roles, pr.roles...)
+		identity := existing.identity
+		if identity == "" {
+			identity = pr.identity
+		}
+		p.certPrincipals[fingerprint] = &principal{name: existing.name, roles: roles, identity: identity}
+		return
+	}
+	p.certPrincipals[fingerprint] = pr
+}
+
+// authenticate identifies the request principal by api token or client certificate.
+func (p *Policy) authenticate(r *http.Request) (pr *principal, err error) {
+	token := r.Header.Get(tokenHeader)
+	if auth := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, bearerAuthPrefix) {
+		token = strings.TrimPrefix(auth, bearerAuthPrefix)
+	}
+
+	if token != "" {
+		for t, tp := range p.tokenPrincipals {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return tp, nil
+			}
+		}
+		return nil, ErrInvalidToken
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if cp, ok := p.certPrincipals[sha256.Sum256(r.TLS.PeerCertificates[0].Raw)]; ok {
+			return cp, nil
+		}
+	}
+
+	if p.defaultPrincipal == nil {
+		return nil, ErrPermissionDenied
+	}
+
+	return p.defaultPrincipal, nil
+}
+
+// Authorize checks whether the request could perform the operation on database,
+// queries are checked against table allow-list of roles. Empty dbID stands for
+// operations not bound to a specific database which requires a role of all databases.
+func (p *Policy) Authorize(r *http.Request, dbID string, op Operation, queries ...string) (err error) {
+	var pr *principal
+	var matchedRole string
+	var tables []string
+
+	defer func() {
+		fields := log.Fields{
+			"remote":    r.RemoteAddr,
+			"path":      r.URL.Path,
+			"db":        dbID,
+			"operation": op,
+			"allowed":   err == nil,
+		}
+		if pr != nil {
+			fields["principal"] = pr.name
+		}
+		if matchedRole != "" {
+			fields["role"] = matchedRole
+		}
+		if tables != nil {
+			fields["tables"] = tables
+		}
+		if err != nil {
+			fields["reason"] = err.Error()
+		}
+		log.WithFields(fields).Info("adapter authorization decision")
+	}()
+
+	if pr, err = p.authenticate(r); err != nil {
+		return
+	}
+
+	// tables are resolved lazily since most roles are not table restricted
+	var tablesResolved, tablesOK bool
+
+	for _, rl := range pr.roles {
+		if rl.operation.level() < op.level() {
+			continue
+		}
+		if !rl.anyDB && (dbID == "" || !rl.databases[dbID]) {
+			continue
+		}
+		if rl.tables != nil && len(queries) > 0 {
+			if !tablesResolved {
+				tablesResolved = true
+				tables, tablesOK = queryTables(queries)
+			}
+			if !tablesOK || !containsAll(rl.tables, tables) {
+				continue
+			}
+		}
+
+		matchedRole = rl.name
+		return nil
+	}
+
+	if tablesResolved && !tablesOK {
+		return ErrUnrecognizedQuery
+	}
+
+	return ErrPermissionDenied
+}
+
+// Principal returns the name of the request principal.
+func (p *Policy) Principal(r *http.Request) (name string, err error) {
+	var pr *principal
+	if pr, err = p.authenticate(r); err != nil {
+		return
+	}
+	return pr.name, nil
+}
+
+// AllowedTables returns the tables of database granted to the request principal for the operation,
+// nil tables with nil error stands for all tables.
+func (p *Policy) AllowedTables(r *http.Request, dbID string, op Operation) (tables map[string]bool, err error) {
+	var pr *principal
+	if pr, err = p.authenticate(r); err != nil {
+		return
+	}
+
+	var granted bool
+
+	for _, rl := range pr.roles {
+		if rl.operation.level() < op.level() {
+			continue
+		}
+		if !rl.anyDB && (dbID == "" || !rl.databases[dbID]) {
+			continue
+		}
+		if rl.tables == nil {
+			return nil, nil
+		}
+
+		granted = true
+		if tables == nil {
+			tables = make(map[string]bool)
+		}
+		for t := range rl.tables {
+			tables[t] = true
+		}
+	}
+
+	if !granted {
+		err = ErrPermissionDenied
+	}
+
+	return
+}
+
+func queryTables(queries []string) (tables []string, ok bool) {
+	tables = make([]string, 0)
+	for _, q := range queries {
+		var t []string
+		if t, ok = ReferencedTables(q); !ok {
+			return
+		}
+		tables = append(tables, t...)
+	}
+	return tables, true
+}
+
+func containsAll(allowed map[string]bool, tables []string) bool {
+	for _, t := range tables {
+		if !allowed[t] {
+			return false
+		}
+	}
+	return true
+}
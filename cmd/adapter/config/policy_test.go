@@ -0,0 +1,256 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReferencedTables(t *testing.T) {
+	Convey("referenced tables should be extracted", t, func() {
+		cases := []struct {
+			query  string
+			tables []string
+		}{
+			{"SELECT 1", []string{}},
+			{"select * from Users u join orders o on u.id = o.uid", []string{"orders", "users"}},
+			{"SELECT * FROM a, main.b AS x, \"c d\" WHERE 1", []string{"a", "b", "c d"}},
+			{"SELECT * FROM (SELECT id FROM a) t WHERE id IN (SELECT id FROM b)", []string{"a", "b"}},
+			{"WITH x(id) AS (SELECT id FROM a), y AS (SELECT 1) SELECT * FROM x, y", []string{"a"}},
+			{"INSERT OR REPLACE INTO a(id) VALUES(1) ON CONFLICT(id) DO UPDATE SET id = 2", []string{"a"}},
+			{"UPDATE OR IGNORE a SET v = 'FROM b'", []string{"a"}},
+			{"DELETE FROM a; CREATE TABLE IF NOT EXISTS b (id INT)", []string{"a", "b"}},
+			{"CREATE INDEX idx ON a(id)", []string{"a"}},
+			{"ALTER TABLE a RENAME TO b", []string{"a", "b"}},
+			{"SELECT * FROM (SELECT id FROM a) t, b", []string{"a", "b"}},
+			// targets of writes are never common table expressions
+			{"WITH secret AS (SELECT 1) DELETE FROM secret", []string{"secret"}},
+			{"WITH t AS (SELECT 1) INSERT INTO t SELECT * FROM t", []string{"t"}},
+			{"WITH t AS (SELECT 1) UPDATE t SET v = 1", []string{"t"}},
+			// common table expressions are scoped to the statement declaring them
+			{"WITH secret AS (SELECT 1) SELECT * FROM users; DELETE FROM secret", []string{"secret", "users"}},
+			{"WITH secret AS (SELECT 1) SELECT * FROM users; SELECT * FROM secret", []string{"secret", "users"}},
+			{"SELECT * FROM (WITH secret AS (SELECT 1) SELECT * FROM secret)", []string{"secret"}},
+		}
+
+		for _, c := range cases {
+			tables, ok := ReferencedTables(c.query)
+			So(ok, ShouldBeTrue)
+			if len(c.tables) == 0 {
+				So(tables, ShouldBeEmpty)
+			} else {
+				So(tables, ShouldResemble, c.tables)
+			}
+		}
+	})
+	Convey("unverifiable queries should be rejected", t, func() {
+		for _, q := range []string{
+			"PRAGMA table_info(a)",
+			"ATTACH DATABASE 'x' AS x",
+			"SELECT * FROM other.a",
+			"SELECT 1; VACUUM",
+			"SELECT * FROM pragma_table_info('secret')",
+			"SELECT * FROM a JOIN json_each(a.v)",
+		} {
+			_, ok := ReferencedTables(q)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	Convey("Given a policy with legacy certificates and roles", t, func() {
+		adminCert := &x509.Certificate{Raw: []byte("admin")}
+		teamCert := &x509.Certificate{Raw: []byte("team")}
+
+		cfg := &Config{
+			AdminCertificates: []*x509.Certificate{adminCert},
+			Authorization: &AuthorizationConfig{
+				Roles: map[string]*RoleConfig{
+					"team-rw": {
+						Databases: []string{"db1"},
+						Operation: WriteOperation,
+						Tables:    []string{"users"},
+					},
+					"reader": {
+						Databases: []string{AnyDatabase},
+						Operation: ReadOperation,
+					},
+				},
+				Principals: []*PrincipalConfig{
+					{Name: "team", Roles: []string{"team-rw"}, Tokens: []string{"secret"}},
+				},
+			},
+		}
+
+		p, err := loadPolicy(cfg, "", nil)
+		So(err, ShouldBeNil)
+		p.addCert(teamCert, p.tokenPrincipals["secret"])
+
+		Convey("admin certificate should be granted everything", func() {
+			r := httptest.NewRequest("POST", "/v1/admin/create", nil)
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{adminCert}}
+			So(p.Authorize(r, "", AdminOperation), ShouldBeNil)
+			So(p.Authorize(r, "db2", WriteOperation, "PRAGMA foo"), ShouldBeNil)
+		})
+		Convey("team principal should be scoped to its database and tables", func() {
+			r := httptest.NewRequest("POST", "/v1/exec", nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			So(p.Authorize(r, "db1", WriteOperation, "INSERT INTO users VALUES(1)"), ShouldBeNil)
+			So(p.Authorize(r, "db1", ReadOperation, "SELECT * FROM users"), ShouldBeNil)
+			So(p.Authorize(r, "db1", WriteOperation, "DELETE FROM orders"), ShouldEqual, ErrPermissionDenied)
+			So(p.Authorize(r, "db1", ReadOperation, "PRAGMA table_info(users)"), ShouldEqual, ErrUnrecognizedQuery)
+			So(p.Authorize(r, "db2", ReadOperation, "SELECT * FROM users"), ShouldEqual, ErrPermissionDenied)
+			So(p.Authorize(r, "db1", AdminOperation), ShouldEqual, ErrPermissionDenied)
+
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{teamCert}}
+			r.Header.Del("Authorization")
+			So(p.Authorize(r, "db1", WriteOperation, "UPDATE users SET a = 1"), ShouldBeNil)
+		})
+		Convey("granted tables should be listed by principal", func() {
+			r := httptest.NewRequest("GET", "/v1/tables", nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			name, err := p.Principal(r)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "team")
+			tables, err := p.AllowedTables(r, "db1", ReadOperation)
+			So(err, ShouldBeNil)
+			So(tables, ShouldResemble, map[string]bool{"users": true})
+			_, err = p.AllowedTables(r, "db2", ReadOperation)
+			So(err, ShouldEqual, ErrPermissionDenied)
+
+			r = httptest.NewRequest("GET", "/v1/tables", nil)
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{adminCert}}
+			tables, err = p.AllowedTables(r, "db2", ReadOperation)
+			So(err, ShouldBeNil)
+			So(tables, ShouldBeNil)
+		})
+		Convey("unknown clients should be denied without default role", func() {
+			r := httptest.NewRequest("GET", "/v1/query", nil)
+			So(p.Authorize(r, "db1", ReadOperation, "SELECT 1"), ShouldEqual, ErrPermissionDenied)
+			r.Header.Set("X-Adapter-Token", "wrong")
+			So(p.Authorize(r, "db1", ReadOperation, "SELECT 1"), ShouldEqual, ErrInvalidToken)
+		})
+		Convey("default role should apply to unknown clients", func() {
+			cfg.Authorization.DefaultRole = "reader"
+			p, err = loadPolicy(cfg, "", nil)
+			So(err, ShouldBeNil)
+			r := httptest.NewRequest("GET", "/v1/query", nil)
+			So(p.Authorize(r, "db3", ReadOperation, "SELECT 1"), ShouldBeNil)
+			So(p.Authorize(r, "db3", WriteOperation, "DELETE FROM a"), ShouldEqual, ErrPermissionDenied)
+		})
+		Convey("uploaded identity should be bound to principal", func() {
+			privateKey, _, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+
+			r := httptest.NewRequest("PUT", "/v1/identity", nil)
+			So(p.SetIdentity(r, privateKey, nil), ShouldEqual, ErrPermissionDenied)
+
+			r.Header.Set("X-Adapter-Token", "secret")
+			identity, err := p.Identity(r)
+			So(err, ShouldBeNil)
+			So(identity, ShouldBeEmpty)
+
+			So(p.SetIdentity(r, privateKey, nil), ShouldBeNil)
+			identity, err = p.Identity(r)
+			So(err, ShouldBeNil)
+			So(identity, ShouldEqual, uploadedIdentityPrefix+"team")
+
+			So(p.ClearIdentity(r), ShouldBeNil)
+			identity, err = p.Identity(r)
+			So(err, ShouldBeNil)
+			So(identity, ShouldBeEmpty)
+		})
+	})
+	Convey("principal names should be unique", t, func() {
+		cfg := &Config{
+			AdminCertificates: []*x509.Certificate{
+				{Raw: []byte("admin"), Subject: pkix.Name{CommonName: "admin"}},
+			},
+			WriteCertificates: []*x509.Certificate{
+				{Raw: []byte("admin"), Subject: pkix.Name{CommonName: "admin"}},
+			},
+			Authorization: &AuthorizationConfig{
+				Principals: []*PrincipalConfig{{Name: "team"}},
+			},
+		}
+		_, err := loadPolicy(cfg, "", nil)
+		So(err, ShouldBeNil)
+
+		cfg.Authorization.Principals = append(cfg.Authorization.Principals, &PrincipalConfig{Name: "team"})
+		_, err = loadPolicy(cfg, "", nil)
+		So(err, ShouldEqual, ErrInvalidAuthorizationConfig)
+
+		// legacy certificates are named by fingerprint, not by common name
+		cfg.Authorization.Principals = []*PrincipalConfig{{Name: "admin"}}
+		_, err = loadPolicy(cfg, "", nil)
+		So(err, ShouldBeNil)
+
+		adminFingerprint := sha256.Sum256([]byte("admin"))
+		cfg.Authorization.Principals = []*PrincipalConfig{
+			{Name: legacyAdminRole + ":" + hex.EncodeToString(adminFingerprint[:])},
+		}
+		_, err = loadPolicy(cfg, "", nil)
+		So(err, ShouldEqual, ErrInvalidAuthorizationConfig)
+
+		cfg.Authorization.Principals = []*PrincipalConfig{{Name: anonymousName}}
+		_, err = loadPolicy(cfg, "", nil)
+		So(err, ShouldEqual, ErrInvalidAuthorizationConfig)
+
+		// legacy certificates sharing the same or an empty common name are still accepted
+		cfg.Authorization.Principals = nil
+		cfg.WriteCertificates = []*x509.Certificate{
+			{Raw: []byte("other"), Subject: pkix.Name{CommonName: "admin"}},
+			{Raw: []byte("unnamed")},
+			{Raw: []byte("unnamed2")},
+		}
+		_, err = loadPolicy(cfg, "", nil)
+		So(err, ShouldBeNil)
+	})
+	Convey("tokens should be unique", t, func() {
+		cfg := &Config{
+			Authorization: &AuthorizationConfig{
+				Principals: []*PrincipalConfig{
+					{Name: "team", Tokens: []string{"secret"}},
+					{Name: "other", Tokens: []string{"other"}},
+				},
+			},
+		}
+		_, err := loadPolicy(cfg, "", nil)
+		So(err, ShouldBeNil)
+
+		cfg.Authorization.Principals[1].Tokens = append(cfg.Authorization.Principals[1].Tokens, "secret")
+		_, err = loadPolicy(cfg, "", nil)
+		So(err, ShouldEqual, ErrInvalidAuthorizationConfig)
+	})
+	Convey("legacy config should allow anyone to read", t, func() {
+		p, err := loadPolicy(&Config{}, "", nil)
+		So(err, ShouldBeNil)
+		r := httptest.NewRequest("GET", "/v1/query", nil)
+		So(p.Authorize(r, "db1", ReadOperation, "SELECT 1"), ShouldBeNil)
+		So(p.Authorize(r, "db1", WriteOperation, "DELETE FROM a"), ShouldEqual, ErrPermissionDenied)
+	})
+}
@@ -0,0 +1,354 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+type sqlTokenType int
+
+const (
+	tokenKeyword sqlTokenType = iota // bare word, could be keyword or identifier
+	tokenIdent                       // quoted identifier
+	tokenPunct                       // punctuation
+	tokenOther                       // literals and operators
+)
+
+type sqlToken struct {
+	typ   sqlTokenType
+	value string
+}
+
+func (t sqlToken) is(keyword string) bool {
+	return t.typ == tokenKeyword && strings.EqualFold(t.value, keyword)
+}
+
+func (t sqlToken) isPunct(p string) bool {
+	return t.typ == tokenPunct && t.value == p
+}
+
+func (t sqlToken) isName() bool {
+	return t.typ == tokenKeyword || t.typ == tokenIdent
+}
+
+// allowedStatements defines statements that could be checked against table allow-list.
+var allowedStatements = map[string]bool{
+	"SELECT":  true,
+	"INSERT":  true,
+	"REPLACE": true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	"WITH":    true,
+	"VALUES":  true,
+	"CREATE":  true,
+	"DROP":    true,
+	"ALTER":   true,
+}
+
+// ReferencedTables returns the lower-cased table names referenced by the sql statements,
+// ok is false if the statements could not be analyzed reliably (e.g. PRAGMA/ATTACH, foreign schema,
+// table-valued functions).
+func ReferencedTables(query string) (tables []string, ok bool) {
+	tokens := tokenizeSQL(query)
+	// found collects the tables of all statements, refs collects the names read by the current
+	// statement, which may refer to the common table expressions declared by the statement
+	found := make(map[string]bool)
+	refs := make(map[string]bool)
+	cteNames := make(map[string]bool)
+	statementStart := true
+
+	endStatement := func() {
+		for name := range refs {
+			if !cteNames[name] {
+				found[name] = true
+			}
+		}
+		refs = make(map[string]bool)
+		cteNames = make(map[string]bool)
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+
+		if t.isPunct(";") {
+			endStatement()
+			statementStart = true
+			continue
+		}
+
+		if statementStart {
+			statementStart = false
+			if t.typ != tokenKeyword || !allowedStatements[strings.ToUpper(t.value)] {
+				return nil, false
+			}
+			if t.is("WITH") {
+				// only the common table expressions leading the statement are scoped to it, the
+				// names of nested ones are treated as tables
+				collectCTENames(tokens, i+1, cteNames)
+				continue
+			}
+		}
+
+		switch {
+		case t.is("UPDATE") && i+1 < len(tokens) && tokens[i+1].is("SET"):
+			// upsert clause: ON CONFLICT DO UPDATE SET
+		case t.is("FROM") || t.is("JOIN"):
+			// the target of DELETE FROM is always a table, never a common table expression
+			target := t.is("FROM") && i > 0 && tokens[i-1].is("DELETE")
+			// table list, separated by comma in FROM clause
+			for j := i + 1; j < len(tokens); {
+				name, next, valid := readTableName(tokens, j)
+				if !valid {
+					return nil, false
+				}
+				if name != "" && next < len(tokens) && tokens[next].isPunct("(") {
+					// table-valued function, e.g. pragma_table_info('t')
+					return nil, false
+				}
+				if name != "" && target {
+					found[name] = true
+				} else if name != "" {
+					refs[name] = true
+				}
+				next = skipAlias(tokens, next)
+				if next < len(tokens) && tokens[next].isPunct(",") && t.is("FROM") {
+					j = next + 1
+					continue
+				}
+				break
+			}
+		case t.is("INTO") || t.is("UPDATE") || t.is("TABLE") || t.is("VIEW") ||
+			t.is("TO") && i > 0 && tokens[i-1].is("RENAME") ||
+			t.is("ON") && precededByIndex(tokens, i):
+			j := i + 1
+			// skip conflict clause and existence checks
+			for j < len(tokens) && (tokens[j].is("OR") || tokens[j].is("ROLLBACK") || tokens[j].is("ABORT") ||
+				tokens[j].is("REPLACE") || tokens[j].is("FAIL") || tokens[j].is("IGNORE") ||
+				tokens[j].is("IF") || tokens[j].is("NOT") || tokens[j].is("EXISTS")) {
+				j++
+			}
+			// the target of INTO, UPDATE and TABLE is always a table, never a common table
+			// expression
+			name, _, valid := readTableName(tokens, j)
+			if !valid || name == "" {
+				return nil, false
+			}
+			found[name] = true
+		}
+	}
+	endStatement()
+
+	for name := range found {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+
+	return tables, true
+}
+
+// readTableName reads a optionally schema qualified table name, sub queries are left for the outer scan.
+func readTableName(tokens []sqlToken, i int) (name string, next int, ok bool) {
+	if i >= len(tokens) {
+		return "", i, false
+	}
+
+	if tokens[i].isPunct("(") {
+		// sub query or join group
+		return "", i, true
+	}
+
+	if !tokens[i].isName() {
+		return "", i, false
+	}
+
+	name = strings.ToLower(tokens[i].value)
+	next = i + 1
+
+	if next+1 < len(tokens) && tokens[next].isPunct(".") && tokens[next+1].isName() {
+		schema := name
+		if schema != "main" && schema != "temp" {
+			// attached databases are not allowed
+			return "", next, false
+		}
+		name = strings.ToLower(tokens[next+1].value)
+		next += 2
+	}
+
+	return name, next, true
+}
+
+// skipAlias skips sub query or join group, which are scanned by the outer loop, and table alias.
+func skipAlias(tokens []sqlToken, i int) int {
+	if i < len(tokens) && tokens[i].isPunct("(") {
+		i = skipParens(tokens, i)
+	}
+	if i < len(tokens) && tokens[i].is("AS") {
+		i++
+	}
+	if i < len(tokens) && tokens[i].isName() && !isClauseKeyword(tokens[i]) {
+		i++
+	}
+	return i
+}
+
+func isClauseKeyword(t sqlToken) bool {
+	if t.typ != tokenKeyword {
+		return false
+	}
+	switch strings.ToUpper(t.value) {
+	case "WHERE", "GROUP", "ORDER", "LIMIT", "HAVING", "JOIN", "INNER", "LEFT", "RIGHT", "CROSS",
+		"NATURAL", "OUTER", "ON", "USING", "UNION", "INTERSECT", "EXCEPT", "SET", "VALUES",
+		"SELECT", "DEFAULT", "WINDOW", "INDEXED", "NOT", "RETURNING":
+		return true
+	}
+	return false
+}
+
+// collectCTENames collects common table expression names, the cte bodies are scanned by caller.
+func collectCTENames(tokens []sqlToken, i int, names map[string]bool) {
+	if i < len(tokens) && tokens[i].is("RECURSIVE") {
+		i++
+	}
+
+	for i < len(tokens) && tokens[i].isName() {
+		names[strings.ToLower(tokens[i].value)] = true
+		i++
+
+		if i < len(tokens) && tokens[i].isPunct("(") {
+			// column list
+			i = skipParens(tokens, i)
+		}
+		if i < len(tokens) && tokens[i].is("AS") {
+			i++
+		}
+		if i >= len(tokens) || !tokens[i].isPunct("(") {
+			return
+		}
+		if i = skipParens(tokens, i); i >= len(tokens) || !tokens[i].isPunct(",") {
+			return
+		}
+		i++
+	}
+}
+
+func precededByIndex(tokens []sqlToken, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		if tokens[j].isPunct(";") {
+			return false
+		}
+		if tokens[j].is("INDEX") || tokens[j].is("TRIGGER") {
+			return true
+		}
+	}
+	return false
+}
+
+func skipParens(tokens []sqlToken, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		if tokens[i].isPunct("(") {
+			depth++
+		} else if tokens[i].isPunct(")") {
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+func tokenizeSQL(query string) (tokens []sqlToken) {
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(query)
+			}
+		case c == '\'':
+			end := skipQuote(query, i, '\'')
+			tokens = append(tokens, sqlToken{typ: tokenOther, value: query[i:end]})
+			i = end
+		case c == '"' || c == '`':
+			end := skipQuote(query, i, c)
+			valueEnd := end
+			if valueEnd > i+1 && query[valueEnd-1] == c {
+				valueEnd--
+			}
+			value := strings.Replace(query[i+1:valueEnd], string([]byte{c, c}), string(c), -1)
+			tokens = append(tokens, sqlToken{typ: tokenIdent, value: value})
+			i = end
+		case c == '[':
+			end := strings.IndexByte(query[i:], ']')
+			if end < 0 {
+				end = len(query) - i
+			}
+			tokens = append(tokens, sqlToken{typ: tokenIdent, value: query[i+1 : i+end]})
+			i += end + 1
+		case isWordStart(c):
+			j := i + 1
+			for j < len(query) && isWordPart(query[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{typ: tokenKeyword, value: query[i:j]})
+			i = j
+		case c == '(' || c == ')' || c == ',' || c == '.' || c == ';':
+			tokens = append(tokens, sqlToken{typ: tokenPunct, value: string(c)})
+			i++
+		default:
+			tokens = append(tokens, sqlToken{typ: tokenOther, value: string(c)})
+			i++
+		}
+	}
+
+	return
+}
+
+func skipQuote(query string, offset int, quote byte) int {
+	for i := offset + 1; i < len(query); i++ {
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || c >= '0' && c <= '9' || c == '$'
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import "github.com/pkg/errors"
+
+var (
+	// ErrUnknownTable defines table not found in database schema.
+	ErrUnknownTable = errors.New("unknown table")
+	// ErrUnknownColumn defines column not found in table schema.
+	ErrUnknownColumn = errors.New("unknown column")
+	// ErrInvalidFilter defines malformed filter expression.
+	ErrInvalidFilter = errors.New("invalid filter")
+	// ErrInvalidOrder defines malformed order expression.
+	ErrInvalidOrder = errors.New("invalid order")
+	// ErrEmptyValues defines insert/update without any column value.
+	ErrEmptyValues = errors.New("empty column values")
+	// ErrInvalidValue defines value which could not be bound as sql argument.
+	ErrInvalidValue = errors.New("invalid column value")
+	// ErrGraphQLSyntax defines graphql document syntax error.
+	ErrGraphQLSyntax = errors.New("graphql syntax error")
+	// ErrUnsupportedGraphQL defines graphql features not supported by adapter.
+	ErrUnsupportedGraphQL = errors.New("unsupported graphql feature")
+	// ErrInvalidGraphQLField defines unknown graphql field or argument.
+	ErrInvalidGraphQLField = errors.New("invalid graphql field")
+)
@@ -0,0 +1,565 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// OperationType defines graphql operation type.
+type OperationType string
+
+const (
+	// QueryOperation defines read only graphql operation.
+	QueryOperation OperationType = "query"
+	// MutationOperation defines write graphql operation.
+	MutationOperation OperationType = "mutation"
+)
+
+// Operation defines a parsed graphql operation, only fields, arguments and variables are supported.
+type Operation struct {
+	Type       OperationType
+	Name       string
+	Defaults   map[string]interface{} // variable default values
+	Selections []*Field
+}
+
+// Field defines a selected graphql field.
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]interface{}
+	Selections []*Field
+}
+
+// Variable defines a variable reference in argument values.
+type Variable string
+
+// EnumValue defines a bare enum value in argument values.
+type EnumValue string
+
+// ResponseKey returns the key of field in response object.
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type gqlTokenType int
+
+const (
+	gqlEOF gqlTokenType = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	typ   gqlTokenType
+	value string
+	pos   int
+}
+
+type gqlParser struct {
+	src    string
+	pos    int
+	tok    gqlToken
+	peeked bool
+	err    error // lexical error, terminates the document
+}
+
+// ParseGraphQL parses a graphql document containing a single operation,
+// operationName selects the operation if the document contains many.
+func ParseGraphQL(document string, operationName string) (op *Operation, err error) {
+	p := &gqlParser{src: document}
+
+	var ops []*Operation
+	for p.peek().typ != gqlEOF {
+
+		var o *Operation
+		if o, err = p.parseOperation(); err != nil {
+			return
+		}
+		ops = append(ops, o)
+	}
+
+	switch {
+	case p.err != nil:
+		return nil, p.err
+	case len(ops) == 0:
+		return nil, errors.Wrap(ErrGraphQLSyntax, "empty document")
+	case operationName != "":
+		for _, o := range ops {
+			if o.Name == operationName {
+				return o, nil
+			}
+		}
+		return nil, errors.Wrapf(ErrGraphQLSyntax, "unknown operation %s", operationName)
+	case len(ops) > 1:
+		return nil, errors.Wrap(ErrGraphQLSyntax, "operation name is required for multiple operations")
+	default:
+		return ops[0], nil
+	}
+}
+
+func (p *gqlParser) parseOperation() (op *Operation, err error) {
+	op = &Operation{Type: QueryOperation, Defaults: make(map[string]interface{})}
+
+	if t := p.peek(); t.typ == gqlName {
+		switch t.value {
+		case "query":
+		case "mutation":
+			op.Type = MutationOperation
+		case "fragment", "subscription":
+			return nil, errors.Wrapf(ErrUnsupportedGraphQL, "%s at %d", t.value, t.pos)
+		default:
+			return nil, p.unexpected(t)
+		}
+		p.next()
+
+		if t = p.peek(); t.typ == gqlName {
+			op.Name = t.value
+			p.next()
+		}
+		if p.isPunct("(") {
+			if err = p.parseVariableDefinitions(op); err != nil {
+				return
+			}
+		}
+		if p.isPunct("@") {
+			return nil, errors.Wrapf(ErrUnsupportedGraphQL, "directive at %d", p.tok.pos)
+		}
+	}
+
+	if op.Selections, err = p.parseSelectionSet(); err != nil {
+		return
+	}
+
+	return
+}
+
+func (p *gqlParser) parseVariableDefinitions(op *Operation) (err error) {
+	p.next() // (
+
+	for !p.isPunct(")") {
+		if err = p.expectPunct("$"); err != nil {
+			return
+		}
+		var name string
+		if name, err = p.expectName(); err != nil {
+			return
+		}
+		if err = p.expectPunct(":"); err != nil {
+			return
+		}
+		if err = p.skipType(); err != nil {
+			return
+		}
+		if p.isPunct("=") {
+			p.next()
+			var v interface{}
+			if v, err = p.parseValue(true); err != nil {
+				return
+			}
+			op.Defaults[name] = v
+		}
+		if p.tok.typ == gqlEOF {
+			return p.unexpected(p.tok)
+		}
+	}
+
+	p.next() // )
+	return
+}
+
+// skipType skips variable type, types are checked by the generated sql statements.
+func (p *gqlParser) skipType() (err error) {
+	if p.isPunct("[") {
+		p.next()
+		if err = p.skipType(); err != nil {
+			return
+		}
+		if err = p.expectPunct("]"); err != nil {
+			return
+		}
+	} else if _, err = p.expectName(); err != nil {
+		return
+	}
+
+	if p.isPunct("!") {
+		p.next()
+	}
+
+	return
+}
+
+func (p *gqlParser) parseSelectionSet() (fields []*Field, err error) {
+	if err = p.expectPunct("{"); err != nil {
+		return
+	}
+
+	for !p.isPunct("}") {
+		if p.isPunct("...") {
+			return nil, errors.Wrapf(ErrUnsupportedGraphQL, "fragment at %d", p.tok.pos)
+		}
+
+		var f *Field
+		if f, err = p.parseField(); err != nil {
+			return
+		}
+		fields = append(fields, f)
+	}
+
+	p.next() // }
+
+	if len(fields) == 0 {
+		return nil, errors.Wrap(ErrGraphQLSyntax, "empty selection set")
+	}
+
+	return
+}
+
+func (p *gqlParser) parseField() (f *Field, err error) {
+	f = &Field{}
+
+	if f.Name, err = p.expectName(); err != nil {
+		return
+	}
+
+	if p.isPunct(":") {
+		p.next()
+		f.Alias = f.Name
+		if f.Name, err = p.expectName(); err != nil {
+			return
+		}
+	}
+
+	if p.isPunct("(") {
+		p.next()
+		f.Arguments = make(map[string]interface{})
+
+		for !p.isPunct(")") {
+			var name string
+			if name, err = p.expectName(); err != nil {
+				return
+			}
+			if err = p.expectPunct(":"); err != nil {
+				return
+			}
+			if f.Arguments[name], err = p.parseValue(false); err != nil {
+				return
+			}
+		}
+
+		p.next() // )
+	}
+
+	if p.isPunct("@") {
+		return nil, errors.Wrapf(ErrUnsupportedGraphQL, "directive at %d", p.tok.pos)
+	}
+
+	if p.isPunct("{") {
+		if f.Selections, err = p.parseSelectionSet(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// parseValue parses argument value, lists are []interface{} and objects are map[string]interface{}.
+func (p *gqlParser) parseValue(constant bool) (v interface{}, err error) {
+	t := p.next()
+
+	switch t.typ {
+	case gqlInt:
+		return json.Number(t.value), nil
+	case gqlFloat:
+		return json.Number(t.value), nil
+	case gqlString:
+		return t.value, nil
+	case gqlName:
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return EnumValue(t.value), nil
+		}
+	case gqlPunct:
+		switch t.value {
+		case "$":
+			if constant {
+				return nil, p.unexpected(t)
+			}
+			var name string
+			if name, err = p.expectName(); err != nil {
+				return
+			}
+			return Variable(name), nil
+		case "[":
+			list := make([]interface{}, 0)
+			for !p.isPunct("]") {
+				if p.tok.typ == gqlEOF {
+					return nil, p.unexpected(p.tok)
+				}
+				var item interface{}
+				if item, err = p.parseValue(constant); err != nil {
+					return
+				}
+				list = append(list, item)
+			}
+			p.next() // ]
+			return list, nil
+		case "{":
+			obj := make(map[string]interface{})
+			for !p.isPunct("}") {
+				var name string
+				if name, err = p.expectName(); err != nil {
+					return
+				}
+				if err = p.expectPunct(":"); err != nil {
+					return
+				}
+				if obj[name], err = p.parseValue(constant); err != nil {
+					return
+				}
+			}
+			p.next() // }
+			return obj, nil
+		}
+	}
+
+	return nil, p.unexpected(t)
+}
+
+func (p *gqlParser) isPunct(value string) bool {
+	t := p.peek()
+	return t.typ == gqlPunct && t.value == value
+}
+
+func (p *gqlParser) expectPunct(value string) (err error) {
+	t := p.next()
+	if t.typ != gqlPunct || t.value != value {
+		return p.unexpected(t)
+	}
+	return
+}
+
+func (p *gqlParser) expectName() (name string, err error) {
+	t := p.next()
+	if t.typ != gqlName {
+		return "", p.unexpected(t)
+	}
+	return t.value, nil
+}
+
+func (p *gqlParser) unexpected(t gqlToken) error {
+	if p.err != nil {
+		return p.err
+	}
+	if t.typ == gqlEOF {
+		return errors.Wrap(ErrGraphQLSyntax, "unexpected end of document")
+	}
+	return errors.Wrapf(ErrGraphQLSyntax, "unexpected %q at %d", t.value, t.pos)
+}
+
+func (p *gqlParser) peek() gqlToken {
+	if !p.peeked {
+		var err error
+		if p.tok, err = p.lex(); err != nil {
+			// lexical error ends the token stream, reported by unexpected
+			p.err = err
+			p.tok = gqlToken{typ: gqlEOF, pos: p.pos}
+		}
+		p.peeked = true
+	}
+	return p.tok
+}
+
+func (p *gqlParser) next() (t gqlToken) {
+	t = p.peek()
+	p.peeked = false
+	return
+}
+
+func (p *gqlParser) lex() (t gqlToken, err error) {
+	// skip ignored tokens: whitespace, commas, comments and unicode bom
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+				p.pos++
+			}
+		} else if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+		} else {
+			break
+		}
+	}
+
+	start := p.pos
+	if start >= len(p.src) {
+		return gqlToken{typ: gqlEOF, pos: start}, nil
+	}
+
+	c := p.src[start]
+
+	switch {
+	case strings.HasPrefix(p.src[start:], "..."):
+		p.pos += 3
+		return gqlToken{typ: gqlPunct, value: "...", pos: start}, nil
+	case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+		p.pos++
+		return gqlToken{typ: gqlPunct, value: string(c), pos: start}, nil
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		p.pos++
+		for p.pos < len(p.src) && isGraphQLNamePart(p.src[p.pos]) {
+			p.pos++
+		}
+		return gqlToken{typ: gqlName, value: p.src[start:p.pos], pos: start}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		return p.lexNumber()
+	case c == '"':
+		if strings.HasPrefix(p.src[start:], `"""`) {
+			return p.lexBlockString()
+		}
+		return p.lexString()
+	}
+
+	err = errors.Wrapf(ErrGraphQLSyntax, "unexpected character %q at %d", c, start)
+	return
+}
+
+func (p *gqlParser) lexNumber() (t gqlToken, err error) {
+	start := p.pos
+	typ := gqlInt
+
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && isGraphQLNumberPart(p.src[p.pos]) {
+		if c := p.src[p.pos]; c == '.' || c == 'e' || c == 'E' {
+			typ = gqlFloat
+		}
+		p.pos++
+	}
+
+	value := p.src[start:p.pos]
+	if _, err = strconv.ParseFloat(value, 64); err != nil {
+		return t, errors.Wrapf(ErrGraphQLSyntax, "invalid number %q at %d", value, start)
+	}
+
+	return gqlToken{typ: typ, value: value, pos: start}, nil
+}
+
+func (p *gqlParser) lexString() (t gqlToken, err error) {
+	start := p.pos
+	p.pos++ // "
+
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return gqlToken{typ: gqlString, value: sb.String(), pos: start}, nil
+		case c == '\n' || c == '\r':
+			return t, errors.Wrapf(ErrGraphQLSyntax, "unterminated string at %d", start)
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case '"', '\\', '/':
+				sb.WriteByte(e)
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				if p.pos+4 >= len(p.src) {
+					return t, errors.Wrapf(ErrGraphQLSyntax, "invalid escape at %d", p.pos)
+				}
+				var r uint64
+				if r, err = strconv.ParseUint(p.src[p.pos+1:p.pos+5], 16, 32); err != nil {
+					return t, errors.Wrapf(ErrGraphQLSyntax, "invalid escape at %d", p.pos)
+				}
+				sb.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				return t, errors.Wrapf(ErrGraphQLSyntax, "invalid escape at %d", p.pos)
+			}
+			p.pos++
+		default:
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			sb.WriteString(p.src[p.pos : p.pos+size])
+			p.pos += size
+		}
+	}
+
+	return t, errors.Wrapf(ErrGraphQLSyntax, "unterminated string at %d", start)
+}
+
+func (p *gqlParser) lexBlockString() (t gqlToken, err error) {
+	start := p.pos
+	p.pos += 3
+
+	end := strings.Index(p.src[p.pos:], `"""`)
+	for end > 0 && p.src[p.pos+end-1] == '\\' {
+		// escaped triple quote
+		next := strings.Index(p.src[p.pos+end+1:], `"""`)
+		if next < 0 {
+			end = -1
+			break
+		}
+		end += next + 1
+	}
+	if end < 0 {
+		return t, errors.Wrapf(ErrGraphQLSyntax, "unterminated block string at %d", start)
+	}
+
+	value := strings.Replace(p.src[p.pos:p.pos+end], `\"""`, `"""`, -1)
+	p.pos += end + 3
+
+	return gqlToken{typ: gqlString, value: value, pos: start}, nil
+}
+
+func isGraphQLNamePart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isGraphQLNumberPart(c byte) bool {
+	return c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-'
+}
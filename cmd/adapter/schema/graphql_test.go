@@ -0,0 +1,141 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	Convey("graphql document should be parsed", t, func() {
+		op, err := ParseGraphQL(`
+			# list users
+			query List($min: Float = 1.5, $ids: [Int!]!) {
+				top: users(filter: {score: {_gte: $min}, id: {_in: $ids}}, order_by: [{score: desc}], limit: 10) {
+					id, name
+					__typename
+				}
+			}`, "")
+		So(err, ShouldBeNil)
+		So(op.Type, ShouldEqual, QueryOperation)
+		So(op.Name, ShouldEqual, "List")
+		So(op.Defaults["min"], ShouldEqual, json.Number("1.5"))
+		So(op.Selections, ShouldHaveLength, 1)
+
+		f := op.Selections[0]
+		So(f.ResponseKey(), ShouldEqual, "top")
+		So(f.Name, ShouldEqual, "users")
+		So(f.Arguments["limit"], ShouldEqual, json.Number("10"))
+		So(f.Arguments["order_by"], ShouldResemble, []interface{}{map[string]interface{}{"score": EnumValue("desc")}})
+		So(f.Selections, ShouldHaveLength, 3)
+
+		op, err = ParseGraphQL(`mutation { insert_users(objects: [{name: "a\"bé"}]) { affected_rows } }`, "")
+		So(err, ShouldBeNil)
+		So(op.Type, ShouldEqual, MutationOperation)
+		objects := op.Selections[0].Arguments["objects"].([]interface{})
+		So(objects[0].(map[string]interface{})["name"], ShouldEqual, "a\"bé")
+	})
+	Convey("unsupported or malformed documents should be rejected", t, func() {
+		for _, doc := range []string{
+			"",
+			"{ users { ...f } }",
+			"fragment f on users { id }",
+			"{ users { id }",
+			`{ users(filter: {name: "x}) { id } }`,
+			"{ users @skip(if: true) { id } }",
+			"query A { users { id } } query B { users { id } }",
+		} {
+			_, err := ParseGraphQL(doc, "")
+			So(err, ShouldNotBeNil)
+		}
+
+		op, err := ParseGraphQL("query A { a: users { id } } query B { b: users { id } }", "B")
+		So(err, ShouldBeNil)
+		So(op.Selections[0].Alias, ShouldEqual, "b")
+	})
+}
+
+func TestPlan(t *testing.T) {
+	Convey("Given a schema", t, func() {
+		s := testSchema()
+		limits := Limits{Default: 100, Max: 1000}
+
+		Convey("query should be planned to select statements", func() {
+			op, err := ParseGraphQL(`query($min: Float) {
+				__typename
+				users(filter: {score: {_gte: $min}}, limit: 5000) { name, n: name, __typename }
+			}`, "")
+			So(err, ShouldBeNil)
+
+			plan, err := s.Plan(op, map[string]interface{}{"min": json.Number("2")}, limits)
+			So(err, ShouldBeNil)
+			stmts := plan.Statements()
+			So(stmts, ShouldHaveLength, 1)
+			So(stmts[0].Query, ShouldEqual, `SELECT "name" FROM "users" WHERE ("score" >= ?) LIMIT ?`)
+			So(stmts[0].Args, ShouldResemble, []interface{}{int64(2), int64(1000)})
+
+			data, err := json.Marshal(plan.Data([][][]interface{}{{{"bob"}}}, nil))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"__typename":"Query","users":[{"name":"bob","n":"bob","__typename":"users"}]}`)
+		})
+		Convey("mutation should be planned to write statements", func() {
+			op, err := ParseGraphQL(`mutation {
+				insert_users(objects: [{id: 1}, {id: 2}]) { affected_rows }
+				delete_users(filter: {id: 3}) { __typename, affected_rows }
+			}`, "")
+			So(err, ShouldBeNil)
+
+			plan, err := s.Plan(op, nil, limits)
+			So(err, ShouldBeNil)
+			stmts := plan.Statements()
+			So(stmts, ShouldHaveLength, 3)
+			So(stmts[2].Query, ShouldEqual, `DELETE FROM "users" WHERE ("id" = ?)`)
+
+			data, err := json.Marshal(plan.Data(nil, []int64{1, 1, 0}))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"insert_users":{"affected_rows":2},"delete_users":{"__typename":"mutation_response","affected_rows":0}}`)
+		})
+		Convey("invalid fields should be rejected", func() {
+			for _, doc := range []string{
+				"{ orders { id } }",
+				"{ users { password } }",
+				"{ users(where: {}) { id } }",
+				"{ users { id { x } } }",
+				"{ users }",
+				"mutation { delete_users { affected_rows } }",
+				"mutation { drop_users { affected_rows } }",
+				"mutation { update_users(filter: {}, set: {}) { affected_rows } }",
+			} {
+				op, err := ParseGraphQL(doc, "")
+				So(err, ShouldBeNil)
+				_, err = s.Plan(op, nil, limits)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("sdl should describe generated api", func() {
+			sdl := s.SDL()
+			So(sdl, ShouldContainSubstring, "type users {\n  id: Int!\n  name: String\n  score: Float\n}")
+			So(sdl, ShouldContainSubstring, "users(filter: users_filter, order_by: [users_order_by!], limit: Int, offset: Int): [users!]!")
+			So(sdl, ShouldContainSubstring, "delete_users(filter: users_filter!): mutation_response!")
+			So(strings.Contains(sdl, "odd"), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,489 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	typenameField     = "__typename"
+	affectedRowsField = "affected_rows"
+	insertPrefix      = "insert_"
+	updatePrefix      = "update_"
+	deletePrefix      = "delete_"
+	queryTypeName     = "Query"
+	mutationTypeName  = "Mutation"
+	mutationResponse  = "mutation_response"
+)
+
+// Limits defines pagination limits of generated select statements.
+type Limits struct {
+	Default int // limit applied without limit argument, non-positive for no limit
+	Max     int // maximum limit, non-positive for unlimited
+}
+
+// Apply returns the effective limit of requested limit, non-positive limit stands for no request.
+func (l Limits) Apply(limit int) int {
+	if limit <= 0 {
+		limit = l.Default
+	}
+	if l.Max > 0 && (limit <= 0 || limit > l.Max) {
+		limit = l.Max
+	}
+	return limit
+}
+
+// Plan defines the statements generated for a graphql operation.
+type Plan struct {
+	Type   OperationType
+	Fields []*PlanField
+}
+
+// PlanField defines the statements and response shape of a top-level field.
+type PlanField struct {
+	Key        string
+	Table      *Table
+	Statements []*Statement
+	typename   string   // set for __typename field without statements
+	columns    []string // projected columns of select statement
+	selections []*Field
+}
+
+// Plan compiles the graphql operation to sql statements, variables override operation defaults.
+func (s *Schema) Plan(op *Operation, variables map[string]interface{}, limits Limits) (plan *Plan, err error) {
+	plan = &Plan{Type: op.Type}
+
+	vars := make(map[string]interface{}, len(op.Defaults)+len(variables))
+	for k, v := range op.Defaults {
+		vars[k] = v
+	}
+	for k, v := range variables {
+		vars[k] = v
+	}
+
+	for _, f := range op.Selections {
+		pf := &PlanField{Key: f.ResponseKey(), selections: f.Selections}
+
+		if f.Name == typenameField {
+			if op.Type == MutationOperation {
+				pf.typename = mutationTypeName
+			} else {
+				pf.typename = queryTypeName
+			}
+			plan.Fields = append(plan.Fields, pf)
+			continue
+		}
+
+		args := resolveValue(f.Arguments, vars).(map[string]interface{})
+
+		if op.Type == MutationOperation {
+			err = s.planMutation(pf, f, args)
+		} else {
+			err = s.planQuery(pf, f, args, limits)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", pf.Key)
+		}
+
+		plan.Fields = append(plan.Fields, pf)
+	}
+
+	return
+}
+
+func (s *Schema) planQuery(pf *PlanField, f *Field, args map[string]interface{}, limits Limits) (err error) {
+	if pf.Table, err = s.graphQLTable(f.Name); err != nil {
+		return
+	}
+
+	opts := &SelectOptions{}
+	limit := 0
+
+	for name, value := range args {
+		switch name {
+		case "filter":
+			if opts.Filter, err = objectArgument(name, value); err != nil {
+				return
+			}
+		case "order_by":
+			if opts.Orders, err = orderArgument(value); err != nil {
+				return
+			}
+		case "limit":
+			if limit, err = intArgument(name, value); err != nil {
+				return
+			}
+		case "offset":
+			if opts.Offset, err = intArgument(name, value); err != nil {
+				return
+			}
+		default:
+			return errors.Wrapf(ErrInvalidGraphQLField, "unknown argument %s", name)
+		}
+	}
+
+	opts.Limit = limits.Apply(limit)
+
+	if len(f.Selections) == 0 {
+		return errors.Wrap(ErrInvalidGraphQLField, "selection set is required")
+	}
+
+	for _, sel := range f.Selections {
+		if sel.Name == typenameField {
+			continue
+		}
+		if len(sel.Selections) > 0 || len(sel.Arguments) > 0 {
+			return errors.Wrapf(ErrInvalidGraphQLField, "column %s has no arguments or sub fields", sel.Name)
+		}
+		opts.Columns = appendUnique(opts.Columns, sel.Name)
+	}
+
+	if len(opts.Columns) == 0 {
+		// only __typename selected, select something to count rows
+		opts.Columns = []string{pf.Table.Columns[0].Name}
+	}
+
+	var stmt *Statement
+	if stmt, pf.columns, err = pf.Table.Select(opts); err != nil {
+		return
+	}
+	pf.Statements = []*Statement{stmt}
+
+	return
+}
+
+func (s *Schema) planMutation(pf *PlanField, f *Field, args map[string]interface{}) (err error) {
+	var prefix string
+	for _, p := range []string{insertPrefix, updatePrefix, deletePrefix} {
+		if strings.HasPrefix(f.Name, p) {
+			prefix = p
+			break
+		}
+	}
+	if prefix == "" {
+		return errors.Wrapf(ErrInvalidGraphQLField, "unknown mutation %s", f.Name)
+	}
+
+	if pf.Table, err = s.graphQLTable(strings.TrimPrefix(f.Name, prefix)); err != nil {
+		return
+	}
+
+	for _, sel := range f.Selections {
+		if sel.Name != affectedRowsField && sel.Name != typenameField {
+			return errors.Wrapf(ErrInvalidGraphQLField, "unknown field %s of %s", sel.Name, mutationResponse)
+		}
+	}
+	if len(f.Selections) == 0 {
+		return errors.Wrap(ErrInvalidGraphQLField, "selection set is required")
+	}
+
+	allowed := map[string][]string{
+		insertPrefix: {"objects"},
+		updatePrefix: {"filter", "set"},
+		deletePrefix: {"filter"},
+	}[prefix]
+	for name := range args {
+		if !containsString(allowed, name) {
+			return errors.Wrapf(ErrInvalidGraphQLField, "unknown argument %s", name)
+		}
+	}
+	for _, name := range allowed {
+		if _, ok := args[name]; !ok {
+			return errors.Wrapf(ErrInvalidGraphQLField, "argument %s is required", name)
+		}
+	}
+
+	switch prefix {
+	case insertPrefix:
+		objects, ok := args["objects"].([]interface{})
+		if !ok {
+			// single object is coerced to list
+			objects = []interface{}{args["objects"]}
+		}
+		for _, o := range objects {
+			var values map[string]interface{}
+			if values, err = objectArgument("objects", o); err != nil {
+				return
+			}
+			var stmt *Statement
+			if stmt, err = pf.Table.Insert(values); err != nil {
+				return
+			}
+			pf.Statements = append(pf.Statements, stmt)
+		}
+	case updatePrefix:
+		var filter, values map[string]interface{}
+		if filter, err = objectArgument("filter", args["filter"]); err != nil {
+			return
+		}
+		if values, err = objectArgument("set", args["set"]); err != nil {
+			return
+		}
+		var stmt *Statement
+		if stmt, err = pf.Table.Update(values, filter); err != nil {
+			return
+		}
+		pf.Statements = []*Statement{stmt}
+	case deletePrefix:
+		var filter map[string]interface{}
+		if filter, err = objectArgument("filter", args["filter"]); err != nil {
+			return
+		}
+		var stmt *Statement
+		if stmt, err = pf.Table.Delete(filter); err != nil {
+			return
+		}
+		pf.Statements = []*Statement{stmt}
+	}
+
+	return
+}
+
+// Statements returns statements of all fields in execution order.
+func (p *Plan) Statements() (stmts []*Statement) {
+	for _, f := range p.Fields {
+		stmts = append(stmts, f.Statements...)
+	}
+	return
+}
+
+// Data builds graphql response data from statement results in execution order,
+// rows are results of query plan and affectedRows are results of mutation plan.
+func (p *Plan) Data(rows [][][]interface{}, affectedRows []int64) (data *Object) {
+	data = &Object{}
+
+	for _, f := range p.Fields {
+		if f.typename != "" {
+			data.Set(f.Key, f.typename)
+			continue
+		}
+
+		if p.Type == MutationOperation {
+			var affected int64
+			for range f.Statements {
+				if len(affectedRows) > 0 {
+					affected += affectedRows[0]
+					affectedRows = affectedRows[1:]
+				}
+			}
+
+			result := &Object{}
+			for _, sel := range f.selections {
+				if sel.Name == typenameField {
+					result.Set(sel.ResponseKey(), mutationResponse)
+				} else {
+					result.Set(sel.ResponseKey(), affected)
+				}
+			}
+			data.Set(f.Key, result)
+			continue
+		}
+
+		var fieldRows [][]interface{}
+		if len(rows) > 0 {
+			fieldRows, rows = rows[0], rows[1:]
+		}
+
+		list := make([]*Object, 0, len(fieldRows))
+		for _, row := range fieldRows {
+			item := &Object{}
+			for _, sel := range f.selections {
+				if sel.Name == typenameField {
+					item.Set(sel.ResponseKey(), f.Table.Name)
+					continue
+				}
+				for i, c := range f.columns {
+					if c == sel.Name && i < len(row) {
+						item.Set(sel.ResponseKey(), row[i])
+						break
+					}
+				}
+			}
+			list = append(list, item)
+		}
+		data.Set(f.Key, list)
+	}
+
+	return
+}
+
+// Object defines a json object preserving key order as required by graphql response.
+type Object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Set sets the value of key, the first set determines the key order.
+func (o *Object) Set(key string, value interface{}) {
+	if o.values == nil {
+		o.values = make(map[string]interface{})
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// Get returns the value of key.
+func (o *Object) Get(key string) interface{} {
+	return o.values[key]
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (s *Schema) graphQLTable(name string) (t *Table, err error) {
+	if !isGraphQLName(name) {
+		return nil, errors.Wrapf(ErrInvalidGraphQLField, "unknown table %s", name)
+	}
+	if t, err = s.Table(name); err != nil {
+		return nil, errors.Wrapf(ErrInvalidGraphQLField, "unknown table %s", name)
+	}
+	if len(t.Columns) == 0 {
+		return nil, errors.Wrapf(ErrInvalidGraphQLField, "table %s has no columns", name)
+	}
+	return
+}
+
+// resolveValue substitutes variables and enum values in argument values.
+func resolveValue(value interface{}, vars map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case Variable:
+		return vars[string(v)]
+	case EnumValue:
+		return string(v)
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, resolveValue(item, vars))
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			obj[k] = resolveValue(item, vars)
+		}
+		return obj
+	default:
+		return v
+	}
+}
+
+func objectArgument(name string, value interface{}) (obj map[string]interface{}, err error) {
+	if value == nil {
+		return
+	}
+	var ok bool
+	if obj, ok = value.(map[string]interface{}); !ok {
+		err = errors.Wrapf(ErrInvalidGraphQLField, "argument %s requires an object", name)
+	}
+	return
+}
+
+func intArgument(name string, value interface{}) (i int, err error) {
+	var v interface{}
+	if v, err = NormalizeValue(value); err == nil {
+		switch n := v.(type) {
+		case nil:
+			return 0, nil
+		case int64:
+			if n >= 0 {
+				return int(n), nil
+			}
+		}
+	}
+	return 0, errors.Wrapf(ErrInvalidGraphQLField, "argument %s requires a non-negative integer", name)
+}
+
+// orderArgument parses order_by argument like [{age: desc}, {name: asc}],
+// columns of a single object are ordered by name.
+func orderArgument(value interface{}) (orders []Order, err error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.Wrap(ErrInvalidOrder, "order_by requires objects")
+		}
+		columns := make([]string, 0, len(obj))
+		for c := range obj {
+			columns = append(columns, c)
+		}
+		sort.Strings(columns)
+		for _, c := range columns {
+			switch obj[c] {
+			case "asc":
+				orders = append(orders, Order{Column: c})
+			case "desc":
+				orders = append(orders, Order{Column: c, Desc: true})
+			default:
+				return nil, errors.Wrapf(ErrInvalidOrder, "column %s requires asc or desc", c)
+			}
+		}
+	}
+
+	return
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,426 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	filterAnd = "_and"
+	filterOr  = "_or"
+	filterNot = "_not"
+)
+
+var filterOperators = map[string]string{
+	"_eq":   "=",
+	"_ne":   "<>",
+	"_gt":   ">",
+	"_gte":  ">=",
+	"_lt":   "<",
+	"_lte":  "<=",
+	"_like": "LIKE",
+}
+
+// Statement defines a generated sql statement with arguments.
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// Order defines a sort column.
+type Order struct {
+	Column string
+	Desc   bool
+}
+
+// SelectOptions defines the projection, filter and pagination of select statement.
+type SelectOptions struct {
+	Columns []string // empty for all columns
+	Filter  map[string]interface{}
+	Orders  []Order
+	Limit   int // non-positive for no limit
+	Offset  int
+}
+
+// ParseOrder parses order expression like "age.desc,name".
+func ParseOrder(expr string) (orders []Order, err error) {
+	if strings.TrimSpace(expr) == "" {
+		return
+	}
+
+	for _, item := range strings.Split(expr, ",") {
+		o := Order{Column: strings.TrimSpace(item)}
+		if i := strings.LastIndex(o.Column, "."); i >= 0 {
+			switch strings.ToLower(o.Column[i+1:]) {
+			case "asc":
+			case "desc":
+				o.Desc = true
+			default:
+				return nil, ErrInvalidOrder
+			}
+			o.Column = o.Column[:i]
+		}
+		if o.Column == "" {
+			return nil, ErrInvalidOrder
+		}
+		orders = append(orders, o)
+	}
+
+	return
+}
+
+// ParseFilter parses json filter expression like {"age": {"_gt": 18}, "name": "bob"}.
+func ParseFilter(expr string) (filter map[string]interface{}, err error) {
+	if strings.TrimSpace(expr) == "" {
+		return
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(expr))
+	decoder.UseNumber()
+
+	if err = decoder.Decode(&filter); err != nil {
+		return nil, errors.Wrap(ErrInvalidFilter, err.Error())
+	}
+
+	return
+}
+
+// Select builds select statement of table, the resulting column names are returned in projection order.
+func (t *Table) Select(opts *SelectOptions) (stmt *Statement, columns []string, err error) {
+	stmt = &Statement{}
+
+	columns = opts.Columns
+	if len(columns) == 0 {
+		columns = make([]string, 0, len(t.Columns))
+		for _, c := range t.Columns {
+			columns = append(columns, c.Name)
+		}
+	}
+
+	projection := make([]string, 0, len(columns))
+	for _, name := range columns {
+		if _, err = t.Column(name); err != nil {
+			return nil, nil, errors.Wrapf(err, "column %s", name)
+		}
+		projection = append(projection, QuoteIdentifier(name))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	sb.WriteString(strings.Join(projection, ", "))
+	sb.WriteString(" FROM ")
+	sb.WriteString(QuoteIdentifier(t.Name))
+
+	if err = t.writeWhere(&sb, opts.Filter, &stmt.Args); err != nil {
+		return nil, nil, err
+	}
+
+	if len(opts.Orders) > 0 {
+		orders := make([]string, 0, len(opts.Orders))
+		for _, o := range opts.Orders {
+			if _, err = t.Column(o.Column); err != nil {
+				return nil, nil, errors.Wrapf(err, "column %s", o.Column)
+			}
+			if o.Desc {
+				orders = append(orders, QuoteIdentifier(o.Column)+" DESC")
+			} else {
+				orders = append(orders, QuoteIdentifier(o.Column)+" ASC")
+			}
+		}
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(strings.Join(orders, ", "))
+	}
+
+	if opts.Limit > 0 {
+		sb.WriteString(" LIMIT ?")
+		stmt.Args = append(stmt.Args, int64(opts.Limit))
+		if opts.Offset > 0 {
+			sb.WriteString(" OFFSET ?")
+			stmt.Args = append(stmt.Args, int64(opts.Offset))
+		}
+	} else if opts.Offset > 0 {
+		sb.WriteString(" LIMIT -1 OFFSET ?")
+		stmt.Args = append(stmt.Args, int64(opts.Offset))
+	}
+
+	stmt.Query = sb.String()
+
+	return
+}
+
+// Insert builds insert statement of a single row.
+func (t *Table) Insert(values map[string]interface{}) (stmt *Statement, err error) {
+	if len(values) == 0 {
+		return nil, ErrEmptyValues
+	}
+
+	stmt = &Statement{}
+	names := sortedKeys(values)
+	columns := make([]string, 0, len(names))
+	placeholders := make([]string, 0, len(names))
+
+	for _, name := range names {
+		var v interface{}
+		if v, err = t.columnValue(name, values[name]); err != nil {
+			return nil, err
+		}
+		columns = append(columns, QuoteIdentifier(name))
+		placeholders = append(placeholders, "?")
+		stmt.Args = append(stmt.Args, v)
+	}
+
+	stmt.Query = "INSERT INTO " + QuoteIdentifier(t.Name) + " (" + strings.Join(columns, ", ") +
+		") VALUES (" + strings.Join(placeholders, ", ") + ")"
+
+	return
+}
+
+// Update builds update statement of rows matching the filter.
+func (t *Table) Update(values map[string]interface{}, filter map[string]interface{}) (stmt *Statement, err error) {
+	if len(values) == 0 {
+		return nil, ErrEmptyValues
+	}
+
+	stmt = &Statement{}
+	names := sortedKeys(values)
+	sets := make([]string, 0, len(names))
+
+	for _, name := range names {
+		var v interface{}
+		if v, err = t.columnValue(name, values[name]); err != nil {
+			return nil, err
+		}
+		sets = append(sets, QuoteIdentifier(name)+" = ?")
+		stmt.Args = append(stmt.Args, v)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("UPDATE ")
+	sb.WriteString(QuoteIdentifier(t.Name))
+	sb.WriteString(" SET ")
+	sb.WriteString(strings.Join(sets, ", "))
+
+	if err = t.writeWhere(&sb, filter, &stmt.Args); err != nil {
+		return nil, err
+	}
+
+	stmt.Query = sb.String()
+
+	return
+}
+
+// Delete builds delete statement of rows matching the filter.
+func (t *Table) Delete(filter map[string]interface{}) (stmt *Statement, err error) {
+	stmt = &Statement{}
+
+	var sb strings.Builder
+	sb.WriteString("DELETE FROM ")
+	sb.WriteString(QuoteIdentifier(t.Name))
+
+	if err = t.writeWhere(&sb, filter, &stmt.Args); err != nil {
+		return nil, err
+	}
+
+	stmt.Query = sb.String()
+
+	return
+}
+
+func (t *Table) columnValue(name string, value interface{}) (v interface{}, err error) {
+	if _, err = t.Column(name); err != nil {
+		return nil, errors.Wrapf(err, "column %s", name)
+	}
+	if v, err = NormalizeValue(value); err != nil {
+		return nil, errors.Wrapf(err, "column %s", name)
+	}
+	return
+}
+
+func (t *Table) writeWhere(sb *strings.Builder, filter map[string]interface{}, args *[]interface{}) (err error) {
+	if len(filter) == 0 {
+		return
+	}
+
+	var clause string
+	if clause, err = t.buildFilter(filter, args); err != nil {
+		return
+	}
+
+	sb.WriteString(" WHERE ")
+	sb.WriteString(clause)
+
+	return
+}
+
+// buildFilter compiles the filter object, conditions of object are joined with AND.
+func (t *Table) buildFilter(filter map[string]interface{}, args *[]interface{}) (clause string, err error) {
+	conditions := make([]string, 0, len(filter))
+
+	for _, key := range sortedKeys(filter) {
+		value := filter[key]
+		var cond string
+
+		switch key {
+		case filterAnd, filterOr:
+			items, ok := value.([]interface{})
+			if !ok || len(items) == 0 {
+				return "", errors.Wrapf(ErrInvalidFilter, "%s requires a non-empty list", key)
+			}
+			parts := make([]string, 0, len(items))
+			for _, item := range items {
+				sub, ok := item.(map[string]interface{})
+				if !ok || len(sub) == 0 {
+					return "", errors.Wrapf(ErrInvalidFilter, "%s requires filter objects", key)
+				}
+				var part string
+				if part, err = t.buildFilter(sub, args); err != nil {
+					return
+				}
+				parts = append(parts, part)
+			}
+			if key == filterAnd {
+				cond = "(" + strings.Join(parts, " AND ") + ")"
+			} else {
+				cond = "(" + strings.Join(parts, " OR ") + ")"
+			}
+		case filterNot:
+			sub, ok := value.(map[string]interface{})
+			if !ok || len(sub) == 0 {
+				return "", errors.Wrapf(ErrInvalidFilter, "%s requires a filter object", key)
+			}
+			var part string
+			if part, err = t.buildFilter(sub, args); err != nil {
+				return
+			}
+			cond = "NOT " + part
+		default:
+			if _, err = t.Column(key); err != nil {
+				return "", errors.Wrapf(err, "column %s", key)
+			}
+			if cond, err = buildColumnFilter(QuoteIdentifier(key), value, args); err != nil {
+				return "", errors.Wrapf(err, "column %s", key)
+			}
+		}
+
+		conditions = append(conditions, cond)
+	}
+
+	return "(" + strings.Join(conditions, " AND ") + ")", nil
+}
+
+func buildColumnFilter(column string, value interface{}, args *[]interface{}) (cond string, err error) {
+	ops, isOps := value.(map[string]interface{})
+	if !isOps {
+		// scalar value is a shortcut of _eq
+		ops = map[string]interface{}{"_eq": value}
+	}
+	if len(ops) == 0 {
+		return "", ErrInvalidFilter
+	}
+
+	conditions := make([]string, 0, len(ops))
+
+	for _, op := range sortedKeys(ops) {
+		operand := ops[op]
+
+		switch op {
+		case "_in":
+			items, ok := operand.([]interface{})
+			if !ok {
+				return "", errors.Wrap(ErrInvalidFilter, "_in requires a list")
+			}
+			if len(items) == 0 {
+				// nothing matches an empty set
+				conditions = append(conditions, "0")
+				continue
+			}
+			placeholders := make([]string, 0, len(items))
+			for _, item := range items {
+				var v interface{}
+				if v, err = NormalizeValue(item); err != nil {
+					return
+				}
+				placeholders = append(placeholders, "?")
+				*args = append(*args, v)
+			}
+			conditions = append(conditions, column+" IN ("+strings.Join(placeholders, ", ")+")")
+		case "_is_null":
+			isNull, ok := operand.(bool)
+			if !ok {
+				return "", errors.Wrap(ErrInvalidFilter, "_is_null requires a boolean")
+			}
+			if isNull {
+				conditions = append(conditions, column+" IS NULL")
+			} else {
+				conditions = append(conditions, column+" IS NOT NULL")
+			}
+		default:
+			sqlOp, ok := filterOperators[op]
+			if !ok {
+				return "", errors.Wrapf(ErrInvalidFilter, "unknown operator %s", op)
+			}
+			var v interface{}
+			if v, err = NormalizeValue(operand); err != nil {
+				return
+			}
+			if v == nil && (op == "_eq" || op == "_ne") {
+				if op == "_eq" {
+					conditions = append(conditions, column+" IS NULL")
+				} else {
+					conditions = append(conditions, column+" IS NOT NULL")
+				}
+				continue
+			}
+			conditions = append(conditions, column+" "+sqlOp+" ?")
+			*args = append(*args, v)
+		}
+	}
+
+	return strings.Join(conditions, " AND "), nil
+}
+
+// NormalizeValue converts decoded json/graphql value to sql argument.
+func NormalizeValue(value interface{}) (v interface{}, err error) {
+	switch a := value.(type) {
+	case nil, string, bool, int64, float64:
+		return a, nil
+	case int:
+		return int64(a), nil
+	case json.Number:
+		if i, err := a.Int64(); err == nil {
+			return i, nil
+		}
+		return a.Float64()
+	default:
+		// nested lists and objects are not valid sql values
+		return nil, ErrInvalidValue
+	}
+}
+
+func sortedKeys(m map[string]interface{}) (keys []string) {
+	keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testSchema() *Schema {
+	return NewSchema([]*Table{
+		{
+			Name: "users",
+			Columns: []*Column{
+				{Name: "id", DeclType: "INTEGER", NotNull: true, PrimaryKey: true},
+				{Name: "name", DeclType: "VARCHAR(32)"},
+				{Name: "score", DeclType: "REAL"},
+			},
+		},
+		{
+			Name: "odd table",
+			Columns: []*Column{
+				{Name: "v", DeclType: "BLOB"},
+			},
+		},
+	})
+}
+
+func TestLoad(t *testing.T) {
+	Convey("schema should be discovered with table_info", t, func() {
+		var queries []string
+		s, err := Load(func(query string, args ...interface{}) (columns []string, rows [][]interface{}, err error) {
+			queries = append(queries, query)
+			if len(queries) == 1 {
+				return []string{"name"}, [][]interface{}{{"users"}}, nil
+			}
+			return nil, [][]interface{}{
+				{int64(0), "id", "INTEGER", int64(1), nil, int64(1)},
+				{int64(1), "flag", "boolean", int64(0), "0", int64(0)},
+			}, nil
+		})
+		So(err, ShouldBeNil)
+		So(queries[1], ShouldEqual, `PRAGMA table_info("users")`)
+
+		table, err := s.Table("users")
+		So(err, ShouldBeNil)
+		So(table.Columns, ShouldHaveLength, 2)
+		So(table.Columns[0].Type, ShouldEqual, IntScalar)
+		So(table.Columns[0].PrimaryKey, ShouldBeTrue)
+		So(table.Columns[1].Type, ShouldEqual, BooleanScalar)
+		So(table.Columns[1].NotNull, ShouldBeFalse)
+
+		_, err = s.Table("orders")
+		So(err, ShouldEqual, ErrUnknownTable)
+	})
+}
+
+func TestStatements(t *testing.T) {
+	Convey("Given a users table", t, func() {
+		table, err := testSchema().Table("users")
+		So(err, ShouldBeNil)
+
+		Convey("select should compile filter, order and pagination", func() {
+			filter, err := ParseFilter(`{"score": {"_gte": 1.5, "_lt": 10}, "_or": [{"name": {"_like": "a%"}}, {"name": null}], "id": {"_in": [1, 2]}}`)
+			So(err, ShouldBeNil)
+			orders, err := ParseOrder("score.desc,id")
+			So(err, ShouldBeNil)
+
+			stmt, columns, err := table.Select(&SelectOptions{
+				Columns: []string{"id", "name"},
+				Filter:  filter,
+				Orders:  orders,
+				Limit:   10,
+				Offset:  20,
+			})
+			So(err, ShouldBeNil)
+			So(columns, ShouldResemble, []string{"id", "name"})
+			So(stmt.Query, ShouldEqual, `SELECT "id", "name" FROM "users" WHERE ((("name" LIKE ?) OR ("name" IS NULL)) AND "id" IN (?, ?) AND "score" >= ? AND "score" < ?) ORDER BY "score" DESC, "id" ASC LIMIT ? OFFSET ?`)
+			So(stmt.Args, ShouldResemble, []interface{}{"a%", int64(1), int64(2), 1.5, int64(10), int64(10), int64(20)})
+		})
+		Convey("select without options should list all columns", func() {
+			stmt, columns, err := table.Select(&SelectOptions{Offset: 5})
+			So(err, ShouldBeNil)
+			So(columns, ShouldResemble, []string{"id", "name", "score"})
+			So(stmt.Query, ShouldEqual, `SELECT "id", "name", "score" FROM "users" LIMIT -1 OFFSET ?`)
+		})
+		Convey("insert, update and delete should bind values", func() {
+			stmt, err := table.Insert(map[string]interface{}{"name": "bob", "id": 1})
+			So(err, ShouldBeNil)
+			So(stmt.Query, ShouldEqual, `INSERT INTO "users" ("id", "name") VALUES (?, ?)`)
+			So(stmt.Args, ShouldResemble, []interface{}{int64(1), "bob"})
+
+			stmt, err = table.Update(map[string]interface{}{"name": "alice"}, map[string]interface{}{"_not": map[string]interface{}{"id": 1}})
+			So(err, ShouldBeNil)
+			So(stmt.Query, ShouldEqual, `UPDATE "users" SET "name" = ? WHERE (NOT ("id" = ?))`)
+			So(stmt.Args, ShouldResemble, []interface{}{"alice", int64(1)})
+
+			stmt, err = table.Delete(map[string]interface{}{"id": map[string]interface{}{"_in": []interface{}{}}})
+			So(err, ShouldBeNil)
+			So(stmt.Query, ShouldEqual, `DELETE FROM "users" WHERE (0)`)
+		})
+		Convey("invalid input should be rejected", func() {
+			_, _, err := table.Select(&SelectOptions{Columns: []string{"password"}})
+			So(err, ShouldNotBeNil)
+			_, err = table.Delete(map[string]interface{}{"id": map[string]interface{}{"_regexp": "x"}})
+			So(err, ShouldNotBeNil)
+			_, err = table.Insert(map[string]interface{}{})
+			So(err, ShouldEqual, ErrEmptyValues)
+			_, err = table.Insert(map[string]interface{}{"name": []interface{}{"x"}})
+			So(err, ShouldNotBeNil)
+			_, err = ParseOrder("id.up")
+			So(err, ShouldEqual, ErrInvalidOrder)
+			_, err = ParseFilter("[1]")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	graphQLNameRegex = regexp.MustCompile("^[_A-Za-z][_0-9A-Za-z]*$")
+)
+
+// Scalar defines the graphql scalar type of column.
+type Scalar string
+
+const (
+	// IntScalar defines integer affinity columns.
+	IntScalar Scalar = "Int"
+	// FloatScalar defines real/numeric affinity columns.
+	FloatScalar Scalar = "Float"
+	// StringScalar defines text and blob affinity columns.
+	StringScalar Scalar = "String"
+	// BooleanScalar defines columns declared as boolean.
+	BooleanScalar Scalar = "Boolean"
+)
+
+// QueryFunc defines the read query used to discover schema.
+type QueryFunc func(query string, args ...interface{}) (columns []string, rows [][]interface{}, err error)
+
+// Column defines a table column.
+type Column struct {
+	Name       string
+	DeclType   string
+	NotNull    bool
+	PrimaryKey bool
+	Type       Scalar
+}
+
+// Table defines a table and its columns in declaration order.
+type Table struct {
+	Name    string
+	Columns []*Column
+	columns map[string]*Column
+}
+
+// Schema defines tables of a database.
+type Schema struct {
+	Tables []*Table
+	tables map[string]*Table
+}
+
+// Load discovers database schema with sqlite_master and table_info pragma.
+func Load(query QueryFunc) (s *Schema, err error) {
+	var rows [][]interface{}
+	if _, rows, err = query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"); err != nil {
+		return
+	}
+
+	tables := make([]*Table, 0, len(rows))
+
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+
+		t := &Table{Name: fmt.Sprint(row[0])}
+
+		var columnRows [][]interface{}
+		if _, columnRows, err = query("PRAGMA table_info(" + QuoteIdentifier(t.Name) + ")"); err != nil {
+			return
+		}
+
+		// cid, name, type, notnull, dflt_value, pk
+		for _, cr := range columnRows {
+			if len(cr) < 6 {
+				continue
+			}
+
+			c := &Column{
+				Name:       fmt.Sprint(cr[1]),
+				NotNull:    toInt64(cr[3]) != 0,
+				PrimaryKey: toInt64(cr[5]) != 0,
+			}
+			if cr[2] != nil {
+				c.DeclType = fmt.Sprint(cr[2])
+			}
+			c.Type = scalarFromDeclType(c.DeclType)
+
+			t.Columns = append(t.Columns, c)
+		}
+
+		tables = append(tables, t)
+	}
+
+	return NewSchema(tables), nil
+}
+
+// NewSchema builds schema from table definitions.
+func NewSchema(tables []*Table) (s *Schema) {
+	s = &Schema{
+		Tables: tables,
+		tables: make(map[string]*Table, len(tables)),
+	}
+
+	sort.Slice(s.Tables, func(i, j int) bool {
+		return s.Tables[i].Name < s.Tables[j].Name
+	})
+
+	for _, t := range tables {
+		t.columns = make(map[string]*Column, len(t.Columns))
+		for _, c := range t.Columns {
+			if c.Type == "" {
+				c.Type = scalarFromDeclType(c.DeclType)
+			}
+			t.columns[c.Name] = c
+		}
+		s.tables[t.Name] = t
+	}
+
+	return
+}
+
+// Table returns table by name.
+func (s *Schema) Table(name string) (t *Table, err error) {
+	var ok bool
+	if t, ok = s.tables[name]; !ok {
+		err = ErrUnknownTable
+	}
+	return
+}
+
+// Column returns column by name.
+func (t *Table) Column(name string) (c *Column, err error) {
+	var ok bool
+	if c, ok = t.columns[name]; !ok {
+		err = ErrUnknownColumn
+	}
+	return
+}
+
+// QuoteIdentifier quotes table or column name for sql statements.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// isGraphQLName reports whether name could be used as graphql type or field name.
+func isGraphQLName(name string) bool {
+	return graphQLNameRegex.MatchString(name) && !strings.HasPrefix(name, "__")
+}
+
+// scalarFromDeclType maps declared column type to graphql scalar with sqlite affinity rules.
+func scalarFromDeclType(declType string) Scalar {
+	t := strings.ToUpper(declType)
+
+	switch {
+	case strings.Contains(t, "INT"):
+		return IntScalar
+	case strings.Contains(t, "BOOL"):
+		return BooleanScalar
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"),
+		strings.Contains(t, "BLOB"), t == "":
+		return StringScalar
+	default:
+		// REAL, FLOAT, DOUBLE and NUMERIC affinity
+		return FloatScalar
+	}
+}
+
+func toInt64(v interface{}) int64 {
+	switch i := v.(type) {
+	case int64:
+		return i
+	case int:
+		return int64(i)
+	case float64:
+		return int64(i)
+	case bool:
+		if i {
+			return 1
+		}
+		return 0
+	case string:
+		n, _ := strconv.ParseInt(i, 10, 64)
+		return n
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schema
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SDL returns the graphql schema definition of generated api, tables or columns
+// which are not valid graphql names are omitted.
+func (s *Schema) SDL() string {
+	var sb strings.Builder
+	var queries, mutations []string
+	scalars := make(map[Scalar]bool)
+
+	for _, t := range s.Tables {
+		if !isGraphQLName(t.Name) {
+			continue
+		}
+
+		var columns []*Column
+		for _, c := range t.Columns {
+			if isGraphQLName(c.Name) {
+				columns = append(columns, c)
+				scalars[c.Type] = true
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "type %s {\n", t.Name)
+		for _, c := range columns {
+			if c.NotNull {
+				fmt.Fprintf(&sb, "  %s: %s!\n", c.Name, c.Type)
+			} else {
+				fmt.Fprintf(&sb, "  %s: %s\n", c.Name, c.Type)
+			}
+		}
+		sb.WriteString("}\n\n")
+
+		fmt.Fprintf(&sb, "input %s_filter {\n", t.Name)
+		fmt.Fprintf(&sb, "  %s: [%s_filter!]\n", filterAnd, t.Name)
+		fmt.Fprintf(&sb, "  %s: [%s_filter!]\n", filterOr, t.Name)
+		fmt.Fprintf(&sb, "  %s: %s_filter\n", filterNot, t.Name)
+		for _, c := range columns {
+			fmt.Fprintf(&sb, "  %s: %s_comparison\n", c.Name, c.Type)
+		}
+		sb.WriteString("}\n\n")
+
+		fmt.Fprintf(&sb, "input %s_order_by {\n", t.Name)
+		for _, c := range columns {
+			fmt.Fprintf(&sb, "  %s: order_direction\n", c.Name)
+		}
+		sb.WriteString("}\n\n")
+
+		fmt.Fprintf(&sb, "input %s_input {\n", t.Name)
+		for _, c := range columns {
+			fmt.Fprintf(&sb, "  %s: %s\n", c.Name, c.Type)
+		}
+		sb.WriteString("}\n\n")
+
+		queries = append(queries, fmt.Sprintf(
+			"  %s(filter: %s_filter, order_by: [%s_order_by!], limit: Int, offset: Int): [%s!]!",
+			t.Name, t.Name, t.Name, t.Name))
+		mutations = append(mutations,
+			fmt.Sprintf("  %s%s(objects: [%s_input!]!): %s!", insertPrefix, t.Name, t.Name, mutationResponse),
+			fmt.Sprintf("  %s%s(filter: %s_filter!, set: %s_input!): %s!", updatePrefix, t.Name, t.Name, t.Name, mutationResponse),
+			fmt.Sprintf("  %s%s(filter: %s_filter!): %s!", deletePrefix, t.Name, t.Name, mutationResponse))
+	}
+
+	for _, scalar := range []Scalar{IntScalar, FloatScalar, StringScalar, BooleanScalar} {
+		if !scalars[scalar] {
+			continue
+		}
+		fmt.Fprintf(&sb, "input %s_comparison {\n", scalar)
+		for _, op := range []string{"_eq", "_ne", "_gt", "_gte", "_lt", "_lte"} {
+			fmt.Fprintf(&sb, "  %s: %s\n", op, scalar)
+		}
+		fmt.Fprintf(&sb, "  _in: [%s!]\n", scalar)
+		sb.WriteString("  _like: String\n")
+		sb.WriteString("  _is_null: Boolean\n")
+		sb.WriteString("}\n\n")
+	}
+
+	sb.WriteString("enum order_direction {\n  asc\n  desc\n}\n\n")
+	fmt.Fprintf(&sb, "type %s {\n  %s: Int!\n}\n\n", mutationResponse, affectedRowsField)
+
+	if len(queries) > 0 {
+		fmt.Fprintf(&sb, "type %s {\n%s\n}\n\n", queryTypeName, strings.Join(queries, "\n"))
+		fmt.Fprintf(&sb, "type %s {\n%s\n}\n\n", mutationTypeName, strings.Join(mutations, "\n"))
+		fmt.Fprintf(&sb, "schema {\n  query: %s\n  mutation: %s\n}\n", queryTypeName, mutationTypeName)
+	}
+
+	return sb.String()
+}
@@ -23,7 +23,10 @@ import (
 )
 
 // ThunderDBStorage defines the thunderdb database abstraction.
-type ThunderDBStorage struct{}
+type ThunderDBStorage struct {
+	// client identity name used to sign queries, empty for adapter identity
+	identity string
+}
 
 // NewCovenantSQLStorage returns new thunderdb storage handler.
 func NewCovenantSQLStorage() (s *ThunderDBStorage) {
@@ -31,10 +34,15 @@ func NewCovenantSQLStorage() (s *ThunderDBStorage) {
 	return
 }
 
+// WithIdentity implements the DelegatedStorage abstraction interface.
+func (s *ThunderDBStorage) WithIdentity(identity string) Storage {
+	return &ThunderDBStorage{identity: identity}
+}
+
 // Create implements the Storage abstraction interface.
 func (s *ThunderDBStorage) Create(nodeCnt int) (dbID string, err error) {
 	var dsn string
-	if dsn, err = client.Create(client.ResourceMeta{Node: uint16(nodeCnt)}); err != nil {
+	if dsn, err = client.CreateWithIdentity(client.ResourceMeta{Node: uint16(nodeCnt)}, s.identity); err != nil {
 		return
 	}
 
@@ -51,12 +59,13 @@ func (s *ThunderDBStorage) Create(nodeCnt int) (dbID string, err error) {
 func (s *ThunderDBStorage) Drop(dbID string) (err error) {
 	cfg := client.NewConfig()
 	cfg.DatabaseID = dbID
+	cfg.Identity = s.identity
 	err = client.Drop(cfg.FormatDSN())
 	return
 }
 
 // Query implements the Storage abstraction interface.
-func (s *ThunderDBStorage) Query(dbID string, query string) (columns []string, types []string, result [][]interface{}, err error) {
+func (s *ThunderDBStorage) Query(dbID string, query string, args ...interface{}) (columns []string, types []string, result [][]interface{}, err error) {
 	var conn *sql.DB
 	if conn, err = s.getConn(dbID); err != nil {
 		return
@@ -64,7 +73,7 @@ func (s *ThunderDBStorage) Query(dbID string, query string) (columns []string, t
 	defer conn.Close()
 
 	var rows *sql.Rows
-	if rows, err = conn.Query(query); err != nil {
+	if rows, err = conn.Query(query, args...); err != nil {
 		return
 	}
 	defer rows.Close()
@@ -92,14 +101,64 @@ func (s *ThunderDBStorage) Query(dbID string, query string) (columns []string, t
 }
 
 // Exec implements the Storage abstraction interface.
-func (s *ThunderDBStorage) Exec(dbID string, query string) (err error) {
+func (s *ThunderDBStorage) Exec(dbID string, query string, args ...interface{}) (affectedRows int64, lastInsertID int64, err error) {
 	var conn *sql.DB
 	if conn, err = s.getConn(dbID); err != nil {
 		return
 	}
 	defer conn.Close()
 
-	_, err = conn.Exec(query)
+	var result sql.Result
+	if result, err = conn.Exec(query, args...); err != nil {
+		return
+	}
+
+	if affectedRows, err = result.RowsAffected(); err != nil {
+		return
+	}
+	lastInsertID, err = result.LastInsertId()
+
+	return
+}
+
+// ExecTx implements the Storage abstraction interface.
+func (s *ThunderDBStorage) ExecTx(dbID string, queries []Query) (affectedRows []int64, err error) {
+	var conn *sql.DB
+	if conn, err = s.getConn(dbID); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// queries are buffered by driver and sent in a single request on commit
+	var tx *sql.Tx
+	if tx, err = conn.Begin(); err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// results are available after commit
+	results := make([]sql.Result, 0, len(queries))
+
+	for _, q := range queries {
+		var result sql.Result
+		if result, err = tx.Exec(q.Pattern, q.Args...); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	affectedRows = make([]int64, len(results))
+
+	for i, result := range results {
+		if affectedRows[i], err = result.RowsAffected(); err != nil {
+			return nil, err
+		}
+	}
 
 	return
 }
@@ -107,6 +166,7 @@ func (s *ThunderDBStorage) Exec(dbID string, query string) (err error) {
 func (s *ThunderDBStorage) getConn(dbID string) (db *sql.DB, err error) {
 	cfg := client.NewConfig()
 	cfg.DatabaseID = dbID
+	cfg.Identity = s.identity
 
 	return sql.Open("covenantsql", cfg.FormatDSN())
 }
@@ -74,7 +74,7 @@ func (s *SQLite3Storage) Drop(dbID string) (err error) {
 }
 
 // Query implements the Storage abstraction interface.
-func (s *SQLite3Storage) Query(dbID string, query string) (columns []string, types []string, result [][]interface{}, err error) {
+func (s *SQLite3Storage) Query(dbID string, query string, args ...interface{}) (columns []string, types []string, result [][]interface{}, err error) {
 	var conn *sql.DB
 	if conn, err = s.getConn(dbID, true); err != nil {
 		return
@@ -88,7 +88,7 @@ func (s *SQLite3Storage) Query(dbID string, query string) (columns []string, typ
 	defer tx.Rollback()
 
 	var rows *sql.Rows
-	if rows, err = tx.Query(query); err != nil {
+	if rows, err = tx.Query(query, args...); err != nil {
 		return
 	}
 	defer rows.Close()
@@ -116,14 +116,53 @@ func (s *SQLite3Storage) Query(dbID string, query string) (columns []string, typ
 }
 
 // Exec implements the Storage abstraction interface.
-func (s *SQLite3Storage) Exec(dbID string, query string) (err error) {
+func (s *SQLite3Storage) Exec(dbID string, query string, args ...interface{}) (affectedRows int64, lastInsertID int64, err error) {
 	var conn *sql.DB
 	if conn, err = s.getConn(dbID, false); err != nil {
 		return
 	}
 	defer conn.Close()
 
-	_, err = conn.Exec(query)
+	var result sql.Result
+	if result, err = conn.Exec(query, args...); err != nil {
+		return
+	}
+
+	affectedRows, _ = result.RowsAffected()
+	lastInsertID, _ = result.LastInsertId()
+
+	return
+}
+
+// ExecTx implements the Storage abstraction interface.
+func (s *SQLite3Storage) ExecTx(dbID string, queries []Query) (affectedRows []int64, err error) {
+	var conn *sql.DB
+	if conn, err = s.getConn(dbID, false); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var tx *sql.Tx
+	if tx, err = conn.Begin(); err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	affectedRows = make([]int64, 0, len(queries))
+
+	for _, q := range queries {
+		var result sql.Result
+		if result, err = tx.Exec(q.Pattern, q.Args...); err != nil {
+			return nil, err
+		}
+
+		affected, _ := result.RowsAffected()
+		affectedRows = append(affectedRows, affected)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
 
 	return
 }
@@ -21,6 +21,12 @@ import (
 	"io"
 )
 
+// Query defines a query pattern with positional or named (sql.NamedArg) arguments.
+type Query struct {
+	Pattern string
+	Args    []interface{}
+}
+
 // Storage defines the storage abstraction layer interface.
 type Storage interface {
 	// Create operation.
@@ -28,9 +34,18 @@ type Storage interface {
 	// Drop operation.
 	Drop(dbID string) (err error)
 	// Query for result.
-	Query(dbID string, query string) (columns []string, types []string, rows [][]interface{}, err error)
+	Query(dbID string, query string, args ...interface{}) (columns []string, types []string, rows [][]interface{}, err error)
 	// Exec for update.
-	Exec(dbID string, query string) (err error)
+	Exec(dbID string, query string, args ...interface{}) (affectedRows int64, lastInsertID int64, err error)
+	// ExecTx executes queries in one transaction.
+	ExecTx(dbID string, queries []Query) (affectedRows []int64, err error)
+}
+
+// DelegatedStorage defines storage which could sign queries with a delegated client identity.
+type DelegatedStorage interface {
+	Storage
+	// WithIdentity returns storage handler signing queries with the named client identity.
+	WithIdentity(identity string) Storage
 }
 
 // golang does trick convert, use rowScanner to return the original result type in sqlite3 driver
@@ -137,6 +137,13 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 		return
 	}
 
+	// relay for the miners behind NAT
+	log.Infof("register relay service rpc")
+	if err = rpc.NewRelayService().RegisterTo(server); err != nil {
+		log.Errorf("register relay service failed: %v", err)
+		return
+	}
+
 	// init block producer database service
 	log.Infof("register block producer database service rpc")
 	var dbService *bp.DBService
@@ -168,6 +175,16 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 	chain.Start()
 	defer chain.Stop()
 
+	// charge database deposit on creation and suspend the database in arrears
+	if conf.GConf.BP.DepositPeriod > 0 {
+		dbService.Chain = chain
+		dbService.DepositPeriod = conf.GConf.BP.DepositPeriod
+
+		stopStateCh := make(chan struct{})
+		defer close(stopStateCh)
+		go dbService.MonitorStates(bp.DefaultStateCheckInterval, stopStateCh)
+	}
+
 	log.Info(conf.StartSucceedMessage)
 	//go periodicPingBlockProducer()
 
@@ -28,6 +28,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 )
 
 const logo = `
@@ -121,6 +122,12 @@ func main() {
 	utils.StartProfile(cpuProfile, memProfile)
 	defer utils.StopProfile()
 
+	// init tracing, if trace exporter is not configured, nothing will be done
+	if err := trace.Start(name, string(conf.GConf.ThisNodeID), conf.GConf.Trace); err != nil {
+		log.Fatalf("start tracing failed: %v", err)
+	}
+	defer trace.Stop()
+
 	if clientMode {
 		if err := runClient(conf.GConf.ThisNodeID); err != nil {
 			log.Fatalf("run client failed: %v", err.Error())
@@ -0,0 +1,311 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/gorilla/mux"
+)
+
+var (
+	apiTimeout = time.Second * 10
+
+	// defaultTxLimit defines the default page size of the transaction list
+	defaultTxLimit = 20
+)
+
+func sendResponse(code int, success bool, msg interface{}, data interface{}, rw http.ResponseWriter) {
+	msgStr := "ok"
+	if msg != nil {
+		msgStr = fmt.Sprint(msg)
+	}
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":  msgStr,
+		"success": success,
+		"data":    data,
+	})
+}
+
+func errorCode(err error) int {
+	if err == ErrNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+type explorerAPI struct {
+	service *Service
+}
+
+func (a *explorerAPI) GetHighestBlock(rw http.ResponseWriter, r *http.Request) {
+	height, block, err := a.service.getHighestBlock()
+	if err != nil {
+		sendResponse(errorCode(err), false, err, nil, rw)
+		return
+	}
+
+	sendResponse(200, true, "", a.formatBlock(height, block), rw)
+}
+
+func (a *explorerAPI) GetBlockByHeight(rw http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseUint(mux.Vars(r)["height"], 10, 32)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	block, err := a.service.getBlockByHeight(uint32(height))
+	if err != nil {
+		sendResponse(errorCode(err), false, err, nil, rw)
+		return
+	}
+
+	sendResponse(200, true, "", a.formatBlock(uint32(height), block), rw)
+}
+
+func (a *explorerAPI) GetBlock(rw http.ResponseWriter, r *http.Request) {
+	h, err := hash.NewHashFromStr(mux.Vars(r)["hash"])
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	height, block, err := a.service.getBlock(h)
+	if err != nil {
+		sendResponse(errorCode(err), false, err, nil, rw)
+		return
+	}
+
+	sendResponse(200, true, "", a.formatBlock(height, block), rw)
+}
+
+func (a *explorerAPI) GetTx(rw http.ResponseWriter, r *http.Request) {
+	h, err := hash.NewHashFromStr(mux.Vars(r)["hash"])
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	height, tx, err := a.service.getTx(h)
+	if err != nil {
+		sendResponse(errorCode(err), false, err, nil, rw)
+		return
+	}
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"tx": a.formatTx(height, tx),
+	}, rw)
+}
+
+func (a *explorerAPI) GetAddressTxs(rw http.ResponseWriter, r *http.Request) {
+	addr, err := a.getAddress(mux.Vars(r))
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	offset, limit := 0, defaultTxLimit
+	if v := r.FormValue("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			sendResponse(400, false, "invalid offset", nil, rw)
+			return
+		}
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			sendResponse(400, false, "invalid limit", nil, rw)
+			return
+		}
+	}
+
+	heights, txs, err := a.service.getAddressTxs(addr, offset, limit)
+	if err != nil {
+		sendResponse(errorCode(err), false, err, nil, rw)
+		return
+	}
+
+	list := make([]map[string]interface{}, 0, len(txs))
+	for i, tx := range txs {
+		list = append(list, a.formatTx(int64(heights[i]), tx))
+	}
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"address": addr.String(),
+		"txs":     list,
+	}, rw)
+}
+
+func (a *explorerAPI) GetAccount(rw http.ResponseWriter, r *http.Request) {
+	addr, err := a.getAddress(mux.Vars(r))
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	account, err := a.service.getAccount(addr)
+	if err != nil {
+		sendResponse(errorCode(err), false, err, nil, rw)
+		return
+	}
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"account": map[string]interface{}{
+			"address":          account.Address.String(),
+			"stable_balance":   account.StableCoinBalance,
+			"covenant_balance": account.CovenantCoinBalance,
+			"databases":        account.Databases,
+		},
+	}, rw)
+}
+
+func (a *explorerAPI) formatBlock(height uint32, b *pt.Block) map[string]interface{} {
+	txs := make([]map[string]interface{}, 0, len(b.TxBillings)+len(b.Transactions))
+
+	for _, tx := range b.TxBillings {
+		txs = append(txs, a.formatTxSummary(tx))
+	}
+	for _, tx := range b.Transactions {
+		txs = append(txs, a.formatTxSummary(tx))
+	}
+
+	return map[string]interface{}{
+		"block": map[string]interface{}{
+			"height":      height,
+			"hash":        b.SignedHeader.BlockHash.String(),
+			"parent_hash": b.SignedHeader.ParentHash.String(),
+			"merkle_root": b.SignedHeader.MerkleRoot.String(),
+			"timestamp":   a.formatTime(b.Timestamp()),
+			"version":     b.SignedHeader.Version,
+			"producer":    a.formatAddress(b.Producer()),
+			"txs":         txs,
+		},
+	}
+}
+
+func (a *explorerAPI) formatTxSummary(tx pi.Transaction) map[string]interface{} {
+	return map[string]interface{}{
+		"hash": tx.GetHash().String(),
+		"type": tx.GetTransactionType().String(),
+	}
+}
+
+func (a *explorerAPI) formatTx(height int64, tx pi.Transaction) map[string]interface{} {
+	res := a.formatTxSummary(tx)
+	res["height"] = height
+	res["account"] = a.formatAddress(tx.GetAccountAddress())
+	res["nonce"] = tx.GetAccountNonce()
+
+	switch t := tx.(type) {
+	case *pt.Transfer:
+		res["sender"] = a.formatAddress(t.Sender)
+		res["receiver"] = a.formatAddress(t.Receiver)
+		res["amount"] = t.Amount
+	case *pt.TxBilling:
+		receivers := make([]string, 0, len(t.TxContent.Receivers))
+		for _, v := range t.TxContent.Receivers {
+			if v != nil {
+				receivers = append(receivers, a.formatAddress(*v))
+			}
+		}
+		res["database"] = t.TxContent.BillingRequest.Header.DatabaseID
+		res["sequence"] = t.TxContent.SequenceID
+		res["receivers"] = receivers
+		res["fees"] = t.TxContent.Fees
+		res["rewards"] = t.TxContent.Rewards
+	case *pt.BaseAccount:
+		res["stable_balance"] = t.StableCoinBalance
+		res["covenant_balance"] = t.CovenantCoinBalance
+	case *pt.CreateDatabase:
+		res["owner"] = a.formatAddress(t.Owner)
+		res["database"] = t.DatabaseID
+		res["deposit"] = t.Deposit
+	case *pt.DropDatabase:
+		res["database"] = t.DatabaseID
+	case *pt.TopUp:
+		res["database"] = t.DatabaseID
+		res["amount"] = t.Amount
+	}
+
+	return res
+}
+
+func (a *explorerAPI) formatAddress(addr proto.AccountAddress) string {
+	return addr.String()
+}
+
+func (a *explorerAPI) formatTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e6
+}
+
+func (a *explorerAPI) getAddress(vars map[string]string) (addr proto.AccountAddress, err error) {
+	var h *hash.Hash
+	if h, err = hash.NewHashFromStr(vars["address"]); err != nil {
+		return
+	}
+	addr = proto.AccountAddress(*h)
+	return
+}
+
+func startAPI(service *Service, listenAddr string) (server *http.Server, err error) {
+	router := mux.NewRouter()
+	router.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		sendResponse(http.StatusOK, true, nil, nil, rw)
+	}).Methods("GET")
+
+	api := &explorerAPI{
+		service: service,
+	}
+	v1Router := router.PathPrefix("/v1").Subrouter()
+	v1Router.HandleFunc("/head", api.GetHighestBlock).Methods("GET")
+	v1Router.HandleFunc("/height/{height:[0-9]+}", api.GetBlockByHeight).Methods("GET")
+	v1Router.HandleFunc("/block/{hash}", api.GetBlock).Methods("GET")
+	v1Router.HandleFunc("/tx/{hash}", api.GetTx).Methods("GET")
+	v1Router.HandleFunc("/address/{address}/txs", api.GetAddressTxs).Methods("GET")
+	v1Router.HandleFunc("/account/{address}", api.GetAccount).Methods("GET")
+
+	server = &http.Server{
+		Addr:         listenAddr,
+		WriteTimeout: apiTimeout,
+		ReadTimeout:  apiTimeout,
+		IdleTimeout:  apiTimeout,
+		Handler:      router,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("start api server failed: %v", err)
+		}
+	}()
+
+	return server, err
+}
+
+func stopAPI(server *http.Server) (err error) {
+	return server.Shutdown(context.Background())
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	bp "github.com/CovenantSQL/CovenantSQL/blockproducer"
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+var (
+	version = "unknown"
+	commit  = "unknown"
+	branch  = "unknown"
+)
+
+var (
+	// config
+	configFile   string
+	listenAddr   string
+	syncInterval time.Duration
+)
+
+func init() {
+	flag.StringVar(&configFile, "config", "./config.yaml", "Config file path")
+	flag.StringVar(&listenAddr, "listen", "127.0.0.1:4665", "listen address for http explorer api")
+	flag.DurationVar(&syncInterval, "interval", 10*time.Second, "interval to fetch new blocks from block producer")
+}
+
+func main() {
+	// set random
+	rand.Seed(time.Now().UnixNano())
+	flag.Parse()
+
+	var err error
+	conf.GConf, err = conf.LoadConfig(configFile)
+	if err != nil {
+		log.Fatalf("load config from %s failed: %s", configFile, err)
+	}
+
+	kms.InitBP()
+
+	// start rpc
+	var server *rpc.Server
+	if server, err = initNode(); err != nil {
+		log.Fatalf("init node failed: %v", err)
+	}
+
+	var producers []proto.AccountAddress
+	if producers, err = getProducers(); err != nil {
+		log.Fatalf("load block producers failed: %v", err)
+	}
+
+	// open index database
+	var service *Service
+	if service, err = NewService(
+		filepath.Join(conf.GConf.WorkingRoot, dbFileName), syncInterval, producers,
+	); err != nil {
+		log.Fatalf("init explorer service failed: %v", err)
+	}
+
+	// receive new blocks advised by block producer
+	if err = server.RegisterService(bp.MainChainRPCName, service); err != nil {
+		log.Fatalf("register explorer service failed: %v", err)
+	}
+	go server.Serve()
+
+	// start explorer api
+	httpServer, err := startAPI(service, listenAddr)
+	if err != nil {
+		log.Fatalf("start explorer api failed: %v", err)
+	}
+
+	// register node
+	if err = registerNode(); err != nil {
+		log.Fatalf("register node failed: %v", err)
+	}
+
+	// start following main chain
+	service.start()
+
+	log.Info("explorer started")
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(
+		signalCh,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+	)
+	signal.Ignore(syscall.SIGHUP, syscall.SIGTTIN, syscall.SIGTTOU)
+
+	<-signalCh
+
+	// stop explorer api
+	if err = stopAPI(httpServer); err != nil {
+		log.Fatalf("stop explorer api failed: %v", err)
+	}
+
+	// stop following main chain
+	if err = service.stop(); err != nil {
+		log.Fatalf("stop service failed: %v", err)
+	}
+
+	server.Listener.Close()
+	server.Stop()
+
+	log.Info("explorer stopped")
+}
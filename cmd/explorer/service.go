@@ -0,0 +1,528 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bp "github.com/CovenantSQL/CovenantSQL/blockproducer"
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/coreos/bbolt"
+)
+
+const (
+	dbFileName = "explorer.db"
+)
+
+// Bucket stores main chain block/transaction information as follows
+/*
+[root]
+  |
+  |--[block]
+  |    |---> [height] => block
+  |     \--> [height] => block
+  |
+  |--[block-hash]
+  |    |---> [hash] => height
+  |     \--> [hash] => height
+  |
+  |--[tx]
+  |    |---> [hash] => height+type+tx
+  |     \--> [hash] => height+type+tx
+  |
+   \-> [address]
+         \---> [`address`]
+                  |---> [height+index] => hash
+                   \--> [height+index] => hash
+*/
+
+var (
+	// ErrStopped defines error on explorer service has already stopped
+	ErrStopped = errors.New("explorer service has stopped")
+	// ErrNotFound defines error on fail to found specified resource
+	ErrNotFound = errors.New("resource not found")
+	// ErrParentNotMatch defines error on block parent mismatches the indexed chain
+	ErrParentNotMatch = errors.New("block parent does not match the indexed chain")
+
+	// bolt db buckets
+	blockBucket     = []byte("block")
+	blockHashBucket = []byte("block-hash")
+	txBucket        = []byte("tx")
+	addressBucket   = []byte("address")
+
+	fetchBlockMethod = fmt.Sprintf("%s.%s", bp.MainChainRPCName, "FetchBlock")
+)
+
+// Service defines the main chain explorer service structure, it follows the main chain blocks from
+// block producer and indexes the blocks, transactions and the accounts involved.
+type Service struct {
+	lock     sync.Mutex
+	db       *bolt.DB
+	caller   *rpc.Caller
+	interval time.Duration
+	syncCh   chan struct{}
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+	stopped  int32
+
+	// state indexes the account states by replaying the indexed blocks
+	state *bp.StateIndex
+}
+
+// NewService creates new explorer service with the index database file, the accounts of block
+// producers are used to replay the database transactions issued by them.
+func NewService(
+	dbFile string, interval time.Duration, producers []proto.AccountAddress) (service *Service, err error,
+) {
+	db, err := bolt.Open(dbFile, 0600, nil)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			db.Close()
+		}
+	}()
+
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		if _, err = tx.CreateBucketIfNotExists(blockBucket); err != nil {
+			return
+		}
+		if _, err = tx.CreateBucketIfNotExists(blockHashBucket); err != nil {
+			return
+		}
+		if _, err = tx.CreateBucketIfNotExists(txBucket); err != nil {
+			return
+		}
+		_, err = tx.CreateBucketIfNotExists(addressBucket)
+		return
+	}); err != nil {
+		return
+	}
+
+	service = &Service{
+		db:       db,
+		caller:   rpc.NewCaller(),
+		interval: interval,
+		syncCh:   make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		state:    bp.NewStateIndex(producers),
+	}
+
+	// rebuild the account states from the indexed blocks
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(blockBucket).ForEach(func(k, v []byte) (err error) {
+			var b = &pt.Block{}
+			if err = b.Deserialize(v); err != nil {
+				return
+			}
+			service.state.ApplyBlock(b)
+			return
+		})
+	})
+
+	return
+}
+
+func uint32ToBytes(h uint32) (data []byte) {
+	data = make([]byte, 4)
+	binary.BigEndian.PutUint32(data, h)
+	return
+}
+
+func bytesToUint32(data []byte) uint32 {
+	return binary.BigEndian.Uint32(data)
+}
+
+// AdviseNewBlock handles new block advised by block producer.
+func (s *Service) AdviseNewBlock(req *bp.AdviseNewBlockReq, resp *bp.AdviseNewBlockResp) (err error) {
+	if atomic.LoadInt32(&s.stopped) == 1 {
+		return ErrStopped
+	}
+
+	if req.Block == nil {
+		log.Infof("received empty block from node %v", req.GetNodeID().String())
+		return
+	}
+
+	var parent uint32
+	if parent, err = s.getBlockHeight(&req.Block.SignedHeader.ParentHash); err == ErrNotFound {
+		// missing ancestors, catch up by fetching
+		s.triggerSync()
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	return s.addBlock(parent+1, req.Block)
+}
+
+func (s *Service) start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *Service) stop() (err error) {
+	if !atomic.CompareAndSwapInt32(&s.stopped, 0, 1) {
+		return ErrStopped
+	}
+
+	close(s.stopCh)
+	s.wg.Wait()
+
+	return s.db.Close()
+}
+
+func (s *Service) triggerSync() {
+	select {
+	case s.syncCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Service) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sync()
+
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		case <-s.syncCh:
+		}
+	}
+}
+
+// sync fetches blocks from block producer until the head is reached.
+func (s *Service) sync() {
+	for atomic.LoadInt32(&s.stopped) == 0 {
+		var (
+			next uint32
+			b    *pt.Block
+			err  error
+		)
+		if next, err = s.nextHeight(); err != nil {
+			log.WithError(err).Error("get next block height failed")
+			return
+		}
+		if b, err = s.fetchBlock(next); err != nil {
+			if err.Error() != bp.ErrNoSuchBlock.Error() {
+				log.WithField("height", next).WithError(err).Warning("fetch block failed")
+			}
+			return
+		}
+		if err = s.addBlock(next, b); err != nil {
+			log.WithField("height", next).WithError(err).Warning("add block failed")
+			return
+		}
+	}
+}
+
+func (s *Service) fetchBlock(height uint32) (b *pt.Block, err error) {
+	var (
+		curBP proto.NodeID
+		req   = &bp.FetchBlockReq{Height: height}
+		resp  = &bp.FetchBlockResp{}
+	)
+	if curBP, err = rpc.GetCurrentBP(); err != nil {
+		return
+	}
+	if err = s.caller.CallNode(curBP, fetchBlockMethod, req, resp); err != nil {
+		return
+	}
+	if resp.Block == nil {
+		err = ErrNotFound
+		return
+	}
+	b = resp.Block
+	return
+}
+
+func (s *Service) requestBP(method route.RemoteFunc, request interface{}, response interface{}) (err error) {
+	var curBP proto.NodeID
+	if curBP, err = rpc.GetCurrentBP(); err != nil {
+		return
+	}
+	return s.caller.CallNode(curBP, method.String(), request, response)
+}
+
+func (s *Service) addBlock(height uint32, b *pt.Block) (err error) {
+	if err = b.Verify(); err != nil {
+		return
+	}
+
+	var (
+		enc []byte
+		txs = make([]pi.Transaction, 0, len(b.TxBillings)+len(b.Transactions))
+	)
+	if enc, err = b.Serialize(); err != nil {
+		return
+	}
+	for _, v := range b.TxBillings {
+		txs = append(txs, v)
+	}
+	txs = append(txs, b.Transactions...)
+
+	log.WithFields(log.Fields{
+		"height":   height,
+		"producer": b.Producer(),
+		"txs":      len(txs),
+	}).Debugf("add new block %v -> %v", b.SignedHeader.BlockHash, b.SignedHeader.ParentHash)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The involved accounts should be computed before the block is applied
+	var (
+		involved = make([][]proto.AccountAddress, len(txs))
+		indexed  bool
+	)
+	for i, v := range txs {
+		involved[i] = s.state.InvolvedAccounts(v)
+	}
+	defer func() {
+		if err == nil && indexed {
+			s.state.ApplyBlock(b)
+		}
+	}()
+
+	return s.db.Update(func(tx *bolt.Tx) (err error) {
+		var (
+			bb  = tx.Bucket(blockBucket)
+			hb  = tx.Bucket(blockHashBucket)
+			key = uint32ToBytes(height)
+		)
+		if h := hb.Get(b.SignedHeader.BlockHash[:]); h != nil {
+			if bytesToUint32(h) == height {
+				// already indexed
+				return
+			}
+			return ErrParentNotMatch
+		}
+		if bb.Get(key) != nil {
+			return ErrParentNotMatch
+		}
+		if height > 0 {
+			if h := hb.Get(b.SignedHeader.ParentHash[:]); h == nil || bytesToUint32(h) != height-1 {
+				return ErrParentNotMatch
+			}
+		}
+		if err = bb.Put(key, enc); err != nil {
+			return
+		}
+		if err = hb.Put(b.SignedHeader.BlockHash[:], key); err != nil {
+			return
+		}
+
+		var (
+			tb = tx.Bucket(txBucket)
+			ab = tx.Bucket(addressBucket)
+		)
+		for i, v := range txs {
+			var (
+				h     = v.GetHash()
+				txEnc []byte
+				bk    *bolt.Bucket
+			)
+			if txEnc, err = v.Serialize(); err != nil {
+				return
+			}
+			val := append(append(uint32ToBytes(height), v.GetTransactionType().Bytes()...), txEnc...)
+			if err = tb.Put(h[:], val); err != nil {
+				return
+			}
+			pos := append(uint32ToBytes(height), uint32ToBytes(uint32(i))...)
+			for _, addr := range involved[i] {
+				if bk, err = ab.CreateBucketIfNotExists(addr[:]); err != nil {
+					return
+				}
+				if err = bk.Put(pos, h[:]); err != nil {
+					return
+				}
+			}
+		}
+		indexed = true
+		return
+	})
+}
+
+func (s *Service) nextHeight() (height uint32, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if last, _ := tx.Bucket(blockBucket).Cursor().Last(); last != nil {
+			height = bytesToUint32(last) + 1
+		}
+		return nil
+	})
+	return
+}
+
+func (s *Service) getBlockHeight(h *hash.Hash) (height uint32, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(blockHashBucket).Get(h[:])
+		if v == nil {
+			return ErrNotFound
+		}
+		height = bytesToUint32(v)
+		return nil
+	})
+	return
+}
+
+func loadBlock(tx *bolt.Tx, key []byte) (b *pt.Block, err error) {
+	v := tx.Bucket(blockBucket).Get(key)
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	b = &pt.Block{}
+	err = b.Deserialize(v)
+	return
+}
+
+func (s *Service) getHighestBlock() (height uint32, b *pt.Block, err error) {
+	err = s.db.View(func(tx *bolt.Tx) (err error) {
+		last, _ := tx.Bucket(blockBucket).Cursor().Last()
+		if last == nil {
+			return ErrNotFound
+		}
+		height = bytesToUint32(last)
+		b, err = loadBlock(tx, last)
+		return
+	})
+	return
+}
+
+func (s *Service) getBlockByHeight(height uint32) (b *pt.Block, err error) {
+	err = s.db.View(func(tx *bolt.Tx) (err error) {
+		b, err = loadBlock(tx, uint32ToBytes(height))
+		return
+	})
+	return
+}
+
+func (s *Service) getBlock(h *hash.Hash) (height uint32, b *pt.Block, err error) {
+	if height, err = s.getBlockHeight(h); err != nil {
+		return
+	}
+	b, err = s.getBlockByHeight(height)
+	return
+}
+
+// getTx returns the transaction of hash h and the height of the block it was packed in. The
+// transactions not packed yet are looked up from block producer with a height of -1.
+func (s *Service) getTx(h *hash.Hash) (height int64, tx pi.Transaction, err error) {
+	err = s.db.View(func(btx *bolt.Tx) (err error) {
+		v := btx.Bucket(txBucket).Get(h[:])
+		if v == nil {
+			return ErrNotFound
+		}
+		height = int64(bytesToUint32(v[:4]))
+		tx, err = pt.DecodeTransaction(pi.FromBytes(v[4:8]), v[8:])
+		return
+	})
+	if err != ErrNotFound {
+		return
+	}
+
+	req := &bp.QueryTxByHashReq{Hash: *h}
+	resp := &bp.QueryTxByHashResp{}
+	if err = s.requestBP(route.MCCQueryTxByHash, req, resp); err != nil {
+		return
+	}
+	height = -1
+	tx, err = resp.Record.Transaction()
+	return
+}
+
+// getAddressTxs returns the packed transactions involving addr, the latest first.
+func (s *Service) getAddressTxs(
+	addr proto.AccountAddress, offset, limit int) (heights []uint32, txs []pi.Transaction, err error,
+) {
+	err = s.db.View(func(btx *bolt.Tx) (err error) {
+		bk := btx.Bucket(addressBucket).Bucket(addr[:])
+		if bk == nil {
+			return
+		}
+		var (
+			tb  = btx.Bucket(txBucket)
+			cur = bk.Cursor()
+		)
+		for k, h := cur.Last(); k != nil; k, h = cur.Prev() {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if limit > 0 && len(txs) >= limit {
+				break
+			}
+			v := tb.Get(h)
+			if v == nil {
+				return ErrNotFound
+			}
+			var tx pi.Transaction
+			if tx, err = pt.DecodeTransaction(pi.FromBytes(v[4:8]), v[8:]); err != nil {
+				return
+			}
+			heights = append(heights, bytesToUint32(v[:4]))
+			txs = append(txs, tx)
+		}
+		return
+	})
+	return
+}
+
+// Account defines the account state indexed from the main chain.
+type Account struct {
+	Address             proto.AccountAddress
+	StableCoinBalance   uint64
+	CovenantCoinBalance uint64
+	Databases           []proto.DatabaseID
+}
+
+func (s *Service) getAccount(addr proto.AccountAddress) (account *Account, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	o, loaded := s.state.LoadAccount(addr)
+	if !loaded {
+		err = ErrNotFound
+		return
+	}
+	account = &Account{
+		Address:             addr,
+		StableCoinBalance:   o.StableCoinBalance,
+		CovenantCoinBalance: o.CovenantCoinBalance,
+		Databases:           s.state.LoadDatabases(addr),
+	}
+	return
+}
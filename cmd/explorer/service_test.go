@@ -0,0 +1,179 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bp "github.com/CovenantSQL/CovenantSQL/blockproducer"
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	pt "github.com/CovenantSQL/CovenantSQL/blockproducer/types"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func createBlock(
+	priv *asymmetric.PrivateKey, parent hash.Hash, txs ...pi.Transaction) (b *pt.Block, err error,
+) {
+	b = &pt.Block{
+		SignedHeader: pt.SignedHeader{
+			Header: pt.Header{
+				Version:    0x01000000,
+				ParentHash: parent,
+				Timestamp:  time.Now().UTC(),
+			},
+		},
+		Transactions: txs,
+	}
+	err = b.PackAndSignBlock(priv)
+	return
+}
+
+func TestService(t *testing.T) {
+	Convey("Given an explorer service with some indexed blocks", t, func() {
+		dir, err := ioutil.TempDir("", "explorer")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		service, err := NewService(filepath.Join(dir, dbFileName), time.Second, nil)
+		So(err, ShouldBeNil)
+		defer service.db.Close()
+
+		priv, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+
+		var (
+			addr1 = proto.AccountAddress{0x1}
+			addr2 = proto.AccountAddress{0x2}
+			addr3 = proto.AccountAddress{0x3}
+			t1    = &pt.Transfer{TransferHeader: pt.TransferHeader{Sender: addr1, Receiver: addr2, Amount: 1}}
+			t2    = &pt.Transfer{TransferHeader: pt.TransferHeader{Sender: addr2, Receiver: addr3, Amount: 2}}
+		)
+		So(t1.Sign(priv), ShouldBeNil)
+		So(t2.Sign(priv), ShouldBeNil)
+
+		var (
+			ba1 = &pt.BaseAccount{Account: pt.Account{Address: addr1, StableCoinBalance: 10}}
+			ba2 = &pt.BaseAccount{Account: pt.Account{Address: addr2, StableCoinBalance: 5}}
+		)
+		ba1.AccountHash = hash.Hash{0x1}
+		ba2.AccountHash = hash.Hash{0x2}
+
+		genesis, err := createBlock(priv, hash.Hash{}, ba1, ba2)
+		So(err, ShouldBeNil)
+		b1, err := createBlock(priv, genesis.SignedHeader.BlockHash, t1)
+		So(err, ShouldBeNil)
+		b2, err := createBlock(priv, b1.SignedHeader.BlockHash, t2)
+		So(err, ShouldBeNil)
+
+		next, err := service.nextHeight()
+		So(err, ShouldBeNil)
+		So(next, ShouldEqual, 0)
+		So(service.addBlock(0, genesis), ShouldBeNil)
+		So(service.addBlock(1, b1), ShouldBeNil)
+		So(service.AdviseNewBlock(&bp.AdviseNewBlockReq{Block: b2}, &bp.AdviseNewBlockResp{}), ShouldBeNil)
+
+		Convey("Blocks should be found by height and hash", func() {
+			next, err = service.nextHeight()
+			So(err, ShouldBeNil)
+			So(next, ShouldEqual, 3)
+
+			height, b, err := service.getHighestBlock()
+			So(err, ShouldBeNil)
+			So(height, ShouldEqual, 2)
+			So(b.SignedHeader.BlockHash, ShouldEqual, b2.SignedHeader.BlockHash)
+
+			b, err = service.getBlockByHeight(1)
+			So(err, ShouldBeNil)
+			So(b.SignedHeader.BlockHash, ShouldEqual, b1.SignedHeader.BlockHash)
+			So(len(b.Transactions), ShouldEqual, 1)
+			So(b.Transactions[0].GetHash(), ShouldEqual, t1.GetHash())
+			So(b.Transactions[0].GetTransactionType(), ShouldEqual, pi.TransactionTypeTransfer)
+
+			height, b, err = service.getBlock(&b1.SignedHeader.BlockHash)
+			So(err, ShouldBeNil)
+			So(height, ShouldEqual, 1)
+
+			_, err = service.getBlockByHeight(3)
+			So(err, ShouldEqual, ErrNotFound)
+			_, _, err = service.getBlock(&hash.Hash{})
+			So(err, ShouldEqual, ErrNotFound)
+		})
+		Convey("Transactions should be found by hash and address", func() {
+			h := t2.GetHash()
+			height, tx, err := service.getTx(&h)
+			So(err, ShouldBeNil)
+			So(height, ShouldEqual, 2)
+			So(tx.GetHash(), ShouldEqual, t2.GetHash())
+
+			heights, txs, err := service.getAddressTxs(addr2, 0, 0)
+			So(err, ShouldBeNil)
+			So(heights, ShouldResemble, []uint32{2, 1, 0})
+			So(len(txs), ShouldEqual, 3)
+			So(txs[0].GetHash(), ShouldEqual, t2.GetHash())
+			So(txs[1].GetHash(), ShouldEqual, t1.GetHash())
+
+			heights, txs, err = service.getAddressTxs(addr2, 1, 1)
+			So(err, ShouldBeNil)
+			So(heights, ShouldResemble, []uint32{1})
+
+			_, txs, err = service.getAddressTxs(proto.AccountAddress{0x4}, 0, 0)
+			So(err, ShouldBeNil)
+			So(txs, ShouldBeEmpty)
+		})
+		Convey("Account states should be indexed from the replayed blocks", func() {
+			var check = func(service *Service) {
+				account, err := service.getAccount(addr1)
+				So(err, ShouldBeNil)
+				So(account.StableCoinBalance, ShouldEqual, 9)
+				account, err = service.getAccount(addr2)
+				So(err, ShouldBeNil)
+				So(account.StableCoinBalance, ShouldEqual, 4)
+				account, err = service.getAccount(addr3)
+				So(err, ShouldBeNil)
+				So(account.StableCoinBalance, ShouldEqual, 2)
+				So(account.Databases, ShouldBeEmpty)
+				_, err = service.getAccount(proto.AccountAddress{0x4})
+				So(err, ShouldEqual, ErrNotFound)
+			}
+			check(service)
+			Convey("The account states should be rebuilt on reopen", func() {
+				So(service.db.Close(), ShouldBeNil)
+				reopened, err := NewService(filepath.Join(dir, dbFileName), time.Second, nil)
+				So(err, ShouldBeNil)
+				defer reopened.db.Close()
+				check(reopened)
+			})
+		})
+		Convey("Blocks not chained to the index should be rejected", func() {
+			So(service.addBlock(1, b1), ShouldBeNil)
+			So(service.addBlock(2, b1), ShouldEqual, ErrParentNotMatch)
+			fork, err := createBlock(priv, genesis.SignedHeader.BlockHash)
+			So(err, ShouldBeNil)
+			So(service.addBlock(1, fork), ShouldEqual, ErrParentNotMatch)
+			So(service.addBlock(3, fork), ShouldEqual, ErrParentNotMatch)
+			b2.SignedHeader.Timestamp = time.Now()
+			So(service.addBlock(3, b2), ShouldNotBeNil)
+		})
+	})
+}
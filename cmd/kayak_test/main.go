@@ -599,6 +599,13 @@ func createServer(nodeOffset, port int) (service *kt.ETLSTransportService, serve
 		return
 	}
 
+	// test nodes are registered without roles, open the test services to them
+	acl := route.DefaultACL()
+	acl[kayakServiceName+".Call"] = route.RegisteredCaller
+	acl[dbServiceName+".Write"] = route.RegisteredCaller
+	acl[dbServiceName+".Read"] = route.RegisteredCaller
+	server.SetACL(acl)
+
 	listenAddr := fmt.Sprintf(listenAddrPattern, port)
 	privateKeyPath := filepath.Join(fmt.Sprintf(nodeDirPattern, nodeOffset), privateKeyFile)
 	err = server.InitRPCServer(listenAddr, privateKeyPath, []byte(privateKeyMasterKey))
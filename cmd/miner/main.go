@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -34,6 +35,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 	"github.com/CovenantSQL/CovenantSQL/worker"
 )
 
@@ -141,6 +143,12 @@ func main() {
 	utils.StartProfile(cpuProfile, memProfile)
 	defer utils.StopProfile()
 
+	// init tracing, if trace exporter is not configured, nothing will be done
+	if err := trace.Start(name, string(conf.GConf.ThisNodeID), conf.GConf.Trace); err != nil {
+		log.Fatalf("start tracing failed: %v", err)
+	}
+	defer trace.Stop()
+
 	// set generate key pair config
 	conf.GConf.GenerateKeyPair = genKeyPair
 
@@ -155,6 +163,16 @@ func main() {
 		log.Debugf("miner test mode enabled")
 	}
 
+	// register to relay before announcing the node, the relay address is the node address
+	if conf.GConf.Relay != nil && conf.GConf.Relay.Enabled {
+		var relayListener *rpc.RelayListener
+		if relayListener, err = startRelayListener(server); err != nil {
+			log.Fatalf("start relay listener failed: %v", err)
+		}
+		go server.ServeListener(relayListener)
+		defer relayListener.Close()
+	}
+
 	// stop channel for all daemon routines
 	stopCh := make(chan struct{})
 	defer close(stopCh)
@@ -222,6 +240,10 @@ func main() {
 				for _, bpNodeID := range bpNodeIDs {
 					err := rpc.PingBP(localNodeInfo, bpNodeID)
 					if err == nil {
+						// registered to block producer, announce to dht peers
+						if err = rpc.JoinDHT(context.Background()); err != nil {
+							log.WithError(err).Warning("join dht failed")
+						}
 						return
 					}
 				}
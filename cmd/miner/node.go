@@ -23,12 +23,15 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+const dhtServiceName = "DHT"
+
 func initNode() (server *rpc.Server, err error) {
 	var masterKey []byte
 	if !conf.GConf.IsTestMode {
@@ -58,6 +61,66 @@ func initNode() (server *rpc.Server, err error) {
 		return
 	}
 
+	// serve dht lookups by local routing table
+	if err = server.RegisterService(dhtServiceName, route.NewPeerDHTService()); err != nil {
+		log.Errorf("register dht service failed: %v", err)
+		return
+	}
+
+	// serve as a volunteer relay for nodes behind NAT
+	if conf.GConf.Relay != nil && conf.GConf.Relay.Serve {
+		if err = rpc.NewRelayService().RegisterTo(server); err != nil {
+			log.Errorf("register relay service failed: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// startRelayListener registers the node behind NAT to a relay, and announces the relay address
+// as the address of the local node.
+func startRelayListener(server *rpc.Server) (l *rpc.RelayListener, err error) {
+	relays := conf.GConf.Relay.Nodes
+	if len(relays) == 0 {
+		relays = route.GetBPs()
+	}
+	l = rpc.NewRelayListener(server.Listener.Addr().String(), relays)
+	l.OnRelayChange = func(addr string) {
+		// announce the new address to block producers
+		node, err := setLocalNodeAddr(addr)
+		if err == nil {
+			for _, bpNodeID := range route.GetBPs() {
+				if err = rpc.PingBP(node, bpNodeID); err == nil {
+					return
+				}
+			}
+		}
+		log.WithField("addr", addr).WithError(err).Warning("announce relay address failed")
+	}
+	var addr string
+	if addr, err = l.Start(); err != nil {
+		log.Errorf("register to relay failed: %v", err)
+		return
+	}
+	if _, err = setLocalNodeAddr(addr); err != nil {
+		l.Close()
+		return
+	}
+	log.WithField("addr", addr).Info("node is relayed")
+	return
+}
+
+func setLocalNodeAddr(addr string) (node *proto.Node, err error) {
+	var localNodeID proto.NodeID
+	if localNodeID, err = kms.GetLocalNodeID(); err != nil {
+		return
+	}
+	if node, err = kms.GetNodeInfo(localNodeID); err != nil {
+		return
+	}
+	node.Addr = addr
+	err = kms.SetNode(node)
 	return
 }
 
@@ -29,6 +29,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
@@ -513,11 +514,25 @@ func (s *Service) getUpstream(dbID proto.DatabaseID) (instance *wt.ServiceInstan
 	}
 
 	instance = &resp.Header.InstanceMeta
+	// the miners push blocks and acks to the observer by the miner only rpc methods
+	setPeersRole(instance.Peers)
 	s.upstreamServers.Store(dbID, instance)
 
 	return
 }
 
+// setPeersRole trusts the servers of the peers signed by a block producer as miners, so that they
+// are permitted to advise blocks and acks to the observer.
+func setPeersRole(peers *kayak.Peers) {
+	if peers == nil || peers.Signature == nil || !route.IsBPPublicKey(peers.PubKey) || !peers.Verify() {
+		log.WithField("peers", peers).Warning("peers are not signed by block producer")
+		return
+	}
+	for _, s := range peers.Servers {
+		route.SetNodeRole(s.ID, proto.Miner)
+	}
+}
+
 func (s *Service) getAck(dbID proto.DatabaseID, h *hash.Hash) (ack *wt.SignedAckHeader, err error) {
 	err = s.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(ackBucket).Bucket([]byte(dbID))
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"gopkg.in/yaml.v2"
+)
+
+// Config defines the configurable options for postgresql protocol adapter.
+type Config struct {
+	ListenAddr string `yaml:"ListenAddr"`
+	// optional tls support, enabled if both certificate and private key are provided
+	CertificatePath string      `yaml:"CertificatePath"`
+	PrivateKeyPath  string      `yaml:"PrivateKeyPath"`
+	TLSConfig       *tls.Config `yaml:"-"`
+	// user/password pairs for md5 authentication, empty for trust authentication
+	Users map[string]string `yaml:"Users"`
+	// server version reported to clients
+	ServerVersion string `yaml:"ServerVersion"`
+}
+
+type confWrapper struct {
+	PGAdapter *Config `yaml:"PGAdapter"`
+}
+
+// LoadConfig load the common covenantsql client config again for extra pg adapter config.
+func LoadConfig(configPath string) (config *Config, err error) {
+	var configBytes []byte
+	if configBytes, err = ioutil.ReadFile(configPath); err != nil {
+		log.Errorf("read config file failed: %v", err)
+		return
+	}
+
+	configWrapper := &confWrapper{}
+	if err = yaml.Unmarshal(configBytes, configWrapper); err != nil {
+		log.Errorf("unmarshal config file failed: %v", err)
+		return
+	}
+
+	if configWrapper.PGAdapter == nil {
+		err = ErrInvalidAdapterConfig
+		log.Errorf("could not read pg adapter config: %v", err)
+		return
+	}
+
+	config = configWrapper.PGAdapter
+
+	if config.ListenAddr == "" {
+		err = ErrInvalidAdapterConfig
+		log.Error("ListenAddr is not defined in pg adapter config")
+		return
+	}
+
+	if config.ServerVersion == "" {
+		config.ServerVersion = "9.6.0"
+	}
+
+	if config.CertificatePath != "" && config.PrivateKeyPath != "" {
+		certPath := filepath.Join(conf.GConf.WorkingRoot, config.CertificatePath)
+		privateKeyPath := filepath.Join(conf.GConf.WorkingRoot, config.PrivateKeyPath)
+
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(certPath, privateKeyPath); err != nil {
+			log.Errorf("load pg adapter certificate failed: %v", err)
+			return
+		}
+
+		config.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+
+	if len(config.Users) == 0 {
+		log.Warning("no users defined in pg adapter config, trust authentication is used")
+	}
+
+	return
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "errors"
+
+var (
+	// ErrInvalidAdapterConfig represents invalid pg adapter config.
+	ErrInvalidAdapterConfig = errors.New("invalid pg adapter config")
+	// ErrInvalidMessage represents malformed protocol message from client.
+	ErrInvalidMessage = errors.New("invalid protocol message")
+	// ErrUnsupportedProtocol represents unsupported protocol version.
+	ErrUnsupportedProtocol = errors.New("unsupported protocol version")
+	// ErrUnsupportedFormat represents unsupported binary data format.
+	ErrUnsupportedFormat = errors.New("unsupported data format")
+	// ErrAuthenticationFailed represents the client provides invalid user/password.
+	ErrAuthenticationFailed = errors.New("password authentication failed")
+	// ErrMissingDatabase represents the client does not specify a database in startup message.
+	ErrMissingDatabase = errors.New("database is required")
+	// ErrStatementNotFound represents referencing a non-existent prepared statement.
+	ErrStatementNotFound = errors.New("prepared statement does not exist")
+	// ErrPortalNotFound represents referencing a non-existent portal.
+	ErrPortalNotFound = errors.New("portal does not exist")
+	// ErrNotInTransaction represents commit/rollback without a transaction.
+	ErrNotInTransaction = errors.New("there is no transaction in progress")
+	// ErrTransactionAborted represents commands issued in a failed transaction.
+	ErrTransactionAborted = errors.New("current transaction is aborted, commands ignored until end of transaction block")
+)
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	configFile string
+	password   string
+)
+
+func init() {
+	flag.StringVar(&configFile, "config", "config.yaml", "configuration file for covenantsql")
+	flag.StringVar(&password, "password", "", "master key password for covenantsql")
+}
+
+func main() {
+	flag.Parse()
+
+	// init client
+	var err error
+	if err = client.Init(configFile, []byte(password)); err != nil {
+		log.Errorf("init covenantsql client failed: %v", err)
+		os.Exit(-1)
+		return
+	}
+
+	// load pg adapter config from same config file
+	var cfg *Config
+	if cfg, err = LoadConfig(configFile); err != nil {
+		log.Errorf("read pg adapter config failed: %v", err)
+		os.Exit(-1)
+		return
+	}
+
+	server := NewServer(cfg)
+	if err = server.Serve(); err != nil {
+		log.Errorf("start pg adapter failed: %v", err)
+		os.Exit(-1)
+		return
+	}
+
+	log.Infof("started pg adapter on %v", cfg.ListenAddr)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, unix.SIGTERM)
+
+	<-stop
+
+	server.Shutdown()
+	log.Info("stopped pg adapter")
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// protocolVersion defines the postgresql v3.0 protocol version number.
+	protocolVersion = 196608
+	// sslRequestCode defines the magic version number of SSLRequest message.
+	sslRequestCode = 80877103
+	// cancelRequestCode defines the magic version number of CancelRequest message.
+	cancelRequestCode = 80877102
+	// maxMessageSize defines the maximum accepted frontend message size.
+	maxMessageSize = 64 * 1024 * 1024
+)
+
+// frontend message types.
+const (
+	msgQuery     byte = 'Q'
+	msgParse     byte = 'P'
+	msgBind      byte = 'B'
+	msgDescribe  byte = 'D'
+	msgExecute   byte = 'E'
+	msgSync      byte = 'S'
+	msgClose     byte = 'C'
+	msgFlush     byte = 'H'
+	msgTerminate byte = 'X'
+	msgPassword  byte = 'p'
+)
+
+// backend message types.
+const (
+	msgAuthentication       byte = 'R'
+	msgParameterStatus      byte = 'S'
+	msgBackendKeyData       byte = 'K'
+	msgReadyForQuery        byte = 'Z'
+	msgRowDescription       byte = 'T'
+	msgDataRow              byte = 'D'
+	msgCommandComplete      byte = 'C'
+	msgEmptyQueryResponse   byte = 'I'
+	msgErrorResponse        byte = 'E'
+	msgParseComplete        byte = '1'
+	msgBindComplete         byte = '2'
+	msgCloseComplete        byte = '3'
+	msgNoData               byte = 'n'
+	msgParameterDescription byte = 't'
+	msgPortalSuspended      byte = 's'
+)
+
+// authentication request codes.
+const (
+	authOK                = 0
+	authCleartextPassword = 3
+	authMD5Password       = 5
+)
+
+// transaction status indicators used in ReadyForQuery message.
+const (
+	txStatusIdle   byte = 'I'
+	txStatusInTx   byte = 'T'
+	txStatusFailed byte = 'E'
+)
+
+// readStartupMessage reads the untyped startup packet from client.
+func readStartupMessage(r io.Reader) (code uint32, body []byte, err error) {
+	var header [8]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 8 || length > maxMessageSize {
+		err = ErrInvalidMessage
+		return
+	}
+
+	code = binary.BigEndian.Uint32(header[4:])
+	body = make([]byte, length-8)
+	_, err = io.ReadFull(r, body)
+	return
+}
+
+// readMessage reads a typed frontend message.
+func readMessage(r *bufio.Reader) (typ byte, body []byte, err error) {
+	if typ, err = r.ReadByte(); err != nil {
+		return
+	}
+
+	var lenBytes [4]byte
+	if _, err = io.ReadFull(r, lenBytes[:]); err != nil {
+		return
+	}
+
+	length := binary.BigEndian.Uint32(lenBytes[:])
+	if length < 4 || length > maxMessageSize {
+		err = ErrInvalidMessage
+		return
+	}
+
+	body = make([]byte, length-4)
+	_, err = io.ReadFull(r, body)
+	return
+}
+
+// messageReader decodes fields from a frontend message body.
+type messageReader struct {
+	buf []byte
+	err error
+}
+
+func newMessageReader(body []byte) *messageReader {
+	return &messageReader{buf: body}
+}
+
+func (r *messageReader) byte() (b byte) {
+	if r.err != nil {
+		return
+	}
+	if len(r.buf) < 1 {
+		r.err = ErrInvalidMessage
+		return
+	}
+	b = r.buf[0]
+	r.buf = r.buf[1:]
+	return
+}
+
+func (r *messageReader) int16() (v int16) {
+	if r.err != nil {
+		return
+	}
+	if len(r.buf) < 2 {
+		r.err = ErrInvalidMessage
+		return
+	}
+	v = int16(binary.BigEndian.Uint16(r.buf))
+	r.buf = r.buf[2:]
+	return
+}
+
+// count reads an int16 element count, each element takes at least elemSize bytes of the
+// remaining body, so a forged count never allocates more than the message carries.
+func (r *messageReader) count(elemSize int) (n int) {
+	if n = int(r.int16()); r.err != nil {
+		return 0
+	}
+	if n < 0 || n*elemSize > len(r.buf) {
+		r.err = ErrInvalidMessage
+		return 0
+	}
+	return
+}
+
+func (r *messageReader) int32() (v int32) {
+	if r.err != nil {
+		return
+	}
+	if len(r.buf) < 4 {
+		r.err = ErrInvalidMessage
+		return
+	}
+	v = int32(binary.BigEndian.Uint32(r.buf))
+	r.buf = r.buf[4:]
+	return
+}
+
+func (r *messageReader) string() (s string) {
+	if r.err != nil {
+		return
+	}
+	idx := bytes.IndexByte(r.buf, 0)
+	if idx < 0 {
+		r.err = ErrInvalidMessage
+		return
+	}
+	s = string(r.buf[:idx])
+	r.buf = r.buf[idx+1:]
+	return
+}
+
+func (r *messageReader) bytes(n int) (b []byte) {
+	if r.err != nil {
+		return
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = ErrInvalidMessage
+		return
+	}
+	b = append([]byte(nil), r.buf[:n]...)
+	r.buf = r.buf[n:]
+	return
+}
+
+// messageWriter buffers backend messages before flushing them to client.
+type messageWriter struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+func newMessageWriter(w io.Writer) *messageWriter {
+	return &messageWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *messageWriter) start(typ byte) {
+	w.buf = append(w.buf[:0], typ, 0, 0, 0, 0)
+}
+
+func (w *messageWriter) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *messageWriter) int16(v int16) {
+	w.buf = append(w.buf, byte(uint16(v)>>8), byte(v))
+}
+
+func (w *messageWriter) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *messageWriter) string(s string) {
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+func (w *messageWriter) bytes(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+// finish fills the message length and appends message to output buffer.
+func (w *messageWriter) finish() (err error) {
+	binary.BigEndian.PutUint32(w.buf[1:5], uint32(len(w.buf)-1))
+	_, err = w.w.Write(w.buf)
+	return
+}
+
+func (w *messageWriter) flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/xo/usql/drivers"
+	"github.com/xo/usql/stmt"
+)
+
+// statementKind defines the way a statement is processed by adapter.
+type statementKind int
+
+const (
+	// kindEmpty is a statement contains nothing but comments/spaces.
+	kindEmpty statementKind = iota
+	// kindRead is a statement returns rows.
+	kindRead
+	// kindWrite is a statement modifies database.
+	kindWrite
+	// kindBegin starts a transaction.
+	kindBegin
+	// kindCommit commits a transaction.
+	kindCommit
+	// kindRollback rollbacks a transaction.
+	kindRollback
+	// kindSet is a session variable statement, accepted and ignored.
+	kindSet
+)
+
+// statement defines a parsed client statement.
+type statement struct {
+	// original query text
+	query string
+	// query text rewritten to sqlite3 placeholder syntax
+	pattern string
+	// number of positional parameters
+	paramCount int
+	// kind of statement
+	kind statementKind
+	// command tag prefix
+	tag string
+}
+
+// parseStatement classifies query and rewrites the placeholders.
+func parseStatement(query string) (s *statement) {
+	s = &statement{query: query}
+	s.pattern, s.paramCount = rewritePlaceholders(query)
+
+	prefix := stmt.FindPrefix(query)
+	if prefix == "" {
+		s.kind = kindEmpty
+		return
+	}
+
+	words := strings.Split(prefix, " ")
+
+	switch words[0] {
+	case "BEGIN", "START":
+		s.kind, s.tag = kindBegin, "BEGIN"
+		return
+	case "COMMIT", "END":
+		s.kind, s.tag = kindCommit, "COMMIT"
+		return
+	case "ROLLBACK", "ABORT":
+		s.kind, s.tag = kindRollback, "ROLLBACK"
+		return
+	case "SET", "RESET", "DISCARD", "DEALLOCATE":
+		s.kind, s.tag = kindSet, words[0]
+		return
+	}
+
+	var isQuery bool
+	s.tag, isQuery = drivers.QueryExecType(prefix, query)
+	if isQuery {
+		s.kind = kindRead
+	} else {
+		s.kind = kindWrite
+	}
+
+	return
+}
+
+// commandTag builds the CommandComplete tag of statement.
+func (s *statement) commandTag(rows int64) string {
+	switch s.tag {
+	case "SELECT", "UPDATE", "DELETE":
+		return s.tag + " " + strconv.FormatInt(rows, 10)
+	case "INSERT", "REPLACE":
+		return "INSERT 0 " + strconv.FormatInt(rows, 10)
+	default:
+		return s.tag
+	}
+}
+
+// rewritePlaceholders converts postgresql $n placeholders to sqlite3 ?n placeholders,
+// quoted strings, quoted identifiers and comments are left untouched.
+func rewritePlaceholders(query string) (pattern string, paramCount int) {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := skipQuoted(query, i, c)
+			b.WriteString(query[i:end])
+			i = end
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i
+			}
+			b.WriteString(query[i:end])
+			i = end
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 4
+			}
+			b.WriteString(query[i:end])
+			i = end
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			j := i + 1
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+			if n, err := strconv.Atoi(query[i+1 : j]); err == nil && n > paramCount {
+				paramCount = n
+			}
+			b.WriteByte('?')
+			b.WriteString(query[i+1 : j])
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	pattern = b.String()
+	return
+}
+
+// splitStatements splits a simple query string into statements by semicolons.
+func splitStatements(query string) (stmts []string) {
+	start := 0
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i, c)
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(query)
+			}
+		case c == ';':
+			if s := strings.TrimSpace(query[start:i]); s != "" {
+				stmts = append(stmts, s)
+			}
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+
+	if s := strings.TrimSpace(query[start:]); s != "" {
+		stmts = append(stmts, s)
+	}
+
+	return
+}
+
+// skipQuoted returns the index after the quoted section starts at offset.
+func skipQuoted(query string, offset int, quote byte) int {
+	for i := offset + 1; i < len(query); i++ {
+		if query[i] == quote {
+			// doubled quote is an escaped quote
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+
+	return len(query)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
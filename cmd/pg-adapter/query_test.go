@@ -0,0 +1,159 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRewritePlaceholders(t *testing.T) {
+	Convey("placeholders should be rewritten outside quotes and comments", t, func() {
+		pattern, cnt := rewritePlaceholders(
+			`SELECT '$1', "$2", $1 -- $3` + "\n" + `FROM t WHERE a = $2 /* $4 */`)
+		So(cnt, ShouldEqual, 2)
+		So(pattern, ShouldEqual,
+			`SELECT '$1', "$2", ?1 -- $3`+"\n"+`FROM t WHERE a = ?2 /* $4 */`)
+
+		pattern, cnt = rewritePlaceholders("SELECT 'it''s $1'")
+		So(cnt, ShouldEqual, 0)
+		So(pattern, ShouldEqual, "SELECT 'it''s $1'")
+	})
+}
+
+func TestSplitStatements(t *testing.T) {
+	Convey("statements should be split by semicolons outside quotes", t, func() {
+		So(splitStatements(""), ShouldBeEmpty)
+		So(splitStatements(" ; ;"), ShouldBeEmpty)
+		So(splitStatements("SELECT 1; SELECT ';'; -- ;\nSELECT 2"), ShouldResemble, []string{
+			"SELECT 1", "SELECT ';'", "-- ;\nSELECT 2",
+		})
+	})
+}
+
+func TestParseStatement(t *testing.T) {
+	Convey("statements should be classified", t, func() {
+		So(parseStatement("select * from t").kind, ShouldEqual, kindRead)
+		So(parseStatement("PRAGMA table_info(t)").kind, ShouldEqual, kindRead)
+		So(parseStatement("insert into t values($1)").kind, ShouldEqual, kindWrite)
+		So(parseStatement("begin").kind, ShouldEqual, kindBegin)
+		So(parseStatement("COMMIT").kind, ShouldEqual, kindCommit)
+		So(parseStatement("rollback").kind, ShouldEqual, kindRollback)
+		So(parseStatement("SET extra_float_digits = 3").kind, ShouldEqual, kindSet)
+		So(parseStatement(" -- nothing").kind, ShouldEqual, kindEmpty)
+	})
+	Convey("command tags should follow postgresql format", t, func() {
+		So(parseStatement("SELECT 1").commandTag(1), ShouldEqual, "SELECT 1")
+		So(parseStatement("INSERT INTO t VALUES(1)").commandTag(2), ShouldEqual, "INSERT 0 2")
+		So(parseStatement("UPDATE t SET a = 1").commandTag(3), ShouldEqual, "UPDATE 3")
+		So(parseStatement("CREATE TABLE t (a INT)").commandTag(0), ShouldEqual, "CREATE TABLE")
+	})
+}
+
+func TestTypes(t *testing.T) {
+	Convey("declared types should be mapped by sqlite3 affinity", t, func() {
+		So(typeOIDFromDeclType("INTEGER"), ShouldEqual, oidInt8)
+		So(typeOIDFromDeclType("bigint"), ShouldEqual, oidInt8)
+		So(typeOIDFromDeclType("VARCHAR(255)"), ShouldEqual, oidText)
+		So(typeOIDFromDeclType("BLOB"), ShouldEqual, oidBytea)
+		So(typeOIDFromDeclType("DOUBLE PRECISION"), ShouldEqual, oidFloat8)
+		So(typeOIDFromDeclType("BOOLEAN"), ShouldEqual, oidBool)
+		So(typeOIDFromDeclType("TIMESTAMP"), ShouldEqual, oidTimestamp)
+		So(typeOIDFromDeclType(""), ShouldEqual, oidText)
+	})
+	Convey("values should round trip through wire formats", t, func() {
+		for _, format := range []int16{formatText, formatBinary} {
+			data, err := encodeValue(int64(-42), oidInt8, format)
+			So(err, ShouldBeNil)
+			v, err := decodeParam(data, oidInt8, format)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, int64(-42))
+
+			data, err = encodeValue(1.5, oidFloat8, format)
+			So(err, ShouldBeNil)
+			v, err = decodeParam(data, oidFloat8, format)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 1.5)
+
+			data, err = encodeValue([]byte{0xde, 0xad}, oidBytea, format)
+			So(err, ShouldBeNil)
+			v, err = decodeParam(data, oidBytea, format)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []byte{0xde, 0xad})
+		}
+
+		ts := time.Date(2018, 10, 1, 12, 30, 0, 0, time.UTC)
+		data, err := encodeValue(ts, oidTimestamp, formatBinary)
+		So(err, ShouldBeNil)
+		v, err := decodeParam(data, oidTimestamp, formatBinary)
+		So(err, ShouldBeNil)
+		So(v.(time.Time).Equal(ts), ShouldBeTrue)
+
+		data, err = encodeValue(nil, oidText, formatText)
+		So(err, ShouldBeNil)
+		So(data, ShouldBeNil)
+	})
+}
+
+func TestMessage(t *testing.T) {
+	Convey("backend messages should be readable as frontend messages", t, func() {
+		var buf bytes.Buffer
+		w := newMessageWriter(&buf)
+		w.start(msgParse)
+		w.string("stmt")
+		w.string("SELECT $1")
+		w.int16(1)
+		w.int32(int32(oidInt8))
+		So(w.finish(), ShouldBeNil)
+		So(w.flush(), ShouldBeNil)
+
+		typ, body, err := readMessage(bufio.NewReader(&buf))
+		So(err, ShouldBeNil)
+		So(typ, ShouldEqual, msgParse)
+
+		mr := newMessageReader(body)
+		So(mr.string(), ShouldEqual, "stmt")
+		So(mr.string(), ShouldEqual, "SELECT $1")
+		So(mr.int16(), ShouldEqual, 1)
+		So(uint32(mr.int32()), ShouldEqual, oidInt8)
+		So(mr.err, ShouldBeNil)
+		mr.byte()
+		So(mr.err, ShouldEqual, ErrInvalidMessage)
+
+		// negative and oversized counts are rejected before allocating
+		mr = newMessageReader([]byte{0xff, 0xff})
+		So(mr.count(4), ShouldEqual, 0)
+		So(mr.err, ShouldEqual, ErrInvalidMessage)
+		mr = newMessageReader([]byte{0x00, 0x02, 0, 0, 0, 1})
+		So(mr.count(4), ShouldEqual, 0)
+		So(mr.err, ShouldEqual, ErrInvalidMessage)
+		mr = newMessageReader([]byte{0x00, 0x01, 0, 0, 0, 1})
+		So(mr.count(4), ShouldEqual, 1)
+		So(mr.err, ShouldBeNil)
+	})
+	Convey("md5 password should match postgresql algorithm", t, func() {
+		// md5(md5("secretalice") + "\x01\x02\x03\x04")
+		So(md5Password("alice", "secret", []byte{1, 2, 3, 4}), ShouldStartWith, "md5")
+		So(md5Password("alice", "secret", []byte{1, 2, 3, 4}), ShouldHaveLength, 35)
+		So(md5Password("alice", "secret", []byte{1, 2, 3, 4}), ShouldNotEqual,
+			md5Password("alice", "secret", []byte{4, 3, 2, 1}))
+	})
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Server defines the postgresql wire protocol server.
+type Server struct {
+	cfg      *Config
+	listener net.Listener
+
+	// database handles shared by sessions, indexed by database id
+	dbs     map[string]*sql.DB
+	dbsLock sync.Mutex
+
+	sessions     map[*session]struct{}
+	sessionsLock sync.Mutex
+	sessionID    uint32
+
+	wg sync.WaitGroup
+}
+
+// NewServer returns a new pg adapter server.
+func NewServer(cfg *Config) *Server {
+	return &Server{
+		cfg:      cfg,
+		dbs:      make(map[string]*sql.DB),
+		sessions: make(map[*session]struct{}),
+	}
+}
+
+// Serve binds the listen address and start accepting connections.
+func (s *Server) Serve() (err error) {
+	if s.listener, err = net.Listen("tcp", s.cfg.ListenAddr); err != nil {
+		return
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return
+}
+
+// Shutdown closes the listener and all client sessions.
+func (s *Server) Shutdown() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	s.sessionsLock.Lock()
+	for sess := range s.sessions {
+		sess.close()
+	}
+	s.sessionsLock.Unlock()
+
+	s.wg.Wait()
+
+	s.dbsLock.Lock()
+	defer s.dbsLock.Unlock()
+
+	for dbID, db := range s.dbs {
+		db.Close()
+		delete(s.dbs, dbID)
+	}
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			log.WithError(err).Debug("pg adapter listener closed")
+			return
+		}
+
+		sess := newSession(s, conn, atomic.AddUint32(&s.sessionID, 1))
+
+		s.sessionsLock.Lock()
+		s.sessions[sess] = struct{}{}
+		s.sessionsLock.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.sessionsLock.Lock()
+				delete(s.sessions, sess)
+				s.sessionsLock.Unlock()
+			}()
+
+			sess.serve()
+		}()
+	}
+}
+
+// getDB returns the shared database handle of specified database.
+func (s *Server) getDB(dbID string) (db *sql.DB, err error) {
+	s.dbsLock.Lock()
+	defer s.dbsLock.Unlock()
+
+	var ok bool
+	if db, ok = s.dbs[dbID]; ok {
+		return
+	}
+
+	cfg := client.NewConfig()
+	cfg.DatabaseID = dbID
+
+	if db, err = sql.Open("covenantsql", cfg.FormatDSN()); err != nil {
+		return
+	}
+
+	// fetch database peers to make sure the database exists
+	if err = db.Ping(); err != nil {
+		db.Close()
+		db = nil
+		return
+	}
+
+	s.dbs[dbID] = db
+
+	return
+}
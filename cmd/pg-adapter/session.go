@@ -0,0 +1,942 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/sirupsen/logrus"
+)
+
+// sqlState defines postgresql error codes used by adapter.
+const (
+	sqlStateInternal          = "XX000"
+	sqlStateProtocolViolation = "08P01"
+	sqlStateSyntaxError       = "42601"
+	sqlStateInvalidPassword   = "28P01"
+	sqlStateInvalidCatalog    = "3D000"
+	sqlStateInvalidStatement  = "26000"
+	sqlStateInvalidCursor     = "34000"
+	sqlStateNoTransaction     = "25P01"
+	sqlStateInFailedTx        = "25P02"
+	sqlStateNotSupported      = "0A000"
+)
+
+// columnDesc defines a result column description.
+type columnDesc struct {
+	name string
+	oid  uint32
+}
+
+// preparedStatement defines a statement created by Parse message.
+type preparedStatement struct {
+	*statement
+	paramOIDs []uint32
+}
+
+// portal defines a bound statement created by Bind message.
+type portal struct {
+	stmt          *preparedStatement
+	args          []interface{}
+	resultFormats []int16
+
+	// cached result set of read statements
+	executed bool
+	columns  []columnDesc
+	rows     [][]interface{}
+	pos      int
+}
+
+// session defines a single client connection.
+type session struct {
+	server *Server
+	id     uint32
+	secret uint32
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *messageWriter
+
+	user     string
+	database string
+	db       *sql.DB
+	tx       *sql.Tx
+	txStatus byte
+
+	stmts   map[string]*preparedStatement
+	portals map[string]*portal
+
+	// in extended query mode, messages are discarded until Sync after error occurs
+	skipUntilSync bool
+
+	closeOnce sync.Once
+}
+
+func newSession(server *Server, conn net.Conn, id uint32) *session {
+	return &session{
+		server:   server,
+		id:       id,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		w:        newMessageWriter(conn),
+		txStatus: txStatusIdle,
+		stmts:    make(map[string]*preparedStatement),
+		portals:  make(map[string]*portal),
+	}
+}
+
+func (s *session) logger() *logrus.Entry {
+	return log.WithFields(log.Fields{
+		"session":  s.id,
+		"remote":   s.conn.RemoteAddr().String(),
+		"user":     s.user,
+		"database": s.database,
+	})
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		if s.tx != nil {
+			s.tx.Rollback()
+			s.tx = nil
+		}
+		s.conn.Close()
+	})
+}
+
+func (s *session) serve() {
+	defer s.close()
+
+	if err := s.startup(); err != nil {
+		s.logger().WithError(err).Debug("pg session startup failed")
+		return
+	}
+
+	s.logger().Info("pg session started")
+
+	for {
+		typ, body, err := readMessage(s.r)
+		if err != nil {
+			if err != io.EOF {
+				s.logger().WithError(err).Debug("read message failed")
+			}
+			return
+		}
+
+		if typ == msgTerminate {
+			s.logger().Info("pg session terminated")
+			return
+		}
+
+		if s.skipUntilSync && typ != msgSync {
+			continue
+		}
+
+		switch typ {
+		case msgQuery:
+			err = s.handleSimpleQuery(body)
+		case msgParse:
+			err = s.handleParse(body)
+		case msgBind:
+			err = s.handleBind(body)
+		case msgDescribe:
+			err = s.handleDescribe(body)
+		case msgExecute:
+			err = s.handleExecute(body)
+		case msgClose:
+			err = s.handleClose(body)
+		case msgSync:
+			s.skipUntilSync = false
+			err = s.writeReadyForQuery()
+		case msgFlush:
+			err = s.w.flush()
+		default:
+			err = s.writeError(newPGError(sqlStateProtocolViolation,
+				fmt.Errorf("unsupported message type %q", typ)))
+			if err == nil {
+				err = s.w.flush()
+			}
+			return
+		}
+
+		if err != nil {
+			s.logger().WithError(err).Debug("write message failed")
+			return
+		}
+	}
+}
+
+// startup processes the ssl negotiation, startup message and authentication.
+func (s *session) startup() (err error) {
+	var code uint32
+	var body []byte
+
+	for {
+		if code, body, err = readStartupMessage(s.r); err != nil {
+			return
+		}
+
+		switch code {
+		case sslRequestCode:
+			if s.server.cfg.TLSConfig == nil {
+				if _, err = s.conn.Write([]byte{'N'}); err != nil {
+					return
+				}
+				continue
+			}
+
+			if _, err = s.conn.Write([]byte{'S'}); err != nil {
+				return
+			}
+
+			tlsConn := tls.Server(s.conn, s.server.cfg.TLSConfig)
+			if err = tlsConn.Handshake(); err != nil {
+				return
+			}
+
+			s.conn = tlsConn
+			s.r = bufio.NewReader(tlsConn)
+			s.w = newMessageWriter(tlsConn)
+			continue
+		case cancelRequestCode:
+			// query cancellation is not supported by covenantsql client
+			err = io.EOF
+			return
+		case protocolVersion:
+		default:
+			s.writeError(newPGError(sqlStateProtocolViolation, ErrUnsupportedProtocol))
+			s.w.flush()
+			err = ErrUnsupportedProtocol
+			return
+		}
+
+		break
+	}
+
+	// parse startup parameters
+	params := make(map[string]string)
+	mr := newMessageReader(body)
+	for {
+		key := mr.string()
+		if mr.err != nil {
+			return mr.err
+		}
+		if key == "" {
+			break
+		}
+		params[key] = mr.string()
+	}
+
+	s.user = params["user"]
+	s.database = params["database"]
+	if s.database == "" {
+		s.database = s.user
+	}
+
+	if err = s.authenticate(); err != nil {
+		s.writeError(newPGError(sqlStateInvalidPassword, err))
+		s.w.flush()
+		return
+	}
+
+	if s.database == "" {
+		err = ErrMissingDatabase
+		s.writeError(newPGError(sqlStateInvalidCatalog, err))
+		s.w.flush()
+		return
+	}
+
+	if s.db, err = s.server.getDB(s.database); err != nil {
+		s.writeError(newPGError(sqlStateInvalidCatalog,
+			fmt.Errorf("database %q does not exist: %v", s.database, err)))
+		s.w.flush()
+		return
+	}
+
+	// authentication ok
+	s.w.start(msgAuthentication)
+	s.w.int32(authOK)
+	if err = s.w.finish(); err != nil {
+		return
+	}
+
+	status := [][2]string{
+		{"server_version", s.server.cfg.ServerVersion},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+		{"application_name", params["application_name"]},
+	}
+	for _, kv := range status {
+		s.w.start(msgParameterStatus)
+		s.w.string(kv[0])
+		s.w.string(kv[1])
+		if err = s.w.finish(); err != nil {
+			return
+		}
+	}
+
+	var secret [4]byte
+	rand.Read(secret[:])
+	s.secret = binary.BigEndian.Uint32(secret[:])
+
+	s.w.start(msgBackendKeyData)
+	s.w.int32(int32(s.id))
+	s.w.int32(int32(s.secret))
+	if err = s.w.finish(); err != nil {
+		return
+	}
+
+	return s.writeReadyForQuery()
+}
+
+// authenticate processes md5 password authentication if users are configured.
+func (s *session) authenticate() (err error) {
+	if len(s.server.cfg.Users) == 0 {
+		return
+	}
+
+	expected, ok := s.server.cfg.Users[s.user]
+
+	var salt [4]byte
+	if _, err = rand.Read(salt[:]); err != nil {
+		return
+	}
+
+	s.w.start(msgAuthentication)
+	s.w.int32(authMD5Password)
+	s.w.bytes(salt[:])
+	if err = s.w.finish(); err != nil {
+		return
+	}
+	if err = s.w.flush(); err != nil {
+		return
+	}
+
+	var typ byte
+	var body []byte
+	if typ, body, err = readMessage(s.r); err != nil {
+		return
+	}
+	if typ != msgPassword {
+		return ErrInvalidMessage
+	}
+
+	mr := newMessageReader(body)
+	response := mr.string()
+	if mr.err != nil {
+		return mr.err
+	}
+
+	if !ok || response != md5Password(s.user, expected, salt[:]) {
+		s.logger().Warning("pg session authentication failed")
+		return ErrAuthenticationFailed
+	}
+
+	return
+}
+
+// md5Password computes the postgresql md5 password challenge response.
+func md5Password(user string, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+func (s *session) handleSimpleQuery(body []byte) (err error) {
+	mr := newMessageReader(body)
+	query := mr.string()
+	if mr.err != nil {
+		return mr.err
+	}
+
+	s.logger().WithField("query", query).Debug("got simple query")
+
+	// simple query destroys unnamed statement and portal
+	delete(s.stmts, "")
+	delete(s.portals, "")
+
+	stmts := splitStatements(query)
+	if len(stmts) == 0 {
+		s.w.start(msgEmptyQueryResponse)
+		if err = s.w.finish(); err != nil {
+			return
+		}
+		return s.writeReadyForQuery()
+	}
+
+	for _, q := range stmts {
+		st := parseStatement(q)
+		p := &portal{stmt: &preparedStatement{statement: st}}
+
+		var tag string
+		var execErr error
+		if st.kind == kindRead {
+			if execErr = s.executeRead(p); execErr == nil {
+				if err = s.writeRowDescription(p.columns, nil); err != nil {
+					return
+				}
+				if err = s.writeRows(p, 0); err != nil {
+					return
+				}
+				tag = st.commandTag(int64(len(p.rows)))
+			}
+		} else {
+			tag, execErr = s.execute(p)
+		}
+
+		if execErr != nil {
+			if err = s.writeError(execErr); err != nil {
+				return
+			}
+			break
+		}
+
+		if err = s.writeCommandComplete(tag); err != nil {
+			return
+		}
+	}
+
+	return s.writeReadyForQuery()
+}
+
+func (s *session) handleParse(body []byte) (err error) {
+	mr := newMessageReader(body)
+	name := mr.string()
+	query := mr.string()
+	paramCnt := mr.count(4)
+	paramOIDs := make([]uint32, 0, paramCnt)
+	for i := 0; i < paramCnt; i++ {
+		paramOIDs = append(paramOIDs, uint32(mr.int32()))
+	}
+	if mr.err != nil {
+		return s.extendedError(newPGError(sqlStateProtocolViolation, mr.err))
+	}
+
+	s.logger().WithField("statement", name).WithField("query", query).Debug("got parse")
+
+	st := parseStatement(query)
+	if len(splitStatements(query)) > 1 {
+		return s.extendedError(newPGError(sqlStateSyntaxError,
+			fmt.Errorf("cannot insert multiple commands into a prepared statement")))
+	}
+
+	for len(paramOIDs) < st.paramCount {
+		paramOIDs = append(paramOIDs, oidUnknown)
+	}
+
+	s.stmts[name] = &preparedStatement{statement: st, paramOIDs: paramOIDs}
+
+	s.w.start(msgParseComplete)
+	return s.w.finish()
+}
+
+func (s *session) handleBind(body []byte) (err error) {
+	mr := newMessageReader(body)
+	portalName := mr.string()
+	stmtName := mr.string()
+
+	formatCnt := mr.count(2)
+	paramFormats := make([]int16, 0, formatCnt)
+	for i := 0; i < formatCnt; i++ {
+		paramFormats = append(paramFormats, mr.int16())
+	}
+
+	paramCnt := mr.count(4)
+	params := make([][]byte, 0, paramCnt)
+	for i := 0; i < paramCnt; i++ {
+		size := mr.int32()
+		if size < 0 {
+			params = append(params, nil)
+			continue
+		}
+		params = append(params, mr.bytes(int(size)))
+	}
+
+	resultFormatCnt := mr.count(2)
+	resultFormats := make([]int16, 0, resultFormatCnt)
+	for i := 0; i < resultFormatCnt; i++ {
+		resultFormats = append(resultFormats, mr.int16())
+	}
+
+	if mr.err != nil {
+		return s.extendedError(newPGError(sqlStateProtocolViolation, mr.err))
+	}
+
+	ps, ok := s.stmts[stmtName]
+	if !ok {
+		return s.extendedError(newPGError(sqlStateInvalidStatement, ErrStatementNotFound))
+	}
+
+	args := make([]interface{}, len(params))
+	for i, param := range params {
+		var oid uint32
+		if i < len(ps.paramOIDs) {
+			oid = ps.paramOIDs[i]
+		}
+		if args[i], err = decodeParam(param, oid, formatCode(paramFormats, i)); err != nil {
+			return s.extendedError(newPGError(sqlStateProtocolViolation, err))
+		}
+	}
+
+	s.portals[portalName] = &portal{
+		stmt:          ps,
+		args:          args,
+		resultFormats: resultFormats,
+	}
+
+	s.w.start(msgBindComplete)
+	return s.w.finish()
+}
+
+func (s *session) handleDescribe(body []byte) (err error) {
+	mr := newMessageReader(body)
+	typ := mr.byte()
+	name := mr.string()
+	if mr.err != nil {
+		return s.extendedError(newPGError(sqlStateProtocolViolation, mr.err))
+	}
+
+	switch typ {
+	case 'S':
+		ps, ok := s.stmts[name]
+		if !ok {
+			return s.extendedError(newPGError(sqlStateInvalidStatement, ErrStatementNotFound))
+		}
+
+		s.w.start(msgParameterDescription)
+		s.w.int16(int16(len(ps.paramOIDs)))
+		for _, oid := range ps.paramOIDs {
+			if oid == oidUnknown {
+				oid = oidText
+			}
+			s.w.int32(int32(oid))
+		}
+		if err = s.w.finish(); err != nil {
+			return
+		}
+
+		if ps.kind != kindRead {
+			s.w.start(msgNoData)
+			return s.w.finish()
+		}
+
+		var columns []columnDesc
+		if columns, err = s.probeColumns(ps); err != nil {
+			return s.extendedError(err)
+		}
+
+		return s.writeRowDescription(columns, nil)
+	case 'P':
+		p, ok := s.portals[name]
+		if !ok {
+			return s.extendedError(newPGError(sqlStateInvalidCursor, ErrPortalNotFound))
+		}
+
+		if p.stmt.kind != kindRead {
+			s.w.start(msgNoData)
+			return s.w.finish()
+		}
+
+		// the result set is fetched on describe and cached for the following execute
+		if !p.executed {
+			if err = s.executeRead(p); err != nil {
+				return s.extendedError(err)
+			}
+		}
+
+		return s.writeRowDescription(p.columns, p.resultFormats)
+	default:
+		return s.extendedError(newPGError(sqlStateProtocolViolation, ErrInvalidMessage))
+	}
+}
+
+func (s *session) handleExecute(body []byte) (err error) {
+	mr := newMessageReader(body)
+	name := mr.string()
+	maxRows := int(mr.int32())
+	if mr.err != nil {
+		return s.extendedError(newPGError(sqlStateProtocolViolation, mr.err))
+	}
+
+	p, ok := s.portals[name]
+	if !ok {
+		return s.extendedError(newPGError(sqlStateInvalidCursor, ErrPortalNotFound))
+	}
+
+	if p.stmt.kind == kindEmpty {
+		s.w.start(msgEmptyQueryResponse)
+		return s.w.finish()
+	}
+
+	if p.stmt.kind != kindRead {
+		var tag string
+		var execErr error
+		if tag, execErr = s.execute(p); execErr != nil {
+			return s.extendedError(execErr)
+		}
+		return s.writeCommandComplete(tag)
+	}
+
+	if !p.executed {
+		if err = s.executeRead(p); err != nil {
+			return s.extendedError(err)
+		}
+	}
+
+	if err = s.writeRows(p, maxRows); err != nil {
+		return
+	}
+
+	if p.pos < len(p.rows) {
+		s.w.start(msgPortalSuspended)
+		return s.w.finish()
+	}
+
+	return s.writeCommandComplete(p.stmt.commandTag(int64(len(p.rows))))
+}
+
+func (s *session) handleClose(body []byte) (err error) {
+	mr := newMessageReader(body)
+	typ := mr.byte()
+	name := mr.string()
+	if mr.err != nil {
+		return s.extendedError(newPGError(sqlStateProtocolViolation, mr.err))
+	}
+
+	switch typ {
+	case 'S':
+		if ps, ok := s.stmts[name]; ok {
+			for portalName, p := range s.portals {
+				if p.stmt == ps {
+					delete(s.portals, portalName)
+				}
+			}
+			delete(s.stmts, name)
+		}
+	case 'P':
+		delete(s.portals, name)
+	default:
+		return s.extendedError(newPGError(sqlStateProtocolViolation, ErrInvalidMessage))
+	}
+
+	s.w.start(msgCloseComplete)
+	return s.w.finish()
+}
+
+// execute runs the non-read statement and returns the command tag.
+func (s *session) execute(p *portal) (tag string, err error) {
+	st := p.stmt.statement
+
+	if s.txStatus == txStatusFailed && st.kind != kindCommit && st.kind != kindRollback {
+		err = newPGError(sqlStateInFailedTx, ErrTransactionAborted)
+		return
+	}
+
+	switch st.kind {
+	case kindEmpty, kindSet:
+		tag = st.tag
+	case kindBegin:
+		tag = st.commandTag(0)
+		if s.tx != nil {
+			// already in transaction, postgresql issues a warning only
+			return
+		}
+		if s.tx, err = s.db.Begin(); err != nil {
+			return
+		}
+		s.txStatus = txStatusInTx
+	case kindCommit, kindRollback:
+		tag = st.commandTag(0)
+		if s.tx == nil {
+			return
+		}
+		if st.kind == kindRollback || s.txStatus == txStatusFailed {
+			tag = "ROLLBACK"
+			if err = s.tx.Rollback(); err == sql.ErrTxDone {
+				// covenantsql driver reports empty transaction rollback as done
+				err = nil
+			}
+		} else {
+			err = s.tx.Commit()
+		}
+		s.tx = nil
+		s.txStatus = txStatusIdle
+	case kindWrite:
+		var result sql.Result
+		if s.tx != nil {
+			result, err = s.tx.Exec(st.pattern, p.args...)
+		} else {
+			result, err = s.db.Exec(st.pattern, p.args...)
+		}
+		if err != nil {
+			s.failTransaction()
+			err = wrapQueryError(err)
+			return
+		}
+
+		// covenantsql driver does not report affected rows for now
+		affected, _ := result.RowsAffected()
+		tag = st.commandTag(affected)
+	default:
+		err = newPGError(sqlStateNotSupported, fmt.Errorf("unsupported statement: %s", st.query))
+	}
+
+	return
+}
+
+// executeRead runs the read statement and caches the result set in portal.
+func (s *session) executeRead(p *portal) (err error) {
+	if s.txStatus == txStatusFailed {
+		return newPGError(sqlStateInFailedTx, ErrTransactionAborted)
+	}
+
+	var rows *sql.Rows
+	if s.tx != nil {
+		rows, err = s.tx.Query(p.stmt.pattern, p.args...)
+	} else {
+		rows, err = s.db.Query(p.stmt.pattern, p.args...)
+	}
+	if err != nil {
+		s.failTransaction()
+		return wrapQueryError(err)
+	}
+	defer rows.Close()
+
+	if p.columns, err = readColumns(rows); err != nil {
+		return wrapQueryError(err)
+	}
+
+	p.rows = p.rows[:0]
+	for rows.Next() {
+		row := make([]interface{}, len(p.columns))
+		dest := make([]interface{}, len(p.columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return wrapQueryError(err)
+		}
+		p.rows = append(p.rows, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return wrapQueryError(err)
+	}
+
+	p.executed = true
+	p.pos = 0
+
+	return
+}
+
+// probeColumns fetches result columns of prepared read statement without parameters bound.
+func (s *session) probeColumns(ps *preparedStatement) (columns []columnDesc, err error) {
+	args := make([]interface{}, ps.paramCount)
+	probe := "SELECT * FROM (" + strings.TrimRight(strings.TrimSpace(ps.pattern), ";") + ") LIMIT 0"
+
+	var rows *sql.Rows
+	if rows, err = s.db.Query(probe, args...); err != nil {
+		if ps.paramCount > 0 {
+			return nil, wrapQueryError(err)
+		}
+
+		// statements like PRAGMA could not be used as sub query
+		if rows, err = s.db.Query(ps.pattern); err != nil {
+			return nil, wrapQueryError(err)
+		}
+	}
+	defer rows.Close()
+
+	if columns, err = readColumns(rows); err != nil {
+		err = wrapQueryError(err)
+	}
+
+	return
+}
+
+func (s *session) failTransaction() {
+	if s.tx != nil {
+		s.txStatus = txStatusFailed
+	}
+}
+
+func (s *session) writeRowDescription(columns []columnDesc, formats []int16) (err error) {
+	s.w.start(msgRowDescription)
+	s.w.int16(int16(len(columns)))
+	for i, c := range columns {
+		s.w.string(c.name)
+		s.w.int32(0) // table oid
+		s.w.int16(0) // column attribute number
+		s.w.int32(int32(c.oid))
+		s.w.int16(typeSize(c.oid))
+		s.w.int32(-1) // type modifier
+		s.w.int16(formatCode(formats, i))
+	}
+	return s.w.finish()
+}
+
+// writeRows sends at most maxRows rows of portal, zero maxRows means unlimited.
+func (s *session) writeRows(p *portal, maxRows int) (err error) {
+	end := len(p.rows)
+	if maxRows > 0 && p.pos+maxRows < end {
+		end = p.pos + maxRows
+	}
+
+	for ; p.pos < end; p.pos++ {
+		row := p.rows[p.pos]
+
+		s.w.start(msgDataRow)
+		s.w.int16(int16(len(row)))
+		for i, v := range row {
+			var data []byte
+			if data, err = encodeValue(v, p.columns[i].oid, formatCode(p.resultFormats, i)); err != nil {
+				return
+			}
+			if data == nil {
+				s.w.int32(-1)
+				continue
+			}
+			s.w.int32(int32(len(data)))
+			s.w.bytes(data)
+		}
+		if err = s.w.finish(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *session) writeCommandComplete(tag string) error {
+	s.w.start(msgCommandComplete)
+	s.w.string(tag)
+	return s.w.finish()
+}
+
+func (s *session) writeReadyForQuery() (err error) {
+	s.w.start(msgReadyForQuery)
+	s.w.byte(s.txStatus)
+	if err = s.w.finish(); err != nil {
+		return
+	}
+	return s.w.flush()
+}
+
+func (s *session) writeError(err error) error {
+	pgErr, ok := err.(*pgError)
+	if !ok {
+		pgErr = newPGError(sqlStateInternal, err)
+	}
+
+	s.logger().WithError(pgErr.err).WithField("code", pgErr.code).Debug("send error response")
+
+	s.w.start(msgErrorResponse)
+	s.w.byte('S')
+	s.w.string("ERROR")
+	s.w.byte('V')
+	s.w.string("ERROR")
+	s.w.byte('C')
+	s.w.string(pgErr.code)
+	s.w.byte('M')
+	s.w.string(pgErr.err.Error())
+	s.w.byte(0)
+	return s.w.finish()
+}
+
+// extendedError sends error response and discards messages until next Sync message.
+func (s *session) extendedError(err error) error {
+	s.skipUntilSync = true
+	return s.writeError(err)
+}
+
+// pgError defines an error with postgresql error code.
+type pgError struct {
+	code string
+	err  error
+}
+
+func newPGError(code string, err error) *pgError {
+	return &pgError{code: code, err: err}
+}
+
+// Error implements the error interface.
+func (e *pgError) Error() string {
+	return e.err.Error()
+}
+
+func wrapQueryError(err error) error {
+	if strings.Contains(err.Error(), "syntax error") {
+		return newPGError(sqlStateSyntaxError, err)
+	}
+	return newPGError(sqlStateInternal, err)
+}
+
+func readColumns(rows *sql.Rows) (columns []columnDesc, err error) {
+	var names []string
+	if names, err = rows.Columns(); err != nil {
+		return
+	}
+
+	var types []*sql.ColumnType
+	if types, err = rows.ColumnTypes(); err != nil {
+		return
+	}
+
+	columns = make([]columnDesc, len(names))
+	for i, name := range names {
+		if name == "" {
+			name = fmt.Sprintf("_c%d", i)
+		}
+		columns[i].name = name
+		columns[i].oid = oidText
+		if i < len(types) && types[i] != nil {
+			columns[i].oid = typeOIDFromDeclType(types[i].DatabaseTypeName())
+		}
+	}
+
+	return
+}
+
+// formatCode returns the format code of i-th field according to postgresql format code list rules.
+func formatCode(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return formatText
+	case 1:
+		return formats[0]
+	default:
+		if i < len(formats) {
+			return formats[i]
+		}
+		return formatText
+	}
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postgresql type oids, see pg_type.h for the full list.
+const (
+	oidUnknown     uint32 = 0
+	oidBool        uint32 = 16
+	oidBytea       uint32 = 17
+	oidInt8        uint32 = 20
+	oidInt2        uint32 = 21
+	oidInt4        uint32 = 23
+	oidText        uint32 = 25
+	oidFloat4      uint32 = 700
+	oidFloat8      uint32 = 701
+	oidVarchar     uint32 = 1043
+	oidDate        uint32 = 1082
+	oidTimestamp   uint32 = 1114
+	oidTimestampTZ uint32 = 1184
+	oidNumeric     uint32 = 1700
+)
+
+// data format codes.
+const (
+	formatText   int16 = 0
+	formatBinary int16 = 1
+)
+
+const (
+	pgTimestampFormat = "2006-01-02 15:04:05.999999"
+	pgDateFormat      = "2006-01-02"
+)
+
+// pgEpoch defines the binary timestamp epoch of postgresql.
+var pgEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// typeOIDFromDeclType maps sqlite3 declared column type to postgresql type oid,
+// the rules follows the sqlite3 type affinity determination.
+func typeOIDFromDeclType(declType string) uint32 {
+	t := strings.ToUpper(strings.TrimSpace(declType))
+
+	// strip type modifiers like VARCHAR(255)
+	if idx := strings.IndexByte(t, '('); idx >= 0 {
+		t = strings.TrimSpace(t[:idx])
+	}
+
+	switch t {
+	case "BOOL", "BOOLEAN":
+		return oidBool
+	case "DATE":
+		return oidDate
+	case "DATETIME", "TIMESTAMP":
+		return oidTimestamp
+	case "NUMERIC", "DECIMAL":
+		return oidNumeric
+	}
+
+	switch {
+	case strings.Contains(t, "INT"):
+		return oidInt8
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return oidText
+	case strings.Contains(t, "BLOB"):
+		return oidBytea
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return oidFloat8
+	}
+
+	// expressions and untyped columns
+	return oidText
+}
+
+// typeSize returns the pg_type.typlen value of type oid.
+func typeSize(oid uint32) int16 {
+	switch oid {
+	case oidBool:
+		return 1
+	case oidInt2:
+		return 2
+	case oidInt4, oidFloat4, oidDate:
+		return 4
+	case oidInt8, oidFloat8, oidTimestamp, oidTimestampTZ:
+		return 8
+	default:
+		return -1
+	}
+}
+
+// encodeValue encodes a database value into postgresql wire format, nil result represents NULL.
+func encodeValue(v interface{}, oid uint32, format int16) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	if format == formatBinary {
+		return encodeBinary(v, oid)
+	}
+
+	return encodeText(v, oid), nil
+}
+
+func encodeText(v interface{}, oid uint32) []byte {
+	switch x := v.(type) {
+	case []byte:
+		if oid == oidBytea {
+			return []byte(`\x` + hex.EncodeToString(x))
+		}
+		return x
+	case string:
+		if oid == oidBytea {
+			return []byte(`\x` + hex.EncodeToString([]byte(x)))
+		}
+		return []byte(x)
+	case bool:
+		if x {
+			return []byte("t")
+		}
+		return []byte("f")
+	case int64:
+		if oid == oidBool {
+			return encodeText(x != 0, oid)
+		}
+		return []byte(strconv.FormatInt(x, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(x, 'g', -1, 64))
+	case time.Time:
+		if oid == oidDate {
+			return []byte(x.Format(pgDateFormat))
+		}
+		return []byte(x.UTC().Format(pgTimestampFormat))
+	default:
+		return []byte(fmt.Sprint(x))
+	}
+}
+
+func encodeBinary(v interface{}, oid uint32) (b []byte, err error) {
+	switch oid {
+	case oidBool:
+		var bv bool
+		switch x := v.(type) {
+		case bool:
+			bv = x
+		case int64:
+			bv = x != 0
+		default:
+			bv, err = strconv.ParseBool(string(encodeText(v, oidText)))
+		}
+		if bv {
+			return []byte{1}, err
+		}
+		return []byte{0}, err
+	case oidInt8:
+		var iv int64
+		switch x := v.(type) {
+		case int64:
+			iv = x
+		case float64:
+			iv = int64(x)
+		case bool:
+			if x {
+				iv = 1
+			}
+		default:
+			iv, err = strconv.ParseInt(string(encodeText(v, oidText)), 10, 64)
+		}
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(iv))
+		return
+	case oidFloat8:
+		var fv float64
+		switch x := v.(type) {
+		case float64:
+			fv = x
+		case int64:
+			fv = float64(x)
+		default:
+			fv, err = strconv.ParseFloat(string(encodeText(v, oidText)), 64)
+		}
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(fv))
+		return
+	case oidTimestamp, oidTimestampTZ:
+		t, ok := v.(time.Time)
+		if !ok {
+			err = ErrUnsupportedFormat
+			return
+		}
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(t.Sub(pgEpoch)/time.Microsecond))
+		return
+	case oidBytea:
+		switch x := v.(type) {
+		case []byte:
+			return x, nil
+		case string:
+			return []byte(x), nil
+		}
+	case oidText, oidVarchar:
+		return encodeText(v, oid), nil
+	}
+
+	err = ErrUnsupportedFormat
+	return
+}
+
+// decodeParam decodes a bind parameter into value accepted by covenantsql driver.
+func decodeParam(data []byte, oid uint32, format int16) (v interface{}, err error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	if format == formatText {
+		switch oid {
+		case oidBytea:
+			if strings.HasPrefix(string(data), `\x`) {
+				return hex.DecodeString(string(data[2:]))
+			}
+			return data, nil
+		case oidInt2, oidInt4, oidInt8:
+			return strconv.ParseInt(string(data), 10, 64)
+		case oidFloat4, oidFloat8:
+			return strconv.ParseFloat(string(data), 64)
+		case oidBool:
+			return strconv.ParseBool(string(data))
+		default:
+			// let sqlite3 apply column affinity
+			return string(data), nil
+		}
+	}
+
+	switch oid {
+	case oidBool:
+		if len(data) != 1 {
+			return nil, ErrInvalidMessage
+		}
+		return data[0] != 0, nil
+	case oidInt2:
+		if len(data) != 2 {
+			return nil, ErrInvalidMessage
+		}
+		return int64(int16(binary.BigEndian.Uint16(data))), nil
+	case oidInt4:
+		if len(data) != 4 {
+			return nil, ErrInvalidMessage
+		}
+		return int64(int32(binary.BigEndian.Uint32(data))), nil
+	case oidInt8:
+		if len(data) != 8 {
+			return nil, ErrInvalidMessage
+		}
+		return int64(binary.BigEndian.Uint64(data)), nil
+	case oidFloat4:
+		if len(data) != 4 {
+			return nil, ErrInvalidMessage
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case oidFloat8:
+		if len(data) != 8 {
+			return nil, ErrInvalidMessage
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case oidTimestamp, oidTimestampTZ:
+		if len(data) != 8 {
+			return nil, ErrInvalidMessage
+		}
+		us := int64(binary.BigEndian.Uint64(data))
+		return pgEpoch.Add(time.Duration(us) * time.Microsecond), nil
+	case oidText, oidVarchar, oidUnknown:
+		return string(data), nil
+	default:
+		return data, nil
+	}
+}
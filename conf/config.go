@@ -26,6 +26,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 	"gopkg.in/yaml.v2"
 )
 
@@ -82,6 +83,9 @@ type BPInfo struct {
 	ChainFileName string `yaml:"ChainFileName"`
 	// BPGenesis is the genesis block filed
 	BPGenesis BPGenesisInfo `yaml:"BPGenesisInfo,omitempty"`
+	// DepositPeriod is the billing periods prepaid by database deposit, deposit is not charged on
+	// database creation if it's zero
+	DepositPeriod uint64 `yaml:"DepositPeriod,omitempty"`
 }
 
 // MinerDatabaseFixture config.
@@ -112,6 +116,36 @@ type DNSSeed struct {
 	DNSServers     []string `yaml:"DNSServers"`
 }
 
+// BootstrapConfig defines the sources of the block producer list to bootstrap the node.
+type BootstrapConfig struct {
+	// Sources are tried in order until one returns a verified list, source can be "static",
+	// "file", "http" or "dns"
+	Sources []string `yaml:"Sources"`
+	// StaticFile is the yaml file of the block producer node list
+	StaticFile string `yaml:"StaticFile,omitempty"`
+	// SignedFile is the yaml file of the block producer list signed by a pinned key
+	SignedFile string `yaml:"SignedFile,omitempty"`
+	// HTTPURL is the url serving the block producer list signed by a pinned key
+	HTTPURL string `yaml:"HTTPURL,omitempty"`
+	// DNSDomain is the DNSSEC seed domain, BPDomain is used if not set
+	DNSDomain string `yaml:"DNSDomain,omitempty"`
+	// PinnedKeys are the public keys of the trusted block producers, block producers with other
+	// keys are rejected if set
+	PinnedKeys []*asymmetric.PublicKey `yaml:"PinnedKeys,omitempty"`
+	// CacheFile saves the last known good list, which is used if all sources fail
+	CacheFile string `yaml:"CacheFile,omitempty"`
+}
+
+// RelayConfig defines the relays of the node behind NAT and whether the node serves as a relay.
+type RelayConfig struct {
+	// Enabled registers the node to a relay and announces the relay address of the node
+	Enabled bool `yaml:"Enabled"`
+	// Nodes are the relays tried in order, the block producers are used if not set
+	Nodes []proto.NodeID `yaml:"Nodes,omitempty"`
+	// Serve makes the node a volunteer relay for other nodes, it should have a public address
+	Serve bool `yaml:"Serve,omitempty"`
+}
+
 // Config holds all the config read from yaml config file.
 type Config struct {
 	IsTestMode      bool `yaml:"IsTestMode,omitempty"` // when testMode use default empty masterKey and test DNS domain
@@ -129,9 +163,22 @@ type Config struct {
 
 	DNSSeed DNSSeed `yaml:"DNSSeed"`
 
+	// Bootstrap configures the sources of the block producer list, DNS seed is used if not set
+	Bootstrap *BootstrapConfig `yaml:"Bootstrap,omitempty"`
+
+	// Relay configures the NAT traversal of the node, the node is reached directly if not set
+	Relay *RelayConfig `yaml:"Relay,omitempty"`
+
+	// EnableLegacyETLS accepts the ETLS version 1 peers, which have no integrity protection nor
+	// forward secrecy, and enables the fallback to it when dialing an old node
+	EnableLegacyETLS bool `yaml:"EnableLegacyETLS,omitempty"`
+
 	BP    *BPInfo    `yaml:"BlockProducer"`
 	Miner *MinerInfo `yaml:"Miner,omitempty"`
 
+	// Trace configures the span exporter of the node, tracing is disabled if not set
+	Trace *trace.Config `yaml:"Trace,omitempty"`
+
 	KnownNodes  []proto.Node `yaml:"KnownNodes"`
 	SeedBPNodes []proto.Node `yaml:"-"`
 }
@@ -177,5 +224,13 @@ func LoadConfig(configPath string) (config *Config, err error) {
 	if config.Miner != nil && !path.IsAbs(config.Miner.RootDir) {
 		config.Miner.RootDir = path.Join(configDir, config.Miner.RootDir)
 	}
+
+	if b := config.Bootstrap; b != nil {
+		for _, p := range []*string{&b.StaticFile, &b.SignedFile, &b.CacheFile} {
+			if *p != "" && !path.IsAbs(*p) {
+				*p = path.Join(configDir, *p)
+			}
+		}
+	}
 	return
 }
@@ -57,6 +57,15 @@ func (s *Signature) IsEqual(signature *Signature) bool {
 	return (*ec.Signature)(s).IsEqual((*ec.Signature)(signature))
 }
 
+// Signer defines the signing key of an identity, which is a PrivateKey in process, a key in an
+// encrypted wallet or a key held by a remote signing daemon.
+type Signer interface {
+	// PubKey returns the public key to verify the signatures.
+	PubKey() *PublicKey
+	// Sign generates the signature for the provided hash.
+	Sign(hash []byte) (*Signature, error)
+}
+
 // Sign generates an ECDSA signature for the provided hash (which should be the result of hashing
 // a larger message) using the private key. Produced signature is deterministic (same message and
 // same key yield the same signature) and canonical in accordance with RFC6979 and BIP0062.
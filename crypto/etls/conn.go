@@ -33,9 +33,11 @@ type CryptoConn struct {
 	net.Conn
 	*Cipher
 	NodeID *proto.RawNodeID
+	// isClient indicates a dialed connection, which initiates the handshake
+	isClient bool
 }
 
-// NewConn returns a new CryptoConn
+// NewConn returns a new CryptoConn of an accepted connection
 func NewConn(c net.Conn, cipher *Cipher, nodeID *proto.RawNodeID) *CryptoConn {
 	return &CryptoConn{
 		Conn:   c,
@@ -44,6 +46,16 @@ func NewConn(c net.Conn, cipher *Cipher, nodeID *proto.RawNodeID) *CryptoConn {
 	}
 }
 
+// NewClientConn returns a new CryptoConn of a dialed connection
+func NewClientConn(c net.Conn, cipher *Cipher, nodeID *proto.RawNodeID) *CryptoConn {
+	return &CryptoConn{
+		Conn:     c,
+		Cipher:   cipher,
+		NodeID:   nodeID,
+		isClient: true,
+	}
+}
+
 // Dial connects to a address with a Cipher
 // address should be in the form of host:port
 func Dial(network, address string, cipher *Cipher) (c *CryptoConn, err error) {
@@ -53,23 +65,38 @@ func Dial(network, address string, cipher *Cipher) (c *CryptoConn, err error) {
 		return
 	}
 
-	c = NewConn(conn, cipher, nil)
+	c = NewClientConn(conn, cipher, nil)
 	return
 }
 
+// Handshake runs the handshake if it has not been run yet, Read and Write run it implicitly. A
+// dialing side may call it to find out an old peer before any data is sent.
+func (c *CryptoConn) Handshake() error {
+	return c.handshake(c.Conn, c.isClient)
+}
+
 // RawRead is the raw net.Conn.Read
 func (c *CryptoConn) RawRead(b []byte) (n int, err error) {
 	return c.Conn.Read(b)
 }
 
-// Read iv and Encrypted data
+// Read iv and Encrypted data, or the records of Version2
 func (c *CryptoConn) Read(b []byte) (n int, err error) {
+	if err = c.handshake(c.Conn, c.isClient); err != nil {
+		return
+	}
+	if c.negotiated == Version2 {
+		return c.readRecords(c.Conn, b)
+	}
+
 	if c.decStream == nil {
 		iv := make([]byte, c.info.ivLen)
-		if _, err = io.ReadFull(c.Conn, iv); err != nil {
+		copy(iv, c.pending)
+		if _, err = io.ReadFull(c.Conn, iv[len(c.pending):]); err != nil {
 			log.Infof("ReadFull failed: %s", err)
 			return
 		}
+		c.pending = nil
 		if err = c.initDecrypt(iv); err != nil {
 			return
 		}
@@ -96,8 +123,15 @@ func (c *CryptoConn) RawWrite(b []byte) (n int, err error) {
 	return c.Conn.Read(b)
 }
 
-// Write iv and Encrypted data
+// Write iv and Encrypted data, or the records of Version2
 func (c *CryptoConn) Write(b []byte) (n int, err error) {
+	if err = c.handshake(c.Conn, c.isClient); err != nil {
+		return
+	}
+	if c.negotiated == Version2 {
+		return c.writeRecords(c.Conn, b)
+	}
+
 	var iv []byte
 	if c.encStream == nil {
 		iv, err = c.initEncrypt()
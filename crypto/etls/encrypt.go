@@ -21,6 +21,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"io"
+	"sync"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	ec "github.com/btcsuite/btcd/btcec"
@@ -90,10 +91,63 @@ type Cipher struct {
 	key        []byte
 	info       *cipherInfo
 	iv         []byte
+
+	// version is the highest ETLS version to negotiate, and negotiated is the one in use after
+	// the handshake. legacy indicates that Version1 peers are accepted.
+	version       byte
+	negotiated    byte
+	legacy        bool
+	handshakeLock sync.Mutex
+	handshakeErr  error
+	// pending keeps the bytes of a Version1 IV read during the handshake
+	pending []byte
+
+	// Version2 record states
+	sealer, opener      cipher.AEAD
+	sendSeq, recvSeq    uint64
+	readLock, writeLock sync.Mutex
+	readBuf             []byte
+	readErr             error
 }
 
-// NewCipher creates a cipher that can be used in Dial(), Listen() etc.
+// NewCipher creates a cipher that can be used in Dial(), Listen() etc. It negotiates Version2 on
+// dialed connections, and accepts both Version1 and Version2 peers on accepted connections.
 func NewCipher(rawKey []byte) (c *Cipher) {
+	c = newCipher(rawKey)
+	c.version = Version2
+	c.legacy = true
+	return
+}
+
+// NewStrictCipher creates a Version2 only cipher, which refuses the Version1 peers on accepted
+// connections.
+func NewStrictCipher(rawKey []byte) (c *Cipher) {
+	c = newCipher(rawKey)
+	c.version = Version2
+	return
+}
+
+// NewLegacyCipher creates a Version1 only cipher, which is used to talk to the old peers.
+func NewLegacyCipher(rawKey []byte) (c *Cipher) {
+	c = newCipher(rawKey)
+	c.version = Version1
+	c.legacy = true
+	return
+}
+
+// Legacy returns a new Version1 only cipher with the same key, which is used to dial again to an
+// old peer that fails the Version2 handshake.
+func (c *Cipher) Legacy() *Cipher {
+	return &Cipher{key: c.key, info: c.info, version: Version1, legacy: true}
+}
+
+// Clone returns a new cipher with the same key and versions, which is used to dial another
+// connection to the same peer.
+func (c *Cipher) Clone() *Cipher {
+	return &Cipher{key: c.key, info: c.info, version: c.version, legacy: c.legacy}
+}
+
+func newCipher(rawKey []byte) (c *Cipher) {
 	mi := &cipherInfo{
 		32,
 		16,
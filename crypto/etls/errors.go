@@ -0,0 +1,39 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etls
+
+import "errors"
+
+var (
+	// ErrInvalidHello indicates that the handshake hello message is malformed.
+	ErrInvalidHello = errors.New("invalid etls hello")
+	// ErrUnsupportedVersion indicates that the peer speaks an unsupported etls version.
+	ErrUnsupportedVersion = errors.New("unsupported etls version")
+	// ErrUnsupportedCipherSuite indicates that the peer requests an unsupported cipher suite.
+	ErrUnsupportedCipherSuite = errors.New("unsupported etls cipher suite")
+	// ErrKeyConfirmation indicates that the peer derives different session keys in the handshake,
+	// which does not hold the expected static key.
+	ErrKeyConfirmation = errors.New("etls key confirmation failed")
+	// ErrRecordAuthentication indicates that a record fails the authentication, which is tampered,
+	// replayed, reordered or encrypted with another key.
+	ErrRecordAuthentication = errors.New("etls record authentication failed")
+	// ErrRecordTooLarge indicates that a record exceeds the maximum record size.
+	ErrRecordTooLarge = errors.New("etls record too large")
+	// ErrSequenceOverflow indicates that the record sequence number is exhausted, the connection
+	// must be re-established.
+	ErrSequenceOverflow = errors.New("etls record sequence overflow")
+)
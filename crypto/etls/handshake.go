@@ -0,0 +1,286 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+
+	ec "github.com/btcsuite/btcd/btcec"
+)
+
+const (
+	// Version1 is the legacy ETLS: an AES-CFB stream keyed by the static ECDH secret, which has no
+	// integrity protection nor forward secrecy. It is only kept for the old peers.
+	Version1 byte = 0x01
+	// Version2 negotiates a per-connection key with ephemeral ECDH authenticated by the static
+	// ECDH secret, and frames data into AEAD records with implicit sequence numbers.
+	Version2 byte = 0x02
+
+	// SuiteAES256GCM is the AES-256-GCM cipher suite, which every Version2 peer supports.
+	SuiteAES256GCM byte = 0x01
+)
+
+// helloMagic starts a Version2 hello. A Version1 connection starts with a random IV instead, and
+// is told apart by the mismatched magic.
+var helloMagic = []byte("CQL-ETLS")
+
+const (
+	helloPubKeyLen = 33 // compressed secp256k1 public key
+	helloLen       = 8 + 1 + 1 + helloPubKeyLen
+	sessionKeyLen  = 32
+)
+
+// hello is the handshake message of Version2, both sides send one with a fresh ephemeral key.
+type hello struct {
+	version byte
+	suite   byte
+	pubKey  []byte
+}
+
+func (h *hello) marshal() (b []byte) {
+	b = make([]byte, 0, helloLen)
+	b = append(b, helloMagic...)
+	b = append(b, h.version, h.suite)
+	b = append(b, h.pubKey...)
+	return
+}
+
+// readHelloBody reads the hello after the magic.
+func readHelloBody(r io.Reader) (h *hello, err error) {
+	var buf = make([]byte, helloLen-len(helloMagic))
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+	h = &hello{
+		version: buf[0],
+		suite:   buf[1],
+		pubKey:  buf[2:],
+	}
+	if h.version != Version2 {
+		return nil, ErrUnsupportedVersion
+	}
+	return
+}
+
+func readHello(r io.Reader) (h *hello, err error) {
+	var magic = make([]byte, len(helloMagic))
+	if _, err = io.ReadFull(r, magic); err != nil {
+		return
+	}
+	if !bytes.Equal(magic, helloMagic) {
+		return nil, ErrInvalidHello
+	}
+	return readHelloBody(r)
+}
+
+var cipherSuites = map[byte]func(key []byte) (cipher.AEAD, error){
+	SuiteAES256GCM: newAESGCM,
+}
+
+func newAESGCM(key []byte) (aead cipher.AEAD, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+// handshake negotiates the ETLS version and the session keys once, the dialing side initiates it.
+// The accepting side also takes a Version1 peer if the cipher accepts the legacy peers.
+//
+// The Version2 handshake goes as:
+//
+//	client -> server: hello
+//	server -> client: hello, server finished
+//	client -> server: client finished
+//
+// in which the finished messages confirm that both sides derive the same session keys.
+func (c *Cipher) handshake(conn io.ReadWriter, isClient bool) (err error) {
+	c.handshakeLock.Lock()
+	defer c.handshakeLock.Unlock()
+	if c.negotiated != 0 || c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+	defer func() {
+		if err != nil {
+			c.handshakeErr = err
+		}
+	}()
+
+	if c.version == Version1 {
+		c.negotiated = Version1
+		return
+	}
+
+	var (
+		local  *ec.PrivateKey
+		remote *hello
+		mine   = &hello{version: Version2, suite: SuiteAES256GCM}
+		keys   *sessionKeys
+	)
+	if local, err = ec.NewPrivateKey(ec.S256()); err != nil {
+		return
+	}
+	mine.pubKey = local.PubKey().SerializeCompressed()
+
+	if isClient {
+		if _, err = conn.Write(mine.marshal()); err != nil {
+			return
+		}
+		if remote, err = readHello(conn); err != nil {
+			return
+		}
+		if remote.suite != mine.suite {
+			return ErrUnsupportedCipherSuite
+		}
+		if keys, err = c.deriveKeys(local, remote, mine, remote); err != nil {
+			return
+		}
+		if err = readFinished(conn, keys.serverFinished); err != nil {
+			return
+		}
+		if _, err = conn.Write(keys.clientFinished); err != nil {
+			return
+		}
+	} else {
+		var magic = make([]byte, len(helloMagic))
+		if _, err = io.ReadFull(conn, magic); err != nil {
+			return
+		}
+		if !bytes.Equal(magic, helloMagic) {
+			if !c.legacy {
+				return ErrUnsupportedVersion
+			}
+			// An old peer, the bytes read are the leading part of its IV
+			c.negotiated = Version1
+			c.pending = magic
+			return
+		}
+		if remote, err = readHelloBody(conn); err != nil {
+			return
+		}
+		if _, ok := cipherSuites[remote.suite]; !ok {
+			return ErrUnsupportedCipherSuite
+		}
+		mine.suite = remote.suite
+		if keys, err = c.deriveKeys(local, remote, remote, mine); err != nil {
+			return
+		}
+		if _, err = conn.Write(append(mine.marshal(), keys.serverFinished...)); err != nil {
+			return
+		}
+		if err = readFinished(conn, keys.clientFinished); err != nil {
+			return
+		}
+	}
+
+	var newAEAD = cipherSuites[mine.suite]
+	if isClient {
+		c.sealer, err = newAEAD(keys.clientWrite)
+	} else {
+		c.sealer, err = newAEAD(keys.serverWrite)
+	}
+	if err != nil {
+		return
+	}
+	if isClient {
+		c.opener, err = newAEAD(keys.serverWrite)
+	} else {
+		c.opener, err = newAEAD(keys.clientWrite)
+	}
+	if err != nil {
+		return
+	}
+	c.negotiated = Version2
+	return
+}
+
+// sessionKeys are the keys derived by a Version2 handshake.
+type sessionKeys struct {
+	// clientWrite and serverWrite key the client to server direction and the reverse one
+	clientWrite, serverWrite []byte
+	// clientFinished and serverFinished are the expected finished messages of both sides
+	clientFinished, serverFinished []byte
+}
+
+// deriveKeys derives the session keys from the ephemeral secret of the hellos, the static key is
+// mixed in as the salt so that only the expected peer can derive them.
+func (c *Cipher) deriveKeys(
+	local *ec.PrivateKey, remote, client, server *hello) (keys *sessionKeys, err error,
+) {
+	var pub *ec.PublicKey
+	if pub, err = ec.ParsePubKey(remote.pubKey, ec.S256()); err != nil {
+		return nil, ErrInvalidHello
+	}
+	var info []byte
+	info = append(info, "CovenantSQL ETLS v2"...)
+	info = append(info, server.suite)
+	info = append(info, client.pubKey...)
+	info = append(info, server.pubKey...)
+	var okm = hkdf(c.key, ec.GenerateSharedSecret(local, pub), info, 4*sessionKeyLen)
+	keys = &sessionKeys{
+		clientWrite:    okm[:sessionKeyLen],
+		serverWrite:    okm[sessionKeyLen : 2*sessionKeyLen],
+		clientFinished: finished(okm[2*sessionKeyLen:3*sessionKeyLen], info),
+		serverFinished: finished(okm[3*sessionKeyLen:], info),
+	}
+	return
+}
+
+// finished returns the finished message of the handshake transcript info with key.
+func finished(key, info []byte) []byte {
+	var mac = hmac.New(sha256.New, key)
+	mac.Write(info)
+	return mac.Sum(nil)
+}
+
+// readFinished reads the finished message of the peer and compares it with the expected one.
+func readFinished(r io.Reader, expected []byte) (err error) {
+	var buf = make([]byte, len(expected))
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+	if !hmac.Equal(buf, expected) {
+		return ErrKeyConfirmation
+	}
+	return
+}
+
+// hkdf implements the HMAC-SHA256 based key derivation function of RFC 5869.
+func hkdf(salt, secret, info []byte, length int) (okm []byte) {
+	var extractor = hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	var (
+		prk      = extractor.Sum(nil)
+		expander = hmac.New(sha256.New, prk)
+		t        []byte
+	)
+	for i := byte(1); len(okm) < length; i++ {
+		expander.Reset()
+		expander.Write(t)
+		expander.Write(info)
+		expander.Write([]byte{i})
+		t = expander.Sum(nil)
+		okm = append(okm, t...)
+	}
+	return okm[:length]
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etls
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestConnPair returns a handshaked pair of connections over a pipe.
+func newTestConnPair(clientCipher, serverCipher *Cipher) (client, server *CryptoConn) {
+	p1, p2 := net.Pipe()
+	client = NewClientConn(p1, clientCipher, nil)
+	server = NewConn(p2, serverCipher, nil)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.handshake(server.Conn, false)
+	}()
+	So(client.handshake(client.Conn, true), ShouldBeNil)
+	So(<-errCh, ShouldBeNil)
+	return
+}
+
+func TestCipher_Version2(t *testing.T) {
+	Convey("Given a pair of version 2 connections", t, func() {
+		client, server := newTestConnPair(NewCipher([]byte(pass)), NewCipher([]byte(pass)))
+		Reset(func() {
+			client.Close()
+			server.Close()
+		})
+		So(client.negotiated, ShouldEqual, Version2)
+		So(server.negotiated, ShouldEqual, Version2)
+
+		Convey("Data should be framed into records and read back", func() {
+			var (
+				data = bytes.Repeat([]byte("covenantsql"), 4*maxRecordPayload/11)
+				buf  bytes.Buffer
+				read = make([]byte, len(data))
+			)
+			n, err := client.writeRecords(&buf, data)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(data))
+			So(buf.Len(), ShouldBeGreaterThan, len(data))
+			_, err = io.ReadFull(readerFunc(func(b []byte) (int, error) {
+				return server.readRecords(&buf, b)
+			}), read)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, data)
+		})
+		Convey("Tampered records should be refused", func() {
+			var buf bytes.Buffer
+			_, err := client.writeRecords(&buf, []byte("transfer 100"))
+			So(err, ShouldBeNil)
+			record := buf.Bytes()
+			record[len(record)-1] ^= 0x1
+			_, err = server.readRecords(bytes.NewReader(record), make([]byte, 64))
+			So(err, ShouldEqual, ErrRecordAuthentication)
+			// the connection should not be used any more
+			_, err = server.readRecords(bytes.NewReader(nil), make([]byte, 64))
+			So(err, ShouldEqual, ErrRecordAuthentication)
+		})
+		Convey("Replayed records should be refused", func() {
+			var buf bytes.Buffer
+			_, err := client.writeRecords(&buf, []byte("transfer 100"))
+			So(err, ShouldBeNil)
+			record := append([]byte{}, buf.Bytes()...)
+			_, err = server.readRecords(&buf, make([]byte, 64))
+			So(err, ShouldBeNil)
+			_, err = server.readRecords(bytes.NewReader(record), make([]byte, 64))
+			So(err, ShouldEqual, ErrRecordAuthentication)
+		})
+		Convey("Each connection should have its own session keys", func() {
+			another, _ := newTestConnPair(NewCipher([]byte(pass)), NewCipher([]byte(pass)))
+			var b1, b2 bytes.Buffer
+			_, err := client.writeRecords(&b1, []byte("transfer 100"))
+			So(err, ShouldBeNil)
+			_, err = another.writeRecords(&b2, []byte("transfer 100"))
+			So(err, ShouldBeNil)
+			So(b1.Bytes(), ShouldNotResemble, b2.Bytes())
+		})
+	})
+	Convey("The server should accept version 1 peers", t, func() {
+		p1, p2 := net.Pipe()
+		client := NewClientConn(p1, NewLegacyCipher([]byte(pass)), nil)
+		server := NewConn(p2, NewCipher([]byte(pass)), nil)
+		Reset(func() {
+			client.Close()
+			server.Close()
+		})
+		go client.Write([]byte("legacy"))
+		read := make([]byte, 6)
+		_, err := io.ReadFull(server, read)
+		So(err, ShouldBeNil)
+		So(string(read), ShouldEqual, "legacy")
+		So(server.negotiated, ShouldEqual, Version1)
+	})
+	Convey("A strict server should refuse version 1 peers", t, func() {
+		p1, p2 := net.Pipe()
+		client := NewClientConn(p1, NewStrictCipher([]byte(pass)).Legacy(), nil)
+		server := NewConn(p2, NewStrictCipher([]byte(pass)), nil)
+		Reset(func() {
+			client.Close()
+			server.Close()
+		})
+		go client.Write([]byte("legacy"))
+		_, err := server.Read(make([]byte, 6))
+		So(err, ShouldEqual, ErrUnsupportedVersion)
+	})
+	Convey("The handshake should fail if the peers hold different keys", t, func() {
+		p1, p2 := net.Pipe()
+		client := NewClientConn(p1, NewCipher([]byte(pass)), nil)
+		server := NewConn(p2, NewCipher([]byte("another")), nil)
+		Reset(func() {
+			client.Close()
+			server.Close()
+		})
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- client.Handshake()
+			// unblock the server waiting for the client finished message
+			client.Close()
+		}()
+		So(server.Handshake(), ShouldNotBeNil)
+		So(<-errCh, ShouldEqual, ErrKeyConfirmation)
+	})
+	Convey("The server should refuse unsupported versions", t, func() {
+		p1, p2 := net.Pipe()
+		server := NewConn(p2, NewCipher([]byte(pass)), nil)
+		Reset(func() {
+			p1.Close()
+			server.Close()
+		})
+		h := &hello{version: 0x3, suite: SuiteAES256GCM, pubKey: make([]byte, helloPubKeyLen)}
+		go p1.Write(h.marshal())
+		_, err := server.Read(make([]byte, 1))
+		So(err, ShouldEqual, ErrUnsupportedVersion)
+	})
+}
+
+type readerFunc func(b []byte) (int, error)
+
+func (f readerFunc) Read(b []byte) (int, error) {
+	return f(b)
+}
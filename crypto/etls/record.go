@@ -0,0 +1,104 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etls
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+const (
+	// maxRecordPayload is the maximum plaintext size of a record.
+	maxRecordPayload = 16 * 1024
+	recordHeaderLen  = 2
+	recordNonceLen   = 12
+)
+
+// recordNonce returns the nonce of the record with sequence number seq. Since the sequence number
+// is never sent, a replayed, reordered or dropped record always fails the authentication.
+func recordNonce(seq uint64) (nonce []byte) {
+	nonce = make([]byte, recordNonceLen)
+	binary.BigEndian.PutUint64(nonce[recordNonceLen-8:], seq)
+	return
+}
+
+// writeRecords splits b into records, seals and writes them to w.
+func (c *Cipher) writeRecords(w io.Writer, b []byte) (n int, err error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	for len(b) > 0 {
+		var size = len(b)
+		if size > maxRecordPayload {
+			size = maxRecordPayload
+		}
+		if c.sendSeq == math.MaxUint64 {
+			return n, ErrSequenceOverflow
+		}
+		var (
+			ctLen  = size + c.sealer.Overhead()
+			record = make([]byte, recordHeaderLen, recordHeaderLen+ctLen)
+		)
+		// The header is authenticated as the additional data
+		binary.BigEndian.PutUint16(record, uint16(ctLen))
+		record = c.sealer.Seal(record, recordNonce(c.sendSeq), b[:size], record[:recordHeaderLen])
+		c.sendSeq++
+		if _, err = w.Write(record); err != nil {
+			return
+		}
+		n += size
+		b = b[size:]
+	}
+	return
+}
+
+// readRecords reads into b from the buffered plaintext, or reads and opens the next record from r
+// if the buffer is drained.
+func (c *Cipher) readRecords(r io.Reader, b []byte) (n int, err error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	if len(c.readBuf) == 0 {
+		var (
+			header = make([]byte, recordHeaderLen)
+			ct     []byte
+		)
+		if _, err = io.ReadFull(r, header); err != nil {
+			return
+		}
+		var ctLen = int(binary.BigEndian.Uint16(header))
+		if ctLen > maxRecordPayload+c.opener.Overhead() {
+			c.readErr = ErrRecordTooLarge
+			return 0, c.readErr
+		}
+		ct = make([]byte, ctLen)
+		if _, err = io.ReadFull(r, ct); err != nil {
+			return
+		}
+		if c.readBuf, err = c.opener.Open(ct[:0], recordNonce(c.recvSeq), ct, header); err != nil {
+			// The stream is no longer trustworthy
+			c.readErr = ErrRecordAuthentication
+			return 0, c.readErr
+		}
+		c.recvSeq++
+	}
+	n = copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return
+}
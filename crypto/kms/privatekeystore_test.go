@@ -23,6 +23,7 @@ import (
 	"os"
 
 	"io/ioutil"
+	"path/filepath"
 
 	"bytes"
 
@@ -42,8 +43,12 @@ func TestSaveLoadPrivateKey(t *testing.T) {
 		pass := ""
 		privateKeyBytes, _ := hex.DecodeString("f7c0bc718eb0df81e796a11e6f62e23cd2be0a4bdcca30df40d4d915cc3be3ff")
 		privateKey, _ := asymmetric.PrivKeyFromBytes(privateKeyBytes)
-		SavePrivateKey("private.key", privateKey, []byte(pass))
-		pl, _ := LoadPrivateKey("private.key", []byte(pass))
+		dir, err := ioutil.TempDir("", "privatekeystore")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "private.key")
+		SavePrivateKey(keyFile, privateKey, []byte(pass))
+		pl, _ := LoadPrivateKey(keyFile, []byte(pass))
 		So(bytes.Compare(pl.Serialize(), privateKey.Serialize()), ShouldBeZeroValue)
 		So(bytes.Compare(privateKeyBytes, privateKey.Serialize()), ShouldBeZeroValue)
 		publicKeyBytes, _ := hex.DecodeString("02c76216704d797c64c58bc11519fb68582e8e63de7e5b3b2dbbbe8733efe5fd24")
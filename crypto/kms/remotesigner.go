@@ -0,0 +1,186 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/ugorji/go/codec"
+)
+
+/*
+	The remote signer protocol is net/rpc with msgpack codec, usually served by a signing daemon
+	on a unix socket, so the private keys never enter the process using them. The daemon serves
+	the keys of a Wallet, the access to the socket should be restricted by file permission.
+
+		Signer.PubKey(RemoteSignerReq{Address}) -> RemoteSignerResp{PublicKey}
+		Signer.Sign(RemoteSignerReq{Address, Hash}) -> RemoteSignerResp{Signature}
+*/
+
+// RemoteSignerServiceName is the rpc service name of the remote signer protocol.
+const RemoteSignerServiceName = "Signer"
+
+var (
+	// ErrRemoteSignerMismatch indicates the remote signer returns the key of another address or
+	// an invalid signature
+	ErrRemoteSignerMismatch = errors.New("remote signer key mismatch")
+)
+
+// RemoteSignerReq defines the request of the remote signer protocol.
+type RemoteSignerReq struct {
+	Address proto.AccountAddress
+	Hash    []byte
+}
+
+// RemoteSignerResp defines the response of the remote signer protocol.
+type RemoteSignerResp struct {
+	PublicKey []byte
+	Signature []byte
+}
+
+// RemoteSignerService serves the keys of the wallet by the remote signer protocol.
+type RemoteSignerService struct {
+	wallet *Wallet
+}
+
+// NewRemoteSignerService returns a new RemoteSignerService of the wallet.
+func NewRemoteSignerService(w *Wallet) *RemoteSignerService {
+	return &RemoteSignerService{wallet: w}
+}
+
+// PubKey returns the public key of the address.
+func (s *RemoteSignerService) PubKey(req *RemoteSignerReq, resp *RemoteSignerResp) (err error) {
+	signer, err := s.wallet.Signer(req.Address)
+	if err != nil {
+		return
+	}
+	resp.PublicKey = signer.PubKey().Serialize()
+	return
+}
+
+// Sign signs the hash with the key of the address.
+func (s *RemoteSignerService) Sign(req *RemoteSignerReq, resp *RemoteSignerResp) (err error) {
+	signer, err := s.wallet.Signer(req.Address)
+	if err != nil {
+		return
+	}
+	sig, err := signer.Sign(req.Hash)
+	if err != nil {
+		return
+	}
+	resp.Signature = sig.Serialize()
+	return
+}
+
+// Serve serves the remote signer protocol on the listener until it's closed.
+func (s *RemoteSignerService) Serve(l net.Listener) (err error) {
+	server := rpc.NewServer()
+	if err = server.RegisterName(RemoteSignerServiceName, s); err != nil {
+		return
+	}
+	for {
+		var conn net.Conn
+		if conn, err = l.Accept(); err != nil {
+			log.WithError(err).Info("remote signer service stopped")
+			return
+		}
+		go server.ServeCodec(codec.MsgpackSpecRpc.ServerCodec(conn, newRemoteSignerHandle()))
+	}
+}
+
+// RemoteSigner is the signer of a key held by a signing daemon.
+type RemoteSigner struct {
+	client  *rpc.Client
+	address proto.AccountAddress
+	public  *asymmetric.PublicKey
+}
+
+// DialRemoteSigner connects to the signing daemon, and fetches the public key of the address.
+func DialRemoteSigner(network, address string, addr proto.AccountAddress) (s *RemoteSigner, err error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return
+	}
+	s = &RemoteSigner{
+		client:  rpc.NewClientWithCodec(codec.MsgpackSpecRpc.ClientCodec(conn, newRemoteSignerHandle())),
+		address: addr,
+	}
+	defer func() {
+		if err != nil {
+			s.Close()
+			s = nil
+		}
+	}()
+
+	var resp RemoteSignerResp
+	if err = s.client.Call(RemoteSignerServiceName+".PubKey", &RemoteSignerReq{Address: addr}, &resp); err != nil {
+		return
+	}
+	if s.public, err = asymmetric.ParsePubKey(resp.PublicKey); err != nil {
+		return
+	}
+	// the daemon must not swap the key of the address
+	var keyAddr proto.AccountAddress
+	if keyAddr, err = SignerAddress(s); err != nil {
+		return
+	}
+	if keyAddr != addr {
+		err = ErrRemoteSignerMismatch
+	}
+	return
+}
+
+// PubKey implements asymmetric.Signer.PubKey.
+func (s *RemoteSigner) PubKey() *asymmetric.PublicKey {
+	return s.public
+}
+
+// Sign implements asymmetric.Signer.Sign, the signature is verified before returning.
+func (s *RemoteSigner) Sign(hash []byte) (sig *asymmetric.Signature, err error) {
+	var resp RemoteSignerResp
+	req := &RemoteSignerReq{
+		Address: s.address,
+		Hash:    hash,
+	}
+	if err = s.client.Call(RemoteSignerServiceName+".Sign", req, &resp); err != nil {
+		return
+	}
+	if sig, err = asymmetric.ParseSignature(resp.Signature); err != nil {
+		return
+	}
+	if !sig.Verify(hash, s.public) {
+		return nil, ErrRemoteSignerMismatch
+	}
+	return
+}
+
+// Close closes the connection to the signing daemon.
+func (s *RemoteSigner) Close() error {
+	return s.client.Close()
+}
+
+func newRemoteSignerHandle() *codec.MsgpackHandle {
+	return &codec.MsgpackHandle{
+		WriteExt:    true,
+		RawToString: true,
+	}
+}
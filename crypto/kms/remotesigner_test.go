@@ -0,0 +1,69 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	Convey("keys of wallet should be served by remote signer", t, func() {
+		dir, err := ioutil.TempDir("", "remotesigner")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		w, err := OpenWallet(filepath.Join(dir, "wallet"), []byte(password))
+		So(err, ShouldBeNil)
+		addr1, err := w.NewKey()
+		So(err, ShouldBeNil)
+		addr2, err := w.NewKey()
+		So(err, ShouldBeNil)
+
+		sock := filepath.Join(dir, "signer.sock")
+		l, err := net.Listen("unix", sock)
+		So(err, ShouldBeNil)
+		defer l.Close()
+		go NewRemoteSignerService(w).Serve(l)
+
+		// several identities are held by one process
+		for _, addr := range []proto.AccountAddress{addr1, addr2} {
+			s, err := DialRemoteSigner("unix", sock, addr)
+			So(err, ShouldBeNil)
+			local, err := w.Signer(addr)
+			So(err, ShouldBeNil)
+			So(s.PubKey().IsEqual(local.PubKey()), ShouldBeTrue)
+
+			h := hash.THashH(addr[:])
+			sig, err := s.Sign(h[:])
+			So(err, ShouldBeNil)
+			So(sig.Verify(h[:], local.PubKey()), ShouldBeTrue)
+			So(s.Close(), ShouldBeNil)
+		}
+
+		_, err = DialRemoteSigner("unix", sock, proto.AccountAddress{})
+		So(err, ShouldNotBeNil)
+		_, err = DialRemoteSigner("unix", filepath.Join(dir, "notexist.sock"), addr1)
+		So(err, ShouldNotBeNil)
+	})
+}
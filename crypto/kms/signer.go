@@ -0,0 +1,56 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+/*
+	An identity signs with an asymmetric.Signer, so one process can hold several of them:
+
+		- the local key pair set by InitLocalKeyPair, returned by GetLocalSigner
+		- a key file encrypted by master key, loaded by LoadFileSigner
+		- the keys of an encrypted Wallet, returned by Wallet.Signer
+		- a key held by a signing daemon, dialed by DialRemoteSigner
+*/
+
+// GetLocalSigner returns the signer of local key pair.
+func GetLocalSigner() (signer asymmetric.Signer, err error) {
+	var private *asymmetric.PrivateKey
+	if private, err = GetLocalPrivateKey(); err != nil {
+		return
+	}
+	return private, nil
+}
+
+// LoadFileSigner loads the signer from the private key file encrypted by masterKey, the file is
+// in the format of SavePrivateKey.
+func LoadFileSigner(keyFilePath string, masterKey []byte) (signer asymmetric.Signer, err error) {
+	var private *asymmetric.PrivateKey
+	if private, err = LoadPrivateKey(keyFilePath, masterKey); err != nil {
+		return
+	}
+	return private, nil
+}
+
+// SignerAddress returns the account address of the signer.
+func SignerAddress(signer asymmetric.Signer) (addr proto.AccountAddress, err error) {
+	return utils.PubKeyHash(signer.PubKey())
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const walletRecordLen = hash.HashBSize + asymmetric.PrivateKeyBytesLen
+
+var (
+	// ErrNotWalletFile indicates specified wallet file is corrupted
+	ErrNotWalletFile = errors.New("wallet file corrupted")
+	// ErrWalletKeyNotFound indicates the wallet has no key of the address
+	ErrWalletKeyNotFound = errors.New("key not found in wallet")
+)
+
+// Wallet is a set of private keys saved in one file encrypted by master key, each key is an
+// identity of the process. The file is the concatenation of the records in the format of
+// SavePrivateKey: sha256 + privateKey.
+type Wallet struct {
+	path      string
+	masterKey []byte
+	keys      map[proto.AccountAddress]*asymmetric.PrivateKey
+	addrs     []proto.AccountAddress
+	sync.RWMutex
+}
+
+// OpenWallet loads the wallet from walletPath, an empty wallet is returned if the file does
+// not exist, it's created when the first key is added.
+func OpenWallet(walletPath string, masterKey []byte) (w *Wallet, err error) {
+	w = &Wallet{
+		path:      walletPath,
+		masterKey: masterKey,
+		keys:      make(map[proto.AccountAddress]*asymmetric.PrivateKey),
+	}
+
+	fileContent, err := ioutil.ReadFile(walletPath)
+	if os.IsNotExist(err) {
+		return w, nil
+	} else if err != nil {
+		return nil, err
+	}
+	decData, err := symmetric.DecryptWithPassword(fileContent, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(decData)%walletRecordLen != 0 {
+		return nil, ErrNotWalletFile
+	}
+
+	for i := 0; i < len(decData); i += walletRecordLen {
+		record := decData[i : i+walletRecordLen]
+		if !bytes.Equal(hash.DoubleHashB(record[hash.HashBSize:]), record[:hash.HashBSize]) {
+			return nil, ErrHashNotMatch
+		}
+		key, _ := asymmetric.PrivKeyFromBytes(record[hash.HashBSize:])
+		if _, err = w.add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// NewKey generates a new key in the wallet and saves the wallet.
+func (w *Wallet) NewKey() (addr proto.AccountAddress, err error) {
+	key, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		return
+	}
+	return w.ImportKey(key)
+}
+
+// ImportKey adds the key to the wallet and saves the wallet.
+func (w *Wallet) ImportKey(key *asymmetric.PrivateKey) (addr proto.AccountAddress, err error) {
+	w.Lock()
+	defer w.Unlock()
+	if addr, err = w.add(key); err != nil {
+		return
+	}
+	err = w.save()
+	return
+}
+
+// RemoveKey removes the key of the address from the wallet and saves the wallet.
+func (w *Wallet) RemoveKey(addr proto.AccountAddress) (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.keys[addr]; !ok {
+		return ErrWalletKeyNotFound
+	}
+	delete(w.keys, addr)
+	for i := range w.addrs {
+		if w.addrs[i] == addr {
+			w.addrs = append(w.addrs[:i], w.addrs[i+1:]...)
+			break
+		}
+	}
+	return w.save()
+}
+
+// Addresses returns the account addresses of the keys in the order of adding.
+func (w *Wallet) Addresses() (addrs []proto.AccountAddress) {
+	w.RLock()
+	defer w.RUnlock()
+	addrs = make([]proto.AccountAddress, len(w.addrs))
+	copy(addrs, w.addrs)
+	return
+}
+
+// Signer returns the signer of the address.
+func (w *Wallet) Signer(addr proto.AccountAddress) (signer asymmetric.Signer, err error) {
+	w.RLock()
+	defer w.RUnlock()
+	key, ok := w.keys[addr]
+	if !ok {
+		return nil, ErrWalletKeyNotFound
+	}
+	return key, nil
+}
+
+// add adds the key without saving, adding an existing key is a no-op.
+func (w *Wallet) add(key *asymmetric.PrivateKey) (addr proto.AccountAddress, err error) {
+	if addr, err = SignerAddress(key); err != nil {
+		return
+	}
+	if _, ok := w.keys[addr]; !ok {
+		w.keys[addr] = key
+		w.addrs = append(w.addrs, addr)
+	}
+	return
+}
+
+// save writes the wallet to a temp file and renames it, so the wallet is never half written.
+func (w *Wallet) save() (err error) {
+	rawData := make([]byte, 0, len(w.addrs)*walletRecordLen)
+	for _, addr := range w.addrs {
+		serializedKey := w.keys[addr].Serialize()
+		rawData = append(rawData, hash.DoubleHashB(serializedKey)...)
+		rawData = append(rawData, serializedKey...)
+	}
+	encData, err := symmetric.EncryptWithPassword(rawData, w.masterKey)
+	if err != nil {
+		return
+	}
+	tmpPath := w.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, encData, 0600); err != nil {
+		return
+	}
+	return os.Rename(tmpPath, w.path)
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const walletPath = "./.testwallet"
+
+func TestWallet(t *testing.T) {
+	Convey("keys should be saved and loaded with wallet", t, func() {
+		defer os.Remove(walletPath)
+		w, err := OpenWallet(walletPath, []byte(password))
+		So(err, ShouldBeNil)
+		So(w.Addresses(), ShouldBeEmpty)
+
+		addr1, err := w.NewKey()
+		So(err, ShouldBeNil)
+		pk, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		addr2, err := w.ImportKey(pk)
+		So(err, ShouldBeNil)
+		addr, err := w.ImportKey(pk)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, addr2)
+		expected, err := utils.PubKeyHash(pk.PubKey())
+		So(err, ShouldBeNil)
+		So(addr2, ShouldEqual, expected)
+
+		w, err = OpenWallet(walletPath, []byte(password))
+		So(err, ShouldBeNil)
+		So(w.Addresses(), ShouldResemble, []proto.AccountAddress{addr1, addr2})
+		signer, err := w.Signer(addr2)
+		So(err, ShouldBeNil)
+		So(signer.PubKey().IsEqual(pk.PubKey()), ShouldBeTrue)
+		h := hash.THashH([]byte("data"))
+		sig, err := signer.Sign(h[:])
+		So(err, ShouldBeNil)
+		So(sig.Verify(h[:], pk.PubKey()), ShouldBeTrue)
+
+		So(w.RemoveKey(addr1), ShouldBeNil)
+		So(w.RemoveKey(addr1), ShouldEqual, ErrWalletKeyNotFound)
+		_, err = w.Signer(addr1)
+		So(err, ShouldEqual, ErrWalletKeyNotFound)
+		w, err = OpenWallet(walletPath, []byte(password))
+		So(err, ShouldBeNil)
+		So(w.Addresses(), ShouldResemble, []proto.AccountAddress{addr2})
+
+		_, err = OpenWallet(walletPath, []byte("wrong"))
+		So(err, ShouldNotBeNil)
+	})
+	Convey("corrupted wallet should not be loaded", t, func() {
+		defer os.Remove(walletPath)
+		enc, _ := symmetric.EncryptWithPassword([]byte("aa"), []byte(password))
+		ioutil.WriteFile(walletPath, enc, 0600)
+		_, err := OpenWallet(walletPath, []byte(password))
+		So(err, ShouldEqual, ErrNotWalletFile)
+
+		record := make([]byte, walletRecordLen)
+		enc, _ = symmetric.EncryptWithPassword(record, []byte(password))
+		ioutil.WriteFile(walletPath, enc, 0600)
+		_, err = OpenWallet(walletPath, []byte(password))
+		So(err, ShouldEqual, ErrHashNotMatch)
+	})
+	Convey("private key file should be loaded as signer", t, func() {
+		defer os.Remove(privateKeyPath)
+		pk, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		So(SavePrivateKey(privateKeyPath, pk, []byte(password)), ShouldBeNil)
+		signer, err := LoadFileSigner(privateKeyPath, []byte(password))
+		So(err, ShouldBeNil)
+		So(signer.PubKey().IsEqual(pk.PubKey()), ShouldBeTrue)
+		signer, err = LoadFileSigner("/path/not/exist", []byte(password))
+		So(err, ShouldNotBeNil)
+		So(signer, ShouldBeNil)
+	})
+}
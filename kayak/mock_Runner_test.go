@@ -2,27 +2,31 @@
 
 package kayak
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	"context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // MockRunner is an autogenerated mock type for the Runner type
 type MockRunner struct {
 	mock.Mock
 }
 
-// Apply provides a mock function with given fields: data
-func (_m *MockRunner) Apply(data []byte) (uint64, error) {
-	ret := _m.Called(data)
+// Apply provides a mock function with given fields: ctx, data
+func (_m *MockRunner) Apply(ctx context.Context, data []byte) (uint64, error) {
+	ret := _m.Called(ctx, data)
 
 	var r0 uint64
-	if rf, ok := ret.Get(0).(func([]byte) uint64); ok {
-		r0 = rf(data)
+	if rf, ok := ret.Get(0).(func(context.Context, []byte) uint64); ok {
+		r0 = rf(ctx, data)
 	} else {
 		r0 = ret.Get(0).(uint64)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func([]byte) error); ok {
-		r1 = rf(data)
+	if rf, ok := ret.Get(1).(func(context.Context, []byte) error); ok {
+		r1 = rf(ctx, data)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -17,6 +17,7 @@
 package kayak
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 
@@ -120,12 +121,18 @@ func (r *Runtime) Shutdown() (err error) {
 
 // Apply defines common process logic.
 func (r *Runtime) Apply(data []byte) (offset uint64, err error) {
+	return r.ApplyWithContext(context.Background(), data)
+}
+
+// ApplyWithContext defines common process logic, the spans of the process are recorded as
+// children of the span in ctx.
+func (r *Runtime) ApplyWithContext(ctx context.Context, data []byte) (offset uint64, err error) {
 	// validate if myself is leader
 	if !r.isLeader {
 		return 0, ErrNotLeader
 	}
 
-	offset, err = r.config.Runner.Apply(data)
+	offset, err = r.config.Runner.Apply(ctx, data)
 	if err != nil {
 		return 0, err
 	}
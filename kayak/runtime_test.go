@@ -187,7 +187,7 @@ func TestRuntimeAll(t *testing.T) {
 			So(r.logStore, ShouldNotBeNil)
 
 			// run process
-			runner.On("Apply", mock.Anything).Return(uint64(1), nil)
+			runner.On("Apply", mock.Anything, mock.Anything).Return(uint64(1), nil)
 
 			_, err = r.Apply([]byte("test"))
 			So(err, ShouldBeNil)
@@ -245,7 +245,7 @@ func TestRuntimeAll(t *testing.T) {
 		).Return(nil)
 		runner.On("Shutdown", mock.Anything).
 			Return(nil)
-		runner.On("Apply", mock.Anything).Return(uint64(1), nil)
+		runner.On("Apply", mock.Anything, mock.Anything).Return(uint64(1), nil)
 
 		err = r.Init()
 		So(err, ShouldBeNil)
@@ -69,6 +69,10 @@ func testWithNewNode() (mock *mockRes, err error) {
 	if err != nil {
 		return
 	}
+	// mock nodes are registered without roles
+	acl := route.DefaultACL()
+	acl["Kayak.Call"] = route.RegisteredCaller
+	mock.server.SetACL(acl)
 	_, testFile, _, _ := runtime.Caller(0)
 	privKeyPath := filepath.Join(filepath.Dir(testFile), "../../test/node_standalone/private.key")
 	if err = mock.server.InitRPCServer(addr, privKeyPath, []byte("")); err != nil {
@@ -26,6 +26,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/twopc"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 )
 
 var (
@@ -44,6 +45,11 @@ type TwoPCConfig struct {
 	Storage twopc.Worker
 }
 
+type applyRequest struct {
+	ctx  context.Context
+	data []byte
+}
+
 type logProcessResult struct {
 	offset uint64
 	err    error
@@ -74,7 +80,7 @@ type TwoPCRunner struct {
 
 	// Lock/events
 	processLock     sync.Mutex
-	processReq      chan []byte
+	processReq      chan *applyRequest
 	processRes      chan logProcessResult
 	updatePeersLock sync.Mutex
 	updatePeersReq  chan *Peers
@@ -98,7 +104,7 @@ type TwoPCWorkerWrapper struct {
 func NewTwoPCRunner() *TwoPCRunner {
 	return &TwoPCRunner{
 		shutdownCh:     make(chan struct{}),
-		processReq:     make(chan []byte),
+		processReq:     make(chan *applyRequest),
 		processRes:     make(chan logProcessResult),
 		updatePeersReq: make(chan *Peers),
 		updatePeersRes: make(chan error),
@@ -277,7 +283,7 @@ func (r *TwoPCRunner) UpdatePeers(peers *Peers) error {
 }
 
 // Apply implements Runner.Apply.
-func (r *TwoPCRunner) Apply(data []byte) (uint64, error) {
+func (r *TwoPCRunner) Apply(ctx context.Context, data []byte) (uint64, error) {
 	r.processLock.Lock()
 	defer r.processLock.Unlock()
 
@@ -286,7 +292,7 @@ func (r *TwoPCRunner) Apply(data []byte) (uint64, error) {
 		return 0, ErrNotLeader
 	}
 
-	r.processReq <- data
+	r.processReq <- &applyRequest{ctx: ctx, data: data}
 	res := <-r.processRes
 
 	return res.offset, res.err
@@ -315,8 +321,8 @@ func (r *TwoPCRunner) run() {
 		case <-r.shutdownCh:
 			// TODO(xq262144): cleanup logic
 			return
-		case data := <-r.processReq:
-			r.processRes <- r.processNewLog(data)
+		case req := <-r.processReq:
+			r.processRes <- r.processNewLog(req.ctx, req.data)
 		case request := <-r.transport.Process():
 			r.processRequest(request)
 			// TODO(xq262144): support timeout logic for auto rollback prepared transaction on leader change
@@ -334,7 +340,16 @@ func (r *TwoPCRunner) safeForPeersUpdate() chan *Peers {
 	return nil
 }
 
-func (r *TwoPCRunner) processNewLog(data []byte) (res logProcessResult) {
+func (r *TwoPCRunner) processNewLog(ctx context.Context, data []byte) (res logProcessResult) {
+	// only inherit the span of the caller, the process should not be canceled by the caller
+	ctx, span := trace.StartSpan(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)),
+		"kayak.Apply", trace.SpanKindInternal)
+	defer func() {
+		span.SetAttribute("kayak.index", res.offset)
+		span.SetError(res.err)
+		span.End()
+	}()
+
 	// build Log
 	l := &Log{
 		Index:    r.lastLogIndex + 1,
@@ -347,30 +362,36 @@ func (r *TwoPCRunner) processNewLog(data []byte) (res logProcessResult) {
 	l.ComputeHash()
 
 	localPrepare := func(ctx context.Context) error {
-		// prepare local prepare node
-		if err := r.config.Storage.Prepare(ctx, l.Data); err != nil {
-			return err
-		}
+		return trace.WithSpan(ctx, "kayak.LocalPrepare", func(ctx context.Context) error {
+			// prepare local prepare node
+			if err := r.config.Storage.Prepare(ctx, l.Data); err != nil {
+				return err
+			}
 
-		// write log to storage
-		return r.logStore.StoreLog(l)
+			// write log to storage
+			return r.logStore.StoreLog(l)
+		})
 	}
 
 	localRollback := func(ctx context.Context) error {
-		// prepare local rollback node
-		r.logStore.DeleteRange(r.lastLogIndex+1, l.Index)
-		return r.config.Storage.Rollback(ctx, l.Data)
+		return trace.WithSpan(ctx, "kayak.LocalRollback", func(ctx context.Context) error {
+			// prepare local rollback node
+			r.logStore.DeleteRange(r.lastLogIndex+1, l.Index)
+			return r.config.Storage.Rollback(ctx, l.Data)
+		})
 	}
 
-	localCommit := func(ctx context.Context) (err error) {
-		err = r.config.Storage.Commit(ctx, l.Data)
+	localCommit := func(ctx context.Context) error {
+		return trace.WithSpan(ctx, "kayak.LocalCommit", func(ctx context.Context) (err error) {
+			err = r.config.Storage.Commit(ctx, l.Data)
 
-		r.stableStore.SetUint64(keyCommittedIndex, l.Index)
-		r.lastLogHash = &l.Hash
-		r.lastLogIndex = l.Index
-		r.lastLogTerm = l.Term
+			r.stableStore.SetUint64(keyCommittedIndex, l.Index)
+			r.lastLogHash = &l.Hash
+			r.lastLogIndex = l.Index
+			r.lastLogTerm = l.Term
 
-		return
+			return
+		})
 	}
 
 	// build 2PC workers
@@ -392,12 +413,12 @@ func (r *TwoPCRunner) processNewLog(data []byte) (res logProcessResult) {
 			localCommit,   // after all remote nodes commit
 		))
 
-		res.err = c.Put(nodes, l)
+		res.err = c.PutContext(ctx, nodes, l)
 		res.offset = r.lastLogIndex
 	} else {
 		// single node short cut
 		// init context
-		ctx, cancel := context.WithTimeout(context.Background(), r.config.ProcessTimeout)
+		ctx, cancel := context.WithTimeout(ctx, r.config.ProcessTimeout)
 		defer cancel()
 
 		if err := localPrepare(ctx); err != nil {
@@ -428,6 +449,12 @@ func (r *TwoPCRunner) getState() ServerState {
 }
 
 func (r *TwoPCRunner) processRequest(req Request) {
+	ctx, span := trace.StartSpan(requestContext(req), "kayak."+req.GetMethod(), trace.SpanKindInternal)
+	if span != nil {
+		span.SetAttribute("kayak.leader", string(req.GetPeerNodeID()))
+		req = &tracedRequest{Request: req, span: span}
+	}
+
 	// verify call from leader
 	if err := r.verifyLeader(req); err != nil {
 		req.SendResponse(nil, err)
@@ -436,11 +463,11 @@ func (r *TwoPCRunner) processRequest(req Request) {
 
 	switch req.GetMethod() {
 	case "Prepare":
-		r.processPrepare(req)
+		r.processPrepare(ctx, req)
 	case "Commit":
-		r.processCommit(req)
+		r.processCommit(ctx, req)
 	case "Rollback":
-		r.processRollback(req)
+		r.processRollback(ctx, req)
 	default:
 		req.SendResponse(nil, ErrInvalidRequest)
 	}
@@ -501,7 +528,7 @@ func (r *TwoPCRunner) verifyLog(req Request) (log *Log, err error) {
 	return
 }
 
-func (r *TwoPCRunner) processPrepare(req Request) {
+func (r *TwoPCRunner) processPrepare(ctx context.Context, req Request) {
 	req.SendResponse(nil, func() (err error) {
 		// already in transaction, try abort previous
 		if r.getState() != Idle {
@@ -510,7 +537,8 @@ func (r *TwoPCRunner) processPrepare(req Request) {
 		}
 
 		// init context
-		r.currentContext, _ = context.WithTimeout(context.Background(), r.config.ProcessTimeout)
+		r.currentContext, _ = context.WithTimeout(
+			trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), r.config.ProcessTimeout)
 
 		// get log
 		var l *Log
@@ -559,7 +587,7 @@ func (r *TwoPCRunner) processPrepare(req Request) {
 	}())
 }
 
-func (r *TwoPCRunner) processCommit(req Request) {
+func (r *TwoPCRunner) processCommit(ctx context.Context, req Request) {
 	// commit log
 	req.SendResponse(nil, func() (err error) {
 		// TODO(xq262144): check current running transaction index
@@ -595,7 +623,7 @@ func (r *TwoPCRunner) processCommit(req Request) {
 
 		// commit on storage
 		// return err but still commit local index
-		err = r.config.Storage.Commit(r.currentContext, l.Data)
+		err = r.config.Storage.Commit(storageContext(r.currentContext, ctx), l.Data)
 
 		// commit log
 		r.stableStore.SetUint64(keyCommittedIndex, l.Index)
@@ -610,7 +638,7 @@ func (r *TwoPCRunner) processCommit(req Request) {
 	}())
 }
 
-func (r *TwoPCRunner) processRollback(req Request) {
+func (r *TwoPCRunner) processRollback(ctx context.Context, req Request) {
 	// rollback log
 	req.SendResponse(nil, func() (err error) {
 		// TODO(xq262144): check current running transaction index
@@ -645,7 +673,7 @@ func (r *TwoPCRunner) processRollback(req Request) {
 		}
 
 		// rollback on storage
-		if err = r.config.Storage.Rollback(r.currentContext, l.Data); err != nil {
+		if err = r.config.Storage.Rollback(storageContext(r.currentContext, ctx), l.Data); err != nil {
 			return
 		}
 
@@ -716,6 +744,33 @@ func (tpww *TwoPCWorkerWrapper) callRemote(ctx context.Context, method string, l
 	return
 }
 
+// tracedRequest ends the span of the request on sending response.
+type tracedRequest struct {
+	Request
+	span *trace.Span
+}
+
+// SendResponse implements Request.SendResponse.
+func (r *tracedRequest) SendResponse(resp []byte, err error) error {
+	r.span.SetError(err)
+	r.span.End()
+	return r.Request.SendResponse(resp, err)
+}
+
+// requestContext returns the context carrying the trace of the remote leader if the request is
+// received by a transport supporting trace propagation.
+func requestContext(req Request) context.Context {
+	if t, ok := req.(interface{ GetTraceParent() string }); ok {
+		return trace.ContextWithTraceParent(context.Background(), t.GetTraceParent())
+	}
+	return context.Background()
+}
+
+// storageContext returns the transaction context carrying the span of the current request.
+func storageContext(txCtx context.Context, reqCtx context.Context) context.Context {
+	return trace.ContextWithSpan(txCtx, trace.SpanFromContext(reqCtx))
+}
+
 func nestedTimeoutCtx(ctx context.Context, timeout time.Duration, process func(context.Context) error) error {
 	nestedCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
@@ -280,7 +280,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 
 		// try call process
 		testPayload := []byte("test data")
-		_, err = mockRes.runner.Apply(testPayload)
+		_, err = mockRes.runner.Apply(context.Background(), testPayload)
 		So(err, ShouldNotBeNil)
 		So(err, ShouldEqual, ErrNotLeader)
 	})
@@ -327,7 +327,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 
 			// try call process
 			var offset uint64
-			offset, err = mockRes.runner.Apply(testPayload)
+			offset, err = mockRes.runner.Apply(context.Background(), testPayload)
 			So(err, ShouldBeNil)
 			So(offset, ShouldEqual, uint64(1))
 
@@ -364,7 +364,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 			})
 
 			// try call process
-			_, err = mockRes.runner.Apply(testPayload)
+			_, err = mockRes.runner.Apply(context.Background(), testPayload)
 			So(err, ShouldNotBeNil)
 
 			// no log should be written to local log store after failed preparing
@@ -388,7 +388,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 			mockRes.logStore.On("DeleteRange", uint64(1), uint64(1)).Return(nil)
 
 			// try call process
-			_, err = mockRes.runner.Apply(testPayload)
+			_, err = mockRes.runner.Apply(context.Background(), testPayload)
 
 			So(err, ShouldNotBeNil)
 		})
@@ -409,7 +409,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 				Return(nil)
 
 			// try call process
-			_, err = mockRes.runner.Apply(testPayload)
+			_, err = mockRes.runner.Apply(context.Background(), testPayload)
 
 			So(err, ShouldNotBeNil)
 		})
@@ -430,7 +430,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 			mockRes.logStore.On("DeleteRange", uint64(1), uint64(1)).Return(nil)
 
 			// try call process
-			_, err = mockRes.runner.Apply(testPayload)
+			_, err = mockRes.runner.Apply(context.Background(), testPayload)
 
 			// rollback error is ignored
 			So(err, ShouldNotBeNil)
@@ -500,7 +500,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 			})
 
 			// try call process
-			_, err := lMock.runner.Apply(testPayload)
+			_, err := lMock.runner.Apply(context.Background(), testPayload)
 
 			So(err, ShouldBeNil)
 
@@ -535,7 +535,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 			// commit second log
 			callOrder.Reset()
 
-			_, err = lMock.runner.Apply(testPayload)
+			_, err = lMock.runner.Apply(context.Background(), testPayload)
 
 			So(err, ShouldBeNil)
 
@@ -597,7 +597,7 @@ func TestTwoPCRunner_Apply(t *testing.T) {
 			})
 
 			// try call process
-			_, err := lMock.runner.Apply(testPayload)
+			_, err := lMock.runner.Apply(context.Background(), testPayload)
 
 			So(err, ShouldNotBeNil)
 			So(err, ShouldEqual, unknownErr)
@@ -951,7 +951,7 @@ func TestTwoPCRunner_UpdatePeers(t *testing.T) {
 
 			// test call process
 			testPayload := []byte("test data")
-			_, err := lMock.runner.Apply(testPayload)
+			_, err := lMock.runner.Apply(context.Background(), testPayload)
 
 			// no longer leader
 			So(err, ShouldNotBeNil)
@@ -220,7 +220,7 @@ func (c *Peers) Serialize() []byte {
 }
 
 // Sign generates signature.
-func (c *Peers) Sign(signer *asymmetric.PrivateKey) error {
+func (c *Peers) Sign(signer asymmetric.Signer) error {
 	sig, err := signer.Sign(c.Serialize())
 
 	if err != nil {
@@ -316,7 +316,7 @@ type Runner interface {
 
 	// Apply defines log replication and log commit logic
 	// and should be called by Leader role only.
-	Apply(data []byte) (uint64, error)
+	Apply(ctx context.Context, data []byte) (uint64, error)
 
 	// Shutdown defines destruct logic.
 	Shutdown(wait bool) error
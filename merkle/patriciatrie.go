@@ -20,38 +20,275 @@ import (
 	"errors"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
-	"github.com/tchap/go-patricia/patricia"
 )
 
-// Trie is a patricia trie
+// Trie is a binary patricia trie over the bits of the hashed keys. The nodes are immutable and
+// cache their hashes, so that an update only rehashes the path of the updated key, and a copy of
+// the trie shares all its nodes with the original one.
 type Trie struct {
-	trie *patricia.Trie
+	root *trieNode
 }
 
 // NewPatricia is patricia construction
 func NewPatricia() *Trie {
-	trie := patricia.NewTrie(patricia.MaxPrefixPerNode(16), patricia.MaxChildrenPerSparseNode(17))
-	return &Trie{trie}
+	return &Trie{}
+}
+
+// Copy returns a copy of the trie, which can be updated independently of the original one.
+func (trie *Trie) Copy() *Trie {
+	return &Trie{root: trie.root}
 }
 
 // Insert serializes key into binary and computes its hash,
-// then stores the (hash(key), value) into the trie
+// then stores the (hash(key), value) into the trie if the key does not exist yet
 func (trie *Trie) Insert(key []byte, value []byte) (inserted bool) {
-	hashedKey := hash.HashB(key)
+	if _, err := trie.Get(key); err == nil {
+		return false
+	}
+	trie.Update(key, value)
+	return true
+}
 
-	inserted = trie.trie.Insert(hashedKey, value)
+// Update stores the (hash(key), value) into the trie, overwriting the value of the key if it
+// exists.
+func (trie *Trie) Update(key []byte, value []byte) {
+	var l = &trieLeaf{
+		key:   hash.HashH(key),
+		value: hash.THashH(value),
+		raw:   value,
+	}
+	trie.root = insertNode(trie.root, l, 0)
+}
+
+// Delete removes the key from the trie, and returns whether the key existed.
+func (trie *Trie) Delete(key []byte) (deleted bool) {
+	var k = hash.HashH(key)
+	trie.root, deleted = deleteNode(trie.root, &k, 0)
 	return
 }
 
 // Get returns the value according to the key
 func (trie *Trie) Get(key []byte) ([]byte, error) {
-	hashedKey := hash.HashB(key)
-
-	rawValue := trie.trie.Get(hashedKey)
-	if rawValue == nil {
+	var (
+		k = hash.HashH(key)
+		n = trie.root
+	)
+	for depth := 0; n != nil && n.leaf == nil; depth++ {
+		n = n.child(bitAt(&k, depth))
+	}
+	if n == nil || !n.leaf.key.IsEqual(&k) {
 		return nil, errors.New("no such key")
 	}
-	value := rawValue.([]byte)
+	return n.leaf.raw, nil
+}
+
+// Root returns the merkle root of the trie. The root commits to all the (hash(key), value) pairs
+// as a binary tree over the bits of the hashed keys, in which a subtree holding a single pair is
+// represented by its leaf hash directly. The root of an empty trie is the zero hash.
+func (trie *Trie) Root() hash.Hash {
+	return trie.root.hash()
+}
+
+// Prove returns the merkle proof of the key against the current root of the trie, which proves
+// either the value of the key or the absence of it.
+func (trie *Trie) Prove(key []byte) (proof *Proof) {
+	var (
+		k = hash.HashH(key)
+		n = trie.root
+	)
+	proof = &Proof{}
+	for depth := 0; n != nil; depth++ {
+		if n.leaf != nil {
+			proof.HasLeaf = true
+			proof.LeafKey = n.leaf.key
+			proof.LeafValue = n.leaf.value
+			return
+		}
+		var b = bitAt(&k, depth)
+		proof.Siblings = append(proof.Siblings, n.child(1-b).hash())
+		n = n.child(b)
+	}
+	return
+}
+
+// Proof is a merkle proof of a key in the trie.
+type Proof struct {
+	// Siblings are the roots of the sibling subtrees along the path of the hashed key, from the
+	// trie root down.
+	Siblings []hash.Hash
+	// HasLeaf indicates that the path ends with a leaf instead of an empty subtree, and LeafKey
+	// and LeafValue are the hashed key and the value hash of the leaf.
+	HasLeaf   bool
+	LeafKey   hash.Hash
+	LeafValue hash.Hash
+}
+
+// Verify returns whether the proof proves that the key has the value in the trie of the root. A
+// nil value verifies that the key is absent.
+func (p *Proof) Verify(root *hash.Hash, key []byte, value []byte) bool {
+	var (
+		k = hash.HashH(key)
+		n = len(p.Siblings)
+		h hash.Hash
+	)
+	if n > hash.HashSize*8 {
+		return false
+	}
+	if value != nil {
+		v := hash.THashH(value)
+		if !p.HasLeaf || !p.LeafKey.IsEqual(&k) || !p.LeafValue.IsEqual(&v) {
+			return false
+		}
+		h = leafHash(&p.LeafKey, &p.LeafValue)
+	} else if p.HasLeaf {
+		// Another key occupies the path, which must share the path with the absent key
+		if p.LeafKey.IsEqual(&k) {
+			return false
+		}
+		for i := 0; i < n; i++ {
+			if bitAt(&p.LeafKey, i) != bitAt(&k, i) {
+				return false
+			}
+		}
+		h = leafHash(&p.LeafKey, &p.LeafValue)
+	}
+	for i := n - 1; i >= 0; i-- {
+		if bitAt(&k, i) == 0 {
+			h = branchHash(&h, &p.Siblings[i])
+		} else {
+			h = branchHash(&p.Siblings[i], &h)
+		}
+	}
+	return h.IsEqual(root)
+}
+
+type trieLeaf struct {
+	key   hash.Hash
+	value hash.Hash
+	raw   []byte
+}
+
+// trieNode is either a leaf or a branch of which at least one child is not empty. A branch
+// always holds more than one leaf in its subtree, or it would be represented by its only leaf.
+// A nil node is an empty subtree.
+type trieNode struct {
+	leaf        *trieLeaf
+	left, right *trieNode
+	h           hash.Hash
+}
+
+func newLeafNode(l *trieLeaf) *trieNode {
+	return &trieNode{leaf: l, h: leafHash(&l.key, &l.value)}
+}
+
+func newBranchNode(left, right *trieNode) *trieNode {
+	var (
+		l = left.hash()
+		r = right.hash()
+	)
+	return &trieNode{left: left, right: right, h: branchHash(&l, &r)}
+}
+
+func (n *trieNode) hash() hash.Hash {
+	if n == nil {
+		return hash.Hash{}
+	}
+	return n.h
+}
+
+func (n *trieNode) child(b byte) *trieNode {
+	if b == 0 {
+		return n.left
+	}
+	return n.right
+}
+
+// withChild returns a copy of the branch n of which the child at bit b is replaced by c.
+func (n *trieNode) withChild(b byte, c *trieNode) *trieNode {
+	if b == 0 {
+		return newBranchNode(c, n.right)
+	}
+	return newBranchNode(n.left, c)
+}
+
+// insertNode returns the subtree at depth with the leaf l inserted, the nodes along the path are
+// copied instead of being modified.
+func insertNode(n *trieNode, l *trieLeaf, depth int) *trieNode {
+	if n == nil {
+		return newLeafNode(l)
+	}
+	if n.leaf == nil {
+		var b = bitAt(&l.key, depth)
+		return n.withChild(b, insertNode(n.child(b), l, depth+1))
+	}
+	if n.leaf.key.IsEqual(&l.key) {
+		return newLeafNode(l)
+	}
+	return splitLeaf(n, newLeafNode(l), depth)
+}
+
+// splitLeaf returns the subtree at depth holding the leaf nodes x and y of different keys.
+func splitLeaf(x, y *trieNode, depth int) *trieNode {
+	var bx, by = bitAt(&x.leaf.key, depth), bitAt(&y.leaf.key, depth)
+	switch {
+	case bx == by && bx == 0:
+		return newBranchNode(splitLeaf(x, y, depth+1), nil)
+	case bx == by:
+		return newBranchNode(nil, splitLeaf(x, y, depth+1))
+	case bx == 0:
+		return newBranchNode(x, y)
+	default:
+		return newBranchNode(y, x)
+	}
+}
+
+// deleteNode returns the subtree at depth with the key k removed, and whether the key existed.
+func deleteNode(n *trieNode, k *hash.Hash, depth int) (_ *trieNode, deleted bool) {
+	if n == nil {
+		return nil, false
+	}
+	if n.leaf != nil {
+		if n.leaf.key.IsEqual(k) {
+			return nil, true
+		}
+		return n, false
+	}
+	var (
+		b = bitAt(k, depth)
+		c *trieNode
+	)
+	if c, deleted = deleteNode(n.child(b), k, depth+1); !deleted {
+		return n, false
+	}
+	// Collapse the branch if its subtree holds a single leaf now
+	var s = n.child(1 - b)
+	if c == nil && (s == nil || s.leaf != nil) {
+		return s, true
+	}
+	if s == nil && c.leaf != nil {
+		return c, true
+	}
+	return n.withChild(b, c), true
+}
+
+func bitAt(h *hash.Hash, i int) byte {
+	return (h[i/8] >> uint(7-i%8)) & 1
+}
+
+// leafHash and branchHash are domain separated by a prefix byte, so that a leaf can never be
+// taken as a branch, and vice versa.
+func leafHash(key, value *hash.Hash) hash.Hash {
+	var buf = make([]byte, 0, 1+2*hash.HashSize)
+	buf = append(buf, 0x00)
+	buf = append(buf, key[:]...)
+	buf = append(buf, value[:]...)
+	return hash.THashH(buf)
+}
 
-	return value, nil
+func branchHash(l, r *hash.Hash) hash.Hash {
+	var buf = make([]byte, 0, 1+2*hash.HashSize)
+	buf = append(buf, 0x01)
+	buf = append(buf, l[:]...)
+	buf = append(buf, r[:]...)
+	return hash.THashH(buf)
 }
@@ -99,3 +99,101 @@ func TestTrie_Get(t *testing.T) {
 		})
 	})
 }
+
+func TestTrie_Prove(t *testing.T) {
+	Convey("Given an empty trie", t, func() {
+		trie := NewPatricia()
+		root := trie.Root()
+		So(root, ShouldResemble, hash.Hash{})
+		So(trie.Prove([]byte("a")).Verify(&root, []byte("a"), nil), ShouldBeTrue)
+		So(trie.Prove([]byte("a")).Verify(&root, []byte("a"), []byte("1")), ShouldBeFalse)
+
+		Convey("The proofs should verify the values and absences of keys", func() {
+			for i := 0; i < 100; i++ {
+				So(trie.Insert(serialize(int32(i)), serialize(int32(i*i))), ShouldBeTrue)
+			}
+			root = trie.Root()
+			So(root, ShouldNotResemble, hash.Hash{})
+			for i := 0; i < 100; i++ {
+				var (
+					key   = serialize(int32(i))
+					value = serialize(int32(i * i))
+					proof = trie.Prove(key)
+				)
+				So(proof.Verify(&root, key, value), ShouldBeTrue)
+				So(proof.Verify(&root, key, nil), ShouldBeFalse)
+				So(proof.Verify(&root, key, serialize(int32(i+1))), ShouldBeFalse)
+				So(proof.Verify(&hash.Hash{}, key, value), ShouldBeFalse)
+			}
+			for i := 100; i < 200; i++ {
+				var (
+					key   = serialize(int32(i))
+					proof = trie.Prove(key)
+				)
+				So(proof.Verify(&root, key, nil), ShouldBeTrue)
+				So(proof.Verify(&root, key, serialize(int32(i*i))), ShouldBeFalse)
+			}
+
+			Convey("The root should only depend on the content", func() {
+				another := NewPatricia()
+				for i := 99; i >= 0; i-- {
+					So(another.Insert(serialize(int32(i)), serialize(int32(i*i))), ShouldBeTrue)
+				}
+				So(another.Root(), ShouldResemble, root)
+				So(another.Insert(serialize(int32(100)), serialize(int32(0))), ShouldBeTrue)
+				So(another.Root(), ShouldNotResemble, root)
+			})
+			Convey("A proof of a key should not verify another key", func() {
+				proof := trie.Prove(serialize(int32(1)))
+				So(proof.Verify(&root, serialize(int32(2)), serialize(int32(1))), ShouldBeFalse)
+				So(proof.Verify(&root, serialize(int32(2)), nil), ShouldBeFalse)
+				proof.Siblings = proof.Siblings[1:]
+				So(proof.Verify(&root, serialize(int32(1)), serialize(int32(1))), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestTrie_Update(t *testing.T) {
+	Convey("Given a trie with some keys", t, func() {
+		trie := NewPatricia()
+		for i := 0; i < 100; i++ {
+			So(trie.Insert(serialize(int32(i)), serialize(int32(i))), ShouldBeTrue)
+		}
+		root := trie.Root()
+
+		Convey("Updating and deleting keys should keep the root consistent with the content", func() {
+			cpy := trie.Copy()
+			for i := 0; i < 100; i += 2 {
+				So(cpy.Delete(serialize(int32(i))), ShouldBeTrue)
+				So(cpy.Delete(serialize(int32(i))), ShouldBeFalse)
+			}
+			for i := 1; i < 100; i += 2 {
+				cpy.Update(serialize(int32(i)), serialize(int32(i*i)))
+			}
+			expected := NewPatricia()
+			for i := 99; i > 0; i -= 2 {
+				So(expected.Insert(serialize(int32(i)), serialize(int32(i*i))), ShouldBeTrue)
+			}
+			So(cpy.Root(), ShouldResemble, expected.Root())
+			value, err := cpy.Get(serialize(int32(3)))
+			So(err, ShouldBeNil)
+			So(deserialize(value), ShouldEqual, 9)
+			_, err = cpy.Get(serialize(int32(2)))
+			So(err, ShouldNotBeNil)
+
+			Convey("The original trie should be left unchanged", func() {
+				So(trie.Root(), ShouldResemble, root)
+				value, err := trie.Get(serialize(int32(3)))
+				So(err, ShouldBeNil)
+				So(deserialize(value), ShouldEqual, 3)
+			})
+			Convey("Deleting all the keys should result in an empty trie", func() {
+				for i := 1; i < 100; i += 2 {
+					So(cpy.Delete(serialize(int32(i))), ShouldBeTrue)
+				}
+				So(cpy.Root(), ShouldResemble, hash.Hash{})
+			})
+		})
+	})
+}
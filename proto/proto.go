@@ -18,6 +18,7 @@
 package proto
 
 import (
+	"context"
 	"time"
 )
 
@@ -28,21 +29,34 @@ import (
 type EnvelopeAPI interface {
 	GetVersion() string
 	GetTTL() time.Duration
-	GetExpire() time.Duration
+	GetExpire() int64
 	GetNodeID() *RawNodeID
+	GetTraceParent() string
+	GetContext() context.Context
+	Deadline() (time.Time, bool)
 
 	SetVersion(string)
 	SetTTL(time.Duration)
-	SetExpire(time.Duration)
+	SetExpire(int64)
 	SetNodeID(*RawNodeID)
+	SetTraceParent(string)
+	SetContext(context.Context)
 }
 
 // Envelope is the protocol header
 type Envelope struct {
 	Version string
-	TTL     time.Duration
-	Expire  time.Duration
-	NodeID  *RawNodeID
+	// TTL is the remaining time of the caller deadline when the request is sent
+	TTL time.Duration
+	// ExpireUnixNano is the local deadline in unix nanoseconds set by the rpc server from TTL,
+	// 0 if the caller set no deadline
+	ExpireUnixNano int64
+	NodeID         *RawNodeID
+	// TraceParent is the W3C traceparent of the caller span, replaced by the server span on server
+	TraceParent string
+
+	// ctx is the handler context set by the rpc server, it is never sent
+	ctx context.Context
 }
 
 // PingReq is Ping RPC request
@@ -88,13 +102,18 @@ type FindNeighborResp struct {
 // FindNodeReq is FindNode RPC request
 type FindNodeReq struct {
 	NodeID NodeID
+	// Count is the max count of closest nodes to NodeID to be returned, 0 means none
+	Count int
 	Envelope
 }
 
 // FindNodeResp is FindNode RPC response
 type FindNodeResp struct {
+	// Node is the requested node, nil if it is not known by the callee
 	Node *Node
-	Msg  string
+	// Closest is the nodes closest to the requested node id in the routing table of the callee
+	Closest []Node
+	Msg     string
 	Envelope
 }
 
@@ -111,8 +130,27 @@ func (e *Envelope) GetTTL() time.Duration {
 }
 
 // GetExpire implements EnvelopeAPI.GetExpire
-func (e *Envelope) GetExpire() time.Duration {
-	return e.Expire
+func (e *Envelope) GetExpire() int64 {
+	return e.ExpireUnixNano
+}
+
+// Deadline implements EnvelopeAPI.Deadline and returns the local deadline of the request, ok is
+// false if the caller set no deadline.
+func (e *Envelope) Deadline() (deadline time.Time, ok bool) {
+	if e.ExpireUnixNano <= 0 {
+		return
+	}
+	return time.Unix(0, e.ExpireUnixNano), true
+}
+
+// GetContext implements EnvelopeAPI.GetContext and returns the context of the request handler,
+// which carries the local deadline and the server span, and is canceled once the response is
+// written. It returns context.Background() if the request is not received by the rpc server.
+func (e *Envelope) GetContext() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
 }
 
 // GetNodeID implements EnvelopeAPI.GetNodeID
@@ -120,6 +158,11 @@ func (e *Envelope) GetNodeID() *RawNodeID {
 	return e.NodeID
 }
 
+// GetTraceParent implements EnvelopeAPI.GetTraceParent
+func (e *Envelope) GetTraceParent() string {
+	return e.TraceParent
+}
+
 // SetVersion implements EnvelopeAPI.SetVersion
 func (e *Envelope) SetVersion(ver string) {
 	e.Version = ver
@@ -131,8 +174,8 @@ func (e *Envelope) SetTTL(ttl time.Duration) {
 }
 
 // SetExpire implements EnvelopeAPI.SetExpire
-func (e *Envelope) SetExpire(exp time.Duration) {
-	e.Expire = exp
+func (e *Envelope) SetExpire(exp int64) {
+	e.ExpireUnixNano = exp
 }
 
 // SetNodeID implements EnvelopeAPI.SetNodeID
@@ -140,5 +183,15 @@ func (e *Envelope) SetNodeID(nodeID *RawNodeID) {
 	e.NodeID = nodeID
 }
 
+// SetTraceParent implements EnvelopeAPI.SetTraceParent
+func (e *Envelope) SetTraceParent(traceParent string) {
+	e.TraceParent = traceParent
+}
+
+// SetContext implements EnvelopeAPI.SetContext
+func (e *Envelope) SetContext(ctx context.Context) {
+	e.ctx = ctx
+}
+
 // DatabaseID is database name, will be generated from UUID
 type DatabaseID string
@@ -24,8 +24,8 @@ func (z DatabaseID) Msgsize() (s int) {
 func (z *Envelope) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 4
-	o = append(o, 0x84, 0x84)
+	// map header, size 5
+	o = append(o, 0x85, 0x85)
 	if z.NodeID == nil {
 		o = hsp.AppendNil(o)
 	} else {
@@ -35,12 +35,14 @@ func (z *Envelope) MarshalHash() (o []byte, err error) {
 			o = hsp.AppendBytes(o, oTemp)
 		}
 	}
-	o = append(o, 0x84)
+	o = append(o, 0x85)
 	o = hsp.AppendString(o, z.Version)
-	o = append(o, 0x84)
+	o = append(o, 0x85)
+	o = hsp.AppendString(o, z.TraceParent)
+	o = append(o, 0x85)
 	o = hsp.AppendInt64(o, int64(z.TTL))
-	o = append(o, 0x84)
-	o = hsp.AppendInt64(o, int64(z.Expire))
+	o = append(o, 0x85)
+	o = hsp.AppendInt64(o, z.ExpireUnixNano)
 	return
 }
 
@@ -52,7 +54,7 @@ func (z *Envelope) Msgsize() (s int) {
 	} else {
 		s += z.NodeID.Msgsize()
 	}
-	s += 8 + hsp.StringPrefixSize + len(z.Version) + 4 + hsp.Int64Size + 7 + hsp.Int64Size
+	s += 8 + hsp.StringPrefixSize + len(z.Version) + 12 + hsp.StringPrefixSize + len(z.TraceParent) + 4 + hsp.Int64Size + 15 + hsp.Int64Size
 	return
 }
 
@@ -136,25 +138,27 @@ func (z *FindNeighborResp) Msgsize() (s int) {
 func (z *FindNodeReq) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 2
-	o = append(o, 0x82, 0x82)
+	// map header, size 3
+	o = append(o, 0x83, 0x83)
 	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x82)
+	o = append(o, 0x83)
 	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
+	o = append(o, 0x83)
+	o = hsp.AppendInt(o, z.Count)
 	return
 }
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *FindNodeReq) Msgsize() (s int) {
-	s = 1 + 9 + z.Envelope.Msgsize() + 7 + z.NodeID.Msgsize()
+	s = 1 + 9 + z.Envelope.Msgsize() + 7 + z.NodeID.Msgsize() + 6 + hsp.IntSize
 	return
 }
 
@@ -162,8 +166,8 @@ func (z *FindNodeReq) Msgsize() (s int) {
 func (z *FindNodeResp) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 3
-	o = append(o, 0x83, 0x83)
+	// map header, size 4
+	o = append(o, 0x84, 0x84)
 	if z.Node == nil {
 		o = hsp.AppendNil(o)
 	} else {
@@ -173,13 +177,22 @@ func (z *FindNodeResp) MarshalHash() (o []byte, err error) {
 			o = hsp.AppendBytes(o, oTemp)
 		}
 	}
-	o = append(o, 0x83)
+	o = append(o, 0x84)
 	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x83)
+	o = append(o, 0x84)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Closest)))
+	for za0001 := range z.Closest {
+		if oTemp, err := z.Closest[za0001].MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	o = append(o, 0x84)
 	o = hsp.AppendString(o, z.Msg)
 	return
 }
@@ -192,7 +205,11 @@ func (z *FindNodeResp) Msgsize() (s int) {
 	} else {
 		s += z.Node.Msgsize()
 	}
-	s += 9 + z.Envelope.Msgsize() + 4 + hsp.StringPrefixSize + len(z.Msg)
+	s += 9 + z.Envelope.Msgsize() + 8 + hsp.ArrayHeaderSize
+	for za0001 := range z.Closest {
+		s += z.Closest[za0001].Msgsize()
+	}
+	s += 4 + hsp.StringPrefixSize + len(z.Msg)
 	return
 }
 
@@ -17,6 +17,7 @@
 package proto
 
 import (
+	"context"
 	"testing"
 
 	"time"
@@ -28,8 +29,19 @@ import (
 func TestEnvelope_GetSet(t *testing.T) {
 	Convey("set get", t, func() {
 		env := Envelope{}
-		env.SetExpire(time.Second)
-		So(env.GetExpire(), ShouldEqual, time.Second)
+		_, ok := env.Deadline()
+		So(ok, ShouldBeFalse)
+		So(env.GetContext() == context.Background(), ShouldBeTrue)
+		exp := time.Now().Add(time.Second)
+		env.SetExpire(exp.UnixNano())
+		So(env.GetExpire(), ShouldEqual, exp.UnixNano())
+		deadline, ok := env.Deadline()
+		So(ok, ShouldBeTrue)
+		So(deadline.Equal(exp), ShouldBeTrue)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		env.SetContext(ctx)
+		So(env.GetContext() == ctx, ShouldBeTrue)
 
 		nodeID := &RawNodeID{
 			Hash: hash.Hash{0xa, 0xa},
@@ -17,6 +17,8 @@
 package route
 
 import (
+	"sync"
+
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -44,17 +46,23 @@ ACLs:
 	Miner -> Miner, Kayak.Call():
 		ACL: Open to Miner Leader.
 
-   	BP -> BP, Exchange NodeInfo, Kayak.Call():
+   	BP -> BP, Exchange NodeInfo, Kayak.Call(), MCCBFT.Call():
   		ACL: Open to BP
 
    	Client -> Miner, SQL Query:
    		ACL: Open to Registered Client
 
-   	* -> BP, DHT.Ping():
+   	* -> *, DHT.Ping():
   		ACL: Open to world, add difficulty verification
 
-   	* -> BP, DHT.FindNode(), DHT.FindNeighbor():
-  		ACL: Open to world
+   	* -> *, DHT.FindNode(), DHT.FindNeighbor():
+  		ACL: Open to world, served by BP and by the routing table of every node
+
+   	* -> BP or volunteer relay, Relay.Register(), Relay.Connect(), Relay.Accept():
+  		ACL: Open to world, the node is not registered before its relay address is known
+
+	The ACLs above are declared in defaultACL and enforced by rpc.Server before dispatching
+	any request, calling a method not declared is forbidden.
 */
 
 // RemoteFunc defines the RPC Call name
@@ -119,6 +127,40 @@ const (
 	MCCQueryAccountStableBalance
 	// MCCQueryAccountCovenantBalance is used by block producer to provide account covenant coin balance
 	MCCQueryAccountCovenantBalance
+	// MCCQueryAccountDatabases is used by block producer to provide databases owned by account
+	MCCQueryAccountDatabases
+	// MCCQuerySQLChainProfile is used by block producer to provide database profile
+	MCCQuerySQLChainProfile
+	// MCCQueryTxHistory is used by block producer to provide transaction history of account
+	MCCQueryTxHistory
+	// MCCQueryTxByHash is used by block producer to provide transaction by hash
+	MCCQueryTxByHash
+	// SQLCQueryTime is used by sqlchain to synchronize clock between adjacent nodes
+	SQLCQueryTime
+	// SQLCSignBilling is used by sqlchain to collect billing signatures from adjacent nodes
+	SQLCSignBilling
+	// SQLCLaunchBilling is used by sqlchain to launch billing process on adjacent nodes
+	SQLCLaunchBilling
+	// MCCAdviseNewBlock is used by block producer main chain to advise new block to other producers
+	MCCAdviseNewBlock
+	// MCCAdviseTxBilling is used by block producer main chain to advise billing tx to other producers
+	MCCAdviseTxBilling
+	// MCCAdviseBillingRequest is used by sqlchain to upload billing request to block producer
+	MCCAdviseBillingRequest
+	// MCCFetchBlock is used by block producer main chain to fetch block from other producers
+	MCCFetchBlock
+	// MCCFetchTxBilling is used by block producer main chain to fetch billing tx from other producers
+	MCCFetchTxBilling
+	// MCCBFTCall is used by block producer main chain to run the consensus between producers
+	MCCBFTCall
+	// MCCQueryTime is used by block producer main chain to synchronize clock between producers
+	MCCQueryTime
+	// RelayRegister is used by node behind NAT to register to relay
+	RelayRegister
+	// RelayConnect is used to connect to the node behind NAT through its relay
+	RelayConnect
+	// RelayAccept is used by node behind NAT to accept the connection forwarded by relay
+	RelayAccept
 )
 
 // String returns the RemoteFunc string
@@ -182,43 +224,225 @@ func (s RemoteFunc) String() string {
 		return "MCC.QueryAccountStableBalance"
 	case MCCQueryAccountCovenantBalance:
 		return "MCC.QueryAccountCovenantBalance"
+	case MCCQueryAccountDatabases:
+		return "MCC.QueryAccountDatabases"
+	case MCCQuerySQLChainProfile:
+		return "MCC.QuerySQLChainProfile"
+	case MCCQueryTxHistory:
+		return "MCC.QueryTxHistory"
+	case MCCQueryTxByHash:
+		return "MCC.QueryTxByHash"
+	case SQLCQueryTime:
+		return "SQLC.QueryTime"
+	case SQLCSignBilling:
+		return "SQLC.SignBilling"
+	case SQLCLaunchBilling:
+		return "SQLC.LaunchBilling"
+	case MCCAdviseNewBlock:
+		return "MCC.AdviseNewBlock"
+	case MCCAdviseTxBilling:
+		return "MCC.AdviseTxBilling"
+	case MCCAdviseBillingRequest:
+		return "MCC.AdviseBillingRequest"
+	case MCCFetchBlock:
+		return "MCC.FetchBlock"
+	case MCCFetchTxBilling:
+		return "MCC.FetchTxBilling"
+	case MCCBFTCall:
+		return "MCCBFT.Call"
+	case MCCQueryTime:
+		return "MCC.QueryTime"
+	case RelayRegister:
+		return "Relay.Register"
+	case RelayConnect:
+		return "Relay.Connect"
+	case RelayAccept:
+		return "Relay.Accept"
 	}
 	return "Unknown"
 }
 
+// CallerRole is the bit set of rpc callers classified by their identities.
+type CallerRole uint8
+
+const (
+	// AnonymousCaller is the caller using anonymous ETLS
+	AnonymousCaller CallerRole = 1 << iota
+	// UnregisteredCaller is the caller not found in DHT
+	UnregisteredCaller
+	// ClientCaller is the registered caller which is neither miner nor block producer
+	ClientCaller
+	// MinerCaller is the registered caller with Miner role
+	MinerCaller
+	// BPCaller is the block producer caller
+	BPCaller
+
+	// RegisteredCaller is any caller found in DHT
+	RegisteredCaller = ClientCaller | MinerCaller | BPCaller
+	// WorldCaller is any caller with non-anonymous ETLS
+	WorldCaller = UnregisteredCaller | RegisteredCaller
+	// AnyCaller is any caller including anonymous ETLS
+	AnyCaller = AnonymousCaller | WorldCaller
+)
+
+// String returns the CallerRole string
+func (r CallerRole) String() string {
+	switch r {
+	case AnonymousCaller:
+		return "Anonymous"
+	case UnregisteredCaller:
+		return "Unregistered"
+	case ClientCaller:
+		return "Client"
+	case MinerCaller:
+		return "Miner"
+	case BPCaller:
+		return "BlockProducer"
+	}
+	return "Unknown"
+}
+
+// ACL maps rpc method name to the callers permitted to call it, a method not listed is
+// forbidden to everyone.
+type ACL map[string]CallerRole
+
+var defaultACL = ACL{
+	DHTPing.String():                        AnyCaller,
+	DHTFindNeighbor.String():                WorldCaller,
+	DHTFindNode.String():                    WorldCaller,
+	MetricUploadMetrics.String():            RegisteredCaller,
+	KayakCall.String():                      BPCaller,
+	DBSQuery.String():                       RegisteredCaller,
+	DBSAck.String():                         RegisteredCaller,
+	DBSDeploy.String():                      BPCaller,
+	DBSGetRequest.String():                  RegisteredCaller,
+	DBCCall.String():                        MinerCaller | BPCaller,
+	BPDBCreateDatabase.String():             RegisteredCaller,
+	BPDBDropDatabase.String():               RegisteredCaller,
+	BPDBGetDatabase.String():                RegisteredCaller,
+	BPDBGetNodeDatabases.String():           RegisteredCaller,
+	SQLCAdviseNewBlock.String():             MinerCaller | BPCaller,
+	SQLCAdviseBinLog.String():               MinerCaller | BPCaller,
+	SQLCAdviseResponsedQuery.String():       MinerCaller | BPCaller,
+	SQLCAdviseAckedQuery.String():           MinerCaller | BPCaller,
+	SQLCFetchBlock.String():                 MinerCaller | BPCaller,
+	SQLCFetchAckedQuery.String():            MinerCaller | BPCaller,
+	SQLCSubscribeTransactions.String():      RegisteredCaller,
+	SQLCCancelSubscription.String():         RegisteredCaller,
+	SQLCQueryTime.String():                  MinerCaller | BPCaller,
+	SQLCSignBilling.String():                MinerCaller | BPCaller,
+	SQLCLaunchBilling.String():              MinerCaller | BPCaller,
+	OBSAdviseAckedQuery.String():            MinerCaller | BPCaller,
+	OBSAdviseNewBlock.String():              MinerCaller | BPCaller,
+	MCCNextAccountNonce.String():            RegisteredCaller,
+	MCCAddTx.String():                       RegisteredCaller,
+	MCCAddTxTransfer.String():               RegisteredCaller,
+	MCCQueryAccountStableBalance.String():   RegisteredCaller,
+	MCCQueryAccountCovenantBalance.String(): RegisteredCaller,
+	MCCQueryAccountDatabases.String():       RegisteredCaller,
+	MCCQuerySQLChainProfile.String():        RegisteredCaller,
+	MCCQueryTxHistory.String():              RegisteredCaller,
+	MCCQueryTxByHash.String():               RegisteredCaller,
+	MCCAdviseNewBlock.String():              BPCaller,
+	MCCAdviseTxBilling.String():             BPCaller,
+	MCCAdviseBillingRequest.String():        MinerCaller | BPCaller,
+	MCCFetchBlock.String():                  RegisteredCaller,
+	MCCFetchTxBilling.String():              BPCaller,
+	MCCBFTCall.String():                     BPCaller,
+	MCCQueryTime.String():                   BPCaller,
+	RelayRegister.String():                  WorldCaller,
+	RelayConnect.String():                   WorldCaller,
+	RelayAccept.String():                    WorldCaller,
+}
+
+// DefaultACL returns a copy of the ACL of all the builtin rpc methods, the copy can be
+// extended with methods of custom services.
+func DefaultACL() (acl ACL) {
+	acl = make(ACL, len(defaultACL))
+	for k, v := range defaultACL {
+		acl[k] = v
+	}
+	return
+}
+
+var (
+	// nodeRoles records the roles of the non-BP nodes learned from the pinned config or from the
+	// data signed by block producers, the roles announced by the nodes themselves are not trusted.
+	nodeRoles     = make(map[proto.RawNodeID]proto.ServerRole)
+	nodeRolesLock sync.RWMutex
+)
+
+// SetNodeRole records the role of the node, which should only come from the pinned config or
+// from the data signed by block producers, such as the peers of a database.
+func SetNodeRole(id proto.NodeID, role proto.ServerRole) {
+	rawID := id.ToRawNodeID()
+	if rawID == nil {
+		return
+	}
+	nodeRolesLock.Lock()
+	defer nodeRolesLock.Unlock()
+	nodeRoles[*rawID] = role
+}
+
+// DeleteNodeRole removes the recorded role of the node.
+func DeleteNodeRole(id proto.NodeID) {
+	rawID := id.ToRawNodeID()
+	if rawID == nil {
+		return
+	}
+	nodeRolesLock.Lock()
+	defer nodeRolesLock.Unlock()
+	delete(nodeRoles, *rawID)
+}
+
+func getNodeRole(id *proto.RawNodeID) (role proto.ServerRole, ok bool) {
+	nodeRolesLock.RLock()
+	defer nodeRolesLock.RUnlock()
+	role, ok = nodeRoles[*id]
+	return
+}
+
+// GetCallerRole returns the role of the caller node. A non-BP node is a miner only if its role
+// is recorded by SetNodeRole, otherwise it is a client if it is found in the local DHT store,
+// the role announced by the node by DHT.Ping is never trusted.
+func GetCallerRole(id *proto.RawNodeID) CallerRole {
+	if id == nil {
+		return UnregisteredCaller
+	}
+	if id.IsEqual(&kms.AnonymousRawNodeID.Hash) {
+		return AnonymousCaller
+	}
+	if IsBPNodeID(id) {
+		return BPCaller
+	}
+	if role, ok := getNodeRole(id); ok && role == proto.Miner {
+		return MinerCaller
+	}
+	if _, err := kms.GetNodeInfo(id.ToNodeID()); err != nil {
+		return UnregisteredCaller
+	}
+	return ClientCaller
+}
+
+// Permits returns if the caller role is permitted to call the rpc method
+func (acl ACL) Permits(role CallerRole, method string) bool {
+	permitted, ok := acl[method]
+	return ok && permitted&role != 0
+}
+
+// IsPermitted returns if the node is permitted to call the rpc method
+func (acl ACL) IsPermitted(id *proto.RawNodeID, method string) bool {
+	return acl.Permits(GetCallerRole(id), method)
+}
+
 // IsPermitted returns if the node is permitted to call the RPC func
 func IsPermitted(callerEnvelope *proto.Envelope, funcName RemoteFunc) (ok bool) {
 	callerETLSNodeID := callerEnvelope.GetNodeID()
-	// strict anonymous ETLS only used for Ping
 	// the envelope node id is set at NodeAwareServerCodec and CryptoListener.CHandler
-	// if callerETLSNodeID == nil here indicates that ETLS is not used, just ignore it
-	if callerETLSNodeID != nil {
-		if callerETLSNodeID.IsEqual(&kms.AnonymousRawNodeID.Hash) {
-			if funcName != DHTPing {
-				log.Warnf("anonymous ETLS connection can not used by %s", funcName)
-				return false
-			}
-		}
+	// if callerETLSNodeID == nil here indicates that ETLS is not used, treat it as unregistered
+	role := GetCallerRole(callerETLSNodeID)
+	if ok = defaultACL.Permits(role, funcName.String()); !ok {
+		log.Warnf("%s caller can not call %s", role, funcName)
 	}
-
-	if !IsBPNodeID(callerETLSNodeID) {
-		// non BP
-		switch funcName {
-		// DHT related
-		case DHTPing, DHTFindNode, DHTFindNeighbor, MetricUploadMetrics:
-			return true
-			// Kayak related
-		case KayakCall:
-			return false
-			// DBSDeploy
-		case DBSDeploy:
-			return false
-		default:
-			// calling Unspecified RPC is forbidden
-			return false
-		}
-	}
-
-	// BP can call any RPC
-	return true
+	return
 }
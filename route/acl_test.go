@@ -21,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 
@@ -58,8 +59,59 @@ func TestIsPermitted(t *testing.T) {
 		So(IsPermitted(testAnonymous, DHTFindNode), ShouldBeFalse)
 	})
 
+	Convey("test ACL", t, func() {
+		acl := DefaultACL()
+		So(acl.Permits(AnonymousCaller, DHTPing.String()), ShouldBeTrue)
+		So(acl.Permits(AnonymousCaller, DHTFindNode.String()), ShouldBeFalse)
+		So(acl.Permits(UnregisteredCaller, DBSQuery.String()), ShouldBeFalse)
+		So(acl.Permits(ClientCaller, DBSQuery.String()), ShouldBeTrue)
+		So(acl.Permits(ClientCaller, SQLCAdviseNewBlock.String()), ShouldBeFalse)
+		So(acl.Permits(MinerCaller, SQLCAdviseNewBlock.String()), ShouldBeTrue)
+		So(acl.Permits(MinerCaller, MCCAdviseNewBlock.String()), ShouldBeFalse)
+		So(acl.Permits(BPCaller, "Test.Unknown"), ShouldBeFalse)
+
+		// every builtin remote func is declared
+		for i := DHTPing; i <= MCCQueryTime; i++ {
+			_, ok := acl[i.String()]
+			So(ok, ShouldBeTrue)
+		}
+
+		// extending the copy does not affect default acl
+		acl["Test.Unknown"] = AnyCaller
+		So(acl.Permits(BPCaller, "Test.Unknown"), ShouldBeTrue)
+		So(DefaultACL().Permits(BPCaller, "Test.Unknown"), ShouldBeFalse)
+	})
+
+	Convey("test GetCallerRole", t, func() {
+		So(GetCallerRole(nil), ShouldEqual, UnregisteredCaller)
+		So(GetCallerRole(kms.AnonymousRawNodeID), ShouldEqual, AnonymousCaller)
+		So(GetCallerRole(&conf.GConf.BP.RawNodeID), ShouldEqual, BPCaller)
+		unknownID := proto.NodeID("0000")
+		So(GetCallerRole(unknownID.ToRawNodeID()), ShouldEqual, UnregisteredCaller)
+
+		kms.Unittest = true
+		defer func() { kms.Unittest = false }()
+		pub := conf.GConf.BP.PublicKey
+		miner := &proto.Node{ID: proto.NodeID(strings.Repeat("0", 63) + "1"), Role: proto.Miner, PublicKey: pub}
+		client := &proto.Node{ID: proto.NodeID(strings.Repeat("0", 63) + "2"), Role: proto.Client, PublicKey: pub}
+		So(kms.SetNode(miner), ShouldBeNil)
+		So(kms.SetNode(client), ShouldBeNil)
+		// the role announced by the node itself is not trusted
+		So(GetCallerRole(miner.ID.ToRawNodeID()), ShouldEqual, ClientCaller)
+		So(IsPermitted(&proto.Envelope{NodeID: miner.ID.ToRawNodeID()}, DBCCall), ShouldBeFalse)
+		SetNodeRole(miner.ID, proto.Miner)
+		defer DeleteNodeRole(miner.ID)
+		So(GetCallerRole(miner.ID.ToRawNodeID()), ShouldEqual, MinerCaller)
+		So(GetCallerRole(client.ID.ToRawNodeID()), ShouldEqual, ClientCaller)
+		So(IsPermitted(&proto.Envelope{NodeID: miner.ID.ToRawNodeID()}, DBCCall), ShouldBeTrue)
+		So(IsPermitted(&proto.Envelope{NodeID: client.ID.ToRawNodeID()}, DBCCall), ShouldBeFalse)
+		So(IsPermitted(&proto.Envelope{NodeID: miner.ID.ToRawNodeID()}, MCCBFTCall), ShouldBeFalse)
+		DeleteNodeRole(miner.ID)
+		So(GetCallerRole(miner.ID.ToRawNodeID()), ShouldEqual, ClientCaller)
+	})
+
 	Convey("string RemoteFunc", t, func() {
-		for i := DHTPing; i <= OBSAdviseNewBlock; i++ {
+		for i := DHTPing; i <= MCCQueryTime; i++ {
 			So(fmt.Sprintf("%s", RemoteFunc(i)), ShouldContainSubstring, ".")
 		}
 		So(fmt.Sprintf("%s", RemoteFunc(9999)), ShouldContainSubstring, "Unknown")
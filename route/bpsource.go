@@ -0,0 +1,456 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"gopkg.in/yaml.v2"
+)
+
+// Block producer sources supported by BootstrapConfig.Sources.
+const (
+	// BPSourceStatic reads the block producer list from a yaml file
+	BPSourceStatic = "static"
+	// BPSourceFile reads the signed block producer list from a yaml file
+	BPSourceFile = "file"
+	// BPSourceHTTP fetches the signed block producer list from a http endpoint
+	BPSourceHTTP = "http"
+	// BPSourceDNS resolves the block producer list from DNSSEC seed
+	BPSourceDNS = "dns"
+)
+
+var (
+	// BPSourceHTTPTimeout is the timeout of fetching the block producer list by http.
+	BPSourceHTTPTimeout = 10 * time.Second
+	// BPSourceHTTPMaxSize is the max size of the block producer list fetched by http.
+	BPSourceHTTPMaxSize int64 = 1 << 20
+
+	// ErrUnknownBPSource indicates the block producer source is not supported
+	ErrUnknownBPSource = errors.New("unknown block producer source")
+	// ErrNoBPAvailable indicates no source returns a verified block producer list
+	ErrNoBPAvailable = errors.New("no block producer available from bootstrap sources")
+	// ErrEmptyBPList indicates the source returns an empty block producer list
+	ErrEmptyBPList = errors.New("empty block producer list")
+	// ErrBPNotPinned indicates the key of the block producer or list signer is not pinned
+	ErrBPNotPinned = errors.New("block producer public key not pinned")
+	// ErrInvalidBPListSignature indicates the signature of the block producer list is invalid
+	ErrInvalidBPListSignature = errors.New("invalid block producer list signature")
+	// ErrStaleBPList indicates the signed block producer list is older than the last known good list
+	ErrStaleBPList = errors.New("block producer list older than last known good list")
+	// ErrBPListTooLarge indicates the block producer list exceeds BPSourceHTTPMaxSize
+	ErrBPListTooLarge = errors.New("block producer list too large")
+)
+
+// BPSource is a source of the block producer list to bootstrap the node.
+type BPSource interface {
+	// Name returns the name of the source in logs
+	Name() string
+	// GetBPs returns the block producers, which are verified by BootstrapBPs
+	GetBPs() (IDNodeMap, error)
+}
+
+// signedBPSource is a BPSource of signed block producer lists, which are checked against the
+// timestamp of the last known good list to prevent rollback to an old list.
+type signedBPSource interface {
+	BPSource
+	// getSignedBPs returns the block producers in the list not older than lastKnown and the
+	// timestamp of the list
+	getSignedBPs(lastKnown time.Time) (IDNodeMap, time.Time, error)
+}
+
+// bpCache is the format of the last known good block producer list.
+type bpCache struct {
+	Nodes []proto.Node `yaml:"Nodes"`
+	// Timestamp is the timestamp of the latest signed list ever verified
+	Timestamp time.Time `yaml:"Timestamp"`
+}
+
+// SignedBPList is the block producer list signed by a pinned block producer key, it's the
+// format of the signed bootstrap file and the http endpoint.
+type SignedBPList struct {
+	Nodes     []proto.Node          `yaml:"Nodes"`
+	Timestamp time.Time             `yaml:"Timestamp"`
+	Signee    *asymmetric.PublicKey `yaml:"Signee"`
+	// Signature is the hex encoded signature of the nodes and timestamp
+	Signature string `yaml:"Signature"`
+}
+
+func (l *SignedBPList) digest() (h []byte, err error) {
+	buf := new(bytes.Buffer)
+	for i := range l.Nodes {
+		var b []byte
+		if b, err = l.Nodes[i].MarshalHash(); err != nil {
+			return
+		}
+		buf.Write(b)
+	}
+	if err = binary.Write(buf, binary.BigEndian, l.Timestamp.UnixNano()); err != nil {
+		return
+	}
+	return hash.THashB(buf.Bytes()), nil
+}
+
+// Sign signs the list with the private key of a block producer.
+func (l *SignedBPList) Sign(signer asymmetric.Signer) (err error) {
+	h, err := l.digest()
+	if err != nil {
+		return
+	}
+	sig, err := signer.Sign(h)
+	if err != nil {
+		return
+	}
+	l.Signee = signer.PubKey()
+	l.Signature = hex.EncodeToString(sig.Serialize())
+	return
+}
+
+// Verify checks the list is signed by one of the pinned keys and is not older than lastKnown,
+// the timestamp of the last known good list.
+func (l *SignedBPList) Verify(pinned []*asymmetric.PublicKey, lastKnown time.Time) (err error) {
+	if l.Signee == nil || !isPinned(l.Signee, pinned) {
+		return ErrBPNotPinned
+	}
+	sigBytes, err := hex.DecodeString(l.Signature)
+	if err != nil {
+		return ErrInvalidBPListSignature
+	}
+	sig, err := asymmetric.ParseSignature(sigBytes)
+	if err != nil {
+		return ErrInvalidBPListSignature
+	}
+	h, err := l.digest()
+	if err != nil {
+		return
+	}
+	if !sig.Verify(h, l.Signee) {
+		return ErrInvalidBPListSignature
+	}
+	if l.Timestamp.Before(lastKnown) {
+		return ErrStaleBPList
+	}
+	return
+}
+
+// StaticBPSource reads the block producer list from a yaml file in the format of KnownNodes.
+type StaticBPSource struct {
+	Path string
+}
+
+// Name implements BPSource.Name.
+func (s *StaticBPSource) Name() string {
+	return BPSourceStatic + ":" + s.Path
+}
+
+// GetBPs implements BPSource.GetBPs.
+func (s *StaticBPSource) GetBPs() (BPNodes IDNodeMap, err error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return
+	}
+	var nodes []proto.Node
+	if err = yaml.Unmarshal(data, &nodes); err != nil {
+		return
+	}
+	return toIDNodeMap(nodes), nil
+}
+
+// SignedFileBPSource reads the signed block producer list from a yaml file.
+type SignedFileBPSource struct {
+	Path   string
+	Pinned []*asymmetric.PublicKey
+}
+
+// Name implements BPSource.Name.
+func (s *SignedFileBPSource) Name() string {
+	return BPSourceFile + ":" + s.Path
+}
+
+// GetBPs implements BPSource.GetBPs.
+func (s *SignedFileBPSource) GetBPs() (BPNodes IDNodeMap, err error) {
+	BPNodes, _, err = s.getSignedBPs(time.Time{})
+	return
+}
+
+func (s *SignedFileBPSource) getSignedBPs(lastKnown time.Time) (
+	BPNodes IDNodeMap, timestamp time.Time, err error,
+) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return
+	}
+	return parseSignedBPList(data, s.Pinned, lastKnown)
+}
+
+// HTTPBPSource fetches the signed block producer list from a http endpoint.
+type HTTPBPSource struct {
+	URL    string
+	Pinned []*asymmetric.PublicKey
+}
+
+// Name implements BPSource.Name.
+func (s *HTTPBPSource) Name() string {
+	return BPSourceHTTP + ":" + s.URL
+}
+
+// GetBPs implements BPSource.GetBPs.
+func (s *HTTPBPSource) GetBPs() (BPNodes IDNodeMap, err error) {
+	BPNodes, _, err = s.getSignedBPs(time.Time{})
+	return
+}
+
+func (s *HTTPBPSource) getSignedBPs(lastKnown time.Time) (
+	BPNodes IDNodeMap, timestamp time.Time, err error,
+) {
+	client := &http.Client{Timeout: BPSourceHTTPTimeout}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected http status: %s", resp.Status)
+		return
+	}
+	// read one more byte to tell the oversized list from the list of exactly the max size
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, BPSourceHTTPMaxSize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(data)) > BPSourceHTTPMaxSize {
+		err = ErrBPListTooLarge
+		return
+	}
+	return parseSignedBPList(data, s.Pinned, lastKnown)
+}
+
+// DNSBPSource resolves the block producer list from DNSSEC seed.
+type DNSBPSource struct {
+	Domain string
+}
+
+// Name implements BPSource.Name.
+func (s *DNSBPSource) Name() string {
+	return BPSourceDNS + ":" + s.Domain
+}
+
+// GetBPs implements BPSource.GetBPs.
+func (s *DNSBPSource) GetBPs() (BPNodes IDNodeMap, err error) {
+	return NewDNSClient().GetBPFromDNSSeed(s.Domain)
+}
+
+// NewBPSources returns the block producer sources in the order of config.
+func NewBPSources(cfg *conf.BootstrapConfig) (sources []BPSource, err error) {
+	for _, name := range cfg.Sources {
+		switch name {
+		case BPSourceStatic:
+			sources = append(sources, &StaticBPSource{Path: cfg.StaticFile})
+		case BPSourceFile:
+			sources = append(sources, &SignedFileBPSource{Path: cfg.SignedFile, Pinned: cfg.PinnedKeys})
+		case BPSourceHTTP:
+			sources = append(sources, &HTTPBPSource{URL: cfg.HTTPURL, Pinned: cfg.PinnedKeys})
+		case BPSourceDNS:
+			domain := cfg.DNSDomain
+			if domain == "" {
+				domain = BPDomain
+			}
+			sources = append(sources, &DNSBPSource{Domain: domain})
+		default:
+			return nil, ErrUnknownBPSource
+		}
+	}
+	return
+}
+
+// BootstrapBPs returns the block producer list from the first source returning a verified list,
+// and saves it as the last known good list. The last known good list is used if all sources fail.
+// Signed lists older than the last known good list are rejected. DNS seed is the only source if
+// cfg is nil.
+func BootstrapBPs(cfg *conf.BootstrapConfig) (BPNodes IDNodeMap, err error) {
+	if cfg == nil {
+		cfg = &conf.BootstrapConfig{Sources: []string{BPSourceDNS}}
+	}
+	sources, err := NewBPSources(cfg)
+	if err != nil {
+		return
+	}
+
+	var (
+		cache    *bpCache
+		cacheErr error
+	)
+	if cfg.CacheFile != "" {
+		if cache, cacheErr = loadBPCache(cfg.CacheFile); cacheErr != nil && !os.IsNotExist(cacheErr) {
+			log.WithField("file", cfg.CacheFile).WithError(cacheErr).Warning(
+				"load block producers cache failed")
+		}
+	}
+	var lastKnown time.Time
+	if cache != nil {
+		lastKnown = cache.Timestamp
+	}
+
+	for _, s := range sources {
+		var timestamp time.Time
+		if signed, ok := s.(signedBPSource); ok {
+			BPNodes, timestamp, err = signed.getSignedBPs(lastKnown)
+		} else {
+			BPNodes, err = s.GetBPs()
+		}
+		if err == nil {
+			err = VerifyBPs(BPNodes, cfg.PinnedKeys)
+		}
+		if err != nil {
+			log.WithField("source", s.Name()).WithError(err).Warning("get block producers failed")
+			continue
+		}
+		log.WithFields(log.Fields{
+			"source": s.Name(),
+			"count":  len(BPNodes),
+		}).Info("got block producers")
+		if cfg.CacheFile != "" {
+			// lists from unsigned sources never lower the timestamp of the last known good list
+			if timestamp.Before(lastKnown) {
+				timestamp = lastKnown
+			}
+			if errSave := saveBPCache(cfg.CacheFile, BPNodes, timestamp); errSave != nil {
+				log.WithField("file", cfg.CacheFile).WithError(errSave).Warning(
+					"save block producers cache failed")
+			}
+		}
+		return
+	}
+
+	if cache != nil {
+		BPNodes = toIDNodeMap(cache.Nodes)
+		if err = VerifyBPs(BPNodes, cfg.PinnedKeys); err == nil {
+			log.WithField("file", cfg.CacheFile).Warning("using last known good block producers")
+			return
+		}
+		log.WithField("file", cfg.CacheFile).WithError(err).Warning("verify block producers cache failed")
+	}
+	return nil, ErrNoBPAvailable
+}
+
+// VerifyBPs checks the NodeIDs of the block producers are proved by their public keys and
+// nonces, and the public keys are pinned if any key is pinned.
+func VerifyBPs(BPNodes IDNodeMap, pinned []*asymmetric.PublicKey) (err error) {
+	if len(BPNodes) == 0 {
+		return ErrEmptyBPList
+	}
+	for id, n := range BPNodes {
+		rawID := n.ID.ToRawNodeID()
+		if rawID == nil || !rawID.IsEqual(&id.Hash) || !kms.IsIDPubNonceValid(&id, &n.Nonce, n.PublicKey) {
+			return fmt.Errorf("%v: %s", ErrInvalidNodeIDProof, n.ID)
+		}
+		if len(pinned) > 0 && !isPinned(n.PublicKey, pinned) {
+			return fmt.Errorf("%v: %s", ErrBPNotPinned, n.ID)
+		}
+	}
+	return
+}
+
+func parseSignedBPList(data []byte, pinned []*asymmetric.PublicKey, lastKnown time.Time) (
+	BPNodes IDNodeMap, timestamp time.Time, err error,
+) {
+	var l SignedBPList
+	if err = yaml.Unmarshal(data, &l); err != nil {
+		return
+	}
+	if err = l.Verify(pinned, lastKnown); err != nil {
+		return
+	}
+	return toIDNodeMap(l.Nodes), l.Timestamp, nil
+}
+
+func loadBPCache(path string) (cache *bpCache, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	cache = new(bpCache)
+	if err = yaml.Unmarshal(data, cache); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func saveBPCache(path string, BPNodes IDNodeMap, timestamp time.Time) (err error) {
+	cache := &bpCache{
+		Nodes:     make([]proto.Node, 0, len(BPNodes)),
+		Timestamp: timestamp,
+	}
+	for _, n := range BPNodes {
+		cache.Nodes = append(cache.Nodes, n)
+	}
+	data, err := yaml.Marshal(cache)
+	if err != nil {
+		return
+	}
+	// write to temp file and rename, so the last known good list is never truncated
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// toIDNodeMap indexes the nodes by NodeID, the role of a node is Follower if not set to a block
+// producer role.
+func toIDNodeMap(nodes []proto.Node) (BPNodes IDNodeMap) {
+	BPNodes = make(IDNodeMap, len(nodes))
+	for _, n := range nodes {
+		rawID := n.ID.ToRawNodeID()
+		if rawID == nil {
+			continue
+		}
+		if n.Role != proto.Leader && n.Role != proto.Follower {
+			n.Role = proto.Follower
+		}
+		BPNodes[*rawID] = n
+	}
+	return
+}
+
+func isPinned(key *asymmetric.PublicKey, pinned []*asymmetric.PublicKey) bool {
+	for _, k := range pinned {
+		if key.IsEqual(k) {
+			return true
+		}
+	}
+	return false
+}
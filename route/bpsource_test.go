@@ -0,0 +1,241 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v2"
+)
+
+func genBP(t *testing.T, addr string) (*asymmetric.PrivateKey, proto.Node) {
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := mine.Uint256{}
+	h := mine.HashBlock(pub.Serialize(), nonce)
+	return priv, proto.Node{
+		ID:        proto.NodeID(h.String()),
+		Role:      proto.Follower,
+		Addr:      addr,
+		PublicKey: pub,
+		Nonce:     nonce,
+	}
+}
+
+func writeYAML(t *testing.T, path string, v interface{}) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBootstrapBPs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv1, bp1 := genBP(t, "10.0.0.1:2230")
+	_, bp2 := genBP(t, "10.0.0.2:2230")
+	privOther, other := genBP(t, "10.0.0.3:2230")
+	pinned := []*asymmetric.PublicKey{bp1.PublicKey, bp2.PublicKey}
+
+	staticFile := filepath.Join(dir, "static.yaml")
+	writeYAML(t, staticFile, []proto.Node{bp1, bp2})
+	signed := &SignedBPList{Nodes: []proto.Node{bp1, bp2}, Timestamp: time.Now().UTC()}
+	if err = signed.Sign(priv1); err != nil {
+		t.Fatal(err)
+	}
+	signedFile := filepath.Join(dir, "signed.yaml")
+	writeYAML(t, signedFile, signed)
+
+	Convey("static yaml list should be verified against pinned keys", t, func() {
+		cfg := &conf.BootstrapConfig{
+			Sources:    []string{BPSourceStatic},
+			StaticFile: staticFile,
+		}
+		BPNodes, err := BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(BPNodes, ShouldHaveLength, 2)
+		So(BPNodes[*bp1.ID.ToRawNodeID()].Addr, ShouldEqual, bp1.Addr)
+
+		cfg.PinnedKeys = pinned
+		_, err = BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+
+		cfg.PinnedKeys = []*asymmetric.PublicKey{bp1.PublicKey}
+		_, err = BootstrapBPs(cfg)
+		So(err, ShouldEqual, ErrNoBPAvailable)
+		So(VerifyBPs(toIDNodeMap([]proto.Node{bp1, bp2}), cfg.PinnedKeys), ShouldNotBeNil)
+
+		forged := other
+		forged.PublicKey = bp1.PublicKey
+		So(VerifyBPs(toIDNodeMap([]proto.Node{forged}), nil), ShouldNotBeNil)
+		So(VerifyBPs(IDNodeMap{}, nil), ShouldEqual, ErrEmptyBPList)
+	})
+	Convey("signed bootstrap file should be signed by pinned key", t, func() {
+		cfg := &conf.BootstrapConfig{
+			Sources:    []string{BPSourceFile},
+			SignedFile: signedFile,
+			PinnedKeys: pinned,
+		}
+		BPNodes, err := BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(BPNodes, ShouldHaveLength, 2)
+
+		tampered := *signed
+		tampered.Nodes = []proto.Node{bp1, other}
+		So(tampered.Verify(pinned, time.Time{}), ShouldEqual, ErrInvalidBPListSignature)
+		tampered = *signed
+		tampered.Timestamp = signed.Timestamp.Add(time.Second)
+		So(tampered.Verify(pinned, time.Time{}), ShouldEqual, ErrInvalidBPListSignature)
+		tampered = *signed
+		tampered.Signature = "bad"
+		So(tampered.Verify(pinned, time.Time{}), ShouldEqual, ErrInvalidBPListSignature)
+
+		unpinned := &SignedBPList{Nodes: []proto.Node{bp1, bp2}, Timestamp: time.Now().UTC()}
+		So(unpinned.Sign(privOther), ShouldBeNil)
+		So(unpinned.Verify(pinned, time.Time{}), ShouldEqual, ErrBPNotPinned)
+		So(signed.Verify(nil, time.Time{}), ShouldEqual, ErrBPNotPinned)
+
+		So(signed.Verify(pinned, signed.Timestamp), ShouldBeNil)
+		So(signed.Verify(pinned, signed.Timestamp.Add(time.Second)), ShouldEqual, ErrStaleBPList)
+	})
+	Convey("signed list should be fetched from http endpoint", t, func() {
+		data, err := yaml.Marshal(signed)
+		So(err, ShouldBeNil)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/bootstrap" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		}))
+		defer srv.Close()
+
+		cfg := &conf.BootstrapConfig{
+			Sources:    []string{BPSourceHTTP},
+			HTTPURL:    srv.URL + "/bootstrap",
+			PinnedKeys: pinned,
+		}
+		BPNodes, err := BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(BPNodes, ShouldHaveLength, 2)
+
+		cfg.HTTPURL = srv.URL + "/notfound"
+		_, err = BootstrapBPs(cfg)
+		So(err, ShouldEqual, ErrNoBPAvailable)
+
+		defer func(size int64) { BPSourceHTTPMaxSize = size }(BPSourceHTTPMaxSize)
+		BPSourceHTTPMaxSize = int64(len(data))
+		_, err = (&HTTPBPSource{URL: srv.URL + "/bootstrap", Pinned: pinned}).GetBPs()
+		So(err, ShouldBeNil)
+		BPSourceHTTPMaxSize = int64(len(data)) - 1
+		_, err = (&HTTPBPSource{URL: srv.URL + "/bootstrap", Pinned: pinned}).GetBPs()
+		So(err, ShouldEqual, ErrBPListTooLarge)
+	})
+	Convey("sources should be tried in order and last known good list cached", t, func() {
+		cacheFile := filepath.Join(dir, "cache.yaml")
+		cfg := &conf.BootstrapConfig{
+			Sources:    []string{BPSourceFile, BPSourceStatic},
+			SignedFile: filepath.Join(dir, "notexist.yaml"),
+			StaticFile: staticFile,
+			PinnedKeys: pinned,
+			CacheFile:  cacheFile,
+		}
+		BPNodes, err := BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(BPNodes, ShouldHaveLength, 2)
+		_, err = os.Stat(cacheFile)
+		So(err, ShouldBeNil)
+
+		// all sources fail, the cache is used
+		cfg.StaticFile = filepath.Join(dir, "notexist.yaml")
+		cached, err := BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(cached, ShouldResemble, BPNodes)
+
+		// the cache is verified too
+		cfg.PinnedKeys = []*asymmetric.PublicKey{other.PublicKey}
+		_, err = BootstrapBPs(cfg)
+		So(err, ShouldEqual, ErrNoBPAvailable)
+
+		cfg.Sources = []string{"ftp"}
+		_, err = BootstrapBPs(cfg)
+		So(err, ShouldEqual, ErrUnknownBPSource)
+	})
+	Convey("signed list older than the last known good list should be rejected", t, func() {
+		cacheFile := filepath.Join(dir, "rollback.yaml")
+		cfg := &conf.BootstrapConfig{
+			Sources:    []string{BPSourceFile},
+			SignedFile: signedFile,
+			PinnedKeys: pinned,
+			CacheFile:  cacheFile,
+		}
+		_, err := BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		cache, err := loadBPCache(cacheFile)
+		So(err, ShouldBeNil)
+		So(cache.Timestamp.Equal(signed.Timestamp), ShouldBeTrue)
+
+		// a newer list only with bp1 is accepted
+		newer := &SignedBPList{Nodes: []proto.Node{bp1}, Timestamp: signed.Timestamp.Add(time.Minute)}
+		So(newer.Sign(priv1), ShouldBeNil)
+		writeYAML(t, signedFile, newer)
+		BPNodes, err := BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(BPNodes, ShouldHaveLength, 1)
+
+		// replaying the older list falls back to the last known good list
+		writeYAML(t, signedFile, signed)
+		_, _, err = (&SignedFileBPSource{Path: signedFile, Pinned: pinned}).getSignedBPs(newer.Timestamp)
+		So(err, ShouldEqual, ErrStaleBPList)
+		BPNodes, err = BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(BPNodes, ShouldHaveLength, 1)
+		cache, err = loadBPCache(cacheFile)
+		So(err, ShouldBeNil)
+		So(cache.Timestamp.Equal(newer.Timestamp), ShouldBeTrue)
+
+		// unsigned sources never lower the timestamp
+		cfg.Sources = []string{BPSourceStatic}
+		cfg.StaticFile = staticFile
+		BPNodes, err = BootstrapBPs(cfg)
+		So(err, ShouldBeNil)
+		So(BPNodes, ShouldHaveLength, 2)
+		cache, err = loadBPCache(cacheFile)
+		So(err, ShouldBeNil)
+		So(cache.Timestamp.Equal(newer.Timestamp), ShouldBeTrue)
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"sync"
 
 	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -45,6 +46,9 @@ var (
 
 	// ErrNilNodeID indicates we got nil node id
 	ErrNilNodeID = errors.New("nil node id")
+
+	// ErrPermissionDenied indicates the caller is not permitted to call the rpc method
+	ErrPermissionDenied = errors.New("rpc call not permitted")
 )
 
 // Resolver does NodeID translation
@@ -75,6 +79,19 @@ func IsBPNodeID(id *proto.RawNodeID) bool {
 	return ok
 }
 
+// IsBPPublicKey returns if it is the public key of a Block Producer
+func IsBPPublicKey(pub *asymmetric.PublicKey) bool {
+	if pub == nil {
+		return false
+	}
+	for _, id := range GetBPs() {
+		if bpPub, err := kms.GetPublicKey(id); err == nil && bpPub.IsEqual(pub) {
+			return true
+		}
+	}
+	return false
+}
+
 // setResolveCache initializes Resolver.cache by a new map
 func setResolveCache(initCache NodeIDAddressMap) {
 	initResolver()
@@ -115,7 +132,7 @@ func SetNodeAddrCache(id *proto.RawNodeID, addr string) (err error) {
 	return setNodeAddrCache(id, addr)
 }
 
-// initBPNodeIDs initializes BlockProducer route and map from config file and DNS Seed
+// initBPNodeIDs initializes BlockProducer route and map from config file and bootstrap sources
 func initBPNodeIDs() (bpNodeIDs NodeIDAddressMap) {
 	// clear address map before init
 	resolver.bpNodeIDs = make(NodeIDAddressMap)
@@ -127,15 +144,12 @@ func initBPNodeIDs() (bpNodeIDs NodeIDAddressMap) {
 
 	var BPNodes = make(IDNodeMap)
 
-	// ignore DNS seed in test mode
+	// ignore bootstrap sources in test mode
 	if !conf.GConf.IsTestMode {
-		dc := NewDNSClient()
-		var seedDomain = BPDomain
-		//seedDomain = TestBPDomain
 		var err error
-		BPNodes, err = dc.GetBPFromDNSSeed(seedDomain)
+		BPNodes, err = BootstrapBPs(conf.GConf.Bootstrap)
 		if err != nil {
-			log.Errorf("getting BP addr from DNS %s failed: %s", seedDomain, err)
+			log.Errorf("getting BP addr from bootstrap sources failed: %s", err)
 			return
 		}
 	}
@@ -206,6 +220,10 @@ func InitKMS(PubKeyStoreFile string) {
 			if err != nil {
 				log.Errorf("set node failed: %v\n %s", node, err)
 			}
+			if n.Role == proto.Miner {
+				// the known nodes are pinned by config
+				SetNodeRole(n.ID, n.Role)
+			}
 			if n.ID == conf.GConf.ThisNodeID {
 				kms.SetLocalNodeIDNonce(rawNodeID.CloneBytes(), &n.Nonce)
 			}
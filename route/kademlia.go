@@ -0,0 +1,256 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+/*
+NOTE:
+	The routing table follows S/Kademlia:
+	1. Distance of nodes is the XOR of their NodeIDs, the i-th k-bucket holds nodes sharing
+	   exactly i leading bits with the local NodeID
+	1. A node is only accepted if its NodeID = Hash(PublicKey + Nonce) with enough difficulty,
+	   so NodeIDs can not be chosen freely to eclipse a part of the key space
+	1. A full bucket never evicts live nodes, newcomers wait in the replacement cache and take
+	   the place of nodes failing to respond, which favors long-lived nodes
+	1. Lookups run on LookupDisjointPaths disjoint paths which never query the same node, so
+	   a lookup succeeds if any path is free of adversarial nodes
+*/
+
+const (
+	// BucketSize is the k of Kademlia, the max count of nodes in a k-bucket and returned by
+	// FindNode
+	BucketSize = 20
+	// LookupAlpha is the count of concurrent queries in a lookup path
+	LookupAlpha = 3
+	// LookupDisjointPaths is the d of S/Kademlia, the count of disjoint paths of a lookup
+	LookupDisjointPaths = 3
+
+	// replacementCacheSize is the max count of nodes waiting for a place in a full k-bucket
+	replacementCacheSize = BucketSize
+	// bucketCount is the count of k-buckets, one for each possible common prefix length
+	bucketCount = hash.HashSize * 8
+)
+
+var (
+	// ErrInvalidNodeIDProof indicates the NodeID does not match the public key and nonce
+	ErrInvalidNodeIDProof = errors.New("node id does not match public key and nonce")
+	// ErrNodeIDDifficulty indicates the NodeID difficulty is lower than MinNodeIDDifficulty
+	ErrNodeIDDifficulty = errors.New("node id difficulty too low")
+
+	localTable     *RoutingTable
+	localTableLock sync.Mutex
+)
+
+type kBucket struct {
+	// nodes are sorted by last seen time, the most recently seen node is the last
+	nodes        []proto.Node
+	replacements []proto.Node
+}
+
+// RoutingTable is the S/Kademlia routing table of a node.
+type RoutingTable struct {
+	sync.RWMutex
+	self    proto.RawNodeID
+	buckets [bucketCount]kBucket
+}
+
+// VerifyNode checks the NodeID of the node is proved by its public key and nonce, and has
+// enough difficulty.
+func VerifyNode(node *proto.Node) (err error) {
+	if node == nil {
+		return ErrNilNodeID
+	}
+	id := node.ID.ToRawNodeID()
+	if id == nil || !kms.IsIDPubNonceValid(id, &node.Nonce, node.PublicKey) {
+		return ErrInvalidNodeIDProof
+	}
+	if conf.GConf != nil && id.Difficulty() < conf.GConf.MinNodeIDDifficulty {
+		return ErrNodeIDDifficulty
+	}
+	return
+}
+
+// CloserTo returns if a is closer than b to the target by XOR distance.
+func CloserTo(target, a, b *proto.RawNodeID) bool {
+	for i := range target.Hash {
+		da, db := a.Hash[i]^target.Hash[i], b.Hash[i]^target.Hash[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+// SortByDistance sorts the nodes by XOR distance to the target, the closest first.
+func SortByDistance(target *proto.RawNodeID, nodes []proto.Node) {
+	ids := make(map[proto.NodeID]*proto.RawNodeID, len(nodes))
+	for _, n := range nodes {
+		ids[n.ID] = n.ID.ToRawNodeID()
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return CloserTo(target, ids[nodes[i].ID], ids[nodes[j].ID])
+	})
+}
+
+// commonPrefixLen returns the count of leading bits shared by a and b.
+func commonPrefixLen(a, b *proto.RawNodeID) int {
+	for i := range a.Hash {
+		if x := a.Hash[i] ^ b.Hash[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return bucketCount
+}
+
+// NewRoutingTable returns a new empty routing table of the node self.
+func NewRoutingTable(self *proto.RawNodeID) *RoutingTable {
+	return &RoutingTable{self: *self}
+}
+
+// LocalRoutingTable returns the routing table of the local node, the table is created on first
+// call after the local NodeID is set in kms, and recreated if the local NodeID changes.
+func LocalRoutingTable() (rt *RoutingTable, err error) {
+	localTableLock.Lock()
+	defer localTableLock.Unlock()
+	nodeID, err := kms.GetLocalNodeID()
+	if err != nil {
+		return
+	}
+	self := nodeID.ToRawNodeID()
+	if self == nil {
+		return nil, ErrNilNodeID
+	}
+	if localTable == nil || !localTable.self.IsEqual(&self.Hash) {
+		localTable = NewRoutingTable(self)
+	}
+	return localTable, nil
+}
+
+// Self returns the NodeID of the table owner.
+func (rt *RoutingTable) Self() proto.NodeID {
+	return rt.self.ToNodeID()
+}
+
+// Add verifies the node and adds it to the table as the most recently seen node of its bucket.
+// If the bucket is full, the node is kept in the replacement cache of the bucket.
+func (rt *RoutingTable) Add(node *proto.Node) (err error) {
+	if err = VerifyNode(node); err != nil {
+		return
+	}
+	id := node.ID.ToRawNodeID()
+	if id.IsEqual(&rt.self.Hash) {
+		return
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+	b := &rt.buckets[commonPrefixLen(&rt.self, id)]
+	if i := indexOf(b.nodes, node.ID); i >= 0 {
+		b.nodes = append(b.nodes[:i], b.nodes[i+1:]...)
+		b.nodes = append(b.nodes, *node)
+		return
+	}
+	if len(b.nodes) < BucketSize {
+		b.nodes = append(b.nodes, *node)
+		return
+	}
+	if i := indexOf(b.replacements, node.ID); i >= 0 {
+		b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+	} else if len(b.replacements) >= replacementCacheSize {
+		b.replacements = b.replacements[1:]
+	}
+	b.replacements = append(b.replacements, *node)
+	return
+}
+
+// Remove removes the node failing to respond from the table, the most recently seen node in
+// the replacement cache of the bucket takes its place.
+func (rt *RoutingTable) Remove(id *proto.RawNodeID) {
+	rt.Lock()
+	defer rt.Unlock()
+	b := &rt.buckets[commonPrefixLen(&rt.self, id)]
+	nodeID := id.ToNodeID()
+	if i := indexOf(b.replacements, nodeID); i >= 0 {
+		b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+		return
+	}
+	i := indexOf(b.nodes, nodeID)
+	if i < 0 {
+		return
+	}
+	b.nodes = append(b.nodes[:i], b.nodes[i+1:]...)
+	if n := len(b.replacements); n > 0 {
+		b.nodes = append(b.nodes, b.replacements[n-1])
+		b.replacements = b.replacements[:n-1]
+	}
+}
+
+// Get returns the node with the id in the table, or nil if not found.
+func (rt *RoutingTable) Get(id *proto.RawNodeID) *proto.Node {
+	rt.RLock()
+	defer rt.RUnlock()
+	b := &rt.buckets[commonPrefixLen(&rt.self, id)]
+	if i := indexOf(b.nodes, id.ToNodeID()); i >= 0 {
+		node := b.nodes[i]
+		return &node
+	}
+	return nil
+}
+
+// Closest returns at most count nodes in the table closest to the target, the closest first.
+func (rt *RoutingTable) Closest(target *proto.RawNodeID, count int) (nodes []proto.Node) {
+	rt.RLock()
+	for i := range rt.buckets {
+		nodes = append(nodes, rt.buckets[i].nodes...)
+	}
+	rt.RUnlock()
+	SortByDistance(target, nodes)
+	if len(nodes) > count {
+		nodes = nodes[:count]
+	}
+	return
+}
+
+// Len returns the count of nodes in the table, not including the replacement caches.
+func (rt *RoutingTable) Len() (n int) {
+	rt.RLock()
+	defer rt.RUnlock()
+	for i := range rt.buckets {
+		n += len(rt.buckets[i].nodes)
+	}
+	return
+}
+
+func indexOf(nodes []proto.Node, id proto.NodeID) int {
+	for i := range nodes {
+		if nodes[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
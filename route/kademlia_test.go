@@ -0,0 +1,147 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func genNode(t *testing.T) *proto.Node {
+	_, pub, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := mine.Uint256{}
+	h := mine.HashBlock(pub.Serialize(), nonce)
+	return &proto.Node{
+		ID:        proto.NodeID(h.String()),
+		PublicKey: pub,
+		Nonce:     nonce,
+	}
+}
+
+// genNodeInBucket generates a node in the bucket i of the table.
+func genNodeInBucket(t *testing.T, rt *RoutingTable, i int) *proto.Node {
+	for {
+		n := genNode(t)
+		if commonPrefixLen(&rt.self, n.ID.ToRawNodeID()) == i {
+			return n
+		}
+	}
+}
+
+func TestVerifyNode(t *testing.T) {
+	defer func(c *conf.Config) { conf.GConf = c }(conf.GConf)
+	conf.GConf = nil
+
+	Convey("node id should be proved by public key and nonce", t, func() {
+		node := genNode(t)
+		So(VerifyNode(node), ShouldBeNil)
+		So(VerifyNode(nil), ShouldEqual, ErrNilNodeID)
+
+		forged := *node
+		forged.PublicKey = genNode(t).PublicKey
+		So(VerifyNode(&forged), ShouldEqual, ErrInvalidNodeIDProof)
+		forged = *node
+		forged.Nonce = mine.Uint256{A: 1}
+		So(VerifyNode(&forged), ShouldEqual, ErrInvalidNodeIDProof)
+		forged = *node
+		forged.PublicKey = nil
+		So(VerifyNode(&forged), ShouldEqual, ErrInvalidNodeIDProof)
+
+		conf.GConf = &conf.Config{MinNodeIDDifficulty: 256}
+		So(VerifyNode(node), ShouldEqual, ErrNodeIDDifficulty)
+	})
+}
+
+func TestRoutingTable(t *testing.T) {
+	defer func(c *conf.Config) { conf.GConf = c }(conf.GConf)
+	conf.GConf = nil
+
+	Convey("verified nodes should be added to the table", t, func() {
+		self := genNode(t)
+		rt := NewRoutingTable(self.ID.ToRawNodeID())
+		So(rt.Self(), ShouldEqual, self.ID)
+
+		So(rt.Add(self), ShouldBeNil)
+		So(rt.Len(), ShouldEqual, 0)
+
+		node := genNode(t)
+		So(rt.Add(node), ShouldBeNil)
+		So(rt.Add(node), ShouldBeNil)
+		So(rt.Len(), ShouldEqual, 1)
+		So(rt.Get(node.ID.ToRawNodeID()), ShouldResemble, node)
+
+		forged := *genNode(t)
+		forged.PublicKey = node.PublicKey
+		So(rt.Add(&forged), ShouldEqual, ErrInvalidNodeIDProof)
+		So(rt.Get(forged.ID.ToRawNodeID()), ShouldBeNil)
+
+		rt.Remove(node.ID.ToRawNodeID())
+		So(rt.Len(), ShouldEqual, 0)
+		So(rt.Get(node.ID.ToRawNodeID()), ShouldBeNil)
+	})
+	Convey("full bucket should keep newcomers in replacement cache", t, func() {
+		rt := NewRoutingTable(genNode(t).ID.ToRawNodeID())
+		nodes := make([]*proto.Node, BucketSize)
+		for i := range nodes {
+			nodes[i] = genNodeInBucket(t, rt, 0)
+			So(rt.Add(nodes[i]), ShouldBeNil)
+		}
+		newcomer := genNodeInBucket(t, rt, 0)
+		So(rt.Add(newcomer), ShouldBeNil)
+		So(rt.Len(), ShouldEqual, BucketSize)
+		So(rt.Get(newcomer.ID.ToRawNodeID()), ShouldBeNil)
+
+		// live nodes are never evicted by newcomers
+		So(rt.Get(nodes[0].ID.ToRawNodeID()), ShouldNotBeNil)
+
+		// the replacement takes the place of the node failing to respond
+		rt.Remove(nodes[0].ID.ToRawNodeID())
+		So(rt.Len(), ShouldEqual, BucketSize)
+		So(rt.Get(nodes[0].ID.ToRawNodeID()), ShouldBeNil)
+		So(rt.Get(newcomer.ID.ToRawNodeID()), ShouldResemble, newcomer)
+
+		rt.Remove(nodes[1].ID.ToRawNodeID())
+		So(rt.Len(), ShouldEqual, BucketSize-1)
+	})
+	Convey("closest nodes should be sorted by xor distance", t, func() {
+		rt := NewRoutingTable(genNode(t).ID.ToRawNodeID())
+		for i := 0; i < 50; i++ {
+			So(rt.Add(genNode(t)), ShouldBeNil)
+		}
+		target := genNode(t).ID.ToRawNodeID()
+		closest := rt.Closest(target, 10)
+		So(closest, ShouldHaveLength, 10)
+		for i := 1; i < len(closest); i++ {
+			So(CloserTo(target, closest[i].ID.ToRawNodeID(), closest[i-1].ID.ToRawNodeID()),
+				ShouldBeFalse)
+		}
+		all := rt.Closest(target, rt.Len())
+		So(all[:10], ShouldResemble, closest)
+		for _, n := range all[10:] {
+			So(CloserTo(target, n.ID.ToRawNodeID(), closest[9].ID.ToRawNodeID()), ShouldBeFalse)
+		}
+		So(rt.Closest(target, 1000), ShouldHaveLength, rt.Len())
+	})
+}
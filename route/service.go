@@ -26,11 +26,17 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
-// DHTService is server side RPC implementation
+// DHTService is server side RPC implementation, the Consistent ring of registered nodes only
+// exists on block producers, while every node serves lookups by its local routing table.
 type DHTService struct {
 	Consistent *consistent.Consistent
 }
 
+// NewPeerDHTService will return a new DHTService serving lookups by the local routing table only
+func NewPeerDHTService() (s *DHTService) {
+	return &DHTService{}
+}
+
 // NewDHTServiceWithRing will return a new DHTService and set an existing hash ring
 func NewDHTServiceWithRing(c *consistent.Consistent) (s *DHTService, err error) {
 	s = &DHTService{
@@ -56,13 +62,49 @@ func (DHT *DHTService) FindNode(req *proto.FindNodeReq, resp *proto.FindNodeResp
 		log.Error(err)
 		return
 	}
-	node, err := DHT.Consistent.GetNode(string(req.NodeID))
-	if err != nil {
-		err = fmt.Errorf("get node %s from DHT failed: %s", req.NodeID, err)
+	target := req.NodeID.ToRawNodeID()
+	if target == nil {
+		err = fmt.Errorf("invalid node id %s", req.NodeID)
 		log.Error(err)
 		return
 	}
-	resp.Node = node
+
+	// the routing table is not available before the local node id is initialized
+	rt, errRT := LocalRoutingTable()
+	if errRT == nil && req.Count > 0 {
+		count := req.Count
+		if count > BucketSize {
+			count = BucketSize
+		}
+		resp.Closest = rt.Closest(target, count)
+	}
+
+	if DHT.Consistent != nil {
+		if resp.Node, err = DHT.Consistent.GetNode(string(req.NodeID)); err == nil {
+			return
+		}
+		if len(resp.Closest) == 0 {
+			err = fmt.Errorf("get node %s from DHT failed: %s", req.NodeID, err)
+			log.Error(err)
+			return
+		}
+		err = nil
+	}
+
+	if errRT != nil {
+		err = fmt.Errorf("get local routing table failed: %s", errRT)
+		log.Error(err)
+		return
+	}
+	if rt.Self() == req.NodeID {
+		resp.Node, err = kms.GetNodeInfo(req.NodeID)
+		if err != nil {
+			err = fmt.Errorf("get local node info failed: %s", err)
+			log.Error(err)
+		}
+		return
+	}
+	resp.Node = rt.Get(target)
 	return
 }
 
@@ -74,7 +116,12 @@ func (DHT *DHTService) FindNeighbor(req *proto.FindNeighborReq, resp *proto.Find
 		return
 	}
 
-	nodes, err := DHT.Consistent.GetNeighborsEx(string(req.NodeID), req.Count, req.Roles)
+	var nodes []proto.Node
+	if DHT.Consistent != nil {
+		nodes, err = DHT.Consistent.GetNeighborsEx(string(req.NodeID), req.Count, req.Roles)
+	} else {
+		nodes, err = findNeighborInTable(req.NodeID, req.Count, req.Roles)
+	}
 	if err != nil {
 		err = fmt.Errorf("get nodes from DHT failed: %s", err)
 		log.Error(err)
@@ -108,11 +155,54 @@ func (DHT *DHTService) Ping(req *proto.PingReq, resp *proto.PingResp) (err error
 		return
 	}
 
-	err = DHT.Consistent.Add(req.Node)
+	// only nodes announcing themselves by ETLS are added to the routing table and saved for
+	// dialing, so addresses can not be forged by others
+	if callerID := req.GetNodeID(); callerID != nil && callerID.ToNodeID() == req.Node.ID {
+		if rt, errRT := LocalRoutingTable(); errRT == nil {
+			rt.Add(&req.Node)
+		}
+		if errCache := SetNodeAddrCache(callerID, req.Node.Addr); errCache != nil {
+			log.WithField("node", req.Node.ID).WithError(errCache).Warning("set node addr cache failed")
+		}
+		if errKMS := kms.SetNode(&req.Node); errKMS != nil {
+			log.WithField("node", req.Node.ID).WithError(errKMS).Warning("set node to kms failed")
+		}
+	}
+
+	if DHT.Consistent != nil {
+		err = DHT.Consistent.Add(req.Node)
+		if err != nil {
+			err = fmt.Errorf("DHT.Consistent.Add %v failed: %s", req.Node, err)
+			return
+		}
+	}
+	resp.Msg = "Pong"
+	return
+}
+
+func findNeighborInTable(id proto.NodeID, count int, roles []proto.ServerRole) (nodes []proto.Node, err error) {
+	target := id.ToRawNodeID()
+	if target == nil {
+		return nil, ErrNilNodeID
+	}
+	rt, err := LocalRoutingTable()
 	if err != nil {
-		err = fmt.Errorf("DHT.Consistent.Add %v failed: %s", req.Node, err)
-	} else {
-		resp.Msg = "Pong"
+		return
+	}
+	for _, n := range rt.Closest(target, BucketSize) {
+		if len(nodes) >= count {
+			break
+		}
+		if len(roles) == 0 {
+			nodes = append(nodes, n)
+			continue
+		}
+		for _, r := range roles {
+			if n.Role == r {
+				nodes = append(nodes, n)
+				break
+			}
+		}
 	}
 	return
 }
@@ -21,6 +21,8 @@ import (
 	"net"
 	"net/rpc"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -272,3 +274,41 @@ func TestDHTService_Ping(t *testing.T) {
 	log.Debugf("respA2: %v", respA2)
 	rc.Close()
 }
+
+func TestDHTService_PingAnnounce(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../test/node_0/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+	conf.GConf.MinNodeIDDifficulty = 0
+	kms.ResetBucket()
+
+	NewNodeIDDifficultyTimeout = 100 * time.Millisecond
+	dht := NewPeerDHTService()
+
+	Convey("node relayed by others should not be saved for dialing", t, func() {
+		node := NewNode()
+		So(node.InitNodeCryptoInfo(100*time.Millisecond), ShouldBeNil)
+		node.Addr = "127.0.0.1:1234"
+		req := &PingReq{Node: *node}
+		So(dht.Ping(req, new(PingResp)), ShouldBeNil)
+		_, err := GetNodeAddrCache(node.ID.ToRawNodeID())
+		So(err, ShouldEqual, ErrUnknownNodeID)
+		_, err = kms.GetNodeInfo(node.ID)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("node announced itself by ETLS should be saved for dialing", t, func() {
+		node := NewNode()
+		So(node.InitNodeCryptoInfo(100*time.Millisecond), ShouldBeNil)
+		node.Addr = "127.0.0.1:1235"
+		req := &PingReq{Node: *node}
+		req.SetNodeID(node.ID.ToRawNodeID())
+		So(dht.Ping(req, new(PingResp)), ShouldBeNil)
+		addr, err := GetNodeAddrCache(node.ID.ToRawNodeID())
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, node.Addr)
+		info, err := kms.GetNodeInfo(node.ID)
+		So(err, ShouldBeNil)
+		So(info.Addr, ShouldEqual, node.Addr)
+	})
+}
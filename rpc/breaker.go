@@ -0,0 +1,92 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var (
+	// BreakerFailureThreshold is the count of consecutive failures to open the circuit of a node.
+	BreakerFailureThreshold = 5
+	// BreakerCooldown is the duration an open circuit waits before probing the node again.
+	BreakerCooldown = 10 * time.Second
+)
+
+// circuitBreaker stops calling a node after consecutive failures, and lets a single probe call
+// through after cooldown to decide whether to close the circuit again.
+type circuitBreaker struct {
+	sync.Mutex
+	state    breakerState
+	failures int
+	since    time.Time
+}
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "Closed"
+	case breakerOpen:
+		return "Open"
+	case breakerHalfOpen:
+		return "HalfOpen"
+	}
+	return "Unknown"
+}
+
+// allow returns ErrCircuitOpen if calling the node is not allowed now.
+func (b *circuitBreaker) allow() (err error) {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case breakerOpen, breakerHalfOpen:
+		// half-open probe is also renewed after cooldown in case its result is never reported
+		if time.Since(b.since) < BreakerCooldown {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.since = time.Now()
+	}
+	return
+}
+
+// report records the result of a call and returns true if the circuit is opened by it.
+func (b *circuitBreaker) report(failed bool) (opened bool) {
+	b.Lock()
+	defer b.Unlock()
+	if !failed {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= BreakerFailureThreshold) {
+		b.state = breakerOpen
+		b.since = time.Now()
+		opened = true
+	}
+	return
+}
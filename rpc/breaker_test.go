@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	origThreshold, origCooldown := BreakerFailureThreshold, BreakerCooldown
+	BreakerFailureThreshold, BreakerCooldown = 3, 100*time.Millisecond
+	defer func() {
+		BreakerFailureThreshold, BreakerCooldown = origThreshold, origCooldown
+	}()
+
+	Convey("circuit should open after consecutive failures and close after a probe", t, func() {
+		b := &circuitBreaker{}
+		So(b.report(true), ShouldBeFalse)
+		So(b.report(false), ShouldBeFalse)
+		So(b.report(true), ShouldBeFalse)
+		So(b.report(true), ShouldBeFalse)
+		So(b.allow(), ShouldBeNil)
+		So(b.report(true), ShouldBeTrue)
+		So(b.state, ShouldEqual, breakerOpen)
+		So(b.allow(), ShouldEqual, ErrCircuitOpen)
+
+		// single probe after cooldown
+		time.Sleep(BreakerCooldown)
+		So(b.allow(), ShouldBeNil)
+		So(b.state, ShouldEqual, breakerHalfOpen)
+		So(b.allow(), ShouldEqual, ErrCircuitOpen)
+
+		// failed probe opens the circuit again
+		So(b.report(true), ShouldBeTrue)
+		So(b.allow(), ShouldEqual, ErrCircuitOpen)
+
+		time.Sleep(BreakerCooldown)
+		So(b.allow(), ShouldBeNil)
+		So(b.report(false), ShouldBeFalse)
+		So(b.state, ShouldEqual, breakerClosed)
+		So(b.allow(), ShouldBeNil)
+	})
+
+	Convey("session pool should fail fast on node with open circuit", t, func() {
+		var dials int
+		p := newSessionPool(func(nodeID proto.NodeID) (net.Conn, error) {
+			dials++
+			return nil, errors.New("connection refused")
+		})
+		for i := 0; i < BreakerFailureThreshold; i++ {
+			_, err := p.Get("0000")
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotEqual, ErrCircuitOpen)
+		}
+		_, err := p.Get("0000")
+		So(err, ShouldEqual, ErrCircuitOpen)
+		So(dials, ShouldEqual, BreakerFailureThreshold)
+
+		// other nodes are not affected
+		_, err = p.Get("0001")
+		So(err, ShouldNotEqual, ErrCircuitOpen)
+
+		// errors replied by server do not count
+		for i := 0; i < BreakerFailureThreshold; i++ {
+			p.ReportResult("0002", rpc.ServerError("bad request"))
+		}
+		So(p.getBreaker("0002").allow(), ShouldBeNil)
+
+		p.Close()
+		So(p.getBreaker("0000").allow(), ShouldBeNil)
+	})
+}
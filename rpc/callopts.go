@@ -0,0 +1,250 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
+)
+
+// CallOption defines an option of rpc call.
+type CallOption func(o *callOptions)
+
+type callOptions struct {
+	timeout time.Duration
+	retry   RetryPolicy
+}
+
+// RetryPolicy defines the retry policy of idempotent rpc calls. The n-th retry waits
+// min(InitialBackoff * Multiplier^(n-1), MaxBackoff), randomized by ±Jitter of itself.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+var (
+	// DefaultRetryPolicy is the retry policy of builtin idempotent rpc methods.
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+
+	methodOptionsLock sync.RWMutex
+	methodOptions     = map[string][]CallOption{
+		route.DHTPing.String():                        idempotent(10 * time.Second),
+		route.DHTFindNode.String():                    idempotent(10 * time.Second),
+		route.DHTFindNeighbor.String():                idempotent(10 * time.Second),
+		route.MetricUploadMetrics.String():            {WithTimeout(10 * time.Second)},
+		route.DBSQuery.String():                       {WithTimeout(time.Minute)},
+		route.DBSAck.String():                         {WithTimeout(10 * time.Second)},
+		route.DBSDeploy.String():                      {WithTimeout(time.Minute)},
+		route.DBSGetRequest.String():                  idempotent(10 * time.Second),
+		route.BPDBCreateDatabase.String():             {WithTimeout(3 * time.Minute)},
+		route.BPDBDropDatabase.String():               {WithTimeout(3 * time.Minute)},
+		route.BPDBGetDatabase.String():                idempotent(10 * time.Second),
+		route.BPDBGetNodeDatabases.String():           idempotent(10 * time.Second),
+		route.SQLCFetchBlock.String():                 idempotent(10 * time.Second),
+		route.SQLCFetchAckedQuery.String():            idempotent(10 * time.Second),
+		route.SQLCQueryTime.String():                  {WithTimeout(5 * time.Second)},
+		route.MCCNextAccountNonce.String():            idempotent(10 * time.Second),
+		route.MCCAddTx.String():                       {WithTimeout(10 * time.Second)},
+		route.MCCAddTxTransfer.String():               {WithTimeout(10 * time.Second)},
+		route.MCCQueryAccountStableBalance.String():   idempotent(10 * time.Second),
+		route.MCCQueryAccountCovenantBalance.String(): idempotent(10 * time.Second),
+		route.MCCQueryAccountDatabases.String():       idempotent(10 * time.Second),
+		route.MCCQuerySQLChainProfile.String():        idempotent(10 * time.Second),
+		route.MCCQueryTxHistory.String():              idempotent(10 * time.Second),
+		route.MCCQueryTxByHash.String():               idempotent(10 * time.Second),
+		route.MCCFetchBlock.String():                  idempotent(10 * time.Second),
+		route.MCCFetchTxBilling.String():              idempotent(10 * time.Second),
+		route.MCCQueryTime.String():                   {WithTimeout(5 * time.Second)},
+	}
+)
+
+func idempotent(timeout time.Duration) []CallOption {
+	return []CallOption{WithTimeout(timeout), WithRetry(DefaultRetryPolicy)}
+}
+
+// WithTimeout sets the timeout of each attempt of the call, 0 means no timeout. The remaining
+// time is sent to the server as the request TTL if the args has an envelope.
+func WithTimeout(timeout time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetry sets the retry policy of the call, it should only be used by idempotent methods.
+func WithRetry(policy RetryPolicy) CallOption {
+	return func(o *callOptions) {
+		o.retry = policy
+	}
+}
+
+// WithoutRetry disables the retry of the call.
+func WithoutRetry() CallOption {
+	return func(o *callOptions) {
+		o.retry = RetryPolicy{}
+	}
+}
+
+// SetMethodCallOptions sets the default options of the rpc method, which are applied before the
+// options passed to each call.
+func SetMethodCallOptions(method string, opts ...CallOption) {
+	methodOptionsLock.Lock()
+	defer methodOptionsLock.Unlock()
+	methodOptions[method] = opts
+}
+
+func newCallOptions(method string, opts []CallOption) (o *callOptions) {
+	o = &callOptions{}
+	methodOptionsLock.RLock()
+	defaults := methodOptions[method]
+	methodOptionsLock.RUnlock()
+	for _, opt := range defaults {
+		opt(o)
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return
+}
+
+func (o *callOptions) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout > 0 {
+		return context.WithTimeout(ctx, o.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (o *callOptions) shouldRetry(ctx context.Context, attempt int, err error) bool {
+	return attempt < o.retry.MaxAttempts && ctx.Err() == nil && isRetryable(err)
+}
+
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	b := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && b > float64(p.MaxBackoff) {
+		b = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		b += b * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(b)
+}
+
+// waitBackoff waits the backoff of the retry or until ctx is done.
+func (o *callOptions) waitBackoff(ctx context.Context, retry int) (err error) {
+	timer := time.NewTimer(o.retry.backoff(retry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+	}
+	return
+}
+
+// isRetryable returns if the call failed before the server handled it, errors replied by the
+// server are never retried.
+func isRetryable(err error) bool {
+	if _, ok := err.(rpc.ServerError); ok {
+		return false
+	}
+	return err != ErrCircuitOpen && err != context.Canceled
+}
+
+// isNodeFailure returns if the error indicates that the remote node is unreachable or too slow.
+func isNodeFailure(err error) bool {
+	return err != nil && isRetryable(err)
+}
+
+// isConnBroken returns if the client connection is unusable any more.
+func isConnBroken(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == rpc.ErrShutdown
+}
+
+// withRequestContext returns a shallow copy of the args carrying the remaining time of ctx as
+// request TTL and the span of ctx as trace parent, the args itself is left untouched as it may be
+// shared by concurrent calls.
+func withRequestContext(ctx context.Context, args interface{}) interface{} {
+	deadline, hasDeadline := ctx.Deadline()
+	traceParent := trace.TraceParent(ctx)
+	if !hasDeadline && traceParent == "" {
+		return args
+	}
+	if _, ok := args.(proto.EnvelopeAPI); !ok {
+		return args
+	}
+	v := reflect.ValueOf(args)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return args
+	}
+	cp := reflect.New(v.Elem().Type())
+	cp.Elem().Set(v.Elem())
+	env := cp.Interface().(proto.EnvelopeAPI)
+	if hasDeadline {
+		env.SetTTL(time.Until(deadline))
+	}
+	if traceParent != "" {
+		env.SetTraceParent(traceParent)
+	}
+	return cp.Interface()
+}
+
+// callWithContext invokes the call and aborts it by closing the client once ctx is done.
+func callWithContext(ctx context.Context, client *Client, method string, args, reply interface{}) (err error) {
+	ctx, span := trace.StartSpan(ctx, method, trace.SpanKindClient)
+	span.SetAttribute("rpc.system", rpcSystem)
+	span.SetAttribute("rpc.method", method)
+	span.SetAttribute("network.peer.address", client.RemoteAddr)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	call := client.Go(method, withRequestContext(ctx, args), reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		// net/rpc does not support cancel in progress calls, close the client to abort the call and
+		// wait it done, so that the reply won't be written after return
+		client.Close()
+		<-call.Done
+		err = ctx.Err()
+	case <-call.Done:
+		err = call.Error
+	}
+	return
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type SleepReq struct {
+	proto.Envelope
+	Duration time.Duration
+}
+
+type SleepResp struct {
+	HasDeadline bool
+	Remaining   time.Duration
+}
+
+type sleepService struct {
+	aborted chan error
+}
+
+func (s *sleepService) Sleep(req *SleepReq, resp *SleepResp) error {
+	ctx := req.GetContext()
+	deadline, ok := ctx.Deadline()
+	resp.HasDeadline = ok
+	if ok {
+		resp.Remaining = time.Until(deadline)
+	}
+	select {
+	case <-time.After(req.Duration):
+		return nil
+	case <-ctx.Done():
+		s.aborted <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	Convey("backoff should grow with jitter and be capped", t, func() {
+		p := RetryPolicy{
+			MaxAttempts:    5,
+			InitialBackoff: 100 * time.Millisecond,
+			MaxBackoff:     time.Second,
+			Multiplier:     2,
+			Jitter:         0.2,
+		}
+		for i := 0; i < 100; i++ {
+			So(p.backoff(1), ShouldBeBetweenOrEqual, 80*time.Millisecond, 120*time.Millisecond)
+			So(p.backoff(2), ShouldBeBetweenOrEqual, 160*time.Millisecond, 240*time.Millisecond)
+			So(p.backoff(10), ShouldBeBetweenOrEqual, 800*time.Millisecond, 1200*time.Millisecond)
+		}
+	})
+
+	Convey("method options should be overridden by call options", t, func() {
+		SetMethodCallOptions("Test.Idempotent", WithTimeout(time.Second), WithRetry(DefaultRetryPolicy))
+		o := newCallOptions("Test.Idempotent", nil)
+		So(o.timeout, ShouldEqual, time.Second)
+		So(o.retry, ShouldResemble, DefaultRetryPolicy)
+		o = newCallOptions("Test.Idempotent", []CallOption{WithoutRetry(), WithTimeout(0)})
+		So(o.timeout, ShouldEqual, 0)
+		So(o.shouldRetry(context.Background(), 1, errors.New("dial failed")), ShouldBeFalse)
+		o = newCallOptions("Test.Unknown", nil)
+		So(o.timeout, ShouldEqual, 0)
+		So(o.retry.MaxAttempts, ShouldEqual, 0)
+	})
+
+	Convey("only errors before server handling should be retried", t, func() {
+		o := newCallOptions("Test.Idempotent", nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		So(o.shouldRetry(ctx, 1, errors.New("dial failed")), ShouldBeTrue)
+		So(o.shouldRetry(ctx, 1, context.DeadlineExceeded), ShouldBeTrue)
+		So(o.shouldRetry(ctx, o.retry.MaxAttempts, context.DeadlineExceeded), ShouldBeFalse)
+		So(o.shouldRetry(ctx, 1, rpc.ServerError("bad request")), ShouldBeFalse)
+		So(o.shouldRetry(ctx, 1, ErrCircuitOpen), ShouldBeFalse)
+		cancel()
+		So(o.shouldRetry(ctx, 1, errors.New("dial failed")), ShouldBeFalse)
+	})
+}
+
+func TestCallWithContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &sleepService{aborted: make(chan error, 1)}
+	server, err := NewServerWithService(ServiceMap{"Sleep": service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetListener(l)
+	go server.Serve()
+	defer server.Stop()
+
+	call := func(ctx context.Context, req *SleepReq, resp *SleepResp) (err error) {
+		client, err := initClient(l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		return callWithContext(ctx, client, "Sleep.Sleep", req, resp)
+	}
+
+	Convey("deadline should be propagated to server as local deadline", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req := &SleepReq{}
+		resp := &SleepResp{}
+		So(call(ctx, req, resp), ShouldBeNil)
+		So(resp.HasDeadline, ShouldBeTrue)
+		So(resp.Remaining, ShouldBeBetweenOrEqual, time.Second, 5*time.Second)
+		// shared args is not modified
+		So(req.TTL, ShouldEqual, 0)
+
+		resp = &SleepResp{}
+		So(call(context.Background(), &SleepReq{Envelope: proto.Envelope{ExpireUnixNano: 1}}, resp), ShouldBeNil)
+		So(resp.HasDeadline, ShouldBeFalse)
+	})
+
+	Convey("in progress call should be aborted by context", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := call(ctx, &SleepReq{Duration: 3 * time.Second}, &SleepResp{})
+		So(err == context.DeadlineExceeded, ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+		// handler is aborted by the local deadline derived from the envelope
+		select {
+		case err = <-service.aborted:
+			So(err == context.DeadlineExceeded, ShouldBeTrue)
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler is not aborted by deadline")
+		}
+	})
+}
+
+func TestCaller_Retry(t *testing.T) {
+	Convey("idempotent call should be retried until max attempts", t, func() {
+		var dials int
+		c := &Caller{pool: newSessionPool(func(nodeID proto.NodeID) (net.Conn, error) {
+			dials++
+			return nil, errors.New("connection refused")
+		})}
+		policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+		err := c.CallNode("0000", "Test.Retry", &SleepReq{}, &SleepResp{}, WithRetry(policy))
+		So(err, ShouldNotBeNil)
+		So(dials, ShouldEqual, 3)
+
+		err = c.CallNode("0001", "Test.Retry", &SleepReq{}, &SleepResp{})
+		So(err, ShouldNotBeNil)
+		So(dials, ShouldEqual, 4)
+	})
+}
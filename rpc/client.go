@@ -18,15 +18,21 @@
 package rpc
 
 import (
+	"io"
 	"net"
 	"net/rpc"
+	"os"
+	"sync"
+	"syscall"
 
 	"io/ioutil"
 
+	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	"github.com/hashicorp/yamux"
 	"github.com/ugorji/go/codec"
@@ -60,6 +66,90 @@ func dial(network, address string, remoteNodeID *proto.RawNodeID, cipher *etls.C
 		log.Errorf("connect to %s failed: %s", address, err)
 		return
 	}
+	return newClientConn(conn, remoteNodeID, cipher, isAnonymous)
+}
+
+// dialWithFallback works as dial and runs the handshake before any data is sent, so that an old
+// node failing the handshake of ETLS version 2 is dialed again with the legacy ETLS if the legacy
+// ETLS is enabled and the node is not known to speak ETLS version 2.
+func dialWithFallback(network, address string, remoteNodeID *proto.RawNodeID, cipher *etls.Cipher, isAnonymous bool) (c *etls.CryptoConn, err error) {
+	if c, err = dial(network, address, remoteNodeID, cipher, isAnonymous); err != nil {
+		return
+	}
+	peer := etlsPeerKey(address, remoteNodeID)
+	if err = c.Handshake(); err == nil {
+		etlsV2Peers.Store(peer, true)
+		return
+	}
+	c.Close()
+	if !fallbackToLegacy(peer, err) {
+		log.WithField("addr", address).WithError(err).Error("etls handshake failed")
+		return nil, err
+	}
+	return dial(network, address, remoteNodeID, cipher.Legacy(), isAnonymous)
+}
+
+// etlsV2Peers records the peers which have completed the handshake of ETLS version 2, they are
+// never dialed with the legacy ETLS again, so that the connections to them cannot be downgraded
+// by resetting the handshake.
+var etlsV2Peers sync.Map
+
+// etlsPeerKey returns the key of the peer in etlsV2Peers, which is the node id of the peer, or
+// its address if the node id is unknown.
+func etlsPeerKey(address string, remoteNodeID *proto.RawNodeID) string {
+	if remoteNodeID != nil {
+		return remoteNodeID.String()
+	}
+	return address
+}
+
+// fallbackToLegacy returns whether the peer failing the handshake of ETLS version 2 with err
+// should be dialed again with the legacy ETLS.
+func fallbackToLegacy(peer string, err error) bool {
+	if !legacyETLSEnabled() || !isLegacyETLSPeer(err) {
+		return false
+	}
+	if _, ok := etlsV2Peers.Load(peer); ok {
+		log.WithField("peer", peer).WithError(err).Warning(
+			"refuse to fall back to legacy etls for peer speaking etls version 2")
+		return false
+	}
+	log.WithField("peer", peer).WithError(err).Warning("fall back to legacy etls")
+	return true
+}
+
+// legacyETLSEnabled returns whether the ETLS version 1 peers are accepted, which is opt-in by
+// EnableLegacyETLS.
+func legacyETLSEnabled() bool {
+	return conf.GConf != nil && conf.GConf.EnableLegacyETLS
+}
+
+// newCipher returns the ETLS cipher of symmetricKey, which accepts the ETLS version 1 peers only if
+// the legacy ETLS is enabled.
+func newCipher(symmetricKey []byte) *etls.Cipher {
+	if legacyETLSEnabled() {
+		return etls.NewCipher(symmetricKey)
+	}
+	return etls.NewStrictCipher(symmetricKey)
+}
+
+// isLegacyETLSPeer returns whether the handshake error indicates an old peer, which does not
+// answer the hello of ETLS version 2 but drops the connection or answers garbage.
+func isLegacyETLSPeer(err error) bool {
+	switch err {
+	case etls.ErrInvalidHello, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.ECONNRESET
+		}
+	}
+	return false
+}
+
+// newClientConn sends the local NodeID and nonce on the connection and wraps it by ETLS.
+func newClientConn(conn net.Conn, remoteNodeID *proto.RawNodeID, cipher *etls.Cipher, isAnonymous bool) (c *etls.CryptoConn, err error) {
 	var writeBuf []byte
 	if isAnonymous {
 		writeBuf = append(kms.AnonymousRawNodeID.CloneBytes(), (&cpuminer.Uint256{}).Bytes()...)
@@ -82,10 +172,11 @@ func dial(network, address string, remoteNodeID *proto.RawNodeID, cipher *etls.C
 	wrote, err := conn.Write(writeBuf)
 	if err != nil || wrote != len(writeBuf) {
 		log.Errorf("write node id and nonce failed: %s", err)
+		conn.Close()
 		return
 	}
 
-	c = etls.NewConn(conn, cipher, remoteNodeID)
+	c = etls.NewClientConn(conn, cipher, remoteNodeID)
 	return
 }
 
@@ -125,8 +216,8 @@ func dialToNodeEx(nodeID proto.NodeID, isAnonymous bool) (conn net.Conn, err err
 	var rawNodeID = nodeID.ToRawNodeID()
 	/*
 		As a common practice of PKI, we should add some randomness to the ECDHed pre-master-key
-		we did that at the [ETLS](../crypto/etls) layer with a non-deterministic authenticated
-		encryption input vector(random IV).
+		we did that at the [ETLS](../crypto/etls) layer, which mixes it with the secret of an
+		ephemeral ECDH per connection to derive the session keys.
 
 		To understand that and func "rpc.GetSharedSecretWith"
 		Please refer to:
@@ -150,8 +241,34 @@ func dialToNodeEx(nodeID proto.NodeID, isAnonymous bool) (conn net.Conn, err err
 		return
 	}
 
-	cipher := etls.NewCipher(symmetricKey)
-	conn, err = dial("tcp", nodeAddr, rawNodeID, cipher, isAnonymous)
+	return dialToAddr(nodeAddr, rawNodeID, newCipher(symmetricKey), isAnonymous)
+}
+
+// dialToContact connects to the node learned from the DHT with its own address and public key,
+// which are not cached for other dials.
+func dialToContact(node *proto.Node) (conn net.Conn, err error) {
+	if err = route.VerifyNode(node); err != nil {
+		return
+	}
+	var rawNodeID = node.ID.ToRawNodeID()
+	symmetricKey, err := genSharedSecret(rawNodeID, node.PublicKey)
+	if err != nil {
+		log.Errorf("get shared secret for %s failed: %s", node.ID, err)
+		return
+	}
+	return dialToAddr(node.Addr, rawNodeID, newCipher(symmetricKey), false)
+}
+
+// dialToAddr connects to the node at nodeAddr, which may be the relay address of a node behind NAT.
+func dialToAddr(nodeAddr string, rawNodeID *proto.RawNodeID, cipher *etls.Cipher, isAnonymous bool) (
+	conn net.Conn, err error,
+) {
+	if relayID, ok := ParseRelayAddr(nodeAddr); ok {
+		// the node is behind NAT, connect through its relay
+		conn, err = dialRelayed(relayID, rawNodeID, cipher, isAnonymous)
+	} else {
+		conn, err = dialWithFallback("tcp", nodeAddr, rawNodeID, cipher, isAnonymous)
+	}
 	if err != nil {
 		log.Errorf("connect to %s: %s", nodeAddr, err)
 		return
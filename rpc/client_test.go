@@ -17,9 +17,13 @@
 package rpc
 
 import (
+	"io"
 	"net"
 	"testing"
 
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	. "github.com/smartystreets/goconvey/convey"
@@ -61,6 +65,72 @@ func TestDial(t *testing.T) {
 	})
 }
 
+// serveLegacyEcho serves the connections of l as an old node speaking the legacy ETLS only, which
+// echoes 4 bytes on each connection.
+func serveLegacyEcho(l net.Listener, key []byte) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			header := make([]byte, hash.HashBSize+32)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			c := etls.NewConn(conn, etls.NewLegacyCipher(key), nil)
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			c.Write(buf)
+		}(conn)
+	}
+}
+
+func TestDialWithFallback(t *testing.T) {
+	Convey("dial to an old node should fall back to the legacy etls if enabled", t, func() {
+		saved := conf.GConf
+		conf.GConf = &conf.Config{EnableLegacyETLS: true}
+		defer func() { conf.GConf = saved }()
+		key := []byte(pass)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		go serveLegacyEcho(l, key)
+
+		c, err := dialWithFallback("tcp", l.Addr().String(), nil, etls.NewCipher(key), true)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		So(err, ShouldBeNil)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldEqual, "ping")
+
+		Convey("the fallback should be refused by default", func() {
+			conf.GConf = &conf.Config{}
+			c, err := dialWithFallback("tcp", l.Addr().String(), nil, newCipher(key), true)
+			So(c, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			conf.GConf = nil
+			c, err = dialWithFallback("tcp", l.Addr().String(), nil, newCipher(key), true)
+			So(c, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("the fallback should be refused for a peer known to speak etls version 2", func() {
+			peer := etlsPeerKey(l.Addr().String(), nil)
+			etlsV2Peers.Store(peer, true)
+			defer etlsV2Peers.Delete(peer)
+			c, err := dialWithFallback("tcp", l.Addr().String(), nil, newCipher(key), true)
+			So(c, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 //func TestDialToNode(t *testing.T) {
 //	Convey("DialToNode error case", t, func() {
 //		defer os.Remove(publicKeyStore)
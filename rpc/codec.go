@@ -17,15 +17,35 @@
 package rpc
 
 import (
+	"context"
+	"errors"
 	"net/rpc"
+	"sync"
+	"time"
 
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 )
 
+// rpcSystem is the rpc.system attribute of rpc spans.
+const rpcSystem = "dh-rpc"
+
 // NodeAwareServerCodec wraps normal rpc.ServerCodec and inject node id during request process
 type NodeAwareServerCodec struct {
 	rpc.ServerCodec
 	NodeID *proto.RawNodeID
+	// ACL is checked before dispatching requests if not nil
+	ACL route.ACL
+
+	denyErr error
+
+	method    string
+	seq       uint64
+	spans     map[uint64]*trace.Span
+	cancels   map[uint64]context.CancelFunc
+	spansLock sync.Mutex
 }
 
 // NewNodeAwareServerCodec returns new NodeAwareServerCodec with normal rpc.ServerCode and proto.RawNodeID
@@ -36,6 +56,36 @@ func NewNodeAwareServerCodec(codec rpc.ServerCodec, nodeID *proto.RawNodeID) *No
 	}
 }
 
+// ReadRequestHeader override default rpc.ServerCodec behaviour and check the ACL of the
+// requested method.
+func (nc *NodeAwareServerCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	nc.denyErr = nil
+	if err = nc.ServerCodec.ReadRequestHeader(r); err != nil {
+		return
+	}
+	nc.method, nc.seq = r.ServiceMethod, r.Seq
+
+	// non-ETLS connection carries no node identity to check
+	if nc.ACL == nil || nc.NodeID == nil {
+		return
+	}
+
+	// the role is resolved for each request, since a persistent stream may be opened before the
+	// role of the caller is recorded
+	role := route.GetCallerRole(nc.NodeID)
+	if !nc.ACL.Permits(role, r.ServiceMethod) {
+		log.WithFields(log.Fields{
+			"node":   nc.NodeID.String(),
+			"role":   role.String(),
+			"method": r.ServiceMethod,
+			"seq":    r.Seq,
+		}).Warning("rpc call denied by acl")
+		nc.denyErr = route.ErrPermissionDenied
+	}
+
+	return
+}
+
 // ReadRequestBody override default rpc.ServerCodec behaviour and inject remote node id into request
 func (nc *NodeAwareServerCodec) ReadRequestBody(body interface{}) (err error) {
 	err = nc.ServerCodec.ReadRequestBody(body)
@@ -43,6 +93,15 @@ func (nc *NodeAwareServerCodec) ReadRequestBody(body interface{}) (err error) {
 		return
 	}
 
+	// the server span is ended by the response of the same seq
+	nc.startSpan(body)
+
+	// the body is always consumed to keep the stream in sync, the denial is then replied
+	// by rpc.Server as a request error before dispatching
+	if nc.denyErr != nil {
+		return nc.denyErr
+	}
+
 	// test if request contains rpc envelope
 	if body == nil {
 		return
@@ -51,7 +110,71 @@ func (nc *NodeAwareServerCodec) ReadRequestBody(body interface{}) (err error) {
 	if r, ok := body.(proto.EnvelopeAPI); ok {
 		// inject node id to rpc envelope
 		r.SetNodeID(nc.NodeID)
+		// convert caller deadline to local deadline, clock of the caller is never trusted
+		if ttl := r.GetTTL(); ttl > 0 {
+			r.SetExpire(time.Now().Add(ttl).UnixNano())
+		} else {
+			r.SetExpire(0)
+		}
+		// the handler context is canceled by the response of the same seq
+		ctx, cancel := requestContext(r)
+		r.SetContext(ctx)
+		nc.spansLock.Lock()
+		if nc.cancels == nil {
+			nc.cancels = make(map[uint64]context.CancelFunc)
+		}
+		nc.cancels[nc.seq] = cancel
+		nc.spansLock.Unlock()
 	}
 
 	return
 }
+
+// WriteResponse override default rpc.ServerCodec behaviour and end the server span of the request.
+func (nc *NodeAwareServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	nc.spansLock.Lock()
+	span, ok := nc.spans[r.Seq]
+	delete(nc.spans, r.Seq)
+	cancel, hasCancel := nc.cancels[r.Seq]
+	delete(nc.cancels, r.Seq)
+	nc.spansLock.Unlock()
+	if hasCancel {
+		cancel()
+	}
+	if ok {
+		if r.Error != "" {
+			span.SetError(errors.New(r.Error))
+		}
+		span.End()
+	}
+	return nc.ServerCodec.WriteResponse(r, body)
+}
+
+// startSpan starts the server span of the request as child of the caller span in the envelope,
+// and replaces the envelope trace parent with it for the handler.
+func (nc *NodeAwareServerCodec) startSpan(body interface{}) {
+	ctx := context.Background()
+	env, isEnv := body.(proto.EnvelopeAPI)
+	if isEnv {
+		ctx = trace.ContextWithTraceParent(ctx, env.GetTraceParent())
+	}
+	_, span := trace.StartSpan(ctx, nc.method, trace.SpanKindServer)
+	if span == nil {
+		return
+	}
+	span.SetAttribute("rpc.system", rpcSystem)
+	span.SetAttribute("rpc.method", nc.method)
+	if nc.NodeID != nil {
+		span.SetAttribute("peer.node", nc.NodeID.String())
+	}
+	if isEnv {
+		env.SetTraceParent(span.TraceParent())
+	}
+
+	nc.spansLock.Lock()
+	defer nc.spansLock.Unlock()
+	if nc.spans == nil {
+		nc.spans = make(map[uint64]*trace.Span)
+	}
+	nc.spans[nc.seq] = span
+}
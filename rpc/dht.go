@@ -0,0 +1,309 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+var (
+	// DHTLookupTimeout is the timeout of looking up a node in the DHT.
+	DHTLookupTimeout = 10 * time.Second
+
+	// ErrNoDHTPeers defines failure on joining the DHT without any responding peers.
+	ErrNoDHTPeers = errors.New("no dht peers available")
+)
+
+// findNodeFunc sends the FindNode request to the peer.
+type findNodeFunc func(ctx context.Context, peer *proto.Node, req *proto.FindNodeReq, resp *proto.FindNodeResp) error
+
+// nodeLookup is an iterative S/Kademlia lookup of the target on disjoint paths.
+type nodeLookup struct {
+	self        proto.NodeID
+	target      proto.RawNodeID
+	table       *route.RoutingTable
+	findNode    findNodeFunc
+	stopOnFound bool
+
+	lock sync.Mutex
+	// visited is shared by all paths, so no node is queried by two paths
+	visited   map[proto.NodeID]bool
+	responded []proto.Node
+	found     *proto.Node
+}
+
+// LookupNode finds the node by iterative lookups in the DHT, starting from the closest nodes in
+// the local routing table, or from the block producers if the table is empty. The found node is
+// reported by other nodes, so it is never saved to route and kms, only nodes announcing
+// themselves by DHT.Ping are.
+func LookupNode(ctx context.Context, id *proto.RawNodeID) (node *proto.Node, err error) {
+	if id == nil {
+		return nil, route.ErrNilNodeID
+	}
+	l := newNodeLookup(id, true)
+	if node, _ = l.run(ctx, l.seeds()); node == nil {
+		err = route.ErrUnknownNodeID
+	}
+	return
+}
+
+// lookupTable returns the node in the local routing table, which are the nodes responded to the
+// local node or announced themselves to it.
+func lookupTable(id *proto.RawNodeID) *proto.Node {
+	rt, err := route.LocalRoutingTable()
+	if err != nil {
+		return nil
+	}
+	return rt.Get(id)
+}
+
+// JoinDHT looks up the local node to fill the routing table, and announces the local node to the
+// closest nodes found by DHT.Ping.
+func JoinDHT(ctx context.Context) (err error) {
+	localNodeID, err := kms.GetLocalNodeID()
+	if err != nil {
+		return
+	}
+	localNode, err := kms.GetNodeInfo(localNodeID)
+	if err != nil {
+		return
+	}
+
+	l := newNodeLookup(localNodeID.ToRawNodeID(), false)
+	_, closest := l.run(ctx, l.seeds())
+	if len(closest) == 0 {
+		return ErrNoDHTPeers
+	}
+
+	announced := 0
+	for i := range closest {
+		n := &closest[i]
+		req := &proto.PingReq{Node: *localNode}
+		if errPing := callContact(
+			ctx, n, route.DHTPing.String(), req, new(proto.PingResp)); errPing != nil {
+			log.WithField("peer", n.ID).WithError(errPing).Debug("announce to dht peer failed")
+			continue
+		}
+		announced++
+	}
+	log.WithFields(log.Fields{
+		"peers":     len(closest),
+		"announced": announced,
+	}).Info("joined dht")
+	return
+}
+
+func newNodeLookup(target *proto.RawNodeID, stopOnFound bool) (l *nodeLookup) {
+	l = &nodeLookup{
+		target:      *target,
+		findNode:    findNodeByRPC,
+		stopOnFound: stopOnFound,
+		visited:     make(map[proto.NodeID]bool),
+	}
+	if rt, err := route.LocalRoutingTable(); err == nil {
+		l.table = rt
+		l.self = rt.Self()
+	}
+	return
+}
+
+// seeds returns the closest nodes in the routing table, block producers are only used to
+// bootstrap an empty routing table. The local node is a seed too if it is a block producer, as
+// its DHT service knows all the registered nodes.
+func (l *nodeLookup) seeds() (seeds []proto.Node) {
+	if l.table != nil {
+		if seeds = l.table.Closest(&l.target, route.BucketSize); len(seeds) > 0 {
+			return
+		}
+	}
+	for _, bp := range route.GetBPs() {
+		if !l.visited[bp] {
+			seeds = append(seeds, proto.Node{ID: bp})
+		}
+	}
+	return
+}
+
+// run splits the seeds into disjoint paths and runs them concurrently, it returns the found node
+// and the nodes responded closest to the target.
+func (l *nodeLookup) run(ctx context.Context, seeds []proto.Node) (found *proto.Node, closest []proto.Node) {
+	ctx, cancel := context.WithTimeout(ctx, DHTLookupTimeout)
+	defer cancel()
+
+	route.SortByDistance(&l.target, seeds)
+	paths := route.LookupDisjointPaths
+	if len(seeds) < paths {
+		paths = len(seeds)
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < paths; i++ {
+		var shortlist []proto.Node
+		for j := i; j < len(seeds); j += paths {
+			shortlist = append(shortlist, seeds[j])
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.runPath(ctx, shortlist)
+		}()
+	}
+	wg.Wait()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	closest = append(closest, l.responded...)
+	route.SortByDistance(&l.target, closest)
+	if len(closest) > route.BucketSize {
+		closest = closest[:route.BucketSize]
+	}
+	return l.found, closest
+}
+
+// runPath queries the closest unvisited nodes of the shortlist until the BucketSize closest
+// nodes are all visited or the target is found.
+func (l *nodeLookup) runPath(ctx context.Context, shortlist []proto.Node) {
+	for ctx.Err() == nil && !l.isDone() {
+		batch := l.next(shortlist)
+		if len(batch) == 0 {
+			return
+		}
+		results := make([][]proto.Node, len(batch))
+		wg := &sync.WaitGroup{}
+		for i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = l.query(ctx, &batch[i])
+			}(i)
+		}
+		wg.Wait()
+
+		for _, nodes := range results {
+			for _, n := range nodes {
+				if indexOfNode(shortlist, n.ID) < 0 {
+					shortlist = append(shortlist, n)
+				}
+			}
+		}
+		route.SortByDistance(&l.target, shortlist)
+		if len(shortlist) > route.BucketSize {
+			shortlist = shortlist[:route.BucketSize]
+		}
+	}
+}
+
+// next marks and returns at most LookupAlpha closest nodes of the shortlist not visited by any path.
+func (l *nodeLookup) next(shortlist []proto.Node) (batch []proto.Node) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, n := range shortlist {
+		if len(batch) >= route.LookupAlpha {
+			break
+		}
+		if !l.visited[n.ID] {
+			l.visited[n.ID] = true
+			batch = append(batch, n)
+		}
+	}
+	return
+}
+
+func (l *nodeLookup) isDone() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.stopOnFound && l.found != nil
+}
+
+// query sends FindNode to the peer and returns the verified nodes in response.
+func (l *nodeLookup) query(ctx context.Context, peer *proto.Node) (nodes []proto.Node) {
+	targetID := l.target.ToNodeID()
+	req := &proto.FindNodeReq{
+		NodeID: targetID,
+		Count:  route.BucketSize,
+	}
+	resp := new(proto.FindNodeResp)
+	if err := l.findNode(ctx, peer, req, resp); err != nil {
+		log.WithField("peer", peer.ID).WithError(err).Debug("dht find node failed")
+		if l.table != nil {
+			l.table.Remove(peer.ID.ToRawNodeID())
+		}
+		return
+	}
+
+	if l.table != nil && peer.PublicKey != nil {
+		// the peer responded, refresh it in the routing table
+		l.table.Add(peer)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.responded = append(l.responded, *peer)
+	if resp.Node != nil && resp.Node.ID == targetID && route.VerifyNode(resp.Node) == nil {
+		l.found = resp.Node
+	}
+	for _, n := range resp.Closest {
+		// nodes failing the crypto puzzle are ignored
+		if err := route.VerifyNode(&n); err != nil {
+			log.WithField("peer", peer.ID).WithError(err).Debug("dht peer responded invalid node")
+			continue
+		}
+		if n.ID != l.self && !l.visited[n.ID] {
+			nodes = append(nodes, n)
+		}
+	}
+	return
+}
+
+func findNodeByRPC(ctx context.Context, peer *proto.Node, req *proto.FindNodeReq, resp *proto.FindNodeResp) error {
+	return callContact(ctx, peer, route.DHTFindNode.String(), req, resp)
+}
+
+// callContact calls the node by the address and public key in the contact, block producers
+// seeded without them are known by config.
+func callContact(ctx context.Context, node *proto.Node, method string, args, reply interface{}) (err error) {
+	if node.PublicKey == nil {
+		return NewCaller().CallNodeWithContext(ctx, node.ID, method, args, reply)
+	}
+	conn, err := dialToContact(node)
+	if err != nil {
+		return
+	}
+	client, err := InitClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer client.Close()
+	return callWithContext(ctx, client, method, args, reply)
+}
+
+func indexOfNode(nodes []proto.Node, id proto.NodeID) int {
+	for i := range nodes {
+		if nodes[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
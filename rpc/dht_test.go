@@ -0,0 +1,228 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type simNode struct {
+	node  *proto.Node
+	table *route.RoutingTable
+	// down nodes fail to respond, bogus nodes respond forged nodes
+	down  bool
+	bogus bool
+}
+
+// simNetwork is a simulated DHT network serving FindNode from the routing table of each node.
+type simNetwork struct {
+	t       *testing.T
+	nodes   map[proto.NodeID]*simNode
+	forged  map[proto.NodeID]bool
+	lock    sync.Mutex
+	queries map[proto.NodeID]int
+}
+
+func genDHTNode(t *testing.T) *proto.Node {
+	_, pub, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mine.HashBlock(pub.Serialize(), mine.Uint256{})
+	return &proto.Node{
+		ID:        proto.NodeID(h.String()),
+		PublicKey: pub,
+	}
+}
+
+func newSimNetwork(t *testing.T, count, known int) (s *simNetwork, nodes []*simNode) {
+	s = &simNetwork{
+		t:       t,
+		nodes:   make(map[proto.NodeID]*simNode),
+		forged:  make(map[proto.NodeID]bool),
+		queries: make(map[proto.NodeID]int),
+	}
+	nodes = make([]*simNode, count)
+	for i := range nodes {
+		node := genDHTNode(t)
+		nodes[i] = &simNode{
+			node:  node,
+			table: route.NewRoutingTable(node.ID.ToRawNodeID()),
+		}
+		s.nodes[node.ID] = nodes[i]
+	}
+	all := make([]proto.Node, count)
+	for i, n := range nodes {
+		all[i] = *n.node
+	}
+	for _, n := range nodes {
+		// nodes know a few random nodes and their closest neighbors, as found by joining the DHT
+		for _, i := range rand.Perm(count)[:known] {
+			n.table.Add(nodes[i].node)
+		}
+		route.SortByDistance(n.node.ID.ToRawNodeID(), all)
+		for i := range all[:known+1] {
+			n.table.Add(&all[i])
+		}
+	}
+	return
+}
+
+func (s *simNetwork) findNode(
+	ctx context.Context, peer *proto.Node, req *proto.FindNodeReq, resp *proto.FindNodeResp) error {
+	s.lock.Lock()
+	s.queries[peer.ID]++
+	s.lock.Unlock()
+
+	n, ok := s.nodes[peer.ID]
+	if !ok || n.down {
+		return errors.New("peer is down")
+	}
+	if n.bogus {
+		for i := 0; i < req.Count; i++ {
+			forged := *genDHTNode(s.t)
+			forged.PublicKey = n.node.PublicKey
+			s.lock.Lock()
+			s.forged[forged.ID] = true
+			s.lock.Unlock()
+			resp.Closest = append(resp.Closest, forged)
+		}
+		return nil
+	}
+	target := req.NodeID.ToRawNodeID()
+	if req.NodeID == n.node.ID {
+		resp.Node = n.node
+	} else {
+		resp.Node = n.table.Get(target)
+	}
+	resp.Closest = n.table.Closest(target, req.Count)
+	return nil
+}
+
+func (s *simNetwork) lookup(from *simNode, target proto.NodeID, stopOnFound bool) *nodeLookup {
+	s.lock.Lock()
+	s.queries = make(map[proto.NodeID]int)
+	s.lock.Unlock()
+	return &nodeLookup{
+		self:        from.node.ID,
+		target:      *target.ToRawNodeID(),
+		table:       from.table,
+		findNode:    s.findNode,
+		stopOnFound: stopOnFound,
+		visited:     make(map[proto.NodeID]bool),
+	}
+}
+
+func TestNodeLookup(t *testing.T) {
+	defer func(c *conf.Config) { conf.GConf = c }(conf.GConf)
+	conf.GConf = nil
+
+	Convey("lookup should find the node in the simulated network", t, func() {
+		s, nodes := newSimNetwork(t, 60, 8)
+		for i := 0; i < 10; i++ {
+			from, target := nodes[i], nodes[len(nodes)-1-i]
+			l := s.lookup(from, target.node.ID, true)
+			found, _ := l.run(context.Background(), l.seeds())
+			So(found, ShouldNotBeNil)
+			So(found.ID, ShouldEqual, target.node.ID)
+			So(found.PublicKey.IsEqual(target.node.PublicKey), ShouldBeTrue)
+			for _, c := range s.queries {
+				So(c, ShouldEqual, 1)
+			}
+			So(s.queries[from.node.ID], ShouldEqual, 0)
+		}
+	})
+	Convey("lookup should return the closest responding nodes", t, func() {
+		s, nodes := newSimNetwork(t, 60, 8)
+		from := nodes[0]
+		target := genDHTNode(t).ID
+		l := s.lookup(from, target, false)
+		found, closest := l.run(context.Background(), l.seeds())
+		So(found, ShouldBeNil)
+		So(closest, ShouldHaveLength, route.BucketSize)
+
+		// the closest nodes of the network except the local node
+		var all []proto.Node
+		for _, n := range nodes[1:] {
+			all = append(all, *n.node)
+		}
+		route.SortByDistance(target.ToRawNodeID(), all)
+		for i := range closest {
+			So(closest[i].ID, ShouldEqual, all[i].ID)
+		}
+	})
+	Convey("failing and bogus nodes should not break the lookup", t, func() {
+		s, nodes := newSimNetwork(t, 60, 10)
+		for i := 1; i < 40; i++ {
+			switch i % 4 {
+			case 1:
+				nodes[i].down = true
+			case 2:
+				nodes[i].bogus = true
+			}
+		}
+		from, target := nodes[0], nodes[59]
+		// the closest seeds are queried first
+		for i, n := range from.table.Closest(target.node.ID.ToRawNodeID(), 6) {
+			if n.ID != target.node.ID {
+				s.nodes[n.ID].down = i%2 == 0
+				s.nodes[n.ID].bogus = i%2 == 1
+			}
+		}
+		l := s.lookup(from, target.node.ID, true)
+		found, closest := l.run(context.Background(), l.seeds())
+		So(found, ShouldNotBeNil)
+		So(found.ID, ShouldEqual, target.node.ID)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		So(s.forged, ShouldNotBeEmpty)
+		for id, c := range s.queries {
+			So(c, ShouldEqual, 1)
+			// forged nodes are never queried
+			So(s.forged[id], ShouldBeFalse)
+			// down nodes are removed from the local table
+			if s.nodes[id].down {
+				So(from.table.Get(id.ToRawNodeID()), ShouldBeNil)
+			}
+		}
+		for _, n := range closest {
+			So(s.nodes[n.ID].down, ShouldBeFalse)
+			So(s.forged[n.ID], ShouldBeFalse)
+		}
+	})
+	Convey("lookup should stop on timeout", t, func() {
+		s, nodes := newSimNetwork(t, 10, 5)
+		l := s.lookup(nodes[0], genDHTNode(t).ID, true)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		found, closest := l.run(ctx, l.seeds())
+		So(found, ShouldBeNil)
+		So(closest, ShouldBeEmpty)
+	})
+}
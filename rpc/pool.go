@@ -49,6 +49,7 @@ type Session struct {
 // SessionPool is the struct type of session pool
 type SessionPool struct {
 	sessions   SessionMap
+	breakers   map[proto.NodeID]*circuitBreaker
 	nodeDialer NodeDialer
 	sync.RWMutex
 }
@@ -67,6 +68,7 @@ func (s *Session) Close() {
 func newSessionPool(nd NodeDialer) *SessionPool {
 	return &SessionPool{
 		sessions:   make(SessionMap),
+		breakers:   make(map[proto.NodeID]*circuitBreaker),
 		nodeDialer: nd,
 	}
 }
@@ -119,8 +121,36 @@ func (p *SessionPool) getSessionFromPool(id proto.NodeID) (sess *Session, ok boo
 	return
 }
 
+func (p *SessionPool) getBreaker(id proto.NodeID) (b *circuitBreaker) {
+	p.Lock()
+	defer p.Unlock()
+	b, ok := p.breakers[id]
+	if !ok {
+		b = &circuitBreaker{}
+		p.breakers[id] = b
+	}
+	return
+}
+
+// ReportResult feeds the result of a call to the node into its circuit breaker, the session to
+// the node is removed once the circuit is opened.
+func (p *SessionPool) ReportResult(id proto.NodeID, err error) {
+	if p.getBreaker(id).report(isNodeFailure(err)) {
+		log.WithFields(log.Fields{
+			"node":  id,
+			"error": err,
+		}).Warning("circuit of node opened")
+		p.Remove(id)
+	}
+}
+
 // Get returns existing session to the node, if not exist try best to create one
 func (p *SessionPool) Get(id proto.NodeID) (conn net.Conn, err error) {
+	// fail fast if the node keeps failing
+	if err = p.getBreaker(id).allow(); err != nil {
+		return
+	}
+
 	// first try to get one session from pool
 	cachedConn, ok := p.getSessionFromPool(id)
 	if ok {
@@ -138,6 +168,7 @@ func (p *SessionPool) Get(id proto.NodeID) (conn net.Conn, err error) {
 	newConn, err := p.nodeDialer(id)
 	if err != nil {
 		log.Errorf("dial new session to node %s failed: %v", id, err)
+		p.ReportResult(id, err)
 		return
 	}
 	newSess, err := toSession(id, newConn)
@@ -184,6 +215,7 @@ func (p *SessionPool) Close() {
 		s.Close()
 	}
 	p.sessions = make(SessionMap)
+	p.breakers = make(map[proto.NodeID]*circuitBreaker)
 }
 
 // Len returns the session counts in the pool
@@ -0,0 +1,883 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/hashicorp/yamux"
+)
+
+/*
+	A node behind NAT registers to a relay, which is a block producer or a volunteer node with a
+	public address, and announces RelayAddr of the relay as its address. All the relay streams are
+	opened by the node behind NAT or the caller, so they pass through NAT:
+
+		node(N)                       relay(R)                       caller(C)
+		Relay.Register ---------->    keeps the control stream of N
+		                              observed address of N  <------ Relay.Connect(N)
+		punch C          <----------  notice(token, address of C)
+		Relay.Accept(token) ----->    splices the streams    ------> observed address of N
+		                                                              direct dial to N
+
+	C and N run ETLS and yamux over the spliced streams, so R only forwards encrypted bytes. The
+	observed addresses are used for hole punching, both sides dial from the port observed by R
+	with SO_REUSEPORT: N dials C from its listening port, which it registers to R from, and C
+	connects to R by a dedicated connection and dials N from the same local port. The ETLS
+	handshakes over the relayed streams and the direct connection run at the same time, the first
+	one completed is used and the other is closed, so the relayed streams are kept until the
+	direct connection is proved to work.
+*/
+
+const (
+	// RelayAddrPrefix is the prefix of the address of a node reachable through a relay
+	RelayAddrPrefix = "relay://"
+
+	// relayChunkSize is the max size of bytes sent in one stream message
+	relayChunkSize = 32 * 1024
+)
+
+var (
+	// MaxRelayedNodes is the max count of nodes registered to a relay.
+	MaxRelayedNodes = 1024
+	// RelayAcceptTimeout is the timeout of the relayed node accepting a connection.
+	RelayAcceptTimeout = 10 * time.Second
+	// RelayPunchTimeout is the timeout of setting up direct connection by hole punching.
+	RelayPunchTimeout = 2 * time.Second
+	// MaxRelayPendingPerCaller is the max count of connections of a caller waiting for the
+	// relayed nodes to accept.
+	MaxRelayPendingPerCaller = 16
+	// RelayRetryInterval is the interval of registering again after the relay is lost.
+	RelayRetryInterval = 5 * time.Second
+
+	// ErrRelayTargetNotFound defines failure on connecting to a node not registered to the relay.
+	ErrRelayTargetNotFound = errors.New("relay target not registered")
+	// ErrRelayFull defines failure on registering to a relay with MaxRelayedNodes nodes.
+	ErrRelayFull = errors.New("relay is full")
+	// ErrRelayTooManyPending defines failure on connecting through a relay with
+	// MaxRelayPendingPerCaller connections of the caller pending.
+	ErrRelayTooManyPending = errors.New("too many pending relay connections")
+	// ErrRelayAnonymous defines failure on registering to a relay with anonymous ETLS.
+	ErrRelayAnonymous = errors.New("anonymous node can not be relayed")
+	// ErrRelayInvalidToken defines failure on accepting a connection with unknown token.
+	ErrRelayInvalidToken = errors.New("invalid relay token")
+	// ErrRelayListenerClosed defines failure on accepting from a closed RelayListener.
+	ErrRelayListenerClosed = errors.New("relay listener closed")
+	// ErrNoRelayAvailable defines failure on registering to relays when none accepts.
+	ErrNoRelayAvailable = errors.New("no relay available")
+)
+
+type relayRegistered struct {
+	// ObservedAddr is the public address of the node observed by relay
+	ObservedAddr string
+}
+
+type relayConnectReq struct {
+	Target proto.NodeID
+}
+
+type relayConnectResp struct {
+	// TargetAddr is the public address of the target observed by relay
+	TargetAddr string
+}
+
+type relayNotice struct {
+	Token      string
+	Caller     proto.NodeID
+	CallerAddr string
+}
+
+type relayAcceptReq struct {
+	Token string
+}
+
+// RelayAddr returns the address of a node reachable through the relay.
+func RelayAddr(relay proto.NodeID) string {
+	return RelayAddrPrefix + string(relay)
+}
+
+// ParseRelayAddr returns the relay of the address if it's a RelayAddr.
+func ParseRelayAddr(addr string) (relay proto.NodeID, ok bool) {
+	if !strings.HasPrefix(addr, RelayAddrPrefix) {
+		return
+	}
+	relay = proto.NodeID(strings.TrimPrefix(addr, RelayAddrPrefix))
+	return relay, relay.Difficulty() >= 0
+}
+
+// relayNetAddr is the net.Addr of a RelayListener.
+type relayNetAddr string
+
+func (a relayNetAddr) Network() string {
+	return "relay"
+}
+
+func (a relayNetAddr) String() string {
+	return string(a)
+}
+
+// streamConn is the net.Conn forwarding bytes by stream messages. Deadlines are not supported,
+// the stream is canceled by closing the connection instead.
+type streamConn struct {
+	s   *Stream
+	buf []byte
+	// sess is closed with the stream if the session is dedicated to the stream
+	sess io.Closer
+}
+
+func newStreamConn(s *Stream) *streamConn {
+	return &streamConn{s: s}
+}
+
+func (c *streamConn) Read(b []byte) (n int, err error) {
+	for len(c.buf) == 0 {
+		if err = c.s.Recv(&c.buf); err != nil {
+			return
+		}
+	}
+	n = copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return
+}
+
+func (c *streamConn) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > relayChunkSize {
+			chunk = chunk[:relayChunkSize]
+		}
+		if err = c.s.Send(chunk); err != nil {
+			return
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return
+}
+
+func (c *streamConn) Close() (err error) {
+	c.s.CloseSend()
+	err = c.s.Close()
+	if c.sess != nil {
+		c.sess.Close()
+	}
+	return
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.s.conn.LocalAddr()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.s.conn.RemoteAddr()
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type relayedNode struct {
+	ctrl *Stream
+	addr string
+	// sendLock serializes notices sent on the control stream
+	sendLock sync.Mutex
+}
+
+type relayPending struct {
+	target   proto.NodeID
+	accepted chan *Stream
+	done     chan struct{}
+}
+
+// RelayService forwards connections to the nodes behind NAT registered to it, it's served by
+// block producers and volunteer nodes.
+type RelayService struct {
+	lock    sync.Mutex
+	nodes   map[proto.NodeID]*relayedNode
+	pending map[string]*relayPending
+	// callers counts the pending connections of callers
+	callers map[string]int
+}
+
+// NewRelayService returns a new RelayService.
+func NewRelayService() *RelayService {
+	return &RelayService{
+		nodes:   make(map[proto.NodeID]*relayedNode),
+		pending: make(map[string]*relayPending),
+		callers: make(map[string]int),
+	}
+}
+
+// RegisterTo registers the relay stream handlers to the server.
+func (r *RelayService) RegisterTo(s *Server) (err error) {
+	if err = s.RegisterStreamHandler(route.RelayRegister.String(), r.register); err != nil {
+		return
+	}
+	if err = s.RegisterStreamHandler(route.RelayConnect.String(), r.connect); err != nil {
+		return
+	}
+	return s.RegisterStreamHandler(route.RelayAccept.String(), r.accept)
+}
+
+// Len returns the count of registered nodes.
+func (r *RelayService) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.nodes)
+}
+
+func (r *RelayService) register(s *Stream) (err error) {
+	remote := s.RemoteNodeID()
+	if remote == nil || remote.IsEqual(&kms.AnonymousRawNodeID.Hash) {
+		return ErrRelayAnonymous
+	}
+	id := remote.ToNodeID()
+	node := &relayedNode{
+		ctrl: s,
+		addr: s.conn.RemoteAddr().String(),
+	}
+
+	r.lock.Lock()
+	old, exists := r.nodes[id]
+	if !exists && len(r.nodes) >= MaxRelayedNodes {
+		r.lock.Unlock()
+		return ErrRelayFull
+	}
+	r.nodes[id] = node
+	r.lock.Unlock()
+	if exists {
+		// the node registered again, the old control stream is stale
+		old.ctrl.Close()
+	}
+	defer func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.nodes[id] == node {
+			delete(r.nodes, id)
+		}
+	}()
+
+	log.WithFields(log.Fields{
+		"node": id,
+		"addr": node.addr,
+	}).Info("node registered to relay")
+	if err = node.send(&relayRegistered{ObservedAddr: node.addr}); err != nil {
+		return
+	}
+	// the node is relayed until it closes the control stream
+	for {
+		var msg interface{}
+		if err = s.Recv(&msg); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+	}
+}
+
+func (r *RelayService) connect(s *Stream) (err error) {
+	var req relayConnectReq
+	if err = s.Recv(&req); err != nil {
+		return
+	}
+	r.lock.Lock()
+	node := r.nodes[req.Target]
+	r.lock.Unlock()
+	if node == nil {
+		return ErrRelayTargetNotFound
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err = rand.Read(tokenBytes); err != nil {
+		return
+	}
+	token := hex.EncodeToString(tokenBytes)
+	p := &relayPending{
+		target:   req.Target,
+		accepted: make(chan *Stream, 1),
+		done:     make(chan struct{}),
+	}
+	caller := relayCallerKey(s)
+	r.lock.Lock()
+	if r.callers[caller] >= MaxRelayPendingPerCaller {
+		r.lock.Unlock()
+		return ErrRelayTooManyPending
+	}
+	r.callers[caller]++
+	r.pending[token] = p
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.pending, token)
+		if r.callers[caller]--; r.callers[caller] <= 0 {
+			delete(r.callers, caller)
+		}
+		r.lock.Unlock()
+		close(p.done)
+	}()
+
+	notice := &relayNotice{
+		Token:      token,
+		CallerAddr: s.conn.RemoteAddr().String(),
+	}
+	if caller := s.RemoteNodeID(); caller != nil {
+		notice.Caller = caller.ToNodeID()
+	}
+	if err = node.send(notice); err != nil {
+		return ErrRelayTargetNotFound
+	}
+	if err = s.Send(&relayConnectResp{TargetAddr: node.addr}); err != nil {
+		return
+	}
+
+	timer := time.NewTimer(RelayAcceptTimeout)
+	defer timer.Stop()
+	select {
+	case accepted := <-p.accepted:
+		spliceStreams(s, accepted)
+		return
+	case <-timer.C:
+		return ErrRelayTargetNotFound
+	case <-s.Context().Done():
+		return s.Context().Err()
+	}
+}
+
+func (r *RelayService) accept(s *Stream) (err error) {
+	var req relayAcceptReq
+	if err = s.Recv(&req); err != nil {
+		return
+	}
+	r.lock.Lock()
+	p := r.pending[req.Token]
+	delete(r.pending, req.Token)
+	r.lock.Unlock()
+	remote := s.RemoteNodeID()
+	if p == nil || remote == nil || remote.ToNodeID() != p.target {
+		return ErrRelayInvalidToken
+	}
+
+	p.accepted <- s
+	// the stream is spliced by the connecting handler until both sides finish
+	select {
+	case <-p.done:
+	case <-s.Context().Done():
+	}
+	return
+}
+
+// relayCallerKey returns the key of the caller to limit its pending connections, anonymous
+// callers are identified by their hosts.
+func relayCallerKey(s *Stream) string {
+	if caller := s.RemoteNodeID(); caller != nil && !caller.IsEqual(&kms.AnonymousRawNodeID.Hash) {
+		return string(caller.ToNodeID())
+	}
+	addr := s.conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func (n *relayedNode) send(msg interface{}) error {
+	n.sendLock.Lock()
+	defer n.sendLock.Unlock()
+	return n.ctrl.Send(msg)
+}
+
+// spliceStreams forwards the messages between the streams until both sides finish sending.
+func spliceStreams(a, b *Stream) {
+	wg := &sync.WaitGroup{}
+	forward := func(dst, src *Stream) {
+		defer wg.Done()
+		for {
+			payload, err := src.recvPayload()
+			if err == io.EOF {
+				dst.CloseSend()
+				return
+			}
+			if err == nil {
+				err = dst.sendPayload(payload)
+			}
+			if err != nil {
+				// one side is broken, cancel both
+				src.Close()
+				dst.Close()
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go forward(a, b)
+	go forward(b, a)
+	wg.Wait()
+}
+
+// dialRelayed connects to the node behind NAT through the relay, and tries to connect directly
+// to the address of the node observed by the relay at the same time. The connection completing
+// the handshake first is returned.
+func dialRelayed(relay proto.NodeID, remoteNodeID *proto.RawNodeID, cipher *etls.Cipher, isAnonymous bool) (
+	c *etls.CryptoConn, err error) {
+	relayed, local, targetAddr, err := openRelayConn(relay, remoteNodeID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"relay":  relay,
+			"target": remoteNodeID.ToNodeID(),
+		}).WithError(err).Error("connect through relay failed")
+		return
+	}
+
+	type dialResult struct {
+		c   *etls.CryptoConn
+		err error
+	}
+	relayCh := make(chan dialResult, 1)
+	directCh := make(chan dialResult, 1)
+	go func() {
+		c, err := handshakeClientConn(relayed, remoteNodeID, cipher.Clone(), isAnonymous)
+		relayCh <- dialResult{c, err}
+	}()
+	go func() {
+		if targetAddr == "" {
+			directCh <- dialResult{err: ErrRelayTargetNotFound}
+			return
+		}
+		direct, err := dialFromAddr(local, targetAddr, RelayPunchTimeout)
+		if err != nil {
+			directCh <- dialResult{err: err}
+			return
+		}
+		// the address may be taken by another host, which never answers the handshake
+		direct.SetDeadline(time.Now().Add(RelayPunchTimeout))
+		c, err := handshakeClientConn(direct, remoteNodeID, cipher.Clone(), isAnonymous)
+		if err == nil {
+			direct.SetDeadline(time.Time{})
+		}
+		directCh <- dialResult{c, err}
+	}()
+
+	// closeLater closes the connection completed after the other one is used
+	closeLater := func(ch chan dialResult) {
+		go func() {
+			if r := <-ch; r.c != nil {
+				r.c.Close()
+			}
+		}()
+	}
+	var (
+		relayErr, directErr error
+		peer                = etlsPeerKey("", remoteNodeID)
+	)
+	for relayCh != nil || directCh != nil {
+		select {
+		case r := <-directCh:
+			directCh = nil
+			if directErr = r.err; directErr != nil {
+				log.WithFields(log.Fields{
+					"target": remoteNodeID.ToNodeID(),
+					"addr":   targetAddr,
+				}).WithError(directErr).Debug("connect to relayed node directly failed")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"target": remoteNodeID.ToNodeID(),
+				"addr":   targetAddr,
+			}).Debug("connected to relayed node directly")
+			if relayCh != nil {
+				relayed.Close()
+				closeLater(relayCh)
+			}
+			etlsV2Peers.Store(peer, true)
+			return r.c, nil
+		case r := <-relayCh:
+			relayCh = nil
+			if relayErr = r.err; relayErr != nil {
+				continue
+			}
+			if directCh != nil {
+				closeLater(directCh)
+			}
+			etlsV2Peers.Store(peer, true)
+			return r.c, nil
+		}
+	}
+	relayed.Close()
+	if fallbackToLegacy(peer, relayErr) {
+		if c, err = dialRelayedLegacy(relay, remoteNodeID, cipher, isAnonymous); err == nil {
+			return
+		}
+		relayErr = err
+	}
+	log.WithFields(log.Fields{
+		"relay":  relay,
+		"target": remoteNodeID.ToNodeID(),
+	}).WithError(relayErr).Error("connect through relay failed")
+	return nil, relayErr
+}
+
+// dialRelayedLegacy dials the old node behind the relay with the legacy ETLS, which has no
+// handshake to run.
+func dialRelayedLegacy(relay proto.NodeID, remoteNodeID *proto.RawNodeID, cipher *etls.Cipher, isAnonymous bool) (
+	c *etls.CryptoConn, err error) {
+	relayed, _, _, err := openRelayConn(relay, remoteNodeID)
+	if err != nil {
+		return
+	}
+	if c, err = newClientConn(relayed, remoteNodeID, cipher.Legacy(), isAnonymous); err != nil {
+		relayed.Close()
+	}
+	return
+}
+
+// openRelayConn asks the relay to forward a connection to the target by a dedicated connection
+// dialed with SO_REUSEPORT, it returns the relayed connection, the local address of the
+// dedicated connection and the address of the target observed by the relay.
+func openRelayConn(relay proto.NodeID, remoteNodeID *proto.RawNodeID) (
+	conn *streamConn, local string, targetAddr string, err error) {
+	rawID := relay.ToRawNodeID()
+	if rawID == nil {
+		err = route.ErrNilNodeID
+		return
+	}
+	key, err := GetSharedSecretWith(rawID, false)
+	if err != nil {
+		return
+	}
+	addr, err := GetNodeAddr(rawID)
+	if err != nil {
+		return
+	}
+	// bind the port explicitly with SO_REUSEPORT, so it can be bound again for the direct dial
+	raw, err := dialFromAddr(":0", addr, 0)
+	if err != nil {
+		return
+	}
+	local = raw.LocalAddr().String()
+	relayConn, err := newClientConn(raw, rawID, newCipher(key), false)
+	if err != nil {
+		return
+	}
+	sess, err := yamux.Client(relayConn, YamuxConfig)
+	if err != nil {
+		relayConn.Close()
+		return
+	}
+	stream, err := sess.Open()
+	if err != nil {
+		sess.Close()
+		return
+	}
+	s, err := NewClientStream(context.Background(), stream, route.RelayConnect.String())
+	if err != nil {
+		sess.Close()
+		return
+	}
+	var resp relayConnectResp
+	if err = s.Send(&relayConnectReq{Target: remoteNodeID.ToNodeID()}); err == nil {
+		err = s.Recv(&resp)
+	}
+	if err != nil {
+		s.Close()
+		sess.Close()
+		return
+	}
+	conn = newStreamConn(s)
+	conn.sess = sess
+	return conn, local, resp.TargetAddr, nil
+}
+
+// handshakeClientConn wraps the connection by ETLS and completes the handshake.
+func handshakeClientConn(conn net.Conn, remoteNodeID *proto.RawNodeID, cipher *etls.Cipher, isAnonymous bool) (
+	c *etls.CryptoConn, err error) {
+	if c, err = newClientConn(conn, remoteNodeID, cipher, isAnonymous); err != nil {
+		return
+	}
+	if err = c.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return
+}
+
+// RelayListener accepts the connections forwarded by a relay for the node behind NAT. It keeps
+// registering to the first relay available, and the address of the node should be updated to
+// Addr() by OnRelayChange if the relay is changed.
+type RelayListener struct {
+	localAddr string
+	relays    []proto.NodeID
+	connCh    chan net.Conn
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+
+	// OnRelayChange is called with the new RelayAddr after registering to another relay.
+	OnRelayChange func(addr string)
+
+	lock     sync.Mutex
+	relay    proto.NodeID
+	session  *yamux.Session
+	observed string
+}
+
+// NewRelayListener returns a RelayListener of the node listening on localAddr, which is bound by
+// the connections to relays and peers to punch NAT.
+func NewRelayListener(localAddr string, relays []proto.NodeID) *RelayListener {
+	return &RelayListener{
+		localAddr: localAddr,
+		relays:    relays,
+		connCh:    make(chan net.Conn),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start registers to the first relay available, and keeps registering in background.
+func (l *RelayListener) Start() (addr string, err error) {
+	sess, ctrl, err := l.registerAny()
+	if err != nil {
+		return
+	}
+	go l.run(sess, ctrl)
+	return l.Addr().String(), nil
+}
+
+// Accept implements net.Listener.Accept.
+func (l *RelayListener) Accept() (conn net.Conn, err error) {
+	select {
+	case conn = <-l.connCh:
+		return
+	case <-l.stopCh:
+		return nil, ErrRelayListenerClosed
+	}
+}
+
+// Close implements net.Listener.Close.
+func (l *RelayListener) Close() (err error) {
+	l.stopOnce.Do(func() {
+		close(l.stopCh)
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if l.session != nil {
+			err = l.session.Close()
+		}
+	})
+	return
+}
+
+// Addr implements net.Listener.Addr, it returns the RelayAddr of the current relay.
+func (l *RelayListener) Addr() net.Addr {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return relayNetAddr(RelayAddr(l.relay))
+}
+
+// ObservedAddr returns the public address of the node observed by the current relay.
+func (l *RelayListener) ObservedAddr() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.observed
+}
+
+func (l *RelayListener) run(sess *yamux.Session, ctrl *Stream) {
+	for {
+		for {
+			var notice relayNotice
+			if err := ctrl.Recv(&notice); err != nil {
+				log.WithField("relay", l.relay).WithError(err).Warning("lost relay")
+				break
+			}
+			go l.accept(sess, &notice)
+		}
+		ctrl.Close()
+		sess.Close()
+
+		for {
+			select {
+			case <-l.stopCh:
+				return
+			case <-time.After(RelayRetryInterval):
+			}
+			oldAddr := l.Addr().String()
+			var err error
+			if sess, ctrl, err = l.registerAny(); err != nil {
+				log.WithError(err).Warning("register to relay failed")
+				continue
+			}
+			if addr := l.Addr().String(); addr != oldAddr && l.OnRelayChange != nil {
+				l.OnRelayChange(addr)
+			}
+			break
+		}
+	}
+}
+
+// registerAny registers to the relays in order, the last relay registered is tried first.
+func (l *RelayListener) registerAny() (sess *yamux.Session, ctrl *Stream, err error) {
+	l.lock.Lock()
+	relays := l.relays
+	if l.relay != "" {
+		relays = append([]proto.NodeID{l.relay}, relays...)
+	}
+	l.lock.Unlock()
+	for _, relay := range relays {
+		if sess, ctrl, err = l.register(relay); err == nil {
+			return
+		}
+		log.WithField("relay", relay).WithError(err).Debug("register to relay failed")
+	}
+	return nil, nil, ErrNoRelayAvailable
+}
+
+func (l *RelayListener) register(relay proto.NodeID) (sess *yamux.Session, ctrl *Stream, err error) {
+	rawID := relay.ToRawNodeID()
+	if rawID == nil {
+		return nil, nil, route.ErrNilNodeID
+	}
+	key, err := GetSharedSecretWith(rawID, false)
+	if err != nil {
+		return
+	}
+	addr, err := GetNodeAddr(rawID)
+	if err != nil {
+		return
+	}
+	raw, err := l.dialFromLocal(addr, 0)
+	if err != nil {
+		return
+	}
+	conn, err := newClientConn(raw, rawID, newCipher(key), false)
+	if err != nil {
+		return
+	}
+	// the relay opens no stream on the session, the node opens streams to accept connections
+	if sess, err = yamux.Client(conn, YamuxConfig); err != nil {
+		conn.Close()
+		return
+	}
+	stream, err := sess.Open()
+	if err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+	if ctrl, err = NewClientStream(context.Background(), stream, route.RelayRegister.String()); err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+	var resp relayRegistered
+	if err = ctrl.Recv(&resp); err != nil {
+		ctrl.Close()
+		sess.Close()
+		return nil, nil, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	select {
+	case <-l.stopCh:
+		sess.Close()
+		return nil, nil, ErrRelayListenerClosed
+	default:
+	}
+	l.relay, l.session, l.observed = relay, sess, resp.ObservedAddr
+	log.WithFields(log.Fields{
+		"relay":    relay,
+		"observed": resp.ObservedAddr,
+	}).Info("registered to relay")
+	return
+}
+
+// accept opens a stream to accept the connection forwarded by the relay, and punches NAT for
+// the caller at the same time.
+func (l *RelayListener) accept(sess *yamux.Session, notice *relayNotice) {
+	if notice.CallerAddr != "" {
+		go l.punch(notice.CallerAddr)
+	}
+
+	stream, err := sess.Open()
+	if err != nil {
+		return
+	}
+	s, err := NewClientStream(context.Background(), stream, route.RelayAccept.String())
+	if err != nil {
+		return
+	}
+	if err = s.Send(&relayAcceptReq{Token: notice.Token}); err != nil {
+		s.Close()
+		return
+	}
+	// the caller closes the relayed streams if it's connected directly
+	conn, err := handleCipher(newStreamConn(s))
+	if err != nil {
+		log.WithField("caller", notice.Caller).WithError(err).Debug("accept relayed connection failed")
+		return
+	}
+	select {
+	case l.connCh <- conn:
+	case <-l.stopCh:
+		conn.Close()
+	}
+}
+
+// punch dials the caller from the listening port, so the NAT of the node allows the incoming
+// connection of the caller. It's expected to fail.
+func (l *RelayListener) punch(addr string) {
+	conn, err := l.dialFromLocal(addr, RelayPunchTimeout)
+	if err != nil {
+		log.WithField("addr", addr).WithError(err).Debug("punch nat")
+		return
+	}
+	conn.Close()
+}
+
+// dialFromLocal dials from the listening port of the node, or from any port if it's not supported.
+func (l *RelayListener) dialFromLocal(addr string, timeout time.Duration) (conn net.Conn, err error) {
+	return dialFromAddr(l.localAddr, addr, timeout)
+}
+
+// dialFromAddr dials from the local address with SO_REUSEPORT, or from any port if it's not
+// supported.
+func dialFromAddr(local, addr string, timeout time.Duration) (conn net.Conn, err error) {
+	d := &net.Dialer{Timeout: timeout, Control: reusePortControl}
+	if d.LocalAddr, err = net.ResolveTCPAddr("tcp", local); err == nil {
+		if conn, err = d.Dial("tcp", addr); err == nil {
+			return
+		}
+	}
+	log.WithField("local", local).WithError(err).Debug("dial from local address failed")
+	d.LocalAddr = nil
+	return d.Dial("tcp", addr)
+}
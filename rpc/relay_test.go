@@ -0,0 +1,228 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/consistent"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/hashicorp/yamux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func watchOverConn(conn *etls.CryptoConn, count int) (err error) {
+	sess, err := yamux.Client(conn, YamuxConfig)
+	if err != nil {
+		return
+	}
+	defer sess.Close()
+	muxConn, err := sess.Open()
+	if err != nil {
+		return
+	}
+	s, err := NewClientStream(context.Background(), muxConn, "Test.Watch")
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	if err = s.Send(&WatchReq{Count: count, Size: 100 << 10}); err != nil {
+		return
+	}
+	for i := 0; i < count; i++ {
+		var ev WatchEvent
+		if err = s.Recv(&ev); err != nil {
+			return
+		}
+	}
+	if err = s.Recv(&WatchEvent{}); err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+func TestRelay(t *testing.T) {
+	defer os.Remove(PubKeyStorePath)
+	server, err := NewServerWithService(ServiceMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterStreamHandler("Test.Watch", watch)
+	relay := NewRelayService()
+	if err = relay.RegisterTo(server); err != nil {
+		t.Fatal(err)
+	}
+	acl := route.DefaultACL()
+	acl["Test.Watch"] = route.RegisteredCaller
+	server.SetACL(acl)
+
+	route.NewDHTService(PubKeyStorePath, new(consistent.KMSStorage), true)
+	server.InitRPCServer("127.0.0.1:0", "../keys/test.key", []byte("abc"))
+	go server.Serve()
+	defer server.Stop()
+
+	// the node relays itself, it's the only identity of the process
+	publicKey, err := kms.GetLocalPublicKey()
+	nonce := asymmetric.GetPubKeyNonce(publicKey, 10, 100*time.Millisecond, nil)
+	nodeID := proto.NodeID(nonce.Hash.String())
+	rawNodeID := &proto.RawNodeID{Hash: nonce.Hash}
+	kms.SetPublicKey(nodeID, nonce.Nonce, publicKey)
+	kms.SetLocalNodeIDNonce(nonce.Hash.CloneBytes(), &nonce.Nonce)
+	route.SetNodeAddrCache(rawNodeID, server.Listener.Addr().String())
+	key, err := GetSharedSecretWith(rawNodeID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the relay session is pooled by node id, drop it with the server
+	defer GetSessionPoolInstance().Remove(nodeID)
+
+	Convey("relay address should be parsed", t, func() {
+		addr := RelayAddr(nodeID)
+		relayID, ok := ParseRelayAddr(addr)
+		So(ok, ShouldBeTrue)
+		So(relayID, ShouldEqual, nodeID)
+		_, ok = ParseRelayAddr(server.Listener.Addr().String())
+		So(ok, ShouldBeFalse)
+		_, ok = ParseRelayAddr(RelayAddrPrefix + "bad")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("connecting to node not registered should fail", t, func() {
+		_, err := dialRelayed(nodeID, rawNodeID, etls.NewCipher(key), false)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("connection should be forwarded by relay if punching fails", t, func() {
+		// the node listens nowhere, so the observed address is not reachable
+		l := NewRelayListener("127.0.0.1:0", []proto.NodeID{nodeID})
+		addr, err := l.Start()
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, RelayAddr(nodeID))
+		So(l.ObservedAddr(), ShouldNotBeEmpty)
+		So(relay.Len(), ShouldEqual, 1)
+		go server.ServeListener(l)
+
+		conn, err := dialRelayed(nodeID, rawNodeID, etls.NewCipher(key), false)
+		So(err, ShouldBeNil)
+		So(conn.RemoteAddr().String(), ShouldEqual, server.Listener.Addr().String())
+		So(watchOverConn(conn, 20), ShouldBeNil)
+
+		So(l.Close(), ShouldBeNil)
+		_, err = l.Accept()
+		So(err, ShouldEqual, ErrRelayListenerClosed)
+		for i := 0; i < 50 && relay.Len() != 0; i++ {
+			time.Sleep(20 * time.Millisecond)
+		}
+		So(relay.Len(), ShouldEqual, 0)
+	})
+
+	Convey("connection should be forwarded by relay if direct handshake fails", t, func() {
+		// the observed address of the node is taken by a host never answering the handshake
+		lc := &net.ListenConfig{Control: reusePortControl}
+		silent, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer silent.Close()
+		go func() {
+			for {
+				c, err := silent.Accept()
+				if err != nil {
+					return
+				}
+				defer c.Close()
+			}
+		}()
+
+		l := NewRelayListener(silent.Addr().String(), []proto.NodeID{nodeID})
+		_, err = l.Start()
+		So(err, ShouldBeNil)
+		defer l.Close()
+		So(l.ObservedAddr(), ShouldEqual, silent.Addr().String())
+		go server.ServeListener(l)
+
+		start := time.Now()
+		conn, err := dialRelayed(nodeID, rawNodeID, etls.NewCipher(key), false)
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, RelayPunchTimeout)
+		So(conn.RemoteAddr().String(), ShouldEqual, server.Listener.Addr().String())
+		So(watchOverConn(conn, 3), ShouldBeNil)
+	})
+
+	Convey("pending connections of a caller should be limited", t, func() {
+		l := NewRelayListener("127.0.0.1:0", []proto.NodeID{nodeID})
+		_, err := l.Start()
+		So(err, ShouldBeNil)
+		defer l.Close()
+		go server.ServeListener(l)
+
+		relay.lock.Lock()
+		relay.callers[string(nodeID)] = MaxRelayPendingPerCaller
+		relay.lock.Unlock()
+		_, err = dialRelayed(nodeID, rawNodeID, etls.NewCipher(key), false)
+		So(err, ShouldNotBeNil)
+
+		relay.lock.Lock()
+		delete(relay.callers, string(nodeID))
+		relay.lock.Unlock()
+		conn, err := dialRelayed(nodeID, rawNodeID, etls.NewCipher(key), false)
+		So(err, ShouldBeNil)
+		So(watchOverConn(conn, 1), ShouldBeNil)
+		pending := func() int {
+			relay.lock.Lock()
+			defer relay.lock.Unlock()
+			return len(relay.callers)
+		}
+		// the relayed streams are finished asynchronously
+		for i := 0; i < 50 && pending() != 0; i++ {
+			time.Sleep(20 * time.Millisecond)
+		}
+		So(pending(), ShouldEqual, 0)
+	})
+
+	Convey("connection should be direct if punching succeeds", t, func() {
+		// the node registers from its listening port, which is reachable
+		node, err := NewServerWithService(ServiceMap{})
+		So(err, ShouldBeNil)
+		node.RegisterStreamHandler("Test.Watch", watch)
+		node.SetACL(acl)
+		lc := &net.ListenConfig{Control: reusePortControl}
+		nl, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		node.SetListener(&etls.CryptoListener{Listener: nl, CHandler: handleCipher})
+		go node.Serve()
+		defer node.Stop()
+
+		l := NewRelayListener(nl.Addr().String(), []proto.NodeID{nodeID})
+		_, err = l.Start()
+		So(err, ShouldBeNil)
+		defer l.Close()
+		So(l.ObservedAddr(), ShouldEqual, nl.Addr().String())
+
+		conn, err := dialRelayed(nodeID, rawNodeID, etls.NewCipher(key), false)
+		So(err, ShouldBeNil)
+		So(conn.RemoteAddr().String(), ShouldEqual, nl.Addr().String())
+		So(watchOverConn(conn, 3), ShouldBeNil)
+	})
+}
@@ -19,10 +19,8 @@ package rpc
 import (
 	"context"
 	"errors"
-	"io"
 	"math/rand"
 	"net"
-	"net/rpc"
 	"sync"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
@@ -35,6 +33,12 @@ import (
 var (
 	// ErrNoChiefBlockProducerAvailable defines failure on find chief block producer.
 	ErrNoChiefBlockProducerAvailable = errors.New("no chief block producer found")
+	// ErrNodeIDDifficultyTooLow defines failure on node id proof-of-work below the minimum difficulty.
+	ErrNodeIDDifficultyTooLow = errors.New("node id difficulty too low")
+	// ErrInvalidNodeIDHeader defines failure on reading the node id header of ETLS connection.
+	ErrInvalidNodeIDHeader = errors.New("invalid node id header")
+	// ErrCircuitOpen defines failure on calling a node whose circuit is opened by recent failures.
+	ErrCircuitOpen = errors.New("circuit of node is open")
 
 	// currentBP represents current chief block producer node.
 	currentBP proto.NodeID
@@ -61,7 +65,7 @@ func NewPersistentCaller(target proto.NodeID) *PersistentCaller {
 	}
 }
 
-func (c *PersistentCaller) initClient(method string) (err error) {
+func (c *PersistentCaller) initClient(method string) (client *Client, err error) {
 	c.Lock()
 	defer c.Unlock()
 	if c.client == nil {
@@ -78,34 +82,86 @@ func (c *PersistentCaller) initClient(method string) (err error) {
 			return
 		}
 	}
+	client = c.client
 	return
 }
 
+// resetClient drops the broken client if it is still in use, a new one is dialed by next call.
+func (c *PersistentCaller) resetClient(client *Client) {
+	c.Lock()
+	defer c.Unlock()
+	if c.client == client {
+		c.Close()
+		c.client = nil
+	}
+}
+
 // Call invokes the named function, waits for it to complete, and returns its error status.
-func (c *PersistentCaller) Call(method string, args interface{}, reply interface{}) (err error) {
-	c.initClient(method)
-	err = c.client.Call(method, args, reply)
-	if err != nil {
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			// if got EOF, retry once
-			c.Lock()
-			c.Close()
-			c.client = nil
-			c.Unlock()
-			c.initClient(method)
-			err = c.client.Call(method, args, reply)
-			if err != nil {
-				log.Errorf("second time call RPC %s failed: %v", method, err)
+func (c *PersistentCaller) Call(method string, args interface{}, reply interface{}, opts ...CallOption) (err error) {
+	return c.CallWithContext(context.Background(), method, args, reply, opts...)
+}
+
+// CallWithContext invokes the named function, waits for it to complete or context timeout, and
+// returns its error status. A broken connection is redialed once, and the call is retried by the
+// retry policy of options. Aborting a call closes the connection shared by other in progress calls.
+func (c *PersistentCaller) CallWithContext(
+	ctx context.Context, method string, args interface{}, reply interface{}, opts ...CallOption,
+) (err error) {
+	var (
+		o         = newCallOptions(method, opts)
+		anonymous = method == route.DHTPing.String()
+		redialed  bool
+	)
+	for attempt := 1; ; attempt++ {
+		var client *Client
+		if client, err = c.initClient(method); err == nil {
+			attemptCtx, cancel := o.attemptContext(ctx)
+			err = callWithContext(attemptCtx, client, method, args, reply)
+			cancel()
+			if !anonymous {
+				c.pool.ReportResult(c.TargetID, err)
+			}
+			if err == nil {
 				return
 			}
+			if isConnBroken(err) || attemptCtx.Err() != nil {
+				c.resetClient(client)
+			}
+		}
+		if !redialed && isConnBroken(err) && ctx.Err() == nil {
+			// if got EOF, redial once
+			redialed = true
+			attempt--
+			continue
 		}
-		log.Errorf("call RPC %s failed: %v", method, err)
+		if !o.shouldRetry(ctx, attempt, err) || o.waitBackoff(ctx, attempt) != nil {
+			log.Errorf("call RPC %s failed: %v", method, err)
+			return
+		}
+		log.WithFields(log.Fields{
+			"node":    c.TargetID,
+			"method":  method,
+			"attempt": attempt,
+		}).WithError(err).Debug("retry rpc call")
 	}
-	return
+}
+
+// OpenStream opens a stream of method to the target node on the pooled session, the stream is
+// canceled with ctx and must be closed after use.
+func (c *PersistentCaller) OpenStream(ctx context.Context, method string) (s *Stream, err error) {
+	conn, err := DialToNode(c.TargetID, c.pool, false)
+	if err != nil {
+		log.Errorf("dialing to node: %s failed: %s", c.TargetID, err)
+		return
+	}
+	return NewClientStream(ctx, conn, method)
 }
 
 // Close closes the stream and RPC client
 func (c *PersistentCaller) Close() {
+	if c.client == nil {
+		return
+	}
 	stream, ok := c.client.Conn.(*yamux.Stream)
 	if ok {
 		stream.Close()
@@ -128,14 +184,35 @@ func NewCaller() *Caller {
 
 // CallNode invokes the named function, waits for it to complete, and returns its error status.
 func (c *Caller) CallNode(
-	node proto.NodeID, method string, args interface{}, reply interface{}) (err error) {
-	return c.CallNodeWithContext(context.Background(), node, method, args, reply)
+	node proto.NodeID, method string, args interface{}, reply interface{}, opts ...CallOption) (err error) {
+	return c.CallNodeWithContext(context.Background(), node, method, args, reply, opts...)
 }
 
 // CallNodeWithContext invokes the named function, waits for it to complete or context timeout, and returns its error status.
+// The default options of method set by SetMethodCallOptions are applied before opts.
 func (c *Caller) CallNodeWithContext(
-	ctx context.Context, node proto.NodeID, method string, args interface{}, reply interface{}) (err error) {
-	conn, err := DialToNode(node, c.pool, method == route.DHTPing.String())
+	ctx context.Context, node proto.NodeID, method string, args interface{}, reply interface{},
+	opts ...CallOption) (err error) {
+	o := newCallOptions(method, opts)
+	for attempt := 1; ; attempt++ {
+		if err = c.callNodeOnce(ctx, o, node, method, args, reply); err == nil {
+			return
+		}
+		if !o.shouldRetry(ctx, attempt, err) || o.waitBackoff(ctx, attempt) != nil {
+			return
+		}
+		log.WithFields(log.Fields{
+			"node":    node,
+			"method":  method,
+			"attempt": attempt,
+		}).WithError(err).Debug("retry rpc call")
+	}
+}
+
+func (c *Caller) callNodeOnce(ctx context.Context, o *callOptions,
+	node proto.NodeID, method string, args interface{}, reply interface{}) (err error) {
+	anonymous := method == route.DHTPing.String()
+	conn, err := DialToNode(node, c.pool, anonymous)
 	if err != nil {
 		log.Errorf("dialing to node: %s failed: %s", node, err)
 		return
@@ -158,81 +235,60 @@ func (c *Caller) CallNodeWithContext(
 
 	defer client.Close()
 
-	// TODO(xq262144): golang net/rpc does not support cancel in progress calls
-	ch := client.Go(method, args, reply, make(chan *rpc.Call, 1))
-
-	select {
-	case <-ctx.Done():
-		err = ctx.Err()
-	case call := <-ch.Done:
-		err = call.Error
+	ctx, cancel := o.attemptContext(ctx)
+	defer cancel()
+	err = callWithContext(ctx, client, method, args, reply)
+	if !anonymous && c.pool != nil {
+		c.pool.ReportResult(node, err)
 	}
 
 	return
 }
 
-// GetNodeAddr tries best to get node addr.
+// OpenStream opens a stream of method to the node, the stream is canceled with ctx and must be
+// closed after use.
+func (c *Caller) OpenStream(ctx context.Context, node proto.NodeID, method string) (s *Stream, err error) {
+	conn, err := DialToNode(node, c.pool, false)
+	if err != nil {
+		log.Errorf("dialing to node: %s failed: %s", node, err)
+		return
+	}
+	return NewClientStream(ctx, conn, method)
+}
+
+// GetNodeAddr tries best to get node addr, the node is looked up in the local routing table and
+// then the DHT on cache miss.
 func GetNodeAddr(id *proto.RawNodeID) (addr string, err error) {
 	addr, err = route.GetNodeAddrCache(id)
 	if err != nil {
 		log.Infof("get node %s addr failed: %s", id, err)
 		if err == route.ErrUnknownNodeID {
-			BPs := route.GetBPs()
-			if len(BPs) == 0 {
-				log.Errorf("no available BP")
-				return
-			}
-			client := NewCaller()
-			reqFN := &proto.FindNodeReq{
-				NodeID: proto.NodeID(id.String()),
+			if node := lookupTable(id); node != nil {
+				return node.Addr, nil
 			}
-			respFN := new(proto.FindNodeResp)
-
-			bp := BPs[rand.Intn(len(BPs))]
-			method := "DHT.FindNode"
-			err = client.CallNode(bp, method, reqFN, respFN)
-			if err != nil {
-				log.Errorf("call %s %s failed: %s", bp, method, err)
+			var node *proto.Node
+			if node, err = LookupNode(context.Background(), id); err != nil {
+				log.Errorf("lookup node %s in dht failed: %s", id, err)
 				return
 			}
-			route.SetNodeAddrCache(id, respFN.Node.Addr)
-			addr = respFN.Node.Addr
+			addr = node.Addr
 		}
 	}
 	return
 }
 
-// GetNodeInfo tries best to get node info.
+// GetNodeInfo tries best to get node info, the node is looked up in the local routing table and
+// then the DHT on cache miss.
 func GetNodeInfo(id *proto.RawNodeID) (nodeInfo *proto.Node, err error) {
 	nodeInfo, err = kms.GetNodeInfo(proto.NodeID(id.String()))
 	if err != nil {
 		log.Infof("get node info from KMS for %s failed: %s", id, err)
 		if err == kms.ErrKeyNotFound {
-			BPs := route.GetBPs()
-			if len(BPs) == 0 {
-				log.Errorf("no available BP")
-				return
-			}
-			client := NewCaller()
-			reqFN := &proto.FindNodeReq{
-				NodeID: proto.NodeID(id.String()),
-			}
-			respFN := new(proto.FindNodeResp)
-			bp := BPs[rand.Intn(len(BPs))]
-			method := "DHT.FindNode"
-			err = client.CallNode(bp, method, reqFN, respFN)
-			if err != nil {
-				log.Errorf("call %s %s failed: %s", bp, method, err)
-				return
-			}
-			nodeInfo = respFN.Node
-			errSet := route.SetNodeAddrCache(id, nodeInfo.Addr)
-			if errSet != nil {
-				log.Warnf("set node addr cache failed: %v", errSet)
+			if nodeInfo = lookupTable(id); nodeInfo != nil {
+				return nodeInfo, nil
 			}
-			errSet = kms.SetNode(nodeInfo)
-			if errSet != nil {
-				log.Warnf("set node to kms failed: %v", errSet)
+			if nodeInfo, err = LookupNode(context.Background(), id); err != nil {
+				log.Errorf("lookup node %s in dht failed: %s", id, err)
 			}
 		}
 	}
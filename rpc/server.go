@@ -17,16 +17,21 @@
 package rpc
 
 import (
+	"bufio"
+	"context"
 	"io"
 	"net"
 	"net/rpc"
+	"sync"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 	"github.com/hashicorp/yamux"
 	"github.com/ugorji/go/codec"
 )
@@ -39,7 +44,11 @@ type Server struct {
 	rpcServer  *rpc.Server
 	stopCh     chan interface{}
 	serviceMap ServiceMap
+	acl        route.ACL
 	Listener   net.Listener
+
+	streamHandlers     map[string]StreamHandler
+	streamHandlersLock sync.RWMutex
 }
 
 // NewServer return a new Server
@@ -48,6 +57,9 @@ func NewServer() *Server {
 		rpcServer:  rpc.NewServer(),
 		stopCh:     make(chan interface{}),
 		serviceMap: make(ServiceMap),
+		acl:        route.DefaultACL(),
+
+		streamHandlers: make(map[string]StreamHandler),
 	}
 }
 
@@ -67,13 +79,15 @@ func (s *Server) InitRPCServer(
 		return
 	}
 
-	l, err := etls.NewCryptoListener("tcp", addr, handleCipher)
+	// the listening port is reusable, so it's also the local port to punch NAT for relayed nodes
+	lc := &net.ListenConfig{Control: reusePortControl}
+	l, err := lc.Listen(context.Background(), "tcp", addr)
 	if err != nil {
 		log.Errorf("create crypto listener failed: %s", err)
 		return
 	}
 
-	s.SetListener(l)
+	s.SetListener(&etls.CryptoListener{Listener: l, CHandler: handleCipher})
 
 	return
 }
@@ -99,6 +113,12 @@ func (s *Server) SetListener(l net.Listener) {
 
 // Serve start the Server main loop,
 func (s *Server) Serve() {
+	s.ServeListener(s.Listener)
+}
+
+// ServeListener serves the connections accepted by the listener until the Server is stopped, it's
+// used to serve extra listeners such as RelayListener.
+func (s *Server) ServeListener(l net.Listener) {
 serverLoop:
 	for {
 		select {
@@ -106,7 +126,10 @@ serverLoop:
 			log.Info("Stopping Server Loop")
 			break serverLoop
 		default:
-			conn, err := s.Listener.Accept()
+			conn, err := l.Accept()
+			if err == ErrRelayListenerClosed {
+				break serverLoop
+			}
 			if err != nil {
 				log.Info(err)
 				continue
@@ -152,23 +175,103 @@ sessionLoop:
 				break sessionLoop
 			}
 			log.Debugf("session accepted %d for %v", muxConn.StreamID(), remoteNodeID)
-			msgpackCodec := codec.MsgpackSpecRpc.ServerCodec(muxConn, &codec.MsgpackHandle{
-				WriteExt:    true,
-				RawToString: true,
-			})
-			nodeAwareCodec := NewNodeAwareServerCodec(msgpackCodec, remoteNodeID)
-			go s.rpcServer.ServeCodec(nodeAwareCodec)
+			go s.serveMuxConn(muxConn, remoteNodeID)
 		}
 	}
 
 	log.Debugf("Server.handleConn finished for %s %s", remoteNodeID, conn.RemoteAddr())
 }
 
+// serveMuxConn serves the yamux stream as a stream if it starts with streamMagic, or as unary
+// calls otherwise.
+func (s *Server) serveMuxConn(muxConn net.Conn, remoteNodeID *proto.RawNodeID) {
+	r := bufio.NewReader(muxConn)
+	if head, err := r.Peek(1); err != nil {
+		muxConn.Close()
+		return
+	} else if head[0] == streamMagic {
+		s.serveStream(muxConn, r, remoteNodeID)
+		return
+	}
+
+	msgpackCodec := codec.MsgpackSpecRpc.ServerCodec(&bufferedConn{Conn: muxConn, r: r}, &codec.MsgpackHandle{
+		WriteExt:    true,
+		RawToString: true,
+	})
+	nodeAwareCodec := NewNodeAwareServerCodec(msgpackCodec, remoteNodeID)
+	nodeAwareCodec.ACL = s.acl
+	s.rpcServer.ServeCodec(nodeAwareCodec)
+}
+
+func (s *Server) serveStream(muxConn net.Conn, r *bufio.Reader, remoteNodeID *proto.RawNodeID) {
+	stream, err := acceptStream(muxConn, r, remoteNodeID)
+	if err != nil {
+		log.WithField("node", remoteNodeID).WithError(err).Warning("accept stream failed")
+		muxConn.Close()
+		return
+	}
+	defer stream.Close()
+
+	s.streamHandlersLock.RLock()
+	handler, ok := s.streamHandlers[stream.Method()]
+	s.streamHandlersLock.RUnlock()
+	if !ok {
+		err = ErrStreamMethodNotFound
+	} else if s.acl != nil && remoteNodeID != nil {
+		// non-ETLS connection carries no node identity to check
+		role := route.GetCallerRole(remoteNodeID)
+		if !s.acl.Permits(role, stream.Method()) {
+			log.WithFields(log.Fields{
+				"node":   remoteNodeID.String(),
+				"role":   role.String(),
+				"method": stream.Method(),
+			}).Warning("rpc stream denied by acl")
+			err = route.ErrPermissionDenied
+		}
+	}
+	if err == nil {
+		err = handler(stream)
+	}
+	stream.span.SetError(err)
+	stream.span.End()
+	if endErr := stream.end(err); endErr != nil && endErr != ErrStreamSendClosed {
+		log.WithField("method", stream.Method()).WithError(endErr).Debug("end stream failed")
+	}
+}
+
+// requestContext derives the handler context of a unary request from the local deadline and the
+// server span in its envelope, cancel should be called once the response is written.
+func requestContext(env proto.EnvelopeAPI) (ctx context.Context, cancel context.CancelFunc) {
+	ctx = trace.ContextWithTraceParent(context.Background(), env.GetTraceParent())
+	if deadline, ok := env.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
 // RegisterService with a Service name, used by Client RPC
 func (s *Server) RegisterService(name string, service interface{}) error {
 	return s.rpcServer.RegisterName(name, service)
 }
 
+// RegisterStreamHandler registers the handler of streams opened with method, which should be in
+// the form of "Service.Method" as unary calls to be checked by the ACL.
+func (s *Server) RegisterStreamHandler(method string, handler StreamHandler) (err error) {
+	s.streamHandlersLock.Lock()
+	defer s.streamHandlersLock.Unlock()
+	if _, ok := s.streamHandlers[method]; ok {
+		return ErrStreamHandlerExists
+	}
+	s.streamHandlers[method] = handler
+	return
+}
+
+// SetACL replaces the ACL checked before dispatching requests from ETLS connections,
+// should be called before Serve.
+func (s *Server) SetACL(acl route.ACL) {
+	s.acl = acl
+}
+
 // Stop Server main loop
 func (s *Server) Stop() {
 	if s.Listener != nil {
@@ -178,29 +281,42 @@ func (s *Server) Stop() {
 }
 
 func handleCipher(conn net.Conn) (cryptoConn *etls.CryptoConn, err error) {
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+
 	// NodeID + Uint256 Nonce
 	headerBuf := make([]byte, hash.HashBSize+32)
-	rCount, err := conn.Read(headerBuf)
-	if err != nil || rCount != hash.HashBSize+32 {
+	if _, err = io.ReadFull(conn, headerBuf); err != nil {
 		log.Errorf("read node header error: %s", err)
+		err = ErrInvalidNodeIDHeader
 		return
 	}
 
 	// headerBuf len is hash.HashBSize, so there won't be any error
 	idHash, _ := hash.NewHash(headerBuf[:hash.HashBSize])
 	rawNodeID := &proto.RawNodeID{Hash: *idHash}
-	// TODO(auxten): compute the nonce and check difficulty
-	cpuminer.Uint256FromBytes(headerBuf[hash.HashBSize:])
+	nonce, err := cpuminer.Uint256FromBytes(headerBuf[hash.HashBSize:])
+	if err != nil {
+		log.Errorf("read node nonce error: %s", err)
+		err = ErrInvalidNodeIDHeader
+		return
+	}
 
-	symmetricKey, err := GetSharedSecretWith(
-		rawNodeID,
-		rawNodeID.IsEqual(&kms.AnonymousRawNodeID.Hash),
-	)
+	// node id of non-anonymous ETLS must be mined from the public key used by ECDH
+	var symmetricKey []byte
+	if rawNodeID.IsEqual(&kms.AnonymousRawNodeID.Hash) {
+		symmetricKey, err = GetSharedSecretWith(rawNodeID, true)
+	} else {
+		symmetricKey, err = GetVerifiedSharedSecretWith(rawNodeID, nonce)
+	}
 	if err != nil {
 		log.Errorf("get shared secret for %s failed: %s", rawNodeID.ToNodeID(), err)
 		return
 	}
-	cipher := etls.NewCipher(symmetricKey)
+	cipher := newCipher(symmetricKey)
 	cryptoConn = etls.NewConn(conn, cipher, rawNodeID)
 
 	return
@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/consistent"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
@@ -150,6 +151,10 @@ func TestEncryptIncCounterSimpleArgs(t *testing.T) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	acl := route.DefaultACL()
+	acl["Test.IncCounterSimpleArgs"] = route.RegisteredCaller
+	acl["Test.IncCounter"] = route.MinerCaller
+	server.SetACL(acl)
 
 	route.NewDHTService(PubKeyStorePath, new(consistent.KMSStorage), true)
 	server.InitRPCServer(addr, "../keys/test.key", masterKey)
@@ -175,10 +180,93 @@ func TestEncryptIncCounterSimpleArgs(t *testing.T) {
 	}
 	CheckNum(*repSimple, 10, t)
 
+	// method not permitted to the caller role is denied before dispatching
+	rep := new(TestRep)
+	err = client.Call("Test.IncCounter", &TestReq{Step: 10}, rep)
+	if err == nil || err.Error() != route.ErrPermissionDenied.Error() {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// stream is still usable after denial
+	err = client.Call("Test.IncCounterSimpleArgs", 10, repSimple)
+	if err != nil {
+		log.Fatal(err)
+	}
+	CheckNum(*repSimple, 20, t)
+
+	// role recorded after the stream is opened takes effect on the same stream
+	route.SetNodeRole(serverNodeID, proto.Miner)
+	defer route.DeleteNodeRole(serverNodeID)
+	err = client.Call("Test.IncCounter", &TestReq{Step: 10}, rep)
+	if err != nil {
+		log.Fatal(err)
+	}
+	CheckNum(rep.Ret, 30, t)
+
 	client.Close()
 	server.Stop()
 }
 
+func TestEncryptInvalidNodeID(t *testing.T) {
+	defer os.Remove(PubKeyStorePath)
+	log.SetLevel(log.DebugLevel)
+	addr := "127.0.0.1:0"
+	masterKey := []byte("abc")
+	server, err := NewServerWithService(ServiceMap{"Test": NewTestService()})
+	if err != nil {
+		log.Fatal(err)
+	}
+	acl := route.DefaultACL()
+	acl["Test.IncCounterSimpleArgs"] = route.RegisteredCaller
+	server.SetACL(acl)
+
+	route.NewDHTService(PubKeyStorePath, new(consistent.KMSStorage), true)
+	server.InitRPCServer(addr, "../keys/test.key", masterKey)
+	go server.Serve()
+	defer server.Stop()
+
+	publicKey, err := kms.GetLocalPublicKey()
+	nonce := asymmetric.GetPubKeyNonce(publicKey, 10, 100*time.Millisecond, nil)
+	serverNodeID := proto.NodeID(nonce.Hash.String())
+	kms.SetPublicKey(serverNodeID, nonce.Nonce, publicKey)
+	route.SetNodeAddrCache(&proto.RawNodeID{Hash: nonce.Hash}, server.Listener.Addr().String())
+
+	call := func() (err error) {
+		cryptoConn, err := DialToNode(serverNodeID, nil, false)
+		if err != nil {
+			return
+		}
+		client, err := InitClientConn(cryptoConn)
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		return client.Call("Test.IncCounterSimpleArgs", 10, new(int))
+	}
+
+	Convey("node id with valid nonce should be accepted", t, func() {
+		kms.SetLocalNodeIDNonce(nonce.Hash.CloneBytes(), &nonce.Nonce)
+		So(call(), ShouldBeNil)
+	})
+
+	Convey("node id not mined from the ECDH public key should be refused", t, func() {
+		wrongNonce := nonce.Nonce
+		wrongNonce.A++
+		kms.SetLocalNodeIDNonce(nonce.Hash.CloneBytes(), &wrongNonce)
+		defer kms.SetLocalNodeIDNonce(nonce.Hash.CloneBytes(), &nonce.Nonce)
+		So(call(), ShouldNotBeNil)
+	})
+
+	Convey("node id below minimum difficulty should be refused", t, func() {
+		So(conf.GConf, ShouldNotBeNil)
+		origDifficulty := conf.GConf.MinNodeIDDifficulty
+		conf.GConf.MinNodeIDDifficulty = 256
+		defer func() { conf.GConf.MinNodeIDDifficulty = origDifficulty }()
+		kms.SetLocalNodeIDNonce(nonce.Hash.CloneBytes(), &nonce.Nonce)
+		So(call(), ShouldNotBeNil)
+	})
+}
+
 func TestEncPingFindNeighbor(t *testing.T) {
 	os.Remove(PubKeyStorePath)
 	defer os.Remove(PubKeyStorePath)
@@ -20,6 +20,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -30,39 +31,82 @@ func GetSharedSecretWith(nodeID *proto.RawNodeID, isAnonymous bool) (symmetricKe
 	if isAnonymous {
 		symmetricKey = []byte(`!&\\!qEyey*\cbLc,aKl`)
 		log.Debug("using anonymous ETLS")
-	} else {
-		var remotePublicKey *asymmetric.PublicKey
-		if route.IsBPNodeID(nodeID) {
-			remotePublicKey = kms.BP.PublicKey
-		} else if conf.RoleTag[0] == conf.BlockProducerBuildTag[0] {
-			remotePublicKey, err = kms.GetPublicKey(proto.NodeID(nodeID.String()))
-			if err != nil {
-				log.Errorf("get public key locally failed, node id: %s, err: %s", nodeID.ToNodeID(), err)
-				return
-			}
-		} else {
-			// if non BP running and key not found, ask BlockProducer
-			var nodeInfo *proto.Node
-			nodeInfo, err = GetNodeInfo(nodeID)
-			if err != nil {
-				log.Errorf("get public key failed, node id: %s, err: %s", nodeID.ToNodeID(), err)
-				return
-			}
-			remotePublicKey = nodeInfo.PublicKey
-		}
+		return
+	}
+
+	var remotePublicKey *asymmetric.PublicKey
+	if remotePublicKey, err = getRemotePublicKey(nodeID); err != nil {
+		return
+	}
+	return genSharedSecret(nodeID, remotePublicKey)
+}
+
+// GetVerifiedSharedSecretWith checks the node id proof-of-work of the remote node and gets
+// shared symmetric key with ECDH, the node id must be mined from the public key used by ECDH
+// with the nonce and satisfy the minimum difficulty.
+func GetVerifiedSharedSecretWith(nodeID *proto.RawNodeID, nonce *cpuminer.Uint256) (
+	symmetricKey []byte, err error,
+) {
+	var remotePublicKey *asymmetric.PublicKey
+	if remotePublicKey, err = getRemotePublicKey(nodeID); err != nil {
+		return
+	}
+	if err = verifyNodeID(nodeID, nonce, remotePublicKey); err != nil {
+		log.Errorf("verify node id %s failed: %s", nodeID.ToNodeID(), err)
+		return
+	}
+	return genSharedSecret(nodeID, remotePublicKey)
+}
+
+func verifyNodeID(nodeID *proto.RawNodeID, nonce *cpuminer.Uint256, key *asymmetric.PublicKey) (err error) {
+	if kms.Unittest {
+		return
+	}
+	if !kms.IsIDPubNonceValid(nodeID, nonce, key) {
+		return kms.ErrNodeIDKeyNonceNotMatch
+	}
+	if conf.GConf != nil && nodeID.Hash.Difficulty() < conf.GConf.MinNodeIDDifficulty {
+		return ErrNodeIDDifficultyTooLow
+	}
+	return
+}
 
-		var localPrivateKey *asymmetric.PrivateKey
-		localPrivateKey, err = kms.GetLocalPrivateKey()
+func getRemotePublicKey(nodeID *proto.RawNodeID) (remotePublicKey *asymmetric.PublicKey, err error) {
+	if route.IsBPNodeID(nodeID) {
+		remotePublicKey = kms.BP.PublicKey
+	} else if conf.RoleTag[0] == conf.BlockProducerBuildTag[0] {
+		remotePublicKey, err = kms.GetPublicKey(proto.NodeID(nodeID.String()))
+		if err != nil {
+			log.Errorf("get public key locally failed, node id: %s, err: %s", nodeID.ToNodeID(), err)
+			return
+		}
+	} else {
+		// if non BP running and key not found, ask BlockProducer
+		var nodeInfo *proto.Node
+		nodeInfo, err = GetNodeInfo(nodeID)
 		if err != nil {
-			log.Errorf("get local private key failed: %s", err)
+			log.Errorf("get public key failed, node id: %s, err: %s", nodeID.ToNodeID(), err)
 			return
 		}
+		remotePublicKey = nodeInfo.PublicKey
+	}
+	return
+}
 
-		symmetricKey = asymmetric.GenECDHSharedSecret(localPrivateKey, remotePublicKey)
-		log.Debugf("ECDH for %s Public Key: %x, Session Key: %x",
-			nodeID.ToNodeID(), remotePublicKey.Serialize(), symmetricKey)
-		//log.Debugf("ECDH for %s Public Key: %x, Private Key: %x Session Key: %x",
-		//	nodeID.ToNodeID(), remotePublicKey.Serialize(), localPrivateKey.Serialize(), symmetricKey)
+func genSharedSecret(nodeID *proto.RawNodeID, remotePublicKey *asymmetric.PublicKey) (
+	symmetricKey []byte, err error,
+) {
+	var localPrivateKey *asymmetric.PrivateKey
+	localPrivateKey, err = kms.GetLocalPrivateKey()
+	if err != nil {
+		log.Errorf("get local private key failed: %s", err)
+		return
 	}
+
+	symmetricKey = asymmetric.GenECDHSharedSecret(localPrivateKey, remotePublicKey)
+	log.Debugf("ECDH for %s Public Key: %x, Session Key: %x",
+		nodeID.ToNodeID(), remotePublicKey.Serialize(), symmetricKey)
+	//log.Debugf("ECDH for %s Public Key: %x, Private Key: %x Session Key: %x",
+	//	nodeID.ToNodeID(), remotePublicKey.Serialize(), localPrivateKey.Serialize(), symmetricKey)
 	return
 }
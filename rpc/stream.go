@@ -0,0 +1,339 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
+	"github.com/ugorji/go/codec"
+)
+
+/*
+	A stream takes a whole yamux stream of the session, so it is authenticated and encrypted by
+	the ETLS connection under the session, and flow controlled by the yamux stream window. The
+	first byte of the yamux stream is streamMagic, which is never used by msgpack, to distinguish
+	it from the unary calls served by net/rpc. Frames are msgpack encoded streamFrame:
+
+		client                          server
+		streamMagic, frameOpen ------>  ACL check and handler lookup
+		frameData ... frameEnd <----->  frameData ... frameEnd(Error)
+
+	Each side sends frameEnd once after its last message, the server sends it when the handler
+	returns with the error of the handler. Closing the yamux stream before both frameEnd are
+	exchanged cancels the stream on the remote side.
+*/
+
+const streamMagic byte = 0xc1
+
+type streamFrameKind uint8
+
+const (
+	frameOpen streamFrameKind = iota
+	frameData
+	frameEnd
+)
+
+type streamFrame struct {
+	Kind    streamFrameKind
+	Method  string
+	TTL     time.Duration
+	Trace   string
+	Error   string
+	Payload []byte
+}
+
+var (
+	// StreamRecvBuffer is the count of received messages buffered by a stream before the remote
+	// sender is blocked by the yamux stream window.
+	StreamRecvBuffer = 16
+
+	// ErrStreamSendClosed defines failure on sending to a stream after CloseSend.
+	ErrStreamSendClosed = errors.New("send on closed stream")
+	// ErrStreamMethodNotFound defines failure on opening a stream without registered handler.
+	ErrStreamMethodNotFound = errors.New("stream method not found")
+	// ErrStreamHandlerExists defines failure on registering a stream handler twice.
+	ErrStreamHandlerExists = errors.New("stream handler already registered")
+	// ErrInvalidStreamFrame defines failure on receiving an unexpected stream frame.
+	ErrInvalidStreamFrame = errors.New("invalid stream frame")
+)
+
+// StreamHandler handles a stream opened by remote node, the returned error is sent to the remote
+// receiver unless the handler already called CloseSend.
+type StreamHandler func(s *Stream) error
+
+// Stream is a bidirectional message stream on a yamux stream. Send and Recv can be called
+// concurrently with each other, but not concurrently with themselves.
+type Stream struct {
+	method   string
+	remoteID *proto.RawNodeID
+	conn     net.Conn
+	enc      *codec.Encoder
+	w        *bufio.Writer
+	dec      *codec.Decoder
+	ctx      context.Context
+	cancel   context.CancelFunc
+	span     *trace.Span
+
+	sendLock   sync.Mutex
+	sendClosed bool
+
+	recvCh  chan []byte
+	recvErr error
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (n int, err error) {
+	return c.r.Read(p)
+}
+
+func newStream(conn net.Conn, r *bufio.Reader, remoteID *proto.RawNodeID) *Stream {
+	mh := &codec.MsgpackHandle{
+		WriteExt:    true,
+		RawToString: true,
+	}
+	w := bufio.NewWriter(conn)
+	return &Stream{
+		remoteID: remoteID,
+		conn:     conn,
+		enc:      codec.NewEncoder(w, mh),
+		w:        w,
+		dec:      codec.NewDecoder(r, mh),
+		recvCh:   make(chan []byte, StreamRecvBuffer),
+	}
+}
+
+// start runs the receiving loop and closes the connection once the stream is canceled.
+func (s *Stream) start() {
+	go s.readLoop()
+	go func() {
+		<-s.ctx.Done()
+		s.conn.Close()
+	}()
+}
+
+// NewClientStream opens a stream of method on a yamux stream of the session to the server, the
+// stream is canceled with ctx and the deadline of ctx is sent to the server handler.
+func NewClientStream(ctx context.Context, conn net.Conn, method string) (s *Stream, err error) {
+	s = newStream(conn, bufio.NewReader(conn), nil)
+	s.method = method
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	open := &streamFrame{
+		Kind:   frameOpen,
+		Method: method,
+		Trace:  trace.TraceParent(ctx),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		open.TTL = time.Until(deadline)
+	}
+	if err = s.w.WriteByte(streamMagic); err == nil {
+		err = s.writeFrame(open)
+	}
+	if err != nil {
+		s.cancel()
+		conn.Close()
+		return nil, err
+	}
+	s.start()
+	return
+}
+
+// acceptStream reads the open frame of a stream from remote node.
+func acceptStream(conn net.Conn, r *bufio.Reader, remoteID *proto.RawNodeID) (s *Stream, err error) {
+	if _, err = r.Discard(1); err != nil {
+		return
+	}
+	s = newStream(conn, r, remoteID)
+	var open streamFrame
+	if err = s.dec.Decode(&open); err != nil {
+		return nil, err
+	}
+	if open.Kind != frameOpen {
+		return nil, ErrInvalidStreamFrame
+	}
+	s.method = open.Method
+	ctx := trace.ContextWithTraceParent(context.Background(), open.Trace)
+	ctx, s.span = trace.StartSpan(ctx, open.Method, trace.SpanKindServer)
+	s.span.SetAttribute("rpc.system", rpcSystem)
+	s.span.SetAttribute("rpc.method", open.Method)
+	if open.TTL > 0 {
+		s.ctx, s.cancel = context.WithTimeout(ctx, open.TTL)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(ctx)
+	}
+	s.start()
+	return
+}
+
+// Context returns the context of the stream, which is done once the stream is closed, canceled by
+// remote or broken. On server side it also carries the span of the stream.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Method returns the method name of the stream.
+func (s *Stream) Method() string {
+	return s.method
+}
+
+// RemoteNodeID returns the node id of the client on server side, nil for non-ETLS connections
+// and client side.
+func (s *Stream) RemoteNodeID() *proto.RawNodeID {
+	return s.remoteID
+}
+
+// Send sends a message to the remote, it blocks while the remote is not receiving as fast.
+func (s *Stream) Send(msg interface{}) (err error) {
+	buf, err := utils.EncodeMsgPack(msg)
+	if err != nil {
+		return
+	}
+	return s.sendPayload(buf.Bytes())
+}
+
+// Recv receives a message from the remote. It returns io.EOF after the last message if the remote
+// finished sending normally, or rpc.ServerError of the remote handler.
+func (s *Stream) Recv(msg interface{}) (err error) {
+	payload, err := s.recvPayload()
+	if err != nil {
+		return
+	}
+	return utils.DecodeMsgPack(payload, msg)
+}
+
+// sendPayload sends an encoded message, it's used to forward messages without decoding.
+func (s *Stream) sendPayload(payload []byte) (err error) {
+	return s.writeFrame(&streamFrame{
+		Kind:    frameData,
+		Payload: payload,
+	})
+}
+
+// recvPayload receives an encoded message.
+func (s *Stream) recvPayload() (payload []byte, err error) {
+	payload, ok := <-s.recvCh
+	if !ok {
+		return nil, s.recvErr
+	}
+	return
+}
+
+// CloseSend tells the remote that no more messages will be sent.
+func (s *Stream) CloseSend() (err error) {
+	return s.end(nil)
+}
+
+// Close cancels the stream if it is not finished yet and releases the connection, it must be
+// called after use.
+func (s *Stream) Close() (err error) {
+	s.cancel()
+	return
+}
+
+func (s *Stream) end(handlerErr error) (err error) {
+	f := &streamFrame{Kind: frameEnd}
+	if handlerErr != nil {
+		f.Error = handlerErr.Error()
+	}
+	return s.writeFrame(f)
+}
+
+func (s *Stream) writeFrame(f *streamFrame) (err error) {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if s.sendClosed {
+		return ErrStreamSendClosed
+	}
+	if s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	if f.Kind == frameEnd {
+		s.sendClosed = true
+	}
+	if err = s.enc.Encode(f); err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		if s.ctx.Err() != nil {
+			err = s.ctx.Err()
+		}
+		s.cancel()
+	}
+	return
+}
+
+func (s *Stream) readLoop() {
+	var ended bool
+	finish := func(err error) {
+		if !ended {
+			ended = true
+			s.recvErr = err
+			close(s.recvCh)
+		}
+	}
+
+	for {
+		var f streamFrame
+		if err := s.dec.Decode(&f); err != nil {
+			// connection closed before the remote end means it is canceled
+			if s.ctx.Err() != nil {
+				err = s.ctx.Err()
+			} else if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			finish(err)
+			s.cancel()
+			return
+		}
+
+		switch {
+		case ended:
+			// nothing is expected after the remote end except closing the connection
+			continue
+		case f.Kind == frameData:
+			select {
+			case s.recvCh <- f.Payload:
+			case <-s.ctx.Done():
+				finish(s.ctx.Err())
+				return
+			}
+		case f.Kind == frameEnd:
+			if f.Error != "" {
+				finish(rpc.ServerError(f.Error))
+			} else {
+				finish(io.EOF)
+			}
+		default:
+			finish(ErrInvalidStreamFrame)
+			s.cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/consistent"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/hashicorp/yamux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type WatchReq struct {
+	From  int
+	Count int
+	Size  int
+}
+
+type WatchEvent struct {
+	Seq  int
+	Data []byte
+}
+
+// watch sends Count events starting from From.
+func watch(s *Stream) (err error) {
+	var req WatchReq
+	if err = s.Recv(&req); err != nil {
+		return
+	}
+	for i := 0; i < req.Count; i++ {
+		if err = s.Send(&WatchEvent{Seq: req.From + i, Data: make([]byte, req.Size)}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// echo sends back each received event until the client closes send.
+func echo(s *Stream) (err error) {
+	for {
+		var ev WatchEvent
+		if err = s.Recv(&ev); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return
+		}
+		if ev.Seq < 0 {
+			return errors.New("negative seq")
+		}
+		if err = s.Send(&ev); err != nil {
+			return
+		}
+	}
+}
+
+func openTestStream(ctx context.Context, addr string, method string) (s *Stream, err error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	sess, err := yamux.Client(conn, YamuxConfig)
+	if err != nil {
+		return
+	}
+	muxConn, err := sess.OpenStream()
+	if err != nil {
+		return
+	}
+	return NewClientStream(ctx, muxConn, method)
+}
+
+func TestStream(t *testing.T) {
+	var (
+		sent      int32
+		cancelled = make(chan error, 1)
+	)
+	server, err := NewServerWithService(ServiceMap{"Test": NewTestService()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterStreamHandler("Test.Watch", watch)
+	server.RegisterStreamHandler("Test.Echo", echo)
+	server.RegisterStreamHandler("Test.Flood", func(s *Stream) error {
+		for {
+			if err := s.Send(&WatchEvent{Data: make([]byte, 64<<10)}); err != nil {
+				return err
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+	})
+	server.RegisterStreamHandler("Test.Forever", func(s *Stream) error {
+		<-s.Context().Done()
+		cancelled <- s.Context().Err()
+		return s.Context().Err()
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetListener(l)
+	go server.Serve()
+	defer server.Stop()
+	addr := l.Addr().String()
+
+	Convey("stream handler should not be registered twice", t, func() {
+		So(server.RegisterStreamHandler("Test.Watch", watch), ShouldEqual, ErrStreamHandlerExists)
+	})
+
+	Convey("server streaming should receive all events and io.EOF", t, func() {
+		s, err := openTestStream(context.Background(), addr, "Test.Watch")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		So(s.Send(&WatchReq{From: 10, Count: 100}), ShouldBeNil)
+		So(s.CloseSend(), ShouldBeNil)
+		So(s.Send(&WatchReq{}), ShouldEqual, ErrStreamSendClosed)
+		for i := 0; i < 100; i++ {
+			var ev WatchEvent
+			So(s.Recv(&ev), ShouldBeNil)
+			So(ev.Seq, ShouldEqual, 10+i)
+		}
+		So(s.Recv(&WatchEvent{}), ShouldEqual, io.EOF)
+		So(s.Recv(&WatchEvent{}), ShouldEqual, io.EOF)
+
+		// unary calls are served on the same server
+		client, err := initClient(addr)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		rep := new(int)
+		So(client.Call("Test.IncCounterSimpleArgs", 1, rep), ShouldBeNil)
+	})
+
+	Convey("bidirectional stream should echo events and return handler error", t, func() {
+		s, err := openTestStream(context.Background(), addr, "Test.Echo")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		for i := 0; i < 10; i++ {
+			var ev WatchEvent
+			So(s.Send(&WatchEvent{Seq: i}), ShouldBeNil)
+			So(s.Recv(&ev), ShouldBeNil)
+			So(ev.Seq, ShouldEqual, i)
+		}
+		So(s.CloseSend(), ShouldBeNil)
+		So(s.Recv(&WatchEvent{}), ShouldEqual, io.EOF)
+
+		s, err = openTestStream(context.Background(), addr, "Test.Echo")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		So(s.Send(&WatchEvent{Seq: -1}), ShouldBeNil)
+		So(s.Recv(&WatchEvent{}), ShouldResemble, rpc.ServerError("negative seq"))
+	})
+
+	Convey("unknown stream method should be refused", t, func() {
+		s, err := openTestStream(context.Background(), addr, "Test.Unknown")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		So(s.Recv(&WatchEvent{}), ShouldResemble, rpc.ServerError(ErrStreamMethodNotFound.Error()))
+	})
+
+	Convey("sender should be blocked by slow receiver", t, func() {
+		s, err := openTestStream(context.Background(), addr, "Test.Flood")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		var ev WatchEvent
+		So(s.Recv(&ev), ShouldBeNil)
+		time.Sleep(500 * time.Millisecond)
+		blocked := atomic.LoadInt32(&sent)
+		time.Sleep(200 * time.Millisecond)
+		So(atomic.LoadInt32(&sent), ShouldEqual, blocked)
+		So(blocked, ShouldBeLessThan, int32(2*StreamRecvBuffer)+int32(YamuxConfig.MaxStreamWindowSize>>16)+4)
+		for i := 0; i < 10; i++ {
+			So(s.Recv(&ev), ShouldBeNil)
+		}
+		time.Sleep(200 * time.Millisecond)
+		So(atomic.LoadInt32(&sent), ShouldBeGreaterThan, blocked)
+	})
+
+	Convey("canceling stream on client should cancel server handler", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := openTestStream(ctx, addr, "Test.Forever")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		cancel()
+		So(s.Recv(&WatchEvent{}), ShouldEqual, context.Canceled)
+		So(s.Send(&WatchEvent{}), ShouldEqual, context.Canceled)
+		select {
+		case err := <-cancelled:
+			So(err, ShouldEqual, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server handler is not canceled")
+		}
+	})
+
+	Convey("client deadline should be propagated to server handler", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		s, err := openTestStream(ctx, addr, "Test.Forever")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		select {
+		case err := <-cancelled:
+			So(err == context.DeadlineExceeded, ShouldBeTrue)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server handler is not canceled")
+		}
+	})
+}
+
+func TestEncryptStream(t *testing.T) {
+	defer os.Remove(PubKeyStorePath)
+	server, err := NewServerWithService(ServiceMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var remoteID *proto.RawNodeID
+	server.RegisterStreamHandler("Test.Watch", func(s *Stream) error {
+		remoteID = s.RemoteNodeID()
+		return watch(s)
+	})
+	server.RegisterStreamHandler("Test.Secret", watch)
+	acl := route.DefaultACL()
+	acl["Test.Watch"] = route.RegisteredCaller
+	server.SetACL(acl)
+
+	route.NewDHTService(PubKeyStorePath, new(consistent.KMSStorage), true)
+	server.InitRPCServer("127.0.0.1:0", "../keys/test.key", []byte("abc"))
+	go server.Serve()
+	defer server.Stop()
+
+	publicKey, err := kms.GetLocalPublicKey()
+	nonce := asymmetric.GetPubKeyNonce(publicKey, 10, 100*time.Millisecond, nil)
+	serverNodeID := proto.NodeID(nonce.Hash.String())
+	kms.SetPublicKey(serverNodeID, nonce.Nonce, publicKey)
+	kms.SetLocalNodeIDNonce(nonce.Hash.CloneBytes(), &nonce.Nonce)
+	route.SetNodeAddrCache(&proto.RawNodeID{Hash: nonce.Hash}, server.Listener.Addr().String())
+
+	Convey("stream over ETLS should be checked by ACL", t, func() {
+		caller := NewCaller()
+		s, err := caller.OpenStream(context.Background(), serverNodeID, "Test.Watch")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		So(s.Send(&WatchReq{Count: 3}), ShouldBeNil)
+		for i := 0; i < 3; i++ {
+			var ev WatchEvent
+			So(s.Recv(&ev), ShouldBeNil)
+			So(ev.Seq, ShouldEqual, i)
+		}
+		So(s.Recv(&WatchEvent{}), ShouldEqual, io.EOF)
+		So(remoteID, ShouldNotBeNil)
+		So(remoteID.ToNodeID(), ShouldEqual, serverNodeID)
+
+		s, err = NewPersistentCaller(serverNodeID).OpenStream(context.Background(), "Test.Secret")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		So(s.Recv(&WatchEvent{}), ShouldResemble, rpc.ServerError(route.ErrPermissionDenied.Error()))
+	})
+}
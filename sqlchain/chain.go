@@ -18,6 +18,7 @@ package sqlchain
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"os"
@@ -34,8 +35,10 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
 	ct "github.com/CovenantSQL/CovenantSQL/sqlchain/types"
+	"github.com/CovenantSQL/CovenantSQL/timesync"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 	wt "github.com/CovenantSQL/CovenantSQL/worker/types"
 	"github.com/coreos/bbolt"
 )
@@ -507,6 +510,9 @@ func (c *Chain) produceBlock(now time.Time) (err error) {
 	}
 	peers := c.rt.getPeers()
 	wg := &sync.WaitGroup{}
+	ctx, span := trace.StartSpan(context.Background(), "sqlchain.AdviseNewBlock", trace.SpanKindInternal)
+	span.SetAttribute("sqlchain.block", block.BlockHash().String())
+	defer span.End()
 
 	for _, s := range peers.Servers {
 		if s.ID != c.rt.getServer().ID {
@@ -514,8 +520,8 @@ func (c *Chain) produceBlock(now time.Time) (err error) {
 			go func(id proto.NodeID) {
 				defer wg.Done()
 				resp := &MuxAdviseNewBlockResp{}
-				if err := c.cl.CallNode(
-					id, route.SQLCAdviseNewBlock.String(), req, resp); err != nil {
+				if err := c.cl.CallNodeWithContext(
+					ctx, id, route.SQLCAdviseNewBlock.String(), req, resp); err != nil {
 					log.WithFields(log.Fields{
 						"peer":            c.rt.getPeerInfoString(),
 						"time":            c.rt.getChainTimeString(),
@@ -797,6 +803,49 @@ func (c *Chain) processAcks() {
 	}
 }
 
+// syncTime measures the clocks of the other peers and updates the offset to the coordinated chain
+// time.
+func (c *Chain) syncTime() {
+	var (
+		peers = c.rt.getPeers()
+		self  = c.rt.getServer().ID
+		ids   []proto.NodeID
+	)
+	for _, s := range peers.Servers {
+		if s.ID != self {
+			ids = append(ids, s.ID)
+		}
+	}
+	offset := c.rt.clock.Sync(ids, func(id proto.NodeID) (recv, xmit time.Time, err error) {
+		resp := &timesync.QueryTimeResp{}
+		if err = c.cl.CallNode(
+			id, route.SQLCQueryTime.String(), &timesync.QueryTimeReq{}, resp,
+		); err != nil {
+			return
+		}
+		return resp.ReceiveTime, resp.TransmitTime, nil
+	})
+	c.rt.setOffset(offset)
+	log.WithFields(log.Fields{
+		"peer":   c.rt.getPeerInfoString(),
+		"time":   c.rt.getChainTimeString(),
+		"offset": offset.String(),
+	}).Debug("Synchronized chain time")
+}
+
+// timeSyncCycle synchronizes the chain time once a period.
+func (c *Chain) timeSyncCycle() {
+	defer c.rt.wg.Done()
+	for {
+		c.syncTime()
+		select {
+		case <-c.rt.stopCh:
+			return
+		case <-time.After(c.rt.period):
+		}
+	}
+}
+
 // Start starts the main process of the sql-chain.
 func (c *Chain) Start() (err error) {
 	if err = c.sync(); err != nil {
@@ -813,6 +862,8 @@ func (c *Chain) Start() (err error) {
 	go c.mainCycle()
 	c.rt.wg.Add(1)
 	go c.replicationCycle()
+	c.rt.wg.Add(1)
+	go c.timeSyncCycle()
 	c.rt.startService(c)
 	return
 }
@@ -1221,13 +1272,17 @@ func (c *Chain) collectBillingSignatures(billings *pt.BillingRequest) {
 }
 
 // LaunchBilling launches a new billing process for the blocks within height range [low, high]
-// (inclusive).
+// (inclusive). A negative high stands for the highest height which is ensured by now.
 func (c *Chain) LaunchBilling(low, high int32) (err error) {
 	var (
 		req *pt.BillingRequest
 		h   *hash.Hash
 	)
 
+	if high < 0 {
+		high = c.rt.getNextTurn() - 2
+	}
+
 	defer log.WithFields(log.Fields{
 		"peer": c.rt.getPeerInfoString(),
 		"time": c.rt.getChainTimeString(),
@@ -21,6 +21,7 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/timesync"
 )
 
 // MuxService defines multiplexing service of sql-chain.
@@ -187,6 +188,13 @@ type MuxCancelSubscriptionResp struct {
 	CancelSubscriptionResp
 }
 
+// QueryTime is the RPC method to query the local clock for time synchronization, which is shared
+// by all the sql-chains of the node.
+func (s *MuxService) QueryTime(req *timesync.QueryTimeReq, resp *timesync.QueryTimeResp) error {
+	timesync.ServeQueryTime(resp)
+	return nil
+}
+
 // AdviseNewBlock is the RPC method to advise a new produced block to the target server.
 func (s *MuxService) AdviseNewBlock(req *MuxAdviseNewBlockReq, resp *MuxAdviseNewBlockResp) error {
 	if v, ok := s.serviceMap.Load(req.DatabaseID); ok {
@@ -25,6 +25,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/kayak"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	ct "github.com/CovenantSQL/CovenantSQL/sqlchain/types"
+	"github.com/CovenantSQL/CovenantSQL/timesync"
 	wt "github.com/CovenantSQL/CovenantSQL/worker/types"
 )
 
@@ -75,11 +76,11 @@ type runtime struct {
 	// forks is the alternative head of the sql-chain.
 	forks []*state
 
+	// clock measures the clock offsets of the other peers.
+	clock *timesync.Clock
 	// timeMutex protects following time-relative fields.
 	timeMutex sync.Mutex
 	// offset is the time difference calculated by: coodinatedChainTime - time.Now().
-	//
-	// TODO(leventeliu): update offset in ping cycle.
 	offset time.Duration
 }
 
@@ -107,6 +108,7 @@ func newRunTime(c *Config) (r *runtime) {
 		total:    int32(len(c.Peers.Servers)),
 		nextTurn: 1,
 		head:     &state{},
+		clock:    timesync.NewClock(c.Period/timesync.MaxSkewRatio, timesync.ExpiryRounds*c.Period),
 		offset:   time.Duration(0),
 	}
 
@@ -157,6 +159,13 @@ func (r *runtime) updateTime(now time.Time) {
 	r.offset = time.Until(now)
 }
 
+// setOffset updates the time difference to the coodinated chain time.
+func (r *runtime) setOffset(offset time.Duration) {
+	r.timeMutex.Lock()
+	defer r.timeMutex.Unlock()
+	r.offset = offset
+}
+
 // now returns the current coodinated chain time.
 func (r *runtime) now() time.Time {
 	r.timeMutex.Lock()
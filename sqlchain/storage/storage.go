@@ -26,6 +26,7 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/twopc"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 
 	// Register CovenantSQL/go-sqlite3-encrypt engine.
 	_ "github.com/CovenantSQL/go-sqlite3-encrypt"
@@ -106,6 +107,12 @@ func equalTxID(x, y *TxID) bool {
 	return x.ConnectionID == y.ConnectionID && x.SeqNo == y.SeqNo && x.Timestamp == y.Timestamp
 }
 
+// ExecResult represents the result of a single write query.
+type ExecResult struct {
+	RowsAffected int64
+	LastInsertID int64
+}
+
 // Storage represents a underlying storage implementation based on sqlite3.
 type Storage struct {
 	sync.Mutex
@@ -114,6 +121,7 @@ type Storage struct {
 	tx      *sql.Tx // Current tx
 	id      TxID
 	queries []Query
+	results map[TxID][]ExecResult // exec results of watched txs
 }
 
 // New returns a new storage connected by dsn.
@@ -125,11 +133,30 @@ func New(dsn string) (st *Storage, err error) {
 	}
 
 	return &Storage{
-		dsn: dsn,
-		db:  db,
+		dsn:     dsn,
+		db:      db,
+		results: make(map[TxID][]ExecResult),
 	}, nil
 }
 
+// WatchResults registers the tx, its exec results are kept on commit until TakeResults is
+// called, results of the txs not watched are discarded.
+func (s *Storage) WatchResults(id TxID) {
+	s.Lock()
+	defer s.Unlock()
+	s.results[id] = nil
+}
+
+// TakeResults returns the exec results of the watched tx and unregisters it, nil is returned
+// if the tx is not committed.
+func (s *Storage) TakeResults(id TxID) (results []ExecResult) {
+	s.Lock()
+	defer s.Unlock()
+	results = s.results[id]
+	delete(s.results, id)
+	return
+}
+
 // Prepare implements prepare method of two-phase commit worker.
 func (s *Storage) Prepare(ctx context.Context, wb twopc.WriteBatch) (err error) {
 	el, ok := wb.(*ExecLog)
@@ -171,12 +198,17 @@ func (s *Storage) Commit(ctx context.Context, wb twopc.WriteBatch) (err error) {
 		return errors.New("unexpected WriteBatch type")
 	}
 
+	ctx, span := startSpan(ctx, "storage.Commit", len(el.Queries))
+	defer endSpan(span, &err)
+
 	s.Lock()
 	defer s.Unlock()
 
 	if s.tx != nil {
 		if equalTxID(&s.id, &TxID{el.ConnectionID, el.SeqNo, el.Timestamp}) {
-			for _, q := range s.queries {
+			results := make([]ExecResult, len(s.queries))
+
+			for qi, q := range s.queries {
 				// convert arguments types
 				args := make([]interface{}, len(q.Args))
 
@@ -184,7 +216,8 @@ func (s *Storage) Commit(ctx context.Context, wb twopc.WriteBatch) (err error) {
 					args[i] = v
 				}
 
-				_, err = s.tx.ExecContext(ctx, q.Pattern, args...)
+				var result sql.Result
+				result, err = s.tx.ExecContext(ctx, q.Pattern, args...)
 
 				if err != nil {
 					log.Debugf("commit query failed: %v", err)
@@ -193,9 +226,15 @@ func (s *Storage) Commit(ctx context.Context, wb twopc.WriteBatch) (err error) {
 					s.queries = nil
 					return
 				}
+
+				results[qi].RowsAffected, _ = result.RowsAffected()
+				results[qi].LastInsertID, _ = result.LastInsertId()
 			}
 
 			s.tx.Commit()
+			if _, ok := s.results[s.id]; ok {
+				s.results[s.id] = results
+			}
 			s.tx = nil
 			s.queries = nil
 			return nil
@@ -242,6 +281,9 @@ func (s *Storage) Query(ctx context.Context, queries []Query) (columns []string,
 		return
 	}
 
+	ctx, span := startSpan(ctx, "storage.Query", len(queries))
+	defer endSpan(span, &err)
+
 	var tx *sql.Tx
 	var txOptions = &sql.TxOptions{
 		ReadOnly: true,
@@ -302,6 +344,9 @@ func (s *Storage) Exec(ctx context.Context, queries []Query) (rowsAffected int64
 		return
 	}
 
+	ctx, span := startSpan(ctx, "storage.Exec", len(queries))
+	defer endSpan(span, &err)
+
 	var tx *sql.Tx
 	var txOptions = &sql.TxOptions{
 		ReadOnly: false,
@@ -411,3 +456,15 @@ func (s *rowScanner) ScanArgs() []interface{} {
 	s.fields = make([]interface{}, s.fieldCnt)
 	return s.scanArgs
 }
+
+func startSpan(ctx context.Context, name string, queries int) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpan(ctx, name, trace.SpanKindInternal)
+	span.SetAttribute("db.system", "sqlite")
+	span.SetAttribute("db.queries", queries)
+	return ctx, span
+}
+
+func endSpan(span *trace.Span, err *error) {
+	span.SetError(*err)
+	span.End()
+}
@@ -147,10 +147,28 @@ func TestStorage(t *testing.T) {
 		t.Logf("Error occurred as expected: %v", err)
 	}
 
+	id1 := TxID{el1.ConnectionID, el1.SeqNo, el1.Timestamp}
+	st.WatchResults(id1)
+
 	if err = st.Commit(context.Background(), el1); err != nil {
 		t.Fatalf("Error occurred: %v", err)
 	}
 
+	// test exec results
+	results := st.TakeResults(id1)
+	if len(results) != len(el1.Queries) {
+		t.Fatalf("Error exec result count: %v, should be %v", len(results), len(el1.Queries))
+	}
+	if results[2].RowsAffected != 1 || results[2].LastInsertID != 2 {
+		t.Fatalf("Error exec result: %v", results[2])
+	}
+	if results[6].RowsAffected != 1 {
+		t.Fatalf("Error exec result: %v", results[6])
+	}
+	if results = st.TakeResults(id1); results != nil {
+		t.Fatalf("Unexpected exec results: %v", results)
+	}
+
 	// test query
 	columns, types, data, err := st.Query(context.Background(),
 		[]Query{newQuery("SELECT * FROM `kv` ORDER BY `key` ASC")})
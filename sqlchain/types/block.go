@@ -128,7 +128,7 @@ type Block struct {
 }
 
 // PackAndSignBlock generates the signature for the Block from the given PrivateKey.
-func (b *Block) PackAndSignBlock(signer *asymmetric.PrivateKey) (err error) {
+func (b *Block) PackAndSignBlock(signer asymmetric.Signer) (err error) {
 	// Calculate merkle root
 	b.SignedHeader.MerkleRoot = *merkle.NewMerkle(b.Queries).GetRoot()
 	buffer, err := b.SignedHeader.Header.MarshalHash()
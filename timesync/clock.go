@@ -0,0 +1,218 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package timesync
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+const (
+	// sampleWindow is the number of recent samples kept for each peer.
+	sampleWindow = 8
+
+	// MaxSkewRatio is the suggested ratio of the block period to the clock skew which triggers an
+	// alarm, a node skewing about half a period would produce blocks in wrong turns.
+	MaxSkewRatio = 10
+	// ExpiryRounds is the suggested number of sync rounds after which a sample expires.
+	ExpiryRounds = 2 * sampleWindow
+)
+
+// Sample defines a clock offset measurement of a peer.
+type Sample struct {
+	// Offset is the clock of the peer minus the local clock.
+	Offset time.Duration
+	// Delay is the round trip delay of the measurement.
+	Delay time.Duration
+	// Time is the local time when the measurement is finished.
+	Time time.Time
+}
+
+// NewSample returns the sample measured with the local transmit time t0, the peer receive time t1,
+// the peer transmit time t2 and the local receive time t3.
+func NewSample(t0, t1, t2, t3 time.Time) Sample {
+	return Sample{
+		Offset: (t1.Sub(t0) + t2.Sub(t3)) / 2,
+		Delay:  t3.Sub(t0) - t2.Sub(t1),
+		Time:   t3,
+	}
+}
+
+// QueryFunc queries the clock of a peer, and returns the times when the peer receives the query
+// and transmits the response.
+type QueryFunc func(id proto.NodeID) (recv, xmit time.Time, err error)
+
+// Clock tracks the clock offsets of peers.
+type Clock struct {
+	sync.Mutex
+	// maxSkew is the offset to the coordinated chain time which triggers an alarm.
+	maxSkew time.Duration
+	// expiry is the duration after which a sample is dropped.
+	expiry  time.Duration
+	samples map[proto.NodeID][]Sample
+	offset  time.Duration
+
+	// now returns the local clock reading, it is replaceable in tests.
+	now func() time.Time
+}
+
+// NewClock returns a new clock, which alarms if the local clock or a peer clock skews more than
+// maxSkew, and ignores samples older than expiry.
+func NewClock(maxSkew, expiry time.Duration) *Clock {
+	return &Clock{
+		maxSkew: maxSkew,
+		expiry:  expiry,
+		samples: make(map[proto.NodeID][]Sample),
+		now:     time.Now,
+	}
+}
+
+// AddSample adds a sample of peer id.
+func (c *Clock) AddSample(id proto.NodeID, s Sample) {
+	c.Lock()
+	defer c.Unlock()
+	ss := append(c.samples[id], s)
+	if len(ss) > sampleWindow {
+		ss = ss[len(ss)-sampleWindow:]
+	}
+	c.samples[id] = ss
+}
+
+// peerOffsets returns the offset of each peer with unexpired samples, which is taken from the
+// sample of the minimum delay, the caller should hold the lock.
+func (c *Clock) peerOffsets(now time.Time) (offsets map[proto.NodeID]time.Duration) {
+	offsets = make(map[proto.NodeID]time.Duration)
+	for id, ss := range c.samples {
+		var (
+			best  *Sample
+			valid = ss[:0]
+		)
+		for i := range ss {
+			if c.expiry > 0 && now.Sub(ss[i].Time) > c.expiry {
+				continue
+			}
+			valid = append(valid, ss[i])
+		}
+		if len(valid) == 0 {
+			delete(c.samples, id)
+			continue
+		}
+		c.samples[id] = valid
+		for i := range valid {
+			if best == nil || valid[i].Delay < best.Delay {
+				best = &valid[i]
+			}
+		}
+		offsets[id] = best.Offset
+	}
+	return
+}
+
+// Update recomputes and returns the offset of the local clock to the coordinated chain time,
+// which is the median of the local clock and the peer clocks.
+func (c *Clock) Update() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	var (
+		offsets = c.peerOffsets(c.now())
+		values  = []time.Duration{0} // the local clock
+	)
+	for _, v := range offsets {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	if n := len(values); n%2 == 1 {
+		c.offset = values[n/2]
+	} else {
+		c.offset = (values[n/2-1] + values[n/2]) / 2
+	}
+
+	if c.maxSkew > 0 {
+		if abs(c.offset) > c.maxSkew {
+			log.WithFields(log.Fields{
+				"offset":   c.offset.String(),
+				"max_skew": c.maxSkew.String(),
+				"peers":    len(offsets),
+			}).Warning("local clock skews from the chain time")
+		}
+		for id, v := range offsets {
+			if abs(v-c.offset) > c.maxSkew {
+				log.WithFields(log.Fields{
+					"peer":     id,
+					"offset":   (v - c.offset).String(),
+					"max_skew": c.maxSkew.String(),
+				}).Warning("peer clock skews from the chain time")
+			}
+		}
+	}
+	return c.offset
+}
+
+// Offset returns the last computed offset of the local clock to the coordinated chain time.
+func (c *Clock) Offset() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return c.offset
+}
+
+// Sync measures the clocks of peers with query and returns the updated offset. Failed queries are
+// logged and skipped.
+func (c *Clock) Sync(peers []proto.NodeID, query QueryFunc) time.Duration {
+	for _, id := range peers {
+		t0 := c.now()
+		t1, t2, err := query(id)
+		t3 := c.now()
+		if err != nil {
+			log.WithField("peer", id).WithError(err).Debug("failed to query peer clock")
+			continue
+		}
+		c.AddSample(id, NewSample(t0, t1, t2, t3))
+	}
+	return c.Update()
+}
+
+// QueryTimeReq defines a request of the QueryTime RPC method.
+type QueryTimeReq struct {
+	proto.Envelope
+}
+
+// QueryTimeResp defines a response of the QueryTime RPC method.
+type QueryTimeResp struct {
+	proto.Envelope
+	// ReceiveTime is the time when the request is received.
+	ReceiveTime time.Time
+	// TransmitTime is the time when the response is sent.
+	TransmitTime time.Time
+}
+
+// ServeQueryTime fills resp with the local clock, RPC services implement the QueryTime method by
+// calling it on receiving the request.
+func ServeQueryTime(resp *QueryTimeResp) {
+	resp.ReceiveTime = time.Now()
+	resp.TransmitTime = time.Now()
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
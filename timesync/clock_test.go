@@ -0,0 +1,123 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package timesync
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// simulatedPeers simulates peers with clock offsets and one-way network delays.
+type simulatedPeers struct {
+	local   time.Time
+	offsets map[proto.NodeID]time.Duration
+	delays  map[proto.NodeID]time.Duration
+}
+
+func (p *simulatedPeers) now() time.Time {
+	return p.local
+}
+
+func (p *simulatedPeers) query(id proto.NodeID) (recv, xmit time.Time, err error) {
+	offset, ok := p.offsets[id]
+	if !ok {
+		err = errors.New("unreachable")
+		return
+	}
+	p.local = p.local.Add(p.delays[id])
+	recv = p.local.Add(offset)
+	xmit = recv
+	p.local = p.local.Add(p.delays[id])
+	return
+}
+
+func TestNewSample(t *testing.T) {
+	Convey("The sample should be computed NTP-style", t, func() {
+		var (
+			t0 = time.Unix(100, 0)
+			// peer clock is 1s ahead, 10ms each way, 2ms processing
+			t1 = t0.Add(time.Second + 10*time.Millisecond)
+			t2 = t1.Add(2 * time.Millisecond)
+			t3 = t0.Add(22 * time.Millisecond)
+			s  = NewSample(t0, t1, t2, t3)
+		)
+		So(s.Offset, ShouldEqual, time.Second)
+		So(s.Delay, ShouldEqual, 20*time.Millisecond)
+		So(s.Time, ShouldEqual, t3)
+	})
+}
+
+func TestClock(t *testing.T) {
+	Convey("Given a clock and simulated peers", t, func() {
+		var (
+			p = &simulatedPeers{
+				local: time.Unix(100, 0),
+				offsets: map[proto.NodeID]time.Duration{
+					"node1": 100 * time.Millisecond,
+					"node2": 200 * time.Millisecond,
+					"node3": 300 * time.Millisecond,
+					"node4": time.Hour,
+				},
+				delays: map[proto.NodeID]time.Duration{},
+			}
+			c   = NewClock(time.Second, time.Minute)
+			ids = []proto.NodeID{"node1", "node2", "node3", "node4", "node5"}
+		)
+		c.now = p.now
+
+		Convey("The offset should be the median clock", func() {
+			// {0, 100ms, 200ms, 300ms, 1h}, node5 is unreachable
+			So(c.Sync(ids, p.query), ShouldEqual, 200*time.Millisecond)
+			So(c.Offset(), ShouldEqual, 200*time.Millisecond)
+		})
+		Convey("The offset should be averaged with even clocks", func() {
+			// {0, 100ms, 200ms, 300ms}
+			So(c.Sync(ids[:3], p.query), ShouldEqual, 150*time.Millisecond)
+		})
+		Convey("The local clock should be used without peers", func() {
+			So(c.Sync(nil, p.query), ShouldEqual, 0)
+		})
+		Convey("The sample with the minimum delay should be used", func() {
+			p.delays["node1"] = 50 * time.Millisecond
+			p.offsets["node1"] = 2 * time.Second
+			c.Sync(ids[:1], p.query)
+			p.delays["node1"] = 0
+			p.offsets["node1"] = 100 * time.Millisecond
+			c.Sync(ids[:1], p.query)
+			p.delays["node1"] = 80 * time.Millisecond
+			p.offsets["node1"] = 3 * time.Second
+			// {0, 100ms}
+			So(c.Sync(ids[:1], p.query), ShouldEqual, 50*time.Millisecond)
+		})
+		Convey("The samples should be windowed", func() {
+			for i := 0; i < 2*sampleWindow; i++ {
+				c.Sync(ids[:1], p.query)
+			}
+			So(len(c.samples["node1"]), ShouldEqual, sampleWindow)
+		})
+		Convey("The expired samples should be dropped", func() {
+			c.Sync(ids[:4], p.query)
+			p.local = p.local.Add(2 * time.Minute)
+			So(c.Update(), ShouldEqual, 0)
+			So(c.samples, ShouldBeEmpty)
+		})
+	})
+}
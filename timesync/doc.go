@@ -0,0 +1,29 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package timesync measures the clock offsets to peers NTP-style and computes a robust offset of
+// the local clock to the coordinated chain time.
+//
+// Each round trip to a peer yields a sample with timestamps t0 (local transmit), t1 (peer receive),
+// t2 (peer transmit) and t3 (local receive):
+//
+//	offset = ((t1 - t0) + (t2 - t3)) / 2
+//	delay  = (t3 - t0) - (t2 - t1)
+//
+// The sample with the minimum delay among the recent ones of a peer is used as its offset, and the
+// coordinated chain time is taken as the median clock among the local node and its peers, so that
+// less than half of the nodes cannot drag it away with faulty clocks.
+package timesync
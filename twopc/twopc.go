@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 )
 
 // Hook are called during 2PC running
@@ -124,18 +125,7 @@ func (c *Coordinator) commit(ctx context.Context, workers []Worker, wb WriteBatc
 	return nil
 }
 
-// Put initiates a 2PC process to apply given WriteBatch on all workers.
-func (c *Coordinator) Put(workers []Worker, wb WriteBatch) (err error) {
-	// Initiate phase one: ask nodes to prepare for progress
-	ctx, cancel := context.WithTimeout(context.Background(), c.option.timeout)
-	defer cancel()
-
-	if c.option.beforePrepare != nil {
-		if err := c.option.beforePrepare(ctx); err != nil {
-			return err
-		}
-	}
-
+func (c *Coordinator) prepare(ctx context.Context, workers []Worker, wb WriteBatch) (err error) {
 	errs := make([]error, len(workers))
 	wg := sync.WaitGroup{}
 
@@ -149,25 +139,59 @@ func (c *Coordinator) Put(workers []Worker, wb WriteBatch) (err error) {
 
 	wg.Wait()
 
-	// Check prepare results and initiate phase two
-	var returnErr error
 	for index, err := range errs {
 		if err != nil {
-			returnErr = err
 			log.Debugf("prepare failed on %v: err = %v", workers[index], err)
-			goto ROLLBACK
+			return err
 		}
 	}
 
-	if c.option.beforeCommit != nil {
-		if err := c.option.beforeCommit(ctx); err != nil {
-			returnErr = err
-			log.Debugf("before commit failed: err = %v", err)
-			goto ROLLBACK
+	return nil
+}
+
+// Put initiates a 2PC process to apply given WriteBatch on all workers.
+func (c *Coordinator) Put(workers []Worker, wb WriteBatch) (err error) {
+	return c.PutContext(context.Background(), workers, wb)
+}
+
+// PutContext initiates a 2PC process to apply given WriteBatch on all workers, each phase is
+// traced as a child of the span in ctx.
+func (c *Coordinator) PutContext(ctx context.Context, workers []Worker, wb WriteBatch) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.option.timeout)
+	defer cancel()
+
+	if c.option.beforePrepare != nil {
+		if err := c.option.beforePrepare(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Initiate phase one: ask nodes to prepare for progress
+	returnErr := trace.WithSpan(ctx, "twopc.Prepare", func(ctx context.Context) error {
+		return c.prepare(ctx, workers, wb)
+	})
+
+	// Check prepare results and initiate phase two
+	if returnErr == nil && c.option.beforeCommit != nil {
+		if returnErr = c.option.beforeCommit(ctx); returnErr != nil {
+			log.Debugf("before commit failed: err = %v", returnErr)
 		}
 	}
 
-	err = c.commit(ctx, workers, wb)
+	if returnErr != nil {
+		trace.WithSpan(ctx, "twopc.Rollback", func(ctx context.Context) error {
+			if c.option.beforeRollback != nil {
+				// ignore rollback fail options
+				c.option.beforeRollback(ctx)
+			}
+			return c.rollback(ctx, workers, wb)
+		})
+		return returnErr
+	}
+
+	err = trace.WithSpan(ctx, "twopc.Commit", func(ctx context.Context) error {
+		return c.commit(ctx, workers, wb)
+	})
 
 	if c.option.afterCommit != nil {
 		if err = c.option.afterCommit(ctx); err != nil {
@@ -176,14 +200,4 @@ func (c *Coordinator) Put(workers []Worker, wb WriteBatch) (err error) {
 	}
 
 	return
-
-ROLLBACK:
-	if c.option.beforeRollback != nil {
-		// ignore rollback fail options
-		c.option.beforeRollback(ctx)
-	}
-
-	c.rollback(ctx, workers, wb)
-
-	return returnErr
 }
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package trace
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Exporter exports finished spans of the node described by resource.
+type Exporter interface {
+	ExportSpans(resource Attributes, spans []*SpanData) error
+	Shutdown() error
+}
+
+// Config defines the tracing config of a node.
+type Config struct {
+	// Exporter is "file" or "otlp", tracing is disabled if empty
+	Exporter string `yaml:"Exporter"`
+	// Path is the file to append OTLP/JSON lines by the file exporter
+	Path string `yaml:"Path,omitempty"`
+	// Endpoint is the OTLP/HTTP collector address like http://127.0.0.1:4318
+	Endpoint string `yaml:"Endpoint,omitempty"`
+	// SampleRate is the ratio of traces started by this node to be recorded, 0 means all
+	SampleRate float64 `yaml:"SampleRate,omitempty"`
+	// ServiceName overrides the service name of spans reported by this node
+	ServiceName string `yaml:"ServiceName,omitempty"`
+}
+
+type tracer struct {
+	sync.RWMutex
+	exporter   Exporter
+	sampleRate float64
+	queue      chan *SpanData
+	flushCh    chan chan struct{}
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+	dropped    uint64
+}
+
+var (
+	// ExportBatchSize is the max count of spans exported at once.
+	ExportBatchSize = 512
+	// ExportInterval is the max duration finished spans are buffered before exported.
+	ExportInterval = 5 * time.Second
+	// ExportQueueSize is the count of finished spans buffered, spans are dropped if the queue is full.
+	ExportQueueSize = 4096
+
+	// ErrUnknownExporter defines failure on starting tracing with an unknown exporter.
+	ErrUnknownExporter = errors.New("unknown trace exporter")
+
+	defaultTracer = &tracer{}
+)
+
+// Start starts tracing of the node by the config, it does nothing if cfg is nil or has no exporter.
+func Start(serviceName string, nodeID string, cfg *Config) (err error) {
+	if cfg == nil || cfg.Exporter == "" {
+		return
+	}
+	var exporter Exporter
+	switch strings.ToLower(cfg.Exporter) {
+	case "file":
+		exporter, err = NewFileExporter(cfg.Path)
+	case "otlp":
+		exporter = NewOTLPExporter(cfg.Endpoint)
+	default:
+		err = ErrUnknownExporter
+	}
+	if err != nil {
+		return
+	}
+
+	resource := Attributes{"service.name": serviceName}
+	if cfg.ServiceName != "" {
+		resource["service.name"] = cfg.ServiceName
+	}
+	if nodeID != "" {
+		resource["service.instance.id"] = nodeID
+	}
+	SetExporter(exporter, resource, cfg.SampleRate)
+	log.WithFields(log.Fields{
+		"exporter":    cfg.Exporter,
+		"sample_rate": cfg.SampleRate,
+	}).Info("tracing started")
+	return
+}
+
+// SetExporter replaces the exporter of finished spans, the previous one is flushed and shut down.
+// sampleRate is the ratio of traces started by this node to be recorded, 0 means all.
+func SetExporter(exporter Exporter, resource Attributes, sampleRate float64) {
+	Stop()
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+
+	t := defaultTracer
+	t.Lock()
+	defer t.Unlock()
+	t.exporter = exporter
+	t.sampleRate = sampleRate
+	t.queue = make(chan *SpanData, ExportQueueSize)
+	t.flushCh = make(chan chan struct{})
+	t.stopCh = make(chan struct{})
+	t.doneCh = make(chan struct{})
+	go t.run(exporter, resource, t.queue, t.flushCh, t.stopCh, t.doneCh)
+}
+
+// Enabled returns if the node has an exporter.
+func Enabled() bool {
+	defaultTracer.RLock()
+	defer defaultTracer.RUnlock()
+	return defaultTracer.exporter != nil
+}
+
+// Flush exports all finished spans synchronously.
+func Flush() {
+	t := defaultTracer
+	t.RLock()
+	flushCh, doneCh := t.flushCh, t.doneCh
+	t.RUnlock()
+	if flushCh == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case flushCh <- done:
+		<-done
+	case <-doneCh:
+	}
+}
+
+// Stop exports all finished spans and shuts down the exporter.
+func Stop() {
+	t := defaultTracer
+	t.Lock()
+	defer t.Unlock()
+	if t.exporter == nil {
+		return
+	}
+	close(t.stopCh)
+	<-t.doneCh
+	if dropped := atomic.SwapUint64(&t.dropped, 0); dropped > 0 {
+		log.WithField("dropped", dropped).Warning("spans dropped by full export queue")
+	}
+	t.exporter = nil
+	t.queue = nil
+	t.flushCh = nil
+	t.stopCh = nil
+	t.doneCh = nil
+}
+
+func (t *tracer) sample(id TraceID) bool {
+	t.RLock()
+	defer t.RUnlock()
+	return t.exporter != nil && traceIDRatio(id) < t.sampleRate
+}
+
+func (t *tracer) enqueue(data *SpanData) {
+	t.RLock()
+	queue := t.queue
+	t.RUnlock()
+	if queue == nil {
+		// sampled by remote caller but not exported by this node
+		return
+	}
+	select {
+	case queue <- data:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+func (t *tracer) run(exporter Exporter, resource Attributes,
+	queue chan *SpanData, flushCh chan chan struct{}, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(ExportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, ExportBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := exporter.ExportSpans(resource, batch); err != nil {
+			log.WithField("spans", len(batch)).WithError(err).Warning("export spans failed")
+		}
+		batch = make([]*SpanData, 0, ExportBatchSize)
+	}
+	add := func(data *SpanData) {
+		if batch = append(batch, data); len(batch) >= ExportBatchSize {
+			export()
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-queue:
+				add(data)
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-queue:
+			add(data)
+		case <-ticker.C:
+			export()
+		case done := <-flushCh:
+			drain()
+			close(done)
+		case <-stopCh:
+			drain()
+			if err := exporter.Shutdown(); err != nil {
+				log.WithError(err).Warning("shutdown trace exporter failed")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scopeName = "github.com/CovenantSQL/CovenantSQL"
+	// otlpTracesPath is the path of OTLP/HTTP traces service.
+	otlpTracesPath = "/v1/traces"
+)
+
+// The following types are the OTLP/JSON encoding of ExportTraceServiceRequest, ids are hex
+// encoded and 64-bit integers are decimal strings as required by OTLP/JSON.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Message string     `json:"message,omitempty"`
+	Code    StatusCode `json:"code,omitempty"`
+}
+
+// FileExporter appends spans to a local file, one OTLP/JSON ExportTraceServiceRequest per line,
+// which is the same format as the file exporter of OpenTelemetry collector.
+type FileExporter struct {
+	sync.Mutex
+	f *os.File
+}
+
+// OTLPExporter posts spans in OTLP/JSON to the traces service of an OTLP/HTTP collector.
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewFileExporter returns a new file exporter appending to path.
+func NewFileExporter(path string) (e *FileExporter, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	return &FileExporter{f: f}, nil
+}
+
+// ExportSpans implements Exporter.ExportSpans.
+func (e *FileExporter) ExportSpans(resource Attributes, spans []*SpanData) (err error) {
+	buf, err := encodeOTLP(resource, spans)
+	if err != nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	_, err = e.f.Write(append(buf, '\n'))
+	return
+}
+
+// Shutdown implements Exporter.Shutdown.
+func (e *FileExporter) Shutdown() error {
+	e.Lock()
+	defer e.Unlock()
+	return e.f.Close()
+}
+
+// NewOTLPExporter returns a new OTLP/HTTP exporter to the collector at endpoint.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, otlpTracesPath) {
+		url += otlpTracesPath
+	}
+	return &OTLPExporter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ExportSpans implements Exporter.ExportSpans.
+func (e *OTLPExporter) ExportSpans(resource Attributes, spans []*SpanData) (err error) {
+	buf, err := encodeOTLP(resource, spans)
+	if err != nil {
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("otlp collector responded %s", resp.Status)
+	}
+	return
+}
+
+// Shutdown implements Exporter.Shutdown.
+func (e *OTLPExporter) Shutdown() error {
+	return nil
+}
+
+func encodeOTLP(resource Attributes, spans []*SpanData) ([]byte, error) {
+	scope := otlpScopeSpans{
+		Scope: otlpScope{Name: scopeName},
+		Spans: make([]otlpSpan, 0, len(spans)),
+	}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+			Status: otlpStatus{
+				Message: s.Message,
+				Code:    s.Status,
+			},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return json.Marshal(&otlpTraces{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource:   otlpResource{Attributes: encodeAttributes(resource)},
+				ScopeSpans: []otlpScopeSpans{scope},
+			},
+		},
+	})
+}
+
+func encodeAttributes(attrs Attributes) (kvs []otlpKeyValue) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: encodeValue(attrs[k])})
+	}
+	return
+}
+
+func encodeValue(v interface{}) (av otlpAnyValue) {
+	var i int64
+	switch x := v.(type) {
+	case bool:
+		av.BoolValue = &x
+		return
+	case float32:
+		f := float64(x)
+		av.DoubleValue = &f
+		return
+	case float64:
+		av.DoubleValue = &x
+		return
+	case int:
+		i = int64(x)
+	case int32:
+		i = int64(x)
+	case int64:
+		i = x
+	case uint32:
+		i = int64(x)
+	case uint64:
+		i = int64(x)
+	case string:
+		av.StringValue = &x
+		return
+	default:
+		s := fmt.Sprint(v)
+		av.StringValue = &s
+		return
+	}
+	s := strconv.FormatInt(i, 10)
+	av.IntValue = &s
+	return
+}
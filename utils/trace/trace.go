@@ -0,0 +1,320 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package trace provides spans and trace context propagation compatible with OpenTelemetry.
+//
+// The trace context is carried across nodes in the W3C traceparent format by proto.Envelope,
+// and finished spans are exported in the OTLP/JSON format to a local file or an OTLP collector.
+// Spans are only recorded if the node has an exporter or the remote caller sampled the trace,
+// otherwise StartSpan returns a nil span whose methods do nothing.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SpanKind defines the role of a span, values are the same as OTLP.
+type SpanKind int
+
+const (
+	// SpanKindInternal is an internal operation of a node.
+	SpanKindInternal SpanKind = iota + 1
+	// SpanKindServer is the handling of a remote call.
+	SpanKindServer
+	// SpanKindClient is a remote call.
+	SpanKindClient
+)
+
+// StatusCode defines the status of a span, values are the same as OTLP.
+type StatusCode int
+
+const (
+	// StatusUnset is the default status.
+	StatusUnset StatusCode = iota
+	// StatusOK is the status of a successful operation.
+	StatusOK
+	// StatusError is the status of a failed operation.
+	StatusError
+)
+
+// TraceID is the identifier of a trace.
+type TraceID [16]byte
+
+// SpanID is the identifier of a span.
+type SpanID [8]byte
+
+// SpanContext is the part of a span propagated to children and remote nodes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Attributes defines attributes of spans and resources.
+type Attributes map[string]interface{}
+
+// SpanData is the snapshot of a finished span to be exported.
+type SpanData struct {
+	SpanContext
+	Parent     SpanID
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes Attributes
+	Status     StatusCode
+	Message    string
+}
+
+// Span records an operation of a trace, all methods are safe to be called on nil span.
+type Span struct {
+	sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+var (
+	// ErrInvalidTraceParent defines failure on parsing an invalid W3C traceparent.
+	ErrInvalidTraceParent = errors.New("invalid traceparent")
+)
+
+// String returns the hex encoding of the trace id.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid returns if the trace id is not all zero.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// String returns the hex encoding of the span id.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid returns if the span id is not all zero.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// IsValid returns if the span context has valid trace id and span id.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// TraceParent returns the W3C traceparent of the span context, or empty string if it is invalid.
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	var flags byte
+	if sc.Sampled {
+		flags = 0x01
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceParent parses the span context from the W3C traceparent of version 00.
+func ParseTraceParent(traceParent string) (sc SpanContext, err error) {
+	// 00-<32 hex trace id>-<16 hex span id>-<2 hex flags>
+	if len(traceParent) != 55 || traceParent[:3] != "00-" ||
+		traceParent[35] != '-' || traceParent[52] != '-' {
+		err = ErrInvalidTraceParent
+		return
+	}
+	var flags [1]byte
+	if _, err = hex.Decode(sc.TraceID[:], []byte(traceParent[3:35])); err != nil {
+		err = ErrInvalidTraceParent
+		return
+	}
+	if _, err = hex.Decode(sc.SpanID[:], []byte(traceParent[36:52])); err != nil {
+		err = ErrInvalidTraceParent
+		return
+	}
+	if _, err = hex.Decode(flags[:], []byte(traceParent[53:])); err != nil {
+		err = ErrInvalidTraceParent
+		return
+	}
+	if !sc.IsValid() {
+		err = ErrInvalidTraceParent
+		return
+	}
+	sc.Sampled = flags[0]&0x01 != 0
+	return
+}
+
+// ContextWithSpan returns a copy of ctx carrying the span as parent of new spans.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithTraceParent returns a copy of ctx carrying the remote span context parsed from the
+// W3C traceparent as parent of new spans, ctx is returned as is if the traceparent is invalid.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	sc, err := ParseTraceParent(traceParent)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the parent of new spans in ctx.
+func SpanContextFromContext(ctx context.Context) (sc SpanContext) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	sc, _ = ctx.Value(remoteKey{}).(SpanContext)
+	return
+}
+
+// TraceParent returns the W3C traceparent to be propagated to remote calls made with ctx.
+func TraceParent(ctx context.Context) string {
+	return SpanContextFromContext(ctx).TraceParent()
+}
+
+// StartSpan starts a span as child of the span in ctx, or a new trace if ctx carries no span and
+// the trace is sampled by the exporter setting. The returned context carries the new span.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		if !parent.Sampled {
+			return ctx, nil
+		}
+		sc.TraceID = parent.TraceID
+		sc.Sampled = true
+	} else {
+		sc.TraceID = newTraceID()
+		if !defaultTracer.sample(sc.TraceID) {
+			return ctx, nil
+		}
+		sc.Sampled = true
+	}
+	span := &Span{
+		data: SpanData{
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Name:        name,
+			Kind:        kind,
+			Start:       time.Now(),
+		},
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// WithSpan runs fn with ctx carrying a new internal span named name, the span is ended after fn
+// returns and marked failed by the error of fn.
+func WithSpan(ctx context.Context, name string, fn func(ctx context.Context) error) (err error) {
+	ctx, span := StartSpan(ctx, name, SpanKindInternal)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	return fn(ctx)
+}
+
+// SpanContext returns the span context of the span.
+func (s *Span) SpanContext() (sc SpanContext) {
+	if s == nil {
+		return
+	}
+	return s.data.SpanContext
+}
+
+// TraceParent returns the W3C traceparent of the span.
+func (s *Span) TraceParent() string {
+	return s.SpanContext().TraceParent()
+}
+
+// SetAttribute sets an attribute of the span, value should be string, bool, integer or float.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(Attributes)
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed by err, nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data.Status = StatusError
+	s.data.Message = err.Error()
+}
+
+// End finishes the span and queues it to the exporter, it does nothing if called more than once.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.Unlock()
+	defaultTracer.enqueue(&data)
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return
+}
+
+// traceIDRatio returns the position of the trace id in [0, 1) used by ratio based sampling.
+func traceIDRatio(id TraceID) float64 {
+	return float64(binary.BigEndian.Uint64(id[8:])>>11) / (1 << 53)
+}
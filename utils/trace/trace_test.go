@@ -0,0 +1,249 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type memExporter struct {
+	sync.Mutex
+	resource Attributes
+	spans    []*SpanData
+}
+
+func (e *memExporter) ExportSpans(resource Attributes, spans []*SpanData) error {
+	e.Lock()
+	defer e.Unlock()
+	e.resource = resource
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memExporter) Shutdown() error {
+	return nil
+}
+
+func (e *memExporter) find(name string) *SpanData {
+	e.Lock()
+	defer e.Unlock()
+	for _, s := range e.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTraceParent(t *testing.T) {
+	Convey("traceparent should be formatted and parsed", t, func() {
+		sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+		tp := sc.TraceParent()
+		So(tp, ShouldHaveLength, 55)
+		parsed, err := ParseTraceParent(tp)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, sc)
+
+		sc.Sampled = false
+		parsed, err = ParseTraceParent(sc.TraceParent())
+		So(err, ShouldBeNil)
+		So(parsed.Sampled, ShouldBeFalse)
+
+		So(SpanContext{}.TraceParent(), ShouldBeEmpty)
+	})
+	Convey("invalid traceparent should be rejected", t, func() {
+		for _, tp := range []string{
+			"",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+			"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			"00-0af7651916cd43dd8448eb211c80319x-b7ad6b7169203331-01",
+			"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+			"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		} {
+			_, err := ParseTraceParent(tp)
+			So(err, ShouldEqual, ErrInvalidTraceParent)
+			So(SpanContextFromContext(ContextWithTraceParent(context.Background(), tp)).IsValid(),
+				ShouldBeFalse)
+		}
+	})
+}
+
+func TestSpan(t *testing.T) {
+	Convey("spans should not be recorded without exporter", t, func() {
+		Stop()
+		ctx, span := StartSpan(context.Background(), "root", SpanKindInternal)
+		So(span, ShouldBeNil)
+		So(TraceParent(ctx), ShouldBeEmpty)
+		// nil span is safe to use
+		span.SetAttribute("k", "v")
+		span.SetError(errors.New("error"))
+		span.End()
+	})
+	Convey("spans sampled by remote caller should be continued", t, func() {
+		Stop()
+		remote := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+		ctx := ContextWithTraceParent(context.Background(), remote.TraceParent())
+		ctx, span := StartSpan(ctx, "server", SpanKindServer)
+		So(span, ShouldNotBeNil)
+		So(span.SpanContext().TraceID, ShouldEqual, remote.TraceID)
+		So(span.data.Parent, ShouldEqual, remote.SpanID)
+		So(TraceParent(ctx), ShouldEqual, span.TraceParent())
+		span.End()
+
+		remote.Sampled = false
+		ctx = ContextWithTraceParent(context.Background(), remote.TraceParent())
+		_, span = StartSpan(ctx, "server", SpanKindServer)
+		So(span, ShouldBeNil)
+	})
+	Convey("finished spans should be exported to exporter", t, func() {
+		exporter := &memExporter{}
+		SetExporter(exporter, Attributes{"service.name": "test"}, 0)
+		defer Stop()
+		So(Enabled(), ShouldBeTrue)
+
+		ctx, root := StartSpan(context.Background(), "root", SpanKindClient)
+		So(root, ShouldNotBeNil)
+		err := WithSpan(ctx, "child", func(ctx context.Context) error {
+			SpanFromContext(ctx).SetAttribute("count", 1)
+			return errors.New("child failed")
+		})
+		So(err, ShouldNotBeNil)
+		root.End()
+		root.End()
+		Flush()
+
+		So(exporter.resource["service.name"], ShouldEqual, "test")
+		So(exporter.spans, ShouldHaveLength, 2)
+		child := exporter.find("child")
+		So(child, ShouldNotBeNil)
+		So(child.TraceID, ShouldEqual, root.SpanContext().TraceID)
+		So(child.Parent, ShouldEqual, root.SpanContext().SpanID)
+		So(child.Status, ShouldEqual, StatusError)
+		So(child.Message, ShouldEqual, "child failed")
+		So(child.Attributes["count"], ShouldEqual, 1)
+		So(child.End, ShouldHappenOnOrAfter, child.Start)
+	})
+	Convey("traces started by node should be sampled by ratio", t, func() {
+		SetExporter(&memExporter{}, nil, 0.25)
+		defer Stop()
+		sampled := 0
+		for i := 0; i < 4000; i++ {
+			if _, span := StartSpan(context.Background(), "root", SpanKindInternal); span != nil {
+				sampled++
+			}
+		}
+		So(sampled, ShouldBeBetween, 800, 1200)
+	})
+}
+
+func TestExporter(t *testing.T) {
+	Convey("file exporter should append OTLP/JSON lines", t, func() {
+		dir, err := ioutil.TempDir("", "trace")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "spans.json")
+
+		So(Start("test", "node", &Config{Exporter: "unknown"}), ShouldEqual, ErrUnknownExporter)
+		So(Start("test", "node", nil), ShouldBeNil)
+		So(Enabled(), ShouldBeFalse)
+		So(Start("test", "node", &Config{Exporter: "file", Path: path}), ShouldBeNil)
+		ctx, root := StartSpan(context.Background(), "root", SpanKindServer)
+		_, child := StartSpan(ctx, "child", SpanKindInternal)
+		child.SetAttribute("ok", true)
+		child.End()
+		root.End()
+		Stop()
+
+		f, err := os.Open(path)
+		So(err, ShouldBeNil)
+		defer f.Close()
+		var traces otlpTraces
+		scanner := bufio.NewScanner(f)
+		So(scanner.Scan(), ShouldBeTrue)
+		So(json.Unmarshal(scanner.Bytes(), &traces), ShouldBeNil)
+		So(scanner.Scan(), ShouldBeFalse)
+		So(traces.ResourceSpans, ShouldHaveLength, 1)
+		rs := traces.ResourceSpans[0]
+		So(rs.Resource.Attributes, ShouldHaveLength, 2)
+		// attributes are sorted by key
+		So(rs.Resource.Attributes[0].Key, ShouldEqual, "service.instance.id")
+		So(*rs.Resource.Attributes[0].Value.StringValue, ShouldEqual, "node")
+		So(*rs.Resource.Attributes[1].Value.StringValue, ShouldEqual, "test")
+		spans := rs.ScopeSpans[0].Spans
+		So(spans, ShouldHaveLength, 2)
+		So(spans[0].Name, ShouldEqual, "child")
+		So(spans[0].ParentSpanID, ShouldEqual, spans[1].SpanID)
+		So(spans[0].TraceID, ShouldEqual, spans[1].TraceID)
+		So(*spans[0].Attributes[0].Value.BoolValue, ShouldBeTrue)
+		So(spans[1].ParentSpanID, ShouldBeEmpty)
+		So(spans[1].Kind, ShouldEqual, SpanKindServer)
+	})
+	Convey("otlp exporter should post spans to collector", t, func() {
+		var (
+			lock   sync.Mutex
+			paths  []string
+			traces []otlpTraces
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			var req otlpTraces
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			paths = append(paths, r.URL.Path)
+			traces = append(traces, req)
+		}))
+		defer srv.Close()
+
+		So(Start("test", "", &Config{Exporter: "otlp", Endpoint: srv.URL}), ShouldBeNil)
+		_, span := StartSpan(context.Background(), "call", SpanKindClient)
+		span.SetAttribute("rpc.method", "DHT.Ping")
+		span.SetAttribute("size", uint64(42))
+		span.End()
+		Flush()
+		Stop()
+
+		lock.Lock()
+		defer lock.Unlock()
+		So(paths, ShouldResemble, []string{otlpTracesPath})
+		spans := traces[0].ResourceSpans[0].ScopeSpans[0].Spans
+		So(spans, ShouldHaveLength, 1)
+		So(spans[0].Name, ShouldEqual, "call")
+		So(*spans[0].Attributes[0].Value.StringValue, ShouldEqual, "DHT.Ping")
+		So(*spans[0].Attributes[1].Value.IntValue, ShouldEqual, "42")
+
+		failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer failed.Close()
+		So(NewOTLPExporter(failed.URL).ExportSpans(nil, nil), ShouldNotBeNil)
+	})
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
@@ -33,6 +34,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/sqlchain/storage"
 	ct "github.com/CovenantSQL/CovenantSQL/sqlchain/types"
 	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/trace"
 	wt "github.com/CovenantSQL/CovenantSQL/worker/types"
 )
 
@@ -57,6 +59,7 @@ type Database struct {
 	connSeqs       sync.Map
 	connSeqEvictCh chan uint64
 	chain          *sqlchain.Chain
+	state          int32
 }
 
 // NewDatabase create a single database instance using config.
@@ -174,16 +177,34 @@ func (db *Database) UpdatePeers(peers *kayak.Peers) (err error) {
 	return db.chain.UpdatePeers(peers)
 }
 
+// SetState updates the service state of database.
+func (db *Database) SetState(state wt.DatabaseState) {
+	atomic.StoreInt32(&db.state, int32(state))
+}
+
+// State returns the service state of database.
+func (db *Database) State() wt.DatabaseState {
+	return wt.DatabaseState(atomic.LoadInt32(&db.state))
+}
+
 // Query defines database query interface.
 func (db *Database) Query(request *wt.Request) (response *wt.Response, err error) {
 	if err = request.Verify(); err != nil {
 		return
 	}
 
+	state := db.State()
+	if state == wt.DatabaseSuspended {
+		return nil, ErrDatabaseSuspended
+	}
+
 	switch request.Header.QueryType {
 	case wt.ReadQuery:
 		return db.readQuery(request)
 	case wt.WriteQuery:
+		if state == wt.DatabaseReadOnly {
+			return nil, ErrDatabaseReadOnly
+		}
 		return db.writeQuery(request)
 	default:
 		// TODO(xq262144): verbose errors with custom error structure
@@ -274,14 +295,30 @@ func (db *Database) writeQuery(request *wt.Request) (response *wt.Response, err
 		return
 	}
 
+	// keep exec results of the request on local commit
+	txID := storage.TxID{
+		ConnectionID: request.Header.ConnectionID,
+		SeqNo:        request.Header.SeqNo,
+		Timestamp:    request.Header.Timestamp.UnixNano(),
+	}
+	db.storage.WatchResults(txID)
+	defer db.storage.TakeResults(txID)
+
 	var logOffset uint64
-	logOffset, err = db.kayakRuntime.Apply(buf.Bytes())
+	logOffset, err = db.kayakRuntime.ApplyWithContext(requestContext(request), buf.Bytes())
 
 	if err != nil {
 		return
 	}
 
-	return db.buildQueryResponse(request, logOffset, []string{}, []string{}, [][]interface{}{})
+	execResults := db.storage.TakeResults(txID)
+	results := make([]wt.ResponseExecResult, len(execResults))
+	for i, r := range execResults {
+		results[i].AffectedRows = r.RowsAffected
+		results[i].LastInsertID = r.LastInsertID
+	}
+
+	return db.buildQueryResponse(request, logOffset, results, []string{}, []string{}, [][]interface{}{})
 }
 
 func (db *Database) readQuery(request *wt.Request) (response *wt.Response, err error) {
@@ -290,15 +327,20 @@ func (db *Database) readQuery(request *wt.Request) (response *wt.Response, err e
 	var columns, types []string
 	var data [][]interface{}
 
-	columns, types, data, err = db.storage.Query(context.Background(), convertQuery(request.Payload.Queries))
+	columns, types, data, err = db.storage.Query(requestContext(request), convertQuery(request.Payload.Queries))
 	if err != nil {
 		return
 	}
 
-	return db.buildQueryResponse(request, 0, columns, types, data)
+	return db.buildQueryResponse(request, 0, nil, columns, types, data)
+}
+
+// requestContext returns the context carrying the trace of the rpc call of the request.
+func requestContext(request *wt.Request) context.Context {
+	return trace.ContextWithTraceParent(context.Background(), request.GetTraceParent())
 }
 
-func (db *Database) buildQueryResponse(request *wt.Request, offset uint64,
+func (db *Database) buildQueryResponse(request *wt.Request, offset uint64, results []wt.ResponseExecResult,
 	columns []string, types []string, data [][]interface{}) (response *wt.Response, err error) {
 	// build response
 	response = new(wt.Response)
@@ -309,6 +351,7 @@ func (db *Database) buildQueryResponse(request *wt.Request, offset uint64,
 	response.Header.LogOffset = offset
 	response.Header.Timestamp = getLocalTime()
 	response.Header.RowCount = uint64(len(data))
+	response.Header.Results = results
 	if response.Header.Signee, err = getLocalPubKey(); err != nil {
 		return
 	}
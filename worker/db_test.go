@@ -66,7 +66,7 @@ func TestSingleDatabase(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		// create mux service
-		service := ka.NewMuxService("DBKayak", server)
+		service := ka.NewMuxService(DBKayakRPCName, server)
 
 		// create peers
 		var peers *kayak.Peers
@@ -78,7 +78,7 @@ func TestSingleDatabase(t *testing.T) {
 			DatabaseID:      "TEST",
 			DataDir:         rootDir,
 			KayakMux:        service,
-			ChainMux:        sqlchain.NewMuxService("sqlchain", server),
+			ChainMux:        sqlchain.NewMuxService(SQLChainRPCName, server),
 			MaxWriteTimeGap: time.Second * 5,
 		}
 
@@ -131,6 +131,39 @@ func TestSingleDatabase(t *testing.T) {
 			So(err, ShouldBeNil)
 		})
 
+		Convey("test database state", func() {
+			var writeQuery, readQuery *wt.Request
+			writeQuery, err = buildQuery(wt.WriteQuery, 1, 1, []string{
+				"create table test (test int)",
+			})
+			So(err, ShouldBeNil)
+			readQuery, err = buildQuery(wt.ReadQuery, 1, 2, []string{
+				"select 1",
+			})
+			So(err, ShouldBeNil)
+
+			// read-only database rejects writes only
+			db.SetState(wt.DatabaseReadOnly)
+			So(db.State(), ShouldEqual, wt.DatabaseReadOnly)
+			_, err = db.Query(writeQuery)
+			So(err, ShouldEqual, ErrDatabaseReadOnly)
+			_, err = db.Query(readQuery)
+			So(err, ShouldBeNil)
+
+			// suspended database rejects all queries
+			db.SetState(wt.DatabaseSuspended)
+			_, err = db.Query(readQuery)
+			So(err, ShouldEqual, ErrDatabaseSuspended)
+
+			// service restored
+			db.SetState(wt.DatabaseActive)
+			_, err = db.Query(writeQuery)
+			So(err, ShouldBeNil)
+
+			err = db.Shutdown()
+			So(err, ShouldBeNil)
+		})
+
 		Convey("test invalid request", func() {
 			var writeQuery *wt.Request
 			var res *wt.Response
@@ -304,7 +337,7 @@ func TestInitFailed(t *testing.T) {
 		defer os.RemoveAll(rootDir)
 
 		// create mux service
-		service := ka.NewMuxService("DBKayak", server)
+		service := ka.NewMuxService(DBKayakRPCName, server)
 
 		// create peers
 		var peers *kayak.Peers
@@ -316,7 +349,7 @@ func TestInitFailed(t *testing.T) {
 			DatabaseID:      "TEST",
 			DataDir:         rootDir,
 			KayakMux:        service,
-			ChainMux:        sqlchain.NewMuxService("sqlchain", server),
+			ChainMux:        sqlchain.NewMuxService(SQLChainRPCName, server),
 			MaxWriteTimeGap: time.Duration(5 * time.Second),
 		}
 
@@ -353,7 +386,7 @@ func TestDatabaseRecycle(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		// create mux service
-		service := ka.NewMuxService("DBKayak", server)
+		service := ka.NewMuxService(DBKayakRPCName, server)
 
 		// create peers
 		var peers *kayak.Peers
@@ -365,7 +398,7 @@ func TestDatabaseRecycle(t *testing.T) {
 			DatabaseID:      "TEST",
 			DataDir:         rootDir,
 			KayakMux:        service,
-			ChainMux:        sqlchain.NewMuxService("sqlchain", server),
+			ChainMux:        sqlchain.NewMuxService(SQLChainRPCName, server),
 			MaxWriteTimeGap: time.Duration(5 * time.Second),
 		}
 
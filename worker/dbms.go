@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/CovenantSQL/CovenantSQL/kayak"
 	ka "github.com/CovenantSQL/CovenantSQL/kayak/api"
 	kt "github.com/CovenantSQL/CovenantSQL/kayak/transport"
 	"github.com/CovenantSQL/CovenantSQL/proto"
@@ -216,6 +217,8 @@ func (dbms *DBMS) Create(instance *wt.ServiceInstance, cleanup bool) (err error)
 	if db, err = NewDatabase(dbCfg, instance.Peers, instance.GenesisBlock); err != nil {
 		return
 	}
+	setPeersRole(instance.Peers)
+	db.SetState(instance.State)
 
 	// add to meta
 	err = dbms.addMeta(instance.DatabaseID, db)
@@ -251,7 +254,40 @@ func (dbms *DBMS) Update(instance *wt.ServiceInstance) (err error) {
 	}
 
 	// update peers
-	return db.UpdatePeers(instance.Peers)
+	if err = db.UpdatePeers(instance.Peers); err != nil {
+		return
+	}
+	setPeersRole(instance.Peers)
+	return
+}
+
+// setPeersRole trusts the servers of the peers signed by a block producer as miners, so that they
+// are permitted to call the miner rpc methods.
+func setPeersRole(peers *kayak.Peers) {
+	if peers == nil || peers.Signature == nil || !route.IsBPPublicKey(peers.PubKey) || !peers.Verify() {
+		return
+	}
+	for _, s := range peers.Servers {
+		route.SetNodeRole(s.ID, proto.Miner)
+	}
+}
+
+// UpdateState apply the new service state to database.
+func (dbms *DBMS) UpdateState(instance *wt.ServiceInstance) (err error) {
+	var db *Database
+	var exists bool
+
+	if db, exists = dbms.getMeta(instance.DatabaseID); !exists {
+		return ErrNotExists
+	}
+
+	log.WithFields(log.Fields{
+		"db":    instance.DatabaseID,
+		"state": instance.State.String(),
+	}).Info("update database state")
+
+	db.SetState(instance.State)
+	return
 }
 
 // Query handles query request in dbms.
@@ -80,7 +80,7 @@ func (rpc *DBMSRPCService) Ack(ack *wt.Ack, _ *wt.AckResponse) (err error) {
 	return
 }
 
-// Deploy rpc, called by BP to create/drop database and update peers.
+// Deploy rpc, called by BP to create/drop database and update peers/state.
 func (rpc *DBMSRPCService) Deploy(req *wt.UpdateService, _ *wt.UpdateServiceResponse) (err error) {
 	// verify request node is block producer
 	if !route.IsPermitted(&req.Envelope, route.DBSDeploy) {
@@ -101,6 +101,8 @@ func (rpc *DBMSRPCService) Deploy(req *wt.UpdateService, _ *wt.UpdateServiceResp
 		err = rpc.dbms.Update(&req.Header.Instance)
 	case wt.DropDB:
 		err = rpc.dbms.Drop(req.Header.Instance.DatabaseID)
+	case wt.UpdateState:
+		err = rpc.dbms.UpdateState(&req.Header.Instance)
 	}
 
 	return
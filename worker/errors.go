@@ -39,4 +39,10 @@ var (
 
 	// ErrSpaceLimitExceeded defines errors on disk space exceeding limit.
 	ErrSpaceLimitExceeded = errors.New("space limit exceeded")
+
+	// ErrDatabaseReadOnly defines errors on writing a database which is read-only for arrears.
+	ErrDatabaseReadOnly = errors.New("database is read-only for arrears")
+
+	// ErrDatabaseSuspended defines errors on querying a database which is suspended for arrears.
+	ErrDatabaseSuspended = errors.New("database is suspended for arrears")
 )
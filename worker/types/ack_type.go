@@ -109,7 +109,7 @@ func (sh *SignedAckHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedAckHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedAckHeader) Sign(signer asymmetric.Signer) (err error) {
 	// check original header signature
 	if err = sh.Response.Verify(); err != nil {
 		return
@@ -139,7 +139,7 @@ func (a *Ack) Verify() error {
 }
 
 // Sign the request.
-func (a *Ack) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (a *Ack) Sign(signer asymmetric.Signer) (err error) {
 	// sign
 	return a.Header.Sign(signer)
 }
@@ -44,12 +44,44 @@ type ResourceMeta struct {
 	EncryptionKey string `hspack:"-"` // encryption key for database instance
 }
 
+// DatabaseState defines the service state of a database instance according to its billing.
+type DatabaseState int32
+
+const (
+	// DatabaseActive indicates the database is in service normally.
+	DatabaseActive DatabaseState = iota
+	// DatabaseGrace indicates the database payer is running out of balance, the database is still
+	// in service normally.
+	DatabaseGrace
+	// DatabaseReadOnly indicates the database only accepts read queries for arrears.
+	DatabaseReadOnly
+	// DatabaseSuspended indicates the database rejects all queries for arrears.
+	DatabaseSuspended
+)
+
+// String implements fmt.Stringer.
+func (s DatabaseState) String() string {
+	switch s {
+	case DatabaseActive:
+		return "Active"
+	case DatabaseGrace:
+		return "Grace"
+	case DatabaseReadOnly:
+		return "ReadOnly"
+	case DatabaseSuspended:
+		return "Suspended"
+	default:
+		return "Unknown"
+	}
+}
+
 // ServiceInstance defines single instance to be initialized.
 type ServiceInstance struct {
 	DatabaseID   proto.DatabaseID
 	Peers        *kayak.Peers
 	ResourceMeta ResourceMeta
 	GenesisBlock *ct.Block
+	State        DatabaseState
 }
 
 // InitServiceResponseHeader defines worker service init response header.
@@ -102,6 +134,7 @@ func (i *ServiceInstance) Serialize() []byte {
 	} else {
 		buf.Write([]byte{'\000'})
 	}
+	binary.Write(buf, binary.LittleEndian, int32(i.State))
 
 	return buf.Bytes()
 }
@@ -160,7 +193,7 @@ func (sh *SignedInitServiceResponseHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedInitServiceResponseHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedInitServiceResponseHeader) Sign(signer asymmetric.Signer) (err error) {
 	// build hash
 	buildHash(&sh.InitServiceResponseHeader, &sh.HeaderHash)
 
@@ -185,7 +218,7 @@ func (rs *InitServiceResponse) Verify() error {
 }
 
 // Sign the request.
-func (rs *InitServiceResponse) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (rs *InitServiceResponse) Sign(signer asymmetric.Signer) (err error) {
 	// sign
 	return rs.Header.Sign(signer)
 }
@@ -6,6 +6,20 @@ import (
 	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
+// MarshalHash marshals for hash
+func (z DatabaseState) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	o = hsp.AppendInt32(o, int32(z))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z DatabaseState) Msgsize() (s int) {
+	s = hsp.Int32Size
+	return
+}
+
 // MarshalHash marshals for hash
 func (z *InitService) MarshalHash() (o []byte, err error) {
 	var b []byte
@@ -98,8 +112,8 @@ func (z *ResourceMeta) Msgsize() (s int) {
 func (z *ServiceInstance) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 4
-	o = append(o, 0x84, 0x84)
+	// map header, size 5
+	o = append(o, 0x85, 0x85)
 	if z.GenesisBlock == nil {
 		o = hsp.AppendNil(o)
 	} else {
@@ -109,7 +123,7 @@ func (z *ServiceInstance) MarshalHash() (o []byte, err error) {
 			o = hsp.AppendBytes(o, oTemp)
 		}
 	}
-	o = append(o, 0x84)
+	o = append(o, 0x85)
 	if z.Peers == nil {
 		o = hsp.AppendNil(o)
 	} else {
@@ -119,18 +133,20 @@ func (z *ServiceInstance) MarshalHash() (o []byte, err error) {
 			o = hsp.AppendBytes(o, oTemp)
 		}
 	}
-	o = append(o, 0x84)
+	o = append(o, 0x85)
 	if oTemp, err := z.ResourceMeta.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
-	o = append(o, 0x84)
+	o = append(o, 0x85)
 	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
+	o = append(o, 0x85)
+	o = hsp.AppendInt32(o, int32(z.State))
 	return
 }
 
@@ -148,7 +164,7 @@ func (z *ServiceInstance) Msgsize() (s int) {
 	} else {
 		s += z.Peers.Msgsize()
 	}
-	s += 13 + z.ResourceMeta.Msgsize() + 11 + z.DatabaseID.Msgsize()
+	s += 13 + z.ResourceMeta.Msgsize() + 11 + z.DatabaseID.Msgsize() + 6 + hsp.Int32Size
 	return
 }
 
@@ -164,7 +164,7 @@ func (sh *SignedRequestHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedRequestHeader) Sign(signer asymmetric.Signer) (err error) {
 	// compute hash
 	buildHash(&sh.RequestHeader, &sh.HeaderHash)
 
@@ -203,7 +203,7 @@ func (r *Request) Verify() (err error) {
 }
 
 // Sign the request.
-func (r *Request) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (r *Request) Sign(signer asymmetric.Signer) (err error) {
 	// set query count
 	r.Header.BatchCount = uint64(len(r.Payload.Queries))
 
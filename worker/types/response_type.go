@@ -41,14 +41,21 @@ type ResponsePayload struct {
 	Rows      []ResponseRow
 }
 
+// ResponseExecResult defines the result of single write query.
+type ResponseExecResult struct {
+	AffectedRows int64
+	LastInsertID int64
+}
+
 // ResponseHeader defines a query response header.
 type ResponseHeader struct {
 	Request   SignedRequestHeader
-	NodeID    proto.NodeID // response node id
-	Timestamp time.Time    // time in UTC zone
-	RowCount  uint64       // response row count of payload
-	LogOffset uint64       // request log offset
-	DataHash  hash.Hash    // hash of query response
+	NodeID    proto.NodeID         // response node id
+	Timestamp time.Time            // time in UTC zone
+	RowCount  uint64               // response row count of payload
+	LogOffset uint64               // request log offset
+	DataHash  hash.Hash            // hash of query response
+	Results   []ResponseExecResult // results of write queries, one for each query of request
 }
 
 // SignedResponseHeader defines a signed query response header.
@@ -114,6 +121,11 @@ func (h *ResponseHeader) Serialize() []byte {
 	binary.Write(buf, binary.LittleEndian, h.RowCount)
 	binary.Write(buf, binary.LittleEndian, h.LogOffset)
 	buf.Write(h.DataHash[:])
+	binary.Write(buf, binary.LittleEndian, uint64(len(h.Results)))
+	for _, r := range h.Results {
+		binary.Write(buf, binary.LittleEndian, r.AffectedRows)
+		binary.Write(buf, binary.LittleEndian, r.LastInsertID)
+	}
 
 	return buf.Bytes()
 }
@@ -161,7 +173,7 @@ func (sh *SignedResponseHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedResponseHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedResponseHeader) Sign(signer asymmetric.Signer) (err error) {
 	// make sure original header is signed
 	if err = sh.Request.Verify(); err != nil {
 		return
@@ -201,7 +213,7 @@ func (sh *Response) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *Response) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *Response) Sign(signer asymmetric.Signer) (err error) {
 	// set rows count
 	sh.Header.RowCount = uint64(len(sh.Payload.Rows))
 
@@ -237,6 +237,9 @@ func TestResponse_Sign(t *testing.T) {
 					NodeID:    proto.NodeID("node2"),
 					Timestamp: time.Now().UTC(),
 					RowCount:  uint64(1),
+					Results: []ResponseExecResult{
+						{AffectedRows: 1, LastInsertID: 2},
+					},
 				},
 				Signee: pubKey,
 			},
@@ -355,6 +358,12 @@ func TestResponse_Sign(t *testing.T) {
 				res.Header.Timestamp = res.Header.Timestamp.Add(time.Second)
 				buildHash(&res.Header.ResponseHeader, &res.Header.HeaderHash)
 
+				err = res.Verify()
+				So(err, ShouldNotBeNil)
+			})
+			Convey("exec result change", func() {
+				res.Header.Results[0].AffectedRows = 2
+
 				err = res.Verify()
 				So(err, ShouldNotBeNil)
 			})
@@ -37,6 +37,8 @@ const (
 	UpdateDB
 	// DropDB indicates drop database operation.
 	DropDB
+	// UpdateState indicates database service state update operation.
+	UpdateState
 )
 
 // UpdateServiceHeader defines service update header.
@@ -114,7 +116,7 @@ func (sh *SignedUpdateServiceHeader) Verify() (err error) {
 }
 
 // Sign the request.
-func (sh *SignedUpdateServiceHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (sh *SignedUpdateServiceHeader) Sign(signer asymmetric.Signer) (err error) {
 	// build hash
 	buildHash(&sh.UpdateServiceHeader, &sh.HeaderHash)
 
@@ -139,7 +141,7 @@ func (s *UpdateService) Verify() error {
 }
 
 // Sign the request.
-func (s *UpdateService) Sign(signer *asymmetric.PrivateKey) (err error) {
+func (s *UpdateService) Sign(signer asymmetric.Signer) (err error) {
 	// sign
 	return s.Header.Sign(signer)
 }